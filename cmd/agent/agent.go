@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/copybridge/copybridge-server/pkg/copybridge/client"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxText bounds the text the agent moves between the clipboards.
+const maxText = 16 << 20
+
+// textType is the type of the text the agent pushes.
+const textType = "text/plain; charset=utf-8"
+
+// Reconnection delays of the sync connection.
+const (
+	minRetry = time.Second
+	maxRetry = time.Minute
+)
+
+// syncMessage is a message of the sync protocol. Snapshots and changes
+// carry the clipboard as Id, Version and Event.
+type syncMessage struct {
+	Op        string   `json:"op"`
+	PublicIds []string `json:"public_ids,omitempty"`
+	Event     string   `json:"event,omitempty"`
+	Id        int      `json:"id,omitempty"`
+	Version   int      `json:"version,omitempty"`
+	Message   string   `json:"message,omitempty"`
+}
+
+// localClipboard is the clipboard of the machine the agent runs on.
+type localClipboard interface {
+	Read() (string, error)
+	Write(text string) error
+}
+
+// agent keeps a clipboard slot on the server in sync with the local
+// clipboard. Local changes are pushed when they are seen, and with pull
+// remote changes are applied locally. The last writer wins.
+type agent struct {
+	client   *client.Client
+	local    localClipboard
+	slot     string
+	pull     bool
+	interval time.Duration
+
+	// publicId addresses the slot once it was found, as numeric ids and
+	// aliases may be restricted to owners. id tells its sync messages.
+	publicId string
+	id       int
+
+	mu sync.Mutex
+	// version is the version of the slot the local clipboard holds.
+	version int
+	// last is the hash of the text both clipboards last agreed on, so the
+	// agent does not push text it just pulled, or the other way round.
+	last [sha256.Size]byte
+}
+
+// run syncs the clipboards until ctx is done. The text on the local
+// clipboard when it starts is not pushed, so restarting the agent does not
+// overwrite the slot with a stale clipboard.
+func (a *agent) run(ctx context.Context) error {
+	cb, err := a.client.Get(a.slot)
+	if err != nil {
+		return err
+	}
+	a.publicId, a.id, a.version = cb.PublicId, cb.Id, cb.Version
+	if text, err := a.local.Read(); err == nil {
+		a.last = sha256.Sum256([]byte(text))
+	}
+	log.Printf("syncing clipboard %s (%s) at version %d", cb.PublicId, cb.Name, cb.Version)
+
+	if a.pull {
+		go a.watchRemote(ctx)
+	}
+	a.watchLocal(ctx)
+	return nil
+}
+
+// watchLocal polls the local clipboard and pushes new text to the slot.
+func (a *agent) watchLocal(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		text, err := a.local.Read()
+		if err != nil {
+			// Reading an empty clipboard fails with some commands, which
+			// would be logged every interval.
+			if err.Error() != lastErr {
+				log.Printf("error reading local clipboard: %v", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if err := a.push(text); err != nil {
+			log.Printf("error pushing clipboard: %v", err)
+		}
+	}
+}
+
+// push writes text to the slot unless it is empty, too large or already
+// there. It writes over the version the local clipboard holds, so a change
+// of the slot the agent has not seen yet is not overwritten blindly.
+func (a *agent) push(text string) error {
+	hash := sha256.Sum256([]byte(text))
+	a.mu.Lock()
+	unchanged, version := hash == a.last, a.version
+	a.mu.Unlock()
+	if unchanged || text == "" || len(text) > maxText {
+		return nil
+	}
+
+	cb, err := a.client.Write(a.publicId, version, textType, strings.NewReader(text))
+	if errors.Is(err, client.ErrConflict) {
+		// The slot changed meanwhile. The latest copy is pulled first, so
+		// the text is only written if it is not already there, over the
+		// version it replaces.
+		var latest *client.Clipboard
+		if latest, err = a.client.Get(a.publicId); err != nil {
+			return err
+		}
+		if !latest.Streamed && latest.Data == text {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.last, a.version = hash, max(a.version, latest.Version)
+			return nil
+		}
+		log.Printf("clipboard changed to version %d meanwhile, writing over it", latest.Version)
+		cb, err = a.client.Write(a.publicId, latest.Version, textType, strings.NewReader(text))
+	}
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last, a.version = hash, cb.Version
+	log.Printf("pushed %d bytes, version %d", len(text), cb.Version)
+	return nil
+}
+
+// watchRemote follows the slot over the sync protocol and pulls every new
+// version, reconnecting with increasing delays when the connection fails.
+func (a *agent) watchRemote(ctx context.Context) {
+	retry := minRetry
+	for {
+		start := time.Now()
+		err := a.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxRetry {
+			retry = minRetry
+		}
+		log.Printf("sync connection lost, reconnecting in %s: %v", retry, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(2*retry, maxRetry)
+	}
+}
+
+// follow subscribes to the slot and pulls its changes until the connection
+// fails. The snapshot answering the subscription catches up with changes
+// missed while disconnected.
+func (a *agent) follow(ctx context.Context) error {
+	conn, err := a.client.Sync()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := conn.WriteJSON(syncMessage{Op: "subscribe", PublicIds: []string{a.publicId}}); err != nil {
+		return err
+	}
+
+	for {
+		var m syncMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case m.Op == "error":
+			log.Printf("sync error: %s", m.Message)
+		case m.Op != "snapshot" && m.Op != "change", m.Id != a.id:
+			// Not news about the slot.
+		case m.Event == "clipboard.deleted":
+			log.Printf("clipboard %s was deleted", a.publicId)
+		default:
+			if err := a.pullVersion(m.Version); err != nil {
+				log.Printf("error pulling clipboard: %v", err)
+			}
+		}
+	}
+}
+
+// pullVersion writes the text of the slot to the local clipboard, unless
+// the local clipboard already holds that version or a later one. Data that
+// is not text is left alone.
+func (a *agent) pullVersion(version int) error {
+	a.mu.Lock()
+	current := a.version
+	a.mu.Unlock()
+	if version <= current {
+		return nil
+	}
+
+	text, dataType, err := a.read()
+	if err != nil {
+		return err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(dataType); !strings.HasPrefix(mediaType, "text/") {
+		log.Printf("skipping version %d of type %s", version, dataType)
+		a.setVersion(version)
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(text))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The change of a push may arrive before its response.
+	if hash == a.last {
+		a.version = max(a.version, version)
+		return nil
+	}
+	if err := a.local.Write(text); err != nil {
+		return err
+	}
+	a.last, a.version = hash, version
+	log.Printf("pulled %d bytes, version %d", len(text), version)
+	return nil
+}
+
+// read streams the data of the slot, reading at most maxText bytes. It
+// returns the data and its type.
+func (a *agent) read() (string, string, error) {
+	data, dataType, err := a.client.Read(a.publicId)
+	if err != nil {
+		return "", "", err
+	}
+	defer data.Close()
+
+	b, err := io.ReadAll(io.LimitReader(data, maxText+1))
+	if err != nil {
+		return "", "", err
+	}
+	if len(b) > maxText {
+		return "", "", fmt.Errorf("clipboard is larger than %d bytes", maxText)
+	}
+	return string(b), dataType, nil
+}
+
+func (a *agent) setVersion(version int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.version = max(a.version, version)
+}
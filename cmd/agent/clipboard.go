@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// errNoClipboard is returned when none of the clipboard commands of the
+// platform is installed.
+var errNoClipboard = errors.New("no clipboard command found")
+
+// commandClipboard accesses the clipboard of the operating system through
+// the commands shipped with it or commonly installed, so the agent needs
+// neither cgo nor platform libraries. It only handles text.
+type commandClipboard struct {
+	read  []string
+	write []string
+	env   []string
+}
+
+// Read returns the text on the clipboard.
+func (c *commandClipboard) Read() (string, error) {
+	cmd := exec.Command(c.read[0], c.read[1:]...)
+	cmd.Env = append(os.Environ(), c.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", commandError(c.read[0], err, stderr.String())
+	}
+	return string(out), nil
+}
+
+// Write puts text on the clipboard.
+func (c *commandClipboard) Write(text string) error {
+	cmd := exec.Command(c.write[0], c.write[1:]...)
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdin = strings.NewReader(text)
+	// Commands like xclip fork to keep owning the clipboard, and would
+	// keep captured output open until the selection is taken over.
+	if err := cmd.Run(); err != nil {
+		return commandError(c.write[0], err, "")
+	}
+	return nil
+}
+
+func commandError(name string, err error, stderr string) error {
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%s: %v: %s", name, err, stderr)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// firstInstalled returns the first clipboard whose commands are installed.
+func firstInstalled(candidates ...*commandClipboard) (*commandClipboard, error) {
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c.read[0]); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath(c.write[0]); err != nil {
+			continue
+		}
+		return c, nil
+	}
+	return nil, errNoClipboard
+}
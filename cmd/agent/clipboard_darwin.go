@@ -0,0 +1,11 @@
+package main
+
+// systemClipboard returns the macOS pasteboard. pbcopy and pbpaste encode
+// text as the locale says, so it is set to UTF-8.
+func systemClipboard() (*commandClipboard, error) {
+	return firstInstalled(&commandClipboard{
+		read:  []string{"pbpaste"},
+		write: []string{"pbcopy"},
+		env:   []string{"LANG=en_US.UTF-8"},
+	})
+}
@@ -0,0 +1,28 @@
+//go:build !darwin && !windows
+
+package main
+
+import "os"
+
+// systemClipboard returns the clipboard of the Wayland or X11 session,
+// through wl-clipboard, xclip or xsel, whichever is installed.
+func systemClipboard() (*commandClipboard, error) {
+	var candidates []*commandClipboard
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, &commandClipboard{
+			read:  []string{"wl-paste", "--no-newline", "--type", "text"},
+			write: []string{"wl-copy", "--type", "text/plain;charset=utf-8"},
+		})
+	}
+	candidates = append(candidates,
+		&commandClipboard{
+			read:  []string{"xclip", "-selection", "clipboard", "-out"},
+			write: []string{"xclip", "-selection", "clipboard", "-in"},
+		},
+		&commandClipboard{
+			read:  []string{"xsel", "--clipboard", "--output"},
+			write: []string{"xsel", "--clipboard", "--input"},
+		},
+	)
+	return firstInstalled(candidates...)
+}
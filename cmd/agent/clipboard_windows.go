@@ -0,0 +1,10 @@
+package main
+
+// systemClipboard returns the Windows clipboard, accessed through
+// PowerShell with UTF-8 console streams.
+func systemClipboard() (*commandClipboard, error) {
+	return firstInstalled(&commandClipboard{
+		read:  []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "[Console]::OutputEncoding = [Text.Encoding]::UTF8; [Console]::Out.Write((Get-Clipboard -Raw))"},
+		write: []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "[Console]::InputEncoding = [Text.Encoding]::UTF8; Set-Clipboard -Value ([Console]::In.ReadToEnd())"},
+	})
+}
@@ -0,0 +1,83 @@
+// Command copybridge-agent keeps a clipboard on the server in sync with the
+// clipboard of the machine it runs on, on Windows, macOS and Linux:
+//
+//	copybridge-agent -slot work
+//	copybridge-agent -slot 100000 -pull
+//
+// Text copied locally is streamed to the slot, the public id, id or alias of
+// a clipboard, and with -pull changes of the slot are applied to the local
+// clipboard. The server and API key are taken from COPYBRIDGE_URL and
+// COPYBRIDGE_API_KEY, or the -server and -key flags, and the password of an
+// encrypted slot from COPYBRIDGE_PASSWORD.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/copybridge/copybridge-server/pkg/copybridge/client"
+)
+
+const usage = `Usage: copybridge-agent [flags]
+
+Syncs the local clipboard with a clipboard on the server.
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("copybridge-agent", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", envOr("COPYBRIDGE_URL", "http://localhost:8080"), "server URL")
+	apiKey := flags.String("key", os.Getenv("COPYBRIDGE_API_KEY"), "API key")
+	slot := flags.String("slot", os.Getenv("COPYBRIDGE_SLOT"), "public id, id or alias of the clipboard to sync")
+	pull := flags.Bool("pull", false, "also apply changes of the slot to the local clipboard")
+	interval := flags.Duration("interval", time.Second, "how often to check the local clipboard")
+	_ = flags.Parse(os.Args[1:])
+
+	if *slot == "" || *interval <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	log.SetPrefix("copybridge-agent: ")
+	local, err := systemClipboard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a := &agent{
+		client: &client.Client{
+			BaseURL:  strings.TrimSuffix(*server, "/"),
+			APIKey:   *apiKey,
+			Password: os.Getenv("COPYBRIDGE_PASSWORD"),
+		},
+		local:    local,
+		slot:     *slot,
+		pull:     *pull,
+		interval: *interval,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := a.run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
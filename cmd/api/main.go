@@ -1,18 +1,113 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/doctor"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/logging"
 	"github.com/copybridge/copybridge-server/internal/server"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
 )
 
+// shutdownTimeout bounds how long in-flight requests and background jobs
+// get to finish on SIGINT or SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor())
+	}
+
+	logging.Setup()
 
-	server := server.NewServer()
+	shutdown, err := telemetry.Setup(context.Background())
+	if err != nil {
+		panic(fmt.Sprintf("cannot set up tracing: %s", err))
+	}
+	defer shutdown(context.Background())
 
-	fmt.Printf("Starting server on %s...", server.Addr)
-	err := server.ListenAndServe()
+	srv, err := server.NewServer()
 	if err != nil {
+		panic(fmt.Sprintf("cannot create server: %s", err))
+	}
+	check := env.Bool("SELF_CHECK", true)
+	if err := env.Err(); err != nil {
+		panic(fmt.Sprintf("invalid configuration: %s", err))
+	}
+	if check {
+		selfCheck()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Println("Shutting down...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	fmt.Printf("Starting server on %s...", srv.Addr)
+	err = server.ListenAndServe(srv)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
 }
+
+// runDoctor checks the configuration, database, certificates and
+// cryptography of the server without starting it, and prints what it finds
+// with hints on how to fix it. It returns 1 if the server would not start
+// or work correctly.
+func runDoctor() int {
+	var r doctor.Report
+	// The configuration goes first, as it sets up the key derivation the
+	// crypto self-test times.
+	if err := server.CheckConfig(); err != nil {
+		r.Fail("config", "see the Configuration section of the README", "%v", err)
+	} else {
+		r.OK("config", "configuration is valid")
+	}
+	database.Diagnose(&r)
+	doctor.TLS(&r, time.Now())
+	doctor.Crypto(&r)
+
+	r.Write(os.Stdout)
+	if r.Failed() {
+		return 1
+	}
+	return 0
+}
+
+// selfCheck runs the certificate and crypto checks of the doctor command on
+// start, logging what they warn about and exiting if one fails.
+func selfCheck() {
+	var r doctor.Report
+	doctor.TLS(&r, time.Now())
+	doctor.Crypto(&r)
+
+	for _, f := range r.Findings {
+		switch {
+		case f.Status == doctor.OK:
+		case f.Hint != "":
+			log.Printf("self-check %s: %s: %s (%s)", f.Status, f.Check, f.Message, f.Hint)
+		default:
+			log.Printf("self-check %s: %s: %s", f.Status, f.Check, f.Message)
+		}
+	}
+	if r.Failed() {
+		log.Fatal("self-check failed, run the doctor command for details")
+	}
+}
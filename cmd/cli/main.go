@@ -0,0 +1,258 @@
+// Command copybridge is the reference client of copybridge-server.
+//
+//	echo hello | copybridge copy -name greeting
+//	copybridge paste 7k2x...
+//	copybridge list
+//	copybridge delete 7k2x...
+//
+// Clipboards are given by public id, numeric id or alias, and new ones are
+// printed by public id. The server and API key are taken from
+// COPYBRIDGE_URL and COPYBRIDGE_API_KEY, or the -server and -key flags.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/copybridge/copybridge-server/pkg/copybridge/client"
+
+	"golang.org/x/term"
+)
+
+const usage = `Usage: copybridge [flags] <command> [arguments]
+
+Commands:
+  copy [-name name] [-type type] [-encrypt] [-id id]
+                  copy stdin to a new clipboard, or to clipboard id
+  paste [-untrusted] <id>
+                  write the data of a clipboard to stdout
+  list            list clipboards
+  delete <id>     delete a clipboard
+
+Clipboards are given by public id, numeric id or alias.
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("copybridge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", envOr("COPYBRIDGE_URL", "http://localhost:8080"), "server URL")
+	apiKey := flags.String("key", os.Getenv("COPYBRIDGE_API_KEY"), "API key")
+	prompt := flags.Bool("p", false, "prompt for the clipboard password")
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c := &client.Client{
+		BaseURL:  strings.TrimSuffix(*server, "/"),
+		APIKey:   *apiKey,
+		Password: os.Getenv("COPYBRIDGE_PASSWORD"),
+	}
+
+	var err error
+	if *prompt && c.Password == "" {
+		c.Password, err = readPassword("Password: ")
+		exitOn(err)
+	}
+
+	args := flags.Args()
+	switch args[0] {
+	case "copy":
+		err = copyCmd(c, args[1:])
+	case "paste":
+		err = pasteCmd(c, args[1:])
+	case "list":
+		err = listCmd(c)
+	case "delete":
+		err = deleteCmd(c, args[1:])
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	exitOn(err)
+}
+
+// copyCmd streams stdin to a clipboard, creating it unless -id is given,
+// and prints its public id. Without -type, the type is detected from the
+// data.
+func copyCmd(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ExitOnError)
+	name := flags.String("name", "clipboard", "name of the new clipboard")
+	dataType := flags.String("type", "", "data type, detected if not set")
+	encrypt := flags.Bool("encrypt", false, "protect the new clipboard with a password")
+	ref := flags.String("id", "", "replace the data of this clipboard instead of creating one")
+	_ = flags.Parse(args)
+
+	data := bufio.NewReaderSize(os.Stdin, 512)
+	if *dataType == "" {
+		head, _ := data.Peek(512)
+		*dataType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+
+	version := 0
+	if *ref == "" {
+		if *encrypt && c.Password == "" {
+			password, err := readPassword("New password: ")
+			if err != nil {
+				return err
+			}
+			confirmation, err := readPassword("Confirm password: ")
+			if err != nil {
+				return err
+			}
+			if password != confirmation {
+				return errors.New("passwords do not match")
+			}
+			c.Password = password
+		}
+		if !*encrypt {
+			c.Password = ""
+		}
+
+		created, err := c.Create(*name, *dataType)
+		if err != nil {
+			return err
+		}
+		*ref, version = created.PublicId, created.Version
+	}
+
+	written, err := c.Write(*ref, version, *dataType, data)
+	if err == client.ErrUnauthorized {
+		return errors.New("clipboard is encrypted, rerun with -p to enter its password")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(written.PublicId)
+	return nil
+}
+
+// pasteCmd writes the data of a clipboard to stdout, prompting for its
+// password if it is encrypted.
+func pasteCmd(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("paste", flag.ExitOnError)
+	untrusted := flags.Bool("untrusted", false, "paste content flagged as a script or executable")
+	_ = flags.Parse(args)
+	c.ConfirmUntrusted = *untrusted
+
+	ref, err := refArg(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	err = withPassword(c, func() error {
+		data, _, err := c.Read(ref)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		_, err = io.Copy(os.Stdout, data)
+		return err
+	})
+	var flagged *client.UntrustedError
+	if errors.As(err, &flagged) {
+		return fmt.Errorf("%w, rerun with -untrusted to paste it anyway", err)
+	}
+	return err
+}
+
+// listCmd prints the clipboards visible to the client as a table.
+func listCmd(c *client.Client) error {
+	cs, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tSIZE\tENCRYPTED")
+	for _, cb := range cs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%t\n", cb.PublicId, cb.Name, cb.DataType, cb.Size, cb.IsEncrypted)
+	}
+	return w.Flush()
+}
+
+// deleteCmd deletes a clipboard, prompting for its password if it is
+// encrypted.
+func deleteCmd(c *client.Client, args []string) error {
+	ref, err := refArg(args)
+	if err != nil {
+		return err
+	}
+
+	return withPassword(c, func() error {
+		return c.Delete(ref)
+	})
+}
+
+// withPassword runs a request, prompting for the clipboard password and
+// retrying once if the server asks for it.
+func withPassword(c *client.Client, request func() error) error {
+	err := request()
+	if err != client.ErrUnauthorized || c.Password != "" {
+		return err
+	}
+
+	if c.Password, err = readPassword("Password: "); err != nil {
+		return err
+	}
+	return request()
+}
+
+// readPassword prompts for a password on the terminal without echoing it.
+func readPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("cannot prompt for a password without a terminal, set COPYBRIDGE_PASSWORD")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	password, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// refArg returns the single clipboard argument of a command.
+func refArg(args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", errors.New("expected a clipboard id")
+	}
+	return args[0], nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func exitOn(err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("connection closed before all data was transferred: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "copybridge:", err)
+	os.Exit(1)
+}
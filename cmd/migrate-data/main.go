@@ -0,0 +1,96 @@
+// Command copybridge-migrate-data copies the database of copybridge-server
+// from its SQLite file to Postgres, for deployments outgrowing it:
+//
+//	copybridge-migrate-data -from ./copybridge.db -to postgres://copybridge@db/copybridge
+//
+// Every table is copied, clipboards with their encrypted data and metadata
+// as well as users, tokens and logs, from a consistent snapshot of the
+// SQLite file, in a single Postgres transaction. The rows are read back and
+// compared with the copied ones before it is committed. Data sealed at rest
+// stays sealed, and streamed data in BLOB_DIR or S3 stays where it is.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/copybridge/copybridge-server/internal/migrate"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `Usage: copybridge-migrate-data -from <sqlite file> -to <postgres dsn> [flags]
+
+Copies all data of a copybridge-server SQLite database to Postgres and
+verifies the copy. The Postgres password is best kept in PGPASSWORD or
+~/.pgpass rather than the DSN.
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("copybridge-migrate-data", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	from := flags.String("from", os.Getenv("DB_URL"), "SQLite database file or URL")
+	to := flags.String("to", os.Getenv("POSTGRES_URL"), "Postgres connection string or URL")
+	replace := flags.Bool("replace", false, "drop tables of the same names in Postgres first")
+	_ = flags.Parse(os.Args[1:])
+
+	if *from == "" || *to == "" || flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	log.SetPrefix("copybridge-migrate-data: ")
+	log.SetFlags(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, *from, *to, *replace); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run copies the SQLite database at from to the Postgres database at to.
+func run(ctx context.Context, from, to string, replace bool) error {
+	if _, err := os.Stat(strings.TrimPrefix(strings.SplitN(from, "?", 2)[0], "file:")); err != nil {
+		return err
+	}
+	src, err := sql.Open("sqlite3", migrate.ReadOnlyDSN(from))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := sql.Open("postgres", to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if err := dst.PingContext(ctx); err != nil {
+		return fmt.Errorf("connecting to Postgres: %w", err)
+	}
+
+	tables, err := migrate.Copy(ctx, src, dst, migrate.Postgres, replace)
+	if err != nil {
+		return err
+	}
+	var rows int64
+	for _, t := range tables {
+		log.Printf("copied %s: %d rows", t.Name, t.Rows)
+		rows += t.Rows
+	}
+	log.Printf("copied and verified %d rows of %d tables", rows, len(tables))
+	return nil
+}
@@ -0,0 +1,52 @@
+package account
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// User is an account that owns clipboards.
+type User struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// Namespace isolates the user and their clipboards from other
+	// namespaces. Names are unique within a namespace.
+	Namespace string `json:"namespace"`
+	// Role bounds what the user may do, see Allows.
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	// TOTPEnabled is set once the user has enrolled a second factor, see
+	// TOTP. Logging in then takes a code.
+	TOTPEnabled bool `json:"totp_enabled"`
+}
+
+// HashKey returns the hex-encoded SHA-256 hash of an API key.
+// API keys are high-entropy secrets, so a fast hash is enough to avoid
+// keeping them in memory or storage in plaintext.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseKeys parses a comma-separated list of "user:key" pairs into a map of
+// key hashes to user names. Users outside the default namespace are
+// qualified with it, as in "family/alice:key", see SplitName.
+func ParseKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid API key entry for %q", name)
+		}
+		keys[HashKey(key)] = name
+	}
+
+	return keys, nil
+}
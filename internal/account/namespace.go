@@ -0,0 +1,30 @@
+package account
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultNamespace is the namespace of users and clipboards that were not
+// put in another one. It always exists.
+const DefaultNamespace = "default"
+
+// namespacePattern matches valid namespace names, which appear in paths
+// and environment variable names.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidNamespace reports whether name is a valid namespace name: up to 32
+// lowercase letters, digits, dashes and underscores.
+func ValidNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+// SplitName splits a user name qualified with its namespace, such as
+// "family/alice", into the namespace and the name. Unqualified names are
+// in the default namespace.
+func SplitName(qualified string) (namespace, name string) {
+	if namespace, name, ok := strings.Cut(qualified, "/"); ok {
+		return namespace, name
+	}
+	return DefaultNamespace, qualified
+}
@@ -0,0 +1,37 @@
+package account
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// PairingCodeLength is the number of digits of pairing codes.
+const PairingCodeLength = 6
+
+// NewPairingCode returns a random numeric pairing code, short enough to type
+// on a phone. It is only safe to use with a short lifetime and a limit on
+// failed attempts.
+func NewPairingCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", PairingCodeLength, n.Int64()), nil
+}
+
+// NormalizePairingCode strips the spaces and dashes people type between the
+// digits of a pairing code. It returns "" if the code is malformed.
+func NormalizePairingCode(code string) string {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	if len(code) != PairingCodeLength {
+		return ""
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+	return code
+}
@@ -0,0 +1,45 @@
+package account
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Peer is a device of a user on a WireGuard network. WireGuard only accepts
+// packets from the addresses configured for the key of a peer, so the
+// address a request comes from over the tunnel identifies the device.
+type Peer struct {
+	// User is the name of the user, qualified with their namespace like in
+	// ParseKeys.
+	User   string
+	Device string
+}
+
+// ParsePeers parses a comma-separated list of "user@device:ip" entries into
+// a map of the IP addresses of WireGuard peers, in canonical form, to the
+// peers. Users may have several devices, but every address belongs to one.
+func ParsePeers(s string) (map[string]Peer, error) {
+	peers := make(map[string]Peer)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(entry, ":")
+		user, device, hasDevice := strings.Cut(name, "@")
+		if !ok || !hasDevice || user == "" || device == "" {
+			return nil, fmt.Errorf("invalid peer entry %q", entry)
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q of peer %s", addr, name)
+		}
+		if _, ok := peers[ip.String()]; ok {
+			return nil, fmt.Errorf("duplicate peer address %s", addr)
+		}
+		peers[ip.String()] = Peer{User: user, Device: device}
+	}
+
+	return peers, nil
+}
@@ -0,0 +1,71 @@
+package account
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles of users across the server. Unlike the roles of clipboard.Permission,
+// they bound what a user may do on any clipboard.
+const (
+	// RoleAdmin may also use the admin API.
+	RoleAdmin = "admin"
+	// RoleUser may read and write clipboards. New users get this role.
+	RoleUser = "user"
+	// RoleReadOnly may only read clipboards.
+	RoleReadOnly = "readonly"
+)
+
+// Permissions checked against the role of a user.
+const (
+	PermRead  = "read"
+	PermWrite = "write"
+	PermAdmin = "admin"
+)
+
+// policy lists the permissions of every role.
+var policy = map[string][]string{
+	RoleAdmin:    {PermRead, PermWrite, PermAdmin},
+	RoleUser:     {PermRead, PermWrite},
+	RoleReadOnly: {PermRead},
+}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	_, ok := policy[role]
+	return ok
+}
+
+// Allows reports whether a user with the given role has a permission.
+// Unknown roles have none.
+func Allows(role, perm string) bool {
+	for _, p := range policy[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRoles parses a comma-separated list of "user:role" pairs into a map
+// of user names to roles. Names may be qualified with a namespace like in
+// ParseKeys.
+func ParseRoles(s string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, role, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid role entry %q", entry)
+		}
+		if !ValidRole(role) {
+			return nil, fmt.Errorf("invalid role %q for %s", role, name)
+		}
+		roles[name] = role
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,150 @@
+package account
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned for malformed, forged or expired tokens.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Session is a login of a user, kept alive by a refresh token.
+// Only the hash of the current refresh token is stored, and it changes on
+// every refresh.
+type Session struct {
+	Id          string     `json:"id"`
+	UserId      int        `json:"user_id"`
+	RefreshHash string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	// TOTPVerified is set for sessions logged in with a TOTP code, or with
+	// multi-factor authentication at an identity provider, which the admin
+	// API requires of users with the admin role.
+	TOTPVerified bool `json:"totp_verified"`
+}
+
+// Active reports whether the session can still be used at the given time.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Claims are the claims of an access token.
+type Claims struct {
+	Name      string `json:"name"`
+	SessionId string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// UserId returns the id of the user the token was issued to.
+func (c *Claims) UserId() int {
+	id, _ := strconv.Atoi(c.Subject)
+	return id
+}
+
+// Tokens issues and verifies HS256-signed access tokens.
+type Tokens struct {
+	secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// NewTokens creates a token issuer signing with the given secret.
+func NewTokens(secret []byte, accessTTL, refreshTTL time.Duration) *Tokens {
+	return &Tokens{secret: secret, AccessTTL: accessTTL, RefreshTTL: refreshTTL}
+}
+
+// Access issues an access token for a user within a session.
+func (t *Tokens) Access(u *User, sessionId string, now time.Time) (string, error) {
+	claims := Claims{
+		Name:      u.Name,
+		SessionId: sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(u.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.AccessTTL)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+}
+
+// Verify checks the signature and expiry of an access token and returns its
+// claims.
+func (t *Tokens) Verify(token string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return t.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || claims.UserId() == 0 || claims.SessionId == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// Sign signs other claims with the secret of access tokens, for state the
+// server hands to clients and must get back unchanged. The claims should
+// carry an audience telling them apart from access tokens.
+func (t *Tokens) Sign(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+}
+
+// Parse verifies the signature, expiry and audience of a token made with
+// Sign and decodes its claims.
+func (t *Tokens) Parse(token, audience string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return t.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	if err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// IsJWT reports whether a bearer token looks like a JWT rather than an API key.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// NewRefreshToken generates a refresh token for a session.
+// Refresh tokens are "<session id>.<secret>", so the session can be looked up
+// before comparing the hash of the secret.
+func NewRefreshToken(sessionId string) (token, hash string, err error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return sessionId + "." + secret, HashKey(secret), nil
+}
+
+// ParseRefreshToken splits a refresh token into its session id and the hash
+// of its secret.
+func ParseRefreshToken(token string) (sessionId, hash string, err error) {
+	sessionId, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionId == "" || secret == "" {
+		return "", "", ErrInvalidToken
+	}
+
+	return sessionId, HashKey(secret), nil
+}
+
+// NewSessionId generates a random session id.
+func NewSessionId() (string, error) {
+	return randomHex(16)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,263 @@
+// Package backup reads and writes archives of clipboards, to move them
+// between servers or keep them safe during upgrades.
+package backup
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Archive formats.
+const (
+	FormatJSON = "json"
+	FormatTar  = "tar"
+)
+
+// formatVersion is the version of the archive layout written by Writer.
+const formatVersion = 1
+
+// Record is a clipboard as stored in an archive. Encrypted clipboards carry
+// their ciphertext, password hash, salt, nonce and KDF parameters verbatim, so they can be
+// restored without knowing their password. Data is never sealed with a
+// server master key, as those differ between servers.
+type Record struct {
+	Id           int       `json:"id"`
+	PublicId     string    `json:"public_id,omitempty"`
+	Code         string    `json:"code,omitempty"`
+	Name         string    `json:"name"`
+	DataType     string    `json:"type"`
+	IsEncrypted  bool      `json:"is_encrypted"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Salt         string    `json:"salt,omitempty"`
+	Nonce        string    `json:"nonce,omitempty"`
+	KDF          string    `json:"kdf,omitempty"`
+	Streamed     bool      `json:"streamed,omitempty"`
+	Version      int       `json:"version"`
+	Owner        string    `json:"owner,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	ReadOnly     bool      `json:"read_only,omitempty"`
+	Transforms   []string  `json:"transforms,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Namespace is the namespace of the clipboard and its owner, omitted
+	// for the default namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Filename and ContentDisposition are those of the clipboard, see
+	// clipboard.Clipboard.DispositionHeader.
+	Filename           string `json:"filename,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+
+	// Charset and Locale are those of the clipboard, see
+	// clipboard.ParseText.
+	Charset string `json:"charset,omitempty"`
+	Locale  string `json:"locale,omitempty"`
+
+	// EncryptionScope and SealedFields are those of encrypted clipboards,
+	// see clipboard.ScopeAll, and WrappedKey their wrapped data key.
+	EncryptionScope string `json:"encryption_scope,omitempty"`
+	SealedFields    string `json:"sealed_fields,omitempty"`
+	WrappedKey      string `json:"wrapped_key,omitempty"`
+
+	// Quarantine is what the content scanner flagged the clipboard for.
+	Quarantine string `json:"quarantine,omitempty"`
+
+	// PublishAt and ExpiresAt schedule the clipboard, see
+	// clipboard.Clipboard.Published.
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Flavors are kept with the metadata in both formats.
+	Flavors []Flavor `json:"flavors,omitempty"`
+
+	// Data is base64-encoded in JSON archives and stored as a file of its
+	// own in tar archives.
+	Data []byte `json:"data,omitempty"`
+}
+
+// Flavor is an alternative representation of the data of a record.
+type Flavor struct {
+	DataType string `json:"type"`
+	Nonce    string `json:"nonce,omitempty"`
+	Data     []byte `json:"data"`
+}
+
+// Writer writes records to an archive.
+type Writer interface {
+	Write(rec *Record) error
+	// Close finishes the archive without closing the underlying writer.
+	Close() error
+}
+
+// NewWriter returns a writer of an archive in the given format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	case FormatTar:
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+}
+
+// Read calls fn for every record of an archive in the given format, in the
+// order they were written.
+func Read(r io.Reader, format string, fn func(rec *Record) error) error {
+	switch format {
+	case FormatJSON:
+		return readJSON(r, fn)
+	case FormatTar:
+		return readTar(r, fn)
+	default:
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+}
+
+// jsonWriter writes a single JSON document, one record at a time, so large
+// archives are never held in memory:
+//
+//	{"version": 1, "clipboards": [...]}
+type jsonWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (j *jsonWriter) start() error {
+	if j.started {
+		_, err := io.WriteString(j.w, ",\n")
+		return err
+	}
+	j.started = true
+	_, err := fmt.Fprintf(j.w, "{\"version\":%d,\"clipboards\":[\n", formatVersion)
+	return err
+}
+
+func (j *jsonWriter) Write(rec *Record) error {
+	if err := j.start(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.started {
+		_, err := fmt.Fprintf(j.w, "{\"version\":%d,\"clipboards\":[]}\n", formatVersion)
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n]}\n")
+	return err
+}
+
+func readJSON(r io.Reader, fn func(rec *Record) error) error {
+	var archive struct {
+		Version    int      `json:"version"`
+		Clipboards []Record `json:"clipboards"`
+	}
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return err
+	}
+	if archive.Version != formatVersion {
+		return fmt.Errorf("unsupported archive version %d", archive.Version)
+	}
+
+	for i := range archive.Clipboards {
+		if err := fn(&archive.Clipboards[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarWriter writes every record as clipboards/{id}.json holding its
+// metadata, followed by clipboards/{id}.data holding its data as is.
+type tarWriter struct {
+	tw *tar.Writer
+}
+
+func (t *tarWriter) Write(rec *Record) error {
+	meta := *rec
+	meta.Data = nil
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("clipboards/%d", rec.Id)
+	if err := t.writeFile(name+".json", b, rec.UpdatedAt); err != nil {
+		return err
+	}
+	return t.writeFile(name+".data", rec.Data, rec.UpdatedAt)
+}
+
+func (t *tarWriter) writeFile(name string, b []byte, modTime time.Time) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(b)),
+		Mode:     0o600,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = t.tw.Write(b)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	return t.tw.Close()
+}
+
+func readTar(r io.Reader, fn func(rec *Record) error) error {
+	tr := tar.NewReader(r)
+
+	var pending *Record
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch ext := path.Ext(hdr.Name); {
+		case ext == ".json" && pending == nil:
+			pending = &Record{}
+			if err := json.NewDecoder(tr).Decode(pending); err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+		case ext == ".data" && pending != nil && strings.TrimSuffix(hdr.Name, ext) == fmt.Sprintf("clipboards/%d", pending.Id):
+			if pending.Data, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+			if err := fn(pending); err != nil {
+				return err
+			}
+			pending = nil
+		default:
+			return fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+	}
+
+	if pending != nil {
+		return fmt.Errorf("archive ends without the data of clipboard %d", pending.Id)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package bandwidth meters the bytes transferred per key (users, clipboard
+// tokens, IP addresses) over a UTC day, for daily bandwidth quotas.
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+// Meter counts the bytes transferred per key since the start of the
+// current UTC day. Counts are forgotten when the day changes.
+type Meter struct {
+	mu   sync.Mutex
+	day  time.Time
+	used map[string]int64
+}
+
+// New returns an empty meter.
+func New() *Meter {
+	return &Meter{used: make(map[string]int64)}
+}
+
+// Used returns the bytes transferred by the key today.
+func (m *Meter) Used(key string, now time.Time) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover(now)
+	return m.used[key]
+}
+
+// Add counts n bytes transferred by the key today and returns its total.
+func (m *Meter) Add(key string, n int64, now time.Time) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover(now)
+	m.used[key] += n
+	return m.used[key]
+}
+
+// Reset returns when the counts of the day of now are forgotten: the start
+// of the next UTC day.
+func Reset(now time.Time) time.Time {
+	return startOfDay(now).AddDate(0, 0, 1)
+}
+
+// rollover forgets the counts of previous days.
+func (m *Meter) rollover(now time.Time) {
+	if day := startOfDay(now); !day.Equal(m.day) {
+		m.day = day
+		clear(m.used)
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, mo, d := t.UTC().Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
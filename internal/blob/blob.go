@@ -0,0 +1,214 @@
+// Package blob stores large clipboard data outside of the clipboards table,
+// so it can be streamed instead of being loaded into memory at once.
+package blob
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// ErrNotFound is returned when opening a blob that does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Store stores blobs by key.
+type Store interface {
+	// Put stores the content of r under key and returns its size.
+	Put(key string, r io.Reader) (int64, error)
+	// Open returns a reader of the blob stored under key.
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a blob that does
+	// not exist is not an error.
+	Delete(key string) error
+}
+
+// Shredder is implemented by stores that can overwrite a blob before
+// removing it, so its content cannot be recovered from the storage. Stores
+// that cannot, such as S3, only delete blobs, see Shred.
+type Shredder interface {
+	Shred(key string) error
+}
+
+// Shred overwrites and removes the blob stored under key if store is a
+// Shredder, and deletes it otherwise.
+func Shred(store Store, key string) error {
+	if s, ok := store.(Shredder); ok {
+		return s.Shred(key)
+	}
+	return store.Delete(key)
+}
+
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// NewKey generates a random blob key.
+func NewKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Presigner is implemented by stores that can hand out temporary URLs to
+// download blobs from and upload blobs to directly.
+type Presigner interface {
+	// PresignGet returns a URL that downloads a blob, served with the
+	// given content type and, unless empty, content disposition.
+	PresignGet(key, contentType, disposition string, ttl time.Duration) (string, error)
+	// PresignPut returns a URL that stores a blob of exactly size bytes,
+	// sent with the given content type.
+	PresignPut(key, contentType string, size int64, ttl time.Duration) (string, error)
+	// Size returns the size of a stored blob, or ErrNotFound.
+	Size(key string) (int64, error)
+}
+
+// FromEnv returns the store configured by S3_* or BLOB_DIR variables, or
+// fallback if neither is set.
+func FromEnv(fallback Store) (Store, error) {
+	if s3 := S3FromEnv(); s3 != nil {
+		return s3, nil
+	}
+
+	if dir := env.String("BLOB_DIR", ""); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		return Dir(dir), nil
+	}
+
+	return fallback, nil
+}
+
+// S3FromEnv returns the bucket configured by S3_* variables, or nil if
+// S3_BUCKET is not set.
+func S3FromEnv() *S3 {
+	bucket := env.String("S3_BUCKET", "")
+	if bucket == "" {
+		return nil
+	}
+	region := env.String("S3_REGION", "us-east-1")
+	return &S3{
+		Endpoint:        env.String("S3_ENDPOINT", "https://s3."+region+".amazonaws.com"),
+		Region:          region,
+		Bucket:          bucket,
+		Prefix:          env.String("S3_PREFIX", ""),
+		AccessKeyId:     env.String("S3_ACCESS_KEY_ID", ""),
+		SecretAccessKey: env.String("S3_SECRET_ACCESS_KEY", ""),
+		PathStyle:       env.Bool("S3_PATH_STYLE", true),
+	}
+}
+
+// Dir stores blobs as files in a directory.
+type Dir string
+
+// Put writes the blob to a temporary file first, so readers never see
+// partially written blobs.
+func (d Dir) Put(key string, r io.Reader) (int64, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(string(d), key+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return n, err
+	}
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(f.Name(), path)
+}
+
+func (d Dir) Open(key string) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (d Dir) Delete(key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Shred overwrites the file of a blob with zeros and syncs it before
+// removing it.
+func (d Dir) Shred(key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		_, err = io.CopyN(f, zeros{}, info.Size())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return d.Delete(key)
+}
+
+// zeros reads an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// path returns the file of a blob, refusing keys that could escape the
+// directory.
+func (d Dir) path(key string) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(string(d), key), nil
+}
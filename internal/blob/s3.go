@@ -0,0 +1,397 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the size of the parts blobs of unknown length are
+	// uploaded in. S3 requires at least 5 MiB for all parts but the last.
+	s3PartSize = 8 << 20
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// S3 stores blobs as objects in an S3-compatible bucket, such as AWS S3 or
+// MinIO. Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the keys of all objects.
+	Prefix string
+
+	AccessKeyId     string
+	SecretAccessKey string
+
+	// PathStyle addresses the bucket in the path rather than the host name,
+	// as MinIO and most other S3-compatible services expect.
+	PathStyle bool
+
+	Client *http.Client
+	// Clock returns the current time, defaulting to time.Now.
+	Clock func() time.Time
+}
+
+func (s *S3) Put(key string, r io.Reader) (int64, error) {
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Small blobs fit in a single request.
+		resp, err := s.do(http.MethodPut, key, nil, bytes.NewReader(part[:n]), int64(n))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	uploadId, err := s.createMultipartUpload(key)
+	if err != nil {
+		return 0, err
+	}
+	total, err := s.uploadParts(key, uploadId, part[:n], r)
+	if err != nil {
+		if resp, abortErr := s.do(http.MethodDelete, key, url.Values{"uploadId": {uploadId}}, nil, 0); abortErr == nil {
+			resp.Body.Close()
+		}
+		return total, err
+	}
+
+	return total, nil
+}
+
+// uploadParts uploads first and the rest of r as parts of a multipart
+// upload and completes it.
+func (s *S3) uploadParts(key, uploadId string, first []byte, r io.Reader) (int64, error) {
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []completedPart
+	var total int64
+
+	part := first
+	buf := make([]byte, s3PartSize)
+	for number := 1; ; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId}}
+		resp, err := s.do(http.MethodPut, key, query, bytes.NewReader(part), int64(len(part)))
+		if err != nil {
+			return total, err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{number, resp.Header.Get("ETag")})
+		total += int64(len(part))
+
+		n, err := io.ReadFull(r, buf)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
+		part = buf[:n]
+	}
+
+	complete := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return total, err
+	}
+
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadId}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return total, err
+	}
+	defer resp.Body.Close()
+
+	// Completing can fail after the response status has been sent.
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return total, err
+	}
+	if result.XMLName.Local == "Error" {
+		return total, fmt.Errorf("s3: completing upload failed: %s: %s", result.Code, result.Message)
+	}
+
+	return total, nil
+}
+
+func (s *S3) createMultipartUpload(key string) (string, error) {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadId string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.UploadId == "" {
+		return "", errors.New("s3: no upload id in response")
+	}
+	return result.UploadId, nil
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, 0)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PresignGet returns a URL that downloads a blob without credentials until
+// it expires after ttl. The response carries the given content type and
+// disposition.
+func (s *S3) PresignGet(key, contentType, disposition string, ttl time.Duration) (string, error) {
+	u, host := s.url(key)
+	now := s.now()
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKeyId + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if contentType != "" {
+		query.Set("response-content-type", contentType)
+	}
+	if disposition != "" {
+		query.Set("response-content-disposition", disposition)
+	}
+
+	signature := s.signature(now, http.MethodGet, u.EscapedPath(), query, http.Header{}, host, []string{"host"}, unsignedPayload)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQuery(query)
+
+	return u.String(), nil
+}
+
+// PresignPut returns a URL that uploads a blob without credentials until it
+// expires after ttl. The upload must carry the given content type and
+// length, which are signed.
+func (s *S3) PresignPut(key, contentType string, size int64, ttl time.Duration) (string, error) {
+	u, host := s.url(key)
+	now := s.now()
+
+	signed := []string{"content-length", "content-type", "host"}
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKeyId + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {strings.Join(signed, ";")},
+	}
+	header := http.Header{}
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	header.Set("Content-Type", contentType)
+
+	signature := s.signature(now, http.MethodPut, u.EscapedPath(), query, header, host, signed, unsignedPayload)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQuery(query)
+
+	return u.String(), nil
+}
+
+// Size returns the size of an object.
+func (s *S3) Size(key string) (int64, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// do sends a signed request for an object and returns the response if it
+// succeeded.
+func (s *S3) do(method, key string, query url.Values, body io.Reader, length int64) (*http.Response, error) {
+	u, host := s.url(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+
+	now := s.now()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	signature := s.signature(now, method, u.EscapedPath(), query, req.Header, host, signed, unsignedPayload)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyId, s.scope(now), strings.Join(signed, ";"), signature))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3: %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// url returns the URL of an object and the host it is addressed at.
+func (s *S3) url(key string) (*url.URL, string) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		u = &url.URL{Scheme: "https", Host: s.Endpoint}
+	}
+
+	object := "/" + s.Prefix + key
+	if s.PathStyle {
+		u.Path += "/" + s.Bucket + object
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path += object
+	}
+	u.RawPath = escapePath(u.Path)
+
+	return u, u.Host
+}
+
+func (s *S3) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// scope returns the credential scope of requests signed at the given time.
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// signature computes the Signature Version 4 of a request.
+func (s *S3) signature(t time.Time, method, path string, query url.Values, header http.Header, host string, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		value := host
+		if name != "host" {
+			value = strings.TrimSpace(header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	canonical := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery(query),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format(amzDateFormat) + "\n" + s.scope(t) + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as Signature
+// Version 4 expects.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, escape(name)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapePath escapes every segment of a path.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape percent-encodes everything but unreserved characters.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
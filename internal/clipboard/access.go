@@ -0,0 +1,39 @@
+package clipboard
+
+import "time"
+
+// Access log actions.
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionAudit  = "audit"
+	ActionShare  = "share"
+)
+
+// Access log outcomes.
+const (
+	OutcomeSuccess      = "success"
+	OutcomeUnauthorized = "unauthorized"
+	OutcomeThrottled    = "throttled"
+	OutcomeForbidden    = "forbidden"
+	// OutcomeDecryptionFailed records that the data of an encrypted
+	// clipboard could not be decrypted after its password was accepted.
+	OutcomeDecryptionFailed = "decryption_failed"
+)
+
+// SecurityOutcomes are the outcomes of failed attempts to get at encrypted
+// clipboards, which the admin API lists as security events.
+var SecurityOutcomes = []string{OutcomeUnauthorized, OutcomeThrottled, OutcomeDecryptionFailed}
+
+// AccessEntry records a single access to a clipboard.
+type AccessEntry struct {
+	Id          int       `json:"id"`
+	ClipboardId int       `json:"clipboard_id"`
+	Action      string    `json:"action"`
+	Outcome     string    `json:"outcome"`
+	IP          string    `json:"ip"`
+	Device      string    `json:"device"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,46 @@
+package clipboard
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var aliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// reservedAliases are path segments of routes next to /clipboard/{id},
+// which aliases would be shadowed by.
+var reservedAliases = map[string]bool{"reserve": true, "uploads": true}
+
+// Alias is a name addressing a clipboard in a namespace wherever its id is
+// accepted, e.g. /clipboard/current-otp. It can be pointed at another
+// clipboard, so clients keep using the name while the clipboard changes.
+type Alias struct {
+	Name        string    `json:"name"`
+	ClipboardId int       `json:"clipboard_id"`
+	Namespace   string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// NormalizeAlias lowercases an alias name and validates that it only
+// contains letters, digits and the characters _ . - (at most 64), and
+// cannot be mistaken for a clipboard id or public id.
+func NormalizeAlias(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case !aliasPattern.MatchString(name):
+		return "", fmt.Errorf("invalid alias %q, must be letters, digits, _ . or - (at most 64)", name)
+	case strings.Trim(name, "0123456789") == "", IsPublicId(name), reservedAliases[name]:
+		return "", fmt.Errorf("alias %q is reserved", name)
+	}
+	return name, nil
+}
+
+// IsAlias reports whether an id may be an alias rather than a numeric or
+// public clipboard id.
+func IsAlias(id string) bool {
+	normalized, err := NormalizeAlias(id)
+	return err == nil && normalized == id
+}
@@ -0,0 +1,21 @@
+package clipboard
+
+import "time"
+
+// Change is an entry of the changefeed, recording that a clipboard was
+// created, updated or deleted, or that an item was pushed to or popped from
+// its stack. Op is the type of the event announcing the change, see package
+// events. Seq increases with every recorded change, so clients can ask for
+// the changes after the last one they saw.
+type Change struct {
+	Seq         int64     `json:"seq"`
+	ClipboardId int       `json:"id"`
+	PublicId    string    `json:"public_id,omitempty"`
+	Op          string    `json:"op"`
+	CreatedAt   time.Time `json:"time"`
+
+	// OwnerId and Namespace are those of the clipboard at the time of the
+	// change, which decide who sees it.
+	OwnerId   int    `json:"-"`
+	Namespace string `json:"-"`
+}
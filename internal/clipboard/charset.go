@@ -0,0 +1,171 @@
+package clipboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/language"
+	"golang.org/x/text/transform"
+)
+
+var (
+	// ErrUnknownCharset is returned for text types naming a charset that is
+	// not supported.
+	ErrUnknownCharset = errors.New("unsupported charset")
+	// ErrInvalidEncoding is returned for text that is not valid in its
+	// charset.
+	ErrInvalidEncoding = errors.New("invalid text encoding")
+)
+
+// MaxLocaleLength is the maximum length of a locale in bytes.
+const MaxLocaleLength = 35
+
+// Text converts text data sent in the charset its type names to UTF-8,
+// so text pasted on Windows in windows-1252 does not turn into mojibake on
+// other systems. Text is stored as UTF-8 only.
+type Text struct {
+	// DataType is the type the data is stored as, declaring UTF-8 instead of
+	// the charset it was sent in.
+	DataType string
+	// Charset is the charset the data was sent in by its WHATWG name, such
+	// as "windows-1252", or "" for UTF-8.
+	Charset string
+
+	// decoder converts the data to UTF-8, or validates it if it is UTF-8
+	// already. It is nil for types that are not text.
+	decoder transform.Transformer
+	name    string
+}
+
+// ParseText returns the conversion of data of the given type to UTF-8. The
+// charset parameter of text types names the charset of their data, which
+// is UTF-8 without one. Types that are not text, or malformed, are left as
+// they are. It returns an error wrapping ErrUnknownCharset if the charset is
+// not one of the WHATWG Encoding Standard.
+func ParseText(dataType string) (*Text, error) {
+	base, params, err := mime.ParseMediaType(dataType)
+	if err != nil || !isTextual(base) {
+		return &Text{DataType: dataType}, nil
+	}
+
+	t := &Text{DataType: dataType, decoder: encoding.UTF8Validator, name: "UTF-8"}
+	name, ok := params["charset"]
+	if !ok {
+		return t, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCharset, name)
+	}
+	if canonical, _ := htmlindex.Name(enc); canonical != "utf-8" {
+		params["charset"] = "utf-8"
+		t.DataType = mime.FormatMediaType(base, params)
+		t.Charset, t.name = canonical, canonical
+		// Decoders replace bytes that are invalid in their charset with
+		// U+FFFD rather than failing, so it is rejected in their output.
+		t.decoder = transform.Chain(enc.NewDecoder(), rejectReplacement{})
+	}
+	return t, nil
+}
+
+// Decode returns data converted to UTF-8. It returns an error wrapping
+// ErrInvalidEncoding if data is not valid in its charset.
+func (t *Text) Decode(data string) (string, error) {
+	switch {
+	case t.decoder == nil:
+		return data, nil
+	case t.Charset == "":
+		if !utf8.ValidString(data) {
+			return "", t.invalid()
+		}
+		return data, nil
+	}
+
+	s, _, err := transform.String(t.decoder, data)
+	if err != nil {
+		return "", t.invalid()
+	}
+	return s, nil
+}
+
+// Reader returns a reader of r converted to UTF-8, which fails with an
+// error wrapping ErrInvalidEncoding once it reads data that is not valid in
+// its charset. Errors of r are returned as they are.
+func (t *Text) Reader(r io.Reader) io.Reader {
+	if t.decoder == nil {
+		return r
+	}
+	return &textReader{r: transform.NewReader(r, t.decoder), t: t}
+}
+
+func (t *Text) invalid() error {
+	return fmt.Errorf("%w: data is not valid %s", ErrInvalidEncoding, t.name)
+}
+
+type textReader struct {
+	r io.Reader
+	t *Text
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if errors.Is(err, encoding.ErrInvalidUTF8) || errors.Is(err, errReplacement) {
+		err = r.t.invalid()
+	}
+	return n, err
+}
+
+var errReplacement = errors.New("replacement character in decoded text")
+
+// replacement is U+FFFD encoded in UTF-8.
+var replacement = []byte("�")
+
+// rejectReplacement copies its input, failing on U+FFFD.
+type rejectReplacement struct{ transform.NopResetter }
+
+func (rejectReplacement) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	n := len(src)
+	if !atEOF {
+		// A partial U+FFFD at the end is held back until the rest arrives.
+		for k := len(replacement) - 1; k > 0; k-- {
+			if bytes.HasSuffix(src, replacement[:k]) {
+				n -= k
+				break
+			}
+		}
+	}
+	if bytes.Contains(src[:n], replacement) {
+		return 0, 0, errReplacement
+	}
+	if len(dst) < n {
+		return copy(dst, src[:len(dst)]), len(dst), transform.ErrShortDst
+	}
+	copy(dst, src[:n])
+	if n < len(src) {
+		return n, n, transform.ErrShortSrc
+	}
+	return n, n, nil
+}
+
+// NormalizeLocale returns the canonical form of a BCP 47 language tag, e.g.
+// "de-DE" for "de_de", or an error if locale is not one. The empty locale
+// stands for none.
+func NormalizeLocale(locale string) (string, error) {
+	if locale == "" {
+		return "", nil
+	}
+	if len(locale) > MaxLocaleLength {
+		return "", fmt.Errorf("locale must be at most %d bytes", MaxLocaleLength)
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", fmt.Errorf("invalid locale %q, must be a language tag such as en-US", locale)
+	}
+	return tag.String(), nil
+}
@@ -1,7 +1,15 @@
 package clipboard
 
+import (
+	"slices"
+	"time"
+)
+
 type Clipboard struct {
-	Id           int    `json:"id"`
+	Id       int    `json:"id"`
+	PublicId string `json:"public_id,omitempty"`
+	// Code is the share code the clipboard can be found by, see NewCode.
+	Code         string `json:"code,omitempty"`
 	Name         string `json:"name"`
 	DataType     string `json:"type"`
 	Data         string `json:"data"`
@@ -9,6 +17,75 @@ type Clipboard struct {
 	PasswordHash string `json:"-"`
 	Salt         string `json:"-"`
 	Nonce        string `json:"-"`
+	// KDF holds the parameters the key is derived with, see KDFParams.
+	KDF string `json:"-"`
+	// WrappedKey is the data key of the clipboard, encrypted with the key
+	// derived from its password.
+	WrappedKey string `json:"-"`
+	// EncryptionScope is what the encryption of the clipboard covers, see
+	// ScopeData and ScopeAll. SealedFields holds the encrypted name, type,
+	// filename, disposition, charset and locale of clipboards encrypted with
+	// ScopeAll.
+	EncryptionScope string `json:"encryption_scope,omitempty"`
+	SealedFields    string `json:"-"`
+
+	// Filename is the name the data is downloaded as, and
+	// ContentDisposition whether it is shown inline or downloaded, see
+	// DispositionHeader.
+	Filename           string `json:"filename,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+	// Charset is the charset text was sent in if it was not UTF-8, such as
+	// "windows-1252". Text is always stored as UTF-8, see ParseText.
+	Charset string `json:"charset,omitempty"`
+	// Locale is the language of the data as a BCP 47 tag, e.g. "de-DE".
+	Locale string `json:"locale,omitempty"`
+
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	LastReadAt time.Time `json:"last_read_at"`
+	OwnerId    int       `json:"owner_id,omitempty"`
+	Size       int       `json:"size"`
+	Version    int       `json:"version"`
+	Locked     bool      `json:"locked,omitempty"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	ReadOnly   bool      `json:"read_only,omitempty"`
+	Tags       []string  `json:"tags"`
+	// PublishAt is when a clipboard scheduled for later becomes visible,
+	// and ExpiresAt when it disappears, see Published and Expired.
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Transforms are applied to the data whenever it is written, see
+	// Transform.
+	Transforms []string `json:"transforms,omitempty"`
+
+	// Namespace is the namespace the clipboard belongs to. Clipboards are
+	// only found by requests made in the same namespace.
+	Namespace string `json:"namespace"`
+
+	// Hash is the content hash of unencrypted clipboards, see ContentHash.
+	Hash string `json:"hash,omitempty"`
+	// Refs counts the deduplicated uploads referencing the clipboard. Deleting
+	// it only drops a reference while more than one is left.
+	Refs int `json:"refs,omitempty"`
+
+	// Flavors are alternative representations of the data, see Flavor.
+	// Streamed clipboards have none.
+	Flavors []Flavor `json:"flavors,omitempty"`
+
+	// Metadata describes the data for previews, see Metadata.
+	Metadata *Metadata `json:"metadata,omitempty"`
+
+	// Streamed clipboards keep their data in the blob store under BlobKey.
+	// Their data is only loaded when it is served.
+	Streamed bool   `json:"streamed,omitempty"`
+	BlobKey  string `json:"-"`
+
+	// Trust is computed when the clipboard is served and never stored.
+	Trust TrustLevel `json:"trust,omitempty"`
+	// Quarantine is what the content scanner flagged the data for, such as
+	// "secrets: aws-access-key". Quarantined clipboards are only served to
+	// clients confirming it.
+	Quarantine string `json:"quarantine,omitempty"`
 }
 
 // NewClipboard creates a new clipboard with the given name, data type, and data.
@@ -20,3 +97,37 @@ func NewClipboard(name, dataType, data string) *Clipboard {
 		IsEncrypted: false,
 	}
 }
+
+// Clone returns a copy of the clipboard that shares nothing with it, so
+// either can be changed without affecting the other.
+func (c *Clipboard) Clone() *Clipboard {
+	clone := *c
+	clone.Tags = slices.Clone(c.Tags)
+	clone.Transforms = slices.Clone(c.Transforms)
+	clone.Flavors = slices.Clone(c.Flavors)
+	if c.Metadata != nil {
+		m := *c.Metadata
+		m.Variables = slices.Clone(c.Metadata.Variables)
+		clone.Metadata = &m
+	}
+	if c.PublishAt != nil {
+		t := *c.PublishAt
+		clone.PublishAt = &t
+	}
+	if c.ExpiresAt != nil {
+		t := *c.ExpiresAt
+		clone.ExpiresAt = &t
+	}
+	return &clone
+}
+
+// Published reports whether the clipboard is visible at t, which it is
+// unless scheduled for later.
+func (c *Clipboard) Published(t time.Time) bool {
+	return c.PublishAt == nil || !t.Before(*c.PublishAt)
+}
+
+// Expired reports whether the clipboard expired at or before t.
+func (c *Clipboard) Expired(t time.Time) bool {
+	return c.ExpiresAt != nil && !t.Before(*c.ExpiresAt)
+}
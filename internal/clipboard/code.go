@@ -0,0 +1,96 @@
+package clipboard
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+// codeAdjectives and codeNouns are the words of share codes, chosen to be
+// easy to say, spell and tell apart.
+var codeAdjectives = []string{
+	"amber", "ancient", "autumn", "azure", "blue", "bold", "brave",
+	"breezy", "bright", "brisk", "calm", "candid", "cheerful", "chilly",
+	"clever", "cloudy", "coral", "cosmic", "cozy", "crimson", "crisp",
+	"curly", "dapper", "daring", "dusty", "eager", "early", "electric",
+	"emerald", "fancy", "fluffy", "flying", "frosty", "fuzzy", "gentle",
+	"giant", "gilded", "glad", "golden", "grand", "green", "happy", "hasty",
+	"hidden", "hollow", "humble", "icy", "jade", "jolly", "jumpy", "kind",
+	"lively", "loyal", "lucky", "lunar", "magic", "mellow", "merry",
+	"mighty", "misty", "modern", "mossy", "muddy", "narrow", "neat",
+	"nimble", "noble", "odd", "olive", "orange", "paper", "patient",
+	"plain", "plucky", "polite", "proud", "purple", "quick", "quiet",
+	"rapid", "rare", "red", "rocky", "rosy", "royal", "rustic", "rusty",
+	"salty", "sandy", "scarlet", "shiny", "shy", "silent", "silver",
+	"simple", "sleepy", "slow", "smooth", "snowy", "solar", "sparkly",
+	"speedy", "spicy", "steady", "stormy", "stout", "sunny", "super",
+	"sweet", "swift", "tall", "tame", "tidy", "tiny", "tired", "tropical",
+	"twin", "urban", "velvet", "vivid", "warm", "wavy", "wild", "windy",
+	"wise", "witty", "woolly", "yellow", "young", "zesty",
+}
+
+var codeNouns = []string{
+	"acorn", "anchor", "apple", "arrow", "badger", "banana", "basket",
+	"beacon", "bear", "beaver", "bell", "berry", "biscuit", "bison",
+	"bottle", "breeze", "bridge", "brook", "butter", "cactus", "camel",
+	"candle", "canyon", "castle", "cedar", "cherry", "cloud", "clover",
+	"comet", "compass", "cookie", "copper", "cotton", "coyote", "crane",
+	"cricket", "cupcake", "dolphin", "dragon", "dune", "eagle", "ember",
+	"falcon", "feather", "fern", "finch", "forest", "fox", "galaxy",
+	"garden", "gecko", "geyser", "glacier", "goose", "harbor", "hawk",
+	"hazel", "hedge", "heron", "hill", "honey", "iceberg", "island",
+	"jaguar", "jelly", "kayak", "kettle", "koala", "ladder", "lake",
+	"lantern", "lemon", "lily", "lion", "lizard", "llama", "lotus",
+	"magnet", "mango", "maple", "marble", "meadow", "melon", "meteor",
+	"mirror", "monkey", "moon", "moose", "mountain", "mouse", "muffin",
+	"nectar", "nest", "noodle", "oasis", "ocean", "orchid", "otter", "owl",
+	"paddle", "panda", "parrot", "peach", "pebble", "pepper", "piano",
+	"pillow", "pine", "planet", "pony", "puffin", "pumpkin", "puzzle",
+	"quartz", "rabbit", "rainbow", "raven", "river", "robin", "rocket",
+	"saddle", "sailboat", "salmon", "shadow", "shell", "spark", "sparrow",
+	"spider", "spoon", "squirrel", "star", "stone", "storm", "teapot",
+	"thunder", "tiger", "tomato", "trumpet", "tulip", "turtle", "umbrella",
+	"valley", "violin", "volcano", "waffle", "wagon", "walnut", "whale",
+	"willow", "window", "wolf", "yak", "zebra",
+}
+
+// codeMinNumber and codeMaxNumber bound the number ending share codes.
+const (
+	codeMinNumber = 10
+	codeMaxNumber = 999
+)
+
+var codePattern = regexp.MustCompile(`^[a-z]+-[a-z]+-[0-9]{2,3}$`)
+
+// NewCode returns a random share code such as "paper-tiger-42", which is
+// easier to read aloud than a public id. There are about 20 million codes,
+// so unlike public ids they can be guessed with enough attempts.
+func NewCode() (string, error) {
+	adjective, err := randomIndex(len(codeAdjectives))
+	if err != nil {
+		return "", err
+	}
+	noun, err := randomIndex(len(codeNouns))
+	if err != nil {
+		return "", err
+	}
+	number, err := randomIndex(codeMaxNumber - codeMinNumber + 1)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-%d", codeAdjectives[adjective], codeNouns[noun], codeMinNumber+number), nil
+}
+
+// IsCode reports whether s looks like a share code.
+func IsCode(s string) bool {
+	return codePattern.MatchString(s)
+}
+
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
@@ -0,0 +1,45 @@
+package clipboard
+
+import (
+	"mime"
+	"time"
+)
+
+// Merge modes of updates based on an outdated version of a clipboard.
+const (
+	// MergeThreeWay combines the changes of both updates to text line by
+	// line, and falls back to MergeLastWriterWins if they overlap.
+	MergeThreeWay = "three-way"
+	// MergeLastWriterWins applies the update and keeps the data it replaces
+	// as a Conflict.
+	MergeLastWriterWins = "last-writer-wins"
+)
+
+// ValidMerge reports whether mode is a merge mode.
+func ValidMerge(mode string) bool {
+	return mode == MergeThreeWay || mode == MergeLastWriterWins
+}
+
+// Conflict is a copy of the data of a clipboard that a concurrent update
+// overwrote, kept so the changes are not lost.
+type Conflict struct {
+	Id          int `json:"id"`
+	ClipboardId int `json:"clipboard_id"`
+	// Version is the version of the clipboard the data was replaced at.
+	Version   int       `json:"version"`
+	DataType  string    `json:"type"`
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mergeable reports whether concurrent updates of the clipboard can be
+// merged: its data is unencrypted text stored in the database.
+func (c *Clipboard) Mergeable() bool {
+	return !c.IsEncrypted && !c.Streamed && IsText(c.DataType)
+}
+
+// IsText reports whether data of the given type is text.
+func IsText(dataType string) bool {
+	base, _, err := mime.ParseMediaType(dataType)
+	return err == nil && isTextual(base)
+}
@@ -6,16 +6,35 @@ import (
 	"crypto/rand"
 	// "crypto/sha512"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/crypto/scrypt"
 	// "golang.org/x/crypto/pbkdf2"
+
+	"github.com/copybridge/copybridge-server/internal/stream"
+)
+
+// Encryption scopes. ScopeData only encrypts the data, keeping the name,
+// type and filename readable so clipboards can still be found by them.
+// ScopeAll encrypts these as well, and stores HiddenType as the type.
+const (
+	ScopeData = "data"
+	ScopeAll  = "all"
 )
 
+// HiddenType is the type clipboards encrypted with ScopeAll are stored and
+// listed with.
+const HiddenType = "application/octet-stream"
+
 // HashPassword hashes the given password using bcrypt.
 func HashPassword(password string) (string, error) {
+	start := time.Now()
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashDuration.Since(start, "bcrypt")
 	if err != nil {
 		return "", err
 	}
@@ -25,98 +44,361 @@ func HashPassword(password string) (string, error) {
 
 // Authenticate compares the given password with the stored password hash.
 func (c *Clipboard) Authenticate(password string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(c.PasswordHash), []byte(password)) == nil
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword([]byte(c.PasswordHash), []byte(password))
+	hashDuration.Since(start, "bcrypt")
+	return err == nil
 }
 
-// deriveKey generates a key from the given password and salt using scrypt.
-func deriveKey(password, salt []byte) ([]byte, error) {
-	return scrypt.Key(password, salt, 1<<15, 8, 1, 32)
-	// return pbkdf2.Key(password, salt, 100000, 32, sha512.New), nil
+// aead returns the AES-GCM cipher keyed with the data key of the clipboard,
+// see dataKey.
+func (c *Clipboard) aead(password string) (cipher.AEAD, error) {
+	key, err := c.dataKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
 }
 
-// Encrypt encrypts the clipboard data using the given password with AES-GCM.
-func (c *Clipboard) Encrypt(password string) error {
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// dataKey returns the key the data of the clipboard is encrypted with. The
+// data key is random and stored in WrappedKey, encrypted with a key derived
+// from the password and the salt of the clipboard, so changing the password
+// only wraps the data key again. Clipboards without a salt get one first,
+// used with the currently configured KDF parameters, and a new data key.
+// Clipboards encrypted before data keys were wrapped have no WrappedKey and
+// are encrypted with the derived key itself.
+func (c *Clipboard) dataKey(password string) ([]byte, error) {
 	if c.Salt == "" {
-		salt := make([]byte, 16)
-		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-			return err
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
 		}
-		c.Salt = base64.StdEncoding.EncodeToString(salt)
+		if err := c.wrapKey(password, key); err != nil {
+			return nil, err
+		}
+		return key, nil
 	}
 
+	kek, err := c.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	if c.WrappedKey == "" {
+		return kek, nil
+	}
+
+	aesgcm, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce, data, ok := strings.Cut(c.WrappedKey, ".")
+	if !ok {
+		return nil, errors.New("invalid wrapped key")
+	}
+	key, err := open(aesgcm, data, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// deriveKey derives the key wrapping the data key from the password and the
+// salt of the clipboard.
+func (c *Clipboard) deriveKey(password string) ([]byte, error) {
 	decodedSalt, err := base64.StdEncoding.DecodeString(c.Salt)
 	if err != nil {
+		return nil, err
+	}
+	params, err := ParseKDFParams(c.KDF)
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveKey([]byte(password), decodedSalt, params)
+}
+
+// wrapKey stores the data key in WrappedKey, encrypted with a key derived
+// from the password and a new salt.
+func (c *Clipboard) wrapKey(password string, key []byte) error {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return err
 	}
+	c.Salt = base64.StdEncoding.EncodeToString(salt)
+	c.KDF = currentKDF().String()
 
-	key, err := deriveKey([]byte(password), decodedSalt)
+	kek, err := c.deriveKey(password)
 	if err != nil {
 		return err
 	}
-
-	block, err := aes.NewCipher(key)
+	aesgcm, err := newAEAD(kek)
 	if err != nil {
 		return err
 	}
+	data, nonce, err := seal(aesgcm, string(key))
+	if err != nil {
+		return err
+	}
+
+	c.WrappedKey = nonce + "." + data
+	return nil
+}
 
-	aesgcm, err := cipher.NewGCM(block)
+// ChangePassword makes an encrypted clipboard accessible with a new password
+// instead of the old one, which must be checked with Authenticate first. Its
+// data key is wrapped with the new password, so neither its data nor its
+// flavors and items are encrypted again.
+func (c *Clipboard) ChangePassword(oldPassword, newPassword string) error {
+	key, err := c.dataKey(oldPassword)
 	if err != nil {
 		return err
 	}
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := c.wrapKey(newPassword, key); err != nil {
+		return err
+	}
+
+	c.PasswordHash = hash
+	return nil
+}
 
+// seal encrypts data with a random nonce.
+// It returns the base64-encoded ciphertext and nonce.
+func seal(aesgcm cipher.AEAD, data string) (string, string, error) {
 	nonce := make([]byte, aesgcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return err
+		return "", "", err
+	}
+
+	ciphertext := aesgcm.Seal(nil, nonce, []byte(data), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), base64.StdEncoding.EncodeToString(nonce), nil
+}
+
+// open decrypts base64-encoded ciphertext sealed with the given nonce.
+func open(aesgcm cipher.AEAD, data, nonce string) (string, error) {
+	decodedNonce, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil {
+		return "", err
 	}
 
-	c.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	decodedCiphertext, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := aesgcm.Open(nil, decodedNonce, decodedCiphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// Encrypt encrypts the clipboard data and its flavors using the given
+// password with AES-GCM.
+func (c *Clipboard) Encrypt(password string) error {
+	aesgcm, err := c.aead(password)
+	if err != nil {
+		return err
+	}
 
-	ciphertext := aesgcm.Seal(nil, nonce, []byte(c.Data), nil)
-	c.Data = base64.StdEncoding.EncodeToString(ciphertext)
+	c.Data, c.Nonce, err = seal(aesgcm, c.Data)
+	if err != nil {
+		return err
+	}
+	for i := range c.Flavors {
+		f := &c.Flavors[i]
+		f.Data, f.Nonce, err = seal(aesgcm, f.Data)
+		if err != nil {
+			return err
+		}
+	}
+	if c.EncryptionScope == ScopeAll {
+		if err := c.sealFields(aesgcm); err != nil {
+			return err
+		}
+	}
 	c.IsEncrypted = true
 
 	return nil
 }
 
-// Decrypt decrypts the clipboard data using the given password with AES-GCM.
+// Decrypt decrypts the clipboard data and its flavors using the given
+// password with AES-GCM.
 func (c *Clipboard) Decrypt(password string) error {
-	decodedSalt, err := base64.StdEncoding.DecodeString(c.Salt)
+	aesgcm, err := c.aead(password)
 	if err != nil {
 		return err
 	}
 
-	key, err := deriveKey([]byte(password), decodedSalt)
+	c.Data, err = open(aesgcm, c.Data, c.Nonce)
 	if err != nil {
 		return err
 	}
+	for i := range c.Flavors {
+		f := &c.Flavors[i]
+		f.Data, err = open(aesgcm, f.Data, f.Nonce)
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.openFields(aesgcm); err != nil {
+		return err
+	}
+	c.IsEncrypted = false
 
-	block, err := aes.NewCipher(key)
+	return nil
+}
+
+// sealedFields are the fields of a clipboard ScopeAll encrypts besides its
+// data.
+type sealedFields struct {
+	Name               string `json:"name"`
+	DataType           string `json:"type"`
+	Filename           string `json:"filename,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+	Charset            string `json:"charset,omitempty"`
+	Locale             string `json:"locale,omitempty"`
+}
+
+// sealFields encrypts the name, type, filename, disposition, charset and
+// locale of the clipboard into SealedFields, replacing them with placeholders.
+func (c *Clipboard) sealFields(aesgcm cipher.AEAD) error {
+	fields, err := json.Marshal(sealedFields{c.Name, c.DataType, c.Filename, c.ContentDisposition, c.Charset, c.Locale})
+	if err != nil {
+		return err
+	}
+	data, nonce, err := seal(aesgcm, string(fields))
 	if err != nil {
 		return err
 	}
 
-	aesgcm, err := cipher.NewGCM(block)
+	c.SealedFields = nonce + "." + data
+	c.Name, c.DataType, c.Filename, c.ContentDisposition, c.Charset, c.Locale = "", HiddenType, "", "", "", ""
+	return nil
+}
+
+// openFields restores the fields sealed by sealFields, if any.
+func (c *Clipboard) openFields(aesgcm cipher.AEAD) error {
+	if c.SealedFields == "" {
+		return nil
+	}
+	nonce, data, ok := strings.Cut(c.SealedFields, ".")
+	if !ok {
+		return errors.New("invalid sealed fields")
+	}
+	plaintext, err := open(aesgcm, data, nonce)
 	if err != nil {
 		return err
 	}
+	var fields sealedFields
+	if err := json.Unmarshal([]byte(plaintext), &fields); err != nil {
+		return err
+	}
 
-	decodedNonce, err := base64.StdEncoding.DecodeString(c.Nonce)
+	c.Name, c.DataType, c.Filename, c.ContentDisposition = fields.Name, fields.DataType, fields.Filename, fields.ContentDisposition
+	c.Charset, c.Locale = fields.Charset, fields.Locale
+	c.SealedFields = ""
+	return nil
+}
+
+// OpenFields decrypts the name, type, filename and disposition of a
+// clipboard encrypted with ScopeAll, leaving its data encrypted, as for
+// streamed clipboards whose data is decrypted as it is read. Decrypt opens
+// them too.
+func (c *Clipboard) OpenFields(password string) error {
+	if c.SealedFields == "" {
+		return nil
+	}
+	aesgcm, err := c.aead(password)
 	if err != nil {
 		return err
 	}
+	return c.openFields(aesgcm)
+}
 
-	decodedCiphertext, err := base64.StdEncoding.DecodeString(c.Data)
+// EncryptItems encrypts the data of stack items with the key of the clipboard.
+func (c *Clipboard) EncryptItems(password string, items ...*Item) error {
+	aesgcm, err := c.aead(password)
 	if err != nil {
 		return err
 	}
 
-	plaintext, err := aesgcm.Open(nil, decodedNonce, decodedCiphertext, nil)
+	for _, item := range items {
+		item.Data, item.Nonce, err = seal(aesgcm, item.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecryptItems decrypts the data of stack items with the key of the clipboard.
+func (c *Clipboard) DecryptItems(password string, items ...*Item) error {
+	aesgcm, err := c.aead(password)
 	if err != nil {
 		return err
 	}
 
-	c.Data = string(plaintext)
-	c.IsEncrypted = false
+	for _, item := range items {
+		item.Data, err = open(aesgcm, item.Data, item.Nonce)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// EncryptStream returns a reader of data encrypted with the given password,
+// for clipboards whose data is streamed rather than held in memory.
+// The nonce of the clipboard is replaced with the prefix of the stream nonces.
+func (c *Clipboard) EncryptStream(password string, data io.Reader) (io.Reader, error) {
+	aesgcm, err := c.aead(password)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := stream.NewPrefix()
+	if err != nil {
+		return nil, err
+	}
+	c.Nonce = base64.StdEncoding.EncodeToString(prefix)
+	if c.EncryptionScope == ScopeAll {
+		if err := c.sealFields(aesgcm); err != nil {
+			return nil, err
+		}
+	}
+	c.IsEncrypted = true
+
+	return stream.EncryptReader(aesgcm, prefix, data), nil
+}
+
+// DecryptStream returns a reader of streamed data decrypted with the given
+// password.
+func (c *Clipboard) DecryptStream(password string, data io.Reader) (io.Reader, error) {
+	aesgcm, err := c.aead(password)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := base64.StdEncoding.DecodeString(c.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.DecryptReader(aesgcm, prefix, data), nil
+}
@@ -0,0 +1,65 @@
+package clipboard
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// E2EType is the type of clipboards encrypted end to end by their clients.
+// The server stores and serves their envelope as it is; only clients
+// knowing the password can decrypt it.
+const E2EType = "application/vnd.copybridge.e2e+json"
+
+// E2E parameters clients encrypt with. The key is derived from the password
+// with PBKDF2-SHA256 and the data encrypted with AES-256-GCM, which the
+// WebCrypto API of browsers provides.
+const (
+	E2EVersion       = 1
+	E2EKDF           = "PBKDF2-SHA256"
+	E2EMinIterations = 100000
+)
+
+// E2EEnvelope is the JSON data of a clipboard encrypted end to end. Salt,
+// IV and Data are base64 encoded.
+type E2EEnvelope struct {
+	Version    int    `json:"v"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	IV         string `json:"iv"`
+	Data       string `json:"data"`
+}
+
+// CheckE2E returns an error if data is not a well-formed E2E envelope. The
+// ciphertext itself cannot be checked without the password.
+func CheckE2E(data string) error {
+	var e E2EEnvelope
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return errors.New("end-to-end encrypted data must be a JSON envelope")
+	}
+
+	switch {
+	case e.Version != E2EVersion:
+		return fmt.Errorf("unsupported envelope version %d", e.Version)
+	case e.KDF != E2EKDF:
+		return fmt.Errorf("key derivation must be %s", E2EKDF)
+	case e.Iterations < E2EMinIterations:
+		return fmt.Errorf("key derivation must use at least %d iterations", E2EMinIterations)
+	}
+
+	for _, f := range []struct {
+		name, value string
+		min         int
+	}{{"salt", e.Salt, 16}, {"iv", e.IV, 12}, {"data", e.Data, 16}} {
+		b, err := base64.StdEncoding.DecodeString(f.value)
+		if err != nil {
+			return fmt.Errorf("envelope %s must be base64", f.name)
+		}
+		if len(b) < f.min {
+			return fmt.Errorf("envelope %s must be at least %d bytes", f.name, f.min)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFilenameLength is the maximum length of a filename in bytes.
+const MaxFilenameLength = 255
+
+// Dispositions a clipboard can be served with, see DispositionHeader.
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+)
+
+// CheckFilename returns an error if name cannot be the filename of a
+// clipboard: it must be a single path element without control characters.
+// The empty name stands for no filename.
+func CheckFilename(name string) error {
+	switch {
+	case name == "":
+		return nil
+	case len(name) > MaxFilenameLength:
+		return fmt.Errorf("filename must be at most %d bytes", MaxFilenameLength)
+	case !utf8.ValidString(name) || strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return errors.New("filename must be valid UTF-8 without control characters")
+	case strings.ContainsAny(name, `/\`), name == ".", name == "..":
+		return errors.New("filename must not be a path")
+	}
+	return nil
+}
+
+// CheckDisposition returns an error if disposition is neither inline,
+// attachment nor empty.
+func CheckDisposition(disposition string) error {
+	if disposition != "" && disposition != DispositionInline && disposition != DispositionAttachment {
+		return fmt.Errorf("content_disposition must be %s or %s", DispositionInline, DispositionAttachment)
+	}
+	return nil
+}
+
+// DispositionHeader returns the Content-Disposition header the data of the
+// clipboard is served with, or "" if it has neither a filename nor a
+// disposition. Clipboards with a filename are downloaded as attachments
+// unless they ask to be shown inline.
+func (c *Clipboard) DispositionHeader() string {
+	disposition := c.ContentDisposition
+	if disposition == "" {
+		if c.Filename == "" {
+			return ""
+		}
+		disposition = DispositionAttachment
+	}
+	var params map[string]string
+	if c.Filename != "" {
+		params = map[string]string{"filename": c.Filename}
+	}
+	return mime.FormatMediaType(disposition, params)
+}
+
+// ActiveType reports whether browsers may run scripts in data of a type when
+// showing it, as with HTML, SVG and other XML, and JavaScript. Types that
+// cannot be parsed are assumed to be active.
+func ActiveType(dataType string) bool {
+	base, _, err := mime.ParseMediaType(dataType)
+	if err != nil {
+		return true
+	}
+	switch base {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml", "text/xsl",
+		"text/javascript", "application/javascript", "application/x-javascript", "text/ecmascript", "application/ecmascript":
+		return true
+	}
+	return strings.HasSuffix(base, "+xml")
+}
+
+// ParseDisposition parses a Content-Disposition header sent along with the
+// data of a clipboard into its disposition and filename, which may be empty.
+// Filenames sent as paths are reduced to their last element.
+func ParseDisposition(header string) (string, string, error) {
+	disposition, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", "", errors.New("invalid Content-Disposition header")
+	}
+	if err := CheckDisposition(disposition); err != nil {
+		return "", "", errors.New("Content-Disposition must be " + DispositionInline + " or " + DispositionAttachment)
+	}
+	filename := params["filename"]
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	if err := CheckFilename(filename); err != nil {
+		return "", "", err
+	}
+	return disposition, filename, nil
+}
+
+// maxBaseNameLength is the maximum length in bytes of a name derived from
+// the name of a clipboard, leaving room for extensions and suffixes.
+const maxBaseNameLength = 200
+
+// extensions maps common clipboard types to the extension of their files,
+// for types mime.ExtensionsByType knows none or several extensions of.
+var extensions = map[string]string{
+	"text/plain":               ".txt",
+	"text/html":                ".html",
+	"text/markdown":            ".md",
+	"text/csv":                 ".csv",
+	"text/css":                 ".css",
+	"text/javascript":          ".js",
+	"text/xml":                 ".xml",
+	"text/rtf":                 ".rtf",
+	"text/uri-list":            ".uri",
+	"text/calendar":            ".ics",
+	"text/vcard":               ".vcf",
+	"application/json":         ".json",
+	"application/xml":          ".xml",
+	"application/rtf":          ".rtf",
+	"application/pdf":          ".pdf",
+	"application/zip":          ".zip",
+	"application/octet-stream": ".bin",
+	"image/png":                ".png",
+	"image/jpeg":               ".jpg",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/svg+xml":            ".svg",
+}
+
+// Extension returns the filename extension, with its leading dot, for data
+// of type dataType. Unknown text types are .txt and other unknown types .bin.
+func Extension(dataType string) string {
+	mediaType, _, err := mime.ParseMediaType(dataType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return ".txt"
+	}
+	return ".bin"
+}
+
+// BaseName returns a name for files of the clipboard without extension: its
+// name made safe as a filename, or "clipboard-<id>" if it has none.
+func (c *Clipboard) BaseName() string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, strings.ToValidUTF8(c.Name, "_"))
+	for len(name) > maxBaseNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return fmt.Sprintf("clipboard-%d", c.Id)
+	}
+	return name
+}
+
+// DataFilename returns the name of a file holding the data of the
+// clipboard: its filename, or else its base name with the extension of its
+// type.
+func (c *Clipboard) DataFilename() string {
+	if c.Filename != "" {
+		return c.Filename
+	}
+	return c.BaseName() + Extension(c.DataType)
+}
+
+// FlavorFilename returns the name of a file holding a flavor of the
+// clipboard: the data filename with the extension of the flavor type in
+// place of its own, if it has one.
+func (c *Clipboard) FlavorFilename(f Flavor) string {
+	name := c.DataFilename()
+	if stem := strings.TrimSuffix(name, path.Ext(name)); stem != "" {
+		name = stem
+	}
+	return name + Extension(f.DataType)
+}
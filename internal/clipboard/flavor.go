@@ -0,0 +1,135 @@
+package clipboard
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// MaxFlavors is the maximum number of flavors of a clipboard.
+const MaxFlavors = 8
+
+// Flavor is an alternative representation of the data of a clipboard, such
+// as the HTML or RTF of rich text whose plain text is the clipboard data.
+// The receiving device pastes the flavor it supports best.
+type Flavor struct {
+	DataType string `json:"type"`
+	Data     string `json:"data"`
+	Nonce    string `json:"-"`
+}
+
+// CheckFlavors validates that the flavors of a clipboard have distinct
+// types, different from the type of the clipboard itself.
+func (c *Clipboard) CheckFlavors() error {
+	if len(c.Flavors) > MaxFlavors {
+		return fmt.Errorf("at most %d flavors allowed", MaxFlavors)
+	}
+
+	seen := map[string]bool{baseType(c.DataType): true}
+	for _, f := range c.Flavors {
+		base := baseType(f.DataType)
+		if seen[base] {
+			return fmt.Errorf("duplicate flavor %q", f.DataType)
+		}
+		seen[base] = true
+	}
+
+	return nil
+}
+
+// DataSize returns the size of the data of a clipboard and its flavors, as
+// stored.
+func (c *Clipboard) DataSize() int {
+	n := len(c.Data)
+	for _, f := range c.Flavors {
+		n += len(f.Data)
+	}
+	return n
+}
+
+// Types returns the type of the clipboard followed by the types of its
+// flavors. Clipboards without a type are served as application/octet-stream.
+func (c *Clipboard) Types() []string {
+	types := make([]string, 0, 1+len(c.Flavors))
+	if c.DataType == "" {
+		types = append(types, "application/octet-stream")
+	} else {
+		types = append(types, c.DataType)
+	}
+	for _, f := range c.Flavors {
+		types = append(types, f.DataType)
+	}
+	return types
+}
+
+// Negotiate picks the type the client prefers according to an Accept header
+// and returns its index in types, or -1 if none is acceptable. Earlier types
+// win ties. An empty header accepts anything.
+func Negotiate(accept string, types []string) int {
+	if strings.TrimSpace(accept) == "" {
+		return 0
+	}
+
+	type mediaRange struct {
+		major, minor string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		base, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		major, minor, ok := strings.Cut(base, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{major, minor, q})
+	}
+
+	best, bestQ := -1, 0.0
+	for i, t := range types {
+		major, minor, _ := strings.Cut(baseType(t), "/")
+
+		// The most specific matching range decides the quality of a type.
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			var s int
+			switch {
+			case r.major == major && r.minor == minor:
+				s = 2
+			case r.major == major && r.minor == "*":
+				s = 1
+			case r.major == "*" && r.minor == "*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+
+	return best
+}
+
+// baseType returns the lowercase media type without parameters.
+func baseType(dataType string) string {
+	base, _, err := mime.ParseMediaType(dataType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dataType))
+	}
+	return base
+}
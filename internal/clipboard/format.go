@@ -0,0 +1,30 @@
+package clipboard
+
+import (
+	"fmt"
+
+	"github.com/copybridge/copybridge-server/internal/logging"
+)
+
+// Format prints the clipboard without its data, password hash, salt and
+// nonce, so logging it with %v or %+v cannot leak them. The data of the
+// clipboard and its flavors is replaced by logging.Data.
+func (c Clipboard) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "{Id:%d Name:%q Type:%q Data:%s Encrypted:%t Size:%d Version:%d Owner:%d Namespace:%q Flavors:%v}",
+		c.Id, c.Name, c.DataType, logging.Data(c.Data), c.IsEncrypted, c.Size, c.Version, c.OwnerId, c.Namespace, c.Flavors)
+}
+
+// Format prints the flavor with its data replaced by logging.Data.
+func (fl Flavor) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "{Type:%q Data:%s}", fl.DataType, logging.Data(fl.Data))
+}
+
+// Format prints the stack item with its data replaced by logging.Data.
+func (i Item) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "{Id:%d Clipboard:%d Type:%q Data:%s Size:%d}", i.Id, i.ClipboardId, i.DataType, logging.Data(i.Data), i.Size)
+}
+
+// Format prints the conflict copy with its data replaced by logging.Data.
+func (c Conflict) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "{Id:%d Clipboard:%d Version:%d Type:%q Data:%s}", c.Id, c.ClipboardId, c.Version, c.DataType, logging.Data(c.Data))
+}
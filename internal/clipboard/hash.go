@@ -0,0 +1,36 @@
+package clipboard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+)
+
+// ContentHash returns the hex-encoded SHA-256 hash of the type, data and
+// flavors of the clipboard, identifying identical payloads.
+// Encrypted and streamed clipboards have no content hash, since their
+// plaintext is not at hand.
+func (c *Clipboard) ContentHash() string {
+	if c.IsEncrypted || c.Streamed {
+		return ""
+	}
+
+	h := sha256.New()
+	writeField(h, c.DataType)
+	writeField(h, c.Data)
+	for _, f := range c.Flavors {
+		writeField(h, f.DataType)
+		writeField(h, f.Data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes a length-prefixed field, so that fields cannot run into
+// each other.
+func writeField(h hash.Hash, field string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(field)))
+	h.Write(n[:])
+	h.Write([]byte(field))
+}
@@ -0,0 +1,19 @@
+package clipboard
+
+import "time"
+
+// Item is an entry of a clipboard stack.
+// Clipboards hold an ordered list of items, newest on top, in addition to
+// their own data, like the ring of a clipboard manager.
+type Item struct {
+	Id          int       `json:"id"`
+	ClipboardId int       `json:"clipboard_id"`
+	DataType    string    `json:"type"`
+	Data        string    `json:"data"`
+	Nonce       string    `json:"-"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Trust is computed when the item is served and never stored.
+	Trust TrustLevel `json:"trust,omitempty"`
+}
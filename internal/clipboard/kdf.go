@@ -0,0 +1,233 @@
+package clipboard
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/copybridge/copybridge-server/internal/metrics"
+)
+
+// hashDuration measures password hashing, which is slow by design and
+// bounds how many protected clipboards the server opens per second.
+var hashDuration = metrics.NewHistogram("copybridge_password_hash_duration_seconds",
+	"Time spent hashing and verifying passwords with bcrypt and deriving keys with scrypt.", metrics.DurationBuckets, "algorithm")
+
+// KDFParams are the scrypt cost parameters the key of a clipboard is derived
+// with. They are stored with every encrypted clipboard, so changing them only
+// affects new clipboards.
+type KDFParams struct {
+	// LogN is the base 2 logarithm of the CPU/memory cost N.
+	LogN int
+	R    int
+	P    int
+}
+
+// LegacyKDF are the parameters of clipboards encrypted before they were
+// configurable, and the default for new ones.
+var LegacyKDF = KDFParams{LogN: 15, R: 8, P: 1}
+
+// String encodes the parameters as stored with a clipboard.
+func (p KDFParams) String() string {
+	return fmt.Sprintf("scrypt:%d:%d:%d", p.LogN, p.R, p.P)
+}
+
+// ParseKDFParams decodes parameters stored with a clipboard. Clipboards
+// without parameters use LegacyKDF.
+func ParseKDFParams(s string) (KDFParams, error) {
+	if s == "" {
+		return LegacyKDF, nil
+	}
+
+	var p KDFParams
+	if _, err := fmt.Sscanf(s, "scrypt:%d:%d:%d", &p.LogN, &p.R, &p.P); err != nil {
+		return KDFParams{}, fmt.Errorf("invalid KDF parameters %q", s)
+	}
+	return p, p.Validate()
+}
+
+// Validate checks that scrypt accepts the parameters and that they are not
+// too weak to be useful or too costly to serve.
+func (p KDFParams) Validate() error {
+	switch {
+	case p.LogN < 10 || p.LogN > 20:
+		return errors.New("scrypt log N must be between 10 and 20")
+	case p.R < 1 || p.P < 1:
+		return errors.New("scrypt r and p must be positive")
+	case p.R*p.P >= 1<<30:
+		return errors.New("scrypt r * p must be less than 2^30")
+	}
+	return nil
+}
+
+// KDFConfig controls how keys are derived from clipboard passwords.
+type KDFConfig struct {
+	// Params are used for newly encrypted clipboards.
+	Params KDFParams
+	// MaxConcurrent bounds the key derivations running at once, so a flood
+	// of requests to encrypted clipboards cannot exhaust CPU and memory.
+	// Zero means unbounded.
+	MaxConcurrent int
+	// CacheSize is the number of derived keys kept in memory for CacheTTL,
+	// so clients reading the same clipboard repeatedly do not pay for the
+	// derivation every time. Zero disables the cache.
+	CacheSize int
+	CacheTTL  time.Duration
+}
+
+var kdf = struct {
+	sync.RWMutex
+	params KDFParams
+	sem    chan struct{}
+	cache  *keyCache
+}{params: LegacyKDF}
+
+// ConfigureKDF applies a key derivation configuration. It is meant to be
+// called once at startup.
+func ConfigureKDF(cfg KDFConfig) error {
+	if err := cfg.Params.Validate(); err != nil {
+		return err
+	}
+
+	kdf.Lock()
+	defer kdf.Unlock()
+	kdf.params = cfg.Params
+	kdf.sem = nil
+	if cfg.MaxConcurrent > 0 {
+		kdf.sem = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	kdf.cache = nil
+	if cfg.CacheSize > 0 && cfg.CacheTTL > 0 {
+		cache, err := newKeyCache(cfg.CacheSize, cfg.CacheTTL)
+		if err != nil {
+			return err
+		}
+		kdf.cache = cache
+	}
+	return nil
+}
+
+// currentKDF returns the parameters new clipboards are encrypted with.
+func currentKDF() KDFParams {
+	kdf.RLock()
+	defer kdf.RUnlock()
+	return kdf.params
+}
+
+// deriveKey generates a key from the given password and salt using scrypt
+// with the given parameters, waiting for a free slot if too many
+// derivations are running.
+func deriveKey(password, salt []byte, params KDFParams) ([]byte, error) {
+	kdf.RLock()
+	sem, cache := kdf.sem, kdf.cache
+	kdf.RUnlock()
+
+	var id [sha256.Size]byte
+	if cache != nil {
+		id = cache.id(password, salt, params)
+		if key, ok := cache.get(id); ok {
+			return key, nil
+		}
+	}
+
+	if sem != nil {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+	}
+
+	start := time.Now()
+	key, err := scrypt.Key(password, salt, 1<<params.LogN, params.R, params.P, 32)
+	hashDuration.Since(start, "scrypt")
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.put(id, key)
+	}
+	return key, nil
+}
+
+// keyCache is an LRU cache of derived keys that expire after a while.
+// Entries are identified by an HMAC of the password, salt and parameters
+// under a random secret, so passwords are never kept in memory.
+type keyCache struct {
+	secret []byte
+	size   int
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type cachedKey struct {
+	id      [sha256.Size]byte
+	key     []byte
+	expires time.Time
+}
+
+func newKeyCache(size int, ttl time.Duration) (*keyCache, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &keyCache{
+		secret:  secret,
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element),
+	}, nil
+}
+
+func (c *keyCache) id(password, salt []byte, params KDFParams) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, c.secret)
+	fmt.Fprintf(mac, "%s\x00%d\x00", params, len(salt))
+	mac.Write(salt)
+	mac.Write(password)
+
+	var id [sha256.Size]byte
+	copy(id[:], mac.Sum(nil))
+	return id
+}
+
+func (c *keyCache) get(id [sha256.Size]byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cachedKey)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.key, true
+}
+
+func (c *keyCache) put(id [sha256.Size]byte, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[id] = c.order.PushFront(&cachedKey{id: id, key: key, expires: time.Now().Add(c.ttl)})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedKey).id)
+	}
+}
@@ -0,0 +1,20 @@
+package clipboard
+
+// Metadata describes the data of a clipboard, so clients listing clipboards
+// can render a preview without downloading the data. It is extracted when
+// the data is written, see the preview package. Encrypted and streamed
+// clipboards have none, since their plaintext is not at hand.
+type Metadata struct {
+	// Lines counts the lines of text data.
+	Lines int `json:"lines,omitempty"`
+	// Language is the detected language of code, such as "go" or "json".
+	Language string `json:"language,omitempty"`
+	// Title is the title of the page a link points to. It is fetched in the
+	// background, if enabled, and arrives after the clipboard is written.
+	Title string `json:"title,omitempty"`
+	// Width and Height are the dimensions of images in pixels.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Variables are the variables a template asks for, see TemplateType.
+	Variables []string `json:"variables,omitempty"`
+}
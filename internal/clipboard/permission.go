@@ -0,0 +1,38 @@
+package clipboard
+
+import "time"
+
+// Roles a user can have on an owned clipboard.
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+	RoleOwner = "owner"
+)
+
+// Permission grants a user other than the owner access to a clipboard.
+type Permission struct {
+	ClipboardId int       `json:"clipboard_id"`
+	UserId      int       `json:"user_id"`
+	User        string    `json:"user"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ValidRole reports whether role can be granted.
+func ValidRole(role string) bool {
+	return role == RoleRead || role == RoleWrite
+}
+
+// RoleAllows reports whether a user with the given role may perform action.
+// Readers may read, writers may also update, and only owners may delete,
+// audit and share.
+func RoleAllows(role, action string) bool {
+	switch action {
+	case ActionRead:
+		return role == RoleRead || role == RoleWrite || role == RoleOwner
+	case ActionUpdate:
+		return role == RoleWrite || role == RoleOwner
+	default:
+		return role == RoleOwner
+	}
+}
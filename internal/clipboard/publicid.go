@@ -0,0 +1,29 @@
+package clipboard
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+// PublicIdLength is the length of public clipboard ids.
+const PublicIdLength = 26
+
+// publicIdEncoding writes public ids in lowercase base32, so they never
+// look like the numeric ids they stand in for.
+var publicIdEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NewPublicId returns a random, unguessable public clipboard id. Unlike the
+// sequential numeric ids, public ids cannot be enumerated.
+func NewPublicId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return publicIdEncoding.EncodeToString(b), nil
+}
+
+// IsPublicId reports whether an id looks like a public clipboard id.
+func IsPublicId(id string) bool {
+	return len(id) == PublicIdLength && strings.Trim(id, "abcdefghijklmnopqrstuvwxyz234567") == ""
+}
@@ -0,0 +1,16 @@
+package clipboard
+
+import "time"
+
+// Reservation holds a clipboard id, and optionally a name, for a client that
+// creates the clipboard in several steps, such as a chunked upload. Only the
+// user who reserved the id may create a clipboard under it, and only with
+// the reserved name.
+type Reservation struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	OwnerId   int       `json:"-"`
+	Namespace string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
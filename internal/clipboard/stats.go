@@ -0,0 +1,33 @@
+package clipboard
+
+import "time"
+
+// Stats summarizes how a clipboard is used, from its access log and the
+// sizes it had, e.g. to see whether a shared snippet is read at all.
+type Stats struct {
+	// Reads counts the successful reads, and LastReadAt is when the last
+	// one happened. It is nil if the clipboard was never read.
+	Reads      int        `json:"reads"`
+	LastReadAt *time.Time `json:"last_read_at,omitempty"`
+	// Devices counts the accesses per device, most active first.
+	Devices []DeviceStats `json:"devices"`
+	// Sizes are the recent sizes of the clipboard, oldest first.
+	Sizes []SizeEntry `json:"sizes"`
+}
+
+// DeviceStats counts the successful reads and writes of a clipboard by a
+// device, as named in the access log.
+type DeviceStats struct {
+	Device       string    `json:"device"`
+	Reads        int       `json:"reads"`
+	Writes       int       `json:"writes"`
+	LastAccessAt time.Time `json:"last_access_at"`
+}
+
+// SizeEntry records the size of a clipboard at a version, which is the size
+// of the ciphertext for encrypted clipboards like Size.
+type SizeEntry struct {
+	Version    int       `json:"version"`
+	Size       int64     `json:"size"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
@@ -0,0 +1,19 @@
+package clipboard
+
+import "time"
+
+// Subscription asks for a notification on a channel such as ntfy, Gotify or
+// email whenever a clipboard is updated.
+type Subscription struct {
+	Id          int    `json:"id"`
+	ClipboardId int    `json:"clipboard_id"`
+	UserId      int    `json:"user_id"`
+	Channel     string `json:"channel"`
+	// Target is where the channel delivers to: an ntfy topic, a Gotify
+	// application token or an email address.
+	Target string `json:"target"`
+	// IncludeData puts the data of unencrypted text clipboards into the
+	// notification, e.g. to read a one-time code off the lock screen.
+	IncludeData bool      `json:"include_data"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,28 @@
+package clipboard
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,63}$`)
+
+// NormalizeTags lowercases and deduplicates tags, and validates that they
+// only contain letters, digits and the characters _ . : - (at most 64).
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !tagPattern.MatchString(t) {
+			return nil, fmt.Errorf("invalid tag %q", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+
+	return normalized, nil
+}
@@ -0,0 +1,94 @@
+package clipboard
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TemplateType is the data type of template clipboards. Their data holds
+// placeholders such as {{name}} or {{name|default}}, filled in by
+// RenderTemplate.
+const TemplateType = "text/x-template"
+
+// templatePlaceholder matches the placeholders of templates. Braces that do
+// not form a placeholder are kept as they are.
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*(?:\|([^{}]*))?\}\}`)
+
+// templateBuiltins are the variables every template can use without
+// passing them, derived from the time of rendering. Passed variables of the
+// same name take precedence.
+var templateBuiltins = map[string]func(time.Time) string{
+	"date":     func(t time.Time) string { return t.Format(time.DateOnly) },
+	"time":     func(t time.Time) string { return t.Format("15:04") },
+	"datetime": func(t time.Time) string { return t.Format(time.RFC3339) },
+	"weekday":  func(t time.Time) string { return t.Weekday().String() },
+	"year":     func(t time.Time) string { return t.Format("2006") },
+}
+
+// MissingVariablesError is returned by RenderTemplate for placeholders
+// without a value or default.
+type MissingVariablesError struct {
+	Names []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	return fmt.Sprintf("missing template variables: %s", strings.Join(e.Names, ", "))
+}
+
+// IsTemplate reports whether a data type is TemplateType, ignoring its
+// parameters.
+func IsTemplate(dataType string) bool {
+	base, _, err := mime.ParseMediaType(dataType)
+	return err == nil && base == TemplateType
+}
+
+// TemplateVariables returns the names of the variables a template uses, in
+// the order they first appear, without the built-in ones.
+func TemplateVariables(data string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(data, -1) {
+		name := m[1]
+		if _, ok := templateBuiltins[name]; ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// RenderTemplate replaces the placeholders of a template with the given
+// variables, the built-in variables at now or the defaults of the
+// placeholders, in this order. It returns a *MissingVariablesError naming
+// every placeholder left without a value.
+func RenderTemplate(data string, vars map[string]string, now time.Time) (string, error) {
+	var missing []string
+	seen := make(map[string]bool)
+	rendered := templatePlaceholder.ReplaceAllStringFunc(data, func(placeholder string) string {
+		m := templatePlaceholder.FindStringSubmatch(placeholder)
+		name, def, hasDefault := m[1], m[2], strings.Contains(placeholder, "|")
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		if builtin, ok := templateBuiltins[name]; ok {
+			return builtin(now)
+		}
+		if hasDefault {
+			return def
+		}
+		if !seen[name] {
+			seen[name] = true
+			missing = append(missing, name)
+		}
+		return placeholder
+	})
+
+	if len(missing) > 0 {
+		return "", &MissingVariablesError{Names: missing}
+	}
+	return rendered, nil
+}
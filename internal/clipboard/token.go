@@ -0,0 +1,104 @@
+package clipboard
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenPrefix starts every clipboard token, telling them apart from API keys
+// and session access tokens.
+const TokenPrefix = "cbt_"
+
+// Scopes a clipboard token can grant.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+)
+
+// Token grants access to a single clipboard without the credentials of its
+// owner, e.g. to a script on a kiosk machine. Only a hash of the secret is
+// stored; the secret is handed out once, when the token is created.
+type Token struct {
+	Id          string     `json:"id"`
+	ClipboardId int        `json:"clipboard_id"`
+	Name        string     `json:"name,omitempty"`
+	Scopes      []string   `json:"scopes"`
+	Hash        string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	// MaxViews limits how often the token may read the clipboard, 0 for
+	// no limit. Views counts the reads so far.
+	MaxViews int `json:"max_views,omitempty"`
+	Views    int `json:"views"`
+}
+
+// NewTokenSecret returns a random token id and secret.
+func NewTokenSecret() (id, secret string, err error) {
+	b := make([]byte, 40)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(b[:8]), TokenPrefix + hex.EncodeToString(b[8:]), nil
+}
+
+// IsToken reports whether a credential looks like a clipboard token.
+func IsToken(credential string) bool {
+	return strings.HasPrefix(credential, TokenPrefix)
+}
+
+// NormalizeScopes lowercases and deduplicates scopes and checks that they
+// are known. At least one scope is required.
+func NormalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope != ScopeRead && scope != ScopeWrite && scope != ScopeDelete {
+			return nil, fmt.Errorf("invalid scope %q, must be read, write or delete", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+
+	return normalized, nil
+}
+
+// Active reports whether the token has neither expired at the given time
+// nor used up its views.
+func (t *Token) Active(now time.Time) bool {
+	return (t.ExpiresAt == nil || now.Before(*t.ExpiresAt)) && (t.MaxViews == 0 || t.Views < t.MaxViews)
+}
+
+// Allows reports whether the token may perform action on its clipboard.
+// The read scope allows reading, write allows updating and delete allows
+// deleting. Auditing and sharing are left to the owner.
+func (t *Token) Allows(action string) bool {
+	var scope string
+	switch action {
+	case ActionRead:
+		scope = ScopeRead
+	case ActionUpdate:
+		scope = ScopeWrite
+	case ActionDelete:
+		scope = ScopeDelete
+	default:
+		return false
+	}
+
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
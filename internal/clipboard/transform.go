@@ -0,0 +1,184 @@
+package clipboard
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Transforms clean up textual clipboard data on the server, so clients do
+// not have to.
+const (
+	// TransformTrim removes leading and trailing whitespace.
+	TransformTrim = "trim"
+	// TransformNewlines converts CRLF and CR line endings to LF.
+	TransformNewlines = "newlines"
+	// TransformStripTracking removes tracking parameters such as utm_source
+	// from URLs.
+	TransformStripTracking = "strip_tracking"
+	// TransformTabsToSpaces expands tabs to tab stops every tabWidth columns.
+	TransformTabsToSpaces = "tabs_to_spaces"
+	// TransformSpacesToTabs turns indentation of tabWidth spaces into tabs.
+	TransformSpacesToTabs = "spaces_to_tabs"
+)
+
+// tabWidth is the width of tab stops of the tab and space transforms.
+const tabWidth = 4
+
+var (
+	urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+	// trackingPrefixes and trackingParams match the query parameters
+	// removed by TransformStripTracking.
+	trackingPrefixes = []string{"utm_", "mtm_"}
+	trackingParams   = map[string]bool{
+		"fbclid": true, "gclid": true, "dclid": true, "gbraid": true, "wbraid": true,
+		"msclkid": true, "mc_cid": true, "mc_eid": true, "igshid": true, "yclid": true,
+		"_hsenc": true, "_hsmi": true, "pk_campaign": true, "pk_kwd": true,
+		"pk_source": true, "pk_medium": true,
+	}
+)
+
+// NormalizeTransforms lowercases and deduplicates transforms, keeping their
+// order, and checks that they are known. tabs_to_spaces and spaces_to_tabs
+// exclude each other.
+func NormalizeTransforms(transforms []string) ([]string, error) {
+	seen := make(map[string]bool, len(transforms))
+	normalized := make([]string, 0, len(transforms))
+	for _, t := range transforms {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case TransformTrim, TransformNewlines, TransformStripTracking, TransformTabsToSpaces, TransformSpacesToTabs:
+		default:
+			return nil, fmt.Errorf("invalid transform %q", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	if seen[TransformTabsToSpaces] && seen[TransformSpacesToTabs] {
+		return nil, fmt.Errorf("transforms %s and %s exclude each other", TransformTabsToSpaces, TransformSpacesToTabs)
+	}
+
+	return normalized, nil
+}
+
+// Transform applies normalized transforms to data of the given type, in
+// order. Data that is not text is returned as is.
+func Transform(dataType, data string, transforms []string) string {
+	if len(transforms) == 0 {
+		return data
+	}
+	if base, _, err := mime.ParseMediaType(dataType); err != nil || !isTextual(base) {
+		return data
+	}
+
+	for _, t := range transforms {
+		switch t {
+		case TransformTrim:
+			data = strings.TrimSpace(data)
+		case TransformNewlines:
+			data = strings.ReplaceAll(data, "\r\n", "\n")
+			data = strings.ReplaceAll(data, "\r", "\n")
+		case TransformStripTracking:
+			data = urlPattern.ReplaceAllStringFunc(data, stripTracking)
+		case TransformTabsToSpaces:
+			data = mapLines(data, expandTabs)
+		case TransformSpacesToTabs:
+			data = mapLines(data, indentWithTabs)
+		}
+	}
+	return data
+}
+
+// ApplyTransforms applies the transforms of the clipboard to its data if
+// the data is text. Flavors are left alone.
+func (c *Clipboard) ApplyTransforms() {
+	c.Data = Transform(c.DataType, c.Data, c.Transforms)
+}
+
+// stripTracking removes tracking parameters from the query of a URL,
+// keeping the order of the others. URLs that do not parse are kept as is.
+func stripTracking(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	kept := params[:0]
+	for _, p := range params {
+		key, _, _ := strings.Cut(p, "=")
+		if key, err := url.QueryUnescape(key); err == nil && isTracking(strings.ToLower(key)) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	return u.String()
+}
+
+func isTracking(key string) bool {
+	if trackingParams[key] {
+		return true
+	}
+	for _, prefix := range trackingPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapLines applies fn to every line of data.
+func mapLines(data string, fn func(string) string) string {
+	lines := strings.Split(data, "\n")
+	for i, line := range lines {
+		lines[i] = fn(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// expandTabs replaces the tabs of a line with spaces up to the next tab stop.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+
+	var b strings.Builder
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := tabWidth - col%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+// indentWithTabs replaces every tabWidth spaces of the indentation of a
+// line with a tab. Spaces left over and spaces after the indentation are
+// kept.
+func indentWithTabs(line string) string {
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+	if indent == 0 {
+		return line
+	}
+
+	col := 0
+	for _, r := range line[:indent] {
+		if r == '\t' {
+			col += tabWidth - col%tabWidth
+		} else {
+			col++
+		}
+	}
+	return strings.Repeat("\t", col/tabWidth) + strings.Repeat(" ", col%tabWidth) + line[indent:]
+}
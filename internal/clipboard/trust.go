@@ -0,0 +1,137 @@
+package clipboard
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TrustLevel describes how risky it is to paste a clipboard's content on a
+// receiving device.
+type TrustLevel string
+
+const (
+	// TrustSafe is content that does not look executable.
+	TrustSafe TrustLevel = "safe"
+	// TrustScript is content that looks like a script or shell one-liner.
+	TrustScript TrustLevel = "script"
+	// TrustExecutable is content that looks like a compiled executable.
+	TrustExecutable TrustLevel = "executable"
+)
+
+// defaultTypeTrust holds the built-in trust levels of well-known data types.
+var defaultTypeTrust = map[string]TrustLevel{
+	"application/x-sh":                              TrustScript,
+	"application/x-shellscript":                     TrustScript,
+	"text/x-shellscript":                            TrustScript,
+	"application/x-bat":                             TrustScript,
+	"application/x-powershell":                      TrustScript,
+	"text/x-python":                                 TrustScript,
+	"application/javascript":                        TrustScript,
+	"text/javascript":                               TrustScript,
+	"text/html":                                     TrustScript,
+	"application/xhtml+xml":                         TrustScript,
+	"image/svg+xml":                                 TrustScript,
+	"application/x-msdownload":                      TrustExecutable,
+	"application/x-executable":                      TrustExecutable,
+	"application/x-elf":                             TrustExecutable,
+	"application/x-mach-binary":                     TrustExecutable,
+	"application/vnd.microsoft.portable-executable": TrustExecutable,
+}
+
+// scriptPatterns match shell one-liners commonly used in paste-jacking attacks.
+var scriptPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(curl|wget|iwr|invoke-webrequest)\b[^\n|]*\|\s*(sudo\s+)?(ba|z|da)?sh\b`),
+	regexp.MustCompile(`(?i)\b(bash|sh|zsh|cmd(\.exe)?)\s+(-c|/c)\s`),
+	regexp.MustCompile(`(?i)\bpowershell(\.exe)?\b.*\s-(e|enc|encodedcommand)\s`),
+	regexp.MustCompile(`(?i)\b(iex|invoke-expression)\b\s*\(`),
+	regexp.MustCompile(`(?i)\bsudo\s+\S+`),
+	regexp.MustCompile(`(?i)\brm\s+-(rf|fr)\b`),
+	regexp.MustCompile(`(?i)\bbase64\s+(-d|--decode)\b[^\n]*\|`),
+}
+
+// markupPattern matches HTML and SVG documents, which run the scripts they
+// embed when opened in a browser.
+var markupPattern = regexp.MustCompile(`(?is)^\s*(<\?xml[^>]*>\s*)?(<!--.*?-->\s*)*<(!doctype\s+(html|svg)|html|svg|script)\b`)
+
+// TrustPolicy decides the trust level of clipboards.
+// Types maps data types to a fixed trust level; clipboards of any other type
+// are classified by inspecting their content.
+type TrustPolicy struct {
+	Types map[string]TrustLevel
+}
+
+// NewTrustPolicy returns a policy with the built-in data type levels,
+// overridden by the given comma-separated "type=level" list.
+func NewTrustPolicy(overrides string) (TrustPolicy, error) {
+	p := TrustPolicy{Types: make(map[string]TrustLevel, len(defaultTypeTrust))}
+	for t, l := range defaultTypeTrust {
+		p.Types[t] = l
+	}
+
+	for _, entry := range strings.Split(overrides, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		dataType, level, ok := strings.Cut(entry, "=")
+		if !ok {
+			return p, fmt.Errorf("invalid trust level entry %q", entry)
+		}
+		switch l := TrustLevel(strings.TrimSpace(level)); l {
+		case TrustSafe, TrustScript, TrustExecutable:
+			p.Types[strings.ToLower(strings.TrimSpace(dataType))] = l
+		default:
+			return p, fmt.Errorf("unknown trust level %q for %s", level, dataType)
+		}
+	}
+
+	return p, nil
+}
+
+// Assess returns the trust level of the given clipboard.
+// The clipboard must be decrypted for its content to be inspected.
+func (p TrustPolicy) Assess(c *Clipboard) TrustLevel {
+	return p.AssessData(c.DataType, c.Data)
+}
+
+// AssessData returns the trust level of data of the given type.
+func (p TrustPolicy) AssessData(dataType, data string) TrustLevel {
+	if l, ok := p.Types[strings.ToLower(dataType)]; ok {
+		return l
+	}
+
+	return SniffTrust(data)
+}
+
+// Riskier reports whether l is a higher risk than other.
+func (l TrustLevel) Riskier(other TrustLevel) bool {
+	rank := map[TrustLevel]int{TrustSafe: 0, TrustScript: 1, TrustExecutable: 2}
+	return rank[l] > rank[other]
+}
+
+// SniffTrust classifies data by looking for executable headers, shebangs,
+// HTML and SVG documents and dangerous shell one-liners.
+func SniffTrust(data string) TrustLevel {
+	b := []byte(data)
+	switch {
+	case bytes.HasPrefix(b, []byte("MZ")),
+		bytes.HasPrefix(b, []byte("\x7fELF")),
+		bytes.HasPrefix(b, []byte("\xcf\xfa\xed\xfe")),
+		bytes.HasPrefix(b, []byte("\xce\xfa\xed\xfe")),
+		bytes.HasPrefix(b, []byte("\xca\xfe\xba\xbe")):
+		return TrustExecutable
+	case bytes.HasPrefix(bytes.TrimSpace(b), []byte("#!")),
+		markupPattern.Match(b):
+		return TrustScript
+	}
+
+	for _, re := range scriptPatterns {
+		if re.Match(b) {
+			return TrustScript
+		}
+	}
+
+	return TrustSafe
+}
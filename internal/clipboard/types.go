@@ -0,0 +1,131 @@
+package clipboard
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrTypeNotAllowed is returned for malformed data types and types that
+	// are not in the allowlist.
+	ErrTypeNotAllowed = errors.New("data type not allowed")
+	// ErrTypeMismatch is returned when the data does not look like its type.
+	ErrTypeMismatch = errors.New("data does not match its type")
+)
+
+// textualTypes are non-text/* types whose data is text.
+var textualTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"image/svg+xml":          true,
+}
+
+// TypePolicy validates the data types of clipboards.
+type TypePolicy struct {
+	// Allowed holds the allowed types, either exact ("image/png") or
+	// wildcards ("text/*", "*/*").
+	Allowed []string
+	// Sniff enables checking that the data looks like its type.
+	Sniff bool
+}
+
+// NewTypePolicy parses a comma-separated allowlist of data types.
+// An empty allowlist allows every well-formed type.
+func NewTypePolicy(allowed string, sniff bool) (TypePolicy, error) {
+	p := TypePolicy{Sniff: sniff}
+	for _, t := range strings.Split(allowed, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if t == "*" {
+			t = "*/*"
+		}
+		major, minor, ok := strings.Cut(t, "/")
+		if !ok || major == "" || minor == "" || (major == "*" && minor != "*") {
+			return p, fmt.Errorf("invalid data type pattern %q", t)
+		}
+		p.Allowed = append(p.Allowed, t)
+	}
+
+	return p, nil
+}
+
+// Check returns ErrTypeNotAllowed if dataType is malformed or not allowed,
+// and ErrTypeMismatch if sniffing is enabled and data does not look like
+// dataType. data must not be encrypted.
+func (p TypePolicy) Check(dataType, data string) error {
+	base, _, err := mime.ParseMediaType(dataType)
+	if err != nil || !strings.Contains(base, "/") {
+		return fmt.Errorf("%w: %q is not a valid media type", ErrTypeNotAllowed, dataType)
+	}
+	if !p.allows(base) {
+		return fmt.Errorf("%w: %s", ErrTypeNotAllowed, base)
+	}
+	if p.Sniff && !sniffMatches(base, data) {
+		return fmt.Errorf("%w: %s", ErrTypeMismatch, base)
+	}
+
+	return nil
+}
+
+func (p TypePolicy) allows(base string) bool {
+	if len(p.Allowed) == 0 {
+		return true
+	}
+
+	major, _, _ := strings.Cut(base, "/")
+	for _, t := range p.Allowed {
+		if t == "*/*" || t == base || t == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// isTextual reports whether data of the given type is text.
+func isTextual(base string) bool {
+	return strings.HasPrefix(base, "text/") || textualTypes[base] ||
+		strings.HasSuffix(base, "+json") || strings.HasSuffix(base, "+xml")
+}
+
+// sniffMatches reports whether data plausibly is of the declared type.
+// Only clear contradictions are rejected: binary data labeled as text, and
+// text or recognizable binary formats labeled as a different binary format.
+// Binary data sent base64-encoded is decoded before sniffing.
+func sniffMatches(declared, data string) bool {
+	if declared == "application/octet-stream" || data == "" {
+		return true
+	}
+
+	sniffed := sniff([]byte(data))
+	switch {
+	case sniffed == "application/octet-stream":
+		return !isTextual(declared) || utf8.ValidString(data)
+	case isTextual(sniffed) && isTextual(declared):
+		return true
+	case isTextual(sniffed):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		if err != nil {
+			return false
+		}
+		sniffed = sniff(decoded)
+		return sniffed == "application/octet-stream" || sniffed == declared
+	default:
+		return sniffed == declared
+	}
+}
+
+// sniff returns the base media type of data as detected by
+// http.DetectContentType.
+func sniff(data []byte) string {
+	base, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return base
+}
@@ -0,0 +1,71 @@
+package clipboard
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Upload is a clipboard being uploaded in chunks.
+// Data is appended chunk by chunk until the upload is committed as a clipboard.
+type Upload struct {
+	Id          string    `json:"id"`
+	OwnerId     int       `json:"-"`
+	Name        string    `json:"name"`
+	DataType    string    `json:"type"`
+	IsEncrypted bool      `json:"is_encrypted"`
+	Tags        []string  `json:"tags"`
+	Offset      int       `json:"offset"`
+	Length      int       `json:"length,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+
+	// Filename and ContentDisposition are passed on to the clipboard.
+	Filename           string `json:"filename,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+
+	// ClipboardId is the reserved id the clipboard is created under, see
+	// Reservation, or 0 for the next free one.
+	ClipboardId int `json:"clipboard_id,omitempty"`
+}
+
+// NewUploadId returns a random, unguessable upload id.
+// Anyone knowing the id of an anonymous upload can write to it.
+func NewUploadId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Clipboard returns a new clipboard with the metadata of the upload and the given data.
+func (u *Upload) Clipboard(data string) *Clipboard {
+	c := NewClipboard(u.Name, u.DataType, data)
+	c.IsEncrypted = u.IsEncrypted
+	c.Tags = u.Tags
+	c.Filename = u.Filename
+	c.ContentDisposition = u.ContentDisposition
+	c.Id = u.ClipboardId
+	return c
+}
+
+// BlobUpload is the data of a clipboard being uploaded to the blob store
+// directly, through a presigned URL, bypassing the server. The clipboard
+// only takes the data once the upload is committed.
+type BlobUpload struct {
+	Id          string `json:"id"`
+	ClipboardId int    `json:"clipboard_id"`
+	// Version is the version of the clipboard the upload replaces the data
+	// of. Committing fails if the clipboard changed in the meantime.
+	Version  int    `json:"version"`
+	BlobKey  string `json:"-"`
+	DataType string `json:"type"`
+	Size     int64  `json:"size"`
+
+	// URL is the presigned URL the data is PUT to, with Headers. It is only
+	// known when the upload is created.
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
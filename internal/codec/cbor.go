@@ -0,0 +1,306 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// cborIndefinite is the additional information of indefinite-length items,
+// which end with cborBreak.
+const (
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+func encodeCBOR(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case string:
+		cborHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case json.Number:
+		n := parseNumber(v)
+		switch {
+		case n.isInt && n.neg:
+			cborHead(buf, cborNegInt, n.uint-1)
+		case n.isInt:
+			cborHead(buf, cborUint, n.uint)
+		case n.float32:
+			buf.WriteByte(0xfa)
+			putUint(buf, uint64(math.Float32bits(float32(n.float))), 4)
+		default:
+			buf.WriteByte(0xfb)
+			putUint(buf, math.Float64bits(n.float), 8)
+		}
+	case []any:
+		cborHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			encodeCBOR(buf, item)
+		}
+	case object:
+		cborHead(buf, cborMap, uint64(len(v)))
+		for _, m := range v {
+			cborHead(buf, cborText, uint64(len(m.key)))
+			buf.WriteString(m.key)
+			encodeCBOR(buf, m.value)
+		}
+	}
+}
+
+// cborHead writes the initial byte of an item of a major type and its
+// argument in the shortest form.
+func cborHead(buf *bytes.Buffer, major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		putUint(buf, arg, 1)
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		putUint(buf, arg, 2)
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		putUint(buf, arg, 4)
+	default:
+		buf.WriteByte(major | 27)
+		putUint(buf, arg, 8)
+	}
+}
+
+// putUint writes the size lowest bytes of u in big-endian order.
+func putUint(buf *bytes.Buffer, u uint64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		buf.WriteByte(byte(u >> (8 * i)))
+	}
+}
+
+// cborItem reads the head of the next item and returns its major type,
+// additional information and argument. The argument of indefinite-length
+// items is 0.
+func cborItem(d *decoder) (byte, byte, uint64, error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info := b>>5, b&31
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		arg, err = d.uint(1 << (info - 24))
+	case info == cborIndefinite && (major >= cborBytes && major <= cborMap):
+	default:
+		err = fmt.Errorf("invalid additional information %d", info)
+	}
+	return major, info, arg, err
+}
+
+func decodeCBOR(d *decoder) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	major, info, arg, err := cborItem(d)
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case cborUint:
+		d.writeUint(arg)
+	case cborNegInt:
+		if arg < math.MaxInt64 {
+			d.writeInt(-1 - int64(arg))
+		} else {
+			n := new(big.Int).SetUint64(arg)
+			d.out.WriteString(n.Neg(n.Add(n, big.NewInt(1))).String())
+		}
+	case cborBytes, cborText:
+		s, err := cborString(d, major, info, arg)
+		if err != nil {
+			return err
+		}
+		if major == cborBytes {
+			d.writeBytes(s)
+			return nil
+		}
+		return d.writeString(s)
+	case cborArray:
+		d.out.WriteByte('[')
+		for i := uint64(0); ; i++ {
+			if done, err := cborDone(d, info, arg, i); err != nil || done {
+				if err == nil {
+					d.out.WriteByte(']')
+				}
+				return err
+			}
+			if i > 0 {
+				d.out.WriteByte(',')
+			}
+			if err := decodeCBOR(d); err != nil {
+				return err
+			}
+		}
+	case cborMap:
+		d.out.WriteByte('{')
+		for i := uint64(0); ; i++ {
+			if done, err := cborDone(d, info, arg, i); err != nil || done {
+				if err == nil {
+					d.out.WriteByte('}')
+				}
+				return err
+			}
+			if i > 0 {
+				d.out.WriteByte(',')
+			}
+			keyMajor, keyInfo, keyArg, err := cborItem(d)
+			if err != nil {
+				return err
+			}
+			if keyMajor != cborText {
+				return errors.New("map keys must be text strings")
+			}
+			key, err := cborString(d, keyMajor, keyInfo, keyArg)
+			if err != nil {
+				return err
+			}
+			if err := d.writeString(key); err != nil {
+				return err
+			}
+			d.out.WriteByte(':')
+			if err := decodeCBOR(d); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		// Tags only add meaning to the item they enclose, such as date
+		// strings or epoch times, which JSON has no notion of.
+		return decodeCBOR(d)
+	case cborSimple:
+		return cborSimpleValue(d, info, arg)
+	}
+	return nil
+}
+
+// cborDone reports whether a container with the given additional
+// information and count ends before its item i, consuming the break of
+// indefinite-length containers.
+func cborDone(d *decoder, info byte, count, i uint64) (bool, error) {
+	if info != cborIndefinite {
+		if i == 0 {
+			if err := d.checkCount(count); err != nil {
+				return false, err
+			}
+		}
+		return i == count, nil
+	}
+	if d.pos >= len(d.data) {
+		return false, errTruncated
+	}
+	if d.data[d.pos] == cborBreak {
+		d.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+// cborString reads the content of a byte or text string whose head was
+// read, joining the chunks of indefinite-length strings.
+func cborString(d *decoder, major, info byte, arg uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		return d.bytes(arg)
+	}
+	var s []byte
+	for {
+		if d.pos >= len(d.data) {
+			return nil, errTruncated
+		}
+		if d.data[d.pos] == cborBreak {
+			d.pos++
+			return s, nil
+		}
+		chunkMajor, chunkInfo, chunkArg, err := cborItem(d)
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, errors.New("invalid chunk of indefinite-length string")
+		}
+		chunk, err := d.bytes(chunkArg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+// cborSimpleValue writes the simple value or float whose head was read.
+func cborSimpleValue(d *decoder, info byte, arg uint64) error {
+	switch info {
+	case 20:
+		d.out.WriteString("false")
+	case 21:
+		d.out.WriteString("true")
+	case 22, 23: // null and undefined
+		d.out.WriteString("null")
+	case 25:
+		return d.writeFloat(float16(uint16(arg)))
+	case 26:
+		return d.writeFloat(float64(math.Float32frombits(uint32(arg))))
+	case 27:
+		return d.writeFloat(math.Float64frombits(arg))
+	default:
+		return fmt.Errorf("unsupported simple value %d", arg)
+	}
+	return nil
+}
+
+// float16 converts a half-precision float.
+func float16(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
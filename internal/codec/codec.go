@@ -0,0 +1,267 @@
+// Package codec converts JSON documents to and from the binary formats CBOR
+// (RFC 8949) and MessagePack, so clients can exchange them instead of JSON.
+// Only the JSON data model is supported: objects with string keys, arrays,
+// strings, numbers, booleans and null. Keys keep their order.
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the nesting of documents, so deeply nested input cannot
+// exhaust the stack.
+const maxDepth = 512
+
+// Format is a binary format JSON documents can be converted to.
+type Format struct {
+	// Name is the name of the format in error messages.
+	Name string
+	// MediaType is the media type responses in the format are sent with.
+	MediaType string
+	// aliases are other media types the format is known by.
+	aliases []string
+
+	encode func(buf *bytes.Buffer, v any)
+	decode func(d *decoder) error
+}
+
+var (
+	CBOR = &Format{
+		Name:      "CBOR",
+		MediaType: "application/cbor",
+		encode:    encodeCBOR,
+		decode:    decodeCBOR,
+	}
+	MessagePack = &Format{
+		Name:      "MessagePack",
+		MediaType: "application/msgpack",
+		aliases:   []string{"application/x-msgpack", "application/vnd.msgpack"},
+		encode:    encodeMessagePack,
+		decode:    decodeMessagePack,
+	}
+)
+
+// Formats lists the supported formats.
+var Formats = []*Format{CBOR, MessagePack}
+
+// ByMediaType returns the format of a media type without parameters, or
+// nil if it is not supported.
+func ByMediaType(mediaType string) *Format {
+	mediaType = strings.ToLower(mediaType)
+	for _, f := range Formats {
+		if mediaType == f.MediaType {
+			return f
+		}
+		for _, alias := range f.aliases {
+			if mediaType == alias {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// FromJSON converts a JSON document to the format.
+func (f *Format) FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readJSON(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: trailing data")
+	}
+
+	var buf bytes.Buffer
+	f.encode(&buf, v)
+	return buf.Bytes(), nil
+}
+
+// ToJSON converts a document in the format to JSON. Byte strings, which
+// JSON lacks, become base64-encoded strings.
+func (f *Format) ToJSON(data []byte) ([]byte, error) {
+	d := &decoder{data: data}
+	if err := f.decode(d); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", f.Name, err)
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("invalid %s: trailing data", f.Name)
+	}
+	return d.out.Bytes(), nil
+}
+
+// member is a key and value of a JSON object.
+type member struct {
+	key   string
+	value any
+}
+
+// object is a JSON object with its keys in order.
+type object []member
+
+// readJSON reads the next JSON value as nil, a bool, a json.Number, a
+// string, a []any or an object.
+func readJSON(dec *json.Decoder, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, errors.New("invalid JSON: nested too deeply")
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	switch tok {
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			v, err := readJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+			v, err := readJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{key.(string), v})
+		}
+		_, err := dec.Token()
+		return obj, err
+	}
+	return tok, nil
+}
+
+// number is a JSON number as the integer or float it holds.
+type number struct {
+	isInt   bool
+	neg     bool
+	uint    uint64 // absolute value of integers
+	float   float64
+	float32 bool // whether float fits a float32 exactly
+}
+
+func parseNumber(n json.Number) number {
+	s := string(n)
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return number{isInt: true, uint: u}
+	}
+	if strings.HasPrefix(s, "-") {
+		if u, err := strconv.ParseUint(s[1:], 10, 64); err == nil && u <= 1<<63 {
+			return number{isInt: true, neg: u > 0, uint: u}
+		}
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return number{float: f, float32: float64(float32(f)) == f}
+}
+
+// decoder reads a binary document and writes it out as JSON.
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+	out   bytes.Buffer
+}
+
+var errTruncated = errors.New("unexpected end of data")
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.bytes(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// checkCount fails if a container claims more items than bytes are left,
+// as each item takes at least one, so the count can be trusted.
+func (d *decoder) checkCount(n uint64) error {
+	if n > uint64(len(d.data)-d.pos) {
+		return errTruncated
+	}
+	return nil
+}
+
+func (d *decoder) enter() error {
+	d.depth++
+	if d.depth > maxDepth {
+		return errors.New("nested too deeply")
+	}
+	return nil
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
+
+func (d *decoder) writeString(s []byte) error {
+	if !utf8.Valid(s) {
+		return errors.New("text string is not valid UTF-8")
+	}
+	enc, _ := json.Marshal(string(s))
+	d.out.Write(enc)
+	return nil
+}
+
+func (d *decoder) writeBytes(b []byte) {
+	d.out.WriteByte('"')
+	d.out.WriteString(base64.StdEncoding.EncodeToString(b))
+	d.out.WriteByte('"')
+}
+
+func (d *decoder) writeUint(u uint64) {
+	d.out.WriteString(strconv.FormatUint(u, 10))
+}
+
+func (d *decoder) writeInt(i int64) {
+	d.out.WriteString(strconv.FormatInt(i, 10))
+}
+
+func (d *decoder) writeFloat(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errors.New("NaN and infinity cannot be represented in JSON")
+	}
+	d.out.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	return nil
+}
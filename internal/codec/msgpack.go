@@ -0,0 +1,320 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// msgpackTimestamp is the extension type of MessagePack timestamps.
+const msgpackTimestamp = -1
+
+func encodeMessagePack(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case string:
+		switch n := uint64(len(v)); {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.WriteByte(0xd9)
+			putUint(buf, n, 1)
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			putUint(buf, n, 2)
+		default:
+			buf.WriteByte(0xdb)
+			putUint(buf, n, 4)
+		}
+		buf.WriteString(v)
+	case json.Number:
+		msgpackNumber(buf, parseNumber(v))
+	case []any:
+		msgpackHead(buf, 0x90, 0xdc, uint64(len(v)))
+		for _, item := range v {
+			encodeMessagePack(buf, item)
+		}
+	case object:
+		msgpackHead(buf, 0x80, 0xde, uint64(len(v)))
+		for _, m := range v {
+			encodeMessagePack(buf, m.key)
+			encodeMessagePack(buf, m.value)
+		}
+	}
+}
+
+// msgpackHead writes the header of an array or map of n items, given the
+// fix format of its kind and its 16-bit format, followed by the 32-bit one.
+func msgpackHead(buf *bytes.Buffer, fix, format16 byte, n uint64) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(format16)
+		putUint(buf, n, 2)
+	default:
+		buf.WriteByte(format16 + 1)
+		putUint(buf, n, 4)
+	}
+}
+
+// msgpackNumber writes a number in the smallest format holding it.
+func msgpackNumber(buf *bytes.Buffer, n number) {
+	switch {
+	case n.isInt && !n.neg:
+		switch u := n.uint; {
+		case u < 128:
+			buf.WriteByte(byte(u))
+		case u <= math.MaxUint8:
+			buf.WriteByte(0xcc)
+			putUint(buf, u, 1)
+		case u <= math.MaxUint16:
+			buf.WriteByte(0xcd)
+			putUint(buf, u, 2)
+		case u <= math.MaxUint32:
+			buf.WriteByte(0xce)
+			putUint(buf, u, 4)
+		default:
+			buf.WriteByte(0xcf)
+			putUint(buf, u, 8)
+		}
+	case n.isInt:
+		i := -int64(n.uint-1) - 1
+		switch {
+		case i >= -32:
+			buf.WriteByte(byte(i))
+		case i >= math.MinInt8:
+			buf.WriteByte(0xd0)
+			putUint(buf, uint64(i), 1)
+		case i >= math.MinInt16:
+			buf.WriteByte(0xd1)
+			putUint(buf, uint64(i), 2)
+		case i >= math.MinInt32:
+			buf.WriteByte(0xd2)
+			putUint(buf, uint64(i), 4)
+		default:
+			buf.WriteByte(0xd3)
+			putUint(buf, uint64(i), 8)
+		}
+	case n.float32:
+		buf.WriteByte(0xca)
+		putUint(buf, uint64(math.Float32bits(float32(n.float))), 4)
+	default:
+		buf.WriteByte(0xcb)
+		putUint(buf, math.Float64bits(n.float), 8)
+	}
+}
+
+func decodeMessagePack(d *decoder) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	b, err := d.byte()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case b <= 0x7f:
+		d.writeUint(uint64(b))
+		return nil
+	case b >= 0xe0:
+		d.writeInt(int64(int8(b)))
+		return nil
+	case b <= 0x8f:
+		return msgpackMap(d, uint64(b&0x0f))
+	case b <= 0x9f:
+		return msgpackArray(d, uint64(b&0x0f))
+	case b <= 0xbf:
+		return msgpackString(d, uint64(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		d.out.WriteString("null")
+	case 0xc2:
+		d.out.WriteString("false")
+	case 0xc3:
+		d.out.WriteString("true")
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return err
+		}
+		bin, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		d.writeBytes(bin)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (b - 0xc7))
+		if err != nil {
+			return err
+		}
+		return msgpackExt(d, n)
+	case 0xca:
+		u, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		return d.writeFloat(float64(math.Float32frombits(uint32(u))))
+	case 0xcb:
+		u, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		return d.writeFloat(math.Float64frombits(u))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (b - 0xcc))
+		if err != nil {
+			return err
+		}
+		d.writeUint(u)
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		// Sign-extend the integer from its size.
+		shift := 64 - 8*size
+		d.writeInt(int64(u<<shift) >> shift)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return msgpackExt(d, 1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return err
+		}
+		return msgpackString(d, n)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return err
+		}
+		return msgpackArray(d, n)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return err
+		}
+		return msgpackMap(d, n)
+	default:
+		return fmt.Errorf("invalid format 0x%02x", b)
+	}
+	return nil
+}
+
+func msgpackString(d *decoder, n uint64) error {
+	s, err := d.bytes(n)
+	if err != nil {
+		return err
+	}
+	return d.writeString(s)
+}
+
+func msgpackArray(d *decoder, n uint64) error {
+	if err := d.checkCount(n); err != nil {
+		return err
+	}
+	d.out.WriteByte('[')
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			d.out.WriteByte(',')
+		}
+		if err := decodeMessagePack(d); err != nil {
+			return err
+		}
+	}
+	d.out.WriteByte(']')
+	return nil
+}
+
+func msgpackMap(d *decoder, n uint64) error {
+	if err := d.checkCount(n); err != nil {
+		return err
+	}
+	d.out.WriteByte('{')
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			d.out.WriteByte(',')
+		}
+		b, err := d.byte()
+		if err != nil {
+			return err
+		}
+		var size uint64
+		switch {
+		case b&0xe0 == 0xa0:
+			size = uint64(b & 0x1f)
+		case b >= 0xd9 && b <= 0xdb:
+			if size, err = d.uint(1 << (b - 0xd9)); err != nil {
+				return err
+			}
+		default:
+			return errors.New("map keys must be strings")
+		}
+		if err := msgpackString(d, size); err != nil {
+			return err
+		}
+		d.out.WriteByte(':')
+		if err := decodeMessagePack(d); err != nil {
+			return err
+		}
+	}
+	d.out.WriteByte('}')
+	return nil
+}
+
+// msgpackExt writes an extension value of n bytes. Only timestamps are
+// supported, which become RFC 3339 strings.
+func msgpackExt(d *decoder, n uint64) error {
+	typ, err := d.byte()
+	if err != nil {
+		return err
+	}
+	data, err := d.bytes(n)
+	if err != nil {
+		return err
+	}
+	if int8(typ) != msgpackTimestamp {
+		return fmt.Errorf("unsupported extension type %d", int8(typ))
+	}
+
+	var t time.Time
+	switch len(data) {
+	case 4:
+		t = time.Unix(int64(uint32(be(data))), 0)
+	case 8:
+		u := be(data)
+		t = time.Unix(int64(u&(1<<34-1)), int64(u>>34))
+	case 12:
+		t = time.Unix(int64(be(data[4:])), int64(uint32(be(data[:4]))))
+	default:
+		return errors.New("invalid timestamp")
+	}
+	enc, _ := json.Marshal(t.UTC().Format(time.RFC3339Nano))
+	d.out.Write(enc)
+	return nil
+}
+
+// be decodes a big-endian unsigned integer.
+func be(b []byte) uint64 {
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
@@ -0,0 +1,192 @@
+// Package convert converts clipboard data to other types when it is read,
+// such as Markdown to HTML or HTML to plain text, for receiving apps that
+// need a different flavor than the sender provided.
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/thumbnail"
+)
+
+// Targets data can be converted to.
+const (
+	// HTML renders Markdown and plain text as HTML.
+	HTML = "html"
+	// Text extracts the text of HTML and Markdown.
+	Text = "text"
+	// JSON pretty-prints JSON.
+	JSON = "json"
+	// PNG, JPEG and GIF transcode images.
+	PNG  = "png"
+	JPEG = "jpeg"
+	GIF  = "gif"
+)
+
+// Targets lists the targets in the order they are documented.
+var Targets = []string{HTML, Text, JSON, PNG, JPEG, GIF}
+
+// targetTypes are the types of data converted to each target.
+var targetTypes = map[string]string{
+	HTML: "text/html; charset=utf-8",
+	Text: "text/plain; charset=utf-8",
+	JSON: "application/json",
+	PNG:  "image/png",
+	JPEG: "image/jpeg",
+	GIF:  "image/gif",
+}
+
+var (
+	// ErrUnsupported is returned for data that cannot be converted to a
+	// target, whatever it holds.
+	ErrUnsupported = errors.New("conversion not supported")
+	// ErrInvalidData is returned for data that does not parse as its type.
+	ErrInvalidData = errors.New("data cannot be converted")
+)
+
+// ParseTarget lowercases a target and checks that it is known. "jpg" is
+// read as JPEG.
+func ParseTarget(target string) (string, error) {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if target == "jpg" {
+		target = JPEG
+	}
+	if _, ok := targetTypes[target]; !ok {
+		return "", fmt.Errorf("as must be one of: %s", strings.Join(Targets, ", "))
+	}
+	return target, nil
+}
+
+// Type returns the type of data converted to target.
+func Type(target string) string {
+	return targetTypes[target]
+}
+
+// IsImage reports whether target is an image format.
+func IsImage(target string) bool {
+	return target == PNG || target == JPEG || target == GIF
+}
+
+// Supports reports whether data of dataType can be converted to target.
+func Supports(dataType, target string) bool {
+	base, _, err := mime.ParseMediaType(dataType)
+	if err != nil {
+		return false
+	}
+
+	switch target {
+	case HTML:
+		return isMarkdown(base) || base == "text/html" || base == "text/plain"
+	case Text:
+		return clipboard.IsText(dataType)
+	case JSON:
+		return base == "application/json" || strings.HasSuffix(base, "+json") || base == "text/plain"
+	case PNG, JPEG, GIF:
+		return base == "image/png" || base == "image/jpeg" || base == "image/gif"
+	}
+	return false
+}
+
+// Pick returns the index of the type among types whose data is best
+// converted to target: the first one already of the type of the target, or
+// else the first one that can be converted. It returns -1 if none can.
+func Pick(types []string, target string) int {
+	want, _, _ := mime.ParseMediaType(Type(target))
+	for i, t := range types {
+		if base, _, err := mime.ParseMediaType(t); err == nil && base == want && Supports(t, target) {
+			return i
+		}
+	}
+	for i, t := range types {
+		if Supports(t, target) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Convert converts data of dataType to target. Text already of the type of
+// the target is returned as is, JSON is always pretty-printed and images
+// are always re-encoded, which keeps only the first frame of animated GIFs.
+// Images may be base64-encoded as sent through the JSON API, and images
+// with more than maxPixels pixels are rejected with thumbnail.ErrTooLarge.
+func Convert(dataType string, data []byte, target string, maxPixels int) ([]byte, error) {
+	if !Supports(dataType, target) {
+		return nil, ErrUnsupported
+	}
+	base, _, _ := mime.ParseMediaType(dataType)
+
+	switch target {
+	case HTML:
+		switch {
+		case isMarkdown(base):
+			return []byte(Markdown(string(data))), nil
+		case base == "text/plain":
+			return []byte(textToHTML(string(data))), nil
+		}
+		return data, nil
+	case Text:
+		switch {
+		case isMarkdown(base):
+			return []byte(HTMLText(Markdown(string(data)))), nil
+		case base == "text/html":
+			return []byte(HTMLText(string(data))), nil
+		}
+		return data, nil
+	case JSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, bytes.TrimSpace(data), "", "  "); err != nil {
+			return nil, fmt.Errorf("%w: invalid JSON", ErrInvalidData)
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	img, err := thumbnail.Decode(data, maxPixels)
+	if err == thumbnail.ErrUnsupported {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encodeImage(img, target)
+}
+
+// encodeImage encodes an image in the format of target. JPEG has no
+// transparency, so transparent areas turn white.
+func encodeImage(img image.Image, target string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch target {
+	case PNG:
+		err = png.Encode(&buf, img)
+	case JPEG:
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&buf, flat, &jpeg.Options{Quality: 90})
+	case GIF:
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isMarkdown reports whether a base type is one of the types of Markdown.
+func isMarkdown(base string) bool {
+	return base == "text/markdown" || base == "text/x-markdown"
+}
@@ -0,0 +1,518 @@
+package convert
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	atxHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	thematicBreak = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextH1      = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	setextH2      = regexp.MustCompile(`^ {0,3}-+[ \t]*$`)
+	fenceOpen     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	blockquote    = regexp.MustCompile(`^ {0,3}> ?`)
+	listItem      = regexp.MustCompile(`^( {0,3})([-+*]|\d{1,9}[.)])(?:([ \t]+)(.*))?$`)
+	blankLines    = regexp.MustCompile(`\n[ \t]*\n`)
+)
+
+// Markdown renders CommonMark-style Markdown as HTML: headings, paragraphs,
+// block quotes, lists, code blocks and thematic breaks, with emphasis,
+// strikethrough, code spans, links, images and line breaks inline. Raw HTML
+// is escaped rather than passed through, and links and images only keep
+// URLs of the http, https, mailto and relative kinds, so rendering a
+// clipboard cannot inject scripts into the page showing it.
+func Markdown(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	return renderBlocks(strings.Split(source, "\n"), false)
+}
+
+// renderBlocks renders lines of Markdown as blocks. Tight list items leave
+// their paragraphs unwrapped.
+func renderBlocks(lines []string, tight bool) string {
+	var blocks []string
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case fenceOpen.MatchString(line):
+			m := fenceOpen.FindStringSubmatch(line)
+			indent, fence := len(m[1]), m[2]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if t := strings.TrimLeft(lines[i], " "); len(lines[i])-len(t) < 4 && strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]+" \t") == "" {
+					i++
+					break
+				}
+				code = append(code, trimIndent(lines[i], indent))
+			}
+			blocks = append(blocks, codeBlock(code, m[3]))
+
+		case indentation(line) >= 4:
+			var code []string
+			for ; i < len(lines) && (isBlank(lines[i]) || indentation(lines[i]) >= 4); i++ {
+				code = append(code, trimIndent(lines[i], 4))
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			blocks = append(blocks, codeBlock(code, ""))
+
+		case atxHeading.MatchString(line):
+			m := atxHeading.FindStringSubmatch(line)
+			blocks = append(blocks, fmt.Sprintf("<h%d>%s</h%d>", len(m[1]), inline(m[2]), len(m[1])))
+			i++
+
+		case thematicBreak.MatchString(line):
+			blocks = append(blocks, "<hr />")
+			i++
+
+		case blockquote.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if loc := blockquote.FindStringIndex(lines[i]); loc != nil {
+					quoted = append(quoted, lines[i][loc[1]:])
+				} else if interrupts(lines[i]) {
+					break
+				} else {
+					quoted = append(quoted, lines[i])
+				}
+			}
+			blocks = append(blocks, "<blockquote>\n"+renderBlocks(quoted, false)+"\n</blockquote>")
+
+		case listItem.MatchString(line):
+			var list string
+			list, i = renderList(lines, i)
+			blocks = append(blocks, list)
+
+		default:
+			var para []string
+			heading := 0
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if len(para) > 0 && setextH1.MatchString(lines[i]) {
+					heading = 1
+				} else if len(para) > 0 && setextH2.MatchString(lines[i]) {
+					heading = 2
+				} else if len(para) > 0 && interrupts(lines[i]) {
+					break
+				}
+				if heading > 0 {
+					i++
+					break
+				}
+				para = append(para, lines[i])
+			}
+			blocks = append(blocks, paragraph(para, heading, tight))
+		}
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// renderList renders the list starting at lines[start] and returns it with
+// the index of the line after it. Items continue on lines indented as far
+// as their content, and on lazy continuation lines of paragraphs. Lists
+// with blank lines between or within items are loose and wrap their items
+// in paragraphs.
+func renderList(lines []string, start int) (string, int) {
+	first := listItem.FindStringSubmatch(lines[start])
+	ordered := !isBullet(first[2])
+	delimiter := first[2][len(first[2])-1:]
+
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		m := listItem.FindStringSubmatch(lines[i])
+		if m == nil || m[2][len(m[2])-1:] != delimiter || isBullet(m[2]) == ordered {
+			break
+		}
+		contentIndent := len(m[1]) + len(m[2]) + len(m[3])
+		if len(m[3]) > 4 || m[4] == "" {
+			contentIndent = len(m[1]) + len(m[2]) + 1
+		}
+		item := []string{m[4]}
+		if len(m[3]) > 4 {
+			item[0] = strings.Repeat(" ", len(m[3])-1) + m[4]
+		}
+
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			switch {
+			case isBlank(line):
+				item = append(item, "")
+				continue
+			case indentation(line) >= contentIndent:
+				item = append(item, trimIndent(line, contentIndent))
+				continue
+			case !isBlank(item[len(item)-1]) && !interrupts(line) && !listItem.MatchString(line):
+				item = append(item, line)
+				continue
+			}
+			break
+		}
+
+		// Blank lines only make the list loose if more of it follows.
+		trailing := 0
+		for len(item) > 1 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		for _, line := range item {
+			if isBlank(line) {
+				loose = true
+			}
+		}
+		items = append(items, item)
+		if trailing > 0 && i < len(lines) {
+			if m := listItem.FindStringSubmatch(lines[i]); m != nil && m[2][len(m[2])-1:] == delimiter {
+				loose = true
+			} else {
+				break
+			}
+		}
+	}
+
+	tag, open := "ul", "<ul>"
+	if ordered {
+		tag, open = "ol", "<ol>"
+		if n, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); n != 1 {
+			open = fmt.Sprintf(`<ol start="%d">`, n)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(open + "\n")
+	for _, item := range items {
+		b.WriteString("<li>" + renderBlocks(item, !loose) + "</li>\n")
+	}
+	b.WriteString("</" + tag + ">")
+	return b.String(), i
+}
+
+// interrupts reports whether a line starts a block that ends a paragraph.
+func interrupts(line string) bool {
+	if atxHeading.MatchString(line) || thematicBreak.MatchString(line) || fenceOpen.MatchString(line) || blockquote.MatchString(line) {
+		return true
+	}
+	// Only lists starting at 1 and non-empty items interrupt paragraphs, so
+	// numbers wrapped to the start of a line do not start lists.
+	m := listItem.FindStringSubmatch(line)
+	return m != nil && m[4] != "" && (isBullet(m[2]) || strings.TrimRight(m[2], ".)") == "1")
+}
+
+// paragraph renders the lines of a paragraph, or of a setext heading of
+// the given level. Lines ending in two spaces or a backslash break.
+func paragraph(lines []string, heading int, tight bool) string {
+	for i, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		if i < len(lines)-1 && strings.HasSuffix(line, "  ") {
+			line = strings.TrimRight(line, " ") + "\\"
+		}
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text := inline(strings.Join(lines, "\n"))
+
+	switch {
+	case heading > 0:
+		return fmt.Sprintf("<h%d>%s</h%d>", heading, text, heading)
+	case tight:
+		return text
+	}
+	return "<p>" + text + "</p>"
+}
+
+// codeBlock renders lines of code, with the language of the info string
+// as the class of the code element.
+func codeBlock(lines []string, info string) string {
+	class := ""
+	if info != "" {
+		class = ` class="language-` + html.EscapeString(info) + `"`
+	}
+	code := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		code += "\n"
+	}
+	return "<pre><code" + class + ">" + html.EscapeString(code) + "</code></pre>"
+}
+
+// inline renders the inline content of a block.
+func inline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br />\n")
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if code, n := codeSpan(s[i:]); n > 0 {
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n
+				continue
+			}
+			n := runLength(s[i:], '`')
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if text, dest, n := link(s[i+1:]); n > 0 {
+				if dest = safeURL(dest); dest != "" {
+					b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(text) + `" />`)
+				} else {
+					b.WriteString(html.EscapeString(text))
+				}
+				i += 1 + n
+				continue
+			}
+		case c == '[':
+			if text, dest, n := link(s[i:]); n > 0 {
+				if dest = safeURL(dest); dest != "" {
+					b.WriteString(`<a href="` + html.EscapeString(dest) + `">` + inline(text) + `</a>`)
+				} else {
+					b.WriteString(inline(text))
+				}
+				i += n
+				continue
+			}
+		case c == '<':
+			if end := strings.IndexByte(s[i:], '>'); end > 0 {
+				if dest := s[i+1 : i+end]; !strings.ContainsAny(dest, " \t\n<") && strings.Contains(dest, ":") && safeURL(dest) != "" {
+					b.WriteString(`<a href="` + html.EscapeString(dest) + `">` + html.EscapeString(dest) + `</a>`)
+					i += end + 1
+					continue
+				}
+			}
+		case c == '*' || c == '_' || c == '~':
+			if out, n := emphasis(s, i); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+			n := runLength(s[i:], c)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// codeSpan returns the code of the code span s starts with and its length,
+// or a length of 0 if the backticks it starts with are not closed.
+func codeSpan(s string) (string, int) {
+	n := runLength(s, '`')
+	for j := n; j < len(s); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			break
+		}
+		j += k
+		if m := runLength(s[j:], '`'); m != n {
+			j += m
+			continue
+		}
+		code := strings.ReplaceAll(s[n:j], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		return code, j + n
+	}
+	return "", 0
+}
+
+// link parses the link s starts with, [text](destination "title"), and
+// returns its text, destination and length, or a length of 0 if s does
+// not start with a link. Titles are dropped.
+func link(s string) (string, string, int) {
+	depth := 0
+	end := -1
+	for j := 0; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				end = j
+			}
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return "", "", 0
+	}
+
+	// Destinations may hold balanced parentheses.
+	rest := s[end+2:]
+	close, depth := -1, 0
+	for j := 0; j < len(rest) && close < 0; j++ {
+		switch rest[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				close = j
+			}
+			depth--
+		}
+	}
+	if close < 0 {
+		return "", "", 0
+	}
+	inner := strings.TrimSpace(rest[:close])
+	dest := inner
+	if strings.HasPrefix(inner, "<") {
+		if k := strings.IndexByte(inner, '>'); k > 0 {
+			dest = inner[1:k]
+		}
+	} else if k := strings.IndexAny(inner, " \t\n"); k >= 0 {
+		dest = inner[:k]
+		if title := strings.TrimSpace(inner[k:]); len(title) < 2 || !strings.ContainsAny(title[:1], `"'(`) {
+			return "", "", 0
+		}
+	}
+	return s[1:end], dest, end + 2 + close + 1
+}
+
+// emphasis renders the emphasis, strong emphasis or strikethrough opened
+// by the delimiter run at s[i], and returns it with the length of s it
+// covers, or a length of 0 if the run does not open one. Underscores
+// within words do not count, so snake_case identifiers stay as they are.
+func emphasis(s string, i int) (string, int) {
+	c := s[i]
+	n := runLength(s[i:], c)
+	if n > 3 || (c == '~' && n != 2) || i+n >= len(s) || isSpace(s[i+n]) {
+		return "", 0
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return "", 0
+	}
+
+	delim := s[i : i+n]
+	for j := i + n; j < len(s); {
+		k := strings.Index(s[j:], delim)
+		if k < 0 {
+			return "", 0
+		}
+		j += k
+		if runLength(s[j:], c) != n || isSpace(s[j-1]) || (c == '_' && j+n < len(s) && isWordByte(s[j+n])) {
+			j += runLength(s[j:], c)
+			continue
+		}
+
+		inner := inline(s[i+n : j])
+		switch {
+		case c == '~':
+			inner = "<del>" + inner + "</del>"
+		case n == 1:
+			inner = "<em>" + inner + "</em>"
+		case n == 2:
+			inner = "<strong>" + inner + "</strong>"
+		default:
+			inner = "<em><strong>" + inner + "</strong></em>"
+		}
+		return inner, j + n - i
+	}
+	return "", 0
+}
+
+// safeURL returns a link destination if it is relative or of the http,
+// https or mailto schemes, and "" otherwise.
+func safeURL(dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return dest
+	}
+	return ""
+}
+
+// textToHTML renders plain text as HTML paragraphs, separated by blank
+// lines, keeping its line breaks.
+func textToHTML(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var paras []string
+	for _, p := range blankLines.Split(strings.TrimSpace(text), -1) {
+		if p = strings.TrimSpace(p); p != "" {
+			paras = append(paras, "<p>"+strings.ReplaceAll(html.EscapeString(p), "\n", "<br />\n")+"</p>")
+		}
+	}
+	return strings.Join(paras, "\n")
+}
+
+func isBullet(marker string) bool {
+	return marker == "-" || marker == "+" || marker == "*"
+}
+
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func indentation(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4 - n%4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// trimIndent removes up to n columns of indentation from a line.
+func trimIndent(line string, n int) string {
+	col := 0
+	for i, r := range line {
+		if col >= n || (r != ' ' && r != '\t') {
+			return line[i:]
+		}
+		if r == '\t' {
+			col += 4 - col%4
+		} else {
+			col++
+		}
+	}
+	return ""
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
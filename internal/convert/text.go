@@ -0,0 +1,220 @@
+package convert
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skippedElements hold no text worth extracting.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Template: true,
+	atom.Noscript: true, atom.Iframe: true, atom.Object: true, atom.Svg: true,
+}
+
+// blockElements start on a line of their own, and paragraphElements are
+// also separated from their neighbours by a blank line, except for lists
+// nested in others.
+var (
+	blockElements = map[atom.Atom]bool{
+		atom.Div: true, atom.Li: true, atom.Tr: true, atom.Dt: true, atom.Dd: true,
+		atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+		atom.Nav: true, atom.Aside: true, atom.Main: true, atom.Figure: true,
+		atom.Figcaption: true, atom.Address: true, atom.Caption: true, atom.Form: true,
+		atom.Fieldset: true, atom.Details: true, atom.Summary: true,
+	}
+	paragraphElements = map[atom.Atom]bool{
+		atom.P: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+		atom.H5: true, atom.H6: true, atom.Ul: true, atom.Ol: true, atom.Dl: true,
+		atom.Pre: true, atom.Blockquote: true, atom.Table: true, atom.Hr: true,
+	}
+)
+
+var spaces = regexp.MustCompile(`[ \t\n\r\f]+`)
+
+// HTMLText extracts the text of an HTML document or fragment as it would
+// read in a browser: whitespace collapses except in pre elements, blocks
+// start new lines and paragraphs are separated by blank lines. List items
+// are bulleted or numbered, table cells separated by tabs, images replaced
+// by their alt text, and links followed by their URL in parentheses unless
+// the URL is their text.
+func HTMLText(source string) string {
+	doc, err := html.Parse(strings.NewReader(source))
+	if err != nil {
+		return source
+	}
+
+	t := &textWriter{}
+	t.node(doc)
+
+	lines := strings.Split(t.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
+
+// textWriter collects the text of HTML nodes. Line breaks and spaces are
+// held back until more text follows, so blocks never leave more than one
+// blank line, and no line starts or ends with collapsed whitespace.
+type textWriter struct {
+	b        strings.Builder
+	newlines int
+	space    bool
+	pre      int
+	lists    []int
+}
+
+func (t *textWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		t.text(n.Data)
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		t.children(n)
+		return
+	default:
+		return
+	}
+	if skippedElements[n.DataAtom] {
+		return
+	}
+
+	nested := (n.DataAtom == atom.Ul || n.DataAtom == atom.Ol) && len(t.lists) > 0
+	switch {
+	case paragraphElements[n.DataAtom] && !nested:
+		t.breakLines(2)
+		defer t.breakLines(2)
+	case blockElements[n.DataAtom] || nested:
+		t.breakLines(1)
+		defer t.breakLines(1)
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		t.newlines++
+	case atom.Hr:
+		t.raw("---")
+	case atom.Img:
+		t.text(attr(n, "alt"))
+	case atom.Pre:
+		t.pre++
+		defer func() { t.pre-- }()
+	case atom.Ul, atom.Ol:
+		next := 0
+		if n.DataAtom == atom.Ol {
+			next = 1
+			if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+				next = start
+			}
+		}
+		t.lists = append(t.lists, next)
+		defer func() { t.lists = t.lists[:len(t.lists)-1] }()
+	case atom.Li:
+		prefix := "- "
+		if depth := len(t.lists); depth > 0 {
+			if next := t.lists[depth-1]; next > 0 {
+				prefix = strconv.Itoa(next) + ". "
+				t.lists[depth-1]++
+			}
+			prefix = strings.Repeat("  ", depth-1) + prefix
+		}
+		t.raw(prefix)
+	case atom.Td, atom.Th:
+		if n.PrevSibling != nil {
+			t.raw("\t")
+		}
+	case atom.A:
+		t.children(n)
+		href := attr(n, "href")
+		if href != "" && !strings.HasPrefix(href, "#") && safeURL(href) != "" && strings.TrimSpace(textOf(n)) != href {
+			t.text(" (" + href + ")")
+		}
+		return
+	}
+	t.children(n)
+}
+
+func (t *textWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		t.node(c)
+	}
+}
+
+// text writes text, collapsing its whitespace outside of pre elements.
+func (t *textWriter) text(s string) {
+	if t.pre > 0 {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				t.newlines++
+			}
+			if line != "" {
+				t.raw(line)
+			}
+		}
+		return
+	}
+
+	if s == "" {
+		return
+	}
+	leading := strings.TrimLeft(s, " \t\n\r\f") != s
+	trailing := strings.TrimRight(s, " \t\n\r\f") != s
+	s = spaces.ReplaceAllString(strings.TrimSpace(s), " ")
+	if leading {
+		t.space = true
+	}
+	if s != "" {
+		t.raw(s)
+		t.space = trailing
+	}
+}
+
+// raw writes s as is, after the line breaks and space held back. Spaces
+// are dropped at the start of lines.
+func (t *textWriter) raw(s string) {
+	if written := t.b.String(); written != "" {
+		if t.newlines > 0 {
+			t.b.WriteString(strings.Repeat("\n", t.newlines))
+		} else if t.space && !strings.HasSuffix(written, "\n") {
+			t.b.WriteByte(' ')
+		}
+	}
+	t.newlines, t.space = 0, false
+	t.b.WriteString(s)
+}
+
+// breakLines holds back n line breaks, or as many as held back already if
+// more.
+func (t *textWriter) breakLines(n int) {
+	t.newlines = max(t.newlines, n)
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textOf returns the text of the descendants of a node.
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// LogAccess appends an entry to the access log of a clipboard.
+// It sets the timestamp of the entry. Read-only databases log nothing.
+func (s *service) LogAccess(ctx context.Context, e *clipboard.AccessEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	e.CreatedAt = time.Now().UTC()
+	if s.readOnly {
+		return nil
+	}
+
+	result, err := s.stmts.logAccess.ExecContext(ctx, e.ClipboardId, e.Action, e.Outcome, e.IP, e.Device, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.Id = int(id)
+
+	return nil
+}
+
+// AccessLog retrieves the most recent access log entries of a clipboard,
+// newest first.
+func (s *service) AccessLog(ctx context.Context, clipboardId, limit int) ([]clipboard.AccessEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, clipboard_id, action, outcome, ip, device, created_at FROM access_log WHERE clipboard_id = ? ORDER BY id DESC LIMIT ?;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, clipboardId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []clipboard.AccessEntry{}
+	for rows.Next() {
+		var e clipboard.AccessEntry
+		if err := rows.Scan(&e.Id, &e.ClipboardId, &e.Action, &e.Outcome, &e.IP, &e.Device, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// SecurityEventOptions filters and pages the entries returned by
+// SecurityEvents.
+type SecurityEventOptions struct {
+	// Outcomes restricts the events to some of clipboard.SecurityOutcomes;
+	// all of them are returned if it is empty.
+	Outcomes []string
+	// ClipboardId restricts the events to a clipboard if not 0.
+	ClipboardId int
+	// IP restricts the events to a client address if not empty.
+	IP string
+	// Since and Until restrict the events to those recorded at or after
+	// Since and before Until, if they are not zero.
+	Since, Until time.Time
+
+	Limit  int
+	Offset int
+}
+
+// SecurityEvents retrieves the access log entries of failed attempts on all
+// clipboards matching the options, newest first.
+func (s *service) SecurityEvents(ctx context.Context, opts SecurityEventOptions) ([]clipboard.AccessEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	outcomes := opts.Outcomes
+	if len(outcomes) == 0 {
+		outcomes = clipboard.SecurityOutcomes
+	}
+	where := []string{`outcome IN (` + placeholders(len(outcomes)) + `)`}
+	var args []any
+	for _, o := range outcomes {
+		args = append(args, o)
+	}
+
+	if opts.ClipboardId != 0 {
+		where = append(where, `clipboard_id = ?`)
+		args = append(args, opts.ClipboardId)
+	}
+	if opts.IP != "" {
+		where = append(where, `ip = ?`)
+		args = append(args, opts.IP)
+	}
+	if !opts.Since.IsZero() {
+		where = append(where, `created_at >= ?`)
+		args = append(args, opts.Since.UTC())
+	}
+	if !opts.Until.IsZero() {
+		where = append(where, `created_at < ?`)
+		args = append(args, opts.Until.UTC())
+	}
+
+	sqlSelect := `SELECT id, clipboard_id, action, outcome, ip, device, created_at FROM access_log WHERE ` + strings.Join(where, ` AND `) + ` ORDER BY id DESC LIMIT ? OFFSET ?;`
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []clipboard.AccessEntry{}
+	for rows.Next() {
+		var e clipboard.AccessEntry
+		if err := rows.Scan(&e.Id, &e.ClipboardId, &e.Action, &e.Outcome, &e.IP, &e.Device, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
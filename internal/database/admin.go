@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+)
+
+// Stats holds counts and sizes across the whole database.
+type Stats struct {
+	Clipboards          int   `json:"clipboards"`
+	EncryptedClipboards int   `json:"encrypted_clipboards"`
+	LockedClipboards    int   `json:"locked_clipboards"`
+	Bytes               int64 `json:"bytes"`
+	StackItems          int   `json:"stack_items"`
+	StackBytes          int64 `json:"stack_bytes"`
+	Users               int   `json:"users"`
+	Uploads             int   `json:"uploads"`
+	ActiveSessions      int   `json:"active_sessions"`
+}
+
+// UserUsage is the storage a user takes up.
+type UserUsage struct {
+	account.User
+	Clipboards int   `json:"clipboards"`
+	Bytes      int64 `json:"bytes"`
+}
+
+// Stats returns counts and sizes across the whole database.
+func (s *service) Stats(ctx context.Context) (Stats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT
+		(SELECT COUNT(*) FROM clipboards),
+		(SELECT COUNT(*) FROM clipboards WHERE is_encrypted),
+		(SELECT COUNT(*) FROM clipboards WHERE locked),
+		(SELECT COALESCE(SUM(size), 0) FROM clipboards),
+		(SELECT COUNT(*) FROM clipboard_items),
+		(SELECT COALESCE(SUM(size), 0) FROM clipboard_items),
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM uploads WHERE expires_at > ?),
+		(SELECT COUNT(*) FROM sessions WHERE revoked_at IS NULL AND expires_at > ?);`
+
+	var st Stats
+	now := time.Now().UTC()
+	err := s.db.QueryRowContext(ctx, sqlSelect, now, now).Scan(&st.Clipboards, &st.EncryptedClipboards, &st.LockedClipboards, &st.Bytes,
+		&st.StackItems, &st.StackBytes, &st.Users, &st.Uploads, &st.ActiveSessions)
+	return st, err
+}
+
+// UserUsages returns how many clipboards every user owns and how many bytes
+// they and their stack items take up, by namespace and user name.
+func (s *service) UserUsages(ctx context.Context) ([]UserUsage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT u.id, u.name, u.namespace, u.role, u.created_at, u.totp_enabled,
+		(SELECT COUNT(*) FROM clipboards c WHERE c.owner_id = u.id),
+		(SELECT COALESCE(SUM(c.size), 0) FROM clipboards c WHERE c.owner_id = u.id) +
+		(SELECT COALESCE(SUM(i.size), 0) FROM clipboard_items i JOIN clipboards c ON c.id = i.clipboard_id WHERE c.owner_id = u.id)
+		FROM users u ORDER BY u.namespace, u.name;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []UserUsage{}
+	for rows.Next() {
+		var u UserUsage
+		if err := rows.Scan(&u.Id, &u.Name, &u.Namespace, &u.Role, &u.CreatedAt, &u.TOTPEnabled, &u.Clipboards, &u.Bytes); err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+
+	return usages, rows.Err()
+}
+
+// OwnedClipboards retrieves the ids of the clipboards owned by a user.
+func (s *service) OwnedClipboards(ctx context.Context, ownerId int) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id FROM clipboards WHERE owner_id = ? ORDER BY id;`
+
+	return s.queryIds(ctx, sqlSelect, ownerId)
+}
+
+// SetLocked locks or unlocks a clipboard.
+// It returns false if the clipboard does not exist.
+func (s *service) SetLocked(ctx context.Context, id int, locked bool) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	sqlUpdate := `UPDATE clipboards SET locked = ? WHERE id = ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlUpdate, locked, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrAliasNotFound is returned when an alias does not exist.
+var ErrAliasNotFound = notFound("alias not found")
+
+// aliasColumns lists the columns scanned by scanAlias, in order.
+const aliasColumns = `name, clipboard_id, namespace, created_at, updated_at`
+
+// AliasByName retrieves an alias by its name within a namespace.
+// It returns ErrAliasNotFound if the alias does not exist.
+func (s *service) AliasByName(ctx context.Context, namespace, name string) (*clipboard.Alias, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + aliasColumns + ` FROM clipboard_aliases WHERE namespace = ? AND name = ?;`
+
+	a, err := scanAlias(s.db.QueryRowContext(ctx, sqlSelect, namespace, name))
+	if err == sql.ErrNoRows {
+		return nil, ErrAliasNotFound
+	}
+	return a, err
+}
+
+// SetAlias points an alias at its clipboard, creating it if previous is 0
+// and moving it from the clipboard with id previous otherwise. It sets the
+// timestamps of the alias.
+// It returns ErrNameTaken if the alias was created or moved concurrently.
+func (s *service) SetAlias(ctx context.Context, a *clipboard.Alias, previous int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO clipboard_aliases (name, clipboard_id, namespace, created_at, updated_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING;`
+	sqlUpdate := `UPDATE clipboard_aliases SET clipboard_id = ?, updated_at = ? WHERE namespace = ? AND name = ? AND clipboard_id = ? RETURNING created_at;`
+
+	a.UpdatedAt = time.Now().UTC()
+
+	if previous == 0 {
+		a.CreatedAt = a.UpdatedAt
+		result, err := s.db.ExecContext(ctx, sqlInsert, a.Name, a.ClipboardId, a.Namespace, a.CreatedAt, a.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrNameTaken
+			}
+			return err
+		}
+		return nil
+	}
+
+	err := s.db.QueryRowContext(ctx, sqlUpdate, a.ClipboardId, a.UpdatedAt, a.Namespace, a.Name, previous).Scan(&a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNameTaken
+	}
+	return err
+}
+
+// Aliases retrieves the aliases of a clipboard, sorted by name.
+func (s *service) Aliases(ctx context.Context, clipboardId int) ([]*clipboard.Alias, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + aliasColumns + ` FROM clipboard_aliases WHERE clipboard_id = ? ORDER BY name;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, clipboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	as := []*clipboard.Alias{}
+	for rows.Next() {
+		a, err := scanAlias(rows)
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+
+	return as, rows.Err()
+}
+
+// DeleteAlias deletes an alias of a clipboard.
+// It returns false if the clipboard has no such alias.
+func (s *service) DeleteAlias(ctx context.Context, clipboardId int, name string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_aliases WHERE clipboard_id = ? AND name = ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, clipboardId, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanAlias(row scanner) (*clipboard.Alias, error) {
+	var a clipboard.Alias
+	if err := row.Scan(&a.Name, &a.ClipboardId, &a.Namespace, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
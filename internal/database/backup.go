@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"io"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrClipboardExists is returned when restoring a clipboard whose id is taken.
+var ErrClipboardExists = conflict("clipboard already exists")
+
+// Restore inserts a clipboard from a backup as is. It keeps the id of the
+// clipboard unless it is 0, its timestamps, version and, for encrypted
+// clipboards, its ciphertext, password hash, salt, nonce and KDF parameters.
+// If data is not nil, it is streamed into the blob store instead of storing
+// the data of c. It sets the stored size and the metadata of the clipboard.
+// It returns ErrClipboardExists if the id is taken.
+func (s *service) Restore(ctx context.Context, c *clipboard.Clipboard, data io.Reader) error {
+	var blobKey sql.NullString
+	var stored string
+	if data != nil {
+		key, err := blob.NewKey()
+		if err != nil {
+			return err
+		}
+		counter := &countingReader{r: data}
+		sealed, err := s.keyring.SealReader(counter)
+		if err != nil {
+			return err
+		}
+		if _, err := s.blobs.Put(key, sealed); err != nil {
+			s.deleteBlob(key)
+			return err
+		}
+		blobKey = sql.NullString{String: key, Valid: true}
+		c.Size = int(counter.n)
+	} else {
+		var err error
+		if stored, err = s.keyring.Seal(c.Data); err != nil {
+			return err
+		}
+		c.Size = c.DataSize()
+		c.Hash = c.ContentHash()
+		extractMetadata(c)
+	}
+	c.Refs = 1
+
+	// Like WriteData, only the queries after streaming the data are bounded.
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.insertRestored(ctx, c, stored, blobKey); err != nil {
+		s.deleteBlob(blobKey.String)
+		return err
+	}
+
+	c.LastReadAt = c.UpdatedAt
+	c.Version = max(c.Version, 1)
+	c.Streamed = blobKey.Valid
+	c.BlobKey = blobKey.String
+	if c.Streamed {
+		c.Data = ""
+	}
+
+	return nil
+}
+
+// insertRestored inserts the row of a restored clipboard, its tags, flavors and size after
+// checking that its id is free, and sets the id of new clipboards. Clipboards keep
+// their public id and share code unless missing or taken, and drop the tombstone
+// of the public id.
+func (s *service) insertRestored(ctx context.Context, c *clipboard.Clipboard, data string, blobKey sql.NullString) error {
+	sqlInsert := `INSERT INTO clipboards (id, public_id, code, name, type, data, sealed, filename, content_disposition, charset, locale, is_encrypted, password_hash, salt, nonce, kdf, encryption_scope, sealed_fields, wrapped_key, created_at, updated_at, last_read_at, owner_id, size, blob_key, version, pinned, read_only, transforms, content_hash, namespace, metadata, quarantine, publish_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	sqlExists := `SELECT EXISTS (SELECT 1 FROM clipboards WHERE id = ?);`
+	sqlPublicIdExists := `SELECT EXISTS (SELECT 1 FROM clipboards WHERE public_id = ?);`
+	sqlCodeExists := `SELECT EXISTS (SELECT 1 FROM clipboards WHERE code = ?);`
+	sqlDeleteTombstone := `DELETE FROM clipboard_tombstones WHERE public_id = ?;`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if c.Id != 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, sqlExists, c.Id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrClipboardExists
+		}
+	}
+
+	if c.Namespace == "" {
+		c.Namespace = account.DefaultNamespace
+	}
+	if clipboard.IsPublicId(c.PublicId) {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, sqlPublicIdExists, c.PublicId).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			c.PublicId = ""
+		}
+	} else {
+		c.PublicId = ""
+	}
+	if c.PublicId == "" {
+		if c.PublicId, err = clipboard.NewPublicId(); err != nil {
+			return err
+		}
+	}
+	if clipboard.IsCode(c.Code) {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, sqlCodeExists, c.Code).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			c.Code = ""
+		}
+	} else {
+		c.Code = ""
+	}
+	if c.Code == "" {
+		if c.Code, err = newCode(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, sqlInsert, nullInt(c.Id), c.PublicId, c.Code, c.Name, c.DataType, data, s.sealed(), nullString(c.Filename), nullString(c.ContentDisposition), nullString(c.Charset), nullString(c.Locale), c.IsEncrypted, nullString(c.PasswordHash), nullString(c.Salt), nullString(c.Nonce), nullString(c.KDF), nullString(c.EncryptionScope), nullString(c.SealedFields), nullString(c.WrappedKey),
+		c.CreatedAt, c.UpdatedAt, c.UpdatedAt, nullInt(c.OwnerId), c.Size, blobKey, max(c.Version, 1), c.Pinned, c.ReadOnly, joinTransforms(c.Transforms), nullString(c.Hash), c.Namespace, marshalMetadata(c.Metadata), nullString(c.Quarantine), nullTime(c.PublishAt), nullTime(c.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.Id = int(id)
+
+	if _, err := tx.ExecContext(ctx, sqlDeleteTombstone, c.PublicId); err != nil {
+		return err
+	}
+	if err := insertTags(ctx, tx, c.Id, c.Tags); err != nil {
+		return err
+	}
+	if err := s.writeFlavors(ctx, tx, c); err != nil {
+		return err
+	}
+	if err := recordSize(ctx, tx, c.Id, max(c.Version, 1), int64(c.Size), c.UpdatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nullString maps empty optional strings to NULL.
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// blobChunkSize is the size of the chunks blobs are stored in by chunkStore.
+const blobChunkSize = 256 << 10
+
+// chunkStore stores blobs in the blob_chunks table, so streaming needs no
+// storage besides the database. Chunks are written outside of a transaction
+// to not block other writers during slow uploads; a blob is only used once a
+// clipboard references it.
+type chunkStore struct {
+	db *sql.DB
+}
+
+func (c chunkStore) Put(key string, r io.Reader) (int64, error) {
+	sqlInsert := `INSERT INTO blob_chunks (blob_key, seq, data) VALUES (?, ?, ?);`
+
+	buf := make([]byte, blobChunkSize)
+	var total int64
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := c.db.Exec(sqlInsert, key, seq, buf[:n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func (c chunkStore) Open(key string) (io.ReadCloser, error) {
+	var chunks int
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM blob_chunks WHERE blob_key = ?;`, key).Scan(&chunks); err != nil {
+		return nil, err
+	}
+	return &chunkReader{db: c.db, key: key, chunks: chunks}, nil
+}
+
+func (c chunkStore) Delete(key string) error {
+	_, err := c.db.Exec(`DELETE FROM blob_chunks WHERE blob_key = ?;`, key)
+	return err
+}
+
+// Shred deletes the chunks of a blob on a secureConn, overwriting them.
+func (c chunkStore) Shred(key string) error {
+	conn, err := openSecureConn(context.Background(), c.db)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(context.Background(), `DELETE FROM blob_chunks WHERE blob_key = ?;`, key)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// chunkReader reads a blob one chunk at a time.
+type chunkReader struct {
+	db     *sql.DB
+	key    string
+	chunks int
+	seq    int
+	buf    []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.seq == c.chunks {
+			return 0, io.EOF
+		}
+		err := c.db.QueryRow(`SELECT data FROM blob_chunks WHERE blob_key = ? AND seq = ?;`, c.key, c.seq).Scan(&c.buf)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("blob %s: %w", c.key, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return 0, err
+		}
+		c.seq++
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkReader) Close() error {
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteData streams the data of a clipboard into a new blob, replacing its
+// previous data if the version of the clipboard still matches the version of
+// c, and increments the version. The data is expected to be encrypted
+// already if the clipboard is. It stores the type, filename and disposition
+// of c, sets the update timestamp and the stored size of the clipboard, and
+// drops its flavors and metadata.
+// It returns ErrVersionConflict if the clipboard was changed or deleted
+// meanwhile.
+func (s *service) WriteData(ctx context.Context, c *clipboard.Clipboard, r io.Reader) error {
+	defer s.cache.invalidate(c.Id)
+
+	sqlSelect := `SELECT blob_key FROM clipboards WHERE id = ? AND version = ?;`
+	sqlUpdate := `UPDATE clipboards SET type = ?, data = '', sealed = ?, nonce = ?, sealed_fields = ?, filename = ?, content_disposition = ?, charset = ?, locale = ?, quarantine = ?, blob_key = ?, updated_at = ?, size = ?, content_hash = NULL, metadata = NULL, version = version + 1 WHERE id = ?;`
+	sqlDeleteFlavors := `DELETE FROM clipboard_flavors WHERE clipboard_id = ?;`
+
+	key, err := blob.NewKey()
+	if err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: r}
+	sealed, err := s.keyring.SealReader(counter)
+	if err != nil {
+		return err
+	}
+	if _, err := s.blobs.Put(key, sealed); err != nil {
+		s.deleteBlob(key)
+		return err
+	}
+
+	// Uploads take as long as the client needs, only the queries recording
+	// the blob are bounded.
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.deleteBlob(key)
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldKey sql.NullString
+	if err := tx.QueryRowContext(ctx, sqlSelect, c.Id, c.Version).Scan(&oldKey); err != nil {
+		s.deleteBlob(key)
+		if err == sql.ErrNoRows {
+			return ErrVersionConflict
+		}
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, sqlUpdate, c.DataType, s.sealed(), c.Nonce, nullString(c.SealedFields), nullString(c.Filename), nullString(c.ContentDisposition), nullString(c.Charset), nullString(c.Locale), nullString(c.Quarantine), key, updatedAt, counter.n, c.Id); err != nil {
+		s.deleteBlob(key)
+		return err
+	}
+	if err := recordSize(ctx, tx, c.Id, c.Version+1, counter.n, updatedAt); err != nil {
+		s.deleteBlob(key)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlDeleteFlavors, c.Id); err != nil {
+		s.deleteBlob(key)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		s.deleteBlob(key)
+		return err
+	}
+
+	s.deleteBlob(oldKey.String)
+
+	c.Data = ""
+	c.Flavors = nil
+	c.Size = int(counter.n)
+	c.UpdatedAt = updatedAt
+	c.Streamed = true
+	c.Hash = ""
+	c.Metadata = nil
+	c.BlobKey = key
+	c.Version++
+
+	return nil
+}
+
+// OpenData returns a reader of the stored data of a clipboard, still
+// encrypted if the clipboard is.
+// Data that is not streamed is read from the clipboard itself.
+func (s *service) OpenData(ctx context.Context, c *clipboard.Clipboard) (io.ReadCloser, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if !c.Streamed {
+		return io.NopCloser(strings.NewReader(c.Data)), nil
+	}
+
+	var sealed bool
+	err := s.db.QueryRowContext(ctx, `SELECT sealed FROM clipboards WHERE id = ? AND blob_key = ?;`, c.Id, c.BlobKey).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("clipboard data was replaced")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := s.blobs.Open(c.BlobKey)
+	if err != nil || !sealed {
+		return rc, err
+	}
+
+	r, _, err := s.keyring.OpenReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return readCloser{r, rc}, nil
+}
+
+// DataURL returns a presigned URL of the blob of a streamed clipboard, served
+// with its content disposition, if the blob store supports them. Blobs sealed at rest are never handed out,
+// since the store only holds their ciphertext.
+func (s *service) DataURL(ctx context.Context, c *clipboard.Clipboard, contentType string, ttl time.Duration) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	presigner, ok := s.blobs.(blob.Presigner)
+	if !ok || !c.Streamed {
+		return "", nil
+	}
+
+	var sealed bool
+	err := s.db.QueryRowContext(ctx, `SELECT sealed FROM clipboards WHERE id = ? AND blob_key = ?;`, c.Id, c.BlobKey).Scan(&sealed)
+	if err == sql.ErrNoRows || sealed {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return presigner.PresignGet(c.BlobKey, contentType, c.DispositionHeader(), ttl)
+}
+
+// readCloser reads from a reader layered on top of a closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// deleteBlob deletes a blob that is no longer referenced, logging failures
+// since the clipboard change it belongs to has already succeeded or failed.
+func (s *service) deleteBlob(key string) {
+	if key == "" {
+		return
+	}
+	if err := s.blobs.Delete(key); err != nil {
+		log.Printf("error deleting blob %s: %v", key, err)
+	}
+}
+
+// shredBlob overwrites and deletes a blob where the store can, see
+// blob.Shred. Errors are only logged, like those of deleteBlob.
+func (s *service) shredBlob(key string) {
+	if key == "" {
+		return
+	}
+	if err := blob.Shred(s.blobs, key); err != nil {
+		log.Printf("error shredding blob %s: %v", key, err)
+	}
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+var (
+	// ErrDirectUploads is returned when creating a direct upload while the
+	// blob store cannot hand out URLs, or data is sealed at rest and must
+	// pass through the server.
+	ErrDirectUploads = errors.New("direct uploads are not supported")
+	// ErrUploadIncomplete is returned when committing a direct upload whose
+	// blob has not been stored completely.
+	ErrUploadIncomplete = conflict("upload incomplete")
+)
+
+// presigner returns the blob store if clients may transfer blobs with it
+// directly.
+func (s *service) presigner() (blob.Presigner, bool) {
+	presigner, ok := s.blobs.(blob.Presigner)
+	return presigner, ok && !s.sealed()
+}
+
+// CreateBlobUpload stores a new direct upload and sets its id, blob key and
+// the presigned URL its data is uploaded to, which expires after ttl.
+func (s *service) CreateBlobUpload(ctx context.Context, u *clipboard.BlobUpload, ttl time.Duration) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO blob_uploads (id, clipboard_id, version, blob_key, type, size, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+
+	presigner, ok := s.presigner()
+	if !ok {
+		return ErrDirectUploads
+	}
+
+	var err error
+	if u.Id, err = clipboard.NewUploadId(); err != nil {
+		return err
+	}
+	if u.BlobKey, err = blob.NewKey(); err != nil {
+		return err
+	}
+	if u.URL, err = presigner.PresignPut(u.BlobKey, u.DataType, u.Size, ttl); err != nil {
+		return err
+	}
+	u.Headers = map[string]string{"Content-Type": u.DataType}
+
+	_, err = s.db.ExecContext(ctx, sqlInsert, u.Id, u.ClipboardId, u.Version, u.BlobKey, u.DataType, u.Size, time.Now().UTC(), u.ExpiresAt)
+	return err
+}
+
+// GetBlobUpload retrieves a direct upload by its id. It returns
+// ErrUploadNotFound if the upload does not exist or has expired.
+func (s *service) GetBlobUpload(ctx context.Context, id string) (*clipboard.BlobUpload, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, clipboard_id, version, blob_key, type, size, expires_at FROM blob_uploads WHERE id = ? AND expires_at > ?;`
+
+	var u clipboard.BlobUpload
+	err := s.db.QueryRowContext(ctx, sqlSelect, id, time.Now().UTC()).
+		Scan(&u.Id, &u.ClipboardId, &u.Version, &u.BlobKey, &u.DataType, &u.Size, &u.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CommitBlobUpload replaces the data of a clipboard with the blob of a
+// direct upload if the clipboard still has the version the upload was
+// created for, and increments the version. Like WriteData, it sets the type,
+// update timestamp and stored size of the clipboard, and drops its flavors
+// and metadata. The blob must have the size announced for the upload.
+func (s *service) CommitBlobUpload(ctx context.Context, c *clipboard.Clipboard, u *clipboard.BlobUpload) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(c.Id)
+
+	sqlSelect := `SELECT blob_key FROM clipboards WHERE id = ? AND version = ?;`
+	sqlUpdate := `UPDATE clipboards SET type = ?, data = '', sealed = FALSE, nonce = NULL, blob_key = ?, updated_at = ?, size = ?, content_hash = NULL, metadata = NULL, quarantine = NULL, version = version + 1 WHERE id = ?;`
+	sqlDeleteFlavors := `DELETE FROM clipboard_flavors WHERE clipboard_id = ?;`
+	sqlDeleteUpload := `DELETE FROM blob_uploads WHERE id = ?;`
+
+	presigner, ok := s.presigner()
+	if !ok {
+		return ErrDirectUploads
+	}
+	size, err := presigner.Size(u.BlobKey)
+	if err == blob.ErrNotFound || (err == nil && size != u.Size) {
+		return ErrUploadIncomplete
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldKey sql.NullString
+	if err := tx.QueryRowContext(ctx, sqlSelect, u.ClipboardId, u.Version).Scan(&oldKey); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrVersionConflict
+		}
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, sqlUpdate, u.DataType, u.BlobKey, updatedAt, u.Size, u.ClipboardId); err != nil {
+		return err
+	}
+	if err := recordSize(ctx, tx, u.ClipboardId, u.Version+1, u.Size, updatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlDeleteFlavors, u.ClipboardId); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlDeleteUpload, u.Id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteBlob(oldKey.String)
+	c.DataType = u.DataType
+	c.Data = ""
+	c.Nonce = ""
+	c.Flavors = nil
+	c.Size = int(u.Size)
+	c.UpdatedAt = updatedAt
+	c.Streamed = true
+	c.Hash = ""
+	c.Metadata = nil
+	c.BlobKey = u.BlobKey
+	c.Version = u.Version + 1
+
+	return nil
+}
+
+// DeleteBlobUpload deletes a direct upload and the blob uploaded so far.
+func (s *service) DeleteBlobUpload(ctx context.Context, u *clipboard.BlobUpload) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM blob_uploads WHERE id = ?;`
+
+	if _, err := s.db.ExecContext(ctx, sqlDelete, u.Id); err != nil {
+		return err
+	}
+	s.deleteBlob(u.BlobKey)
+	return nil
+}
+
+// DeleteExpiredBlobUploads deletes the direct uploads that expired before
+// the given time and their blobs, which were never committed.
+// It returns the number of deleted uploads.
+func (s *service) DeleteExpiredBlobUploads(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, blob_key FROM blob_uploads WHERE expires_at <= ?;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var expired []*clipboard.BlobUpload
+	for rows.Next() {
+		var u clipboard.BlobUpload
+		if err := rows.Scan(&u.Id, &u.BlobKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, &u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, u := range expired {
+		if err := s.DeleteBlobUpload(ctx, u); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// cacheEntryOverhead is the size counted for a cached clipboard on top of
+// its data, for its other fields.
+const cacheEntryOverhead = 512
+
+// defaultCacheSize is the default of CLIPBOARD_CACHE_SIZE: 32 MiB, or no
+// cache if other processes share the database file with a job lock, as
+// their writes would go unnoticed until the entries expire.
+func defaultCacheSize() int64 {
+	if env.Bool("JOB_LOCK_ENABLED", false) {
+		return 0
+	}
+	return 32 << 20
+}
+
+// CacheStats describes the clipboard cache, see clipboardCache.
+type CacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// clipboardCache keeps recently read clipboards in memory, so devices
+// polling a clipboard do not query the database every time. Once the
+// cached data exceeds the size of the cache, the least recently used
+// clipboards are evicted. Writes through the service invalidate the
+// clipboards they change; entries also expire after a TTL, which bounds how
+// long writes the service does not see, such as the ones replicated into a
+// read-only database, go unnoticed.
+//
+// A nil cache caches nothing.
+type clipboardCache struct {
+	size int64
+	ttl  time.Duration
+
+	mu         sync.Mutex
+	lru        *list.List
+	byId       map[int]*list.Element
+	byPublicId map[string]int
+	byCode     map[string]int
+	bytes      int64
+	hits       int64
+	misses     int64
+	// generation counts invalidations, so clipboards read before one are
+	// not cached after it.
+	generation uint64
+}
+
+type cacheEntry struct {
+	c       *clipboard.Clipboard
+	size    int64
+	expires time.Time
+}
+
+// newClipboardCache returns a cache of up to size bytes of clipboards, or
+// nil if size is not positive.
+func newClipboardCache(size int64, ttl time.Duration) *clipboardCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &clipboardCache{
+		size:       size,
+		ttl:        ttl,
+		lru:        list.New(),
+		byId:       make(map[int]*list.Element),
+		byPublicId: make(map[string]int),
+		byCode:     make(map[string]int),
+	}
+}
+
+// get returns a copy of the cached clipboard with the given id, or nil.
+func (cc *clipboardCache) get(id int) *clipboard.Clipboard {
+	if cc == nil {
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.lookup(id, true)
+}
+
+// getByPublicId returns a copy of the cached clipboard with the given
+// public id, or nil.
+func (cc *clipboardCache) getByPublicId(publicId string) *clipboard.Clipboard {
+	if cc == nil {
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	id, ok := cc.byPublicId[publicId]
+	return cc.lookup(id, ok)
+}
+
+// getByCode returns a copy of the cached clipboard with the given share
+// code, or nil.
+func (cc *clipboardCache) getByCode(code string) *clipboard.Clipboard {
+	if cc == nil {
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	id, ok := cc.byCode[code]
+	return cc.lookup(id, ok)
+}
+
+// lookup returns a copy of an unexpired entry and counts the hit or miss.
+// cc.mu must be held.
+func (cc *clipboardCache) lookup(id int, ok bool) *clipboard.Clipboard {
+	var el *list.Element
+	if ok {
+		el = cc.byId[id]
+	}
+	if el == nil {
+		cc.misses++
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		cc.remove(el)
+		cc.misses++
+		return nil
+	}
+
+	cc.lru.MoveToFront(el)
+	cc.hits++
+	return e.c.Clone()
+}
+
+// current returns the generation to pass to put for a clipboard about to be
+// read from the database.
+func (cc *clipboardCache) current() uint64 {
+	if cc == nil {
+		return 0
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.generation
+}
+
+// put caches a copy of a clipboard read from the database, unless a
+// clipboard was invalidated since generation, when the clipboard may have
+// been read before the write, or it alone exceeds the size of the cache.
+func (cc *clipboardCache) put(c *clipboard.Clipboard, generation uint64) {
+	if cc == nil {
+		return
+	}
+	size := int64(len(c.Data)) + cacheEntryOverhead
+	for _, f := range c.Flavors {
+		size += int64(len(f.Data))
+	}
+	if size > cc.size {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if generation != cc.generation {
+		return
+	}
+	if el := cc.byId[c.Id]; el != nil {
+		cc.remove(el)
+	}
+	cc.byId[c.Id] = cc.lru.PushFront(&cacheEntry{c: c.Clone(), size: size, expires: time.Now().Add(cc.ttl)})
+	if c.PublicId != "" {
+		cc.byPublicId[c.PublicId] = c.Id
+	}
+	if c.Code != "" {
+		cc.byCode[c.Code] = c.Id
+	}
+	cc.bytes += size
+
+	for cc.bytes > cc.size {
+		cc.remove(cc.lru.Back())
+	}
+}
+
+// touch sets the last read time of a cached clipboard, which reads change
+// without invalidating it.
+func (cc *clipboardCache) touch(id int, t time.Time) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if el := cc.byId[id]; el != nil {
+		el.Value.(*cacheEntry).c.LastReadAt = t
+	}
+}
+
+// invalidate drops a clipboard that is being changed.
+func (cc *clipboardCache) invalidate(id int) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.generation++
+	if el := cc.byId[id]; el != nil {
+		cc.remove(el)
+	}
+}
+
+// clear drops all clipboards, for writes changing many of them.
+func (cc *clipboardCache) clear() {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.generation++
+	cc.lru.Init()
+	clear(cc.byId)
+	clear(cc.byPublicId)
+	clear(cc.byCode)
+	cc.bytes = 0
+}
+
+// remove drops an entry. cc.mu must be held.
+func (cc *clipboardCache) remove(el *list.Element) {
+	e := cc.lru.Remove(el).(*cacheEntry)
+	delete(cc.byId, e.c.Id)
+	delete(cc.byPublicId, e.c.PublicId)
+	delete(cc.byCode, e.c.Code)
+	cc.bytes -= e.size
+}
+
+// CacheStats returns the size and hit rate of the clipboard cache.
+func (s *service) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// stats returns the size and hit rate of the cache.
+func (cc *clipboardCache) stats() CacheStats {
+	if cc == nil {
+		return CacheStats{}
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return CacheStats{Enabled: true, Entries: cc.lru.Len(), Bytes: cc.bytes, Hits: cc.hits, Misses: cc.misses}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ChangeOptions filters and pages the changes returned by Changes.
+type ChangeOptions struct {
+	// Since and Until restrict the changes to those after Since and up to
+	// Until, if it is not 0.
+	Since, Until int64
+	// OwnerId restricts the changes to the clipboards of a user and the ones
+	// shared with them. Owner 0 sees the changes of anonymous clipboards.
+	OwnerId int
+	// Namespace restricts the changes to the clipboards of a namespace.
+	Namespace string
+
+	Limit int
+}
+
+// RecordChange appends a change to the changefeed and sets its sequence
+// number and time. Read-only databases record nothing.
+func (s *service) RecordChange(ctx context.Context, c *clipboard.Change) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO clipboard_changes (clipboard_id, public_id, op, owner_id, namespace, created_at) VALUES (?, ?, ?, ?, ?, ?);`
+
+	c.CreatedAt = time.Now().UTC()
+	if s.readOnly {
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx, sqlInsert, c.ClipboardId, nullString(c.PublicId), c.Op, nullInt(c.OwnerId), c.Namespace, c.CreatedAt)
+	if err != nil {
+		return err
+	}
+	c.Seq, err = result.LastInsertId()
+	return err
+}
+
+// Changes retrieves the changes after opts.Since visible to opts.OwnerId in
+// opts.Namespace, oldest first. Changes of clipboards shared with the user
+// are only visible while the clipboard is still shared, so deletions of
+// shared clipboards are only seen by their owner.
+func (s *service) Changes(ctx context.Context, opts ChangeOptions) ([]clipboard.Change, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT seq, clipboard_id, public_id, op, owner_id, namespace, created_at FROM clipboard_changes
+		WHERE seq > ? AND (? = 0 OR seq <= ?) AND namespace = ?`
+	args := []any{opts.Since, opts.Until, opts.Until, opts.Namespace}
+	if opts.OwnerId == 0 {
+		sqlSelect += ` AND owner_id IS NULL`
+	} else {
+		sqlSelect += ` AND (owner_id = ? OR clipboard_id IN (SELECT clipboard_id FROM clipboard_permissions WHERE user_id = ?))`
+		args = append(args, opts.OwnerId, opts.OwnerId)
+	}
+	sqlSelect += ` ORDER BY seq LIMIT ?;`
+	args = append(args, opts.Limit)
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []clipboard.Change{}
+	for rows.Next() {
+		var c clipboard.Change
+		var publicId sql.NullString
+		var ownerId sql.NullInt64
+		if err := rows.Scan(&c.Seq, &c.ClipboardId, &publicId, &c.Op, &ownerId, &c.Namespace, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.PublicId = publicId.String
+		c.OwnerId = int(ownerId.Int64)
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// ChangeBounds returns the sequence number of the last pruned change, after
+// which all changes are kept, and of the last recorded change. Both are 0
+// before the first change.
+func (s *service) ChangeBounds(ctx context.Context) (int64, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// The sequence keeps the last number handed out when all changes are
+	// pruned.
+	sqlSelect := `SELECT COALESCE((SELECT MIN(seq) - 1 FROM clipboard_changes), (SELECT seq FROM sqlite_sequence WHERE name = 'clipboard_changes'), 0),
+		COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'clipboard_changes'), 0);`
+
+	var pruned, last int64
+	err := s.db.QueryRowContext(ctx, sqlSelect).Scan(&pruned, &last)
+	return pruned, last, err
+}
+
+// PruneChanges deletes the changes recorded before the given time.
+func (s *service) PruneChanges(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_changes WHERE created_at < ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
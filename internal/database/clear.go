@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// Clear updates a clipboard emptied by the caller like Update, and deletes
+// what is left of its previous data: its revisions, conflict copies, stack
+// items, thumbnail and streamed data. Its name, tags, permissions, tokens
+// and aliases are kept. If secure is set, the deleted data is overwritten
+// in the database and in the blob store where possible, see secureConn and
+// blob.Shred.
+// It returns ErrVersionConflict if the clipboard changed since c was
+// retrieved.
+func (s *service) Clear(ctx context.Context, c *clipboard.Clipboard, secure bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(c.Id)
+
+	begin := s.db.BeginTx
+	if secure {
+		conn, err := openSecureConn(ctx, s.db)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := conn.Close(); err != nil {
+				log.Printf("error clearing clipboard %d securely: %v", c.Id, err)
+			}
+		}()
+		begin = conn.BeginTx
+	}
+
+	tx, err := begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	oldKey, err := s.clearTx(ctx, tx, c)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if secure {
+		s.shredBlob(oldKey)
+	} else {
+		s.deleteBlob(oldKey)
+	}
+	c.BlobKey = ""
+	c.Version++
+
+	return nil
+}
+
+// clearTx clears a clipboard as described by Clear in a transaction. It
+// returns the key of the blob its streamed data was kept in, to be deleted
+// after the commit.
+func (s *service) clearTx(ctx context.Context, tx *sql.Tx, c *clipboard.Clipboard) (string, error) {
+	sqlDeleteRevisions := `DELETE FROM clipboard_revisions WHERE clipboard_id = ? AND version <= ?;`
+	sqlDeleteConflicts := `DELETE FROM clipboard_conflicts WHERE clipboard_id = ?;`
+	sqlDeleteItems := `DELETE FROM clipboard_items WHERE clipboard_id = ?;`
+	sqlDeleteThumbnail := `DELETE FROM clipboard_thumbnails WHERE clipboard_id = ?;`
+
+	oldKey, err := s.updateTx(ctx, tx, c)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, sqlDeleteRevisions, c.Id, c.Version); err != nil {
+		return "", err
+	}
+	for _, stmt := range []string{sqlDeleteConflicts, sqlDeleteItems, sqlDeleteThumbnail} {
+		if _, err := tx.ExecContext(ctx, stmt, c.Id); err != nil {
+			return "", err
+		}
+	}
+
+	return oldKey, nil
+}
+
+// secureConn is a connection on which SQLite overwrites the content it
+// deletes with zeros, see PRAGMA secure_delete, for data that must not be
+// recoverable from the database file.
+type secureConn struct {
+	*sql.Conn
+}
+
+func openSecureConn(ctx context.Context, db *sql.DB) (secureConn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return secureConn{}, err
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA secure_delete = ON;`); err != nil {
+		conn.Close()
+		return secureConn{}, err
+	}
+	return secureConn{conn}, nil
+}
+
+// Close checkpoints and truncates the write-ahead log, which still holds
+// the pages as they were before, restores the default and returns the
+// connection to the pool. Pages the log still holds for open readers are
+// only overwritten by a later checkpoint.
+func (c secureConn) Close() error {
+	ctx := context.Background()
+	_, err := c.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE);`)
+	if _, resetErr := c.ExecContext(ctx, `PRAGMA secure_delete = OFF;`); err == nil {
+		err = resetErr
+	}
+	if closeErr := c.Conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// Overwrite updates a clipboard like Update and stores the data it replaces
+// as a conflict copy in the same transaction, setting the id and creation
+// time of the conflict. Only the newest conflict copies are kept.
+func (s *service) Overwrite(ctx context.Context, c *clipboard.Clipboard, conflict *clipboard.Conflict) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	conflict.ClipboardId = c.Id
+	conflict.CreatedAt = time.Now().UTC()
+	return s.update(ctx, c, conflict)
+}
+
+// Revision retrieves the data a text clipboard had at a version, if it is
+// still kept.
+func (s *service) Revision(ctx context.Context, clipboardId, version int) (string, bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT data, sealed FROM clipboard_revisions WHERE clipboard_id = ? AND version = ?;`
+
+	var data string
+	var sealed bool
+	err := s.db.QueryRowContext(ctx, sqlSelect, clipboardId, version).Scan(&data, &sealed)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	data, err = s.open(data, sealed)
+	return data, err == nil, err
+}
+
+// Conflicts retrieves the conflict copies of a clipboard, newest first.
+func (s *service) Conflicts(ctx context.Context, clipboardId int) ([]*clipboard.Conflict, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, clipboard_id, version, type, data, sealed, created_at FROM clipboard_conflicts WHERE clipboard_id = ? ORDER BY id DESC;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, clipboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := []*clipboard.Conflict{}
+	for rows.Next() {
+		var c clipboard.Conflict
+		var sealed bool
+		if err := rows.Scan(&c.Id, &c.ClipboardId, &c.Version, &c.DataType, &c.Data, &sealed, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if c.Data, err = s.open(c.Data, sealed); err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, &c)
+	}
+
+	return conflicts, rows.Err()
+}
+
+// DeleteConflict deletes a conflict copy of a clipboard, once it has been
+// resolved.
+func (s *service) DeleteConflict(ctx context.Context, clipboardId, conflictId int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_conflicts WHERE id = ? AND clipboard_id = ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, conflictId, clipboardId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// saveRevision keeps the data of an unencrypted text clipboard at a version
+// as the base of later three-way merges, and drops revisions older than
+// the merge history of the service.
+func (s *service) saveRevision(ctx context.Context, tx *sql.Tx, c *clipboard.Clipboard, version int) error {
+	sqlInsert := `INSERT OR REPLACE INTO clipboard_revisions (clipboard_id, version, data, sealed) VALUES (?, ?, ?, ?);`
+	sqlPrune := `DELETE FROM clipboard_revisions WHERE clipboard_id = ? AND version <= ?;`
+
+	if s.mergeHistory <= 0 || !c.Mergeable() {
+		_, err := tx.ExecContext(ctx, sqlPrune, c.Id, version)
+		return err
+	}
+
+	data, err := s.keyring.Seal(c.Data)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlInsert, c.Id, version, data, s.sealed()); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, sqlPrune, c.Id, version-s.mergeHistory)
+	return err
+}
+
+// insertConflict stores a conflict copy and drops all but the newest
+// conflict copies of its clipboard, as many as the merge history.
+func (s *service) insertConflict(ctx context.Context, tx *sql.Tx, c *clipboard.Conflict) error {
+	sqlInsert := `INSERT INTO clipboard_conflicts (clipboard_id, version, type, data, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?);`
+	sqlPrune := `DELETE FROM clipboard_conflicts WHERE clipboard_id = ? AND id NOT IN (SELECT id FROM clipboard_conflicts WHERE clipboard_id = ? ORDER BY id DESC LIMIT ?);`
+
+	data, err := s.keyring.Seal(c.Data)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, sqlInsert, c.ClipboardId, c.Version, c.DataType, data, s.sealed(), c.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.Id = int(id)
+
+	_, err = tx.ExecContext(ctx, sqlPrune, c.ClipboardId, c.ClipboardId, max(s.mergeHistory, 1))
+	return err
+}
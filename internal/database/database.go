@@ -3,114 +3,776 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/blob"
 	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/masterkey"
 
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Service represents a service that interacts with a database.
+// Methods give up when their context is done, and queries answering requests also after DB_QUERY_TIMEOUT.
 type Service interface {
 	// Health returns a map of health status information.
-	// The keys and values in the map are service-specific.
-	Health() map[string]string
+	// The keys and values in the map are service-specific, the "status" key is "up" or "down".
+	Health(ctx context.Context) map[string]string
 
-	// Insert inserts a new clipboard into the database.
+	// ProbeStorage times a write, read and delete of a small blob in the storage of streamed clipboards.
+	// The "storage_status" key is "up", "down", or "skipped" if the storage is a read-only database.
+	// Results are reused for a few seconds, so frequent health checks do not keep writing to the storage.
+	ProbeStorage() map[string]string
+
+	// Insert inserts a new clipboard into the database, under its id if it is set.
+	// It returns ErrClipboardExists if the id is taken.
 	// It returns an error if the insertion fails.
-	Insert(c *clipboard.Clipboard) error
+	Insert(ctx context.Context, c *clipboard.Clipboard) error
 
 	// Get retrieves a clipboard from the database by its id.
-	// It returns nil if the clipboard does not exist.
+	// It returns ErrClipboardNotFound if the clipboard does not exist.
+	// It returns an error if the retrieval fails.
+	Get(ctx context.Context, id int) (*clipboard.Clipboard, error)
+
+	// GetByCode retrieves a clipboard from the database by its share code.
+	// It returns ErrClipboardNotFound if the clipboard does not exist.
+	GetByCode(ctx context.Context, code string) (*clipboard.Clipboard, error)
+	// GetByPublicId retrieves a clipboard from the database by its public id.
+	// It returns ErrClipboardNotFound if the clipboard does not exist.
+	// It returns an error if the retrieval fails.
+	GetByPublicId(ctx context.Context, publicId string) (*clipboard.Clipboard, error)
+
+	// Update updates an existing clipboard in the database if it is still at the version of c.
+	// It returns ErrVersionConflict if it is not.
+	// It returns an error if the update fails.
+	Update(ctx context.Context, c *clipboard.Clipboard) error
+
+	// Overwrite updates a clipboard like Update and keeps the data it replaces as a conflict copy.
+	// It returns ErrVersionConflict if the clipboard is not at the version of c.
+	// It returns an error if the update fails.
+	Overwrite(ctx context.Context, c *clipboard.Clipboard, conflict *clipboard.Conflict) error
+
+	// Revision retrieves the data a text clipboard had at a recent version.
+	// It returns false if the version is not kept.
+	// It returns an error if the retrieval fails.
+	Revision(ctx context.Context, clipboardId, version int) (string, bool, error)
+
+	// Conflicts retrieves the conflict copies of a clipboard, newest first.
+	// It returns an error if the retrieval fails.
+	Conflicts(ctx context.Context, clipboardId int) ([]*clipboard.Conflict, error)
+
+	// DeleteConflict deletes a conflict copy of a clipboard.
+	// It returns false if the conflict copy does not exist.
+	// It returns an error if the deletion fails.
+	DeleteConflict(ctx context.Context, clipboardId, conflictId int) (bool, error)
+
+	// List retrieves the clipboards matching the given options.
+	// It returns an error if the retrieval fails.
+	List(ctx context.Context, opts ListOptions) ([]*clipboard.Clipboard, error)
+
+	// AddTags adds tags to a clipboard.
+	// It returns an error if the insertion fails.
+	AddTags(ctx context.Context, id int, tags []string) error
+
+	// RemoveTag removes a tag from a clipboard.
+	// It returns an error if the deletion fails.
+	RemoveTag(ctx context.Context, id int, tag string) error
+
+	// SetPinned pins or unpins a clipboard.
+	// It returns an error if the update fails.
+	SetPinned(ctx context.Context, id int, pinned bool) error
+
+	// SetReadOnly makes a clipboard read-only or writable again.
+	// It returns an error if the update fails.
+	SetReadOnly(ctx context.Context, id int, readOnly bool) error
+
+	// SetPassword stores the new password and wrapped key of a clipboard.
+	// It returns an error if the update fails.
+	SetPassword(ctx context.Context, c *clipboard.Clipboard) error
+
+	// SetPermission grants a user a role on a clipboard, replacing any previous role.
+	// It returns an error if the insertion fails.
+	SetPermission(ctx context.Context, p *clipboard.Permission) error
+
+	// RemovePermission revokes the access of a user to a clipboard.
+	// It returns an error if the deletion fails.
+	RemovePermission(ctx context.Context, clipboardId, userId int) error
+
+	// Permissions retrieves the users a clipboard is shared with.
+	// It returns an error if the retrieval fails.
+	Permissions(ctx context.Context, clipboardId int) ([]clipboard.Permission, error)
+
+	// Role returns the role granted to a user on a clipboard, or "" if it is not shared with them.
 	// It returns an error if the retrieval fails.
-	Get(id int) (*clipboard.Clipboard, error)
+	Role(ctx context.Context, clipboardId, userId int) (string, error)
 
-	// Update updates an existing clipboard in the database.
+	// Clear updates a clipboard emptied by the caller and deletes its revisions, conflict copies, stack items, thumbnail and streamed data,
+	// overwriting them if secure is set.
+	// It returns ErrVersionConflict if the clipboard changed since it was retrieved.
+	Clear(ctx context.Context, c *clipboard.Clipboard, secure bool) error
+
+	// Delete deletes a clipboard, its tags, flavors, thumbnail, tokens, notifications, stack items and access log from the database by its id.
+	// Its public id is kept as a tombstone.
+	// It returns an error if the deletion fails.
+	Delete(ctx context.Context, id int) error
+
+	// Dedupe adds a reference to an unlocked, writable clipboard of the owner in a namespace with the given content hash.
+	// It returns ErrDuplicateNotFound if there is no such clipboard.
+	// It returns an error if the retrieval or update fails.
+	Dedupe(ctx context.Context, namespace string, ownerId int, hash string) (*clipboard.Clipboard, error)
+
+	// Unref drops a reference to a clipboard that has more than one.
+	// It returns false if only a single reference is left.
 	// It returns an error if the update fails.
-	Update(c *clipboard.Clipboard) error
+	Unref(ctx context.Context, id int) (bool, error)
+
+	// Transact applies writes to clipboards in a single transaction, so either all or none are applied.
+	// It returns a *WriteError wrapping ErrVersionConflict or ErrClipboardExists for the write that failed.
+	// It returns an error if the transaction fails.
+	Transact(ctx context.Context, writes []Write) error
+
+	// LogAccess records an access to a clipboard.
+	// It returns an error if the insertion fails.
+	LogAccess(ctx context.Context, e *clipboard.AccessEntry) error
+
+	// AccessLog retrieves up to limit access log entries of a clipboard, newest first.
+	// It returns an error if the retrieval fails.
+	AccessLog(ctx context.Context, clipboardId, limit int) ([]clipboard.AccessEntry, error)
+
+	// ClipboardStats summarizes the reads and writes of a clipboard per device and the sizes of its last versions.
+	// It returns an error if the retrieval fails.
+	ClipboardStats(ctx context.Context, clipboardId int) (*clipboard.Stats, error)
+
+	// RecordChange appends a change of a clipboard to the changefeed.
+	// It returns an error if the insertion fails.
+	RecordChange(ctx context.Context, c *clipboard.Change) error
 
-	// Delete deletes a clipboard from the database by its id.
+	// Changes retrieves the changes of the changefeed matching the options, oldest first.
+	// It returns an error if the retrieval fails.
+	Changes(ctx context.Context, opts ChangeOptions) ([]clipboard.Change, error)
+
+	// ChangeBounds returns the sequence number of the last pruned change and of the last recorded change.
+	// It returns an error if the retrieval fails.
+	ChangeBounds(ctx context.Context) (pruned, last int64, err error)
+
+	// PruneChanges deletes the changes recorded before the given time and returns their number.
 	// It returns an error if the deletion fails.
-	Delete(id int) error
+	PruneChanges(ctx context.Context, before time.Time) (int, error)
+
+	// AcquireLease takes or renews the lease of name for holder until now plus ttl.
+	// It returns false if another holder has an unexpired lease.
+	// It returns an error if the update fails.
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+
+	// ReleaseLease gives up the lease of name if holder has it.
+	// It returns an error if the deletion fails.
+	ReleaseLease(ctx context.Context, name, holder string) error
+
+	// SecurityEvents retrieves the access log entries of failed attempts on all clipboards matching the options, newest first.
+	// It returns an error if the retrieval fails.
+	SecurityEvents(ctx context.Context, opts SecurityEventOptions) ([]clipboard.AccessEntry, error)
+
+	// EnsureUser retrieves a user by name within a namespace, creating it with the user role if it does not exist.
+	// It returns an error if the retrieval or creation fails.
+	EnsureUser(ctx context.Context, namespace, name string) (*account.User, error)
+
+	// Usage returns the number of clipboards owned by a user and their total size in bytes.
+	// Owner 0 stands for the anonymous clipboards of the namespace.
+	// It returns an error if the retrieval fails.
+	Usage(ctx context.Context, namespace string, ownerId int) (int, int64, error)
+
+	// User retrieves a user by id.
+	// It returns ErrUserNotFound if the user does not exist.
+	// It returns an error if the retrieval fails.
+	User(ctx context.Context, id int) (*account.User, error)
+
+	// UserByName retrieves a user by name within a namespace.
+	// It returns ErrUserNotFound if the user does not exist.
+	// It returns an error if the retrieval fails.
+	UserByName(ctx context.Context, namespace, name string) (*account.User, error)
+
+	// SetRole changes the role of a user.
+	// It returns an error if the update fails.
+	SetRole(ctx context.Context, userId int, role string) error
+
+	// TOTP retrieves the TOTP secret of a user.
+	// It returns ErrTOTPNotFound if the user has no secret.
+	// It returns an error if the retrieval fails.
+	TOTP(ctx context.Context, userId int) (*account.TOTP, error)
+
+	// SetTOTPSecret stores a new, not yet enabled TOTP secret of a user.
+	// It returns an error if the update fails.
+	SetTOTPSecret(ctx context.Context, userId int, secret string) error
+
+	// UseTOTP records the time step of an accepted code and enables the TOTP secret of a user.
+	// It returns false if a code of the same or a later step was accepted before.
+	// It returns an error if the update fails.
+	UseTOTP(ctx context.Context, userId int, step int64) (bool, error)
+
+	// DisableTOTP removes the TOTP secret of a user.
+	// It returns an error if the update fails.
+	DisableTOTP(ctx context.Context, userId int) error
+
+	// IdentityUser retrieves the id of the user a subject of an identity provider is linked to.
+	// It returns 0 if the subject is not linked.
+	// It returns an error if the retrieval fails.
+	IdentityUser(ctx context.Context, issuer, subject string) (int, error)
+
+	// LinkIdentity links a subject of an identity provider to a user.
+	// It returns false if the user is linked to another subject of the provider.
+	// It returns an error if the insertion fails.
+	LinkIdentity(ctx context.Context, issuer, subject string, userId int) (bool, error)
+
+	// UnlinkIdentities removes the links of a user to the subjects of identity providers.
+	// It returns false if the user had none.
+	// It returns an error if the deletion fails.
+	UnlinkIdentities(ctx context.Context, userId int) (bool, error)
+
+	// CreateSession stores a new login session.
+	// It returns an error if the insertion fails.
+	CreateSession(ctx context.Context, sess *account.Session) error
+
+	// GetSession retrieves a session by its id.
+	// It returns ErrSessionNotFound if the session does not exist.
+	// It returns an error if the retrieval fails.
+	GetSession(ctx context.Context, id string) (*account.Session, error)
+
+	// RotateSession replaces the refresh token hash of an active session if it matches oldHash.
+	// It returns false if it does not.
+	// It returns an error if the update fails.
+	RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) (bool, error)
+
+	// RevokeSession revokes a session, invalidating its tokens.
+	// It returns an error if the update fails.
+	RevokeSession(ctx context.Context, id string) error
+
+	// DeleteExpiredSessions deletes sessions that expired before the given time.
+	// It returns the number of deleted sessions, or an error if the deletion fails.
+	DeleteExpiredSessions(ctx context.Context, before time.Time) (int, error)
+
+	// CreatePairing stores the hash of a pairing code of a user.
+	// It returns false if an unexpired code with the same hash exists.
+	// It returns an error if the insertion fails.
+	CreatePairing(ctx context.Context, hash string, userId int, expiresAt time.Time) (bool, error)
+
+	// ClaimPairing deletes an unexpired pairing code and returns the id of the user who requested it.
+	// It returns 0 if there is no such code.
+	// It returns an error if the retrieval or deletion fails.
+	ClaimPairing(ctx context.Context, hash string) (int, error)
+
+	// CreateUpload stores a new, empty chunked upload.
+	// It returns an error if the insertion fails.
+	CreateUpload(ctx context.Context, u *clipboard.Upload) error
+
+	// GetUpload retrieves an upload by its id.
+	// It returns ErrUploadNotFound if the upload does not exist or has expired.
+	// It returns an error if the retrieval fails.
+	GetUpload(ctx context.Context, id string) (*clipboard.Upload, error)
+
+	// AppendUpload appends a chunk at the given offset of an upload.
+	// It returns false if the offset does not match the data received so far.
+	// It returns an error if the update fails.
+	AppendUpload(ctx context.Context, id string, offset int, chunk string) (bool, error)
+
+	// UploadData retrieves the data received so far for an upload.
+	// It returns an error if the retrieval fails.
+	UploadData(ctx context.Context, id string) (string, error)
+
+	// DeleteUpload deletes an upload by its id.
+	// It returns an error if the deletion fails.
+	DeleteUpload(ctx context.Context, id string) error
+
+	// DeleteExpiredUploads deletes uploads that expired before the given time.
+	// It returns the number of deleted uploads, or an error if the deletion fails.
+	DeleteExpiredUploads(ctx context.Context, before time.Time) (int, error)
+
+	// Reserve reserves the next free clipboard id and the name of the reservation, if any, and sets its id.
+	// It returns ErrNameTaken if the owner reserved the name already or has a clipboard with it.
+	// It returns an error if the insertion fails.
+	Reserve(ctx context.Context, r *clipboard.Reservation) error
+
+	// GetReservation retrieves the reservation of a clipboard id.
+	// It returns ErrReservationNotFound if the id is not reserved or the reservation has expired.
+	// It returns an error if the retrieval fails.
+	GetReservation(ctx context.Context, id int) (*clipboard.Reservation, error)
+
+	// DeleteReservation releases the reservation of a clipboard id.
+	// It returns an error if the deletion fails.
+	DeleteReservation(ctx context.Context, id int) error
+
+	// StaleClipboards retrieves the ids of unpinned encrypted or unencrypted clipboards of a namespace last updated before the given time.
+	// It returns an error if the retrieval fails.
+	StaleClipboards(ctx context.Context, namespace string, encrypted bool, before time.Time) ([]int, error)
+
+	// ExpiredClipboards retrieves the ids of clipboards that expired before the given time.
+	// It returns an error if the retrieval fails.
+	ExpiredClipboards(ctx context.Context, before time.Time) ([]int, error)
+
+	// CountClipboards returns the number of unpinned clipboards of a namespace.
+	// It returns an error if the retrieval fails.
+	CountClipboards(ctx context.Context, namespace string) (int, error)
+
+	// LeastRecentlyRead retrieves the ids of up to limit unpinned clipboards of a namespace, least recently read first.
+	// It returns an error if the retrieval fails.
+	LeastRecentlyRead(ctx context.Context, namespace string, limit int) ([]int, error)
+
+	// MarkRead records that a clipboard was just read.
+	// It returns an error if the update fails.
+	MarkRead(ctx context.Context, id int) error
+
+	// PushItem puts an item on top of the stack of a clipboard, keeping at most maxItems items.
+	// It returns an error if the insertion fails.
+	PushItem(ctx context.Context, item *clipboard.Item, maxItems int) error
+
+	// Items retrieves up to limit items of the stack of a clipboard, newest first.
+	// It returns an error if the retrieval fails.
+	Items(ctx context.Context, clipboardId, limit int) ([]*clipboard.Item, error)
+
+	// PopItem removes the top item of the stack of a clipboard and returns it.
+	// It returns ErrStackEmpty if the stack is empty.
+	// It returns an error if the removal fails.
+	PopItem(ctx context.Context, clipboardId int) (*clipboard.Item, error)
+
+	// Stats returns counts and sizes across the whole database.
+	// It returns an error if the retrieval fails.
+	Stats(ctx context.Context) (Stats, error)
+
+	// CacheStats returns the size and the hits and misses of the cache of recently read clipboards.
+	CacheStats() CacheStats
+
+	// RefreshMasterKeys fetches the master keys again from the source they
+	// were loaded from, and reseals the data in the background if the
+	// current key changed. Without such a source, it does nothing.
+	// It returns an error if the keys cannot be fetched or are invalid.
+	RefreshMasterKeys(ctx context.Context) error
+
+	// Snapshot writes a consistent copy of the database to a new file at path with the SQLite backup API.
+	// It returns the size of the copy.
+	// It returns an error if path exists or the backup fails.
+	Snapshot(ctx context.Context, path string) (int64, error)
+
+	// UserUsages returns the usage of every user, by name.
+	// It returns an error if the retrieval fails.
+	UserUsages(ctx context.Context) ([]UserUsage, error)
+
+	// OwnedClipboards retrieves the ids of the clipboards owned by a user.
+	// It returns an error if the retrieval fails.
+	OwnedClipboards(ctx context.Context, ownerId int) ([]int, error)
+
+	// SetLocked locks or unlocks a clipboard.
+	// It returns false if the clipboard does not exist.
+	// It returns an error if the update fails.
+	SetLocked(ctx context.Context, id int, locked bool) (bool, error)
+
+	// WriteData streams the data of a clipboard into the blob store, replacing its previous data
+	// if the clipboard is still at the version of c.
+	// It returns ErrVersionConflict if it is not.
+	// It returns an error if the data cannot be read or stored.
+	WriteData(ctx context.Context, c *clipboard.Clipboard, r io.Reader) error
+
+	// OpenData returns a reader of the stored data of a clipboard, streamed or not.
+	// It returns an error if the data cannot be opened.
+	OpenData(ctx context.Context, c *clipboard.Clipboard) (io.ReadCloser, error)
+
+	// DataURL returns a temporary URL to download the data of a streamed clipboard from directly.
+	// It returns "" if the blob store cannot hand out URLs or the data is sealed at rest.
+	// It returns an error if the URL cannot be created.
+	DataURL(ctx context.Context, c *clipboard.Clipboard, contentType string, ttl time.Duration) (string, error)
+
+	// CreateBlobUpload stores a new direct upload into the blob store and sets its id, blob key and presigned URL.
+	// It returns ErrDirectUploads if the blob store cannot hand out URLs or data is sealed at rest.
+	// It returns an error if the URL cannot be created or the insertion fails.
+	CreateBlobUpload(ctx context.Context, u *clipboard.BlobUpload, ttl time.Duration) error
+
+	// GetBlobUpload retrieves a direct upload by its id.
+	// It returns ErrUploadNotFound if the upload does not exist or has expired.
+	// It returns an error if the retrieval fails.
+	GetBlobUpload(ctx context.Context, id string) (*clipboard.BlobUpload, error)
+
+	// CommitBlobUpload replaces the data of a clipboard with the blob of a direct upload.
+	// It returns ErrUploadIncomplete if the blob is missing or has the wrong size, and ErrVersionConflict if the clipboard changed.
+	// It returns an error if the update fails.
+	CommitBlobUpload(ctx context.Context, c *clipboard.Clipboard, u *clipboard.BlobUpload) error
+
+	// DeleteBlobUpload deletes a direct upload and its blob.
+	// It returns an error if the deletion fails.
+	DeleteBlobUpload(ctx context.Context, u *clipboard.BlobUpload) error
+
+	// DeleteExpiredBlobUploads deletes direct uploads that expired before the given time, and their blobs.
+	// It returns the number of deleted uploads, or an error if the deletion fails.
+	DeleteExpiredBlobUploads(ctx context.Context, before time.Time) (int, error)
+
+	// CreateToken stores a new clipboard token.
+	// It returns an error if the insertion fails.
+	CreateToken(ctx context.Context, t *clipboard.Token) error
+
+	// TokenByHash retrieves a clipboard token by the hash of its secret.
+	// It returns ErrTokenNotFound if the token does not exist.
+	// It returns an error if the retrieval fails.
+	TokenByHash(ctx context.Context, hash string) (*clipboard.Token, error)
+
+	// Tokens retrieves the tokens of a clipboard.
+	// It returns an error if the retrieval fails.
+	Tokens(ctx context.Context, clipboardId int) ([]*clipboard.Token, error)
+
+	// DeleteToken revokes a token of a clipboard.
+	// It returns false if the clipboard has no such token.
+	// It returns an error if the deletion fails.
+	DeleteToken(ctx context.Context, clipboardId int, id string) (bool, error)
+
+	// MarkTokenUsed records that a clipboard token was just used.
+	// It returns an error if the update fails.
+	MarkTokenUsed(ctx context.Context, id string) error
+
+	// UseTokenView counts a read with a clipboard token limited to a number of views.
+	// It returns false if the views of the token are used up, and ErrReadOnly on read-only databases.
+	// It returns an error if the update fails.
+	UseTokenView(ctx context.Context, id string) (bool, error)
+
+	// AliasByName retrieves an alias by its name within a namespace.
+	// It returns ErrAliasNotFound if the alias does not exist.
+	// It returns an error if the retrieval fails.
+	AliasByName(ctx context.Context, namespace, name string) (*clipboard.Alias, error)
+
+	// SetAlias points an alias at its clipboard, creating it if previous is
+	// 0 and moving it from the clipboard with id previous otherwise.
+	// It returns ErrNameTaken if the alias was created or moved concurrently.
+	// It returns an error if the insertion or update fails.
+	SetAlias(ctx context.Context, a *clipboard.Alias, previous int) error
+
+	// Aliases retrieves the aliases of a clipboard.
+	// It returns an error if the retrieval fails.
+	Aliases(ctx context.Context, clipboardId int) ([]*clipboard.Alias, error)
+
+	// DeleteAlias deletes an alias of a clipboard.
+	// It returns false if the clipboard has no such alias.
+	// It returns an error if the deletion fails.
+	DeleteAlias(ctx context.Context, clipboardId int, name string) (bool, error)
+
+	// CreateSubscription stores a notification subscription.
+	// It returns an error if the insertion fails.
+	CreateSubscription(ctx context.Context, sub *clipboard.Subscription) error
+
+	// Subscriptions retrieves the notification subscriptions of a user to a clipboard.
+	// It returns an error if the retrieval fails.
+	Subscriptions(ctx context.Context, clipboardId, userId int) ([]clipboard.Subscription, error)
+
+	// NotifiedSubscriptions retrieves the subscriptions to a clipboard of users who may read it.
+	// It returns an error if the retrieval fails.
+	NotifiedSubscriptions(ctx context.Context, clipboardId int) ([]clipboard.Subscription, error)
+
+	// DeleteSubscription removes a notification subscription of a user to a clipboard.
+	// It returns false if the user has no such subscription.
+	// It returns an error if the deletion fails.
+	DeleteSubscription(ctx context.Context, clipboardId, userId, id int) (bool, error)
+
+	// ExpireSubscription removes a notification subscription the channel no longer delivers to.
+	// It returns an error if the deletion fails.
+	ExpireSubscription(ctx context.Context, id int) error
+
+	// Thumbnail retrieves the stored thumbnail of a clipboard for the given version.
+	// It returns ErrThumbnailNotFound if there is none.
+	// It returns an error if the retrieval fails.
+	Thumbnail(ctx context.Context, clipboardId, version int) ([]byte, error)
+
+	// SaveThumbnail stores the thumbnail of a clipboard for the given version.
+	// It returns an error if the insertion fails.
+	SaveThumbnail(ctx context.Context, clipboardId, version int, thumbnail []byte) error
+
+	// SetTitle stores the title of the link of a clipboard in its metadata if the clipboard still has the given version.
+	// Titles fetched for a version that was changed or deleted in the meantime are dropped.
+	// It returns an error if the update fails.
+	SetTitle(ctx context.Context, clipboardId, version int, title string) error
+
+	// Restore inserts a clipboard from a backup, keeping its id unless it is 0, and its encryption fields.
+	// If data is not nil, it is streamed into the blob store.
+	// It returns ErrClipboardExists if the id is taken.
+	// It returns an error if the insertion fails.
+	Restore(ctx context.Context, c *clipboard.Clipboard, data io.Reader) error
+
+	// Replicate overwrites an existing clipboard with a copy from a federated peer, keeping its update timestamp.
+	// It returns ErrVersionConflict if the clipboard does not exist.
+	// It returns an error if the update fails.
+	Replicate(ctx context.Context, c *clipboard.Clipboard) error
+
+	// Tombstones lists the public ids of deleted clipboards with the time they were deleted.
+	// It returns an error if the retrieval fails.
+	Tombstones(ctx context.Context) ([]Tombstone, error)
+
+	// GetTombstone retrieves the tombstone of a deleted clipboard by its public id.
+	// It returns ErrTombstoneNotFound if there is none.
+	// It returns an error if the retrieval fails.
+	GetTombstone(ctx context.Context, publicId string) (*Tombstone, error)
+
+	// PruneTombstones deletes the tombstones of clipboards deleted before the given time and returns their number.
+	// It returns an error if the deletion fails.
+	PruneTombstones(ctx context.Context, before time.Time) (int, error)
 
 	// Close terminates the database connection.
 	// It returns an error if the connection cannot be closed.
 	Close() error
 }
 
+// ErrVersionConflict is returned when updating a clipboard that was changed
+// since it was read.
+var ErrVersionConflict = conflict("clipboard was modified concurrently")
+
+// ErrReadOnly is returned for writes a read-only database cannot skip.
+var ErrReadOnly = errors.New("database is read-only")
+
 type service struct {
-	db *sql.DB
+	// url is the database URL, without connection parameters.
+	url string
+	db  *sql.DB
+
+	// keyring seals clipboard data at rest. It is nil if no master keys
+	// are configured.
+	keyring *masterkey.Keyring
+	// keySource is where the master keys are fetched from, if not from
+	// MASTER_KEYS. resealing serializes the resealing runs after rotations.
+	keySource masterkey.Source
+	resealing sync.Mutex
+
+	// blobs stores the data of streamed clipboards.
+	blobs blob.Store
+
+	stmts *statements
+
+	// pool holds the connection pool settings applied to db, which
+	// sql.DB does not report back.
+	pool poolConfig
+
+	// readOnly is set for replicas serving a copy of the database of a
+	// primary. They skip migrations and the bookkeeping writes of reads.
+	readOnly bool
+
+	// probe caches the result of the last storage probe.
+	probe *probeCache
+
+	// cache keeps recently read clipboards. It is nil if disabled.
+	cache *clipboardCache
+
+	// queryTimeout bounds every method call, 0 meaning no bound besides
+	// the context of the caller.
+	queryTimeout time.Duration
+
+	// mergeHistory is the number of recent versions kept per text
+	// clipboard as bases of three-way merges, and of conflict copies kept
+	// per clipboard.
+	mergeHistory int
 }
 
-var (
-	dburl      = os.Getenv("DB_URL")
-	dbInstance *service
-)
+// poolConfig holds the connection pool settings of a database.
+// Zero values keep the defaults of database/sql: unlimited open connections
+// and lifetime, and two idle connections.
+type poolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+var dbInstance *service
+
+// poolFromEnv reads the pool settings from DB_MAX_OPEN_CONNS,
+// DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME.
+func poolFromEnv() poolConfig {
+	return poolConfig{
+		MaxOpenConns:    env.Int("DB_MAX_OPEN_CONNS", 0),
+		MaxIdleConns:    env.Int("DB_MAX_IDLE_CONNS", 2),
+		ConnMaxLifetime: env.Duration("DB_CONN_MAX_LIFETIME", 0),
+	}
+}
+
+// readOnlyFromEnv reports whether DB_READ_ONLY is set, which it is by
+// default on replicas, which have a PRIMARY_URL.
+func readOnlyFromEnv() bool {
+	return env.Bool("DB_READ_ONLY", env.String("PRIMARY_URL", "") != "")
+}
+
+// apply applies the pool settings to a database and returns the effective
+// ones: database/sql keeps no more idle connections than it may open.
+func (p poolConfig) apply(db *sql.DB) poolConfig {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+
+	if p.MaxOpenConns > 0 && p.MaxIdleConns > p.MaxOpenConns {
+		p.MaxIdleConns = p.MaxOpenConns
+	}
+	return p
+}
+
+// dsn adds the connection parameters the server relies on to a database
+// URL, unless it sets them itself:
+//   - WAL journaling, so reads do not block on writes and vice versa
+//   - a busy timeout, so concurrent writers wait for each other instead of
+//     failing with "database is locked"
+//   - immediate transactions, which take the write lock up front; deferred
+//     ones upgrading from a read fail without waiting for the busy timeout
+//   - query-only connections for read-only databases
+func dsn(url string, readOnly bool, busyTimeout time.Duration) string {
+	params := []struct{ name, value string }{
+		{"_journal_mode", "WAL"},
+		{"_synchronous", "NORMAL"},
+		{"_busy_timeout", strconv.FormatInt(busyTimeout.Milliseconds(), 10)},
+		{"_txlock", "immediate"},
+	}
+	if readOnly {
+		params = append(params, struct{ name, value string }{"_query_only", "true"})
+	}
+
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	for _, p := range params {
+		if strings.Contains(url, p.name+"=") {
+			continue
+		}
+		url += sep + p.name + "=" + p.value
+		sep = "&"
+	}
+	return url
+}
 
-func New() Service {
+// New opens the database at DB_URL like Open, once: later calls return the
+// same database. It returns an error if the database cannot be opened or
+// migrated, and opens it again on the next call then.
+func New() (Service, error) {
 	// Reuse Connection
 	if dbInstance != nil {
-		return dbInstance
+		return dbInstance, nil
+	}
+
+	s, err := open(env.String("DB_URL", ""), false)
+	if err != nil {
+		return nil, err
+	}
+	dbInstance = s
+
+	return dbInstance, nil
+}
+
+// Open opens the database at url and migrates it, or checks its schema if
+// the database is read-only. Unlike New, it opens a separate database on
+// every call, e.g. for tests.
+func Open(url string) (Service, error) {
+	return open(url, false)
+}
+
+// OpenScratch opens a private in-memory database with the latest schema,
+// even on read-only replicas, e.g. to check the configuration of the server
+// against without touching its database.
+func OpenScratch() (Service, error) {
+	return open(fmt.Sprintf("file:scratch-%d?mode=memory&cache=shared", time.Now().UnixNano()), true)
+}
+
+// open opens and migrates a database. Scratch databases are never
+// read-only, migrated without logging and not resealed.
+// The settings are read here rather than at init, so tests can set them,
+// and invalid ones are returned as errors.
+func open(url string, scratch bool) (*service, error) {
+	readOnly := readOnlyFromEnv() && !scratch
+	busyTimeout := env.Duration("DB_BUSY_TIMEOUT", 5*time.Second)
+	pool := poolFromEnv()
+	queryTimeout := env.Duration("DB_QUERY_TIMEOUT", 10*time.Second)
+	mergeHistory := env.Int("MERGE_HISTORY", 10)
+	cache := newClipboardCache(env.Int64("CLIPBOARD_CACHE_SIZE", defaultCacheSize()), env.Duration("CLIPBOARD_CACHE_TTL", time.Minute))
+	probe := newProbeCache()
+	if err := env.Err(); err != nil {
+		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", dburl)
+	db, err := sql.Open(driverName, dsn(url, readOnly, busyTimeout))
 	if err != nil {
 		// This will not be a connection error, but a DSN parse error or
 		// another initialization error.
-		log.Fatal(err)
+		return nil, err
 	}
+	pool = pool.apply(db)
 
-	// Check if table exists
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name='clipboards';")
+	if readOnly {
+		err = checkSchema(db)
+	} else {
+		err = migrate(db, !scratch)
+	}
 	if err != nil {
-		log.Fatal(err)
+		db.Close()
+		return nil, err
 	}
-	defer rows.Close()
 
-	// If table does not exist, create it
-	if !rows.Next() {
-		_, err = db.Exec(`CREATE TABLE clipboards (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			type TEXT NOT NULL,
-			data TEXT NOT NULL,
-			is_encrypted BOOLEAN NOT NULL DEFAULT FALSE,
-			password_hash TEXT,
-			salt TEXT,
-			nonce TEXT
-		);`)
-		if err != nil {
-			log.Fatal(err)
-		}
+	stmts, err := prepareStatements(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-		// Insert a row with id 99999
-		_, err = db.Exec(`INSERT INTO clipboards (id, name, type, data) VALUES (?, ?, ?, ?);`, 99999, "example", "text/plain", "Hello, World!")
-		if err != nil {
-			log.Fatal(err)
-		}
+	keyring, keySource, err := loadKeyring()
+	if err != nil {
+		stmts.close()
+		db.Close()
+		return nil, err
+	}
 
-		// Delete the row with id 99999
-		_, err = db.Exec(`DELETE FROM clipboards WHERE id = ?;`, 99999)
-		if err != nil {
-			log.Fatal(err)
-		}
+	blobs, err := blob.FromEnv(chunkStore{db})
+	if err != nil {
+		stmts.close()
+		db.Close()
+		return nil, fmt.Errorf("cannot set up blob store: %w", err)
 	}
 
-	dbInstance = &service{
-		db: db,
+	s := &service{
+		url:       url,
+		db:        db,
+		keyring:   keyring,
+		keySource: keySource,
+		blobs:     blobs,
+		stmts:     stmts,
+		pool:      pool,
+		readOnly:  readOnly,
+		probe:     probe,
+
+		queryTimeout: queryTimeout,
+		mergeHistory: mergeHistory,
+		cache:        cache,
+	}
+
+	if err := s.checkSealed(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if keyring != nil && !readOnly && !scratch {
+		go s.reseal()
 	}
-	return dbInstance
+
+	return s, nil
+}
+
+// withTimeout bounds a context by the query timeout. Methods of the service
+// call it first, so that a slow or locked database fails requests instead
+// of holding on to their goroutines indefinitely.
+func (s *service) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
 }
 
 // Health checks the health of the database connection by pinging the database.
 // It returns a map with keys indicating various health statistics.
-func (s *service) Health() map[string]string {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+// If the database cannot be reached within a second, the status is "down".
+func (s *service) Health(ctx context.Context) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
 	stats := make(map[string]string)
@@ -120,7 +782,7 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
+		log.Printf("db down: %v", err)
 		return stats
 	}
 
@@ -138,8 +800,14 @@ func (s *service) Health() map[string]string {
 	stats["max_idle_closed"] = strconv.FormatInt(dbStats.MaxIdleClosed, 10)
 	stats["max_lifetime_closed"] = strconv.FormatInt(dbStats.MaxLifetimeClosed, 10)
 
+	// Report the effective pool settings, 0 meaning unlimited.
+	stats["max_open_connections"] = strconv.Itoa(dbStats.MaxOpenConnections)
+	stats["max_idle_connections"] = strconv.Itoa(s.pool.MaxIdleConns)
+	stats["conn_max_lifetime"] = s.pool.ConnMaxLifetime.String()
+	stats["read_only"] = strconv.FormatBool(s.readOnly)
+
 	// Evaluate stats to provide a health message
-	if dbStats.OpenConnections > 40 { // Assuming 50 is the max for this example
+	if heavyLoad(dbStats) {
 		stats["message"] = "The database is experiencing heavy load."
 	}
 
@@ -148,40 +816,113 @@ func (s *service) Health() map[string]string {
 	}
 
 	if dbStats.MaxIdleClosed > int64(dbStats.OpenConnections)/2 {
-		stats["message"] = "Many idle connections are being closed, consider revising the connection pool settings."
+		stats["message"] = "Many idle connections are being closed, consider raising DB_MAX_IDLE_CONNS."
 	}
 
 	if dbStats.MaxLifetimeClosed > int64(dbStats.OpenConnections)/2 {
-		stats["message"] = "Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern."
+		stats["message"] = "Many connections are being closed due to max lifetime, consider increasing DB_CONN_MAX_LIFETIME or revising the connection usage pattern."
 	}
 
 	return stats
 }
 
+// heavyLoad reports whether the connections in use come close to the limit
+// of open connections, 80% of it, or whether more than 40 connections are
+// open without a limit.
+func heavyLoad(stats sql.DBStats) bool {
+	if stats.MaxOpenConnections <= 0 {
+		return stats.OpenConnections > 40
+	}
+	return stats.InUse*5 >= stats.MaxOpenConnections*4
+}
+
 // Close closes the database connection.
 // It logs a message indicating the disconnection from the specific database.
 // If the connection is successfully closed, it returns nil.
 // If an error occurs while closing the connection, it returns the error.
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", dburl)
+	log.Printf("Disconnected from database: %s", s.url)
+	s.stmts.close()
 	return s.db.Close()
 }
 
 // Insert inserts a new clipboard into the database.
 // If the clipboard is encrypted, it inserts the encrypted data along with the password hash, salt, and nonce.
 // If the clipboard is not encrypted, it inserts the data as is.
+// It sets the creation and update timestamps, the stored size, the content hash and
+// the metadata of the clipboard, and inserts its tags and flavors.
+// Clipboards with an id are inserted under it, or ErrClipboardExists is returned
+// if it is taken, and release its reservation; others get the next free id.
+// Every clipboard gets a new public id.
 // If the insertion is successful, it returns nil.
 // If an error occurs during insertion, it returns the error.
-func (s *service) Insert(c *clipboard.Clipboard) error {
-	sqlInsert := `INSERT INTO clipboards (name, type, data) VALUES (?, ?, ?);`
-	sqlInsertEncrypted := `INSERT INTO clipboards (name, type, data, is_encrypted, password_hash, salt, nonce) VALUES (?, ?, ?, ?, ?, ?, ?);`
+func (s *service) Insert(ctx context.Context, c *clipboard.Clipboard) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.insert(ctx, tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert inserts a clipboard as described by Insert in a transaction.
+func (s *service) insert(ctx context.Context, tx *sql.Tx, c *clipboard.Clipboard) error {
+	sqlInsert := `INSERT INTO clipboards (id, public_id, code, name, type, data, sealed, filename, content_disposition, charset, locale, created_at, updated_at, last_read_at, owner_id, size, pinned, transforms, content_hash, namespace, metadata, quarantine, publish_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	sqlInsertEncrypted := `INSERT INTO clipboards (id, public_id, code, name, type, data, sealed, filename, content_disposition, charset, locale, is_encrypted, password_hash, salt, nonce, kdf, encryption_scope, sealed_fields, wrapped_key, created_at, updated_at, last_read_at, owner_id, size, pinned, transforms, namespace, quarantine, publish_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	sqlExists := `SELECT EXISTS (SELECT 1 FROM clipboards WHERE id = ?);`
+	sqlDeleteReservation := `DELETE FROM clipboard_reservations WHERE clipboard_id = ?;`
+
+	now := time.Now().UTC()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	c.LastReadAt = now
+	c.Size = c.DataSize()
+	c.Version = 1
+	c.Hash = c.ContentHash()
+	extractMetadata(c)
+	c.Refs = 1
+	if c.Namespace == "" {
+		c.Namespace = account.DefaultNamespace
+	}
+	publicId, err := clipboard.NewPublicId()
+	if err != nil {
+		return err
+	}
+	c.PublicId = publicId
+
+	data, err := s.keyring.Seal(c.Data)
+	if err != nil {
+		return err
+	}
+
+	if c.Id != 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, sqlExists, c.Id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrClipboardExists
+		}
+		if _, err := tx.ExecContext(ctx, sqlDeleteReservation, c.Id); err != nil {
+			return err
+		}
+	}
+	if c.Code, err = newCode(ctx, tx); err != nil {
+		return err
+	}
 
 	var result sql.Result
-	var err error
 	if c.IsEncrypted {
-		result, err = s.db.Exec(sqlInsertEncrypted, c.Name, c.DataType, c.Data, c.IsEncrypted, c.PasswordHash, c.Salt, c.Nonce)
+		result, err = tx.ExecContext(ctx, sqlInsertEncrypted, nullInt(c.Id), c.PublicId, c.Code, c.Name, c.DataType, data, s.sealed(), nullString(c.Filename), nullString(c.ContentDisposition), nullString(c.Charset), nullString(c.Locale), c.IsEncrypted, c.PasswordHash, c.Salt, c.Nonce, nullString(c.KDF), nullString(c.EncryptionScope), nullString(c.SealedFields), nullString(c.WrappedKey), c.CreatedAt, c.UpdatedAt, c.LastReadAt, nullInt(c.OwnerId), c.Size, c.Pinned, joinTransforms(c.Transforms), c.Namespace, nullString(c.Quarantine), nullTime(c.PublishAt), nullTime(c.ExpiresAt))
 	} else {
-		result, err = s.db.Exec(sqlInsert, c.Name, c.DataType, c.Data)
+		result, err = tx.ExecContext(ctx, sqlInsert, nullInt(c.Id), c.PublicId, c.Code, c.Name, c.DataType, data, s.sealed(), nullString(c.Filename), nullString(c.ContentDisposition), nullString(c.Charset), nullString(c.Locale), c.CreatedAt, c.UpdatedAt, c.LastReadAt, nullInt(c.OwnerId), c.Size, c.Pinned, joinTransforms(c.Transforms), nullString(c.Hash), c.Namespace, marshalMetadata(c.Metadata), nullString(c.Quarantine), nullTime(c.PublishAt), nullTime(c.ExpiresAt))
 	}
 	if err != nil {
 		return err
@@ -193,25 +934,287 @@ func (s *service) Insert(c *clipboard.Clipboard) error {
 	}
 	c.Id = int(id)
 
-	return nil
+	if err := insertTags(ctx, tx, c.Id, c.Tags); err != nil {
+		return err
+	}
+	if err := s.writeFlavors(ctx, tx, c); err != nil {
+		return err
+	}
+	if err := recordSize(ctx, tx, c.Id, c.Version, int64(c.Size), c.CreatedAt); err != nil {
+		return err
+	}
+	return s.saveRevision(ctx, tx, c, c.Version)
 }
 
+// ErrClipboardNotFound is returned when a clipboard does not exist.
+var ErrClipboardNotFound = notFound("clipboard not found")
+
 // Get retrieves a clipboard from the database by its id.
 // If the clipboard is encrypted, it retrieves the encrypted data along with the password hash, salt, and nonce.
 // If the clipboard is not encrypted, it retrieves the data as is.
-// If the clipboard does not exist, it returns nil.
+// If the clipboard does not exist, it returns ErrClipboardNotFound.
 // If an error occurs during retrieval, it returns the error.
-func (s *service) Get(id int) (*clipboard.Clipboard, error) {
-	sqlSelect := `SELECT * FROM clipboards WHERE id = ?;`
+func (s *service) Get(ctx context.Context, id int) (*clipboard.Clipboard, error) {
+	if c := s.cache.get(id); c != nil {
+		return c, nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	var c clipboard.Clipboard
-	var passwordHash, salt, nonce sql.NullString
-	err := s.db.QueryRow(sqlSelect, id).
-		Scan(&c.Id, &c.Name, &c.DataType, &c.Data, &c.IsEncrypted, &passwordHash, &salt, &nonce)
+	generation := s.cache.current()
+	return s.get(ctx, generation, s.stmts.getClipboard.QueryRowContext(ctx, id))
+}
+
+// GetByPublicId retrieves a clipboard from the database by its public id.
+// If the clipboard does not exist, it returns ErrClipboardNotFound.
+func (s *service) GetByPublicId(ctx context.Context, publicId string) (*clipboard.Clipboard, error) {
+	if c := s.cache.getByPublicId(publicId); c != nil {
+		return c, nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + clipboardColumns + ` FROM clipboards WHERE public_id = ?;`
+
+	generation := s.cache.current()
+	return s.get(ctx, generation, s.db.QueryRowContext(ctx, sqlSelect, publicId))
+}
+
+// GetByCode retrieves a clipboard from the database by its share code.
+// If the clipboard does not exist, it returns ErrClipboardNotFound.
+func (s *service) GetByCode(ctx context.Context, code string) (*clipboard.Clipboard, error) {
+	if c := s.cache.getByCode(code); c != nil {
+		return c, nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + clipboardColumns + ` FROM clipboards WHERE code = ?;`
+
+	generation := s.cache.current()
+	return s.get(ctx, generation, s.db.QueryRowContext(ctx, sqlSelect, code))
+}
+
+// maxCodeAttempts bounds the share codes tried for a new clipboard before
+// giving up, should nearly all of them be taken.
+const maxCodeAttempts = 10
+
+// newCode returns a share code no clipboard has yet.
+func newCode(ctx context.Context, tx *sql.Tx) (string, error) {
+	sqlCodeExists := `SELECT EXISTS (SELECT 1 FROM clipboards WHERE code = ?);`
+
+	for i := 0; i < maxCodeAttempts; i++ {
+		code, err := clipboard.NewCode()
+		if err != nil {
+			return "", err
+		}
+		var exists bool
+		if err := tx.QueryRowContext(ctx, sqlCodeExists, code).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return code, nil
+		}
+	}
+	return "", errors.New("no free share code found")
+}
+
+// get scans a clipboard selected with clipboardColumns, loads its tags and
+// flavors and caches it unless the cache was invalidated since generation,
+// taken before the query. It returns ErrClipboardNotFound if no clipboard
+// was selected.
+func (s *service) get(ctx context.Context, generation uint64, row *sql.Row) (*clipboard.Clipboard, error) {
+	c, err := s.scanClipboard(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrClipboardNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.loadTags(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := s.loadFlavors(ctx, c); err != nil {
+		return nil, err
+	}
+	s.cache.put(c, generation)
+
+	return c, nil
+}
+
+// Update updates an existing clipboard in the database if its version still
+// matches the version of c, and increments the version.
+// It refreshes the update timestamp, the stored size, the content hash and the
+// metadata of the clipboard, and replaces its flavors.
+// The data of a streamed clipboard is moved back into the clipboards table.
+func (s *service) Update(ctx context.Context, c *clipboard.Clipboard) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.update(ctx, c, nil)
+}
+
+// update updates a clipboard as described by Update and, if conflict is not
+// nil, stores it in the same transaction.
+func (s *service) update(ctx context.Context, c *clipboard.Clipboard, conflict *clipboard.Conflict) error {
+	defer s.cache.invalidate(c.Id)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	oldKey, err := s.updateTx(ctx, tx, c)
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		if err := s.insertConflict(ctx, tx, conflict); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteBlob(oldKey)
+	c.BlobKey = ""
+	c.Version++
+
+	return nil
+}
+
+// updateTx updates a clipboard as described by Update in a transaction,
+// except for incrementing the version of c once it is committed. It returns
+// the key of the blob the data of a streamed clipboard was kept in, to be
+// deleted after the commit.
+func (s *service) updateTx(ctx context.Context, tx *sql.Tx, c *clipboard.Clipboard) (string, error) {
+	c.UpdatedAt = time.Now().UTC()
+	c.Size = c.DataSize()
+	c.Streamed = false
+	c.Hash = c.ContentHash()
+	extractMetadata(c)
+
+	data, err := s.keyring.Seal(c.Data)
 	if err != nil {
+		return "", err
+	}
+
+	var oldKey sql.NullString
+	if err := tx.Stmt(s.stmts.checkVersion).QueryRowContext(ctx, c.Id, c.Version).Scan(&oldKey); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return "", ErrVersionConflict
 		}
+		return "", err
+	}
+	if _, err := tx.Stmt(s.stmts.updateClipboard).ExecContext(ctx, c.Name, c.DataType, data, s.sealed(), c.Nonce, nullString(c.SealedFields), nullString(c.Filename), nullString(c.ContentDisposition), nullString(c.Charset), nullString(c.Locale), nullString(c.Quarantine), c.UpdatedAt, c.Size, nullString(c.Hash), joinTransforms(c.Transforms), marshalMetadata(c.Metadata), nullTime(c.PublishAt), nullTime(c.ExpiresAt), c.Id); err != nil {
+		return "", err
+	}
+	if err := s.writeFlavors(ctx, tx, c); err != nil {
+		return "", err
+	}
+	if err := s.saveRevision(ctx, tx, c, c.Version+1); err != nil {
+		return "", err
+	}
+	if err := recordSize(ctx, tx, c.Id, c.Version+1, int64(c.Size), c.UpdatedAt); err != nil {
+		return "", err
+	}
+
+	return oldKey.String, nil
+}
+
+// Delete deletes a clipboard, its tags, flavors, thumbnail, tokens, aliases,
+// notifications, stack items, permissions, revisions, conflict copies,
+// access log, size history and streamed data by its id. Its public id is kept as a tombstone, see Tombstones.
+func (s *service) Delete(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	blobKey, err := deleteClipboard(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteBlob(blobKey)
+	return nil
+}
+
+// deleteClipboard deletes a clipboard as described by Delete in a
+// transaction. It returns the key of the blob its streamed data is kept in,
+// to be deleted after the commit.
+func deleteClipboard(ctx context.Context, tx *sql.Tx, id int) (string, error) {
+	sqlSelect := `SELECT blob_key, public_id FROM clipboards WHERE id = ?;`
+	sqlTombstone := `INSERT OR REPLACE INTO clipboard_tombstones (public_id, deleted_at) VALUES (?, ?);`
+	sqlDelete := `DELETE FROM clipboards WHERE id = ?;`
+	sqlDeleteAccessLog := `DELETE FROM access_log WHERE clipboard_id = ?;`
+	sqlDeleteTags := `DELETE FROM clipboard_tags WHERE clipboard_id = ?;`
+	sqlDeleteItems := `DELETE FROM clipboard_items WHERE clipboard_id = ?;`
+	sqlDeleteFlavors := `DELETE FROM clipboard_flavors WHERE clipboard_id = ?;`
+	sqlDeleteThumbnail := `DELETE FROM clipboard_thumbnails WHERE clipboard_id = ?;`
+	sqlDeleteTokens := `DELETE FROM clipboard_tokens WHERE clipboard_id = ?;`
+	sqlDeleteAliases := `DELETE FROM clipboard_aliases WHERE clipboard_id = ?;`
+	sqlDeleteNotifications := `DELETE FROM clipboard_notifications WHERE clipboard_id = ?;`
+	sqlDeletePermissions := `DELETE FROM clipboard_permissions WHERE clipboard_id = ?;`
+	sqlDeleteRevisions := `DELETE FROM clipboard_revisions WHERE clipboard_id = ?;`
+	sqlDeleteConflicts := `DELETE FROM clipboard_conflicts WHERE clipboard_id = ?;`
+	sqlDeleteSizes := `DELETE FROM clipboard_sizes WHERE clipboard_id = ?;`
+
+	var blobKey, publicId sql.NullString
+	if err := tx.QueryRowContext(ctx, sqlSelect, id).Scan(&blobKey, &publicId); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	if publicId.Valid {
+		if _, err := tx.ExecContext(ctx, sqlTombstone, publicId.String, time.Now().UTC()); err != nil {
+			return "", err
+		}
+	}
+	for _, stmt := range []string{sqlDelete, sqlDeleteAccessLog, sqlDeleteTags, sqlDeleteItems, sqlDeleteFlavors, sqlDeleteThumbnail, sqlDeleteTokens, sqlDeleteAliases, sqlDeleteNotifications, sqlDeletePermissions, sqlDeleteRevisions, sqlDeleteConflicts, sqlDeleteSizes} {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return "", err
+		}
+	}
+
+	return blobKey.String, nil
+}
+
+// clipboardColumns lists the clipboards columns in the order scanClipboard expects.
+const clipboardColumns = `id, name, type, data, sealed, is_encrypted, password_hash, salt, nonce, created_at, updated_at, owner_id, size, last_read_at, locked, blob_key, version, kdf, content_hash, refs, pinned, transforms, public_id, namespace, metadata, code, filename, content_disposition, charset, locale, read_only, encryption_scope, sealed_fields, wrapped_key, quarantine, publish_at, expires_at`
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanClipboard scans a row selected with clipboardColumns into a clipboard,
+// opening its data if it is sealed at rest.
+func (s *service) scanClipboard(row scanner) (*clipboard.Clipboard, error) {
+	var c clipboard.Clipboard
+	var passwordHash, salt, nonce, blobKey, kdf, contentHash, transforms, publicId, metadata, code, filename, disposition, charset, locale, scope, sealedFields, wrappedKey, quarantine sql.NullString
+	var ownerId sql.NullInt64
+	var publishAt, expiresAt sql.NullTime
+	var sealed bool
+	err := row.Scan(&c.Id, &c.Name, &c.DataType, &c.Data, &sealed, &c.IsEncrypted, &passwordHash, &salt, &nonce, &c.CreatedAt, &c.UpdatedAt, &ownerId, &c.Size, &c.LastReadAt, &c.Locked, &blobKey, &c.Version, &kdf, &contentHash, &c.Refs, &c.Pinned, &transforms, &publicId, &c.Namespace, &metadata, &code, &filename, &disposition, &charset, &locale, &c.ReadOnly, &scope, &sealedFields, &wrappedKey, &quarantine, &publishAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	// The sealed flag of streamed clipboards applies to their blob.
+	if blobKey.Valid {
+		c.Streamed = true
+		c.BlobKey = blobKey.String
+	} else if c.Data, err = s.open(c.Data, sealed); err != nil {
 		return nil, err
 	}
 
@@ -219,24 +1222,53 @@ func (s *service) Get(id int) (*clipboard.Clipboard, error) {
 		c.PasswordHash = passwordHash.String
 		c.Salt = salt.String
 		c.Nonce = nonce.String
+		c.KDF = kdf.String
+		c.EncryptionScope = scope.String
+		c.SealedFields = sealedFields.String
+		c.WrappedKey = wrappedKey.String
+	}
+	c.OwnerId = int(ownerId.Int64)
+	c.Hash = contentHash.String
+	c.PublicId = publicId.String
+	c.Code = code.String
+	c.Filename = filename.String
+	c.ContentDisposition = disposition.String
+	c.Charset = charset.String
+	c.Locale = locale.String
+	c.Quarantine = quarantine.String
+	c.PublishAt = timePtr(publishAt)
+	c.ExpiresAt = timePtr(expiresAt)
+	if transforms.Valid {
+		c.Transforms = strings.Split(transforms.String, ",")
+	}
+	if c.Metadata, err = unmarshalMetadata(metadata); err != nil {
+		return nil, err
 	}
 
 	return &c, nil
 }
 
-// Update updates an existing clipboard in the database.
-// If
-func (s *service) Update(c *clipboard.Clipboard) error {
-	sqlUpdate := `UPDATE clipboards SET name = ?, type = ?, data = ?, nonce = ? WHERE id = ?;`
+// joinTransforms stores the transforms of a clipboard as a comma-separated
+// list, or NULL if there are none.
+func joinTransforms(transforms []string) sql.NullString {
+	return nullString(strings.Join(transforms, ","))
+}
 
-	_, err := s.db.Exec(sqlUpdate, c.Name, c.DataType, c.Data, c.Nonce, c.Id)
-	return err
+// nullInt maps the zero value of optional references to NULL.
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
 }
 
-// Delete deletes a clipboard from the database by its id.
-func (s *service) Delete(id int) error {
-	sqlDelete := `DELETE FROM clipboards WHERE id = ?;`
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
 
-	_, err := s.db.Exec(sqlDelete, id)
-	return err
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
 }
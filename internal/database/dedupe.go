@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrDuplicateNotFound is returned when no clipboard can be deduplicated
+// with a new one.
+var ErrDuplicateNotFound = notFound("no duplicate clipboard found")
+
+// Dedupe looks for an unlocked, writable clipboard of the owner in a
+// namespace with the given content hash and adds a reference to it, so the
+// payload is stored only once. Anonymous clipboards are never matched, as
+// they belong to no one in particular. Read-only clipboards are skipped, as
+// the references could not be deleted.
+// It returns ErrDuplicateNotFound if there is no such clipboard.
+func (s *service) Dedupe(ctx context.Context, namespace string, ownerId int, hash string) (*clipboard.Clipboard, error) {
+	if ownerId == 0 {
+		return nil, ErrDuplicateNotFound
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id FROM clipboards WHERE content_hash = ? AND owner_id = ? AND namespace = ? AND NOT locked AND NOT read_only ORDER BY id LIMIT 1;`
+	sqlUpdate := `UPDATE clipboards SET refs = refs + 1 WHERE id = ?;`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRowContext(ctx, sqlSelect, hash, ownerId, namespace).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDuplicateNotFound
+		}
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, sqlUpdate, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.cache.invalidate(id)
+
+	return s.Get(ctx, id)
+}
+
+// Unref drops a reference to a clipboard that has more than one.
+// It returns false if the clipboard has a single reference left, which is
+// dropped by deleting the clipboard.
+func (s *service) Unref(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	sqlUpdate := `UPDATE clipboards SET refs = refs - 1 WHERE id = ? AND refs > 1;`
+
+	result, err := s.db.ExecContext(ctx, sqlUpdate, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
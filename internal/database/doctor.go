@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/doctor"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// Diagnose checks the database the server opens on start: that its file
+// can be read and written, that it can be connected to, its schema
+// version, its integrity, the master keys its data is sealed with and the
+// blob store. Unlike opening it, it neither migrates the database nor
+// creates files.
+func Diagnose(r *doctor.Report) {
+	dburl, readOnly := env.String("DB_URL", ""), readOnlyFromEnv()
+	exists, ok := diagnoseFile(r, dburl, readOnly)
+	if !ok {
+		return
+	}
+	if !exists {
+		r.OK("schema", "a new database is migrated to version %d on start", migrations[len(migrations)-1].version)
+		diagnoseKeys(r, nil, false)
+		diagnoseBlobs(r)
+		return
+	}
+
+	// The connection only reads, and leaves the journal mode alone.
+	sep := "?"
+	if strings.Contains(dburl, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite3", dburl+sep+"_query_only=true")
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+	}
+	if err != nil {
+		r.Fail("database", "check DB_URL", "cannot connect: %v", err)
+		return
+	}
+	defer db.Close()
+
+	current := diagnoseSchema(r, db, readOnly)
+	if current < 0 {
+		return
+	}
+
+	var result string
+	if err := db.QueryRow(`PRAGMA quick_check;`).Scan(&result); err != nil {
+		result = err.Error()
+	}
+	if result != "ok" {
+		r.Fail("database", "restore the database from a backup", "integrity check failed: %s", result)
+	} else {
+		r.OK("database", "integrity check passed")
+	}
+
+	diagnoseKeys(r, db, current == migrations[len(migrations)-1].version)
+	diagnoseBlobs(r)
+}
+
+// diagnoseFile checks that the database file at url can be opened, or
+// created in its directory, for reading only if readOnly is set. It returns
+// whether the database exists, and false for ok if it can be neither opened
+// nor created.
+func diagnoseFile(r *doctor.Report, url string, readOnly bool) (exists, ok bool) {
+	path, memory := sqlitePath(url)
+	switch {
+	case url == "":
+		r.Warn("database", "set DB_URL to the path of the database file", "DB_URL is not set, clipboards are lost when the server stops")
+		return false, true
+	case memory:
+		r.Warn("database", "set DB_URL to the path of a database file", "the database is in memory, clipboards are lost when the server stops")
+		return false, true
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err) && readOnly:
+		r.Fail("database", "point DB_URL at the database of the primary", "read-only database %s does not exist", path)
+		return false, false
+	case os.IsNotExist(err):
+		// SQLite creates the file, but not its directory.
+		dir := filepath.Dir(path)
+		if _, err := os.Stat(dir); err != nil {
+			r.Fail("database", "create the directory of DB_URL", "cannot create %s: %v", path, err)
+			return false, false
+		}
+		if err := doctor.CheckWritableDir(dir); err != nil {
+			r.Fail("database", "create the directory of DB_URL and make it writable by the server", "cannot create %s: %v", path, err)
+			return false, false
+		}
+		r.OK("database", "%s does not exist yet and is created on start", path)
+		return false, true
+	case err != nil:
+		r.Fail("database", "check DB_URL", "cannot access %s: %v", path, err)
+		return true, false
+	case info.IsDir():
+		r.Fail("database", "point DB_URL at a file", "%s is a directory", path)
+		return true, false
+	}
+
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		r.Fail("database", "make the database file readable and writable by the server", "cannot open %s: %v", path, err)
+		return true, false
+	}
+	f.Close()
+
+	// WAL journaling creates files next to the database.
+	if !readOnly {
+		if err := doctor.CheckWritableDir(filepath.Dir(path)); err != nil {
+			r.Fail("database", "make the directory of the database writable by the server", "cannot create journal files next to %s: %v", path, err)
+			return true, false
+		}
+	}
+	r.OK("database", "%s can be opened", path)
+	return true, true
+}
+
+// diagnoseSchema compares the schema version of the database with the
+// latest migration, which read-only databases must be at. It returns the
+// version, or -1 if the server would not start with it.
+func diagnoseSchema(r *doctor.Report, db *sql.DB, readOnly bool) int {
+	var current int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`).Scan(&current)
+	if err != nil && !strings.Contains(err.Error(), "no such table") {
+		r.Fail("schema", "", "cannot read schema version: %v", err)
+		return -1
+	}
+
+	latest := migrations[len(migrations)-1].version
+	switch {
+	case current > latest:
+		r.Fail("schema", "upgrade this server", "schema is at version %d, newer than %d", current, latest)
+		return -1
+	case current < latest && readOnly:
+		r.Fail("schema", "upgrade the primary first", "read-only database schema is at version %d, expected %d", current, latest)
+		return -1
+	case current < latest:
+		r.Warn("schema", "back up the database before starting the server", "%d migrations from version %d to %d are applied on start", latest-current, current, latest)
+	default:
+		r.OK("schema", "up to date at version %d", current)
+	}
+	return current
+}
+
+// diagnoseKeys checks that the master keys load and open the data sealed
+// at rest. Sealed data is only looked for in db if it is migrated.
+func diagnoseKeys(r *doctor.Report, db *sql.DB, migrated bool) {
+	keyring, src, err := loadKeyring()
+	if err != nil {
+		r.Fail("keys", "check MASTER_KEYS or the master key source", "cannot load master keys: %v", err)
+		return
+	}
+
+	if keyring == nil {
+		var n int
+		if migrated {
+			n, err = countSealed(db)
+		}
+		switch {
+		case err != nil:
+			r.Fail("keys", "", "cannot count sealed data: %v", err)
+		case n > 0:
+			r.Fail("keys", "set MASTER_KEYS to the keys the data was sealed with", "%d values are sealed at rest but MASTER_KEYS is not set", n)
+		default:
+			r.OK("keys", "MASTER_KEYS is not set, data is not sealed at rest")
+		}
+		return
+	}
+
+	if migrated {
+		var sample string
+		err = db.QueryRow(`SELECT data FROM clipboards WHERE sealed AND blob_key IS NULL LIMIT 1;`).Scan(&sample)
+		if err == nil {
+			_, err = keyring.Open(sample)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			r.Fail("keys", "add the key the data was sealed with to the master keys", "sealed data cannot be opened: %v", err)
+			return
+		}
+	}
+
+	from := "MASTER_KEYS"
+	if src != nil {
+		from = src.String()
+	}
+	r.OK("keys", "master keys loaded from %s, current key %s", from, keyring.Current())
+}
+
+// diagnoseBlobs checks where streamed data is stored. It does not connect
+// to S3.
+func diagnoseBlobs(r *doctor.Report) {
+	if s3 := blob.S3FromEnv(); s3 != nil {
+		if s3.AccessKeyId == "" || s3.SecretAccessKey == "" {
+			r.Warn("blobs", "set S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY", "S3 bucket %s is configured without credentials", s3.Bucket)
+			return
+		}
+		r.OK("blobs", "streamed data is stored in S3 bucket %s", s3.Bucket)
+		return
+	}
+
+	if dir := env.String("BLOB_DIR", ""); dir != "" {
+		if err := doctor.CheckWritableDir(dir); err != nil {
+			r.Fail("blobs", "make BLOB_DIR writable by the server", "cannot store streamed data in %s: %v", dir, err)
+			return
+		}
+		r.OK("blobs", "streamed data is stored in %s", dir)
+		return
+	}
+
+	r.OK("blobs", "streamed data is stored in the database")
+}
+
+// sqlitePath returns the path of the file of a SQLite database URL, or
+// whether it is an in-memory database.
+func sqlitePath(url string) (string, bool) {
+	path, _, _ := strings.Cut(strings.TrimPrefix(url, "file:"), "?")
+	return path, path == ":memory:" || strings.Contains(url, "mode=memory")
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/metrics"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the sqlite3 driver instrumented with metrics, which the
+// service opens its database with.
+const driverName = "sqlite3_instrumented"
+
+var (
+	lockErrors = metrics.NewCounter("copybridge_db_lock_errors_total",
+		"SQLite statements failing because the database (busy) or a table (locked) was locked.", "code")
+	beginDuration = metrics.NewHistogram("copybridge_db_begin_duration_seconds",
+		"Time spent starting transactions, mostly waiting for the write lock.", metrics.DurationBuckets)
+)
+
+func init() {
+	sql.Register(driverName, instrumentedDriver{})
+}
+
+// instrumentedDriver opens sqlite3 connections counting lock errors.
+type instrumentedDriver struct{}
+
+func (instrumentedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, observe(err)
+	}
+	return &instrumentedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// observe counts err if it is a lock error, and returns it.
+func observe(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrBusy:
+			lockErrors.Inc("busy")
+		case sqlite3.ErrLocked:
+			lockErrors.Inc("locked")
+		}
+	}
+	return err
+}
+
+// sqliteConn returns the sqlite3 connection of a driver connection
+// retrieved with sql.Conn.Raw.
+func sqliteConn(conn any) (*sqlite3.SQLiteConn, bool) {
+	if c, ok := conn.(*instrumentedConn); ok {
+		return c.SQLiteConn, true
+	}
+	c, ok := conn.(*sqlite3.SQLiteConn)
+	return c, ok
+}
+
+type instrumentedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	return result, observe(err)
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	return wrapRows(rows), observe(err)
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if s, ok := stmt.(*sqlite3.SQLiteStmt); ok {
+		stmt = &instrumentedStmt{s}
+	}
+	return stmt, observe(err)
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	beginDuration.Since(start)
+	if err != nil {
+		return nil, observe(err)
+	}
+	return instrumentedTx{tx}, nil
+}
+
+type instrumentedStmt struct {
+	*sqlite3.SQLiteStmt
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	result, err := s.SQLiteStmt.ExecContext(ctx, args)
+	return result, observe(err)
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.SQLiteStmt.QueryContext(ctx, args)
+	return wrapRows(rows), observe(err)
+}
+
+type instrumentedTx struct {
+	driver.Tx
+}
+
+func (tx instrumentedTx) Commit() error {
+	return observe(tx.Tx.Commit())
+}
+
+// instrumentedRows counts the lock errors of reading rows, as SQLite only
+// runs a query when its first row is read.
+type instrumentedRows struct {
+	*sqlite3.SQLiteRows
+}
+
+func wrapRows(rows driver.Rows) driver.Rows {
+	if r, ok := rows.(*sqlite3.SQLiteRows); ok {
+		return &instrumentedRows{r}
+	}
+	return rows
+}
+
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	return observe(r.SQLiteRows.Next(dest))
+}
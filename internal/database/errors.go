@@ -0,0 +1,42 @@
+package database
+
+import "errors"
+
+// Kinds of errors a Service returns, for handlers to respond to them with a
+// status code without knowing every error: ErrNotFound with 404, ErrConflict
+// with 409 and ErrUnauthorized with 401. The getters of this package return
+// errors of the ErrNotFound kind for missing records, while optional values
+// of records that exist, such as the metadata of a clipboard, are nil if
+// unset. Its writes return errors of the ErrConflict kind. ErrUnauthorized is left to a Service of a program
+// embedding the server, which may return these errors, or errors of its own
+// that match them with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// kindError is an error with a message of its own that matches one of the
+// kinds of errors with errors.Is.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// notFound returns an error of the ErrNotFound kind.
+func notFound(msg string) error {
+	return &kindError{msg, ErrNotFound}
+}
+
+// conflict returns an error of the ErrConflict kind.
+func conflict(msg string) error {
+	return &kindError{msg, ErrConflict}
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrTombstoneNotFound is returned when no clipboard with a public id was
+// deleted.
+var ErrTombstoneNotFound = notFound("tombstone not found")
+
+// Tombstone records that the clipboard with a public id was deleted.
+type Tombstone struct {
+	PublicId  string
+	DeletedAt time.Time
+}
+
+// Tombstones lists the tombstones of deleted clipboards, oldest first.
+func (s *service) Tombstones(ctx context.Context) ([]Tombstone, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT public_id, deleted_at FROM clipboard_tombstones ORDER BY deleted_at;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tombstones []Tombstone
+	for rows.Next() {
+		var t Tombstone
+		if err := rows.Scan(&t.PublicId, &t.DeletedAt); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, t)
+	}
+
+	return tombstones, rows.Err()
+}
+
+// GetTombstone retrieves the tombstone of a public id.
+// It returns ErrTombstoneNotFound if no clipboard with the public id was
+// deleted.
+func (s *service) GetTombstone(ctx context.Context, publicId string) (*Tombstone, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT public_id, deleted_at FROM clipboard_tombstones WHERE public_id = ?;`
+
+	var t Tombstone
+	err := s.db.QueryRowContext(ctx, sqlSelect, publicId).Scan(&t.PublicId, &t.DeletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrTombstoneNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// PruneTombstones deletes the tombstones of clipboards deleted before the
+// given time and returns how many were deleted.
+func (s *service) PruneTombstones(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_tombstones WHERE deleted_at < ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Replicate overwrites an existing clipboard with a copy received from
+// another server. Unlike Update, it keeps the update timestamp of c and
+// replaces the encryption parameters, owner, tags, pin and transforms of the
+// clipboard. The data is stored in the clipboards table, also if the
+// clipboard was streamed. It sets the stored size, the metadata and the new
+// version of c.
+// It returns ErrVersionConflict if the clipboard no longer exists.
+func (s *service) Replicate(ctx context.Context, c *clipboard.Clipboard) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(c.Id)
+
+	sqlSelect := `SELECT blob_key FROM clipboards WHERE id = ?;`
+	sqlUpdate := `UPDATE clipboards SET name = ?, type = ?, data = ?, sealed = ?, filename = ?, content_disposition = ?, charset = ?, locale = ?, is_encrypted = ?, password_hash = ?, salt = ?, nonce = ?, kdf = ?, encryption_scope = ?, sealed_fields = ?, wrapped_key = ?, quarantine = ?, blob_key = NULL,
+		updated_at = ?, owner_id = ?, size = ?, content_hash = ?, pinned = ?, transforms = ?, metadata = ?, publish_at = ?, expires_at = ?, version = version + 1 WHERE id = ?;`
+	sqlVersion := `SELECT version FROM clipboards WHERE id = ?;`
+	sqlDeleteTags := `DELETE FROM clipboard_tags WHERE clipboard_id = ?;`
+
+	c.Size = c.DataSize()
+	c.Streamed = false
+	c.Hash = c.ContentHash()
+	extractMetadata(c)
+
+	data, err := s.keyring.Seal(c.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldKey sql.NullString
+	if err := tx.QueryRowContext(ctx, sqlSelect, c.Id).Scan(&oldKey); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrVersionConflict
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, sqlUpdate, c.Name, c.DataType, data, s.sealed(), nullString(c.Filename), nullString(c.ContentDisposition), nullString(c.Charset), nullString(c.Locale), c.IsEncrypted, nullString(c.PasswordHash), nullString(c.Salt), nullString(c.Nonce), nullString(c.KDF), nullString(c.EncryptionScope), nullString(c.SealedFields), nullString(c.WrappedKey), nullString(c.Quarantine),
+		c.UpdatedAt, nullInt(c.OwnerId), c.Size, nullString(c.Hash), c.Pinned, joinTransforms(c.Transforms), marshalMetadata(c.Metadata), nullTime(c.PublishAt), nullTime(c.ExpiresAt), c.Id)
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, sqlVersion, c.Id).Scan(&c.Version); err != nil {
+		return err
+	}
+	if err := recordSize(ctx, tx, c.Id, c.Version, int64(c.Size), c.UpdatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlDeleteTags, c.Id); err != nil {
+		return err
+	}
+	if err := insertTags(ctx, tx, c.Id, c.Tags); err != nil {
+		return err
+	}
+	if err := s.writeFlavors(ctx, tx, c); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteBlob(oldKey.String)
+	c.BlobKey = ""
+	return nil
+}
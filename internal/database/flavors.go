@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// writeFlavors replaces the flavors of a clipboard with the ones of c,
+// sealing their data at rest.
+func (s *service) writeFlavors(ctx context.Context, tx *sql.Tx, c *clipboard.Clipboard) error {
+	sqlDelete := `DELETE FROM clipboard_flavors WHERE clipboard_id = ?;`
+	sqlInsert := `INSERT INTO clipboard_flavors (clipboard_id, type, data, sealed, nonce, size) VALUES (?, ?, ?, ?, ?, ?);`
+
+	if _, err := tx.ExecContext(ctx, sqlDelete, c.Id); err != nil {
+		return err
+	}
+
+	for _, f := range c.Flavors {
+		data, err := s.keyring.Seal(f.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqlInsert, c.Id, f.DataType, data, s.sealed(), nullString(f.Nonce), len(f.Data)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFlavors fills in the flavors of the given clipboards, in the order
+// they were stored.
+func (s *service) loadFlavors(ctx context.Context, cs ...*clipboard.Clipboard) error {
+	if len(cs) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*clipboard.Clipboard, len(cs))
+	args := make([]any, 0, len(cs))
+	for _, c := range cs {
+		c.Flavors = nil
+		byId[c.Id] = c
+		args = append(args, c.Id)
+	}
+
+	var rows *sql.Rows
+	var err error
+	if len(args) == 1 {
+		rows, err = s.stmts.clipboardFlavors.QueryContext(ctx, args[0])
+	} else {
+		sqlSelect := `SELECT clipboard_id, type, data, sealed, nonce FROM clipboard_flavors WHERE clipboard_id IN (` + placeholders(len(args)) + `) ORDER BY id;`
+		rows, err = s.db.QueryContext(ctx, sqlSelect, args...)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var f clipboard.Flavor
+		var sealed bool
+		var nonce sql.NullString
+		if err := rows.Scan(&id, &f.DataType, &f.Data, &sealed, &nonce); err != nil {
+			return err
+		}
+		if f.Data, err = s.open(f.Data, sealed); err != nil {
+			return err
+		}
+		f.Nonce = nonce.String
+		byId[id].Flavors = append(byId[id].Flavors, f)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// IdentityUser retrieves the id of the user a subject of an identity
+// provider is linked to, or 0 if it is not linked.
+func (s *service) IdentityUser(ctx context.Context, issuer, subject string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?;`
+
+	var userId int
+	err := s.db.QueryRowContext(ctx, sqlSelect, issuer, subject).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userId, err
+}
+
+// LinkIdentity links a subject of an identity provider to a user, unless
+// either is linked already.
+func (s *service) LinkIdentity(ctx context.Context, issuer, subject string, userId int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT OR IGNORE INTO user_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?);`
+
+	result, err := s.db.ExecContext(ctx, sqlInsert, issuer, subject, userId, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UnlinkIdentities removes the links of a user to identity providers, so
+// the user can be linked to a new account at the provider.
+func (s *service) UnlinkIdentities(ctx context.Context, userId int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM user_identities WHERE user_id = ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, userId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
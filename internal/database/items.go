@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrStackEmpty is returned when the stack of a clipboard has no items.
+var ErrStackEmpty = notFound("clipboard stack is empty")
+
+// PushItem puts an item on top of the stack of a clipboard.
+// If the stack then holds more than maxItems items, the oldest ones are dropped.
+// It sets the id, size and creation timestamp of the item.
+func (s *service) PushItem(ctx context.Context, item *clipboard.Item, maxItems int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO clipboard_items (clipboard_id, type, data, sealed, nonce, size, created_at) VALUES (?, ?, ?, ?, ?, ?, ?);`
+	sqlTrim := `DELETE FROM clipboard_items WHERE clipboard_id = ? AND id NOT IN (SELECT id FROM clipboard_items WHERE clipboard_id = ? ORDER BY id DESC LIMIT ?);`
+
+	item.Size = len(item.Data)
+	item.CreatedAt = time.Now().UTC()
+
+	data, err := s.keyring.Seal(item.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, sqlInsert, item.ClipboardId, item.DataType, data, s.sealed(), item.Nonce, item.Size, item.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	item.Id = int(id)
+
+	if maxItems > 0 {
+		if _, err := tx.ExecContext(ctx, sqlTrim, item.ClipboardId, item.ClipboardId, maxItems); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Items retrieves up to limit items of the stack of a clipboard, newest first.
+func (s *service) Items(ctx context.Context, clipboardId, limit int) ([]*clipboard.Item, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, clipboard_id, type, data, sealed, nonce, size, created_at FROM clipboard_items WHERE clipboard_id = ? ORDER BY id DESC LIMIT ?;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, clipboardId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*clipboard.Item{}
+	for rows.Next() {
+		item, err := s.scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// PopItem removes the top item from the stack of a clipboard and returns it.
+// It returns ErrStackEmpty if the stack is empty.
+func (s *service) PopItem(ctx context.Context, clipboardId int) (*clipboard.Item, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, clipboard_id, type, data, sealed, nonce, size, created_at FROM clipboard_items WHERE clipboard_id = ? ORDER BY id DESC LIMIT 1;`
+	sqlDelete := `DELETE FROM clipboard_items WHERE id = ?;`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	item, err := s.scanItem(tx.QueryRowContext(ctx, sqlSelect, clipboardId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrStackEmpty
+		}
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlDelete, item.Id); err != nil {
+		return nil, err
+	}
+
+	return item, tx.Commit()
+}
+
+// scanItem scans a stack item row, opening its data if it is sealed at rest.
+func (s *service) scanItem(row scanner) (*clipboard.Item, error) {
+	var item clipboard.Item
+	var sealed bool
+	err := row.Scan(&item.Id, &item.ClipboardId, &item.DataType, &item.Data, &sealed, &item.Nonce, &item.Size, &item.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if item.Data, err = s.open(item.Data, sealed); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// AcquireLease takes or renews the lease of name for holder until now plus
+// ttl, in a single statement so two instances never both get it.
+// It returns false if another holder has a lease that has not expired.
+func (s *service) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpsert := `INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= ?;`
+
+	now = now.UTC()
+	result, err := s.db.ExecContext(ctx, sqlUpsert, name, holder, now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseLease gives up the lease of name if holder has it, so another
+// instance can take it right away.
+func (s *service) ReleaseLease(ctx context.Context, name, holder string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM leases WHERE name = ? AND holder = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlDelete, name, holder)
+	return err
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// The orders List can sort clipboards in.
+const (
+	SortUpdatedAt = "updated_at"
+	SortName      = "name"
+	SortSize      = "size"
+)
+
+// ListOptions filters and pages the clipboards returned by List.
+type ListOptions struct {
+	// OwnerId restricts the list to the clipboards of a user and the ones
+	// shared with them. Owner 0 lists anonymous clipboards.
+	OwnerId int
+	// AllOwners lists the clipboards of all users, ignoring OwnerId.
+	AllOwners bool
+	// Namespace restricts the list to the clipboards of a namespace. All
+	// namespaces are listed if it is empty.
+	Namespace string
+	// Tags restricts the list to clipboards having all of the tags.
+	Tags []string
+	// Name restricts the list to clipboards with the given name.
+	Name string
+	// Pinned restricts the list to pinned clipboards.
+	Pinned bool
+	// Type restricts the list to clipboards of a media type, whatever its
+	// parameters, or of any subtype of a type given as "text/*".
+	Type string
+	// Encrypted restricts the list to encrypted clipboards if true, and to
+	// unencrypted ones if false.
+	Encrypted *bool
+	// UpdatedSince restricts the list to clipboards updated at or after it,
+	// if it is not zero.
+	UpdatedSince time.Time
+	// VisibleAt restricts the list to clipboards published and not expired
+	// at that time, if it is not zero. Clipboards scheduled for later are
+	// still listed for OwnerId if they may update them.
+	VisibleAt time.Time
+
+	// Sort is SortUpdatedAt, SortName or SortSize to sort the clipboards by
+	// instead of newest first, in descending order if Desc is set. Pinned
+	// clipboards still come first.
+	Sort string
+	Desc bool
+
+	Limit  int
+	Offset int
+}
+
+// List retrieves the clipboards matching the options, pinned ones first and
+// newest first or in the order of opts.Sort otherwise.
+func (s *service) List(ctx context.Context, opts ListOptions) ([]*clipboard.Clipboard, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var where []string
+	var args []any
+
+	switch {
+	case opts.AllOwners:
+		where = append(where, `1 = 1`)
+	case opts.OwnerId == 0:
+		where = append(where, `owner_id IS NULL`)
+	default:
+		where = append(where, `(owner_id = ? OR id IN (SELECT clipboard_id FROM clipboard_permissions WHERE user_id = ?))`)
+		args = append(args, opts.OwnerId, opts.OwnerId)
+	}
+
+	if opts.Namespace != "" {
+		where = append(where, `namespace = ?`)
+		args = append(args, opts.Namespace)
+	}
+
+	if len(opts.Tags) > 0 {
+		where = append(where, `id IN (SELECT clipboard_id FROM clipboard_tags WHERE tag IN (`+placeholders(len(opts.Tags))+`) GROUP BY clipboard_id HAVING COUNT(*) = ?)`)
+		for _, tag := range opts.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(opts.Tags))
+	}
+
+	if opts.Name != "" {
+		where = append(where, `name = ?`)
+		args = append(args, opts.Name)
+	}
+
+	if opts.Pinned {
+		where = append(where, `pinned`)
+	}
+
+	if base, sub, ok := strings.Cut(opts.Type, "/"); ok && sub == "*" {
+		where = append(where, `type LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(base)+"/%")
+	} else if opts.Type != "" {
+		// LIKE also matches types differing in case only.
+		where = append(where, `(type LIKE ? ESCAPE '\' OR type LIKE ? ESCAPE '\')`)
+		args = append(args, escapeLike(opts.Type), escapeLike(opts.Type)+";%")
+	}
+
+	if opts.Encrypted != nil {
+		where = append(where, `is_encrypted = ?`)
+		args = append(args, *opts.Encrypted)
+	}
+
+	if !opts.UpdatedSince.IsZero() {
+		where = append(where, `updated_at >= ?`)
+		args = append(args, opts.UpdatedSince.UTC())
+	}
+	if !opts.VisibleAt.IsZero() {
+		at := opts.VisibleAt.UTC()
+		where = append(where, `(expires_at IS NULL OR expires_at > ?)`, `(publish_at IS NULL OR publish_at <= ? OR owner_id = ? OR id IN (SELECT clipboard_id FROM clipboard_permissions WHERE user_id = ? AND role = ?))`)
+		args = append(args, at, at, opts.OwnerId, opts.OwnerId, clipboard.RoleWrite)
+	}
+
+	order := `id DESC`
+	switch opts.Sort {
+	case SortUpdatedAt, SortName, SortSize:
+		order = opts.Sort + ` ASC, id ASC`
+		if opts.Desc {
+			order = opts.Sort + ` DESC, id DESC`
+		}
+	}
+
+	sqlSelect := `SELECT ` + clipboardColumns + ` FROM clipboards WHERE ` + strings.Join(where, ` AND `) + ` ORDER BY pinned DESC, ` + order + ` LIMIT ? OFFSET ?;`
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cs := []*clipboard.Clipboard{}
+	for rows.Next() {
+		c, err := s.scanClipboard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadTags(ctx, cs...); err != nil {
+		return nil, err
+	}
+	if err := s.loadFlavors(ctx, cs...); err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/preview"
+)
+
+// extractMetadata sets the metadata of a clipboard whose data is written.
+// Encrypted and streamed clipboards have none.
+func extractMetadata(c *clipboard.Clipboard) {
+	c.Metadata = nil
+	if !c.IsEncrypted && !c.Streamed {
+		c.Metadata = preview.Extract(c.DataType, c.Data)
+	}
+}
+
+// marshalMetadata stores metadata as JSON, or NULL if there is none.
+func marshalMetadata(m *clipboard.Metadata) sql.NullString {
+	if m == nil {
+		return sql.NullString{}
+	}
+	data, _ := json.Marshal(m)
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// unmarshalMetadata reads metadata stored with marshalMetadata.
+func unmarshalMetadata(column sql.NullString) (*clipboard.Metadata, error) {
+	if !column.Valid {
+		return nil, nil
+	}
+	var m clipboard.Metadata
+	if err := json.Unmarshal([]byte(column.String), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SetTitle stores the title of the link of a clipboard in its metadata if
+// the clipboard still has the given version. Read-only databases drop it.
+func (s *service) SetTitle(ctx context.Context, clipboardId, version int, title string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(clipboardId)
+
+	if s.readOnly {
+		return nil
+	}
+	sqlSelect := `SELECT metadata FROM clipboards WHERE id = ? AND version = ?;`
+	sqlUpdate := `UPDATE clipboards SET metadata = ? WHERE id = ? AND version = ?;`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var column sql.NullString
+	if err := tx.QueryRowContext(ctx, sqlSelect, clipboardId, version).Scan(&column); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	m, err := unmarshalMetadata(column)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &clipboard.Metadata{}
+	}
+	m.Title = title
+
+	if _, err := tx.ExecContext(ctx, sqlUpdate, marshalMetadata(m), clipboardId, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
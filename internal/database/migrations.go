@@ -0,0 +1,908 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// migration is a single schema change.
+// Migrations are applied in order, each in its own transaction, and recorded
+// in the schema_migrations table so they only ever run once.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "create clipboards table", createClipboards},
+	{2, "add timestamps and owner to clipboards", addClipboardMetadata},
+	{3, "create access log", createAccessLog},
+	{4, "create users and track clipboard sizes", createUsers},
+	{5, "create clipboard tags", createClipboardTags},
+	{6, "create uploads", createUploads},
+	{7, "track when clipboards were last read", addClipboardLastRead},
+	{8, "create clipboard stack items", createClipboardItems},
+	{9, "create sessions", createSessions},
+	{10, "track data sealed at rest", addSealed},
+	{11, "create clipboard permissions", createClipboardPermissions},
+	{12, "allow administrators to lock clipboards", addClipboardLocked},
+	{13, "store streamed clipboard data as blobs", addBlobs},
+	{14, "version clipboards for optimistic concurrency", addClipboardVersion},
+	{15, "store key derivation parameters of encrypted clipboards", addClipboardKDF},
+	{16, "create clipboard flavors", createClipboardFlavors},
+	{17, "create clipboard thumbnails", createClipboardThumbnails},
+	{18, "create clipboard tokens", createClipboardTokens},
+	{19, "create clipboard notifications", createClipboardNotifications},
+	{20, "add clipboard content hash", addClipboardContentHash},
+	{21, "create pairing codes", createPairingCodes},
+	{22, "allow pinning clipboards", addClipboardPinned},
+	{23, "add clipboard transforms", addClipboardTransforms},
+	{24, "add public clipboard ids", addClipboardPublicIds},
+	{25, "create clipboard tombstones", createClipboardTombstones},
+	{26, "add user roles", addUserRoles},
+	{27, "add namespaces", addNamespaces},
+	{28, "add clipboard preview metadata", addClipboardPreviews},
+	{29, "create direct blob uploads", createBlobUploads},
+	{30, "add two-factor authentication", addTOTP},
+	{31, "create clipboard revisions and conflicts", createConflicts},
+	{32, "create user identities", createUserIdentities},
+	{33, "add clipboard share codes", addClipboardCodes},
+	{34, "index access log outcomes", addAccessLogOutcomeIndex},
+	{35, "create leases", createLeases},
+	{36, "add clipboard filenames", addClipboardFilenames},
+	{37, "allow making clipboards read-only", addClipboardReadOnly},
+	{38, "create clipboard reservations", createReservations},
+	{39, "add clipboard encryption scopes", addEncryptionScopes},
+	{40, "create clipboard changefeed", createChanges},
+	{41, "add wrapped clipboard keys", addWrappedKeys},
+	{42, "add clipboard quarantine", addQuarantine},
+	{43, "create clipboard aliases", createAliases},
+	{44, "add token view limits", addTokenViews},
+	{45, "add clipboard charset and locale", addTextMetadata},
+	{46, "add clipboard publishing schedules", addSchedules},
+	{47, "create clipboard size history", createSizeHistory},
+}
+
+// migrate brings the database schema up to date.
+func migrate(db *sql.DB, verbose bool) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);`, m.version, m.name, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("Applied migration %d: %s", m.version, m.name)
+		}
+	}
+
+	return nil
+}
+
+// checkSchema checks that a database that cannot be migrated, such as the
+// replicated database of a read-only replica, has the schema of this
+// version of the server.
+func checkSchema(db *sql.DB) error {
+	var current int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("cannot read schema version of read-only database: %w", err)
+	}
+
+	latest := migrations[len(migrations)-1].version
+	switch {
+	case current < latest:
+		return fmt.Errorf("read-only database schema is at version %d, expected %d: upgrade the primary first", current, latest)
+	case current > latest:
+		return fmt.Errorf("read-only database schema is at version %d, newer than %d: upgrade this server", current, latest)
+	}
+	return nil
+}
+
+// createClipboards creates the clipboards table.
+// Databases created before migrations existed already have it and are left
+// untouched.
+func createClipboards(tx *sql.Tx) error {
+	var name string
+	err := tx.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='clipboards';`).Scan(&name)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE clipboards (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		is_encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+		password_hash TEXT,
+		salt TEXT,
+		nonce TEXT
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// Insert and delete a row with id 99999 so ids start at 100000
+	_, err = tx.Exec(`INSERT INTO clipboards (id, name, type, data) VALUES (?, ?, ?, ?);`, 99999, "example", "text/plain", "Hello, World!")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM clipboards WHERE id = ?;`, 99999)
+	return err
+}
+
+// addClipboardMetadata adds created_at, updated_at and owner_id columns.
+// Legacy rows get the migration time as timestamps and no owner.
+func addClipboardMetadata(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN created_at TIMESTAMP;`,
+		`ALTER TABLE clipboards ADD COLUMN updated_at TIMESTAMP;`,
+		`ALTER TABLE clipboards ADD COLUMN owner_id INTEGER;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	_, err := tx.Exec(`UPDATE clipboards SET created_at = ?, updated_at = ? WHERE created_at IS NULL;`, now, now)
+	return err
+}
+
+// createAccessLog creates the access_log table recording reads, writes and
+// failed authentication attempts per clipboard.
+func createAccessLog(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE access_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		clipboard_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		device TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX access_log_clipboard_id ON access_log (clipboard_id);`)
+	return err
+}
+
+// createUsers creates the users table and adds a size column to clipboards,
+// backfilled from the stored data.
+func createUsers(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`ALTER TABLE clipboards ADD COLUMN size INTEGER NOT NULL DEFAULT 0;`,
+		`UPDATE clipboards SET size = LENGTH(CAST(data AS BLOB));`,
+		`CREATE INDEX clipboards_owner_id ON clipboards (owner_id);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createClipboardTags creates the clipboard_tags join table.
+func createClipboardTags(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_tags (
+		clipboard_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (clipboard_id, tag)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX clipboard_tags_tag ON clipboard_tags (tag);`)
+	return err
+}
+
+// createUploads creates the uploads table holding chunked uploads until they
+// are committed.
+func createUploads(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE uploads (
+		id TEXT PRIMARY KEY,
+		owner_id INTEGER,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		is_encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+		tags TEXT NOT NULL,
+		data TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		length INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// addClipboardLastRead adds a last_read_at column to clipboards, backfilled
+// with the update time, so retention can evict the least recently read ones.
+func addClipboardLastRead(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN last_read_at TIMESTAMP;`,
+		`UPDATE clipboards SET last_read_at = updated_at;`,
+		`CREATE INDEX clipboards_last_read_at ON clipboards (last_read_at);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createClipboardItems creates the clipboard_items table holding the stack
+// of each clipboard.
+func createClipboardItems(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		clipboard_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX clipboard_items_clipboard_id ON clipboard_items (clipboard_id, id);`)
+	return err
+}
+
+// createSessions creates the sessions table backing access and refresh tokens.
+func createSessions(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		refresh_hash TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	);`)
+	return err
+}
+
+// addSealed adds a sealed column to the tables holding clipboard data,
+// recording whether the data is sealed with a server master key.
+func addSealed(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE clipboard_items ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE uploads ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT FALSE;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createClipboardPermissions creates the clipboard_permissions table sharing
+// owned clipboards with other users.
+func createClipboardPermissions(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_permissions (
+		clipboard_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (clipboard_id, user_id)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX clipboard_permissions_user_id ON clipboard_permissions (user_id);`)
+	return err
+}
+
+// addClipboardLocked adds a locked column to clipboards, set by
+// administrators to block all access to a clipboard.
+func addClipboardLocked(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN locked BOOLEAN NOT NULL DEFAULT FALSE;`)
+	return err
+}
+
+// addBlobs adds a blob_key column to clipboards whose data is streamed to the
+// blob store, and the blob_chunks table backing the default blob store.
+func addBlobs(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN blob_key TEXT;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE blob_chunks (
+		blob_key TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (blob_key, seq)
+	);`)
+	return err
+}
+
+// addClipboardVersion adds a version column to clipboards, incremented on
+// every change of their data so concurrent updates can be detected.
+func addClipboardVersion(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`)
+	return err
+}
+
+// addClipboardKDF adds a kdf column to clipboards holding the parameters
+// their key is derived with. Existing clipboards keep NULL, which stands for
+// the parameters used before they were configurable.
+func addClipboardKDF(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN kdf TEXT;`)
+	return err
+}
+
+// createClipboardFlavors creates the clipboard_flavors table holding the
+// alternative representations of the data of each clipboard.
+func createClipboardFlavors(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_flavors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		clipboard_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		sealed BOOLEAN NOT NULL DEFAULT FALSE,
+		nonce TEXT,
+		size INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX clipboard_flavors_clipboard_id ON clipboard_flavors (clipboard_id, id);`)
+	return err
+}
+
+// createClipboardThumbnails creates the clipboard_thumbnails table caching
+// the thumbnail of the current version of image clipboards.
+func createClipboardThumbnails(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_thumbnails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		clipboard_id INTEGER NOT NULL UNIQUE,
+		version INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		sealed BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// createClipboardTokens creates the clipboard_tokens table holding tokens
+// that grant scoped access to a single clipboard.
+func createClipboardTokens(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_tokens (
+		id TEXT PRIMARY KEY,
+		clipboard_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX clipboard_tokens_clipboard_id ON clipboard_tokens (clipboard_id);`)
+	return err
+}
+
+// createClipboardNotifications creates the clipboard_notifications table
+// holding the notification subscriptions of users to clipboards.
+func createClipboardNotifications(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		clipboard_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		channel TEXT NOT NULL,
+		target TEXT NOT NULL,
+		include_data BOOLEAN NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX clipboard_notifications_clipboard_id ON clipboard_notifications (clipboard_id);`)
+	return err
+}
+
+// addClipboardContentHash adds the content_hash column identifying identical
+// payloads and the refs column counting the deduplicated uploads of a
+// clipboard. Existing clipboards get their hash on their next update.
+func addClipboardContentHash(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN content_hash TEXT;`,
+		`ALTER TABLE clipboards ADD COLUMN refs INTEGER NOT NULL DEFAULT 1;`,
+		`CREATE INDEX clipboards_content_hash ON clipboards (content_hash);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPairingCodes creates the pairing_codes table holding the hashes of
+// the codes devices are paired with.
+func createPairingCodes(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE pairing_codes (
+		code_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// addClipboardPinned adds a pinned column to clipboards, which exempts them
+// from retention and lists them first.
+func addClipboardPinned(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE;`)
+	return err
+}
+
+// addClipboardTransforms adds a transforms column to clipboards, holding the
+// comma-separated transforms applied to their data on every write.
+func addClipboardTransforms(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN transforms TEXT;`)
+	return err
+}
+
+// addClipboardPublicIds adds a public_id column to clipboards, holding the
+// random ids they can be addressed by instead of their sequential ids, and
+// assigns one to every existing clipboard.
+func addClipboardPublicIds(tx *sql.Tx) error {
+	if _, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN public_id TEXT;`); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM clipboards;`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		publicId, err := clipboard.NewPublicId()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE clipboards SET public_id = ? WHERE id = ?;`, publicId, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX clipboards_public_id ON clipboards (public_id);`)
+	return err
+}
+
+// createClipboardTombstones creates the table remembering the public ids of
+// deleted clipboards, so federated peers learn about deletions.
+func createClipboardTombstones(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE clipboard_tombstones (
+		public_id TEXT PRIMARY KEY,
+		deleted_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// addUserRoles adds the role of users. Existing users become regular users.
+func addUserRoles(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';`)
+	return err
+}
+
+// addNamespaces puts users and clipboards in namespaces, all existing ones in
+// the default namespace. The users table is rebuilt, as SQLite cannot drop
+// the unique constraint on names, which now only apply within a namespace.
+func addNamespaces(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE users_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT NOT NULL DEFAULT 'default',
+			name TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			role TEXT NOT NULL DEFAULT 'user',
+			UNIQUE (namespace, name)
+		);`,
+		`INSERT INTO users_new (id, name, created_at, role) SELECT id, name, created_at, role FROM users;`,
+		`DROP TABLE users;`,
+		`ALTER TABLE users_new RENAME TO users;`,
+		`ALTER TABLE clipboards ADD COLUMN namespace TEXT NOT NULL DEFAULT 'default';`,
+		`CREATE INDEX clipboards_namespace ON clipboards (namespace);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addClipboardPreviews adds the preview metadata of clipboards, stored as
+// JSON. Existing clipboards get theirs when their data is next written.
+func addClipboardPreviews(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN metadata TEXT;`)
+	return err
+}
+
+// createBlobUploads creates the table of uploads clients send to the blob
+// store directly, which replace the data of a clipboard once committed.
+func createBlobUploads(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE blob_uploads (
+		id TEXT PRIMARY KEY,
+		clipboard_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		blob_key TEXT NOT NULL,
+		type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// addTOTP adds the TOTP secrets of users and marks the sessions logged in
+// with a TOTP code. Secrets are sealed at rest like clipboard data.
+func addTOTP(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE users ADD COLUMN totp_secret TEXT;`,
+		`ALTER TABLE users ADD COLUMN totp_sealed BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE sessions ADD COLUMN totp_verified BOOLEAN NOT NULL DEFAULT FALSE;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createConflicts creates the tables of the recent versions of text
+// clipboards, the bases of three-way merges, and of the conflict copies of
+// data replaced by concurrent updates. Both are sealed at rest like
+// clipboard data.
+func createConflicts(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE clipboard_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			clipboard_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			data TEXT NOT NULL,
+			sealed BOOLEAN NOT NULL DEFAULT FALSE,
+			UNIQUE (clipboard_id, version)
+		);`,
+		`CREATE TABLE clipboard_conflicts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			clipboard_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			data TEXT NOT NULL,
+			sealed BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX clipboard_conflicts_clipboard_id ON clipboard_conflicts (clipboard_id);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createUserIdentities creates the table linking the subjects of external
+// identity providers to users, so logins keep mapping to the same user when
+// the claim they were matched by changes.
+func createUserIdentities(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE user_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (issuer, subject)
+		);`,
+		`CREATE UNIQUE INDEX user_identities_issuer_user_id ON user_identities (issuer, user_id);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addClipboardCodes adds a code column to clipboards, holding the share
+// codes new clipboards get. Existing clipboards have none.
+func addClipboardCodes(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN code TEXT;`,
+		`CREATE UNIQUE INDEX clipboards_code ON clipboards (code);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addAccessLogOutcomeIndex indexes the access log by outcome, so failed
+// attempts can be listed across clipboards.
+func addAccessLogOutcomeIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE INDEX access_log_outcome ON access_log (outcome, created_at);`)
+	return err
+}
+
+// createLeases creates the leases table, through which instances sharing
+// the database elect the one running background jobs.
+func createLeases(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+// addClipboardFilenames adds the filename and content disposition the data
+// of clipboards is served with, which chunked uploads pass on.
+func addClipboardFilenames(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN filename TEXT;`,
+		`ALTER TABLE clipboards ADD COLUMN content_disposition TEXT;`,
+		`ALTER TABLE uploads ADD COLUMN filename TEXT;`,
+		`ALTER TABLE uploads ADD COLUMN content_disposition TEXT;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addClipboardReadOnly adds a read_only column to clipboards, set by their
+// owners to refuse changes to the data until it is cleared again.
+func addClipboardReadOnly(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN read_only BOOLEAN NOT NULL DEFAULT FALSE;`)
+	return err
+}
+
+// createReservations creates the table of clipboard ids and names reserved
+// for clipboards created later, and lets chunked uploads name the reserved
+// id they create their clipboard under.
+func createReservations(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE clipboard_reservations (
+			clipboard_id INTEGER PRIMARY KEY,
+			name TEXT,
+			owner_id INTEGER,
+			namespace TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX clipboard_reservations_name ON clipboard_reservations (namespace, name);`,
+		`ALTER TABLE uploads ADD COLUMN clipboard_id INTEGER;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addEncryptionScopes records what the encryption of clipboards covers and
+// stores the fields sealed by clipboards encrypting all of them. Existing
+// encrypted clipboards only have their data encrypted.
+func addEncryptionScopes(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN encryption_scope TEXT;`,
+		`ALTER TABLE clipboards ADD COLUMN sealed_fields TEXT;`,
+		`UPDATE clipboards SET encryption_scope = 'data' WHERE is_encrypted;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createChanges creates the changefeed, an append-only log of clipboard
+// changes clients catch up with after being offline.
+func createChanges(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE clipboard_changes (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			clipboard_id INTEGER NOT NULL,
+			public_id TEXT,
+			op TEXT NOT NULL,
+			owner_id INTEGER,
+			namespace TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX clipboard_changes_created_at ON clipboard_changes (created_at);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addWrappedKeys stores the data keys of encrypted clipboards, wrapped with
+// the key derived from their password. Existing encrypted clipboards keep
+// using the derived key itself, which changing their password wraps.
+func addWrappedKeys(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN wrapped_key TEXT;`)
+	return err
+}
+
+// addQuarantine records what the content scanner flagged clipboards for.
+func addQuarantine(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE clipboards ADD COLUMN quarantine TEXT;`)
+	return err
+}
+
+// createAliases creates the clipboard_aliases table holding the names
+// addressing clipboards in their namespace.
+func createAliases(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE clipboard_aliases (
+			name TEXT NOT NULL,
+			clipboard_id INTEGER NOT NULL,
+			namespace TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (namespace, name)
+		);`,
+		`CREATE INDEX clipboard_aliases_clipboard_id ON clipboard_aliases (clipboard_id);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addTokenViews limits how often clipboard tokens may read their
+// clipboard, for share links. Existing tokens are unlimited.
+func addTokenViews(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboard_tokens ADD COLUMN max_views INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE clipboard_tokens ADD COLUMN views INTEGER NOT NULL DEFAULT 0;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addTextMetadata records the charset text was sent in before it was
+// converted to UTF-8, and the language of clipboards.
+func addTextMetadata(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN charset TEXT;`,
+		`ALTER TABLE clipboards ADD COLUMN locale TEXT;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addSchedules records when clipboards scheduled for later are published
+// and when clipboards expire. Expired clipboards are looked up by the
+// cleanup job, see ExpiredClipboards.
+func addSchedules(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`ALTER TABLE clipboards ADD COLUMN publish_at TIMESTAMP;`,
+		`ALTER TABLE clipboards ADD COLUMN expires_at TIMESTAMP;`,
+		`CREATE INDEX clipboards_expires_at ON clipboards (expires_at);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createSizeHistory creates the table of the sizes clipboards had, see
+// ClipboardStats, starting with the current size of existing clipboards.
+func createSizeHistory(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE clipboard_sizes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			clipboard_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			size INTEGER NOT NULL,
+			recorded_at TIMESTAMP NOT NULL,
+			UNIQUE (clipboard_id, version)
+		);`,
+		`INSERT INTO clipboard_sizes (clipboard_id, version, size, recorded_at) SELECT id, version, size, updated_at FROM clipboards;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
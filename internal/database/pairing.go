@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreatePairing stores the hash of a pairing code of a user, after deleting
+// expired codes.
+// It returns false if an unexpired code with the same hash exists.
+func (s *service) CreatePairing(ctx context.Context, hash string, userId int, expiresAt time.Time) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDeleteExpired := `DELETE FROM pairing_codes WHERE expires_at <= ?;`
+	sqlInsert := `INSERT OR IGNORE INTO pairing_codes (code_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?);`
+
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, sqlDeleteExpired, now); err != nil {
+		return false, err
+	}
+
+	result, err := s.db.ExecContext(ctx, sqlInsert, hash, userId, now, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ClaimPairing deletes an unexpired pairing code by its hash and returns the
+// id of the user who requested it, so every code works once.
+// It returns 0 if there is no such code.
+func (s *service) ClaimPairing(ctx context.Context, hash string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT user_id FROM pairing_codes WHERE code_hash = ? AND expires_at > ?;`
+	sqlDelete := `DELETE FROM pairing_codes WHERE code_hash = ?;`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userId int
+	if err := tx.QueryRowContext(ctx, sqlSelect, hash, time.Now().UTC()).Scan(&userId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, sqlDelete, hash); err != nil {
+		return 0, err
+	}
+
+	return userId, tx.Commit()
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// SetPassword stores the password hash, salt, KDF parameters and wrapped
+// data key of a clipboard whose password was changed, see
+// clipboard.Clipboard.ChangePassword. Its data is left as is, and so is its
+// version, as clients holding it need not fetch it again; its update time
+// is refreshed so peers replicate the change.
+func (s *service) SetPassword(ctx context.Context, c *clipboard.Clipboard) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(c.Id)
+
+	sqlUpdate := `UPDATE clipboards SET password_hash = ?, salt = ?, kdf = ?, wrapped_key = ?, updated_at = ? WHERE id = ?;`
+
+	c.UpdatedAt = time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, sqlUpdate, c.PasswordHash, c.Salt, nullString(c.KDF), nullString(c.WrappedKey), c.UpdatedAt, c.Id)
+	return err
+}
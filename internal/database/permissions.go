@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// SetPermission grants a user a role on a clipboard, replacing the role they
+// had before. It sets the creation timestamp of the permission.
+func (s *service) SetPermission(ctx context.Context, p *clipboard.Permission) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpsert := `INSERT INTO clipboard_permissions (clipboard_id, user_id, role, created_at) VALUES (?, ?, ?, ?) ON CONFLICT (clipboard_id, user_id) DO UPDATE SET role = excluded.role, created_at = excluded.created_at;`
+
+	p.CreatedAt = time.Now().UTC()
+
+	_, err := s.db.ExecContext(ctx, sqlUpsert, p.ClipboardId, p.UserId, p.Role, p.CreatedAt)
+	return err
+}
+
+// RemovePermission revokes the access of a user to a clipboard.
+func (s *service) RemovePermission(ctx context.Context, clipboardId, userId int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_permissions WHERE clipboard_id = ? AND user_id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlDelete, clipboardId, userId)
+	return err
+}
+
+// Permissions retrieves the users a clipboard is shared with, by user name.
+func (s *service) Permissions(ctx context.Context, clipboardId int) ([]clipboard.Permission, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT p.clipboard_id, p.user_id, u.name, p.role, p.created_at FROM clipboard_permissions p JOIN users u ON u.id = p.user_id WHERE p.clipboard_id = ? ORDER BY u.name;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, clipboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ps := []clipboard.Permission{}
+	for rows.Next() {
+		var p clipboard.Permission
+		if err := rows.Scan(&p.ClipboardId, &p.UserId, &p.User, &p.Role, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+
+	return ps, rows.Err()
+}
+
+// Role returns the role granted to a user on a clipboard, or "" if the
+// clipboard is not shared with them.
+func (s *service) Role(ctx context.Context, clipboardId, userId int) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var role string
+	err := s.stmts.role.QueryRowContext(ctx, clipboardId, userId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
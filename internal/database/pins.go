@@ -0,0 +1,30 @@
+package database
+
+import "context"
+
+// SetPinned pins or unpins a clipboard. Pinned clipboards are listed first
+// and never deleted by retention.
+func (s *service) SetPinned(ctx context.Context, id int, pinned bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	sqlUpdate := `UPDATE clipboards SET pinned = ? WHERE id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlUpdate, pinned, id)
+	return err
+}
+
+// SetReadOnly makes a clipboard read-only or writable again. The data of
+// read-only clipboards cannot be replaced and they cannot be deleted until
+// they are made writable again.
+func (s *service) SetReadOnly(ctx context.Context, id int, readOnly bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	sqlUpdate := `UPDATE clipboards SET read_only = ? WHERE id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlUpdate, readOnly, id)
+	return err
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// probeSize is the size of the blob written by storage probes.
+const probeSize = 1 << 10
+
+// probeCache holds the result of the last storage probe.
+type probeCache struct {
+	// timeout bounds a probe, after which the storage is reported down.
+	timeout time.Duration
+	// interval is how long the result of a probe is reused, so frequent
+	// health checks do not keep writing to the storage.
+	interval time.Duration
+
+	mu    sync.Mutex
+	at    time.Time
+	stats map[string]string
+}
+
+// newProbeCache configures storage probes by HEALTH_PROBE_TIMEOUT and
+// HEALTH_PROBE_INTERVAL.
+func newProbeCache() *probeCache {
+	return &probeCache{
+		timeout:  env.Duration("HEALTH_PROBE_TIMEOUT", 5*time.Second),
+		interval: env.Duration("HEALTH_PROBE_INTERVAL", 10*time.Second),
+	}
+}
+
+// ProbeStorage times writing, reading back and deleting a small blob in the
+// storage of streamed clipboards. Results are reused for HEALTH_PROBE_INTERVAL.
+// The "storage_status" key is "up", "down" or "skipped" for read-only databases.
+func (s *service) ProbeStorage() map[string]string {
+	s.probe.mu.Lock()
+	defer s.probe.mu.Unlock()
+
+	if s.probe.stats == nil || time.Since(s.probe.at) >= s.probe.interval {
+		s.probe.stats = s.probeStorage()
+		s.probe.at = time.Now()
+	}
+
+	stats := make(map[string]string, len(s.probe.stats)+1)
+	for k, v := range s.probe.stats {
+		stats[k] = v
+	}
+	stats["storage_probed_at"] = s.probe.at.UTC().Format(time.RFC3339)
+	return stats
+}
+
+func (s *service) probeStorage() map[string]string {
+	stats := map[string]string{"storage_backend": storageBackend(s.blobs)}
+	if _, ok := s.blobs.(chunkStore); ok && s.readOnly {
+		stats["storage_status"] = "skipped"
+		return stats
+	}
+
+	// The probe keeps running past the timeout, so its blob is still
+	// deleted once the storage answers.
+	type result struct {
+		latencies map[string]time.Duration
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		latencies, err := probe(s.blobs)
+		done <- result{latencies, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			stats["storage_status"] = "down"
+			stats["storage_error"] = fmt.Sprintf("storage down: %v", res.err)
+			log.Printf("storage down: %v", res.err)
+			break
+		}
+		stats["storage_status"] = "up"
+		for op, d := range res.latencies {
+			stats["storage_"+op] = d.String()
+		}
+	case <-time.After(s.probe.timeout):
+		stats["storage_status"] = "down"
+		stats["storage_error"] = fmt.Sprintf("storage probe timed out after %s", s.probe.timeout)
+		log.Printf("storage probe timed out after %s", s.probe.timeout)
+	}
+	return stats
+}
+
+// probe writes, reads back and deletes a blob of random data, and returns
+// the duration of each operation.
+func probe(store blob.Store) (map[string]time.Duration, error) {
+	key, err := blob.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, probeSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+
+	latencies := make(map[string]time.Duration)
+	start := time.Now()
+	if _, err := store.Put(key, bytes.NewReader(data)); err != nil {
+		_ = store.Delete(key)
+		return nil, fmt.Errorf("write: %w", err)
+	}
+	latencies["write"] = time.Since(start)
+
+	start = time.Now()
+	read, err := readBlob(store, key)
+	if err == nil && !bytes.Equal(read, data) {
+		err = errors.New("data read back differs from data written")
+	}
+	if err != nil {
+		_ = store.Delete(key)
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	latencies["read"] = time.Since(start)
+
+	start = time.Now()
+	if err := store.Delete(key); err != nil {
+		return nil, fmt.Errorf("delete: %w", err)
+	}
+	latencies["delete"] = time.Since(start)
+
+	return latencies, nil
+}
+
+func readBlob(store blob.Store, key string) ([]byte, error) {
+	r, err := store.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// storageBackend names the kind of a blob store.
+func storageBackend(store blob.Store) string {
+	switch store.(type) {
+	case chunkStore:
+		return "database"
+	case blob.Dir:
+		return "dir"
+	case *blob.S3:
+		return "s3"
+	default:
+		return fmt.Sprintf("%T", store)
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+var (
+	// ErrNameTaken is returned when reserving a name the owner reserved
+	// already or has a clipboard with.
+	ErrNameTaken = conflict("name is already taken")
+	// ErrReservationNotFound is returned when an id is not reserved or its
+	// reservation has expired.
+	ErrReservationNotFound = notFound("reservation not found")
+)
+
+// Reserve reserves the next free clipboard id, and the name of r if it has
+// one, until the expiry time of r, and sets its id and creation time.
+// Names are reserved per owner and namespace, like clipboard names.
+// The id is taken from the sequence of clipboard ids, so clipboards created
+// without an id never get it. Expired reservations are dropped first.
+// It returns ErrNameTaken if another unexpired reservation or a clipboard of
+// the owner in the namespace has the name.
+func (s *service) Reserve(ctx context.Context, r *clipboard.Reservation) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDeleteExpired := `DELETE FROM clipboard_reservations WHERE expires_at <= ?;`
+	sqlNameTaken := `SELECT EXISTS (SELECT 1 FROM clipboard_reservations WHERE namespace = ? AND owner_id IS ? AND name = ?)
+		OR EXISTS (SELECT 1 FROM clipboards WHERE namespace = ? AND owner_id IS ? AND name = ?);`
+	sqlNextId := `SELECT MAX(
+		COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'clipboards'), 0),
+		COALESCE((SELECT MAX(id) FROM clipboards), 0),
+		COALESCE((SELECT MAX(clipboard_id) FROM clipboard_reservations), 0)) + 1;`
+	sqlUpdateSequence := `UPDATE sqlite_sequence SET seq = ? WHERE name = 'clipboards';`
+	sqlInsertSequence := `INSERT INTO sqlite_sequence (name, seq) VALUES ('clipboards', ?);`
+	sqlInsert := `INSERT INTO clipboard_reservations (clipboard_id, name, owner_id, namespace, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?);`
+
+	r.CreatedAt = time.Now().UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, sqlDeleteExpired, r.CreatedAt); err != nil {
+		return err
+	}
+	if r.Name != "" {
+		var taken bool
+		if err := tx.QueryRowContext(ctx, sqlNameTaken, r.Namespace, nullInt(r.OwnerId), r.Name, r.Namespace, nullInt(r.OwnerId), r.Name).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrNameTaken
+		}
+	}
+
+	if err := tx.QueryRowContext(ctx, sqlNextId).Scan(&r.Id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, sqlUpdateSequence, r.Id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if _, err := tx.ExecContext(ctx, sqlInsertSequence, r.Id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, sqlInsert, r.Id, nullString(r.Name), nullInt(r.OwnerId), r.Namespace, r.CreatedAt, r.ExpiresAt.UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetReservation retrieves the reservation of a clipboard id.
+// It returns ErrReservationNotFound if the id is not reserved or the
+// reservation has expired.
+func (s *service) GetReservation(ctx context.Context, id int) (*clipboard.Reservation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT clipboard_id, name, owner_id, namespace, created_at, expires_at FROM clipboard_reservations WHERE clipboard_id = ? AND expires_at > ?;`
+
+	var r clipboard.Reservation
+	var name sql.NullString
+	var ownerId sql.NullInt64
+	err := s.db.QueryRowContext(ctx, sqlSelect, id, time.Now().UTC()).
+		Scan(&r.Id, &name, &ownerId, &r.Namespace, &r.CreatedAt, &r.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrReservationNotFound
+		}
+		return nil, err
+	}
+
+	r.Name = name.String
+	r.OwnerId = int(ownerId.Int64)
+
+	return &r, nil
+}
+
+// DeleteReservation releases the reservation of a clipboard id. The id is
+// not handed out again.
+func (s *service) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_reservations WHERE clipboard_id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlDelete, id)
+	return err
+}
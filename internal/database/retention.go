@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// StaleClipboards retrieves the ids of the encrypted or unencrypted
+// clipboards of a namespace last updated before the given time. Pinned
+// clipboards never go stale.
+func (s *service) StaleClipboards(ctx context.Context, namespace string, encrypted bool, before time.Time) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id FROM clipboards WHERE namespace = ? AND is_encrypted = ? AND updated_at < ? AND NOT pinned ORDER BY id;`
+
+	return s.queryIds(ctx, sqlSelect, namespace, encrypted, before.UTC())
+}
+
+// CountClipboards returns the number of unpinned clipboards of a namespace,
+// which are the ones retention may evict.
+func (s *service) CountClipboards(ctx context.Context, namespace string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT COUNT(*) FROM clipboards WHERE namespace = ? AND NOT pinned;`
+
+	var count int
+	err := s.db.QueryRowContext(ctx, sqlSelect, namespace).Scan(&count)
+	return count, err
+}
+
+// LeastRecentlyRead retrieves the ids of up to limit unpinned clipboards of
+// a namespace, least recently read first.
+func (s *service) LeastRecentlyRead(ctx context.Context, namespace string, limit int) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id FROM clipboards WHERE namespace = ? AND NOT pinned ORDER BY last_read_at, id LIMIT ?;`
+
+	return s.queryIds(ctx, sqlSelect, namespace, limit)
+}
+
+// MarkRead records that a clipboard was just read, unless the database is
+// read-only.
+func (s *service) MarkRead(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if s.readOnly {
+		return nil
+	}
+	now := time.Now().UTC()
+	if _, err := s.stmts.markRead.ExecContext(ctx, now, id); err != nil {
+		return err
+	}
+	s.cache.touch(id, now)
+	return nil
+}
+
+// queryIds runs a query selecting a single integer column.
+func (s *service) queryIds(ctx context.Context, query string, args ...any) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ExpiredClipboards retrieves the ids of the clipboards that expired before
+// the given time, pinned or not.
+func (s *service) ExpiredClipboards(ctx context.Context, before time.Time) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id FROM clipboards WHERE expires_at < ? ORDER BY id;`
+
+	return s.queryIds(ctx, sqlSelect, before.UTC())
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/masterkey"
+)
+
+// resealBatchSize is the number of rows resealed per query.
+const resealBatchSize = 100
+
+// keyFetchTimeout bounds fetching master keys from an external source.
+const keyFetchTimeout = 30 * time.Second
+
+// loadKeyring reads the master keys from MASTER_KEYS, or fetches them from
+// the external source configured instead, which it returns as well.
+func loadKeyring() (*masterkey.Keyring, masterkey.Source, error) {
+	keys := env.String("MASTER_KEYS", "")
+	src, err := masterkey.SourceFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	if src == nil {
+		keyring, err := masterkey.Parse(keys)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid MASTER_KEYS: %w", err)
+		}
+		return keyring, nil, nil
+	}
+	if keys != "" {
+		return nil, nil, fmt.Errorf("MASTER_KEYS cannot be set together with the %s", src)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyFetchTimeout)
+	defer cancel()
+	keyring, err := masterkey.Load(ctx, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Loaded master keys from %s, current key %s", src, keyring.Current())
+	return keyring, src, nil
+}
+
+func (s *service) RefreshMasterKeys(ctx context.Context) error {
+	if s.keySource == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, keyFetchTimeout)
+	defer cancel()
+	keyring, err := masterkey.Load(ctx, s.keySource)
+	if err != nil {
+		return err
+	}
+	if !s.keyring.Replace(keyring) {
+		return nil
+	}
+
+	log.Printf("Master key rotated to %s in %s", keyring.Current(), s.keySource)
+	if !s.readOnly {
+		go s.reseal()
+	}
+	return nil
+}
+
+// sealed reports whether new data is sealed at rest.
+func (s *service) sealed() bool {
+	return s.keyring != nil
+}
+
+// open returns stored data, opening it if it is sealed at rest.
+func (s *service) open(data string, sealed bool) (string, error) {
+	if !sealed {
+		return data, nil
+	}
+	return s.keyring.Open(data)
+}
+
+// checkSealed refuses to start without master keys when data has been sealed
+// at rest, since it could neither be read nor written consistently.
+func (s *service) checkSealed() error {
+	if s.keyring != nil {
+		return nil
+	}
+
+	n, err := countSealed(s.db)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errors.New("clipboard data is sealed at rest but MASTER_KEYS is not set")
+	}
+
+	return nil
+}
+
+// countSealed counts the values sealed at rest.
+func countSealed(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT (SELECT COUNT(*) FROM clipboards WHERE sealed) + (SELECT COUNT(*) FROM clipboard_items WHERE sealed) + (SELECT COUNT(*) FROM clipboard_flavors WHERE sealed) + (SELECT COUNT(*) FROM clipboard_thumbnails WHERE sealed) + (SELECT COUNT(*) FROM uploads WHERE sealed) + (SELECT COUNT(*) FROM users WHERE totp_sealed) + (SELECT COUNT(*) FROM clipboard_revisions WHERE sealed) + (SELECT COUNT(*) FROM clipboard_conflicts WHERE sealed);`).Scan(&n)
+	return n, err
+}
+
+// reseal seals the data that is not sealed with the current master key yet,
+// either because it predates MASTER_KEYS or because the key was rotated.
+// Values sealed with a previous key only get their data keys rewrapped,
+// while streamed data is copied. Unfinished uploads sealed with a previous
+// key are left to expire.
+func (s *service) reseal() {
+	s.resealing.Lock()
+	defer s.resealing.Unlock()
+
+	pattern := s.keyring.Current() + ".%"
+	tables := []struct {
+		name, query string
+	}{
+		{"clipboards", `SELECT id, data, sealed FROM clipboards WHERE blob_key IS NULL AND (sealed = FALSE OR data NOT LIKE ?) ORDER BY id LIMIT ?;`},
+		{"clipboard_items", `SELECT id, data, sealed FROM clipboard_items WHERE sealed = FALSE OR data NOT LIKE ? ORDER BY id LIMIT ?;`},
+		{"clipboard_flavors", `SELECT id, data, sealed FROM clipboard_flavors WHERE sealed = FALSE OR data NOT LIKE ? ORDER BY id LIMIT ?;`},
+		{"clipboard_thumbnails", `SELECT id, data, sealed FROM clipboard_thumbnails WHERE sealed = FALSE OR data NOT LIKE ? ORDER BY id LIMIT ?;`},
+		{"clipboard_revisions", `SELECT id, data, sealed FROM clipboard_revisions WHERE sealed = FALSE OR data NOT LIKE ? ORDER BY id LIMIT ?;`},
+		{"clipboard_conflicts", `SELECT id, data, sealed FROM clipboard_conflicts WHERE sealed = FALSE OR data NOT LIKE ? ORDER BY id LIMIT ?;`},
+	}
+
+	for _, t := range tables {
+		n, err := s.resealTable(t.name, t.query, pattern)
+		if err != nil {
+			log.Printf("error resealing %s: %v", t.name, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Resealed %d %s with master key %s", n, t.name, s.keyring.Current())
+		}
+	}
+
+	n, err := s.resealBlobs()
+	if err != nil {
+		log.Printf("error resealing blobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Resealed %d blobs with master key %s", n, s.keyring.Current())
+	}
+
+	n, err = s.resealUploads()
+	if err != nil {
+		log.Printf("error resealing uploads: %v", err)
+	} else if n > 0 {
+		log.Printf("Resealed %d uploads with master key %s", n, s.keyring.Current())
+	}
+
+	n, err = s.resealTOTP(pattern)
+	if err != nil {
+		log.Printf("error resealing TOTP secrets: %v", err)
+	} else if n > 0 {
+		log.Printf("Resealed %d TOTP secrets with master key %s", n, s.keyring.Current())
+	}
+}
+
+// resealTOTP seals the TOTP secrets of users that are not sealed with the
+// current master key yet. There are few enough to do them at once.
+func (s *service) resealTOTP(pattern string) (int, error) {
+	sqlSelect := `SELECT id, totp_secret, totp_sealed FROM users WHERE totp_secret IS NOT NULL AND (totp_sealed = FALSE OR totp_secret NOT LIKE ?);`
+	sqlUpdate := `UPDATE users SET totp_secret = ?, totp_sealed = TRUE WHERE id = ? AND totp_secret = ?;`
+
+	type row struct {
+		id     int
+		secret string
+		sealed bool
+	}
+	rows, err := s.db.Query(sqlSelect, pattern)
+	if err != nil {
+		return 0, err
+	}
+	var users []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.secret, &r.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, r := range users {
+		resealed, err := s.resealValue(r.secret, r.sealed)
+		if err != nil {
+			return total, fmt.Errorf("user %d: %w", r.id, err)
+		}
+		result, err := s.db.Exec(sqlUpdate, resealed, r.id, r.secret)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+// resealValue seals a stored value with the current master key. Values
+// sealed with a previous key are rewrapped rather than decrypted.
+func (s *service) resealValue(data string, sealed bool) (string, error) {
+	if sealed {
+		return s.keyring.Rewrap(data)
+	}
+	return s.keyring.Seal(data)
+}
+
+// resealTable reseals the rows of a table selected by query in batches.
+// Rows changed concurrently are skipped, since they are written with the
+// current key anyway.
+func (s *service) resealTable(table, query, pattern string) (int, error) {
+	sqlUpdate := fmt.Sprintf(`UPDATE %s SET data = ?, sealed = TRUE WHERE id = ? AND data = ?;`, table)
+
+	total := 0
+	for {
+		type row struct {
+			id     int
+			data   string
+			sealed bool
+		}
+
+		rows, err := s.db.Query(query, pattern, resealBatchSize)
+		if err != nil {
+			return total, err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.data, &r.sealed); err != nil {
+				rows.Close()
+				return total, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		updated := 0
+		for _, r := range batch {
+			resealed, err := s.resealValue(r.data, r.sealed)
+			if err != nil {
+				return total, fmt.Errorf("row %d: %w", r.id, err)
+			}
+			result, err := s.db.Exec(sqlUpdate, resealed, r.id, r.data)
+			if err != nil {
+				return total, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return total, err
+			}
+			updated += int(n)
+		}
+		total += updated
+
+		// Stop if every row of the batch was changed concurrently, rather
+		// than selecting the same rows forever.
+		if updated == 0 {
+			return total, nil
+		}
+	}
+}
+
+// resealUploads seals the data received so far for uploads started before
+// MASTER_KEYS was set, as a single chunk.
+func (s *service) resealUploads() (int, error) {
+	sqlSelect := `SELECT id, data FROM uploads WHERE sealed = FALSE;`
+	sqlUpdate := `UPDATE uploads SET data = ?, sealed = TRUE WHERE id = ? AND data = ? AND sealed = FALSE;`
+
+	rows, err := s.db.Query(sqlSelect)
+	if err != nil {
+		return 0, err
+	}
+	uploads := make(map[string]string)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		uploads[id] = data
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for id, data := range uploads {
+		sealed := ""
+		if data != "" {
+			if sealed, err = s.keyring.Seal(data); err != nil {
+				return total, err
+			}
+			sealed += "\n"
+		}
+		result, err := s.db.Exec(sqlUpdate, sealed, id, data)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+	}
+
+	return total, nil
+}
+
+// resealBlobs copies the data of streamed clipboards that is not sealed with
+// the current master key into new, resealed blobs.
+func (s *service) resealBlobs() (int, error) {
+	sqlSelect := `SELECT id, blob_key, sealed FROM clipboards WHERE blob_key IS NOT NULL ORDER BY id;`
+	sqlUpdate := `UPDATE clipboards SET blob_key = ?, sealed = TRUE WHERE id = ? AND blob_key = ?;`
+
+	type row struct {
+		id     int
+		key    string
+		sealed bool
+	}
+
+	rows, err := s.db.Query(sqlSelect)
+	if err != nil {
+		return 0, err
+	}
+	var blobs []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.key, &r.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		blobs = append(blobs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, r := range blobs {
+		resealed, err := s.resealBlob(r.key, r.sealed)
+		if err != nil {
+			return total, fmt.Errorf("clipboard %d: %w", r.id, err)
+		}
+		if resealed == "" {
+			continue
+		}
+
+		result, err := s.db.Exec(sqlUpdate, resealed, r.id, r.key)
+		if err != nil {
+			s.deleteBlob(resealed)
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			// The data was replaced meanwhile.
+			s.deleteBlob(resealed)
+			continue
+		}
+		s.cache.invalidate(r.id)
+		s.deleteBlob(r.key)
+		total++
+	}
+
+	return total, nil
+}
+
+// resealBlob copies a blob into a new blob sealed with the current master
+// key and returns its key. It returns "" if the blob is sealed with the
+// current key already.
+func (s *service) resealBlob(key string, sealed bool) (string, error) {
+	rc, err := s.blobs.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if sealed {
+		var keyId string
+		if r, keyId, err = s.keyring.OpenReader(rc); err != nil {
+			return "", err
+		}
+		if keyId == s.keyring.Current() {
+			return "", nil
+		}
+	}
+
+	resealed, err := blob.NewKey()
+	if err != nil {
+		return "", err
+	}
+	sealedReader, err := s.keyring.SealReader(r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.blobs.Put(resealed, sealedReader); err != nil {
+		s.deleteBlob(resealed)
+		return "", err
+	}
+
+	return resealed, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+)
+
+// ErrSessionNotFound is returned when a session does not exist.
+var ErrSessionNotFound = notFound("session not found")
+
+// CreateSession stores a new session.
+// It sets the creation timestamp of the session.
+func (s *service) CreateSession(ctx context.Context, sess *account.Session) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO sessions (id, user_id, refresh_hash, created_at, expires_at, totp_verified) VALUES (?, ?, ?, ?, ?, ?);`
+
+	sess.CreatedAt = time.Now().UTC()
+
+	_, err := s.db.ExecContext(ctx, sqlInsert, sess.Id, sess.UserId, sess.RefreshHash, sess.CreatedAt, sess.ExpiresAt.UTC(), sess.TOTPVerified)
+	return err
+}
+
+// GetSession retrieves a session by its id.
+// It returns ErrSessionNotFound if the session does not exist.
+func (s *service) GetSession(ctx context.Context, id string) (*account.Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, user_id, refresh_hash, created_at, expires_at, revoked_at, totp_verified FROM sessions WHERE id = ?;`
+
+	var sess account.Session
+	var revokedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, sqlSelect, id).
+		Scan(&sess.Id, &sess.UserId, &sess.RefreshHash, &sess.CreatedAt, &sess.ExpiresAt, &revokedAt, &sess.TOTPVerified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if revokedAt.Valid {
+		sess.RevokedAt = &revokedAt.Time
+	}
+
+	return &sess, nil
+}
+
+// RotateSession replaces the refresh token hash of an active session if the
+// current one matches oldHash, and extends the session until expiresAt.
+// It returns false if it does not, so a refresh token can only be used once.
+func (s *service) RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE sessions SET refresh_hash = ?, expires_at = ? WHERE id = ? AND refresh_hash = ? AND revoked_at IS NULL AND expires_at > ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlUpdate, newHash, expiresAt.UTC(), id, oldHash, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RevokeSession marks a session as revoked.
+// Revoking a session twice keeps the original revocation time.
+func (s *service) RevokeSession(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL;`
+
+	_, err := s.db.ExecContext(ctx, sqlUpdate, time.Now().UTC(), id)
+	return err
+}
+
+// DeleteExpiredSessions deletes the sessions that expired before the given
+// time, revoked or not. It returns the number of deleted sessions.
+func (s *service) DeleteExpiredSessions(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM sessions WHERE expires_at <= ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Snapshot writes a consistent copy of the whole database to a new file at
+// path with the SQLite online backup API, which unlike copying the database
+// file is safe while the database is written to. It returns the size of
+// the copy.
+func (s *service) Snapshot(ctx context.Context, path string) (int64, error) {
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("snapshot %s already exists", path)
+	}
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer destConn.Close()
+	srcConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer srcConn.Close()
+
+	err = destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			destSQLite, ok := sqliteConn(destDriver)
+			srcSQLite, ok2 := sqliteConn(srcDriver)
+			if !ok || !ok2 {
+				return errors.New("snapshots need the sqlite3 driver")
+			}
+
+			b, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			// Copying all pages in one step reads a single version of the
+			// database, which in WAL mode does not block writers.
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// statements holds the statements run on every request, prepared once when
+// the database is opened.
+type statements struct {
+	getClipboard     *sql.Stmt
+	clipboardTags    *sql.Stmt
+	clipboardFlavors *sql.Stmt
+	checkVersion     *sql.Stmt
+	updateClipboard  *sql.Stmt
+	markRead         *sql.Stmt
+	logAccess        *sql.Stmt
+	role             *sql.Stmt
+}
+
+// prepareStatements prepares the hot-path statements. It must run after the
+// migrations, since preparing fails on missing tables and columns.
+func prepareStatements(db *sql.DB) (*statements, error) {
+	st := &statements{}
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&st.getClipboard, `SELECT ` + clipboardColumns + ` FROM clipboards WHERE id = ?;`},
+		{&st.clipboardTags, `SELECT clipboard_id, tag FROM clipboard_tags WHERE clipboard_id = ? ORDER BY tag;`},
+		{&st.clipboardFlavors, `SELECT clipboard_id, type, data, sealed, nonce FROM clipboard_flavors WHERE clipboard_id = ? ORDER BY id;`},
+		{&st.checkVersion, `SELECT blob_key FROM clipboards WHERE id = ? AND version = ?;`},
+		{&st.updateClipboard, `UPDATE clipboards SET name = ?, type = ?, data = ?, sealed = ?, nonce = ?, sealed_fields = ?, filename = ?, content_disposition = ?, charset = ?, locale = ?, quarantine = ?, blob_key = NULL, updated_at = ?, size = ?, content_hash = ?, transforms = ?, metadata = ?, publish_at = ?, expires_at = ?, version = version + 1 WHERE id = ?;`},
+		{&st.markRead, `UPDATE clipboards SET last_read_at = ? WHERE id = ?;`},
+		{&st.logAccess, `INSERT INTO access_log (clipboard_id, action, outcome, ip, device, created_at) VALUES (?, ?, ?, ?, ?, ?);`},
+		{&st.role, `SELECT role FROM clipboard_permissions WHERE clipboard_id = ? AND user_id = ?;`},
+	}
+
+	for _, q := range queries {
+		stmt, err := db.Prepare(q.query)
+		if err != nil {
+			st.close()
+			return nil, fmt.Errorf("cannot prepare %q: %w", q.query, err)
+		}
+		*q.stmt = stmt
+	}
+
+	return st, nil
+}
+
+// close closes the prepared statements.
+func (st *statements) close() {
+	for _, stmt := range []*sql.Stmt{st.getClipboard, st.clipboardTags, st.clipboardFlavors, st.checkVersion, st.updateClipboard, st.markRead, st.logAccess, st.role} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// sizeHistory is how many versions of a clipboard its size is kept for.
+const sizeHistory = 100
+
+// ClipboardStats summarizes the successful accesses of a clipboard in its
+// access log, and the sizes of its last versions.
+func (s *service) ClipboardStats(ctx context.Context, clipboardId int) (*clipboard.Stats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// The times are selected by id, as SQLite returns aggregated
+	// timestamps as text.
+	sqlDevices := `SELECT g.device, g.reads, g.writes, l.created_at FROM (
+			SELECT device, SUM(action = ?) AS reads, SUM(action <> ?) AS writes, MAX(id) AS last_id
+			FROM access_log WHERE clipboard_id = ? AND outcome = ? AND action IN (?, ?, ?) GROUP BY device
+		) g JOIN access_log l ON l.id = g.last_id ORDER BY g.reads + g.writes DESC, g.device;`
+	sqlLastRead := `SELECT created_at FROM access_log WHERE clipboard_id = ? AND action = ? AND outcome = ? ORDER BY id DESC LIMIT 1;`
+	sqlSizes := `SELECT version, size, recorded_at FROM clipboard_sizes WHERE clipboard_id = ? ORDER BY version;`
+
+	stats := &clipboard.Stats{Devices: []clipboard.DeviceStats{}, Sizes: []clipboard.SizeEntry{}}
+
+	rows, err := s.db.QueryContext(ctx, sqlDevices, clipboard.ActionRead, clipboard.ActionRead, clipboardId, clipboard.OutcomeSuccess, clipboard.ActionRead, clipboard.ActionCreate, clipboard.ActionUpdate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d clipboard.DeviceStats
+		if err := rows.Scan(&d.Device, &d.Reads, &d.Writes, &d.LastAccessAt); err != nil {
+			return nil, err
+		}
+		stats.Reads += d.Reads
+		stats.Devices = append(stats.Devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var lastRead time.Time
+	err = s.db.QueryRowContext(ctx, sqlLastRead, clipboardId, clipboard.ActionRead, clipboard.OutcomeSuccess).Scan(&lastRead)
+	switch {
+	case err == nil:
+		stats.LastReadAt = &lastRead
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, sqlSizes, clipboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e clipboard.SizeEntry
+		if err := rows.Scan(&e.Version, &e.Size, &e.RecordedAt); err != nil {
+			return nil, err
+		}
+		stats.Sizes = append(stats.Sizes, e)
+	}
+
+	return stats, rows.Err()
+}
+
+// recordSize records the size of a clipboard at a version, and drops the
+// sizes of versions older than sizeHistory.
+func recordSize(ctx context.Context, tx *sql.Tx, clipboardId, version int, size int64, at time.Time) error {
+	sqlInsert := `INSERT OR REPLACE INTO clipboard_sizes (clipboard_id, version, size, recorded_at) VALUES (?, ?, ?, ?);`
+	sqlPrune := `DELETE FROM clipboard_sizes WHERE clipboard_id = ? AND version <= ?;`
+
+	if _, err := tx.ExecContext(ctx, sqlInsert, clipboardId, version, size, at); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, sqlPrune, clipboardId, version-sizeHistory)
+	return err
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// subscriptionColumns lists the columns scanned into a subscription, in order.
+const subscriptionColumns = `n.id, n.clipboard_id, n.user_id, n.channel, n.target, n.include_data, n.created_at`
+
+// CreateSubscription stores a notification subscription.
+// It sets the id and creation timestamp of the subscription.
+func (s *service) CreateSubscription(ctx context.Context, sub *clipboard.Subscription) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO clipboard_notifications (clipboard_id, user_id, channel, target, include_data, created_at) VALUES (?, ?, ?, ?, ?, ?);`
+
+	sub.CreatedAt = time.Now().UTC()
+
+	result, err := s.db.ExecContext(ctx, sqlInsert, sub.ClipboardId, sub.UserId, sub.Channel, sub.Target, sub.IncludeData, sub.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	sub.Id = int(id)
+	return err
+}
+
+// Subscriptions retrieves the subscriptions of a user to a clipboard.
+func (s *service) Subscriptions(ctx context.Context, clipboardId, userId int) ([]clipboard.Subscription, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + subscriptionColumns + ` FROM clipboard_notifications n WHERE n.clipboard_id = ? AND n.user_id = ? ORDER BY n.id;`
+
+	return s.querySubscriptions(ctx, sqlSelect, clipboardId, userId)
+}
+
+// NotifiedSubscriptions retrieves the subscriptions to a clipboard of users
+// who may still read it: the owner, users it is shared with, or anyone for
+// anonymous clipboards.
+func (s *service) NotifiedSubscriptions(ctx context.Context, clipboardId int) ([]clipboard.Subscription, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + subscriptionColumns + ` FROM clipboard_notifications n JOIN clipboards c ON c.id = n.clipboard_id
+		WHERE n.clipboard_id = ? AND (c.owner_id IS NULL OR c.owner_id = n.user_id
+			OR EXISTS (SELECT 1 FROM clipboard_permissions p WHERE p.clipboard_id = n.clipboard_id AND p.user_id = n.user_id))
+		ORDER BY n.id;`
+
+	return s.querySubscriptions(ctx, sqlSelect, clipboardId)
+}
+
+// DeleteSubscription removes a subscription of a user to a clipboard.
+// It returns false if the user has no such subscription.
+func (s *service) DeleteSubscription(ctx context.Context, clipboardId, userId, id int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_notifications WHERE clipboard_id = ? AND user_id = ? AND id = ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, clipboardId, userId, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ExpireSubscription removes a subscription the channel no longer delivers
+// to, whoever it belongs to.
+func (s *service) ExpireSubscription(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM clipboard_notifications WHERE id = ?;`, id)
+	return err
+}
+
+func (s *service) querySubscriptions(ctx context.Context, query string, args ...any) ([]clipboard.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []clipboard.Subscription{}
+	for rows.Next() {
+		var sub clipboard.Subscription
+		if err := rows.Scan(&sub.Id, &sub.ClipboardId, &sub.UserId, &sub.Channel, &sub.Target, &sub.IncludeData, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
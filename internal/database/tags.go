@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// AddTags adds tags to a clipboard. Tags the clipboard already has are ignored.
+func (s *service) AddTags(ctx context.Context, id int, tags []string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertTags(ctx, tx, id, tags); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveTag removes a tag from a clipboard.
+func (s *service) RemoveTag(ctx context.Context, id int, tag string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	defer s.cache.invalidate(id)
+
+	sqlDelete := `DELETE FROM clipboard_tags WHERE clipboard_id = ? AND tag = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlDelete, id, tag)
+	return err
+}
+
+func insertTags(ctx context.Context, tx *sql.Tx, id int, tags []string) error {
+	sqlInsert := `INSERT INTO clipboard_tags (clipboard_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING;`
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, sqlInsert, id, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadTags fills in the tags of the given clipboards.
+func (s *service) loadTags(ctx context.Context, cs ...*clipboard.Clipboard) error {
+	if len(cs) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*clipboard.Clipboard, len(cs))
+	args := make([]any, 0, len(cs))
+	for _, c := range cs {
+		c.Tags = []string{}
+		byId[c.Id] = c
+		args = append(args, c.Id)
+	}
+
+	var rows *sql.Rows
+	var err error
+	if len(args) == 1 {
+		rows, err = s.stmts.clipboardTags.QueryContext(ctx, args[0])
+	} else {
+		sqlSelect := `SELECT clipboard_id, tag FROM clipboard_tags WHERE clipboard_id IN (` + placeholders(len(args)) + `) ORDER BY tag;`
+		rows, err = s.db.QueryContext(ctx, sqlSelect, args...)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		byId[id].Tags = append(byId[id].Tags, tag)
+	}
+
+	return rows.Err()
+}
+
+// placeholders returns n comma-separated query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
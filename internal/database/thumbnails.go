@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ErrThumbnailNotFound is returned when no thumbnail is stored for a
+// version of a clipboard.
+var ErrThumbnailNotFound = notFound("thumbnail not found")
+
+// Thumbnail retrieves the thumbnail of a clipboard generated for the given
+// version, opening it if it is sealed at rest.
+// It returns ErrThumbnailNotFound if there is none.
+func (s *service) Thumbnail(ctx context.Context, clipboardId, version int) ([]byte, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT data, sealed FROM clipboard_thumbnails WHERE clipboard_id = ? AND version = ?;`
+
+	var data string
+	var sealed bool
+	err := s.db.QueryRowContext(ctx, sqlSelect, clipboardId, version).Scan(&data, &sealed)
+	if err == sql.ErrNoRows {
+		return nil, ErrThumbnailNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.open(data, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+// SaveThumbnail stores the thumbnail of a clipboard for the given version,
+// replacing the thumbnail of a previous version. Read-only databases do not
+// cache thumbnails.
+func (s *service) SaveThumbnail(ctx context.Context, clipboardId, version int, thumbnail []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if s.readOnly {
+		return nil
+	}
+	sqlUpsert := `INSERT INTO clipboard_thumbnails (clipboard_id, version, data, sealed, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (clipboard_id) DO UPDATE SET version = excluded.version, data = excluded.data, sealed = excluded.sealed, created_at = excluded.created_at;`
+
+	data, err := s.keyring.Seal(string(thumbnail))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, sqlUpsert, clipboardId, version, data, s.sealed(), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrTokenNotFound is returned when a clipboard token does not exist.
+var ErrTokenNotFound = notFound("clipboard token not found")
+
+// tokenColumns lists the columns scanned by scanToken, in order.
+const tokenColumns = `id, clipboard_id, name, scopes, token_hash, created_at, expires_at, last_used_at, max_views, views`
+
+// CreateToken stores a new clipboard token.
+// It sets the creation timestamp of the token.
+func (s *service) CreateToken(ctx context.Context, t *clipboard.Token) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO clipboard_tokens (id, clipboard_id, name, scopes, token_hash, created_at, expires_at, max_views) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+
+	t.CreatedAt = time.Now().UTC()
+
+	var expiresAt sql.NullTime
+	if t.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: t.ExpiresAt.UTC(), Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, sqlInsert, t.Id, t.ClipboardId, t.Name, strings.Join(t.Scopes, ","), t.Hash, t.CreatedAt, expiresAt, t.MaxViews)
+	return err
+}
+
+// TokenByHash retrieves a clipboard token by the hash of its secret.
+// It returns ErrTokenNotFound if the token does not exist.
+func (s *service) TokenByHash(ctx context.Context, hash string) (*clipboard.Token, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + tokenColumns + ` FROM clipboard_tokens WHERE token_hash = ?;`
+
+	t, err := scanToken(s.db.QueryRowContext(ctx, sqlSelect, hash))
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	return t, err
+}
+
+// Tokens retrieves the tokens of a clipboard, oldest first.
+func (s *service) Tokens(ctx context.Context, clipboardId int) ([]*clipboard.Token, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT ` + tokenColumns + ` FROM clipboard_tokens WHERE clipboard_id = ? ORDER BY created_at, id;`
+
+	rows, err := s.db.QueryContext(ctx, sqlSelect, clipboardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ts := []*clipboard.Token{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+
+	return ts, rows.Err()
+}
+
+// DeleteToken revokes a token of a clipboard.
+// It returns false if the clipboard has no such token.
+func (s *service) DeleteToken(ctx context.Context, clipboardId int, id string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM clipboard_tokens WHERE clipboard_id = ? AND id = ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, clipboardId, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// MarkTokenUsed records that a token was just used, unless the database is
+// read-only.
+func (s *service) MarkTokenUsed(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if s.readOnly {
+		return nil
+	}
+	sqlUpdate := `UPDATE clipboard_tokens SET last_used_at = ? WHERE id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlUpdate, time.Now().UTC(), id)
+	return err
+}
+
+// UseTokenView counts a read with a token limited to a number of views.
+// It returns false if its views are used up.
+func (s *service) UseTokenView(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if s.readOnly {
+		return false, ErrReadOnly
+	}
+	sqlUpdate := `UPDATE clipboard_tokens SET views = views + 1 WHERE id = ? AND (max_views = 0 OR views < max_views);`
+
+	result, err := s.db.ExecContext(ctx, sqlUpdate, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanToken(row scanner) (*clipboard.Token, error) {
+	var t clipboard.Token
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&t.Id, &t.ClipboardId, &t.Name, &scopes, &t.Hash, &t.CreatedAt, &expiresAt, &lastUsedAt, &t.MaxViews, &t.Views); err != nil {
+		return nil, err
+	}
+
+	t.Scopes = strings.Split(scopes, ",")
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &t, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// The operations of writes in a transaction.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Write is a write to a clipboard applied by Transact.
+type Write struct {
+	// Op is OpCreate, OpUpdate or OpDelete.
+	Op string
+	// Clipboard is the clipboard to create, or to update or delete if it is
+	// still at its version.
+	Clipboard *clipboard.Clipboard
+}
+
+// WriteError is the error of the write a transaction failed at.
+type WriteError struct {
+	// Index is the position of the write in the transaction.
+	Index int
+	Err   error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("write %d: %v", e.Index, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Transact applies writes to clipboards in a single transaction, so either
+// all of them are applied or none is. Clipboards are created like Insert
+// and updated like Update does. Deleting a deduplicated clipboard drops one
+// of its references, and deleting the last one deletes it like Delete.
+// Updated clipboards have their version incremented once the transaction
+// is committed.
+// If a write fails, it returns a *WriteError wrapping ErrVersionConflict if
+// a clipboard to update or delete is no longer at its version, and
+// ErrClipboardExists if the id of a clipboard to create is taken.
+func (s *service) Transact(ctx context.Context, writes []Write) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUnref := `UPDATE clipboards SET refs = refs - 1 WHERE id = ? AND refs > 1;`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var blobKeys []string
+	for i, w := range writes {
+		c := w.Clipboard
+		if w.Op != OpCreate {
+			defer s.cache.invalidate(c.Id)
+		}
+
+		var blobKey string
+		switch w.Op {
+		case OpCreate:
+			err = s.insert(ctx, tx, c)
+		case OpUpdate:
+			blobKey, err = s.updateTx(ctx, tx, c)
+		case OpDelete:
+			var key sql.NullString
+			if err = tx.Stmt(s.stmts.checkVersion).QueryRowContext(ctx, c.Id, c.Version).Scan(&key); err == sql.ErrNoRows {
+				err = ErrVersionConflict
+				break
+			}
+			if err != nil {
+				break
+			}
+			var result sql.Result
+			if result, err = tx.ExecContext(ctx, sqlUnref, c.Id); err != nil {
+				break
+			}
+			var n int64
+			if n, err = result.RowsAffected(); err == nil && n == 0 {
+				blobKey, err = deleteClipboard(ctx, tx, c.Id)
+			}
+		default:
+			err = fmt.Errorf("unknown operation %q", w.Op)
+		}
+		if err != nil {
+			return &WriteError{Index: i, Err: err}
+		}
+		blobKeys = append(blobKeys, blobKey)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, w := range writes {
+		s.deleteBlob(blobKeys[i])
+		if w.Op == OpUpdate {
+			w.Clipboard.BlobKey = ""
+			w.Clipboard.Version++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// ErrUploadNotFound is returned when an upload does not exist or has expired.
+var ErrUploadNotFound = notFound("upload not found")
+
+// CreateUpload stores a new, empty upload.
+// It sets the creation timestamp of the upload.
+func (s *service) CreateUpload(ctx context.Context, u *clipboard.Upload) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO uploads (id, owner_id, name, type, filename, content_disposition, clipboard_id, is_encrypted, tags, data, sealed, size, length, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, 0, ?, ?, ?);`
+
+	u.CreatedAt = time.Now().UTC()
+	u.Offset = 0
+
+	_, err := s.db.ExecContext(ctx, sqlInsert, u.Id, nullInt(u.OwnerId), u.Name, u.DataType, nullString(u.Filename), nullString(u.ContentDisposition), nullInt(u.ClipboardId), u.IsEncrypted, strings.Join(u.Tags, ","), s.sealed(), u.Length, u.CreatedAt, u.ExpiresAt)
+	return err
+}
+
+// GetUpload retrieves the metadata of an upload by its id.
+// It returns ErrUploadNotFound if the upload does not exist or has expired.
+func (s *service) GetUpload(ctx context.Context, id string) (*clipboard.Upload, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, owner_id, name, type, filename, content_disposition, clipboard_id, is_encrypted, tags, size, length, created_at, expires_at FROM uploads WHERE id = ? AND expires_at > ?;`
+
+	var u clipboard.Upload
+	var ownerId, clipboardId sql.NullInt64
+	var filename, disposition sql.NullString
+	var tags string
+	err := s.db.QueryRowContext(ctx, sqlSelect, id, time.Now().UTC()).
+		Scan(&u.Id, &ownerId, &u.Name, &u.DataType, &filename, &disposition, &clipboardId, &u.IsEncrypted, &tags, &u.Offset, &u.Length, &u.CreatedAt, &u.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	u.OwnerId = int(ownerId.Int64)
+	u.Filename = filename.String
+	u.ContentDisposition = disposition.String
+	u.ClipboardId = int(clipboardId.Int64)
+	u.Tags = []string{}
+	if tags != "" {
+		u.Tags = strings.Split(tags, ",")
+	}
+
+	return &u, nil
+}
+
+// AppendUpload appends a chunk to an upload if offset matches the number of
+// bytes received so far. It returns false if it does not.
+// Sealed uploads store each chunk sealed on its own line.
+func (s *service) AppendUpload(ctx context.Context, id string, offset int, chunk string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE uploads SET data = data || ?, size = size + ? WHERE id = ? AND size = ? AND sealed = ?;`
+
+	data, err := s.keyring.Seal(chunk)
+	if err != nil {
+		return false, err
+	}
+	if s.keyring != nil {
+		data += "\n"
+	}
+
+	result, err := s.db.ExecContext(ctx, sqlUpdate, data, len(chunk), id, offset, s.sealed())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UploadData retrieves the data received so far for an upload.
+func (s *service) UploadData(ctx context.Context, id string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT data, sealed FROM uploads WHERE id = ?;`
+
+	var data string
+	var sealed bool
+	if err := s.db.QueryRowContext(ctx, sqlSelect, id).Scan(&data, &sealed); err != nil {
+		return "", err
+	}
+	if !sealed {
+		return data, nil
+	}
+
+	var b strings.Builder
+	for _, chunk := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if chunk == "" {
+			continue
+		}
+		opened, err := s.keyring.Open(chunk)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(opened)
+	}
+
+	return b.String(), nil
+}
+
+// DeleteUpload deletes an upload by its id.
+func (s *service) DeleteUpload(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM uploads WHERE id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlDelete, id)
+	return err
+}
+
+// DeleteExpiredUploads deletes the uploads that expired before the given time.
+// It returns the number of deleted uploads.
+func (s *service) DeleteExpiredUploads(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlDelete := `DELETE FROM uploads WHERE expires_at <= ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlDelete, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
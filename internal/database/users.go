@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+)
+
+var (
+	// ErrUserNotFound is returned when a user does not exist.
+	ErrUserNotFound = notFound("user not found")
+	// ErrTOTPNotFound is returned when a user has no TOTP secret.
+	ErrTOTPNotFound = notFound("two-factor authentication is not set up")
+)
+
+// EnsureUser retrieves the user with the given name in a namespace,
+// creating it with the user role if it does not exist yet. Read-only
+// databases return ErrReadOnly instead of creating it.
+func (s *service) EnsureUser(ctx context.Context, namespace, name string) (*account.User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlInsert := `INSERT INTO users (namespace, name, role, created_at) VALUES (?, ?, ?, ?);`
+
+	u, err := s.UserByName(ctx, namespace, name)
+	if !errors.Is(err, ErrUserNotFound) {
+		return u, err
+	}
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	u = &account.User{Name: name, Namespace: namespace, Role: account.RoleUser, CreatedAt: time.Now().UTC()}
+	result, err := s.db.ExecContext(ctx, sqlInsert, u.Namespace, u.Name, u.Role, u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	u.Id = int(id)
+
+	return u, nil
+}
+
+// User retrieves a user by id.
+// It returns ErrUserNotFound if the user does not exist.
+func (s *service) User(ctx context.Context, id int) (*account.User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, name, namespace, role, created_at, totp_enabled FROM users WHERE id = ?;`
+
+	var u account.User
+	err := s.db.QueryRowContext(ctx, sqlSelect, id).Scan(&u.Id, &u.Name, &u.Namespace, &u.Role, &u.CreatedAt, &u.TOTPEnabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// UserByName retrieves a user by name within a namespace.
+// It returns ErrUserNotFound if the user does not exist.
+func (s *service) UserByName(ctx context.Context, namespace, name string) (*account.User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT id, name, namespace, role, created_at, totp_enabled FROM users WHERE namespace = ? AND name = ?;`
+
+	var u account.User
+	err := s.db.QueryRowContext(ctx, sqlSelect, namespace, name).Scan(&u.Id, &u.Name, &u.Namespace, &u.Role, &u.CreatedAt, &u.TOTPEnabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// SetRole changes the role of a user.
+func (s *service) SetRole(ctx context.Context, userId int, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE users SET role = ? WHERE id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlUpdate, role, userId)
+	return err
+}
+
+// Usage reports how many clipboards a user owns and how many bytes they and
+// their stack items take up. Owner 0 accounts for the clipboards created
+// anonymously in the namespace.
+func (s *service) Usage(ctx context.Context, namespace string, ownerId int) (int, int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT COUNT(*), COALESCE(SUM(size), 0) + COALESCE((SELECT SUM(i.size) FROM clipboard_items i JOIN clipboards c ON c.id = i.clipboard_id WHERE c.owner_id = ?), 0) FROM clipboards WHERE owner_id = ?;`
+	sqlSelectAnonymous := `SELECT COUNT(*), COALESCE(SUM(size), 0) + COALESCE((SELECT SUM(i.size) FROM clipboard_items i JOIN clipboards c ON c.id = i.clipboard_id WHERE c.owner_id IS NULL AND c.namespace = ?), 0) FROM clipboards WHERE owner_id IS NULL AND namespace = ?;`
+
+	var count int
+	var bytes int64
+	var err error
+	if ownerId == 0 {
+		err = s.db.QueryRowContext(ctx, sqlSelectAnonymous, namespace, namespace).Scan(&count, &bytes)
+	} else {
+		err = s.db.QueryRowContext(ctx, sqlSelect, ownerId, ownerId).Scan(&count, &bytes)
+	}
+
+	return count, bytes, err
+}
+
+// TOTP retrieves the TOTP secret of a user, opening it if it is sealed at
+// rest. It returns ErrTOTPNotFound if the user has not started enrolling.
+func (s *service) TOTP(ctx context.Context, userId int) (*account.TOTP, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlSelect := `SELECT totp_secret, totp_sealed, totp_enabled, totp_last_step FROM users WHERE id = ? AND totp_secret IS NOT NULL;`
+
+	var t account.TOTP
+	var sealed bool
+	err := s.db.QueryRowContext(ctx, sqlSelect, userId).Scan(&t.Secret, &sealed, &t.Enabled, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, ErrTOTPNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Secret, err = s.open(t.Secret, sealed); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SetTOTPSecret stores a new TOTP secret of a user, sealing it at rest. The
+// secret is not required until UseTOTP confirms it.
+func (s *service) SetTOTPSecret(ctx context.Context, userId int, secret string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE users SET totp_secret = ?, totp_sealed = ?, totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?;`
+
+	sealed, err := s.keyring.Seal(secret)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, sqlUpdate, sealed, s.sealed(), userId)
+	return err
+}
+
+// UseTOTP records that a code of the given time step was accepted, which
+// enables the TOTP secret of the user if it was not yet. It returns false
+// if a code of the same or a later step was accepted meanwhile.
+func (s *service) UseTOTP(ctx context.Context, userId int, step int64) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE users SET totp_enabled = TRUE, totp_last_step = ? WHERE id = ? AND totp_secret IS NOT NULL AND totp_last_step < ?;`
+
+	result, err := s.db.ExecContext(ctx, sqlUpdate, step, userId, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// DisableTOTP removes the TOTP secret of a user.
+func (s *service) DisableTOTP(ctx context.Context, userId int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlUpdate := `UPDATE users SET totp_secret = NULL, totp_sealed = FALSE, totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?;`
+
+	_, err := s.db.ExecContext(ctx, sqlUpdate, userId)
+	return err
+}
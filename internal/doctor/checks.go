@@ -0,0 +1,145 @@
+package doctor
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// certExpiryWarning is how long before its expiry a certificate is warned
+// about.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// slowKDF is how long deriving a key from a password may take before it is
+// warned about: every read of an encrypted clipboard pays for it.
+const slowKDF = time.Second
+
+// TLS checks the certificate configured with TLS_CERT and TLS_KEY, or the
+// cache directory of certificates obtained with TLS_AUTOCERT_DOMAINS, as of
+// now.
+func TLS(r *Report, now time.Time) {
+	certFile, keyFile := env.String("TLS_CERT", ""), env.String("TLS_KEY", "")
+
+	switch {
+	case env.String("TLS_AUTOCERT_DOMAINS", "") != "":
+		dir := env.String("TLS_AUTOCERT_CACHE_DIR", "certs")
+		if err := CheckWritableDir(dir); err != nil {
+			r.Fail("tls", "make "+dir+" writable by the server or set TLS_AUTOCERT_CACHE_DIR", "cannot cache certificates: %v", err)
+			return
+		}
+		r.OK("tls", "certificates are obtained from Let's Encrypt and cached in %s", dir)
+		return
+	case certFile == "" && keyFile == "":
+		r.OK("tls", "not enabled, serving plain HTTP; terminate TLS in a reverse proxy")
+		return
+	case certFile == "" || keyFile == "":
+		r.Fail("tls", "set both TLS_CERT and TLS_KEY, or neither", "only one of TLS_CERT and TLS_KEY is set")
+		return
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		r.Fail("tls", "check that TLS_CERT and TLS_KEY are readable PEM files of a matching certificate and key", "cannot load certificate: %v", err)
+		return
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		r.Fail("tls", "check that TLS_CERT holds the server certificate first", "cannot parse certificate: %v", err)
+		return
+	}
+
+	switch {
+	case now.After(leaf.NotAfter):
+		r.Fail("tls", "renew the certificate", "certificate expired on %s", leaf.NotAfter.Format(time.DateOnly))
+		return
+	case now.Before(leaf.NotBefore):
+		r.Fail("tls", "check the clock of this host", "certificate is not valid before %s", leaf.NotBefore.Format(time.DateOnly))
+		return
+	case leaf.NotAfter.Sub(now) < certExpiryWarning:
+		r.Warn("tls", "renew the certificate", "certificate expires on %s", leaf.NotAfter.Format(time.DateOnly))
+	default:
+		r.OK("tls", "certificate valid until %s", leaf.NotAfter.Format(time.DateOnly))
+	}
+
+	if u, err := url.Parse(env.String("PUBLIC_URL", "")); err == nil && u.Hostname() != "" {
+		if err := leaf.VerifyHostname(u.Hostname()); err != nil {
+			r.Warn("tls", "use a certificate for the host of PUBLIC_URL", "certificate does not cover %s", u.Hostname())
+		}
+	}
+}
+
+// Crypto checks that random numbers can be read and that clipboards can be
+// encrypted and decrypted with the configured key derivation, and how long
+// deriving a key takes.
+func Crypto(r *Report) {
+	if _, err := rand.Read(make([]byte, 32)); err != nil {
+		r.Fail("crypto", "check the random number source of the system", "cannot read random numbers: %v", err)
+		return
+	}
+
+	c := &clipboard.Clipboard{Name: "doctor", DataType: "text/plain", Data: "self-test", EncryptionScope: clipboard.ScopeAll}
+	start := time.Now()
+	if err := c.Encrypt("password"); err != nil {
+		r.Fail("crypto", "", "encryption self-test failed: %v", err)
+		return
+	}
+	elapsed := time.Since(start)
+
+	if c.Data == "self-test" || c.Name == "doctor" {
+		r.Fail("crypto", "", "encryption self-test left the data readable")
+		return
+	}
+	sealed := *c
+	if err := sealed.Decrypt("wrong"); err == nil {
+		r.Fail("crypto", "", "decryption self-test accepted a wrong password")
+		return
+	}
+	if err := c.Decrypt("password"); err != nil || c.Data != "self-test" || c.Name != "doctor" {
+		r.Fail("crypto", "", "decryption self-test failed: %v", err)
+		return
+	}
+
+	if elapsed > slowKDF {
+		r.Warn("crypto", "lower KDF_SCRYPT_LOG_N or give the server more CPU", "deriving a key from a password takes %s", elapsed.Round(time.Millisecond))
+		return
+	}
+	r.OK("crypto", "encryption self-test passed, deriving a key takes %s", elapsed.Round(time.Millisecond))
+}
+
+// CheckWritableDir checks that files can be created in dir, or that dir
+// can be created if it does not exist.
+func CheckWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return err
+			}
+			dir = parent
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		break
+	}
+
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
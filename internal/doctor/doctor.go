@@ -0,0 +1,78 @@
+// Package doctor diagnoses the configuration and environment of the server
+// without starting it, so operators learn what would keep it from starting
+// or working correctly, and how to fix it, before launching it.
+package doctor
+
+import (
+	"fmt"
+	"io"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	OK      Status = "ok"
+	Warning Status = "warn"
+	Error   Status = "error"
+)
+
+// Finding is the outcome of a check.
+type Finding struct {
+	// Check names what was checked, e.g. "database".
+	Check   string
+	Status  Status
+	Message string
+	// Hint suggests how to fix a warning or error.
+	Hint string
+}
+
+// Report collects the findings of checks.
+type Report struct {
+	Findings []Finding
+}
+
+// OK records a passed check.
+func (r *Report) OK(check, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Check: check, Status: OK, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warn records a problem the server starts with, but which likely needs
+// fixing.
+func (r *Report) Warn(check, hint, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Check: check, Status: Warning, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// Fail records a problem the server does not start or work with.
+func (r *Report) Fail(check, hint, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Check: check, Status: Error, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	for _, f := range r.Findings {
+		if f.Status == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Write prints the findings, one per line with their hints below, and a
+// summary.
+func (r *Report) Write(w io.Writer) {
+	var warnings, errors int
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "%-5s  %-10s  %s\n", f.Status, f.Check, f.Message)
+		if f.Hint != "" {
+			fmt.Fprintf(w, "%-5s  %-10s  → %s\n", "", "", f.Hint)
+		}
+		switch f.Status {
+		case Warning:
+			warnings++
+		case Error:
+			errors++
+		}
+	}
+	fmt.Fprintf(w, "\n%d checks, %d warnings, %d errors\n", len(r.Findings), warnings, errors)
+}
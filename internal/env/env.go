@@ -0,0 +1,162 @@
+// Package env reads configuration values from environment variables.
+// Invalid values are replaced by their defaults and reported by Err, which
+// programs check once they are configured, so misconfiguration is caught at
+// startup.
+package env
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretPattern matches the names of variables holding secrets.
+var secretPattern = regexp.MustCompile(`_KEYS$|SECRET|PASSWORD|TOKEN`)
+
+var (
+	mu   sync.Mutex
+	read = make(map[string]string)
+	// invalid holds the errors of invalid values read since the last call
+	// of Err.
+	invalid []error
+)
+
+// record remembers the effective value of a variable for Snapshot.
+func record(key string, value any) {
+	mu.Lock()
+	defer mu.Unlock()
+	read[key] = fmt.Sprint(value)
+}
+
+// fail records that the value of a variable is invalid, for Err.
+func fail(key string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	invalid = append(invalid, fmt.Errorf("invalid %s: %w", key, err))
+}
+
+// Err returns the errors of the invalid values read since it was last
+// called, or nil if there were none.
+func Err() error {
+	mu.Lock()
+	defer mu.Unlock()
+	err := errors.Join(invalid...)
+	invalid = nil
+	return err
+}
+
+// Snapshot returns the effective values of all variables read so far,
+// including defaults. Secrets are redacted.
+func Snapshot() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	snapshot := make(map[string]string, len(read))
+	for key, value := range read {
+		if secretPattern.MatchString(key) && value != "" {
+			value = "[redacted]"
+		}
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// String returns the value of the variable, or def if it is unset or empty.
+func String(key, def string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		v = def
+	}
+	record(key, v)
+	return v
+}
+
+// Int returns the integer value of the variable, or def if it is unset or
+// invalid.
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		record(key, def)
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		fail(key, err)
+		record(key, def)
+		return def
+	}
+	record(key, i)
+	return i
+}
+
+// Int64 returns the 64-bit integer value of the variable, or def if it is
+// unset or invalid.
+func Int64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		record(key, def)
+		return def
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		fail(key, err)
+		record(key, def)
+		return def
+	}
+	record(key, i)
+	return i
+}
+
+// Bool returns the boolean value of the variable, or def if it is unset or
+// invalid.
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		record(key, def)
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		fail(key, err)
+		record(key, def)
+		return def
+	}
+	record(key, b)
+	return b
+}
+
+// Duration returns the duration value of the variable, or def if it is unset
+// or invalid.
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		record(key, def)
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fail(key, err)
+		record(key, def)
+		return def
+	}
+	record(key, d)
+	return d
+}
+
+// List returns the comma-separated values of the variable, with surrounding
+// whitespace and empty entries removed.
+func List(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	record(key, strings.Join(list, ","))
+	return list
+}
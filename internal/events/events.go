@@ -0,0 +1,102 @@
+// Package events fans out clipboard changes to in-process subscribers such
+// as the MQTT bridge.
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	ClipboardCreated = "clipboard.created"
+	ClipboardUpdated = "clipboard.updated"
+	ClipboardDeleted = "clipboard.deleted"
+	ItemPushed       = "item.pushed"
+	ItemPopped       = "item.popped"
+)
+
+// Event describes a change to a clipboard.
+// Seq is assigned by the bus and increases with every published event, so
+// subscribers can tell the order of changes and notice gaps.
+// Data is only set for unencrypted clipboards, so subscribers never see the
+// content of password-protected ones. Streamed clipboards are announced
+// without their data, which subscribers fetch themselves.
+type Event struct {
+	Seq         uint64    `json:"seq,omitempty"`
+	Type        string    `json:"event,omitempty"`
+	ClipboardId int       `json:"id"`
+	PublicId    string    `json:"public_id,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	DataType    string    `json:"type,omitempty"`
+	Data        string    `json:"data,omitempty"`
+	IsEncrypted bool      `json:"is_encrypted"`
+	Streamed    bool      `json:"streamed,omitempty"`
+	Version     int       `json:"version,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Bus delivers published events to all subscribers.
+// Publishing never blocks: events are dropped for subscribers whose buffer
+// is full.
+type Bus struct {
+	mu     sync.Mutex
+	seq    uint64
+	nextId int
+	subs   map[int]chan Event
+}
+
+// NewBus creates an event bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]chan Event)}
+}
+
+// Publish assigns the next sequence number to an event and sends it to all
+// subscribers.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.Seq = b.seq
+	for id, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("events: dropping %s for clipboard %d, subscriber %d is too slow", e.Type, e.ClipboardId, id)
+		}
+	}
+}
+
+// Seq returns the sequence number of the last published event.
+func (b *Bus) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// Subscribe registers a subscriber with the given buffer size.
+// The returned function unsubscribes and closes the channel.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	id := b.nextId
+	b.nextId++
+	b.subs[id] = ch
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
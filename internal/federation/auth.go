@@ -0,0 +1,71 @@
+package federation
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers authenticating requests between peers.
+const (
+	TimestampHeader = "X-Federation-Timestamp"
+	SignatureHeader = "X-Federation-Signature"
+)
+
+// maxClockSkew is how far the timestamp of a request may be off, which also
+// bounds how long a captured request can be replayed.
+const maxClockSkew = 5 * time.Minute
+
+// Errors returned by Verify.
+var (
+	ErrUnsigned         = errors.New("request is not signed")
+	ErrExpiredSignature = errors.New("request timestamp is too far off")
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// Sign signs a request with the shared secret. body must be the body the
+// request is sent with.
+func Sign(r *http.Request, body []byte, secret string, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, signature(r.Method, r.URL.RequestURI(), timestamp, body, secret))
+}
+
+// Verify checks the signature of a request made with Sign. body is the body
+// of the request, which the caller has read.
+func Verify(r *http.Request, body []byte, secret string, now time.Time) error {
+	timestamp := r.Header.Get(TimestampHeader)
+	sig := r.Header.Get(SignatureHeader)
+	if timestamp == "" || sig == "" {
+		return ErrUnsigned
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return ErrExpiredSignature
+	}
+
+	expected := signature(r.Method, r.URL.RequestURI(), timestamp, body, secret)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// signature is the hex-encoded HMAC-SHA256 of the method, request URI,
+// timestamp and body hash of a request.
+func signature(method, uri, timestamp string, body []byte, secret string) string {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
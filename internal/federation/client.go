@@ -0,0 +1,106 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds every request to a peer.
+const requestTimeout = 30 * time.Second
+
+// Client makes signed requests to the federation API of a peer.
+type Client struct {
+	baseURL string
+	secret  string
+	http    *http.Client
+}
+
+// NewClient returns a client of the peer at baseURL.
+func NewClient(baseURL, secret string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// String returns the base URL of the peer.
+func (c *Client) String() string {
+	return c.baseURL
+}
+
+// Entries lists the records of the namespace of the peer.
+func (c *Client) Entries(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+	if err := c.do(ctx, http.MethodGet, "/federation/records", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Get retrieves a record from the peer. It returns nil if the peer has no
+// record with the public id.
+func (c *Client) Get(ctx context.Context, publicId string) (*Record, error) {
+	var rec Record
+	err := c.do(ctx, http.MethodGet, "/federation/records/"+url.PathEscape(publicId), nil, &rec)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Push sends a record to the peer.
+func (c *Client) Push(ctx context.Context, rec *Record) (*PushResponse, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp PushResponse
+	if err := c.do(ctx, http.MethodPost, "/federation/records", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// errNotFound is returned for responses with status 404, which peers send
+// for unknown records and if they do not federate.
+var errNotFound = errors.New("not found")
+
+// do sends a signed request and decodes the JSON response into v.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	Sign(req, body, c.secret, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
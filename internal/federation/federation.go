@@ -0,0 +1,154 @@
+// Package federation replicates the clipboards of a shared namespace between
+// copybridge servers, so a home server and a server in the cloud can serve
+// the same clipboards.
+//
+// Clipboards are identified by their public ids, which are the same on all
+// servers, while their numeric ids are local. Every server pushes changes to
+// its peers as they happen and periodically reconciles its namespace with
+// every peer in both directions, so servers behind NAT only need to reach
+// their peers. Conflicting changes are resolved by keeping the most recent
+// one, see Newer.
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/backup"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// minSecretLength is the shortest shared secret accepted.
+const minSecretLength = 16
+
+// Config holds the federation settings.
+type Config struct {
+	// Secret is shared by all peers and authenticates their requests.
+	Secret string
+	// Namespace is the tag of the clipboards that are replicated.
+	Namespace string
+	// Peers are the base URLs of the servers to replicate with.
+	Peers []string
+	// SyncInterval is how often the namespace is reconciled with every peer.
+	SyncInterval time.Duration
+	// TombstoneTTL is how long deletions are remembered for peers that
+	// have not seen them yet.
+	TombstoneTTL time.Duration
+}
+
+// ConfigFromEnv reads the federation configuration from FEDERATION_*
+// variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Secret:       env.String("FEDERATION_SECRET", ""),
+		Namespace:    strings.ToLower(env.String("FEDERATION_NAMESPACE", "federated")),
+		Peers:        env.List("FEDERATION_PEERS"),
+		SyncInterval: env.Duration("FEDERATION_SYNC_INTERVAL", 5*time.Minute),
+		TombstoneTTL: env.Duration("FEDERATION_TOMBSTONE_TTL", 30*24*time.Hour),
+	}
+}
+
+// Enabled reports whether a shared secret is configured.
+func (c Config) Enabled() bool {
+	return c.Secret != ""
+}
+
+// Validate checks the configuration of an enabled federation.
+func (c Config) Validate() error {
+	if len(c.Secret) < minSecretLength {
+		return fmt.Errorf("secret must be at least %d bytes", minSecretLength)
+	}
+	if c.Namespace == "" {
+		return errors.New("namespace must not be empty")
+	}
+	if c.SyncInterval <= 0 {
+		return errors.New("sync interval must be positive")
+	}
+	for _, peer := range c.Peers {
+		u, err := url.Parse(peer)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("peer %q must be an http or https URL", peer)
+		}
+	}
+	return nil
+}
+
+// Record is a clipboard as exchanged between peers, or the tombstone of a
+// deleted one. Tombstones only carry the public id and the time of the
+// deletion in UpdatedAt. The numeric id and version of a record are those of
+// the sending server.
+type Record struct {
+	backup.Record
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Entry summarizes a record, so peers can tell which records differ
+// without exchanging their data.
+type Entry struct {
+	PublicId  string    `json:"public_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	// Hash covers the name, type, encryption and data of a clipboard.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Entry summarizes the record.
+func (r *Record) Entry() Entry {
+	e := Entry{PublicId: r.PublicId, UpdatedAt: r.UpdatedAt, Deleted: r.Deleted}
+	if !r.Deleted {
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%s\x00%t\x00%s\x00%s\x00%s\x00", r.Name, r.DataType, r.IsEncrypted, r.PasswordHash, r.Salt, r.Nonce)
+		// Sealed fields replace the name and type of clipboards encrypting
+		// them, and only count for those, so other hashes are unchanged.
+		// Wrapped keys likewise only count for clipboards that have one.
+		if r.SealedFields != "" {
+			fmt.Fprintf(h, "%s\x00", r.SealedFields)
+		}
+		if r.WrappedKey != "" {
+			fmt.Fprintf(h, "%s\x00", r.WrappedKey)
+		}
+		h.Write(r.Data)
+		e.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	return e
+}
+
+// Newer reports whether a is a more recent state of a clipboard than b.
+// The later update wins, and a deletion wins over an update at the same
+// time. Updates at the same time are ordered by their hash, so all peers
+// pick the same one. Deletions are never newer than each other. Tags, pins
+// and transforms are not compared, so changing only them is not replicated.
+func Newer(a, b Entry) bool {
+	if a.Deleted && b.Deleted {
+		return false
+	}
+	if !a.UpdatedAt.Equal(b.UpdatedAt) {
+		return a.UpdatedAt.After(b.UpdatedAt)
+	}
+	if a.Deleted || b.Deleted {
+		return a.Deleted && !b.Deleted
+	}
+	return a.Hash > b.Hash
+}
+
+// Results of applying a pushed record.
+const (
+	// ResultApplied means the record replaced the state of the receiver.
+	ResultApplied = "applied"
+	// ResultUnchanged means the receiver already had the same state.
+	ResultUnchanged = "unchanged"
+	// ResultKept means the receiver has a newer state, which it returns.
+	ResultKept = "kept"
+)
+
+// PushResponse answers a pushed record. Record holds the newer state of the
+// receiver if Result is ResultKept, so the sender can apply it right away.
+type PushResponse struct {
+	Result string  `json:"result"`
+	Record *Record `json:"record,omitempty"`
+}
@@ -0,0 +1,175 @@
+package federation
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/events"
+)
+
+// Store is the local side of the federation.
+type Store interface {
+	// Entries lists the records of the namespace, including tombstones.
+	Entries(ctx context.Context) ([]Entry, error)
+	// Record returns the record of a clipboard of the namespace or its
+	// tombstone, or nil if there is neither.
+	Record(ctx context.Context, publicId string) (*Record, error)
+	// Apply applies a record received from a peer unless the local state
+	// is newer. It returns the result and, with ResultKept, the local
+	// record.
+	Apply(ctx context.Context, rec *Record) (*PushResponse, error)
+	// Prune forgets deletions older than the given time.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// Syncer replicates the namespace of a store with the configured peers.
+type Syncer struct {
+	cfg   Config
+	store Store
+	peers []*Client
+}
+
+// NewSyncer creates a syncer of the store with the peers of the
+// configuration.
+func NewSyncer(cfg Config, store Store) *Syncer {
+	s := &Syncer{cfg: cfg, store: store}
+	for _, peer := range cfg.Peers {
+		s.peers = append(s.peers, NewClient(peer, cfg.Secret))
+	}
+	return s
+}
+
+// Run pushes the clipboard changes published on the bus to all peers until
+// ctx is done. Changes missed while a peer is unreachable are caught up by
+// Sync, which the caller runs every SyncInterval.
+func (s *Syncer) Run(ctx context.Context, bus *events.Bus) {
+	ch, unsubscribe := bus.Subscribe(64)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			switch e.Type {
+			case events.ClipboardCreated, events.ClipboardUpdated, events.ClipboardDeleted:
+				s.push(ctx, e.PublicId)
+			}
+		}
+	}
+}
+
+// Sync reconciles the namespace with every peer once and forgets deletions
+// older than TombstoneTTL.
+func (s *Syncer) Sync(ctx context.Context) {
+	for _, peer := range s.peers {
+		if err := s.reconcile(ctx, peer); err != nil {
+			log.Printf("federation: error syncing with %s: %v", peer, err)
+		}
+	}
+
+	if err := s.store.Prune(ctx, time.Now().Add(-s.cfg.TombstoneTTL)); err != nil {
+		log.Printf("federation: error pruning tombstones: %v", err)
+	}
+}
+
+// push sends the current state of a clipboard to all peers if it belongs to
+// the namespace. Peers that cannot be reached catch up on the next sync.
+func (s *Syncer) push(ctx context.Context, publicId string) {
+	if publicId == "" || len(s.peers) == 0 {
+		return
+	}
+	rec, err := s.store.Record(ctx, publicId)
+	if err != nil {
+		log.Printf("federation: error loading clipboard %s: %v", publicId, err)
+		return
+	}
+	if rec == nil {
+		return
+	}
+
+	for _, peer := range s.peers {
+		if err := s.pushTo(ctx, peer, rec); err != nil {
+			log.Printf("federation: error pushing clipboard %s to %s: %v", publicId, peer, err)
+		}
+	}
+}
+
+// pushTo sends a record to a peer and applies the state of the peer if it
+// is newer.
+func (s *Syncer) pushTo(ctx context.Context, peer *Client, rec *Record) error {
+	resp, err := peer.Push(ctx, rec)
+	if err != nil {
+		return err
+	}
+	if resp.Result != ResultKept || resp.Record == nil {
+		return nil
+	}
+	_, err = s.store.Apply(ctx, resp.Record)
+	return err
+}
+
+// reconcile compares the namespace with the one of a peer and exchanges the
+// records that differ, in whichever direction is newer.
+func (s *Syncer) reconcile(ctx context.Context, peer *Client) error {
+	remote, err := peer.Entries(ctx)
+	if err != nil {
+		return err
+	}
+	local, err := s.store.Entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	remoteById := make(map[string]Entry, len(remote))
+	for _, e := range remote {
+		remoteById[e.PublicId] = e
+	}
+
+	for _, l := range local {
+		r, ok := remoteById[l.PublicId]
+		delete(remoteById, l.PublicId)
+		switch {
+		case !ok && l.Deleted, ok && !Newer(l, r) && !Newer(r, l):
+			// The peer never had the clipboard, or has the same state.
+		case !ok || Newer(l, r):
+			if err := s.send(ctx, peer, l.PublicId); err != nil {
+				return err
+			}
+		default:
+			if err := s.fetch(ctx, peer, l.PublicId); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range remoteById {
+		if r.Deleted {
+			continue
+		}
+		if err := s.fetch(ctx, peer, r.PublicId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send pushes the local record of a clipboard to a peer.
+func (s *Syncer) send(ctx context.Context, peer *Client, publicId string) error {
+	rec, err := s.store.Record(ctx, publicId)
+	if err != nil || rec == nil {
+		return err
+	}
+	return s.pushTo(ctx, peer, rec)
+}
+
+// fetch applies the record of a clipboard of a peer.
+func (s *Syncer) fetch(ctx context.Context, peer *Client, publicId string) error {
+	rec, err := peer.Get(ctx, publicId)
+	if err != nil || rec == nil {
+		return err
+	}
+	_, err = s.store.Apply(ctx, rec)
+	return err
+}
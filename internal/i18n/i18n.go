@@ -0,0 +1,178 @@
+// Package i18n translates the messages of API errors. Catalogs are embedded
+// in the binary, one JSON file per language mapping English messages to
+// their translation. Parts of messages that vary, such as names and sizes,
+// are written {1}, {2}, ... in both and may be reordered by translations.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language messages are written in.
+const Default = "en"
+
+//go:embed catalogs/*.json
+var catalogFiles embed.FS
+
+// placeholder matches the placeholders of catalog entries.
+var placeholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// catalog holds the translations of a language.
+type catalog struct {
+	// exact maps messages without placeholders to their translation.
+	exact map[string]string
+	// patterns match messages with placeholders.
+	patterns []pattern
+}
+
+// pattern matches a message with placeholders. Its groups capture the
+// values of the placeholders, in the order given by order.
+type pattern struct {
+	re          *regexp.Regexp
+	order       []int
+	translation string
+}
+
+// catalogs holds the catalog of every language but Default.
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]*catalog {
+	files, err := catalogFiles.ReadDir("catalogs")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]*catalog)
+	for _, f := range files {
+		data, err := catalogFiles.ReadFile(path.Join("catalogs", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("invalid catalog %s: %v", f.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(f.Name(), ".json")] = newCatalog(messages)
+	}
+	return catalogs
+}
+
+func newCatalog(messages map[string]string) *catalog {
+	c := &catalog{exact: make(map[string]string)}
+	for message, translation := range messages {
+		if !placeholder.MatchString(message) {
+			c.exact[message] = translation
+			continue
+		}
+
+		var expr strings.Builder
+		var order []int
+		last := 0
+		for _, loc := range placeholder.FindAllStringSubmatchIndex(message, -1) {
+			expr.WriteString(regexp.QuoteMeta(message[last:loc[0]]))
+			expr.WriteString("(.+?)")
+			n, _ := strconv.Atoi(message[loc[2]:loc[3]])
+			order = append(order, n)
+			last = loc[1]
+		}
+		expr.WriteString(regexp.QuoteMeta(message[last:]))
+		c.patterns = append(c.patterns, pattern{
+			re:          regexp.MustCompile("^" + expr.String() + "$"),
+			order:       order,
+			translation: translation,
+		})
+	}
+
+	// Try the most specific patterns first.
+	sort.Slice(c.patterns, func(i, j int) bool {
+		return len(c.patterns[i].re.String()) > len(c.patterns[j].re.String())
+	})
+	return c
+}
+
+// Languages returns the languages messages are available in, Default
+// first.
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs)+1)
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return append([]string{Default}, langs...)
+}
+
+// Translate returns the translation of a message into a language, or the
+// message itself if the language or the message is unknown.
+func Translate(lang, message string) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		return message
+	}
+	if translation, ok := c.exact[message]; ok {
+		return translation
+	}
+
+	for _, p := range c.patterns {
+		m := p.re.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		values := make(map[string]string, len(p.order))
+		for i, n := range p.order {
+			values[strconv.Itoa(n)] = m[i+1]
+		}
+		return placeholder.ReplaceAllStringFunc(p.translation, func(s string) string {
+			return values[s[1:len(s)-1]]
+		})
+	}
+	return message
+}
+
+// Negotiate picks the language to respond in from an Accept-Language
+// header, preferring languages with higher quality values and falling back
+// from regional variants such as de-CH to their language. It returns
+// Default if no available language is acceptable.
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if tag == "" || q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{strings.ToLower(tag), q})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	for _, c := range candidates {
+		lang, _, _ := strings.Cut(c.lang, "-")
+		if lang == Default {
+			return Default
+		}
+		if _, ok := catalogs[lang]; ok {
+			return lang
+		}
+	}
+	return Default
+}
@@ -0,0 +1,244 @@
+// Package jobs runs the background work of the server, like retention and
+// backup snapshots, on schedules, keeping statistics of every job and
+// stopping them gracefully.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Func is the work of a job. ctx is cancelled when the scheduler stops.
+type Func func(ctx context.Context) error
+
+// Elector tells whether this process holds the lock of the processes
+// sharing a database, and so runs the jobs only one of them may run.
+type Elector interface {
+	Leading() bool
+}
+
+// Stats are the statistics of a job.
+type Stats struct {
+	Name         string        `json:"name"`
+	Schedule     string        `json:"schedule"`
+	Running      bool          `json:"running"`
+	Runs         int           `json:"runs"`
+	Failures     int           `json:"failures"`
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration_ns"`
+	LastError    string        `json:"last_error,omitempty"`
+	NextRun      time.Time     `json:"next_run"`
+	// Exclusive jobs only run in the process holding the job lock.
+	Exclusive bool `json:"exclusive,omitempty"`
+	// Standby is set for exclusive jobs while another process holds the
+	// lock.
+	Standby bool `json:"standby,omitempty"`
+}
+
+type job struct {
+	schedule Schedule
+	fn       Func
+	stats    Stats
+}
+
+// Scheduler runs jobs on their schedules. A job never overlaps with itself:
+// runs that come due while it is still running are skipped.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	started bool
+	elector Elector
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler without jobs.
+func NewScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{jobs: make(map[string]*job), ctx: ctx, cancel: cancel}
+}
+
+// Add registers a job. Jobs added after Start start right away. Names must
+// be unique.
+func (s *Scheduler) Add(name string, schedule Schedule, fn Func) {
+	s.add(name, schedule, fn, false)
+}
+
+// AddExclusive registers a job that only runs while the elector of the
+// scheduler, if any, reports this process as holding the lock, such as
+// cleanup that processes sharing a database should not all do. Runs that
+// come due while another process holds it are skipped.
+func (s *Scheduler) AddExclusive(name string, schedule Schedule, fn Func) {
+	s.add(name, schedule, fn, true)
+}
+
+func (s *Scheduler) add(name string, schedule Schedule, fn Func, exclusive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[name]; ok {
+		panic(fmt.Sprintf("jobs: duplicate job %q", name))
+	}
+	j := &job{schedule: schedule, fn: fn, stats: Stats{Name: name, Schedule: schedule.String(), Exclusive: exclusive}}
+	s.jobs[name] = j
+	if s.started {
+		s.start(j)
+	}
+}
+
+// Elect makes exclusive jobs run only while e reports this process as
+// holding the lock. Without an elector, they always run.
+func (s *Scheduler) Elect(e Elector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elector = e
+}
+
+// standby reports whether an exclusive job must not run because another
+// instance leads. s.mu must be held.
+func (s *Scheduler) standby(j *job) bool {
+	return j.stats.Exclusive && s.elector != nil && !s.elector.Leading()
+}
+
+// Start runs the jobs on their schedules until Stop.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, j := range s.jobs {
+		s.start(j)
+	}
+}
+
+// start runs the loop of a job. s.mu must be held.
+func (s *Scheduler) start(j *job) {
+	j.stats.NextRun = j.schedule.Next(time.Now())
+	if _, ok := j.schedule.(every); ok {
+		j.stats.NextRun = time.Now()
+	}
+	s.wg.Add(1)
+	go s.loop(j)
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		next := j.stats.NextRun
+		s.mu.Unlock()
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if s.claim(j) {
+			s.run(j)
+		} else {
+			s.mu.Lock()
+			j.stats.NextRun = j.schedule.Next(time.Now())
+			s.mu.Unlock()
+		}
+	}
+}
+
+// claim marks a job as running unless it already is or another instance
+// leads.
+func (s *Scheduler) claim(j *job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j.stats.Running || s.standby(j) {
+		return false
+	}
+	j.stats.Running = true
+	return true
+}
+
+// run runs a claimed job once and records its statistics.
+func (s *Scheduler) run(j *job) {
+	start := time.Now()
+	err := j.fn(s.ctx)
+	end := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.stats.Running = false
+	j.stats.Runs++
+	j.stats.LastRun = start
+	j.stats.LastDuration = end.Sub(start)
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.Failures++
+		j.stats.LastError = err.Error()
+		log.Printf("jobs: %s failed: %v", j.stats.Name, err)
+	}
+	j.stats.NextRun = j.schedule.Next(end)
+}
+
+// Trigger runs a job now, outside of its schedule, and waits for it. It
+// reports false if there is no such job, it is already running or it is
+// exclusive and another instance leads.
+func (s *Scheduler) Trigger(name string) bool {
+	s.mu.Lock()
+	j, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok || !s.claim(j) {
+		return false
+	}
+
+	s.wg.Add(1)
+	defer s.wg.Done()
+	s.run(j)
+	return true
+}
+
+// Stats returns the statistics of all jobs, sorted by name.
+func (s *Scheduler) Stats() []Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]Stats, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		st := j.stats
+		st.Standby = s.standby(j)
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Name < stats[b].Name })
+	return stats
+}
+
+// Stop cancels the context of running jobs and waits for them to return,
+// or for ctx to be done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,171 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a job runs.
+type Schedule interface {
+	// Next returns the first time after t the job is due.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Every returns a schedule running a job every d, starting right when the
+// scheduler starts.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return "@every " + time.Duration(e).String()
+}
+
+// ParseSchedule parses a schedule: "@every <duration>", one of the
+// shorthands @hourly, @daily, @weekly and @monthly, or a cron expression of
+// five fields (minute, hour, day of month, month, day of week) made of *,
+// numbers, ranges, lists and /steps, like "30 3 * * 1-5". Cron schedules
+// use the local time zone.
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	if d, ok := strings.CutPrefix(s, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", d)
+		}
+		return Every(interval), nil
+	}
+
+	expr := s
+	switch s {
+	case "@hourly":
+		expr = "0 * * * *"
+	case "@daily":
+		expr = "0 0 * * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields", s)
+	}
+	c := &cron{expr: s}
+	for i, bounds := range [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}} {
+		set, err := parseField(fields[i], bounds[0], bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", s, err)
+		}
+		c.fields[i] = set
+	}
+	// Sunday is both 0 and 7.
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1
+	}
+	c.anyDom = fields[2] == "*"
+	c.anyDow = fields[4] == "*"
+
+	return c, nil
+}
+
+// cron is a schedule of five cron fields, each a bit set of the values it
+// matches.
+type cron struct {
+	expr   string
+	fields [5]uint64
+	// anyDom and anyDow follow cron in matching days by either field when
+	// both are restricted.
+	anyDom, anyDow bool
+}
+
+// maxCronSearch bounds the search for the next match of schedules that
+// never match, like February 30.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+func (c *cron) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxCronSearch); next.Before(limit); {
+		switch {
+		case !has(c.fields[3], int(next.Month())):
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !c.matchDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case !has(c.fields[1], next.Hour()):
+			next = next.Truncate(time.Hour).Add(time.Hour)
+		case !has(c.fields[0], next.Minute()):
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) matchDay(t time.Time) bool {
+	dom := has(c.fields[2], t.Day())
+	dow := has(c.fields[4], int(t.Weekday()))
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cron) String() string {
+	return c.expr
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<v) != 0
+}
+
+// parseField parses a comma-separated list of *, values and ranges with
+// optional steps into a bit set.
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", s)
+			}
+			rng, step = r, n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			l, h, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(l); err != nil {
+				return 0, fmt.Errorf("invalid value %q", l)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(h); err != nil {
+					return 0, fmt.Errorf("invalid value %q", h)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,103 @@
+// Package lockout tracks failed authentication attempts and locks out keys
+// (clipboards, IP addresses) with exponential backoff.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracker counts failed attempts per key.
+// Once a key reaches the failure threshold, every further failure locks it out
+// for twice as long as the previous one, starting at the base delay and capped
+// at the maximum delay. Keys are forgotten after a maximum delay without failures.
+type Tracker struct {
+	threshold int
+	base, max time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastPrune time.Time
+}
+
+// New returns a tracker locking keys out after threshold failures.
+func New(threshold int, base, max time.Duration) *Tracker {
+	return &Tracker{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		entries:   make(map[string]*entry),
+	}
+}
+
+// Locked returns how long the key remains locked out, or 0 if it is not.
+func (t *Tracker) Locked(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+
+	return max(time.Until(e.lockedUntil), 0)
+}
+
+// Fail records a failed attempt for the key and returns how long it is now
+// locked out, or 0 if it is not.
+func (t *Tracker) Fail(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	e, ok := t.entries[key]
+	if !ok {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures < t.threshold {
+		return 0
+	}
+
+	delay := t.base
+	for i := t.threshold; i < e.failures && delay < t.max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, t.max)
+	e.lockedUntil = now.Add(delay)
+
+	return delay
+}
+
+// Succeed forgets the failed attempts of the key.
+func (t *Tracker) Succeed(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+// prune forgets keys without recent failures, at most once a minute.
+func (t *Tracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+
+	for key, e := range t.entries {
+		if now.After(e.lockedUntil) && now.Sub(e.lastFailure) > t.max {
+			delete(t.entries, key)
+		}
+	}
+}
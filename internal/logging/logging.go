@@ -0,0 +1,178 @@
+// Package logging keeps clipboard data, passwords and tokens out of the
+// server log. Setup routes the standard logger through Writer, which masks
+// anything that looks like a credential, and Data stands in for clipboard
+// data wherever it would be logged.
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Redacted replaces secrets in log lines.
+const Redacted = "[REDACTED]"
+
+// minSecretLength is the length below which configured values are not
+// treated as secrets, so short settings do not mask common words.
+const minSecretLength = 8
+
+var debug atomic.Bool
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// patterns match credentials by their shape, replaced by repl.
+var patterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Authorization headers.
+	{regexp.MustCompile(`(?i)(\b(?:bearer|basic) )[^\s"',]+`), "${1}" + Redacted},
+	// Clipboard tokens.
+	{regexp.MustCompile(`(\bcbt_)[A-Za-z0-9_-]+`), "${1}" + Redacted},
+	// Session access tokens.
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), Redacted},
+	// Passwords in URLs, such as those of database and broker URLs.
+	{regexp.MustCompile(`(://[^/\s:@]*:)[^/\s@]+@`), "${1}" + Redacted + "@"},
+	// Credentials in query strings, such as presigned URL signatures.
+	{regexp.MustCompile(`(?i)([?&](?:x-amz-signature|x-amz-credential|x-amz-security-token|signature|token|access_token|refresh_token|password|key|code)=)[^&\s"']+`), "${1}" + Redacted},
+}
+
+// Setup routes the standard logger and the request log through a Writer and
+// registers the values of secret settings, those whose names contain KEY,
+// SECRET, TOKEN or PASSWORD, with Secret. LOG_DEBUG enables Debugf.
+func Setup() {
+	debug.Store(env.Bool("LOG_DEBUG", false))
+
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if secretSetting(name) {
+			Secret(secretValues(value)...)
+		}
+	}
+
+	log.SetOutput(NewWriter(os.Stderr))
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{
+		Logger:  log.New(NewWriter(os.Stdout), "", log.LstdFlags),
+		NoColor: runtime.GOOS == "windows",
+	})
+}
+
+// SetDebug enables or disables debug logging.
+func SetDebug(enabled bool) {
+	debug.Store(enabled)
+}
+
+// Debug reports whether debug logging is enabled.
+func Debug() bool {
+	return debug.Load()
+}
+
+// Debugf logs a message only if debug logging is enabled. Values are still
+// redacted like any other log line.
+func Debugf(format string, args ...any) {
+	if debug.Load() {
+		log.Printf(format, args...)
+	}
+}
+
+// Secret registers values that are masked wherever they appear in the log,
+// such as API keys. Values shorter than 8 bytes are ignored.
+func Secret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Data stands in for clipboard data, passwords and other sensitive values
+// in log lines. It is Redacted, or the size and a short SHA-256 hash of the
+// value with debug logging enabled, which tells values apart without
+// revealing them.
+func Data(data string) string {
+	if !debug.Load() {
+		return Redacted
+	}
+	sum := sha256.Sum256([]byte(data))
+	return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:6]), len(data))
+}
+
+// Redact masks registered secrets and credentials in a log line.
+func Redact(line string) string {
+	secretsMu.RLock()
+	for _, s := range secrets {
+		line = strings.ReplaceAll(line, s, Redacted)
+	}
+	secretsMu.RUnlock()
+
+	for _, p := range patterns {
+		line = p.re.ReplaceAllString(line, p.repl)
+	}
+	return line
+}
+
+// Writer redacts everything written to it before passing it on. The
+// standard logger writes every line in a single call.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a Writer passing redacted lines on to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// secretSetting reports whether the environment variable name holds a
+// secret.
+func secretSetting(name string) bool {
+	for _, word := range []string{"KEY", "SECRET", "TOKEN", "PASSWORD"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretValues splits a setting into its secrets. Lists of "name:secret"
+// pairs, like API_KEYS and MASTER_KEYS, only hold secrets after the colon.
+func secretValues(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "://") {
+			values = append(values, item)
+			continue
+		}
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			item = item[i+1:]
+		}
+		values = append(values, item)
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package masterkey
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ageMaxFileSize bounds the files read and the keys decrypted, which only
+// hold a few keys.
+const ageMaxFileSize = 1 << 20
+
+// AgeFile decrypts master keys from a file encrypted with age
+// (https://age-encryption.org) to an X25519 recipient, using the identities
+// in another file, such as one created by age-keygen. Both binary and
+// armored files are read.
+type AgeFile struct {
+	File     string
+	Identity string
+}
+
+func (a *AgeFile) String() string {
+	return "age file " + a.File
+}
+
+func (a *AgeFile) Fetch(ctx context.Context) (string, error) {
+	identities, err := readAgeIdentities(a.Identity)
+	if err != nil {
+		return "", err
+	}
+	data, err := readFile(a.File)
+	if err != nil {
+		return "", err
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		r = armor.NewReader(r)
+	}
+	d, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	plaintext, err := io.ReadAll(io.LimitReader(d, ageMaxFileSize+1))
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	if len(plaintext) > ageMaxFileSize {
+		return "", fmt.Errorf("age: the keys in %s are too large", a.File)
+	}
+	return string(plaintext), nil
+}
+
+func readFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, ageMaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > ageMaxFileSize {
+		return nil, fmt.Errorf("%s is too large", path)
+	}
+	return data, nil
+}
+
+// readAgeIdentities reads the X25519 secret keys of an identity file, one
+// per line. Empty lines and comments starting with # are skipped.
+func readAgeIdentities(path string) ([]age.Identity, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return identities, nil
+}
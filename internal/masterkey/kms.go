@@ -0,0 +1,140 @@
+package masterkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const amzDateFormat = "20060102T150405Z"
+
+// KMS decrypts master keys stored in a file as ciphertext of AWS KMS, so
+// only servers allowed to use the KMS key can read them. Requests are
+// signed with AWS Signature Version 4.
+type KMS struct {
+	// Endpoint is the base URL of the service, e.g.
+	// https://kms.us-east-1.amazonaws.com.
+	Endpoint string
+	Region   string
+
+	AccessKeyId     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials.
+	SessionToken string
+
+	// File holds the binary ciphertext blob returned by kms:Encrypt.
+	File string
+
+	Client *http.Client
+	// Clock returns the current time, defaulting to time.Now.
+	Clock func() time.Time
+}
+
+func (k *KMS) String() string {
+	return "KMS ciphertext " + k.File
+}
+
+func (k *KMS) Fetch(ctx context.Context) (string, error) {
+	ciphertext, err := os.ReadFile(k.File)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string][]byte{"CiphertextBlob": ciphertext})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(k.Endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	k.sign(req, body)
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("kms: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("kms: invalid response: %w", err)
+	}
+	return string(result.Plaintext), nil
+}
+
+// sign adds the date, session token and Signature Version 4 headers to a
+// request with the given body.
+func (k *KMS) sign(req *http.Request, body []byte) {
+	now := time.Now().UTC()
+	if k.Clock != nil {
+		now = k.Clock().UTC()
+	}
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	signed := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if k.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", k.SessionToken)
+		signed = []string{"content-type", "host", "x-amz-date", "x-amz-security-token", "x-amz-target"}
+	}
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	payloadHash := sha256.Sum256(body)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		headers.String(),
+		strings.Join(signed, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+
+	scope := now.Format("20060102") + "/" + k.Region + "/kms/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format(amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+k.SecretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, k.Region)
+	key = hmacSHA256(key, "kms")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		k.AccessKeyId, scope, strings.Join(signed, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
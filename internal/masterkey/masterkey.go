@@ -0,0 +1,320 @@
+// Package masterkey seals data at rest with server master keys using
+// envelope encryption: every value is encrypted with its own random data key,
+// which is in turn encrypted with a master key.
+package masterkey
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/copybridge/copybridge-server/internal/stream"
+)
+
+// keyIdPattern restricts key ids to characters that are safe in sealed
+// values and SQL LIKE patterns.
+var keyIdPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// Keyring holds the master keys. New values are sealed with the current key;
+// the others are kept to open values sealed before a rotation.
+// A nil Keyring leaves values untouched.
+type Keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// Parse parses a comma-separated list of "id:key" pairs, where key is a
+// base64-encoded 32-byte AES key. The first key is the current one.
+// It returns nil if s is empty.
+func Parse(s string) (*Keyring, error) {
+	var k *Keyring
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || !keyIdPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid master key entry for %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %q must be 32 base64-encoded bytes", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if k == nil {
+			k = &Keyring{current: id, keys: make(map[string]cipher.AEAD)}
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate master key %q", id)
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// Current returns the id of the key new values are sealed with.
+func (k *Keyring) Current() string {
+	if k == nil {
+		return ""
+	}
+	id, _ := k.currentKey()
+	return id
+}
+
+// Replace swaps the keys of the keyring for those of next, e.g. after they
+// were rotated in an external source, and reports whether the current key
+// changed.
+func (k *Keyring) Replace(next *Keyring) bool {
+	current, keys := next.snapshot()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	changed := k.current != current
+	k.current, k.keys = current, keys
+	return changed
+}
+
+func (k *Keyring) snapshot() (string, map[string]cipher.AEAD) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys
+}
+
+// currentKey returns the id and key new values are sealed with.
+func (k *Keyring) currentKey() (string, cipher.AEAD) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current]
+}
+
+// key returns the key with the given id.
+func (k *Keyring) key(id string) (cipher.AEAD, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.keys[id]
+	return aead, ok
+}
+
+// Seal encrypts a value with a fresh data key wrapped by the current master
+// key. The result is "<key id>.<wrapped data key>.<ciphertext>".
+// Without a keyring, it returns the value as is.
+func (k *Keyring) Seal(value string) (string, error) {
+	if k == nil {
+		return value, nil
+	}
+
+	current, master := k.currentKey()
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(master, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return current + "." + base64.StdEncoding.EncodeToString(wrapped) + "." + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a value produced by Seal with any key of the keyring.
+func (k *Keyring) Open(sealed string) (string, error) {
+	parts := strings.Split(sealed, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed sealed value")
+	}
+	if k == nil {
+		return "", errors.New("value is sealed but no master keys are configured")
+	}
+	master, ok := k.key(parts[0])
+	if !ok {
+		return "", fmt.Errorf("unknown master key %q", parts[0])
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(master, wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	value, err := open(aead, ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// Rewrap wraps the data key of a value produced by Seal with the current
+// master key instead of the one it was sealed with, leaving the ciphertext
+// as is. This is all rotating a master key takes, without decrypting the
+// value itself. Values sealed with the current key are returned unchanged.
+func (k *Keyring) Rewrap(sealed string) (string, error) {
+	parts := strings.Split(sealed, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed sealed value")
+	}
+	if k == nil {
+		return "", errors.New("value is sealed but no master keys are configured")
+	}
+	current, currentMaster := k.currentKey()
+	if parts[0] == current {
+		return sealed, nil
+	}
+	master, ok := k.key(parts[0])
+	if !ok {
+		return "", fmt.Errorf("unknown master key %q", parts[0])
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(master, wrapped)
+	if err != nil {
+		return "", err
+	}
+	if wrapped, err = seal(currentMaster, dataKey); err != nil {
+		return "", err
+	}
+
+	return current + "." + base64.StdEncoding.EncodeToString(wrapped) + "." + parts[2], nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data and prepends the random nonce.
+func seal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts data sealed by seal.
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// maxHeaderSize bounds the header line of sealed streams.
+const maxHeaderSize = 256
+
+// SealReader returns a reader of r sealed with a fresh data key wrapped by
+// the current master key. The result starts with a
+// "<key id>.<wrapped data key>.<nonce prefix>" line followed by the
+// ciphertext, so values of any size can be sealed without buffering them.
+// Without a keyring, it returns r as is.
+func (k *Keyring) SealReader(r io.Reader) (io.Reader, error) {
+	if k == nil {
+		return r, nil
+	}
+
+	current, master := k.currentKey()
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(master, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := stream.NewPrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	header := current + "." + base64.StdEncoding.EncodeToString(wrapped) + "." + base64.StdEncoding.EncodeToString(prefix) + "\n"
+	return io.MultiReader(strings.NewReader(header), stream.EncryptReader(aead, prefix, r)), nil
+}
+
+// OpenReader returns a reader of a stream produced by SealReader with any key
+// of the keyring, and the id of the key it was sealed with.
+func (k *Keyring) OpenReader(r io.Reader) (io.Reader, string, error) {
+	if k == nil {
+		return nil, "", errors.New("value is sealed but no master keys are configured")
+	}
+
+	br := bufio.NewReader(io.LimitReader(r, maxHeaderSize))
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, "", errors.New("malformed sealed stream")
+	}
+	parts := strings.Split(strings.TrimSuffix(line, "\n"), ".")
+	if len(parts) != 3 {
+		return nil, "", errors.New("malformed sealed stream")
+	}
+	master, ok := k.key(parts[0])
+	if !ok {
+		return nil, "", fmt.Errorf("unknown master key %q", parts[0])
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, "", err
+	}
+	dataKey, err := open(master, wrapped)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(prefix) != stream.PrefixSize {
+		return nil, "", errors.New("malformed sealed stream")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// br may have buffered the start of the ciphertext.
+	rest := io.MultiReader(br, r)
+	return stream.DecryptReader(aead, prefix, rest), parts[0], nil
+}
@@ -0,0 +1,82 @@
+package masterkey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// Source fetches master keys kept outside the environment, such as in a
+// secret store, so they need not be set in plain environment variables.
+type Source interface {
+	// Fetch returns the comma-separated "id:key" pairs Parse takes.
+	Fetch(ctx context.Context) (string, error)
+	// String describes the source in logs and errors.
+	String() string
+}
+
+// SourceFromEnv returns the source configured by MASTER_KEYS_VAULT_PATH,
+// MASTER_KEYS_KMS_FILE or MASTER_KEYS_AGE_FILE, or nil if none is set. At
+// most one of them may be set.
+func SourceFromEnv() (Source, error) {
+	var sources []Source
+	if path := env.String("MASTER_KEYS_VAULT_PATH", ""); path != "" {
+		sources = append(sources, &Vault{
+			Addr:      env.String("VAULT_ADDR", "http://127.0.0.1:8200"),
+			Token:     env.String("VAULT_TOKEN", ""),
+			Namespace: env.String("VAULT_NAMESPACE", ""),
+			Path:      path,
+			Field:     env.String("MASTER_KEYS_VAULT_FIELD", "master_keys"),
+		})
+	}
+	if file := env.String("MASTER_KEYS_KMS_FILE", ""); file != "" {
+		region := env.String("KMS_REGION", "us-east-1")
+		sources = append(sources, &KMS{
+			Endpoint:        env.String("KMS_ENDPOINT", "https://kms."+region+".amazonaws.com"),
+			Region:          region,
+			AccessKeyId:     env.String("KMS_ACCESS_KEY_ID", ""),
+			SecretAccessKey: env.String("KMS_SECRET_ACCESS_KEY", ""),
+			SessionToken:    env.String("KMS_SESSION_TOKEN", ""),
+			File:            file,
+		})
+	}
+	if file := env.String("MASTER_KEYS_AGE_FILE", ""); file != "" {
+		identity := env.String("MASTER_KEYS_AGE_IDENTITY", "")
+		if identity == "" {
+			return nil, fmt.Errorf("MASTER_KEYS_AGE_FILE needs MASTER_KEYS_AGE_IDENTITY")
+		}
+		sources = append(sources, &AgeFile{File: file, Identity: identity})
+	}
+
+	switch len(sources) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sources[0], nil
+	default:
+		names := make([]string, len(sources))
+		for i, s := range sources {
+			names[i] = s.String()
+		}
+		return nil, fmt.Errorf("only one source of master keys may be configured, got %s", strings.Join(names, " and "))
+	}
+}
+
+// Load fetches the keys of a source and parses them. A source holding no
+// keys is an error, as it would silently disable sealing.
+func Load(ctx context.Context, src Source) (*Keyring, error) {
+	s, err := src.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch master keys from %s: %w", src, err)
+	}
+	k, err := Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master keys in %s: %w", src, err)
+	}
+	if k == nil {
+		return nil, fmt.Errorf("no master keys in %s", src)
+	}
+	return k, nil
+}
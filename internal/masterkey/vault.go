@@ -0,0 +1,76 @@
+package masterkey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Vault reads master keys from a field of a secret in a HashiCorp Vault KV
+// secrets engine, version 1 or 2.
+type Vault struct {
+	// Addr is the base URL of the Vault server.
+	Addr  string
+	Token string
+	// Namespace is the Vault Enterprise namespace of the secret, if any.
+	Namespace string
+	// Path is the API path of the secret below /v1/, e.g.
+	// "secret/data/copybridge" for version 2 of the engine.
+	Path  string
+	Field string
+
+	Client *http.Client
+}
+
+func (v *Vault) String() string {
+	return "Vault secret " + v.Path
+}
+
+func (v *Vault) Fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(v.Addr, "/")+"/v1/"+strings.TrimPrefix(v.Path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("vault: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("vault: invalid response: %w", err)
+	}
+	data := secret.Data
+	// Version 2 of the engine nests the data next to its metadata.
+	if _, ok := data["metadata"]; ok {
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(data["data"], &nested); err == nil {
+			data = nested
+		}
+	}
+
+	var value string
+	if err := json.Unmarshal(data[v.Field], &value); err != nil {
+		return "", fmt.Errorf("vault: secret has no string field %q", v.Field)
+	}
+	return value, nil
+}
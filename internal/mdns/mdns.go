@@ -0,0 +1,287 @@
+// Package mdns advertises the server on the local network with multicast
+// DNS, so clients can discover it as a _copybridge._tcp service instead of
+// users typing its address on every device.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// Service is the DNS-SD service type the server is advertised as.
+const Service = "_copybridge._tcp.local."
+
+const (
+	// hostTTL and serviceTTL are the record TTLs recommended by RFC 6762.
+	hostTTL    = 120
+	serviceTTL = 4500
+
+	// unicastResponse is the top bit of the question class, asking for a
+	// unicast response; cacheFlush is the same bit on resource records.
+	unicastResponse = 1 << 15
+	cacheFlush      = 1 << 15
+)
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Config holds the advertisement settings.
+type Config struct {
+	Enabled bool
+	// Instance is the human-readable name of the server.
+	Instance string
+	Port     int
+	TLS      bool
+}
+
+// ConfigFromEnv reads the advertisement configuration from MDNS_*
+// variables. The server is advertised as serving HTTPS if TLS is configured.
+func ConfigFromEnv(port int) Config {
+	hostname, _ := os.Hostname()
+	return Config{
+		Enabled:  env.Bool("MDNS_ENABLED", false),
+		Instance: env.String("MDNS_NAME", "copybridge on "+firstLabel(hostname)),
+		Port:     port,
+		TLS:      env.String("TLS_CERT", "") != "" || env.String("TLS_AUTOCERT_DOMAINS", "") != "",
+	}
+}
+
+// Responder answers mDNS queries for the server.
+type Responder struct {
+	conn     *net.UDPConn
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	txt      []string
+}
+
+// Listen joins the mDNS multicast group. The socket is shared with other
+// responders on the host, such as Avahi.
+func Listen(cfg Config) (*Responder, error) {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return nil, errors.New("mdns: PORT must be set to advertise the server")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Responder{
+		port: uint16(cfg.Port),
+		txt:  []string{"txtvers=1", "path=/", "scheme=http"},
+	}
+	if cfg.TLS {
+		r.txt[2] = "scheme=https"
+	}
+	if r.service, err = dnsmessage.NewName(Service); err != nil {
+		return nil, err
+	}
+	// Dots would split the instance name into several labels.
+	if r.instance, err = dnsmessage.NewName(strings.ReplaceAll(cfg.Instance, ".", "-") + "." + Service); err != nil {
+		return nil, err
+	}
+	if r.host, err = dnsmessage.NewName(firstLabel(hostname) + ".local."); err != nil {
+		return nil, err
+	}
+
+	if r.conn, err = net.ListenMulticastUDP("udp4", nil, group); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Run announces the server, answers queries until ctx is done and then
+// withdraws the announcement.
+func (r *Responder) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		r.send(r.records(0), nil, group)
+		r.conn.Close()
+	}()
+
+	// RFC 6762 asks for at least two announcements, a second apart.
+	go func() {
+		for i := 0; i < 2; i++ {
+			r.send(r.records(1), nil, group)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	log.Printf("mdns: advertising %s on port %d", r.instance, r.port)
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("mdns: stopped: %v", err)
+			}
+			return
+		}
+		r.handle(buf[:n], from)
+	}
+}
+
+// handle answers a query for any of the records of the server.
+func (r *Responder) handle(query []byte, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	records := r.records(1)
+	var answers []dnsmessage.Resource
+	answered := make(map[int]bool)
+	unicast := false
+	for _, q := range questions {
+		class := q.Class &^ unicastResponse
+		if class != dnsmessage.ClassINET && class != dnsmessage.ClassANY {
+			continue
+		}
+		for i, rr := range records {
+			if !answered[i] && matches(q, rr.Header) {
+				answers = append(answers, rr)
+				answered[i] = true
+				unicast = unicast || q.Class&unicastResponse != 0
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	var additionals []dnsmessage.Resource
+	for i, rr := range records {
+		if !answered[i] {
+			additionals = append(additionals, rr)
+		}
+	}
+
+	// Queries not sent from the mDNS port come from simple resolvers, which
+	// expect a conventional unicast DNS response.
+	if from.Port != group.Port {
+		r.sendReply(h.ID, questions, answers, additionals, from)
+		return
+	}
+	to := group
+	if unicast {
+		to = from
+	}
+	r.send(answers, additionals, to)
+}
+
+// matches reports whether a record answers a question.
+func matches(q dnsmessage.Question, h dnsmessage.ResourceHeader) bool {
+	return strings.EqualFold(q.Name.String(), h.Name.String()) &&
+		(q.Type == h.Type || q.Type == dnsmessage.TypeALL)
+}
+
+// records returns the records of the server. A zero ttlScale withdraws them.
+func (r *Responder) records(ttlScale uint32) []dnsmessage.Resource {
+	header := func(name dnsmessage.Name, typ dnsmessage.Type, ttl uint32, unique bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if unique {
+			class |= cacheFlush
+		}
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: ttl * ttlScale}
+	}
+
+	records := []dnsmessage.Resource{
+		{Header: header(r.service, dnsmessage.TypePTR, serviceTTL, false), Body: &dnsmessage.PTRResource{PTR: r.instance}},
+		{Header: header(r.instance, dnsmessage.TypeSRV, hostTTL, true), Body: &dnsmessage.SRVResource{Target: r.host, Port: r.port}},
+		{Header: header(r.instance, dnsmessage.TypeTXT, serviceTTL, true), Body: &dnsmessage.TXTResource{TXT: r.txt}},
+	}
+	for _, ip := range addresses() {
+		records = append(records, dnsmessage.Resource{Header: header(r.host, dnsmessage.TypeA, hostTTL, true), Body: &dnsmessage.AResource{A: ip}})
+	}
+
+	return records
+}
+
+func (r *Responder) send(answers, additionals []dnsmessage.Resource, to *net.UDPAddr) {
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	r.write(msg, to)
+}
+
+func (r *Responder) sendReply(id uint16, questions []dnsmessage.Question, answers, additionals []dnsmessage.Resource, to *net.UDPAddr) {
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: id, Response: true, Authoritative: true},
+		Questions:   questions,
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	r.write(msg, to)
+}
+
+func (r *Responder) write(msg dnsmessage.Message, to *net.UDPAddr) {
+	b, err := msg.Pack()
+	if err != nil {
+		log.Printf("mdns: error packing response: %v", err)
+		return
+	}
+	if _, err := r.conn.WriteToUDP(b, to); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("mdns: error sending response: %v", err)
+	}
+}
+
+// addresses returns the IPv4 addresses of the multicast-capable interfaces
+// that are up, looked up on every response since they may change.
+func addresses() [][4]byte {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var ips [][4]byte
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				if ip4 := ipnet.IP.To4(); ip4 != nil {
+					ips = append(ips, [4]byte(ip4))
+				}
+			}
+		}
+	}
+
+	return ips
+}
+
+// firstLabel returns the first label of a host name.
+func firstLabel(hostname string) string {
+	label, _, _ := strings.Cut(hostname, ".")
+	if label == "" {
+		return "copybridge-" + strconv.Itoa(os.Getpid())
+	}
+	return label
+}
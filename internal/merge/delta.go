@@ -0,0 +1,122 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Op is an operation of a delta. Exactly one of its fields is set: Retain
+// keeps and Delete drops the next characters of the text, and Insert adds
+// text at the current position. Counts are in Unicode code points.
+type Op struct {
+	Retain int    `json:"retain,omitempty"`
+	Delete int    `json:"delete,omitempty"`
+	Insert string `json:"insert,omitempty"`
+}
+
+// Apply returns the text the delta ops turn base into. The text after the
+// last operation is kept.
+func Apply(base string, ops []Op) (string, error) {
+	runes := []rune(base)
+	var b strings.Builder
+	b.Grow(len(base))
+	pos := 0
+	for i, op := range ops {
+		switch {
+		case op.Retain > 0 && op.Delete == 0 && op.Insert == "":
+			if op.Retain > len(runes)-pos {
+				return "", fmt.Errorf("operation %d retains past the end of the text", i)
+			}
+			b.WriteString(string(runes[pos : pos+op.Retain]))
+			pos += op.Retain
+		case op.Delete > 0 && op.Retain == 0 && op.Insert == "":
+			if op.Delete > len(runes)-pos {
+				return "", fmt.Errorf("operation %d deletes past the end of the text", i)
+			}
+			pos += op.Delete
+		case op.Insert != "" && op.Retain == 0 && op.Delete == 0:
+			b.WriteString(op.Insert)
+		default:
+			return "", fmt.Errorf("operation %d must have exactly one of a positive retain, a positive delete or an insert", i)
+		}
+	}
+	b.WriteString(string(runes[pos:]))
+	return b.String(), nil
+}
+
+// Delta returns the ops turning from into to. Changed lines are found like
+// in ThreeWay and narrowed down to the characters that changed; texts too
+// large to compare are replaced beyond their common first and last lines.
+func Delta(from, to string) []Op {
+	a := splitLines(from)
+	d := &deltaBuilder{ops: []Op{}}
+	pos := 0
+	for _, h := range lineChanges(a, splitLines(to)) {
+		d.retain(countRunes(a[pos:h.start]))
+		d.replace([]rune(strings.Join(a[h.start:h.end], "")), []rune(strings.Join(h.lines, "")))
+		pos = h.end
+	}
+	return d.ops
+}
+
+// deltaBuilder collects ops, combining consecutive ops of the same kind.
+// Retains are only added once another op follows them, as the rest of the
+// text is kept anyway.
+type deltaBuilder struct {
+	ops     []Op
+	pending int
+}
+
+func (d *deltaBuilder) retain(n int) {
+	d.pending += n
+}
+
+// replace deletes old and inserts new in its place, retaining the
+// characters both start or end with.
+func (d *deltaBuilder) replace(old, new []rune) {
+	start := 0
+	for start < len(old) && start < len(new) && old[start] == new[start] {
+		start++
+	}
+	end := 0
+	for end < len(old)-start && end < len(new)-start && old[len(old)-1-end] == new[len(new)-1-end] {
+		end++
+	}
+
+	d.retain(start)
+	if n := len(old) - start - end; n > 0 {
+		d.add(Op{Delete: n})
+	}
+	if start < len(new)-end {
+		d.add(Op{Insert: string(new[start : len(new)-end])})
+	}
+	d.retain(end)
+}
+
+func (d *deltaBuilder) add(op Op) {
+	if d.pending > 0 {
+		d.ops = append(d.ops, Op{Retain: d.pending})
+		d.pending = 0
+	}
+	if n := len(d.ops); n > 0 {
+		last := &d.ops[n-1]
+		switch {
+		case op.Delete > 0 && last.Delete > 0:
+			last.Delete += op.Delete
+			return
+		case op.Insert != "" && last.Insert != "":
+			last.Insert += op.Insert
+			return
+		}
+	}
+	d.ops = append(d.ops, op)
+}
+
+func countRunes(lines []string) int {
+	n := 0
+	for _, l := range lines {
+		n += utf8.RuneCountInString(l)
+	}
+	return n
+}
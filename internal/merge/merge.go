@@ -0,0 +1,195 @@
+// Package merge combines concurrent edits of text line by line, and turns
+// edits into deltas holding only what changed.
+package merge
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxCells bounds the size of the table comparing two texts, the product of
+// their line counts, so merging huge texts does not exhaust memory. Larger
+// texts are reported as conflicting.
+const maxCells = 1 << 22
+
+// hunk replaces the lines [start, end) of the base with lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// ThreeWay merges the changes ours and theirs made to base, like diff3.
+// Changes to different lines are combined; it returns false if both sides
+// changed the same or adjacent lines differently.
+func ThreeWay(base, ours, theirs string) (string, bool) {
+	switch {
+	case ours == theirs || theirs == base:
+		return ours, true
+	case ours == base:
+		return theirs, true
+	}
+
+	baseLines := splitLines(base)
+	a, ok := diff(baseLines, splitLines(ours))
+	if !ok {
+		return "", false
+	}
+	b, ok := diff(baseLines, splitLines(theirs))
+	if !ok {
+		return "", false
+	}
+
+	var merged []string
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		// Start a group with the hunk starting first and add every hunk of
+		// either side touching it.
+		var groupA, groupB []hunk
+		start, end := 0, 0
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0].start <= b[0].start):
+			start, end = a[0].start, a[0].end
+			groupA, a = []hunk{a[0]}, a[1:]
+		default:
+			start, end = b[0].start, b[0].end
+			groupB, b = []hunk{b[0]}, b[1:]
+		}
+		for grown := true; grown; {
+			grown = false
+			if len(a) > 0 && a[0].start <= end {
+				end = max(end, a[0].end)
+				groupA, a = append(groupA, a[0]), a[1:]
+				grown = true
+			}
+			if len(b) > 0 && b[0].start <= end {
+				end = max(end, b[0].end)
+				groupB, b = append(groupB, b[0]), b[1:]
+				grown = true
+			}
+		}
+
+		merged = append(merged, baseLines[pos:start]...)
+		oursLines := patch(baseLines, start, end, groupA)
+		theirsLines := patch(baseLines, start, end, groupB)
+		switch {
+		case len(groupB) == 0:
+			merged = append(merged, oursLines...)
+		case len(groupA) == 0:
+			merged = append(merged, theirsLines...)
+		case slices.Equal(oursLines, theirsLines):
+			merged = append(merged, oursLines...)
+		default:
+			return "", false
+		}
+		pos = end
+	}
+	merged = append(merged, baseLines[pos:]...)
+
+	return strings.Join(merged, ""), true
+}
+
+// splitLines splits text into lines, keeping their line endings.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diff returns the hunks turning base into changed, from a longest common
+// subsequence of their lines. It returns false if the texts are too large
+// to compare.
+func diff(base, changed []string) ([]hunk, bool) {
+	n, m := len(base), len(changed)
+	if n*m > maxCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// base[i:] and changed[j:].
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if base[i] == changed[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var hunks []hunk
+	var current *hunk
+	i, j := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && base[i] == changed[j] {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			i++
+			j++
+			continue
+		}
+		if current == nil {
+			current = &hunk{start: i, end: i}
+		}
+		if j < m && (i == n || lcs[i][j+1] >= lcs[i+1][j]) {
+			current.lines = append(current.lines, changed[j])
+			j++
+		} else {
+			i++
+			current.end = i
+		}
+	}
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+
+	return hunks, true
+}
+
+// lineChanges returns the hunks turning the lines a into b, comparing
+// only what lies between their common first and last lines. Texts too
+// large to compare are replaced in between as a whole.
+func lineChanges(a, b []string) []hunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	hunks, ok := diff(a, b)
+	if !ok {
+		hunks = []hunk{{start: 0, end: len(a), lines: b}}
+	}
+	for i := range hunks {
+		hunks[i].start += prefix
+		hunks[i].end += prefix
+	}
+	return hunks
+}
+
+// patch returns the lines [start, end) of base with the hunks, which lie
+// within them, applied.
+func patch(base []string, start, end int, hunks []hunk) []string {
+	var lines []string
+	pos := start
+	for _, h := range hunks {
+		lines = append(lines, base[pos:h.start]...)
+		lines = append(lines, h.lines...)
+		pos = h.end
+	}
+	return append(lines, base[pos:end]...)
+}
@@ -0,0 +1,80 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unifiedContext is the number of unchanged lines shown around changes.
+const unifiedContext = 3
+
+// Unified returns the changes turning from into to as a unified diff, like
+// diff -u, with the labels naming the texts in its header. Changed lines
+// are found like in Delta. It returns an empty string if the texts are
+// equal.
+func Unified(from, to, fromLabel, toLabel string) string {
+	a := splitLines(from)
+	hunks := lineChanges(a, splitLines(to))
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromLabel, toLabel)
+
+	// offset is the number of lines the hunks before the current one
+	// added, which moves the lines of to against those of from.
+	offset := 0
+	for len(hunks) > 0 {
+		// Hunks whose context would touch are shown together.
+		n := 1
+		for n < len(hunks) && hunks[n].start-hunks[n-1].end <= 2*unifiedContext {
+			n++
+		}
+		group := hunks[:n]
+		hunks = hunks[n:]
+
+		start := max(group[0].start-unifiedContext, 0)
+		end := min(group[n-1].end+unifiedContext, len(a))
+		added := 0
+		for _, h := range group {
+			added += len(h.lines) - (h.end - h.start)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", lineRange(start, end-start), lineRange(start+offset, end-start+added))
+
+		pos := start
+		for _, h := range group {
+			writeLines(&b, ' ', a[pos:h.start])
+			writeLines(&b, '-', a[h.start:h.end])
+			writeLines(&b, '+', h.lines)
+			pos = h.end
+		}
+		writeLines(&b, ' ', a[pos:end])
+		offset += added
+	}
+	return b.String()
+}
+
+// lineRange formats the range of count lines from the 0-based line start
+// for a hunk header. Empty ranges name the line before them.
+func lineRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// writeLines writes lines with a prefix, marking a last line without line
+// ending.
+func writeLines(b *strings.Builder, prefix byte, lines []string) {
+	for _, line := range lines {
+		b.WriteByte(prefix)
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
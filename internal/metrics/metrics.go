@@ -0,0 +1,274 @@
+// Package metrics collects counters and histograms and writes them in the
+// Prometheus text exposition format. Metrics are declared by the packages
+// they measure and register themselves when created, so Write exports
+// every metric of the program.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of the output of Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DurationBuckets are the default upper bounds of duration histograms,
+// in seconds.
+var DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var registry = struct {
+	sync.Mutex
+	metrics map[string]metric
+}{metrics: make(map[string]metric)}
+
+func register(name string, m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.metrics[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	registry.metrics[name] = m
+}
+
+// Write writes all metrics, sorted by name.
+func Write(w io.Writer) error {
+	registry.Lock()
+	names := make([]string, 0, len(registry.metrics))
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = registry.metrics[name]
+	}
+	registry.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// series is the data of a metric for one combination of label values.
+type series[T any] struct {
+	values []string
+	data   *T
+}
+
+// family holds the series of a metric, keyed by their label values.
+type family[T any] struct {
+	name, help string
+	labels     []string
+	newData    func() *T
+
+	mu     sync.Mutex
+	series map[string]series[T]
+}
+
+// with returns the data of the series with the label values, creating it
+// if needed. It must be called with f.mu held.
+func (f *family[T]) with(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = series[T]{values: append([]string(nil), values...), data: f.newData()}
+		f.series[key] = s
+	}
+	return s.data
+}
+
+// sorted returns the series ordered by their label values.
+// It must be called with f.mu held.
+func (f *family[T]) sorted() []series[T] {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ss := make([]series[T], len(keys))
+	for i, key := range keys {
+		ss[i] = f.series[key]
+	}
+	return ss
+}
+
+func (f *family[T]) header(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, typ)
+}
+
+// Counter is a metric that only goes up, e.g. the number of failed
+// deliveries, partitioned by its labels.
+type Counter struct {
+	family[float64]
+}
+
+// NewCounter creates and registers a counter with the label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family[float64]{
+		name: name, help: help, labels: labels,
+		newData: func() *float64 { return new(float64) },
+		series:  make(map[string]series[float64]),
+	}}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter with the label values by 1.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the label values. It panics if v is
+// negative.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " cannot decrease")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.with(values) += v
+}
+
+// Value returns the counter with the label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.with(values)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, s.values), formatFloat(*s.data))
+	}
+}
+
+type histogramData struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations, e.g. durations, in buckets, partitioned
+// by its labels.
+type Histogram struct {
+	family[histogramData]
+	buckets []float64
+}
+
+// NewHistogram creates and registers a histogram with the bucket upper
+// bounds, in increasing order, and the label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family: family[histogramData]{
+			name: name, help: help, labels: labels,
+			newData: func() *histogramData { return &histogramData{counts: make([]uint64, len(buckets))} },
+			series:  make(map[string]series[histogramData]),
+		},
+		buckets: buckets,
+	}
+	register(name, h)
+	return h
+}
+
+// Observe adds an observation to the histogram with the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := h.with(values)
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		d.counts[i]++
+	}
+	d.sum += v
+	d.count++
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns the number of observations of the histogram with the label
+// values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.with(values).count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		values := append(append([]string(nil), s.values...), "")
+		for i, bound := range h.buckets {
+			cumulative += s.data.counts[i]
+			values[len(values)-1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(labels, values), cumulative)
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(labels, values), s.data.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.values), formatFloat(s.data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.values), s.data.count)
+	}
+}
+
+func labelPairs(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,198 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// digest summarizes the rows of a table independently of their order, so
+// the rows read back from the target can be compared with the copied ones.
+type digest struct {
+	rows   int64
+	hashes [][sha256.Size]byte
+}
+
+// add records a row of values converted with convert or read back from the
+// target.
+func (d *digest) add(t *table, values []any) {
+	h := sha256.New()
+	for i, v := range values {
+		h.Write([]byte(normalize(t.columns[i].pgType, v)))
+		h.Write([]byte{'\t'})
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	d.rows++
+	d.hashes = append(d.hashes, sum)
+}
+
+// sum returns the hash of the sorted row hashes.
+func (d *digest) sum() [sha256.Size]byte {
+	sort.Slice(d.hashes, func(i, j int) bool { return bytes.Compare(d.hashes[i][:], d.hashes[j][:]) < 0 })
+	h := sha256.New()
+	for _, r := range d.hashes {
+		h.Write(r[:])
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// copyRows inserts the rows of a table read from src into the target
+// through tx, and returns their digest.
+func copyRows(ctx context.Context, src querier, tx *sql.Tx, d *Dialect, t *table) (*digest, error) {
+	insert, err := tx.PrepareContext(ctx, t.insertStatement(d))
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+
+	rows, err := src.QueryContext(ctx, "SELECT "+t.columnList()+" FROM "+quoteIdent(t.name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dg := &digest{}
+	values, ptrs := scanTargets(len(t.columns))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			c, err := convert(t.columns[i].pgType, v)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", t.columns[i].name, err)
+			}
+			values[i] = c
+		}
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			return nil, err
+		}
+		dg.add(t, values)
+	}
+	return dg, rows.Err()
+}
+
+// readDigest computes the digest of the rows of a table read back from the
+// target through tx.
+func readDigest(ctx context.Context, tx *sql.Tx, t *table) (*digest, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+t.columnList()+" FROM "+quoteIdent(t.name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dg := &digest{}
+	values, ptrs := scanTargets(len(t.columns))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		dg.add(t, values)
+	}
+	return dg, rows.Err()
+}
+
+// scanTargets returns n values and pointers to them to scan rows into.
+func scanTargets(n int) ([]any, []any) {
+	values := make([]any, n)
+	ptrs := make([]any, n)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	return values, ptrs
+}
+
+// convert returns a value read from SQLite as a parameter for a column of
+// the given Postgres type.
+func convert(pgType string, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch pgType {
+	case "BYTEA":
+		switch v := v.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		default:
+			return []byte(fmt.Sprint(v)), nil
+		}
+	case "BOOLEAN":
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+	case "TIMESTAMPTZ":
+		if t, ok := v.(time.Time); ok {
+			return t.UTC(), nil
+		}
+	}
+
+	switch v := v.(type) {
+	case int64, float64, bool, time.Time:
+		return v, nil
+	case []byte:
+		return text(pgType, string(v))
+	case string:
+		return text(pgType, v)
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", v)
+}
+
+// text checks that a string can be stored in a text column, which Postgres
+// requires to be UTF-8 without NUL characters.
+func text(pgType, s string) (string, error) {
+	if !utf8.ValidString(s) || strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("binary data in a %s column", pgType)
+	}
+	return s, nil
+}
+
+// normalize returns a value in a form that does not depend on how the
+// driver of the source or target represents values of the type.
+func normalize(pgType string, v any) string {
+	switch v := v.(type) {
+	case nil:
+		return `\N`
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		// Postgres keeps microseconds.
+		return v.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+	case int64:
+		if pgType == "BOOLEAN" {
+			return strconv.FormatBool(v != 0)
+		}
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		if pgType == "BYTEA" {
+			return hex.EncodeToString(v)
+		}
+		return normalize(pgType, string(v))
+	case string:
+		if pgType == "DOUBLE PRECISION" || pgType == "NUMERIC" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return strconv.FormatFloat(f, 'g', -1, 64)
+			}
+		}
+		return v
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,64 @@
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Dialect is the SQL dialect of the database the data is copied to. Column
+// types are read from SQLite as Postgres types, which dialects map to their
+// own.
+type Dialect struct {
+	// Name names the dialect in errors.
+	Name string
+	// types maps Postgres types to the types of the dialect, if they differ.
+	types map[string]string
+	// identity is appended to the definition of the identity column.
+	identity string
+	// drop is appended to the statements dropping tables with replace.
+	drop string
+	// placeholder returns the placeholder of the nth parameter, from 1.
+	placeholder func(n int) string
+	// restartIdentity returns the statement making the identity column of a
+	// table continue after the copied rows, if the dialect needs one.
+	restartIdentity func(table, column string) string
+}
+
+// Postgres is the dialect of Postgres databases, opened with a driver such
+// as github.com/lib/pq.
+var Postgres = &Dialect{
+	Name:     "Postgres",
+	identity: " GENERATED BY DEFAULT AS IDENTITY",
+	drop:     " CASCADE",
+	placeholder: func(n int) string {
+		return "$" + strconv.Itoa(n)
+	},
+	restartIdentity: func(table, column string) string {
+		return fmt.Sprintf("SELECT setval(pg_get_serial_sequence(%s, %s), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			quoteLiteral(quoteIdent(table)), quoteLiteral(column), quoteIdent(column), quoteIdent(table))
+	},
+}
+
+// SQLite is the dialect of SQLite databases, which copies are verified
+// against in tests. Declaring integer primary keys as INTEGER makes SQLite
+// fill them in, like identity columns.
+var SQLite = &Dialect{
+	Name: "SQLite",
+	types: map[string]string{
+		"BIGINT":           "INTEGER",
+		"BYTEA":            "BLOB",
+		"DOUBLE PRECISION": "REAL",
+		"TIMESTAMPTZ":      "TIMESTAMP",
+	},
+	placeholder: func(int) string {
+		return "?"
+	},
+}
+
+// columnType returns the type of a column of the given Postgres type.
+func (d *Dialect) columnType(pgType string) string {
+	if t, ok := d.types[pgType]; ok {
+		return t
+	}
+	return pgType
+}
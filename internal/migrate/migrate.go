@@ -0,0 +1,105 @@
+// Package migrate copies the database of the server from its SQLite file to
+// another database, such as Postgres, for deployments outgrowing it, and
+// verifies the copy.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Table reports the rows copied of a table.
+type Table struct {
+	Name string
+	Rows int64
+}
+
+// Copy copies every table of the SQLite database src to dst, which speaks
+// the dialect d, with its rows and indexes. Clipboards are copied with their
+// encrypted data and metadata as well as users, tokens and logs, from a
+// consistent snapshot of src, so the server may keep writing to it.
+//
+// Everything is written in a single transaction of dst, and the rows are
+// read back and compared with the copied ones before it is committed, so a
+// failed or inexact copy leaves nothing behind. Existing tables of the same
+// names make the copy fail, unless replace drops them first.
+func Copy(ctx context.Context, src, dst *sql.DB, d *Dialect, replace bool) ([]Table, error) {
+	// A transaction reads one snapshot of the source, even while the
+	// server keeps writing to it.
+	snapshot, err := src.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Rollback()
+
+	tables, err := readSchema(ctx, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i := range tables {
+		if replace {
+			if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+quoteIdent(tables[i].name)+d.drop); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, tables[i].createStatement(d)); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", tables[i].name, err)
+		}
+	}
+
+	digests := make(map[string]*digest, len(tables))
+	for i := range tables {
+		t := &tables[i]
+		dg, err := copyRows(ctx, snapshot, tx, d, t)
+		if err != nil {
+			return nil, fmt.Errorf("copying %s: %w", t.name, err)
+		}
+		digests[t.name] = dg
+	}
+
+	for i := range tables {
+		for _, statement := range tables[i].indexStatements(d) {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return nil, fmt.Errorf("indexing %s: %w", tables[i].name, err)
+			}
+		}
+	}
+
+	copied := make([]Table, 0, len(tables))
+	for i := range tables {
+		t := &tables[i]
+		read, err := readDigest(ctx, tx, t)
+		if err != nil {
+			return nil, fmt.Errorf("verifying %s: %w", t.name, err)
+		}
+
+		expected := digests[t.name]
+		if read.rows != expected.rows {
+			return nil, fmt.Errorf("verifying %s: copied %d rows; expected %d", t.name, read.rows, expected.rows)
+		}
+		if read.sum() != expected.sum() {
+			return nil, fmt.Errorf("verifying %s: the rows in %s differ from the copied ones", t.name, d.Name)
+		}
+		copied = append(copied, Table{Name: t.name, Rows: read.rows})
+	}
+	return copied, tx.Commit()
+}
+
+// ReadOnlyDSN returns the DSN opening the SQLite database at url without
+// writing to it, waiting for the server if it holds a lock.
+func ReadOnlyDSN(url string) string {
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "mode=ro&_query_only=true&_busy_timeout=5000"
+}
@@ -0,0 +1,322 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// querier runs queries against the source database, within the transaction
+// reading its snapshot.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// table is a table of the SQLite database with its Postgres translation.
+type table struct {
+	name    string
+	columns []column
+	indexes []index
+	// identity is the column of an INTEGER PRIMARY KEY, which SQLite fills
+	// in on inserts, or "".
+	identity string
+}
+
+// column is a column of a table.
+type column struct {
+	name string
+	// pgType is the Postgres type of the column, see pgType. Dialects map it
+	// to their own types.
+	pgType  string
+	notNull bool
+	// def is the default value in Postgres syntax, or "".
+	def string
+	// pk is the position of the column in the primary key, or 0.
+	pk int
+}
+
+// index is an index created on a table or by a UNIQUE constraint.
+type index struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// readSchema returns the tables of the SQLite database, sorted by name.
+func readSchema(ctx context.Context, q querier) ([]table, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := make([]table, 0, len(names))
+	for _, name := range names {
+		t := table{name: name}
+		if err := t.readColumns(ctx, q); err != nil {
+			return nil, fmt.Errorf("reading columns of %s: %w", name, err)
+		}
+		if err := t.readIndexes(ctx, q); err != nil {
+			return nil, fmt.Errorf("reading indexes of %s: %w", name, err)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+func (t *table) readColumns(ctx context.Context, q querier) error {
+	rows, err := q.QueryContext(ctx, `PRAGMA table_info(`+quoteIdent(t.name)+`)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keys := 0
+	for rows.Next() {
+		var (
+			cid      int
+			declared string
+			def      sql.NullString
+			c        column
+		)
+		if err := rows.Scan(&cid, &c.name, &declared, &c.notNull, &def, &c.pk); err != nil {
+			return err
+		}
+		c.pgType = pgType(declared)
+		if def.Valid {
+			c.def = pgDefault(c.pgType, def.String)
+		}
+		if c.pk > 0 {
+			keys++
+			// SQLite fills in INTEGER PRIMARY KEY columns, so they are
+			// never NULL.
+			if strings.EqualFold(declared, "INTEGER") {
+				c.notNull = true
+				t.identity = c.name
+			}
+		}
+		t.columns = append(t.columns, c)
+	}
+	if keys != 1 {
+		t.identity = ""
+	}
+	return rows.Err()
+}
+
+func (t *table) readIndexes(ctx context.Context, q querier) error {
+	rows, err := q.QueryContext(ctx, `PRAGMA index_list(`+quoteIdent(t.name)+`)`)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		name, origin string
+		unique       bool
+		partial      bool
+	}
+	var entries []entry
+	for rows.Next() {
+		var (
+			seq int
+			e   entry
+		)
+		if err := rows.Scan(&seq, &e.name, &e.unique, &e.origin, &e.partial); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.origin == "pk" {
+			continue
+		}
+		columns, err := indexColumns(ctx, q, e.name)
+		if err != nil {
+			return err
+		}
+		// Partial and expression indexes use SQL that need not mean the
+		// same in Postgres, e.g. comparing booleans with integers.
+		if e.partial || columns == nil {
+			log.Printf("skipping index %s of %s, which cannot be translated", e.name, t.name)
+			continue
+		}
+		name := e.name
+		if e.origin == "u" {
+			name = t.name + "_" + strings.Join(columns, "_") + "_key"
+		}
+		t.indexes = append(t.indexes, index{name: name, unique: e.unique, columns: columns})
+	}
+	return nil
+}
+
+// indexColumns returns the columns of an index, or nil if it indexes
+// expressions.
+func indexColumns(ctx context.Context, q querier, name string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `PRAGMA index_info(`+quoteIdent(name)+`)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	expression := false
+	for rows.Next() {
+		var (
+			seqno, cid int
+			column     sql.NullString
+		)
+		if err := rows.Scan(&seqno, &cid, &column); err != nil {
+			return nil, err
+		}
+		if !column.Valid {
+			expression = true
+		}
+		columns = append(columns, column.String)
+	}
+	if expression {
+		return nil, rows.Err()
+	}
+	return columns, rows.Err()
+}
+
+// pgType maps the declared type of a SQLite column to a Postgres type,
+// following the affinity rules of SQLite. Booleans and timestamps, which
+// SQLite stores as integers and text, get their own types.
+func pgType(declared string) string {
+	d := strings.ToUpper(declared)
+	switch {
+	case strings.HasPrefix(d, "BOOL"):
+		return "BOOLEAN"
+	case strings.Contains(d, "TIMESTAMP"), strings.Contains(d, "DATETIME"), d == "DATE":
+		return "TIMESTAMPTZ"
+	case strings.Contains(d, "INT"):
+		return "BIGINT"
+	case strings.Contains(d, "CHAR"), strings.Contains(d, "CLOB"), strings.Contains(d, "TEXT"):
+		return "TEXT"
+	case d == "", strings.Contains(d, "BLOB"):
+		return "BYTEA"
+	case strings.Contains(d, "REAL"), strings.Contains(d, "FLOA"), strings.Contains(d, "DOUB"):
+		return "DOUBLE PRECISION"
+	}
+	return "NUMERIC"
+}
+
+// pgDefault translates the default value of a column, or returns "" if it
+// cannot be.
+func pgDefault(pgType, def string) string {
+	upper := strings.ToUpper(def)
+	switch {
+	case upper == "NULL", upper == "CURRENT_TIMESTAMP":
+		return upper
+	case pgType == "BOOLEAN":
+		switch upper {
+		case "0", "FALSE":
+			return "FALSE"
+		case "1", "TRUE":
+			return "TRUE"
+		}
+	case strings.HasPrefix(def, "'") && strings.HasSuffix(def, "'") && len(def) >= 2:
+		return def
+	case strings.Trim(def, "+-.0123456789") == "" && def != "":
+		return def
+	}
+	return ""
+}
+
+// createStatement returns the statement creating the table in the dialect
+// d, without its indexes, which are created after the data is copied.
+func (t *table) createStatement(d *Dialect) string {
+	var defs []string
+	keys := make(map[int]string)
+	for _, c := range t.columns {
+		def := quoteIdent(c.name) + " " + d.columnType(c.pgType)
+		if c.name == t.identity {
+			def += d.identity
+		} else if c.def != "" {
+			def += " DEFAULT " + c.def
+		}
+		if c.notNull {
+			def += " NOT NULL"
+		}
+		defs = append(defs, def)
+		if c.pk > 0 {
+			keys[c.pk] = quoteIdent(c.name)
+		}
+	}
+	if len(keys) > 0 {
+		pk := make([]string, len(keys))
+		for i := range pk {
+			pk[i] = keys[i+1]
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(pk, ", ")+")")
+	}
+	return "CREATE TABLE " + quoteIdent(t.name) + " (\n  " + strings.Join(defs, ",\n  ") + "\n)"
+}
+
+// indexStatements returns the statements creating the indexes of the table
+// and, in the dialect d, restarting the sequence of its identity column
+// after the copied rows.
+func (t *table) indexStatements(d *Dialect) []string {
+	var statements []string
+	for _, ix := range t.indexes {
+		columns := make([]string, len(ix.columns))
+		for i, c := range ix.columns {
+			columns[i] = quoteIdent(c)
+		}
+		unique := ""
+		if ix.unique {
+			unique = "UNIQUE "
+		}
+		statements = append(statements, fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, quoteIdent(ix.name), quoteIdent(t.name), strings.Join(columns, ", ")))
+	}
+	if t.identity != "" && d.restartIdentity != nil {
+		statements = append(statements, d.restartIdentity(t.name, t.identity))
+	}
+	return statements
+}
+
+// insertStatement returns the statement inserting a row into the table in
+// the dialect d.
+func (t *table) insertStatement(d *Dialect) string {
+	params := make([]string, len(t.columns))
+	for i := range params {
+		params[i] = d.placeholder(i + 1)
+	}
+	return "INSERT INTO " + quoteIdent(t.name) + " (" + t.columnList() + ") VALUES (" + strings.Join(params, ", ") + ")"
+}
+
+// columnList returns the quoted columns of the table, separated by commas.
+func (t *table) columnList() string {
+	columns := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		columns[i] = quoteIdent(c.name)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// quoteIdent quotes an identifier for SQLite and Postgres alike.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteLiteral(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
@@ -0,0 +1,156 @@
+// Package mqtt bridges clipboard changes to an MQTT broker and, optionally,
+// accepts pastes published to it.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/events"
+)
+
+// Config holds the broker connection settings.
+type Config struct {
+	Broker   string
+	ClientId string
+	Username string
+	Password string
+	// Prefix is the topic prefix, clipboards are published to
+	// "<prefix>/<id>".
+	Prefix string
+	// Subscribe accepts pastes published to "<prefix>/<id>/set".
+	Subscribe bool
+}
+
+// ConfigFromEnv reads the bridge configuration from MQTT_* variables.
+func ConfigFromEnv() Config {
+	return Config{
+		Broker:    env.String("MQTT_BROKER", ""),
+		ClientId:  env.String("MQTT_CLIENT_ID", "copybridge-server"),
+		Username:  env.String("MQTT_USERNAME", ""),
+		Password:  env.String("MQTT_PASSWORD", ""),
+		Prefix:    strings.TrimSuffix(env.String("MQTT_TOPIC_PREFIX", "copybridge"), "/"),
+		Subscribe: env.Bool("MQTT_SUBSCRIBE", false),
+	}
+}
+
+// Enabled reports whether a broker is configured.
+func (c Config) Enabled() bool {
+	return c.Broker != ""
+}
+
+// Bridge publishes clipboard events to the broker.
+type Bridge struct {
+	cfg    Config
+	client paho.Client
+	paste  func(id int, data string) error
+}
+
+// Connect connects to the broker. The client reconnects on its own after
+// connection losses and resubscribes on every connect.
+// paste applies pastes received from the broker to a clipboard.
+func Connect(cfg Config, paste func(id int, data string) error) (*Bridge, error) {
+	b := &Bridge{cfg: cfg, paste: paste}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientId).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("mqtt: connection lost: %v", err)
+		})
+	b.client = paho.NewClient(opts)
+
+	token := b.client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		log.Printf("mqtt: broker %s not reachable yet, retrying in the background", cfg.Broker)
+	} else if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Run publishes the events of the bus until ctx is done.
+func (b *Bridge) Run(ctx context.Context, bus *events.Bus) {
+	ch, unsubscribe := bus.Subscribe(64)
+	defer unsubscribe()
+	defer b.client.Disconnect(250)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			b.publish(e)
+		}
+	}
+}
+
+// publish sends an event to the broker.
+// Clipboard changes are retained on "<prefix>/<id>" so new subscribers get
+// the current content right away; deletions clear the retained message.
+// Stack changes go to "<prefix>/<id>/items".
+func (b *Bridge) publish(e events.Event) {
+	topic := fmt.Sprintf("%s/%d", b.cfg.Prefix, e.ClipboardId)
+	retain := true
+	var payload []byte
+	switch e.Type {
+	case events.ClipboardDeleted:
+	case events.ItemPushed, events.ItemPopped:
+		topic += "/items"
+		retain = false
+		payload, _ = json.Marshal(e)
+	default:
+		payload, _ = json.Marshal(e)
+	}
+
+	token := b.client.Publish(topic, 1, retain, payload)
+	go func() {
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			log.Printf("mqtt: error publishing to %s: %v", topic, token.Error())
+		}
+	}()
+}
+
+func (b *Bridge) onConnect(c paho.Client) {
+	log.Printf("mqtt: connected to %s", b.cfg.Broker)
+	if !b.cfg.Subscribe {
+		return
+	}
+
+	topic := b.cfg.Prefix + "/+/set"
+	token := c.Subscribe(topic, 1, b.onPaste)
+	go func() {
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			log.Printf("mqtt: error subscribing to %s: %v", topic, token.Error())
+		}
+	}()
+}
+
+// onPaste applies a message published to "<prefix>/<id>/set" to the
+// clipboard. The payload is the new clipboard data.
+func (b *Bridge) onPaste(_ paho.Client, msg paho.Message) {
+	rest := strings.TrimPrefix(msg.Topic(), b.cfg.Prefix+"/")
+	id, err := strconv.Atoi(strings.TrimSuffix(rest, "/set"))
+	if err != nil {
+		log.Printf("mqtt: ignoring paste to %s: invalid clipboard id", msg.Topic())
+		return
+	}
+
+	if err := b.paste(id, string(msg.Payload())); err != nil {
+		log.Printf("mqtt: error pasting to clipboard %d: %v", id, err)
+	}
+}
@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ntfyTopic matches the topic names ntfy accepts.
+var ntfyTopic = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Ntfy publishes notifications to topics of an ntfy server, e.g.
+// https://ntfy.sh. Targets are topic names.
+type Ntfy struct {
+	URL string
+	// Token is an access token for servers with access control.
+	Token  string
+	Client *http.Client
+}
+
+func (n *Ntfy) Validate(target string) error {
+	if !ntfyTopic.MatchString(target) {
+		return errors.New("ntfy topics consist of up to 64 letters, digits, dashes and underscores")
+	}
+	return nil
+}
+
+func (n *Ntfy) Notify(ctx context.Context, target string, m Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL+"/"+target, strings.NewReader(m.Body))
+	if err != nil {
+		return err
+	}
+	// Header values must be ASCII, so the title is encoded as ntfy documents.
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", m.Title))
+	req.Header.Set("Tags", "clipboard")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	return send(n.Client, req)
+}
+
+// Gotify sends notifications to a Gotify server. Targets are application
+// tokens.
+type Gotify struct {
+	URL    string
+	Client *http.Client
+}
+
+func (g *Gotify) Validate(target string) error {
+	if target == "" || strings.ContainsAny(target, " /?&#") {
+		return errors.New("invalid Gotify application token")
+	}
+	return nil
+}
+
+func (g *Gotify) Notify(ctx context.Context, target string, m Message) error {
+	body, _ := json.Marshal(map[string]any{
+		"title":    m.Title,
+		"message":  m.Body,
+		"priority": 5,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", target)
+
+	return send(g.Client, req)
+}
+
+// send sends a request and checks that it succeeded.
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{code: resp.StatusCode, msg: resp.Status + ": " + strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// statusError is returned by send for unsuccessful responses.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// SMTP sends notifications as emails. Targets are email addresses.
+type SMTP struct {
+	// Addr is the host and port of the mail server.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTP) Validate(target string) error {
+	addr, err := mail.ParseAddress(target)
+	if err != nil || addr.Name != "" || addr.Address != target {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
+
+// Notify sends a plain text email, upgrading the connection with STARTTLS
+// if the server offers it.
+func (s *SMTP) Notify(ctx context.Context, target string, m Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(target); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\n", s.From)
+	fmt.Fprintf(w, "To: %s\r\n", target)
+	fmt.Fprintf(w, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Title))
+	fmt.Fprintf(w, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(w, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(w, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(w, "Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	fmt.Fprint(w, strings.ReplaceAll(body, "\n", "\r\n")+"\r\n")
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Compile-time checks that the channels implement Notifier.
+var (
+	_ Notifier = (*Ntfy)(nil)
+	_ Notifier = (*Gotify)(nil)
+	_ Notifier = (*SMTP)(nil)
+	_ Notifier = (*WebPush)(nil)
+)
@@ -0,0 +1,207 @@
+// Package notify sends notifications about clipboard changes to channels
+// such as ntfy, Gotify, email and Web Push, for the clipboards users
+// subscribed to.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/metrics"
+)
+
+// Channel names.
+const (
+	ChannelNtfy    = "ntfy"
+	ChannelGotify  = "gotify"
+	ChannelEmail   = "email"
+	ChannelWebPush = "webpush"
+)
+
+// maxPreview is the number of characters of clipboard data included in
+// notifications.
+const maxPreview = 256
+
+// sendTimeout bounds the delivery of a single notification.
+const sendTimeout = 10 * time.Second
+
+// deliveries counts notifications by channel and outcome: sent, failed,
+// or expired when the target no longer accepts them.
+var deliveries = metrics.NewCounter("copybridge_notifications_total",
+	"Notifications sent to subscriptions, by channel and outcome.", "channel", "outcome")
+
+// Message is a notification.
+type Message struct {
+	Title string
+	Body  string
+	// ClipboardId is the clipboard the notification is about.
+	ClipboardId int
+}
+
+// Notifier delivers notifications through a channel.
+type Notifier interface {
+	// Validate checks that target can be delivered to.
+	Validate(target string) error
+	// Notify delivers a message to target.
+	Notify(ctx context.Context, target string, m Message) error
+}
+
+// FromEnv returns the notifiers of the channels configured with NTFY_*,
+// GOTIFY_*, SMTP_* and VAPID_* variables, by channel name.
+func FromEnv() (map[string]Notifier, error) {
+	client := &http.Client{Timeout: sendTimeout}
+	notifiers := make(map[string]Notifier)
+
+	if url := env.String("NTFY_URL", ""); url != "" {
+		notifiers[ChannelNtfy] = &Ntfy{
+			URL:    strings.TrimSuffix(url, "/"),
+			Token:  env.String("NTFY_TOKEN", ""),
+			Client: client,
+		}
+	}
+	if url := env.String("GOTIFY_URL", ""); url != "" {
+		notifiers[ChannelGotify] = &Gotify{
+			URL:    strings.TrimSuffix(url, "/"),
+			Client: client,
+		}
+	}
+	if addr := env.String("SMTP_ADDR", ""); addr != "" {
+		notifiers[ChannelEmail] = &SMTP{
+			Addr:     addr,
+			Username: env.String("SMTP_USERNAME", ""),
+			Password: env.String("SMTP_PASSWORD", ""),
+			From:     env.String("SMTP_FROM", "copybridge@localhost"),
+		}
+	}
+
+	if key := env.String("VAPID_PRIVATE_KEY", ""); key != "" {
+		k, err := ParseVAPIDKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAPID_PRIVATE_KEY: %w", err)
+		}
+		notifiers[ChannelWebPush] = &WebPush{
+			Key:     k,
+			Subject: env.String("VAPID_SUBJECT", "mailto:"+env.String("SMTP_FROM", "copybridge@localhost")),
+			TTL:     env.Duration("WEBPUSH_TTL", 24*time.Hour),
+			Client:  client,
+		}
+	}
+
+	return notifiers, nil
+}
+
+// Dispatcher notifies the subscribers of clipboards about their changes.
+type Dispatcher struct {
+	notifiers map[string]Notifier
+	// subscriptions returns the subscriptions of a clipboard whose users
+	// may still read it.
+	subscriptions func(ctx context.Context, clipboardId int) ([]clipboard.Subscription, error)
+	// expire removes a subscription whose channel returned ErrExpired.
+	expire func(ctx context.Context, id int) error
+}
+
+// NewDispatcher creates a dispatcher delivering through the given notifiers.
+func NewDispatcher(notifiers map[string]Notifier, subscriptions func(ctx context.Context, clipboardId int) ([]clipboard.Subscription, error), expire func(ctx context.Context, id int) error) *Dispatcher {
+	return &Dispatcher{notifiers: notifiers, subscriptions: subscriptions, expire: expire}
+}
+
+// Run notifies about the events of the bus until ctx is done.
+// Notifications are sent one at a time, so a slow channel delays the
+// following ones rather than piling up requests.
+func (d *Dispatcher) Run(ctx context.Context, bus *events.Bus) {
+	ch, unsubscribe := bus.Subscribe(64)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			d.dispatch(ctx, e)
+		}
+	}
+}
+
+// dispatch notifies the subscribers of the clipboard of an event about
+// updates and stack pushes. Other events are ignored.
+func (d *Dispatcher) dispatch(ctx context.Context, e events.Event) {
+	if e.Type != events.ClipboardUpdated && e.Type != events.ItemPushed {
+		return
+	}
+
+	subs, err := d.subscriptions(ctx, e.ClipboardId)
+	if err != nil {
+		log.Printf("notify: error loading subscriptions of clipboard %d: %v", e.ClipboardId, err)
+		return
+	}
+
+	for _, sub := range subs {
+		n, ok := d.notifiers[sub.Channel]
+		if !ok {
+			// The channel was disabled since the subscription was made.
+			continue
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := n.Notify(sendCtx, sub.Target, NewMessage(e, sub.IncludeData))
+		cancel()
+		switch {
+		case errors.Is(err, ErrExpired):
+			deliveries.Inc(sub.Channel, "expired")
+			log.Printf("notify: removing expired %s subscription %d of clipboard %d", sub.Channel, sub.Id, e.ClipboardId)
+			if err := d.expire(ctx, sub.Id); err != nil {
+				log.Printf("notify: error removing subscription %d: %v", sub.Id, err)
+			}
+		case err != nil:
+			deliveries.Inc(sub.Channel, "failed")
+			log.Printf("notify: error sending %s notification %d for clipboard %d: %v", sub.Channel, sub.Id, e.ClipboardId, err)
+		default:
+			deliveries.Inc(sub.Channel, "sent")
+		}
+	}
+}
+
+// NewMessage describes an event as a notification. The data of the
+// clipboard is only included if asked for and if it is text; events of
+// encrypted clipboards carry no data to begin with.
+func NewMessage(e events.Event, includeData bool) Message {
+	name := e.Name
+	if name == "" {
+		name = fmt.Sprintf("#%d", e.ClipboardId)
+	}
+
+	m := Message{Title: fmt.Sprintf("Clipboard %s updated", name), ClipboardId: e.ClipboardId}
+	if e.Type == events.ItemPushed {
+		m.Title = fmt.Sprintf("New item on clipboard %s", name)
+	}
+
+	switch {
+	case includeData && e.Data != "" && strings.HasPrefix(e.DataType, "text/") && utf8.ValidString(e.Data):
+		m.Body = preview(e.Data)
+	case e.IsEncrypted:
+		m.Body = "The clipboard is encrypted."
+	default:
+		m.Body = fmt.Sprintf("New %s content.", e.DataType)
+	}
+
+	return m
+}
+
+// preview shortens data to maxPreview characters.
+func preview(data string) string {
+	data = strings.TrimSpace(data)
+	if utf8.RuneCountInString(data) <= maxPreview {
+		return data
+	}
+	runes := []rune(data)
+	return string(runes[:maxPreview]) + "…"
+}
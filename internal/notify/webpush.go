@@ -0,0 +1,250 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrExpired is returned for subscriptions the push service no longer
+// accepts notifications for, e.g. because the browser unsubscribed.
+var ErrExpired = errors.New("subscription expired")
+
+// webPushRecordSize is the record size of encrypted push messages. Push
+// services accept payloads of up to 4096 bytes.
+const webPushRecordSize = 4096
+
+// WebPush delivers notifications to browsers through their push services,
+// so the web UI is notified even when no page of it is open. Targets are
+// push subscriptions in JSON, as PushSubscription.toJSON() returns them.
+// Messages are encrypted for the browser as in RFC 8291 and signed with a
+// VAPID key as in RFC 8292.
+type WebPush struct {
+	// Key identifies the server to push services. Browsers subscribe with
+	// its public key.
+	Key *ecdsa.PrivateKey
+	// Subject is a mailto: or https: URL push services can reach the
+	// operator at.
+	Subject string
+	// TTL is how long push services keep messages for offline browsers.
+	TTL    time.Duration
+	Client *http.Client
+}
+
+// pushSubscription is a push subscription of a browser.
+type pushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// ParseVAPIDKey parses a VAPID private key given as the URL-safe base64 of
+// its 32 bytes, the format tools like web-push generate.
+func ParseVAPIDKey(s string) (*ecdsa.PrivateKey, error) {
+	d, err := decodeBase64URL(s)
+	if err != nil {
+		return nil, errors.New("key must be URL-safe base64")
+	}
+	k, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+	pub := k.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}, nil
+}
+
+// PublicKey returns the public VAPID key as URL-safe base64, the
+// applicationServerKey browsers subscribe with.
+func (p *WebPush) PublicKey() string {
+	pub := make([]byte, 65)
+	pub[0] = 4
+	p.Key.X.FillBytes(pub[1:33])
+	p.Key.Y.FillBytes(pub[33:])
+	return base64.RawURLEncoding.EncodeToString(pub)
+}
+
+func (p *WebPush) Validate(target string) error {
+	_, _, _, err := parsePushSubscription(target)
+	return err
+}
+
+// Notify sends the message as JSON with a title, body and clipboard_id.
+// Messages about the same clipboard replace each other while the browser
+// is offline. It returns ErrExpired if the subscription is gone.
+func (p *WebPush) Notify(ctx context.Context, target string, m Message) error {
+	sub, uaPublic, auth, err := parsePushSubscription(target)
+	if err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"title":        m.Title,
+		"body":         m.Body,
+		"clipboard_id": m.ClipboardId,
+	})
+	body, err := encryptPushMessage(uaPublic, auth, payload)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := p.vapid(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(p.TTL.Seconds())))
+	if m.ClipboardId != 0 {
+		req.Header.Set("Topic", "clipboard-"+strconv.Itoa(m.ClipboardId))
+	}
+
+	err = send(p.Client, req)
+	var status *statusError
+	if errors.As(err, &status) && (status.code == http.StatusNotFound || status.code == http.StatusGone) {
+		return fmt.Errorf("%w: %v", ErrExpired, err)
+	}
+	return err
+}
+
+// vapid returns the Authorization header identifying the server to the
+// push service of endpoint, with a token valid for 12 hours.
+func (p *WebPush) vapid(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": p.Subject,
+	}).SignedString(p.Key)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + p.PublicKey(), nil
+}
+
+// parsePushSubscription parses a push subscription, returning it with the
+// public key and authentication secret of the browser.
+func parsePushSubscription(target string) (*pushSubscription, *ecdh.PublicKey, []byte, error) {
+	var sub pushSubscription
+	if err := json.Unmarshal([]byte(target), &sub); err != nil {
+		return nil, nil, nil, errors.New("target must be a push subscription in JSON")
+	}
+	if u, err := url.Parse(sub.Endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, nil, nil, errors.New("push subscription endpoint must be an https URL")
+	}
+	p256dh, err := decodeBase64URL(sub.Keys.P256dh)
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid p256dh key of push subscription")
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid p256dh key of push subscription")
+	}
+	auth, err := decodeBase64URL(sub.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, nil, errors.New("invalid auth secret of push subscription")
+	}
+	return &sub, uaPublic, auth, nil
+}
+
+// encryptPushMessage encrypts a message for a browser with the aes128gcm
+// content encoding of RFC 8188, in a single record, with keys derived as
+// in RFC 8291.
+func encryptPushMessage(uaPublic *ecdh.PublicKey, auth, plaintext []byte) ([]byte, error) {
+	if len(plaintext)+1+16 > webPushRecordSize {
+		return nil, errors.New("push message too large")
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic.Bytes()...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, auth, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header holds the salt, the record size and the public key of the
+	// server, followed by the only record, padded with its delimiter.
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, append(plaintext, 2), nil), nil
+}
+
+// decodeBase64URL decodes URL-safe base64 with or without padding, as
+// browsers and tools differ.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,369 @@
+// Package oidc logs users in with an external OpenID Connect provider, such
+// as Authelia, Keycloak or Google, using the authorization code flow with
+// PKCE. The endpoints and signing keys of the provider are discovered from
+// its issuer URL.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keysRefreshInterval is how often the signing keys are fetched again at
+// most, when an ID token is signed with an unknown key.
+const keysRefreshInterval = time.Minute
+
+// Config identifies the server as a client of a provider.
+type Config struct {
+	// Issuer is the URL of the provider, the discovery document is fetched
+	// from Issuer/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback the provider redirects to after login.
+	RedirectURL string
+	Scopes      []string
+}
+
+// metadata is the part of the discovery document of a provider in use.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider is an OpenID Connect provider. Its metadata and signing keys are
+// fetched on first use and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu            sync.Mutex
+	meta          *metadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// New creates a provider for a client configuration.
+func New(cfg Config) *Provider {
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// Claims are the claims of a verified ID token, merged with those of the
+// userinfo endpoint.
+type Claims map[string]any
+
+// Subject returns the identifier of the user at the provider.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String returns a string claim, or "" if it is missing or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Bool returns a boolean claim. Some providers send booleans as strings.
+func (c Claims) Bool(name string) bool {
+	switch v := c[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// MFA reports whether the user authenticated with more than one factor, by
+// the authentication methods of RFC 8176 in the amr claim.
+func (c Claims) MFA() bool {
+	methods, _ := c["amr"].([]any)
+	for _, m := range methods {
+		switch m {
+		case "mfa", "otp", "hwk", "sc", "swk":
+			return true
+		}
+	}
+	return false
+}
+
+// NewVerifier generates a random PKCE code verifier. It doubles as a source
+// of state and nonce values.
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthURL returns the URL to send the user to for logging in. The provider
+// redirects back to the redirect URL with the given state, and includes
+// nonce in the ID token.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+}
+
+// errorResponse is an OAuth 2.0 error response.
+type errorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code for an ID token, verifies it and
+// returns its claims. Claims missing from the ID token are completed from
+// the userinfo endpoint, if the provider has one.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	basic := p.cfg.ClientSecret != "" &&
+		(len(meta.TokenAuthMethods) == 0 || slices.Contains(meta.TokenAuthMethods, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens tokenResponse
+	if err := p.do(req, &tokens); err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	claims, err := p.verify(ctx, meta, tokens.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims.String("nonce") != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+
+	if meta.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		info, err := p.userinfo(ctx, meta, tokens.AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("userinfo request: %w", err)
+		}
+		if info.Subject() != claims.Subject() {
+			return nil, errors.New("userinfo is about another subject")
+		}
+		for k, v := range info {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+
+	return claims, nil
+}
+
+// verify checks the signature, issuer, audience and expiry of an ID token.
+func (p *Provider) verify(ctx context.Context, meta *metadata, idToken string) (Claims, error) {
+	var claims jwt.MapClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Claims(claims)
+	if c.Subject() == "" {
+		return nil, errors.New("no subject")
+	}
+	// The authorized party must be this client if the token has several
+	// audiences.
+	if aud, _ := claims.GetAudience(); len(aud) > 1 && c.String("azp") != p.cfg.ClientID {
+		return nil, errors.New("issued to another party")
+	}
+	return c, nil
+}
+
+func (p *Provider) userinfo(ctx context.Context, meta *metadata, accessToken string) (Claims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var claims Claims
+	if err := p.do(req, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// metadata returns the discovery document of the provider, fetching it on
+// first use.
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta metadata
+	if err := p.do(req, &meta); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != strings.TrimSuffix(p.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery: missing endpoints")
+	}
+
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// key returns the signing key with the given id, fetching the keys of the
+// provider again if it is unknown, e.g. after a key rotation. Tokens without
+// a key id are accepted if the provider has a single key.
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := lookupKey(p.keys, kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwks
+	if err := p.do(req, &set); err != nil {
+		return nil, fmt.Errorf("signing keys: %w", err)
+	}
+	p.keys = set.publicKeys()
+	p.keysFetchedAt = time.Now()
+
+	if key := lookupKey(p.keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func lookupKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[kid]
+}
+
+// do sends a request and decodes its JSON response into v, turning error
+// responses into errors.
+func (p *Provider) do(req *http.Request, v any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			if e.Description != "" {
+				return fmt.Errorf("%s: %s", e.Error, e.Description)
+			}
+			return errors.New(e.Error)
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
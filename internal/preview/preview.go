@@ -0,0 +1,187 @@
+// Package preview extracts metadata from clipboard data, such as its line
+// count, the language of code and the dimensions of images, so clients
+// listing clipboards can render previews without downloading the data.
+package preview
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// typeLanguages maps data types to the language of their data.
+var typeLanguages = map[string]string{
+	"application/json":       "json",
+	"application/xml":        "xml",
+	"text/xml":               "xml",
+	"application/javascript": "javascript",
+	"text/javascript":        "javascript",
+	"application/x-sh":       "shell",
+	"text/x-shellscript":     "shell",
+	"text/html":              "html",
+	"text/css":               "css",
+	"text/markdown":          "markdown",
+	"text/x-go":              "go",
+	"text/x-python":          "python",
+	"text/x-c":               "c",
+	"text/x-java":            "java",
+	"text/x-rust":            "rust",
+	"application/sql":        "sql",
+	"application/yaml":       "yaml",
+	"text/yaml":              "yaml",
+	"application/toml":       "toml",
+	"image/svg+xml":          "xml",
+}
+
+// languagePatterns recognize code sent as plain text, tried in order.
+// They are heuristics: only code with telltale lines is recognized.
+var languagePatterns = []struct {
+	language string
+	pattern  *regexp.Regexp
+}{
+	{"go", regexp.MustCompile(`(?m)^package \w+\s*$[\s\S]*^(func|import|type) `)},
+	{"c", regexp.MustCompile(`(?m)^#include [<"]`)},
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+.*\{\s*$|^use \w+::`)},
+	{"java", regexp.MustCompile(`(?m)^\s*public (final )?(class|interface|static void) `)},
+	{"python", regexp.MustCompile(`(?m)^(def \w+\(.*\):|class \w+.*:|from [\w.]+ import )\s*$`)},
+	{"javascript", regexp.MustCompile(`(?m)^\s*(const|let) \w+ = |^\s*function \w+\(|\) => \{|^\s*(import .* from|export (default|const|function)) `)},
+	{"sql", regexp.MustCompile(`(?is)^\s*(select\s.+\sfrom\s|insert\s+into\s|update\s+\w+\s+set\s|delete\s+from\s|create\s+(table|index|view)\s)`)},
+	{"shell", regexp.MustCompile(`(?m)^\s*(sudo |apt(-get)? |export \w+=|cd [~/.]|echo ["$])`)},
+}
+
+// Extract returns the metadata of unencrypted data of the given type, or nil
+// if there is nothing to tell about it. Images sent base64-encoded through
+// the JSON API are decoded first.
+func Extract(dataType, data string) *clipboard.Metadata {
+	if data == "" {
+		return nil
+	}
+
+	base, _, _ := mime.ParseMediaType(dataType)
+	if strings.HasPrefix(base, "image/") && base != "image/svg+xml" {
+		return imageMetadata(data)
+	}
+	if !utf8.ValidString(data) {
+		return nil
+	}
+
+	m := &clipboard.Metadata{
+		Lines:    countLines(data),
+		Language: detectLanguage(base, data),
+	}
+	if base == clipboard.TemplateType {
+		m.Variables = clipboard.TemplateVariables(data)
+	}
+	return m
+}
+
+// imageMetadata returns the dimensions of a PNG, JPEG or GIF image, or nil
+// if it cannot be decoded.
+func imageMetadata(data string) *clipboard.Metadata {
+	cfg, _, err := image.DecodeConfig(strings.NewReader(data))
+	if err != nil {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		if decodeErr != nil {
+			return nil
+		}
+		if cfg, _, err = image.DecodeConfig(bytes.NewReader(decoded)); err != nil {
+			return nil
+		}
+	}
+	return &clipboard.Metadata{Width: cfg.Width, Height: cfg.Height}
+}
+
+// countLines counts the lines of text, including a last line without a
+// trailing newline.
+func countLines(data string) int {
+	n := strings.Count(data, "\n")
+	if !strings.HasSuffix(data, "\n") {
+		n++
+	}
+	return n
+}
+
+// detectLanguage returns the language of code, from its type or else from
+// its content, or "" if it is not recognized.
+func detectLanguage(base, data string) string {
+	if language, ok := typeLanguages[base]; ok {
+		return language
+	}
+	switch {
+	case strings.HasSuffix(base, "+json"):
+		return "json"
+	case strings.HasSuffix(base, "+xml"):
+		return "xml"
+	case base != "text/plain":
+		return ""
+	}
+
+	trimmed := strings.TrimSpace(data)
+	if first, _, _ := strings.Cut(trimmed, "\n"); strings.HasPrefix(first, "#!") {
+		switch {
+		case strings.Contains(first, "python"):
+			return "python"
+		case strings.Contains(first, "node"):
+			return "javascript"
+		default:
+			return "shell"
+		}
+	}
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	lower := strings.ToLower(trimmed)
+	if strings.HasPrefix(lower, "<!doctype html") || strings.HasPrefix(lower, "<html") {
+		return "html"
+	}
+	if strings.HasPrefix(lower, "<?xml") {
+		return "xml"
+	}
+	for _, p := range languagePatterns {
+		if p.pattern.MatchString(data) {
+			return p.language
+		}
+	}
+	return ""
+}
+
+// Link returns the URL clipboard data consists of: a single http or https
+// URL sent as plain text, or the first URL of a text/uri-list.
+func Link(dataType, data string) (string, bool) {
+	base, _, _ := mime.ParseMediaType(dataType)
+	var candidate string
+	switch base {
+	case "text/plain":
+		candidate = strings.TrimSpace(data)
+	case "text/uri-list":
+		for _, line := range strings.Split(data, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				candidate = line
+				break
+			}
+		}
+	default:
+		return "", false
+	}
+
+	if candidate == "" || strings.ContainsAny(candidate, " \t\r\n") {
+		return "", false
+	}
+	u, err := url.Parse(candidate)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
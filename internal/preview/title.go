@@ -0,0 +1,176 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/events"
+)
+
+const (
+	// maxPageSize is how much of a page is searched for its title.
+	maxPageSize = 64 << 10
+	// maxTitleLength is the number of characters titles are cut to.
+	maxTitleLength = 200
+)
+
+// ErrPrivateAddress is returned for links to loopback, private and
+// link-local addresses, unless they are allowed.
+var ErrPrivateAddress = errors.New("link points to a private address")
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Config configures fetching the titles of links.
+type Config struct {
+	// FetchTitles enables fetching titles. It is off by default, since the
+	// server then requests every link stored in a clipboard.
+	FetchTitles bool
+	// Timeout bounds fetching a single title.
+	Timeout time.Duration
+	// AllowPrivate allows links to loopback and private addresses, which are
+	// refused by default so clipboards cannot probe the server's network.
+	AllowPrivate bool
+}
+
+// ConfigFromEnv reads the title fetching configuration from PREVIEW_FETCH_TITLES,
+// PREVIEW_FETCH_TIMEOUT and PREVIEW_FETCH_PRIVATE.
+func ConfigFromEnv() Config {
+	return Config{
+		FetchTitles:  env.Bool("PREVIEW_FETCH_TITLES", false),
+		Timeout:      env.Duration("PREVIEW_FETCH_TIMEOUT", 5*time.Second),
+		AllowPrivate: env.Bool("PREVIEW_FETCH_PRIVATE", false),
+	}
+}
+
+// Fetcher fetches the titles of links written to clipboards.
+type Fetcher struct {
+	client *http.Client
+	// save stores the title of a clipboard if it still has the version the
+	// title was fetched for.
+	save func(ctx context.Context, clipboardId, version int, title string) error
+}
+
+// NewFetcher creates a fetcher storing titles with save.
+func NewFetcher(cfg Config, save func(ctx context.Context, clipboardId, version int, title string) error) *Fetcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = refusePrivate
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Fetcher{
+		client: &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		save:   save,
+	}
+}
+
+// Run fetches the titles of links created or updated on the bus until ctx
+// is done, one at a time.
+func (f *Fetcher) Run(ctx context.Context, bus *events.Bus) {
+	ch, unsubscribe := bus.Subscribe(64)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			f.handle(ctx, e)
+		}
+	}
+}
+
+// handle fetches and stores the title of the link of a clipboard event.
+// Events of encrypted and streamed clipboards carry no data and are skipped.
+func (f *Fetcher) handle(ctx context.Context, e events.Event) {
+	if e.Type != events.ClipboardCreated && e.Type != events.ClipboardUpdated {
+		return
+	}
+	link, ok := Link(e.DataType, e.Data)
+	if !ok {
+		return
+	}
+
+	title, err := f.Title(ctx, link)
+	if err != nil {
+		log.Printf("preview: cannot fetch title of clipboard %d: %v", e.ClipboardId, err)
+		return
+	}
+	if title == "" {
+		return
+	}
+	if err := f.save(ctx, e.ClipboardId, e.Version, title); err != nil {
+		log.Printf("preview: error saving title of clipboard %d: %v", e.ClipboardId, err)
+	}
+}
+
+// Title fetches an HTML page and returns its title, or "" if it has none.
+// Only the beginning of the page is searched.
+func (f *Fetcher) Title(ctx context.Context, link string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if base, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); base != "text/html" {
+		return "", nil
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return "", err
+	}
+	m := titlePattern.FindSubmatch(page)
+	if m == nil {
+		return "", nil
+	}
+
+	title := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	if !utf8.ValidString(title) {
+		return "", nil
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+	return title, nil
+}
+
+// refusePrivate refuses connections to addresses that are not public.
+// It runs after name resolution, so host names cannot sneak around it.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+// Package relay brokers transfers between the devices of a user without
+// storing them. Devices, e.g. behind NAT, keep a connection open to receive
+// offers; the body of the request sending a transfer is streamed straight
+// into the response of the request accepting it.
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// offerBuffer is the number of offers a device can have waiting to be
+// delivered.
+const offerBuffer = 16
+
+// Errors of transfers.
+var (
+	ErrDeviceConnected = errors.New("device is already connected")
+	ErrDeviceNotFound  = errors.New("device is not connected")
+	ErrBusy            = errors.New("device has too many pending transfers")
+	ErrDeclined        = errors.New("transfer was declined")
+)
+
+// Offer announces a transfer to the device it is sent to.
+type Offer struct {
+	Id       string `json:"id"`
+	From     string `json:"from,omitempty"`
+	DataType string `json:"type"`
+	// Size is the size of the data in bytes, or -1 if the sender did not
+	// tell.
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Device is a connected device, named by its user.
+type Device struct {
+	Name        string    `json:"name"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	userId int
+	offers chan Offer
+}
+
+// Offers delivers the offers of transfers sent to the device.
+func (d *Device) Offers() <-chan Offer {
+	return d.offers
+}
+
+// Transfer is a transfer waiting to be accepted or being copied.
+type Transfer struct {
+	Offer
+
+	userId int
+	to     *Device
+	body   io.Reader
+
+	done chan struct{}
+	n    int64
+	err  error
+}
+
+// Done is closed once the transfer was copied, declined or dropped.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Result returns the number of bytes copied and why the transfer failed, if
+// it did. It must only be called once Done is closed.
+func (t *Transfer) Result() (int64, error) {
+	return t.n, t.err
+}
+
+// CopyTo copies the data of an accepted transfer to w and completes it.
+func (t *Transfer) CopyTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, t.body)
+	t.finish(n, err)
+	return n, err
+}
+
+func (t *Transfer) finish(n int64, err error) {
+	t.n, t.err = n, err
+	close(t.done)
+}
+
+// Hub keeps track of the connected devices and pending transfers of all
+// users.
+type Hub struct {
+	mu        sync.Mutex
+	devices   map[int]map[string]*Device
+	transfers map[string]*Transfer
+}
+
+// NewHub creates a hub without devices.
+func NewHub() *Hub {
+	return &Hub{
+		devices:   make(map[int]map[string]*Device),
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+// Connect registers a device of a user under name. A name can only be used
+// by one connection at a time.
+func (h *Hub) Connect(userId int, name string) (*Device, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.devices[userId][name] != nil {
+		return nil, ErrDeviceConnected
+	}
+	if h.devices[userId] == nil {
+		h.devices[userId] = make(map[string]*Device)
+	}
+	d := &Device{
+		Name:        name,
+		ConnectedAt: time.Now().UTC(),
+		userId:      userId,
+		offers:      make(chan Offer, offerBuffer),
+	}
+	h.devices[userId][name] = d
+	return d, nil
+}
+
+// Disconnect unregisters a device and fails the transfers it has not
+// accepted yet.
+func (h *Hub) Disconnect(d *Device) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.devices[d.userId][d.Name] != d {
+		return
+	}
+	delete(h.devices[d.userId], d.Name)
+	if len(h.devices[d.userId]) == 0 {
+		delete(h.devices, d.userId)
+	}
+	for id, t := range h.transfers {
+		if t.to == d {
+			delete(h.transfers, id)
+			t.finish(0, ErrDeviceNotFound)
+		}
+	}
+}
+
+// Devices returns the connected devices of a user, sorted by name.
+func (h *Hub) Devices(userId int) []Device {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	devices := []Device{}
+	for _, d := range h.devices[userId] {
+		devices = append(devices, *d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices
+}
+
+// Send offers the data read from body to a device of the user. The caller
+// must keep body readable until the transfer is done, and cancel it if it
+// stops waiting.
+func (h *Hub) Send(userId int, to string, offer Offer, body io.Reader) (*Transfer, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	offer.Id = hex.EncodeToString(b)
+	offer.CreatedAt = time.Now().UTC()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := h.devices[userId][to]
+	if d == nil {
+		return nil, ErrDeviceNotFound
+	}
+	t := &Transfer{Offer: offer, userId: userId, to: d, body: body, done: make(chan struct{})}
+	select {
+	case d.offers <- offer:
+	default:
+		return nil, ErrBusy
+	}
+	h.transfers[t.Id] = t
+	return t, nil
+}
+
+// Cancel withdraws a transfer that has not been accepted yet. It returns
+// false if it already was, in which case the caller has to wait for it to
+// be done.
+func (h *Hub) Cancel(t *Transfer) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.transfers[t.Id] != t {
+		return false
+	}
+	delete(h.transfers, t.Id)
+	t.finish(0, io.ErrClosedPipe)
+	return true
+}
+
+// Accept takes a pending transfer to a device of the user, to be copied with
+// CopyTo. It returns nil if there is no such transfer.
+func (h *Hub) Accept(userId int, id string) *Transfer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.transfers[id]
+	if t == nil || t.userId != userId {
+		return nil
+	}
+	delete(h.transfers, id)
+	return t
+}
+
+// Decline refuses a pending transfer to a device of the user, failing it
+// with ErrDeclined. It returns false if there is no such transfer.
+func (h *Hub) Decline(userId int, id string) bool {
+	t := h.Accept(userId, id)
+	if t == nil {
+		return false
+	}
+	t.finish(0, ErrDeclined)
+	return true
+}
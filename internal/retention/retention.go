@@ -0,0 +1,177 @@
+// Package retention deletes clipboards according to configurable retention
+// rules, so the database does not grow forever.
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// Store is the storage retention rules are applied to.
+type Store interface {
+	StaleClipboards(ctx context.Context, encrypted bool, before time.Time) ([]int, error)
+	CountClipboards(ctx context.Context) (int, error)
+	LeastRecentlyRead(ctx context.Context, limit int) ([]int, error)
+	Delete(ctx context.Context, id int) error
+	DeleteExpiredUploads(ctx context.Context, before time.Time) (int, error)
+}
+
+// NamespacedStore is a storage keeping clipboards in namespaces, such as the
+// database. Retention rules apply to one namespace at a time, see
+// InNamespace.
+type NamespacedStore interface {
+	StaleClipboards(ctx context.Context, namespace string, encrypted bool, before time.Time) ([]int, error)
+	CountClipboards(ctx context.Context, namespace string) (int, error)
+	LeastRecentlyRead(ctx context.Context, namespace string, limit int) ([]int, error)
+	Delete(ctx context.Context, id int) error
+	DeleteExpiredUploads(ctx context.Context, before time.Time) (int, error)
+}
+
+// InNamespace returns the Store of the clipboards of a namespace of store.
+func InNamespace(store NamespacedStore, namespace string) Store {
+	return namespaceStore{store, namespace}
+}
+
+type namespaceStore struct {
+	NamespacedStore
+	namespace string
+}
+
+func (n namespaceStore) StaleClipboards(ctx context.Context, encrypted bool, before time.Time) ([]int, error) {
+	return n.NamespacedStore.StaleClipboards(ctx, n.namespace, encrypted, before)
+}
+
+func (n namespaceStore) CountClipboards(ctx context.Context) (int, error) {
+	return n.NamespacedStore.CountClipboards(ctx, n.namespace)
+}
+
+func (n namespaceStore) LeastRecentlyRead(ctx context.Context, limit int) ([]int, error) {
+	return n.NamespacedStore.LeastRecentlyRead(ctx, n.namespace, limit)
+}
+
+// Policy holds the retention rules. Zero values disable a rule.
+type Policy struct {
+	// UnencryptedMaxAge and EncryptedMaxAge delete clipboards that were not
+	// updated for longer than the given duration.
+	UnencryptedMaxAge time.Duration
+	EncryptedMaxAge   time.Duration
+	// MaxClipboards caps the number of clipboards, evicting the least
+	// recently read ones first.
+	MaxClipboards int
+
+	// DryRun only logs the clipboards that would be deleted.
+	DryRun bool
+	// Interval is how often the server applies the policy.
+	Interval time.Duration
+}
+
+// PolicyFromEnv reads the retention policy from RETENTION_* variables.
+func PolicyFromEnv() Policy {
+	return Policy{
+		UnencryptedMaxAge: env.Duration("RETENTION_UNENCRYPTED_MAX_AGE", 0),
+		EncryptedMaxAge:   env.Duration("RETENTION_ENCRYPTED_MAX_AGE", 0),
+		MaxClipboards:     env.Int("RETENTION_MAX_CLIPBOARDS", 0),
+		DryRun:            env.Bool("RETENTION_DRY_RUN", false),
+		Interval:          env.Duration("RETENTION_INTERVAL", time.Hour),
+	}
+}
+
+// Override returns the policy with the rules overridden by the variables
+// named like the RETENTION_* ones with the given prefix, e.g.
+// NAMESPACE_FAMILY_RETENTION_MAX_CLIPBOARDS. Unset variables keep the rules
+// of p.
+func (p Policy) Override(prefix string) Policy {
+	p.UnencryptedMaxAge = env.Duration(prefix+"RETENTION_UNENCRYPTED_MAX_AGE", p.UnencryptedMaxAge)
+	p.EncryptedMaxAge = env.Duration(prefix+"RETENTION_ENCRYPTED_MAX_AGE", p.EncryptedMaxAge)
+	p.MaxClipboards = env.Int(prefix+"RETENTION_MAX_CLIPBOARDS", p.MaxClipboards)
+	return p
+}
+
+// Enabled reports whether any retention rule is configured.
+func (p Policy) Enabled() bool {
+	return p.UnencryptedMaxAge > 0 || p.EncryptedMaxAge > 0 || p.MaxClipboards > 0
+}
+
+// Apply runs the retention rules once and returns the ids of the deleted
+// clipboards, or of those that would be deleted in dry-run mode.
+func (p Policy) Apply(ctx context.Context, store Store, now time.Time) ([]int, error) {
+	var deleted []int
+	seen := make(map[int]bool)
+	remove := func(id int, rule string) error {
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		deleted = append(deleted, id)
+
+		if p.DryRun {
+			log.Printf("retention: would delete clipboard %d (%s)", id, rule)
+			return nil
+		}
+		log.Printf("retention: deleting clipboard %d (%s)", id, rule)
+		return store.Delete(ctx, id)
+	}
+
+	for _, rule := range []struct {
+		encrypted bool
+		maxAge    time.Duration
+		name      string
+	}{
+		{false, p.UnencryptedMaxAge, "unencrypted max age"},
+		{true, p.EncryptedMaxAge, "encrypted max age"},
+	} {
+		if rule.maxAge == 0 {
+			continue
+		}
+		ids, err := store.StaleClipboards(ctx, rule.encrypted, now.Add(-rule.maxAge))
+		if err != nil {
+			return deleted, err
+		}
+		for _, id := range ids {
+			if err := remove(id, rule.name); err != nil {
+				return deleted, err
+			}
+		}
+	}
+
+	if p.MaxClipboards > 0 {
+		count, err := store.CountClipboards(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		// In dry-run mode the clipboards selected above are still counted.
+		if p.DryRun {
+			count -= len(deleted)
+		}
+
+		if excess := count - p.MaxClipboards; excess > 0 {
+			ids, err := store.LeastRecentlyRead(ctx, excess+len(deleted))
+			if err != nil {
+				return deleted, err
+			}
+			for _, id := range ids {
+				if excess == 0 {
+					break
+				}
+				if seen[id] {
+					continue
+				}
+				if err := remove(id, "max clipboards"); err != nil {
+					return deleted, err
+				}
+				excess--
+			}
+		}
+	}
+
+	if !p.DryRun {
+		if _, err := store.DeleteExpiredUploads(ctx, now); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,87 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// clamavChunkSize is the size of the chunks data is streamed to clamd in.
+const clamavChunkSize = 64 << 10
+
+// ClamAV scans data for malware with a clamd daemon, streaming it with the
+// INSTREAM command.
+type ClamAV struct {
+	// Network is "unix" or "tcp".
+	Network string
+	// Address is the path of the socket or the host and port of clamd.
+	Address string
+}
+
+// NewClamAV returns a scanner for clamd listening at addr, which is the
+// path of a Unix socket, optionally prefixed with "unix:", or a host and
+// port, optionally prefixed with "tcp:".
+func NewClamAV(addr string) *ClamAV {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return &ClamAV{Network: "unix", Address: strings.TrimPrefix(addr, "unix:")}
+	case strings.HasPrefix(addr, "tcp:"):
+		return &ClamAV{Network: "tcp", Address: strings.TrimPrefix(addr, "tcp:")}
+	case strings.HasPrefix(addr, "/"):
+		return &ClamAV{Network: "unix", Address: addr}
+	}
+	return &ClamAV{Network: "tcp", Address: addr}
+}
+
+func (c *ClamAV) Name() string {
+	return "clamav"
+}
+
+// Scan returns the name of the signature data matches.
+func (c *ClamAV) Scan(ctx context.Context, data []byte) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	_, _ = w.WriteString("zINSTREAM\x00")
+	size := make([]byte, 4)
+	for len(data) > 0 {
+		chunk := data[:min(len(data), clamavChunkSize)]
+		data = data[len(chunk):]
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		_, _ = w.Write(size)
+		_, _ = w.Write(chunk)
+	}
+	// A chunk of length zero ends the stream.
+	binary.BigEndian.PutUint32(size, 0)
+	_, _ = w.Write(size)
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	reply = strings.TrimPrefix(strings.TrimSuffix(reply, "\x00"), "stream: ")
+
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
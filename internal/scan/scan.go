@@ -0,0 +1,171 @@
+// Package scan inspects clipboard content before it is stored, with
+// scanners such as ClamAV and detectors of secrets like API keys, so teams
+// can keep malware and credentials from spreading through shared clipboards.
+// Each scanner either rejects content it objects to or flags it for
+// quarantine.
+package scan
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// Action is what happens to content a scanner objects to.
+type Action string
+
+const (
+	// Reject refuses to store the content.
+	Reject Action = "reject"
+	// Flag stores the content, but quarantines it.
+	Flag Action = "flag"
+)
+
+// ParseAction parses an action, returning def for an empty string.
+func ParseAction(s string, def Action) (Action, error) {
+	switch a := Action(s); a {
+	case "":
+		return def, nil
+	case Reject, Flag:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown action %q, must be reject or flag", s)
+	}
+}
+
+// Scanner inspects data.
+type Scanner interface {
+	// Name identifies the scanner in findings.
+	Name() string
+	// Scan returns what it found in data, such as the name of a virus, or
+	// an empty string if data is clean.
+	Scan(ctx context.Context, data []byte) (string, error)
+}
+
+// Finding is content a scanner objects to.
+type Finding struct {
+	Scanner string
+	Reason  string
+	Action  Action
+}
+
+// String describes the finding as "scanner: reason".
+func (f *Finding) String() string {
+	return f.Scanner + ": " + f.Reason
+}
+
+// Pipeline runs scanners, each with the action to take on its findings.
+// The zero value runs no scanners.
+type Pipeline struct {
+	// MaxSize is how many bytes of data are scanned, the rest is not.
+	// Zero scans all of it.
+	MaxSize int
+	// Timeout bounds a scan with all scanners.
+	Timeout time.Duration
+	// FailOpen lets content through, unscanned, when a scanner fails
+	// rather than failing the scan.
+	FailOpen bool
+
+	rules []rule
+}
+
+type rule struct {
+	scanner Scanner
+	action  Action
+}
+
+// Add adds a scanner taking action on its findings.
+func (p *Pipeline) Add(s Scanner, action Action) {
+	p.rules = append(p.rules, rule{s, action})
+}
+
+// Enabled reports whether the pipeline runs any scanners.
+func (p *Pipeline) Enabled() bool {
+	return p != nil && len(p.rules) > 0
+}
+
+// Scan runs the scanners on data. It returns the first rejecting finding,
+// or else the first flagging one, or nil if data is clean.
+// It returns an error if a scanner fails, unless FailOpen is set.
+func (p *Pipeline) Scan(ctx context.Context, data []byte) (*Finding, error) {
+	if !p.Enabled() {
+		return nil, nil
+	}
+	if p.MaxSize > 0 && len(data) > p.MaxSize {
+		data = data[:p.MaxSize]
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	var flagged *Finding
+	for _, r := range p.rules {
+		reason, err := r.scanner.Scan(ctx, data)
+		if err != nil {
+			if p.FailOpen {
+				log.Printf("error scanning with %s, letting content through: %v", r.scanner.Name(), err)
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", r.scanner.Name(), err)
+		}
+		if reason == "" {
+			continue
+		}
+
+		f := &Finding{Scanner: r.scanner.Name(), Reason: reason, Action: r.action}
+		if r.action == Reject {
+			return f, nil
+		}
+		if flagged == nil {
+			flagged = f
+		}
+	}
+
+	return flagged, nil
+}
+
+// FromEnv returns the pipeline configured with the SCAN_* variables. It
+// runs no scanners unless SCAN_CLAMAV or SCAN_SECRETS is set.
+func FromEnv() (*Pipeline, error) {
+	p := &Pipeline{
+		MaxSize:  env.Int("SCAN_MAX_SIZE", 25<<20),
+		Timeout:  env.Duration("SCAN_TIMEOUT", 10*time.Second),
+		FailOpen: env.Bool("SCAN_FAIL_OPEN", false),
+	}
+
+	if addr := env.String("SCAN_CLAMAV", ""); addr != "" {
+		action, err := ParseAction(env.String("SCAN_CLAMAV_ACTION", ""), Reject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCAN_CLAMAV_ACTION: %w", err)
+		}
+		p.Add(NewClamAV(addr), action)
+	}
+
+	if env.Bool("SCAN_SECRETS", false) {
+		action, err := ParseAction(env.String("SCAN_SECRETS_ACTION", ""), Flag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCAN_SECRETS_ACTION: %w", err)
+		}
+		detectors := DefaultDetectors
+		if path := env.String("SCAN_SECRET_PATTERNS_FILE", ""); path != "" {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SCAN_SECRET_PATTERNS_FILE: %w", err)
+			}
+			custom, err := ParseDetectors(string(text))
+			if err != nil {
+				return nil, fmt.Errorf("invalid SCAN_SECRET_PATTERNS_FILE: %w", err)
+			}
+			detectors = append(detectors[:len(detectors):len(detectors)], custom...)
+		}
+		p.Add(&Secrets{Detectors: detectors}, action)
+	}
+
+	return p, nil
+}
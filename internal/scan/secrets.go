@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Detector finds a kind of secret by a pattern.
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultDetectors find well-known credentials whose format makes false
+// positives unlikely.
+var DefaultDetectors = []Detector{
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`)},
+	{"github-token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`)},
+	{"gitlab-token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"stripe-key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{24,}\b`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+}
+
+// ParseDetectors parses detectors from lines of the form "name=pattern".
+// Blank lines and lines starting with # are skipped.
+func ParseDetectors(text string) ([]Detector, error) {
+	var detectors []Detector
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, pattern, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected name=pattern", i+1)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		detectors = append(detectors, Detector{name, re})
+	}
+	return detectors, nil
+}
+
+// Secrets finds secrets such as API keys and private keys.
+type Secrets struct {
+	Detectors []Detector
+}
+
+func (s *Secrets) Name() string {
+	return "secrets"
+}
+
+// Scan returns the name of the first detector matching data.
+func (s *Secrets) Scan(ctx context.Context, data []byte) (string, error) {
+	for _, d := range s.Detectors {
+		if d.Pattern.Match(data) {
+			return d.Name, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// authenticate checks that the current user may perform action on the
+// clipboard and, for encrypted clipboards, checks the Basic Auth password of
+// the request and returns it.
+// If the request is not authenticated, it records the failed attempt,
+// responds with 401 and returns false.
+// Clients and clipboards with too many failed attempts are locked out and
+// get a 429 with Retry-After instead, so passwords cannot be guessed at the
+// speed of bcrypt comparisons.
+// Unencrypted clipboards are accessible to everyone allowed to.
+// Reads with a view-limited clipboard token use up one of its views, only
+// once the password was checked.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, action string) (string, bool) {
+	if !s.authorize(w, r, c, action) {
+		return "", false
+	}
+	if !c.IsEncrypted {
+		return "", s.countView(w, r, c, action)
+	}
+
+	ip, id := s.clientIP(r), strconv.Itoa(c.Id)
+	if wait := max(s.ipFailures.Locked(ip), s.clipboardFailures.Locked(id)); wait > 0 {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeThrottled)
+		tooManyAttempts(w, wait)
+		return "", false
+	}
+
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeUnauthorized)
+		validation.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	_, span := telemetry.Start(r.Context(), "crypto.Authenticate")
+	authenticated := c.Authenticate(password)
+	span.End()
+	if !authenticated {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeUnauthorized)
+		if wait := max(s.ipFailures.Fail(ip), s.clipboardFailures.Fail(id)); wait > 0 {
+			tooManyAttempts(w, wait)
+			return "", false
+		}
+		validation.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	s.ipFailures.Succeed(ip)
+	s.clipboardFailures.Succeed(id)
+
+	return password, s.countView(w, r, c, action)
+}
+
+// authorize checks that the clipboard is not locked, the role of the
+// current user on the server, see permits, and their role on an owned
+// clipboard.
+// Owned clipboards are private to their owner and the users they are shared
+// with; anonymous clipboards are accessible to everyone. Requests made with a
+// clipboard token are limited to the clipboard and scopes of the token.
+// If the action is not allowed, it responds with 423 for locked clipboards
+// or 403 and returns false.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, action string) bool {
+	if c.Locked {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeForbidden)
+		validation.Error(w, "clipboard is locked by an administrator", http.StatusLocked)
+		return false
+	}
+	if t := currentToken(r); t != nil {
+		if t.ClipboardId != c.Id || !t.Allows(action) {
+			s.logAccess(r, c.Id, action, clipboard.OutcomeForbidden)
+			validation.Error(w, "forbidden", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if !permits(r, action) {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeForbidden)
+		validation.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if c.OwnerId == 0 {
+		return true
+	}
+
+	role, err := s.role(r, c)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return false
+	}
+	if !clipboard.RoleAllows(role, action) {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeForbidden)
+		validation.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// role returns the role of the current user on an owned clipboard, or "" if
+// they have none.
+func (s *Server) role(r *http.Request, c *clipboard.Clipboard) (string, error) {
+	userId := currentUserId(r)
+	switch {
+	case userId == 0:
+		return "", nil
+	case userId == c.OwnerId:
+		return clipboard.RoleOwner, nil
+	default:
+		return s.db.Role(r.Context(), c.Id, userId)
+	}
+}
+
+// tooManyAttempts responds with 429 and the number of seconds to wait before
+// retrying.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	validation.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+}
+
+// decryptionFailed records that the data of a clipboard could not be
+// decrypted and responds with 500.
+func (s *Server) decryptionFailed(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) {
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeDecryptionFailed)
+	validation.Error(w, "clipboard decryption failed", http.StatusInternalServerError)
+}
+
+// logAccess records an access to a clipboard in its access log.
+// Successful reads also refresh the last read time of the clipboard.
+// Errors are logged and never fail the request.
+func (s *Server) logAccess(r *http.Request, id int, action, outcome string) {
+	if action == clipboard.ActionRead && outcome == clipboard.OutcomeSuccess {
+		if err := s.db.MarkRead(r.Context(), id); err != nil {
+			log.Printf("error marking clipboard %d as read: %v", id, err)
+		}
+	}
+
+	e := &clipboard.AccessEntry{
+		ClipboardId: id,
+		Action:      action,
+		Outcome:     outcome,
+		IP:          s.clientIP(r),
+		Device:      device(r),
+	}
+	if err := s.db.LogAccess(r.Context(), e); err != nil {
+		log.Printf("error logging access to clipboard %d: %v", id, err)
+	}
+}
+
+// device returns the name of the WireGuard peer the request comes from, or
+// else the name the client device identifies itself with, falling back to
+// its user agent.
+func device(r *http.Request) string {
+	if d, ok := r.Context().Value(peerContextKey).(string); ok {
+		return d
+	}
+	if d := r.Header.Get("X-Device-Name"); d != "" {
+		return d
+	}
+	return r.UserAgent()
+}
+
+// authenticateAudit checks that the request may audit the clipboard, which
+// only its owner may, see authenticate; clipboard tokens never may.
+// Anonymous clipboards belong to no one, and anyone knowing their id could
+// see who accessed them from where, so they are reported as not found.
+func (s *Server) authenticateAudit(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) bool {
+	if c.OwnerId == 0 {
+		validation.Error(w, "clipboard not found", http.StatusNotFound)
+		return false
+	}
+	_, ok := s.authenticate(w, r, c, clipboard.ActionAudit)
+	return ok
+}
+
+func (s *Server) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultAuditLimit, maxAuditLimit)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.loadClipboard(w, r)
+	if c == nil || !s.authenticateAudit(w, r, c) {
+		return
+	}
+
+	entries, err := s.db.AccessLog(r.Context(), c.Id, limit)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(entries)
+	_, _ = w.Write(jsonResp)
+}
+
+// StatsHandler reports how often and from which devices a clipboard is read
+// and written, and how its size changed, to those who may audit it, see
+// authenticateAudit.
+func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil || !s.authenticateAudit(w, r, c) {
+		return
+	}
+
+	stats, err := s.db.ClipboardStats(r.Context(), c.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(stats)
+	_, _ = w.Write(jsonResp)
+}
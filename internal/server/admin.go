@@ -0,0 +1,429 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// adminRoutes registers the admin API, which is available to users with the
+// admin role and, if ADMIN_TOKEN is set, to requests carrying it.
+func (s *Server) adminRoutes(r chi.Router) {
+	r.Use(s.requireAdmin)
+
+	r.Get("/stats", s.AdminStatsHandler)
+	r.Get("/metrics", s.MetricsHandler)
+	r.Get("/users", s.AdminUsersHandler)
+	r.Post("/users", s.AdminCreateUserHandler)
+	r.Patch("/users/{user}", s.AdminUpdateUserHandler)
+	r.Delete("/users/{user}/totp", s.AdminResetTOTPHandler)
+	r.Delete("/users/{user}/identities", s.AdminUnlinkIdentitiesHandler)
+	r.Delete("/users/{user}/clipboards", s.AdminPurgeUserHandler)
+	r.Get("/clipboards", s.AdminClipboardsHandler)
+	r.Delete("/clipboards/{id}", s.AdminPurgeHandler)
+	r.Post("/clipboards/{id}/lock", s.AdminLockHandler)
+	r.Delete("/clipboards/{id}/lock", s.AdminLockHandler)
+	r.Get("/security-events", s.AdminSecurityEventsHandler)
+	r.Get("/config", s.AdminConfigHandler)
+	r.Get("/jobs", s.AdminJobsHandler)
+	r.Post("/jobs/{name}/run", s.AdminRunJobHandler)
+	if s.dbBackup.dir != "" {
+		r.Post("/backup", s.AdminBackupHandler)
+	}
+}
+
+// requireAdmin only lets requests of users of the default namespace with the
+// admin role, or carrying the admin token in the X-Admin-Token header,
+// through. Administrators must use an access token of a session logged in
+// with a TOTP code. Other users are forbidden. Wrong or missing tokens count towards
+// the lockout of the client IP like wrong clipboard passwords.
+// Administrators manage all namespaces.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := currentUser(r); u != nil && r.Header.Get("X-Admin-Token") == "" {
+			if !account.Allows(u.Role, account.PermAdmin) {
+				validation.Error(w, "forbidden for role "+u.Role, http.StatusForbidden)
+				return
+			}
+			if u.Namespace != account.DefaultNamespace {
+				validation.Error(w, "only administrators of the "+account.DefaultNamespace+" namespace may use the admin API", http.StatusForbidden)
+				return
+			}
+			if !currentTOTPVerified(r) {
+				validation.Error(w, "the admin API requires logging in with a two-factor authentication code", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, asAdmin(r))
+			return
+		}
+
+		ip := s.clientIP(r)
+		if wait := s.ipFailures.Locked(ip); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
+		sum := sha256.Sum256([]byte(r.Header.Get("X-Admin-Token")))
+		if !s.adminEnabled || subtle.ConstantTimeCompare(sum[:], s.adminTokenHash[:]) != 1 {
+			if wait := s.ipFailures.Fail(ip); wait > 0 {
+				tooManyAttempts(w, wait)
+				return
+			}
+			validation.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, asAdmin(r))
+	})
+}
+
+// asAdmin marks a request as made by an administrator.
+func asAdmin(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminContextKey, true))
+}
+
+// isAdmin reports whether requireAdmin let the request through.
+func isAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminContextKey).(bool)
+	return admin
+}
+
+// adminNamespace returns the namespace the admin API looks users up in,
+// given with ?namespace=, or the default namespace.
+func adminNamespace(r *http.Request) string {
+	if name := r.URL.Query().Get("namespace"); name != "" {
+		return name
+	}
+	return account.DefaultNamespace
+}
+
+// AdminStatsHandler reports counts and sizes across the whole server, as
+// last aggregated by the stats job or, without it, computed right away.
+func (s *Server) AdminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.stats.Load()
+	if snapshot == nil {
+		stats, err := s.db.Stats(r.Context())
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		snapshot = &statsSnapshot{Stats: stats, AggregatedAt: time.Now()}
+	}
+
+	resp := struct {
+		database.Stats
+		AggregatedAt time.Time           `json:"aggregated_at"`
+		Uptime       string              `json:"uptime"`
+		Database     string              `json:"database"`
+		Cache        database.CacheStats `json:"cache"`
+	}{snapshot.Stats, snapshot.AggregatedAt.UTC(), time.Since(s.startedAt).Round(time.Second).String(), s.db.Health(r.Context())["status"], s.db.CacheStats()}
+
+	jsonResp, _ := json.Marshal(resp)
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminUsersHandler lists all users with their usage, or the ones of the
+// namespace given with ?namespace=.
+func (s *Server) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	usages, err := s.db.UserUsages(r.Context())
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if name := r.URL.Query().Get("namespace"); name != "" {
+		usages = slices.DeleteFunc(usages, func(u database.UserUsage) bool { return u.Namespace != name })
+	}
+
+	jsonResp, _ := json.Marshal(usages)
+	_, _ = w.Write(jsonResp)
+}
+
+// roleMessage describes the valid roles of users.
+const roleMessage = "role must be one of: " + account.RoleAdmin + ", " + account.RoleUser + ", " + account.RoleReadOnly
+
+// AdminCreateUserHandler creates a user with the role of the body, or the
+// user role, in the namespace of the body, or the default namespace. Users
+// created this way can log in once they have an API key.
+func (s *Server) AdminCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name      string `json:"name"`
+		Role      string `json:"role"`
+		Namespace string `json:"namespace"`
+	}
+	if !s.decodeBody(w, r, &body, "name", "role", "namespace") {
+		return
+	}
+	if body.Role == "" {
+		body.Role = account.RoleUser
+	}
+	if body.Namespace == "" {
+		body.Namespace = account.DefaultNamespace
+	}
+	var errs validation.Errors
+	if strings.TrimSpace(body.Name) == "" {
+		errs.Add("name", validation.CodeRequired, "name is required")
+	}
+	if !account.ValidRole(body.Role) {
+		errs.Add("role", validation.CodeInvalid, roleMessage)
+	}
+	if _, ok := s.namespaces[body.Namespace]; !ok {
+		errs.Add("namespace", validation.CodeInvalid, "namespace must be one of: "+strings.Join(s.namespaceNames(), ", "))
+	}
+	if len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	existing, err := s.db.UserByName(r.Context(), body.Namespace, body.Name)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		validation.Error(w, "user already exists", http.StatusConflict)
+		return
+	}
+
+	u, err := s.db.EnsureUser(r.Context(), body.Namespace, body.Name)
+	if err == nil && u.Role != body.Role {
+		err = s.db.SetRole(r.Context(), u.Id, body.Role)
+		u.Role = body.Role
+	}
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(u)
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminUpdateUserHandler changes the role of a user of the namespace given
+// with ?namespace=. Administrators cannot change their own role, so there is
+// always one left.
+func (s *Server) AdminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if !s.decodeBody(w, r, &body, "role") {
+		return
+	}
+	if !account.ValidRole(body.Role) {
+		var errs validation.Errors
+		errs.Add("role", validation.CodeInvalid, roleMessage)
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	u, err := s.db.UserByName(r.Context(), adminNamespace(r), chi.URLParam(r, "user"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if u.Id == currentUserId(r) && body.Role != u.Role {
+		validation.Error(w, "administrators cannot change their own role", http.StatusConflict)
+		return
+	}
+
+	if err := s.db.SetRole(r.Context(), u.Id, body.Role); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	u.Role = body.Role
+
+	jsonResp, _ := json.Marshal(u)
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminClipboardsHandler lists the clipboards of all users, or of the user
+// given with ?owner=, without their data. ?namespace= restricts the list to
+// a namespace and is the one owners are looked up in.
+func (s *Server) AdminClipboardsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultListLimit, maxListLimit)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, math.MaxInt)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := database.ListOptions{AllOwners: true, Namespace: r.URL.Query().Get("namespace"), Limit: limit, Offset: offset}
+	if name := r.URL.Query().Get("owner"); name != "" {
+		u, err := s.db.UserByName(r.Context(), adminNamespace(r), name)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if u == nil {
+			validation.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		opts.AllOwners, opts.OwnerId = false, u.Id
+	}
+
+	cs, err := s.db.List(r.Context(), opts)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range cs {
+		c.Data = ""
+	}
+
+	jsonResp, _ := json.Marshal(cs)
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminSecurityEventsHandler lists failed attempts on encrypted clipboards
+// across the server, newest first: wrong or missing passwords, attempts
+// refused while locked out and data failing to decrypt. They can be
+// filtered with ?outcome=, which may be repeated, ?clipboard_id=, ?ip=, and
+// ?since= and ?until= in RFC 3339 format, and are paged with ?limit= and
+// ?offset=.
+func (s *Server) AdminSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	opts := database.SecurityEventOptions{Outcomes: queryList(r, "outcome"), IP: r.URL.Query().Get("ip")}
+	for _, o := range opts.Outcomes {
+		if !slices.Contains(clipboard.SecurityOutcomes, o) {
+			validation.Error(w, "invalid outcome: must be one of "+strings.Join(clipboard.SecurityOutcomes, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.IP != "" {
+		if _, err := netip.ParseAddr(opts.IP); err != nil {
+			validation.Error(w, "invalid ip", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var err error
+	if opts.Limit, err = queryInt(r, "limit", defaultAuditLimit, maxAuditLimit); err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Offset, err = queryInt(r, "offset", 0, math.MaxInt); err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.ClipboardId, err = queryInt(r, "clipboard_id", 0, math.MaxInt); err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Since, err = queryTime(r, "since"); err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Until, err = queryTime(r, "until"); err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.db.SecurityEvents(r.Context(), opts)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(events)
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminPurgeHandler deletes a clipboard regardless of its owner and password.
+func (s *Server) AdminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	if err := s.db.Delete(r.Context(), c.Id); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	s.publish(events.ClipboardDeleted, c)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminPurgeUserHandler deletes all clipboards owned by a user of the
+// namespace given with ?namespace=.
+func (s *Server) AdminPurgeUserHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := s.db.UserByName(r.Context(), adminNamespace(r), chi.URLParam(r, "user"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	ids, err := s.db.OwnedClipboards(r.Context(), u.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range ids {
+		if err := s.db.Delete(r.Context(), id); err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		s.publish(events.ClipboardDeleted, &clipboard.Clipboard{Id: id, OwnerId: u.Id, Namespace: u.Namespace})
+	}
+
+	jsonResp, _ := json.Marshal(map[string]int{"deleted": len(ids)})
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminLockHandler locks a clipboard with POST and unlocks it with DELETE.
+// Locked clipboards cannot be read, changed or deleted by anyone but
+// administrators.
+func (s *Server) AdminLockHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		validation.Error(w, "invalid clipboard id", http.StatusBadRequest)
+		return
+	}
+
+	found, err := s.db.SetLocked(r.Context(), id, r.Method == http.MethodPost)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		validation.Error(w, "clipboard not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminConfigHandler shows the effective configuration, with secrets redacted.
+func (s *Server) AdminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResp, _ := json.Marshal(env.Snapshot())
+	_, _ = w.Write(jsonResp)
+}
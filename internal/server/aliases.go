@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type aliasBody struct {
+	Name string `json:"name"`
+}
+
+// AliasesHandler lists the aliases of a clipboard.
+func (s *Server) AliasesHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	if !s.authorize(w, r, c, clipboard.ActionRead) {
+		return
+	}
+
+	as, err := s.db.Aliases(r.Context(), c.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(as)
+	_, _ = w.Write(jsonResp)
+}
+
+// SetAliasHandler points an alias at a clipboard. New aliases are created
+// with 201; existing ones are moved from the clipboard they pointed at,
+// which the user must be allowed to update as well.
+func (s *Server) SetAliasHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	var body aliasBody
+	if !s.decodeBody(w, r, &body, "name") {
+		return
+	}
+	name, err := clipboard.NormalizeAlias(body.Name)
+	if err != nil {
+		var errs validation.Errors
+		errs.Add("name", validation.CodeInvalid, err.Error())
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+
+	existing, err := s.db.AliasByName(r.Context(), c.Namespace, name)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && existing.ClipboardId == c.Id {
+		jsonResp, _ := json.Marshal(existing)
+		_, _ = w.Write(jsonResp)
+		return
+	}
+
+	var previous int
+	if existing != nil {
+		previous = existing.ClipboardId
+		old, err := s.db.Get(r.Context(), previous)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if old != nil && !s.authorize(w, r, old, clipboard.ActionUpdate) {
+			return
+		}
+	}
+
+	a := &clipboard.Alias{Name: name, ClipboardId: c.Id, Namespace: c.Namespace}
+	err = s.db.SetAlias(r.Context(), a, previous)
+	if err == database.ErrNameTaken {
+		validation.Error(w, "alias was changed concurrently", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	if previous != 0 {
+		s.logAccess(r, previous, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	}
+
+	if existing == nil {
+		w.WriteHeader(http.StatusCreated)
+	}
+	jsonResp, _ := json.Marshal(a)
+	_, _ = w.Write(jsonResp)
+}
+
+// DeleteAliasHandler deletes an alias of a clipboard.
+func (s *Server) DeleteAliasHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+
+	ok, err := s.db.DeleteAlias(r.Context(), c.Id, chi.URLParam(r, "name"))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		validation.Error(w, "alias not found", http.StatusNotFound)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadAliased retrieves the clipboard an alias in the namespace of the
+// request points at, or nil if there is no such alias.
+func (s *Server) loadAliased(r *http.Request, name string) (*clipboard.Clipboard, error) {
+	ctx, span := telemetry.Start(r.Context(), "db.AliasByName")
+	a, err := s.db.AliasByName(ctx, currentNamespace(r), name)
+	telemetry.End(span, err)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span = telemetry.Start(r.Context(), "db.Get")
+	c, err := s.db.Get(ctx, a.ClipboardId)
+	telemetry.End(span, err)
+	return c, err
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+	tokenContextKey
+	namespaceContextKey
+	adminContextKey
+	totpContextKey
+	clientIPContextKey
+	peerContextKey
+)
+
+// identify resolves the user behind the API key or access token of the
+// request and stores it in the request context. Requests without either are
+// anonymous, as are requests made with a clipboard token.
+// API keys, access tokens and clipboard tokens are passed as a Bearer token,
+// API keys and clipboard tokens also in the X-API-Key header, since Basic
+// Auth carries clipboard passwords.
+// Requests without either from a WireGuard peer are made by its user, see
+// wireguardAuth.
+func (s *Server) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKey(r)
+		if key == "" {
+			if p := s.wireguard.peer(s.clientIP(r)); p != nil {
+				s.servePeer(w, r, p, next)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if account.IsJWT(key) {
+			s.identifySession(w, r, key, next)
+			return
+		}
+		if clipboard.IsToken(key) {
+			s.identifyToken(w, r, key, next)
+			return
+		}
+
+		u, ok := s.keys[account.HashKey(key)]
+		if !ok {
+			validation.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		s.serveAs(w, r, u, next)
+	})
+}
+
+// identifySession validates an access token and checks that its session has
+// not been revoked before serving the request as its user.
+func (s *Server) identifySession(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	claims, err := s.tokens.Verify(token)
+	if err != nil {
+		validation.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	sess, err := s.db.GetSession(r.Context(), claims.SessionId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if sess == nil || sess.UserId != claims.UserId() || !sess.Active(time.Now()) {
+		validation.Error(w, "session expired or revoked", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), sessionContextKey, sess.Id)
+	if sess.TOTPVerified {
+		ctx = context.WithValue(ctx, totpContextKey, true)
+	}
+	u := &account.User{Id: claims.UserId(), Name: claims.Name}
+	s.serveAs(w, r.WithContext(ctx), u, next)
+}
+
+// serveAs serves the request as a user with their current role, which
+// administrators may change at any time, in their namespace.
+func (s *Server) serveAs(w http.ResponseWriter, r *http.Request, u *account.User, next http.Handler) {
+	stored, err := s.db.User(r.Context(), u.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	withRole := *u
+	if stored != nil {
+		withRole.Role = stored.Role
+		withRole.Namespace = stored.Namespace
+		withRole.TOTPEnabled = stored.TOTPEnabled
+	}
+	if !checkNamespace(w, r, &withRole) {
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, &withRole)))
+}
+
+// apiKey returns the API key sent with the request, if any.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+// currentUser returns the user that sent the request, or nil for anonymous requests.
+func currentUser(r *http.Request) *account.User {
+	u, _ := r.Context().Value(userContextKey).(*account.User)
+	return u
+}
+
+// currentSessionId returns the id of the session of the access token of the
+// request, or "" for requests authenticated with an API key or anonymous.
+func currentSessionId(r *http.Request) string {
+	id, _ := r.Context().Value(sessionContextKey).(string)
+	return id
+}
+
+// currentUserId returns the id of the user that sent the request, or 0 for
+// anonymous requests.
+func currentUserId(r *http.Request) int {
+	if u := currentUser(r); u != nil {
+		return u.Id
+	}
+	return 0
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/backup"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// Strategies for imported clipboards whose id is taken.
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictRenumber  = "renumber"
+)
+
+// ExportHandler writes an archive of all clipboards, as JSON or, with
+// ?format=tar, as a tar archive. Encrypted clipboards are only included with
+// ?encrypted=true, still encrypted with their passwords. Stack items,
+// permissions and access logs are not exported.
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = backup.FormatJSON
+	}
+	includeEncrypted, _ := strconv.ParseBool(r.URL.Query().Get("encrypted"))
+
+	aw, err := backup.NewWriter(w, format)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := "application/json"
+	if format == backup.FormatTar {
+		contentType = "application/x-tar"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "copybridge-" + time.Now().UTC().Format("20060102-150405") + "." + format,
+	}))
+	s.extendDeadlines(w)
+
+	// Once the archive has started, errors can only cut it short, which
+	// makes it fail to import.
+	if err := s.export(r.Context(), aw, includeEncrypted); err != nil {
+		log.Printf("error exporting clipboards: %v", err)
+	}
+}
+
+// export writes all clipboards to an archive and closes it.
+func (s *Server) export(ctx context.Context, aw backup.Writer, includeEncrypted bool) error {
+	owners := make(map[int]string)
+	for offset := 0; ; offset += maxListLimit {
+		cs, err := s.db.List(ctx, database.ListOptions{AllOwners: true, Limit: maxListLimit, Offset: offset})
+		if err != nil {
+			return err
+		}
+
+		for _, c := range cs {
+			if c.IsEncrypted && !includeEncrypted {
+				continue
+			}
+			rec, err := s.exportRecord(ctx, c, owners)
+			if err == nil {
+				err = aw.Write(rec)
+			}
+			if err != nil {
+				return fmt.Errorf("clipboard %d: %w", c.Id, err)
+			}
+		}
+
+		if len(cs) < maxListLimit {
+			break
+		}
+	}
+
+	return aw.Close()
+}
+
+// exportRecord converts a clipboard into an archive record, reading its
+// data from the blob store if it is streamed. owners caches the names of
+// owners by id.
+func (s *Server) exportRecord(ctx context.Context, c *clipboard.Clipboard, owners map[int]string) (*backup.Record, error) {
+	rec := &backup.Record{
+		Id:                 c.Id,
+		PublicId:           c.PublicId,
+		Code:               c.Code,
+		Name:               c.Name,
+		DataType:           c.DataType,
+		Filename:           c.Filename,
+		ContentDisposition: c.ContentDisposition,
+		Charset:            c.Charset,
+		Locale:             c.Locale,
+		IsEncrypted:        c.IsEncrypted,
+		PasswordHash:       c.PasswordHash,
+		Salt:               c.Salt,
+		Nonce:              c.Nonce,
+		KDF:                c.KDF,
+		EncryptionScope:    c.EncryptionScope,
+		SealedFields:       c.SealedFields,
+		WrappedKey:         c.WrappedKey,
+		Quarantine:         c.Quarantine,
+		PublishAt:          c.PublishAt,
+		ExpiresAt:          c.ExpiresAt,
+		Streamed:           c.Streamed,
+		Version:            c.Version,
+		Tags:               c.Tags,
+		Pinned:             c.Pinned,
+		ReadOnly:           c.ReadOnly,
+		Transforms:         c.Transforms,
+		CreatedAt:          c.CreatedAt,
+		UpdatedAt:          c.UpdatedAt,
+	}
+	for _, f := range c.Flavors {
+		rec.Flavors = append(rec.Flavors, backup.Flavor{DataType: f.DataType, Nonce: f.Nonce, Data: []byte(f.Data)})
+	}
+
+	if c.OwnerId != 0 {
+		name, ok := owners[c.OwnerId]
+		if !ok {
+			u, err := s.db.User(ctx, c.OwnerId)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return nil, err
+			}
+			if u != nil {
+				name = u.Name
+			}
+			owners[c.OwnerId] = name
+		}
+		rec.Owner = name
+	}
+	if c.Namespace != account.DefaultNamespace {
+		rec.Namespace = c.Namespace
+	}
+
+	data, err := s.db.OpenData(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	if rec.Data, err = io.ReadAll(data); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// ImportHandler restores the clipboards of an archive written by
+// ExportHandler. Tar archives are recognized by their Content-Type of
+// application/x-tar. Clipboards whose id is taken are skipped, replaced
+// with ?conflict=overwrite, or given a new id with ?conflict=renumber.
+// Owners are matched by name and created if they do not exist.
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	conflict := r.URL.Query().Get("conflict")
+	switch conflict {
+	case "":
+		conflict = conflictSkip
+	case conflictSkip, conflictOverwrite, conflictRenumber:
+	default:
+		validation.Error(w, "invalid conflict strategy", http.StatusBadRequest)
+		return
+	}
+
+	format := backup.FormatJSON
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-tar" {
+		format = backup.FormatTar
+	}
+	s.extendDeadlines(w)
+
+	result := struct {
+		Imported   int            `json:"imported"`
+		Skipped    []int          `json:"skipped"`
+		Renumbered map[string]int `json:"renumbered"`
+	}{Skipped: []int{}, Renumbered: map[string]int{}}
+
+	var dbErr error
+	err := backup.Read(r.Body, format, func(rec *backup.Record) error {
+		c, err := importClipboard(rec)
+		if err != nil {
+			return err
+		}
+		if rec.Owner != "" {
+			u, err := s.db.EnsureUser(r.Context(), c.Namespace, rec.Owner)
+			if err != nil {
+				dbErr = err
+				return err
+			}
+			c.OwnerId = u.Id
+		}
+
+		existing, err := s.db.Get(r.Context(), c.Id)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			dbErr = err
+			return err
+		}
+		if existing != nil {
+			switch conflict {
+			case conflictSkip:
+				result.Skipped = append(result.Skipped, c.Id)
+				return nil
+			case conflictOverwrite:
+				if dbErr = s.db.Delete(r.Context(), c.Id); dbErr != nil {
+					return dbErr
+				}
+				s.publish(events.ClipboardDeleted, existing)
+			case conflictRenumber:
+				c.Id = 0
+			}
+		}
+
+		var data io.Reader
+		if rec.Streamed {
+			data = bytes.NewReader(rec.Data)
+		}
+		if dbErr = s.db.Restore(r.Context(), c, data); dbErr != nil {
+			return dbErr
+		}
+		if c.Id != rec.Id {
+			result.Renumbered[strconv.Itoa(rec.Id)] = c.Id
+		}
+		result.Imported++
+		s.publish(events.ClipboardCreated, c)
+
+		return nil
+	})
+	if dbErr != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		validation.Error(w, "invalid archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(result)
+	_, _ = w.Write(jsonResp)
+}
+
+// importClipboard converts an archive record into a clipboard without an
+// owner. Missing timestamps are set to the current time.
+func importClipboard(rec *backup.Record) (*clipboard.Clipboard, error) {
+	c := &clipboard.Clipboard{
+		Id:                 rec.Id,
+		PublicId:           rec.PublicId,
+		Code:               rec.Code,
+		Name:               rec.Name,
+		DataType:           rec.DataType,
+		Data:               string(rec.Data),
+		Filename:           rec.Filename,
+		ContentDisposition: rec.ContentDisposition,
+		Charset:            rec.Charset,
+		Locale:             rec.Locale,
+		IsEncrypted:        rec.IsEncrypted,
+		PasswordHash:       rec.PasswordHash,
+		Salt:               rec.Salt,
+		Nonce:              rec.Nonce,
+		KDF:                rec.KDF,
+		EncryptionScope:    rec.EncryptionScope,
+		SealedFields:       rec.SealedFields,
+		WrappedKey:         rec.WrappedKey,
+		Quarantine:         rec.Quarantine,
+		PublishAt:          rec.PublishAt,
+		ExpiresAt:          rec.ExpiresAt,
+		Version:            rec.Version,
+		Tags:               rec.Tags,
+		Pinned:             rec.Pinned,
+		ReadOnly:           rec.ReadOnly,
+		Transforms:         rec.Transforms,
+		CreatedAt:          rec.CreatedAt,
+		UpdatedAt:          rec.UpdatedAt,
+		Namespace:          rec.Namespace,
+	}
+	if c.Namespace == "" {
+		c.Namespace = account.DefaultNamespace
+	} else if !account.ValidNamespace(c.Namespace) {
+		return nil, fmt.Errorf("invalid namespace %q", c.Namespace)
+	}
+	for _, f := range rec.Flavors {
+		c.Flavors = append(c.Flavors, clipboard.Flavor{DataType: f.DataType, Nonce: f.Nonce, Data: string(f.Data)})
+	}
+
+	tags, err := clipboard.NormalizeTags(c.Tags)
+	if err != nil {
+		return nil, err
+	}
+	c.Tags = tags
+	if c.Transforms, err = clipboard.NormalizeTransforms(c.Transforms); err != nil {
+		return nil, err
+	}
+	if err := c.CheckFlavors(); err != nil {
+		return nil, err
+	}
+	if err := clipboard.CheckFilename(c.Filename); err != nil {
+		return nil, err
+	}
+	if err := clipboard.CheckDisposition(c.ContentDisposition); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = now
+	}
+	if c.UpdatedAt.IsZero() {
+		c.UpdatedAt = c.CreatedAt
+	}
+
+	return c, nil
+}
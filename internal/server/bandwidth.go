@@ -0,0 +1,95 @@
+package server
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/bandwidth"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// meterBandwidth enforces the daily bandwidth quota of the namespace, see
+// QUOTA_MAX_BANDWIDTH. The bodies of requests and responses, before
+// compression, count towards the quota of the user, the clipboard token or
+// the client IP of anonymous requests. Responses carry the limit, the bytes
+// remaining and the seconds until the quota resets at midnight UTC in
+// X-RateLimit-Bytes-* headers. Once the quota is used up, and for requests
+// announcing more data than remains, the server responds with 429 until it
+// resets. A request in progress is never cut off, so the last one of a day
+// may exceed the quota.
+// Health checks and administration are not metered. Each process sharing
+// a database file meters the requests it serves.
+func (s *Server) meterBandwidth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.quotaOf(currentNamespace(r)).MaxBandwidth
+		if limit == 0 || unmetered(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, now := s.bandwidthKey(r), time.Now()
+		remaining := max(limit-s.bandwidth.Used(key, now), 0)
+		reset := bandwidth.Reset(now).Sub(now)
+		w.Header().Set("X-RateLimit-Bytes-Limit", strconv.FormatInt(limit, 10))
+		w.Header().Set("X-RateLimit-Bytes-Remaining", strconv.FormatInt(remaining, 10))
+		w.Header().Set("X-RateLimit-Bytes-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if remaining == 0 || r.ContentLength > remaining {
+			bandwidthRejections.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			validation.Error(w, "bandwidth quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		s.bandwidth.Add(key, body.n+int64(ww.BytesWritten()), time.Now())
+		bandwidthBytes.Add(float64(body.n), "in")
+		bandwidthBytes.Add(float64(ww.BytesWritten()), "out")
+	})
+}
+
+// bandwidthKey returns the key requests are metered by: the user, the
+// clipboard token or the client IP, within the namespace of the request.
+func (s *Server) bandwidthKey(r *http.Request) string {
+	key := "ip:" + s.clientIP(r)
+	if id := currentUserId(r); id != 0 {
+		key = "user:" + strconv.Itoa(id)
+	} else if t := currentToken(r); t != nil {
+		key = "token:" + t.Id
+	}
+	return currentNamespace(r) + "/" + key
+}
+
+// unmetered reports whether requests to path are exempt from bandwidth
+// quotas, so monitoring and administration keep working.
+func unmetered(path string) bool {
+	switch path {
+	case "/healthz", "/readyz", "/health":
+		return true
+	}
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// StartBlobUploadHandler hands out a presigned URL to upload the data of a
+// clipboard to the blob store directly, so large payloads bypass the
+// server. The body holds the size of the data and, optionally, its type,
+// which default to the type of the clipboard. The data replaces the data of
+// the clipboard once the upload is committed.
+// Encrypted clipboards are encrypted by the server and cannot be uploaded
+// directly.
+func (s *Server) StartBlobUploadHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if !s.authorize(w, r, c, clipboard.ActionUpdate) || !checkWritable(w, c) || !checkVersion(w, r, c) {
+		return
+	}
+	if s.presignTTL <= 0 {
+		validation.Error(w, database.ErrDirectUploads.Error(), http.StatusNotImplemented)
+		return
+	}
+	if c.IsEncrypted {
+		validation.Error(w, "encrypted clipboards cannot be uploaded directly", http.StatusConflict)
+		return
+	}
+	// The content scanner would never see the data.
+	if s.scan.Enabled() {
+		validation.Error(w, "direct uploads are disabled while content scanning is enabled", http.StatusConflict)
+		return
+	}
+
+	var body struct {
+		DataType string `json:"type"`
+		Size     int64  `json:"size"`
+	}
+	if !s.decodeBody(w, r, &body, "type", "size") {
+		return
+	}
+	if body.Size <= 0 {
+		var errs validation.Errors
+		errs.Add("size", validation.CodeRequired, "size is required")
+		validation.WriteErrors(w, errs)
+		return
+	}
+	if body.DataType == "" {
+		body.DataType = c.DataType
+	}
+	// The data never passes through the server, so it cannot be sniffed.
+	if !s.checkType(w, body.DataType, "") {
+		return
+	}
+
+	limit, limitErr, err := s.streamLimit(r.Context(), c)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if limitErr != nil && body.Size > limit {
+		validation.Error(w, limitErr.Error(), limitStatus(limitErr))
+		return
+	}
+
+	if _, err := s.db.DeleteExpiredBlobUploads(r.Context(), time.Now()); err != nil {
+		log.Printf("error deleting expired direct uploads: %v", err)
+	}
+
+	u := &clipboard.BlobUpload{
+		ClipboardId: c.Id,
+		Version:     c.Version,
+		DataType:    body.DataType,
+		Size:        body.Size,
+		ExpiresAt:   time.Now().UTC().Add(s.uploadExpiry),
+	}
+	ctx, span := telemetry.Start(r.Context(), "db.CreateBlobUpload")
+	err = s.db.CreateBlobUpload(ctx, u, s.presignTTL)
+	telemetry.End(span, err)
+	if err == database.ErrDirectUploads {
+		validation.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(u)
+	_, _ = w.Write(jsonResp)
+}
+
+// CommitBlobUploadHandler replaces the data of a clipboard with the data
+// uploaded directly to the blob store, once the client has finished the
+// upload. The clipboard records the type and size of the upload.
+func (s *Server) CommitBlobUploadHandler(w http.ResponseWriter, r *http.Request) {
+	c, u := s.loadBlobUpload(w, r)
+	if u == nil || !checkWritable(w, c) {
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.CommitBlobUpload")
+	err := s.db.CommitBlobUpload(ctx, c, u)
+	telemetry.End(span, err)
+	switch {
+	case errors.Is(err, database.ErrDirectUploads):
+		validation.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		databaseError(w, err)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publish(events.ClipboardUpdated, c)
+
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(c)
+	_, _ = w.Write(jsonResp)
+}
+
+// AbortBlobUploadHandler discards a direct upload and the data uploaded so
+// far.
+func (s *Server) AbortBlobUploadHandler(w http.ResponseWriter, r *http.Request) {
+	_, u := s.loadBlobUpload(w, r)
+	if u == nil {
+		return
+	}
+
+	if err := s.db.DeleteBlobUpload(r.Context(), u); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadBlobUpload retrieves the clipboard of the request and its direct
+// upload identified by the uploadId URL parameter, checking that the
+// request may update the clipboard.
+// If either cannot be retrieved, it writes an error response and returns a
+// nil upload.
+func (s *Server) loadBlobUpload(w http.ResponseWriter, r *http.Request) (*clipboard.Clipboard, *clipboard.BlobUpload) {
+	c := s.loadClipboard(w, r)
+	if c == nil || !s.authorize(w, r, c, clipboard.ActionUpdate) {
+		return nil, nil
+	}
+
+	u, err := s.db.GetBlobUpload(r.Context(), chi.URLParam(r, "uploadId"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil, nil
+	}
+	if u == nil || u.ClipboardId != c.Id {
+		validation.Error(w, "upload not found", http.StatusNotFound)
+		return nil, nil
+	}
+
+	return c, u
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// changesResponse holds a page of the changefeed. Seq is the sequence
+// number to ask for changes since next, which is past changes the user
+// does not see, so clients never scan them twice.
+type changesResponse struct {
+	Changes []clipboard.Change `json:"changes"`
+	Seq     int64              `json:"seq"`
+	More    bool               `json:"more,omitempty"`
+}
+
+// ChangesHandler returns the changes to the clipboards of the user after
+// the sequence number in ?since=, oldest first, so clients coming back
+// online can catch up without listing all clipboards. Clients whose
+// sequence number is older than the changes kept, or newer than the last
+// change, as after restoring the database, get 410 and list again.
+// Without ?since=, only the current sequence number is returned, which
+// clients take before listing their clipboards.
+func (s *Server) ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := queryInt(r, "since", 0, math.MaxInt)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultListLimit, maxListLimit)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.ChangeBounds")
+	pruned, last, err := s.db.ChangeBounds(ctx)
+	telemetry.End(span, err)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("since") == "" {
+		jsonResp, _ := json.Marshal(changesResponse{Changes: []clipboard.Change{}, Seq: last})
+		_, _ = w.Write(jsonResp)
+		return
+	}
+	if int64(since) < pruned || int64(since) > last {
+		validation.Error(w, "changes are no longer available, list clipboards again", http.StatusGone)
+		return
+	}
+
+	ctx, span = telemetry.Start(r.Context(), "db.Changes")
+	changes, err := s.db.Changes(ctx, database.ChangeOptions{
+		Since:     int64(since),
+		Until:     last,
+		OwnerId:   currentUserId(r),
+		Namespace: currentNamespace(r),
+		Limit:     limit,
+	})
+	telemetry.End(span, err)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	// A full page may be followed by more changes, which the next page
+	// starts after.
+	resp := changesResponse{Changes: changes, Seq: last}
+	if len(changes) == limit {
+		resp.Seq = int64(since)
+		if limit > 0 {
+			resp.Seq = changes[limit-1].Seq
+		}
+		resp.More = resp.Seq < last
+	}
+
+	jsonResp, _ := json.Marshal(resp)
+	_, _ = w.Write(jsonResp)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// ClearHandler empties a clipboard, for clients that shared something
+// sensitive and want to keep the clipboard they are set up with: its data,
+// flavors, filename, charset, metadata, stack items, revisions and conflict
+// copies are deleted, while its name, type, tags, permissions, tokens and
+// aliases are kept. Encrypted clipboards stay encrypted with the same
+// password. With ?secure=true, the deleted data is overwritten where it was
+// stored, see database.Service.Clear.
+// Clearing needs write access like updating, and responds with the cleared
+// clipboard.
+func (s *Server) ClearHandler(w http.ResponseWriter, r *http.Request) {
+	var secure bool
+	if v := r.URL.Query().Get("secure"); v != "" {
+		var err error
+		if secure, err = strconv.ParseBool(v); err != nil {
+			validation.Error(w, "secure must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionUpdate)
+	if !ok || !checkWritable(w, c) || !s.openFields(w, r, c, password) {
+		return
+	}
+
+	oldSize := c.Size
+	c.Data = ""
+	c.Flavors = nil
+	c.Filename = ""
+	c.Charset = ""
+	c.Metadata = nil
+	c.Quarantine = ""
+	if !s.sealUpdate(w, r, c, password, oldSize) {
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.Clear")
+	err := s.db.Clear(ctx, c, secure)
+	telemetry.End(span, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publish(events.ClipboardUpdated, c)
+
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(c)
+	_, _ = w.Write(jsonResp)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// resolveClientIP resolves the IP address of the client behind the request
+// once, so that IP filtering, lockouts, bandwidth quotas and access logs all
+// see the same address, see clientIP. Requests from trusted proxies get
+// their RemoteAddr replaced as well, so request logs show the client and
+// replicas forward its address to the primary.
+func (s *Server) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.forwardedIP(r)
+		if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil && host != ip {
+			r.RemoteAddr = net.JoinHostPort(ip, port)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPContextKey, ip)))
+	})
+}
+
+// clientIP returns the IP address of the client that sent the request, as
+// resolved by resolveClientIP.
+func (s *Server) clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	return s.forwardedIP(r)
+}
+
+// forwardedIP returns the IP address of the client that sent the request.
+// Requests from trusted proxies, such as reverse proxies and replicas, are
+// attributed to the last address in X-Forwarded-For that is not a trusted
+// proxy itself, or to X-Real-IP if they do not set X-Forwarded-For. Hops
+// that are not IP addresses end the search, since whatever precedes them
+// cannot be trusted either.
+func (s *Server) forwardedIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	if len(hops) == 1 && strings.TrimSpace(hops[0]) == "" {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+			return ip
+		}
+		return host
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// CodeHandler resolves the share code of a clipboard, redirecting to the
+// clipboard by its public id, or to the web UI for browsers. Codes can be
+// guessed like numeric ids, so they follow SEQUENTIAL_IDS, and clients
+// trying too many unknown codes are locked out.
+func (s *Server) CodeHandler(w http.ResponseWriter, r *http.Request) {
+	ip := s.clientIP(r)
+	if wait := s.codeFailures.Locked(ip); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	code := strings.ToLower(chi.URLParam(r, "code"))
+	if !clipboard.IsCode(code) {
+		validation.Error(w, "invalid share code", http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.GetByCode")
+	c, err := s.db.GetByCode(ctx, code)
+	telemetry.End(span, err)
+	if errors.Is(err, database.ErrNotFound) {
+		c, err = nil, nil
+	}
+	if c != nil && !inNamespace(r, c) {
+		c = nil
+	}
+	if err == nil && c != nil {
+		var ok bool
+		if ok, err = s.numericAccess(r, c); !ok {
+			c = nil
+		}
+	}
+	if err == nil && c != nil {
+		var hidden bool
+		if hidden, err = s.hidden(r, c); hidden {
+			c = nil
+		}
+	}
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	if c == nil {
+		if wait := s.codeFailures.Fail(ip); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+		validation.Error(w, "clipboard not found", http.StatusNotFound)
+		return
+	}
+	s.codeFailures.Succeed(ip)
+
+	target := s.path(namespacePath(c.Namespace) + "/clipboard/" + c.PublicId)
+	if s.uiEnabled && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		target = s.path("/ui?id=" + url.QueryEscape(c.PublicId))
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// compressionConfig configures gzip compression of requests and responses.
+type compressionConfig struct {
+	// enabled compresses responses for clients accepting gzip.
+	enabled bool
+	// minSize is the smallest response body that is compressed, as small
+	// bodies barely shrink.
+	minSize int
+	// maxRequestSize bounds gzip request bodies once decompressed, so a
+	// small request cannot expand without limit.
+	maxRequestSize int64
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// compress decompresses request bodies sent with Content-Encoding: gzip and
+// compresses JSON and text responses of at least compressionConfig.minSize
+// for clients accepting gzip. Responses that are encoded already, event
+// streams and WebSocket upgrades are passed through.
+func (s *Server) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				validation.Error(w, "invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			defer body.Close()
+			r.Body = http.MaxBytesReader(w, body, s.compression.maxRequestSize)
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			w.Header().Set("Accept-Encoding", "gzip")
+			validation.Error(w, "unsupported content encoding "+encoding, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if !s.compression.enabled || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: s.compression.minSize, status: http.StatusOK}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		return q > 0
+	}
+	return false
+}
+
+// compressible reports whether responses of a content type are worth
+// compressing.
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows
+// whether the response is worth compressing.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.decided || status < http.StatusOK {
+		g.ResponseWriter.WriteHeader(status)
+		return
+	}
+	g.status = status
+	// Responses without a body are sent right away.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		g.decide()
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.decided {
+		g.buf = append(g.buf, p...)
+		if len(g.buf) < g.minSize {
+			return len(p), nil
+		}
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing the response if it is compressible
+// and the buffered start of it reached minSize, and writes out the buffer.
+func (g *gzipResponseWriter) decide() error {
+	g.decided = true
+	h := g.ResponseWriter.Header()
+	// Sniff the type like net/http would, as most handlers leave it to it.
+	if _, ok := h["Content-Type"]; !ok && len(g.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	if len(g.buf) > 0 && len(g.buf) >= g.minSize && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) &&
+		g.status != http.StatusNoContent && g.status != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was written so far, deciding on compression early if
+// needed.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.decide()
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	_ = http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to extend deadlines.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// close finishes the response.
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		g.decide()
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+		g.gz = nil
+	}
+}
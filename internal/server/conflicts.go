@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/merge"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mergeMode returns the merge mode requested with ?merge=, or "" if updates
+// based on an outdated version are to be refused.
+// If the mode is invalid, it writes an error response and returns false.
+func mergeMode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mode := r.URL.Query().Get("merge")
+	if mode != "" && !clipboard.ValidMerge(mode) {
+		validation.Error(w, "invalid merge: must be "+clipboard.MergeThreeWay+" or "+clipboard.MergeLastWriterWins, http.StatusBadRequest)
+		return "", false
+	}
+	return mode, true
+}
+
+// outdatedVersion returns the version named by the If-Match header of the
+// request if it is a single version older than the current version of the
+// clipboard.
+func outdatedVersion(r *http.Request, c *clipboard.Clipboard) (int, bool) {
+	values := r.Header.Values("If-Match")
+	if len(values) != 1 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimSpace(values[0]), `"`))
+	if err != nil || version <= 0 || version >= c.Version {
+		return 0, false
+	}
+	return version, true
+}
+
+// mergeUpdate resolves an update c based on the outdated version base of a
+// clipboard whose data is now that of current. Three-way merges combine the
+// changes of both; if they overlap, or with last-writer-wins, the update is
+// applied as is and the current data returned as a conflict copy to keep.
+// The X-Merge header of the response tells which happened.
+// It returns a nil conflict if the changes were merged into c.
+func (s *Server) mergeUpdate(ctx context.Context, w http.ResponseWriter, c, current *clipboard.Clipboard, base int, mode string) (*clipboard.Conflict, error) {
+	if mode == clipboard.MergeThreeWay && current.DataType == c.DataType {
+		baseData, ok, err := s.db.Revision(ctx, c.Id, base)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if merged, ok := merge.ThreeWay(baseData, c.Data, current.Data); ok {
+				// Flavors of the update no longer match the merged data.
+				if merged != c.Data {
+					c.Flavors = nil
+				}
+				c.Data = merged
+				w.Header().Set("X-Merge", "merged")
+				return nil, nil
+			}
+		}
+	}
+
+	w.Header().Set("X-Merge", "conflict")
+	return &clipboard.Conflict{
+		Version:  current.Version,
+		DataType: current.DataType,
+		Data:     current.Data,
+	}, nil
+}
+
+// ConflictsHandler lists the conflict copies of a clipboard, newest first:
+// the data concurrent updates replaced.
+func (s *Server) ConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionRead); !ok {
+		return
+	}
+
+	conflicts, err := s.db.Conflicts(r.Context(), c.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	jsonResp, _ := json.Marshal(conflicts)
+	_, _ = w.Write(jsonResp)
+}
+
+// DeleteConflictHandler deletes a conflict copy of a clipboard once it has
+// been resolved.
+func (s *Server) DeleteConflictHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+
+	conflictId, err := strconv.Atoi(chi.URLParam(r, "conflictId"))
+	if err != nil {
+		validation.Error(w, "conflict not found", http.StatusNotFound)
+		return
+	}
+	ok, err := s.db.DeleteConflict(r.Context(), c.Id, conflictId)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		validation.Error(w, "conflict not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/convert"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/thumbnail"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// maxConvertSource is the largest data read to convert it.
+const maxConvertSource = 64 << 20
+
+// queryConversion returns the target of the as query parameter, which
+// converts the data of clipboards as they are read, or "" if it is absent.
+func queryConversion(r *http.Request) (string, error) {
+	as := r.URL.Query().Get("as")
+	if as == "" {
+		return "", nil
+	}
+	return convert.ParseTarget(as)
+}
+
+// convertClipboard converts the opened data of a clipboard to target. The
+// data or flavor already of the type of the target is preferred, and the
+// first one that can be converted used otherwise, see convert.Pick.
+// If it cannot be converted, it writes an error response and returns false.
+func (s *Server) convertClipboard(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, data io.Reader, target string) ([]byte, bool) {
+	types := c.Types()
+	i := convert.Pick(types, target)
+	if i < 0 {
+		validation.Error(w, "clipboard of type "+types[0]+" cannot be converted to "+target, http.StatusNotAcceptable)
+		return nil, false
+	}
+
+	// Flavors are never streamed and were decrypted along with the data.
+	var src []byte
+	if i > 0 {
+		src = []byte(c.Flavors[i-1].Data)
+	} else {
+		var err error
+		if src, err = io.ReadAll(io.LimitReader(data, maxConvertSource+1)); err != nil {
+			s.decryptionFailed(w, r, c)
+			return nil, false
+		}
+	}
+	if len(src) > maxConvertSource {
+		validation.Error(w, "clipboard too large to convert", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	_, span := telemetry.Start(r.Context(), "convert."+target)
+	converted, err := convert.Convert(types[i], src, target, s.thumbnailMaxPixels)
+	telemetry.End(span, err)
+	switch {
+	case errors.Is(err, convert.ErrInvalidData):
+		validation.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return nil, false
+	case err == thumbnail.ErrTooLarge:
+		validation.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	case err != nil:
+		validation.Error(w, "conversion failed", http.StatusInternalServerError)
+		return nil, false
+	}
+	return converted, true
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/blob"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// dbBackupExt is the extension of database snapshots.
+const dbBackupExt = ".db"
+
+// dbBackupConfig configures snapshots of the database file.
+type dbBackupConfig struct {
+	// dir is the directory snapshots are written to. Database backups are
+	// disabled if it is empty.
+	dir  string
+	keep int
+	// s3 uploads snapshots to a bucket if it is not nil.
+	s3 *blob.S3
+}
+
+// dbBackupFromEnv reads the DB_BACKUP_* variables. Snapshots are uploaded
+// to the bucket of S3_* under DB_BACKUP_S3_PREFIX if it is set.
+func dbBackupFromEnv() dbBackupConfig {
+	cfg := dbBackupConfig{
+		dir:  env.String("DB_BACKUP_DIR", ""),
+		keep: env.Int("DB_BACKUP_KEEP", 7),
+	}
+	if prefix := env.String("DB_BACKUP_S3_PREFIX", ""); prefix != "" {
+		if cfg.s3 = blob.S3FromEnv(); cfg.s3 != nil {
+			cfg.s3.Prefix = prefix
+		}
+	}
+	return cfg
+}
+
+// dbBackup is a snapshot of the database.
+type dbBackup struct {
+	File  string `json:"file"`
+	Size  int64  `json:"size"`
+	S3Key string `json:"s3_key,omitempty"`
+}
+
+// backupDatabase writes a snapshot of the database to DB_BACKUP_DIR,
+// uploads it to S3 if configured, and removes all but the DB_BACKUP_KEEP
+// most recent local snapshots. Uploaded snapshots are left to the
+// lifecycle rules of the bucket.
+func (s *Server) backupDatabase(ctx context.Context, now time.Time) (*dbBackup, error) {
+	cfg := s.dbBackup
+	if err := os.MkdirAll(cfg.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	// The snapshot is written under a temporary name, so a failed one is
+	// never taken for a good one.
+	name := backupPrefix + now.UTC().Format("20060102-150405") + dbBackupExt
+	tmp := filepath.Join(cfg.dir, "."+name+".tmp")
+	defer os.Remove(tmp)
+	size, err := s.db.Snapshot(ctx, tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(cfg.dir, name)); err != nil {
+		return nil, err
+	}
+	b := &dbBackup{File: name, Size: size}
+
+	if cfg.s3 != nil {
+		f, err := os.Open(filepath.Join(cfg.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := cfg.s3.Put(name, f); err != nil {
+			return nil, fmt.Errorf("uploading snapshot: %w", err)
+		}
+		b.S3Key = cfg.s3.Prefix + name
+	}
+
+	if err := pruneSnapshots(cfg.dir, cfg.keep, dbBackupExt); err != nil {
+		log.Printf("error removing old database snapshots: %v", err)
+	}
+	return b, nil
+}
+
+// AdminBackupHandler writes a snapshot of the database right away, see
+// backupDatabase.
+func (s *Server) AdminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	s.extendDeadlines(w)
+
+	b, err := s.backupDatabase(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("error backing up the database: %v", err)
+		validation.Error(w, "database backup failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(b)
+	_, _ = w.Write(jsonResp)
+}
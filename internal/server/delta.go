@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/merge"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// deltaRequest is the body of PATCH /clipboard/{id}/delta.
+type deltaRequest struct {
+	Ops []merge.Op `json:"ops"`
+}
+
+// deltaResponse holds the ops turning the data of a clipboard at an older
+// version into its data at Version.
+type deltaResponse struct {
+	SinceVersion int        `json:"since_version,omitempty"`
+	Version      int        `json:"version"`
+	Ops          []merge.Op `json:"ops"`
+}
+
+// checkDeltaSync responds with 409 and returns false unless deltas can be
+// computed for the clipboard, which needs the versions kept for merging.
+func checkDeltaSync(w http.ResponseWriter, c *clipboard.Clipboard) bool {
+	if !c.Mergeable() {
+		validation.Error(w, "delta sync needs an unencrypted text clipboard", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// DeltaHandler returns the changes of a text clipboard since the version in
+// ?since_version=, so clients keeping a copy of a large document need not
+// fetch all of it again. Versions older than the merge history respond with
+// 410 and have to be fetched in full.
+func (s *Server) DeltaHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if !checkDeltaSync(w, c) || !s.readClipboard(w, r, c) {
+		return
+	}
+
+	since, err := strconv.Atoi(r.URL.Query().Get("since_version"))
+	if err != nil || since <= 0 || since > c.Version {
+		validation.Error(w, "invalid since_version: must be a version of the clipboard", http.StatusBadRequest)
+		return
+	}
+	base := c.Data
+	if since < c.Version {
+		var kept bool
+		ctx, span := telemetry.Start(r.Context(), "db.Revision")
+		base, kept, err = s.db.Revision(ctx, c.Id, since)
+		telemetry.End(span, err)
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if !kept {
+			validation.Error(w, "version "+strconv.Itoa(since)+" is no longer kept, fetch the whole clipboard", http.StatusGone)
+			return
+		}
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(deltaResponse{
+		SinceVersion: since,
+		Version:      c.Version,
+		Ops:          merge.Delta(base, c.Data),
+	})
+	_, _ = w.Write(jsonResp)
+}
+
+// PatchDeltaHandler updates the data of a text clipboard with the ops of a
+// delta against the version in If-Match. Deltas against outdated versions
+// are merged like PUT merges updates if ?merge= asks for it and the version
+// is still kept. The response holds the ops turning the data the delta
+// produced into the data stored, which differ when the delta was merged or
+// transformed.
+func (s *Server) PatchDeltaHandler(w http.ResponseWriter, r *http.Request) {
+	mode, ok := mergeMode(w, r)
+	if !ok {
+		return
+	}
+
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok || !checkWritable(w, c) || !checkDeltaSync(w, c) {
+		return
+	}
+
+	var req deltaRequest
+	if !s.decodeBody(w, r, &req, "ops") {
+		return
+	}
+
+	current := *c
+	oldSize := c.Size
+	base, baseVersion := c.Data, 0
+	if version, outdated := outdatedVersion(r, c); outdated && mode != "" {
+		data, kept, err := s.db.Revision(r.Context(), c.Id, version)
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		// Without the data the delta was made against, it cannot be
+		// applied at all.
+		if !kept {
+			checkVersion(w, r, c)
+			return
+		}
+		base, baseVersion = data, version
+	} else if !checkVersion(w, r, c) {
+		return
+	}
+
+	patched, err := merge.Apply(base, req.Ops)
+	if err != nil {
+		var errs validation.Errors
+		errs.Add("ops", validation.CodeInvalid, err.Error())
+		validation.WriteErrors(w, errs)
+		return
+	}
+	c.Data = patched
+	// Flavors no longer match the patched data.
+	c.Flavors = nil
+	c.ApplyTransforms()
+	if !s.checkData(w, c, false) {
+		return
+	}
+
+	var conflict *clipboard.Conflict
+	if baseVersion != 0 {
+		if conflict, err = s.mergeUpdate(r.Context(), w, c, &current, baseVersion, mode); err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !s.scanClipboard(w, r, c) {
+		return
+	}
+
+	if !s.checkQuota(r.Context(), w, c.Namespace, c.OwnerId, 0, int64(c.DataSize()-oldSize)) {
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.Update")
+	if conflict != nil {
+		err = s.db.Overwrite(ctx, c, conflict)
+	} else {
+		err = s.db.Update(ctx, c)
+	}
+	telemetry.End(span, err)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publish(events.ClipboardUpdated, c)
+
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(deltaResponse{
+		Version: c.Version,
+		Ops:     merge.Delta(patched, c.Data),
+	})
+	_, _ = w.Write(jsonResp)
+}
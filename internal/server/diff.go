@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/merge"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// DiffHandler returns the changes of a text clipboard between the versions
+// in ?from= and ?to=, by default the current version, as a unified diff,
+// so users can see what changed before reverting. Like deltas, it needs
+// the versions kept for merging; older versions respond with 410.
+func (s *Server) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if !c.Mergeable() {
+		validation.Error(w, "diffs need an unencrypted text clipboard", http.StatusConflict)
+		return
+	}
+	if !s.readClipboard(w, r, c) {
+		return
+	}
+
+	from, ok := versionParam(w, r, c, "from")
+	if !ok {
+		return
+	}
+	to := c.Version
+	if r.URL.Query().Has("to") {
+		if to, ok = versionParam(w, r, c, "to"); !ok {
+			return
+		}
+	}
+
+	texts := make(map[int]string, 2)
+	for _, version := range []int{from, to} {
+		if version == c.Version {
+			texts[version] = c.Data
+			continue
+		}
+		ctx, span := telemetry.Start(r.Context(), "db.Revision")
+		data, kept, err := s.db.Revision(ctx, c.Id, version)
+		telemetry.End(span, err)
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if !kept {
+			validation.Error(w, "version "+strconv.Itoa(version)+" is no longer kept", http.StatusGone)
+			return
+		}
+		texts[version] = data
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	label := func(version int) string { return fmt.Sprintf("%d@%d", c.Id, version) }
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	setETag(w, c)
+	_, _ = w.Write([]byte(merge.Unified(texts[from], texts[to], label(from), label(to))))
+}
+
+// versionParam parses the query parameter name as a version of the
+// clipboard. It responds with 400 and returns false if it is not one.
+func versionParam(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, name string) (int, bool) {
+	version, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || version <= 0 || version > c.Version {
+		validation.Error(w, "invalid "+name+": must be a version of the clipboard", http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
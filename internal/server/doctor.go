@@ -0,0 +1,21 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/copybridge/copybridge-server/internal/database"
+)
+
+// CheckConfig checks the configuration of the server as New does on start,
+// but against a scratch database, so checking it neither migrates the
+// database of the server nor creates the users of API_KEYS in it.
+func CheckConfig() error {
+	db, err := database.OpenScratch()
+	if err != nil {
+		return fmt.Errorf("cannot open scratch database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = New(db)
+	return err
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"archive/zip"
+	"bufio"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// DownloadHandler serves a clipboard as a zip archive of files: its data,
+// each of its flavors and the items on its stack under items/, named after
+// the clipboard filename or name with extensions matching their types. The
+// trust level confirmed is the riskiest of all of them.
+func (s *Server) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionRead)
+	if !ok || !confirmQuarantine(w, r, c) {
+		return
+	}
+
+	data, err := s.openData(r, c, password)
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+	defer data.Close()
+
+	br := bufio.NewReaderSize(data, trustPeekSize)
+	head, err := br.Peek(trustPeekSize)
+	if err != nil && err != io.EOF {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+	riskiest := s.trust.AssessData(c.DataType, string(head))
+	for _, f := range c.Flavors {
+		if level := s.trust.AssessData(f.DataType, f.Data); level.Riskier(riskiest) {
+			riskiest = level
+		}
+	}
+
+	items, err := s.db.Items(r.Context(), c.Id, maxItemsLimit)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !s.decryptItems(w, r, c, password, items...) {
+		return
+	}
+	if level := s.assessItems(items); level.Riskier(riskiest) {
+		riskiest = level
+	}
+	if !confirmTrust(w, r, riskiest) {
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+	s.extendDeadlines(w)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(clipboard.DispositionAttachment, map[string]string{"filename": c.BaseName() + ".zip"}))
+
+	zw := zip.NewWriter(w)
+	names := map[string]bool{}
+	add := func(name, dataType string, modified time.Time, content io.Reader) error {
+		name = uniqueName(names, name)
+		method := zip.Store
+		if compressible(dataType) {
+			method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, content)
+		return err
+	}
+
+	err = add(c.DataFilename(), c.DataType, c.UpdatedAt, br)
+	for _, f := range c.Flavors {
+		if err == nil {
+			err = add(c.FlavorFilename(f), f.DataType, c.UpdatedAt, strings.NewReader(f.Data))
+		}
+	}
+	for _, item := range items {
+		if err == nil {
+			name := "items/" + strconv.Itoa(item.Id) + clipboard.Extension(item.DataType)
+			err = add(name, item.DataType, item.CreatedAt, strings.NewReader(item.Data))
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("error streaming clipboard %d as zip: %v", c.Id, err)
+	}
+}
+
+// uniqueName returns name, or name with a numeric suffix before its
+// extension if it is already taken, and marks the result as taken.
+func uniqueName(taken map[string]bool, name string) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		ext := path.Ext(name)
+		unique = strings.TrimSuffix(name, ext) + "-" + strconv.Itoa(i) + ext
+	}
+	taken[unique] = true
+	return unique
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// databaseError responds to an error returned by the database with the
+// status code of its kind, see database.ErrNotFound, and its message.
+// Errors of no kind are reported as internal errors, without revealing
+// what went wrong.
+func databaseError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		validation.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, database.ErrConflict):
+		validation.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, database.ErrUnauthorized):
+		validation.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+	}
+}
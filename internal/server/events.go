@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/mqtt"
+)
+
+// publish announces a change to a clipboard on the event bus and records it
+// in the changefeed.
+func (s *Server) publish(eventType string, c *clipboard.Clipboard) {
+	s.recordChange(eventType, c)
+	s.events.Publish(clipboardEvent(eventType, c))
+}
+
+// clipboardEvent describes the current state of a clipboard as an event.
+// The data of encrypted, streamed and quarantined clipboards, and of
+// clipboards scheduled for later, is left out.
+func clipboardEvent(eventType string, c *clipboard.Clipboard) events.Event {
+	e := events.Event{
+		Type:        eventType,
+		ClipboardId: c.Id,
+		PublicId:    c.PublicId,
+		Name:        c.Name,
+		DataType:    c.DataType,
+		IsEncrypted: c.IsEncrypted,
+		Streamed:    c.Streamed,
+		Version:     c.Version,
+	}
+	if !c.IsEncrypted && !c.Streamed && c.Quarantine == "" && c.Published(time.Now()) {
+		e.Data = c.Data
+	}
+	return e
+}
+
+// publishItem announces a change to the stack of a clipboard on the event bus.
+func (s *Server) publishItem(eventType string, c *clipboard.Clipboard, item *clipboard.Item) {
+	e := events.Event{
+		Type:        eventType,
+		ClipboardId: c.Id,
+		PublicId:    c.PublicId,
+		Name:        c.Name,
+		DataType:    item.DataType,
+		IsEncrypted: c.IsEncrypted,
+	}
+	if !c.IsEncrypted {
+		e.Data = item.Data
+	}
+
+	s.recordChange(eventType, c)
+	s.events.Publish(e)
+}
+
+// recordChange appends a change of a clipboard to the changefeed. Failures
+// are only logged, since the change itself was made.
+func (s *Server) recordChange(op string, c *clipboard.Clipboard) {
+	change := &clipboard.Change{ClipboardId: c.Id, PublicId: c.PublicId, Op: op, OwnerId: c.OwnerId, Namespace: c.Namespace}
+	if err := s.db.RecordChange(context.Background(), change); err != nil {
+		log.Printf("error recording change of clipboard %d: %v", c.Id, err)
+	}
+}
+
+// startMQTT connects the MQTT bridge if MQTT_BROKER is set.
+// It returns an error if the broker cannot be connected.
+func (s *Server) startMQTT() error {
+	cfg := mqtt.ConfigFromEnv()
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	// Pastes arrive outside of any request, only the query timeout of the
+	// database bounds them.
+	bridge, err := mqtt.Connect(cfg, func(id int, data string) error {
+		return s.paste(context.Background(), id, data)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot connect to MQTT broker: %w", err)
+	}
+	go bridge.Run(context.Background(), s.events)
+	return nil
+}
+
+// paste replaces the data of an unencrypted clipboard with data received
+// from outside of HTTP, such as the MQTT bridge.
+// Encrypted clipboards cannot be pasted to, since there is no password.
+func (s *Server) paste(ctx context.Context, id int, data string) error {
+	c, err := s.db.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return database.ErrClipboardNotFound
+	}
+
+	return s.replaceData(ctx, c, c.DataType, data)
+}
+
+// replaceData replaces the type and data of an unencrypted clipboard if it
+// is still at the version of c, enforcing the same limits as the HTTP API.
+// Its flavors are dropped, since they represent the previous data.
+// It returns database.ErrVersionConflict if the clipboard changed meanwhile.
+func (s *Server) replaceData(ctx context.Context, c *clipboard.Clipboard, dataType, data string) error {
+	if c.IsEncrypted {
+		return errors.New("clipboard is encrypted")
+	}
+	if c.Locked {
+		return errors.New("clipboard is locked")
+	}
+	if c.ReadOnly {
+		return errors.New("clipboard is read-only")
+	}
+	if q := s.quotaOf(c.Namespace); q.MaxClipboardSize > 0 && len(data) > q.MaxClipboardSize {
+		return errClipboardTooLarge
+	}
+	if err := s.types.Check(dataType, data); err != nil {
+		return err
+	}
+	quarantine, err := s.scanContent(ctx, data)
+	if err != nil {
+		return err
+	}
+	if err := s.enforceQuota(ctx, c.Namespace, c.OwnerId, 0, int64(len(data)-c.Size)); err != nil {
+		return err
+	}
+
+	c.DataType = dataType
+	c.Data = data
+	c.Flavors = nil
+	c.Quarantine = quarantine
+	c.ApplyTransforms()
+	if err := s.db.Update(ctx, c); err != nil {
+		return err
+	}
+
+	s.publish(events.ClipboardUpdated, c)
+
+	return nil
+}
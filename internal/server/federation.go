@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/federation"
+	"github.com/copybridge/copybridge-server/internal/jobs"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxFederationBody is the largest record a peer may push.
+const maxFederationBody = 256 << 20
+
+// errInvalidRecord is returned for records that cannot be applied.
+var errInvalidRecord = errors.New("invalid record")
+
+// federationRoutes registers the server-to-server API of the federation,
+// which is only available when FEDERATION_SECRET is set.
+func (s *Server) federationRoutes(r chi.Router) {
+	r.Use(s.requirePeer)
+
+	r.Get("/records", s.FederationEntriesHandler)
+	r.Post("/records", s.FederationPushHandler)
+	r.Get("/records/{publicId}", s.FederationRecordHandler)
+}
+
+// requirePeer only lets requests signed with the shared secret through.
+func (s *Server) requirePeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFederationBody))
+		if err != nil {
+			validation.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := federation.Verify(r, body, s.federation.cfg.Secret, time.Now()); err != nil {
+			validation.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FederationEntriesHandler lists the records of the namespace, including
+// tombstones, without their data.
+func (s *Server) FederationEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	s.extendDeadlines(w)
+
+	entries, err := s.federation.Entries(r.Context())
+	if err != nil {
+		log.Printf("error listing federated clipboards: %v", err)
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(entries)
+	_, _ = w.Write(jsonResp)
+}
+
+// FederationRecordHandler returns the record of a clipboard of the
+// namespace, or its tombstone.
+func (s *Server) FederationRecordHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.federation.Record(r.Context(), chi.URLParam(r, "publicId"))
+	if err != nil {
+		log.Printf("error loading federated clipboard: %v", err)
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if rec == nil {
+		validation.Error(w, "record not found", http.StatusNotFound)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(rec)
+	_, _ = w.Write(jsonResp)
+}
+
+// FederationPushHandler applies a record pushed by a peer unless the local
+// state of the clipboard is newer, which is returned instead.
+func (s *Server) FederationPushHandler(w http.ResponseWriter, r *http.Request) {
+	var rec federation.Record
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		validation.Error(w, "invalid record", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.federation.Apply(r.Context(), &rec)
+	if errors.Is(err, errInvalidRecord) {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error applying federated clipboard %s: %v", rec.PublicId, err)
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(resp)
+	_, _ = w.Write(jsonResp)
+}
+
+// startFederation replicates the namespace with the peers of
+// FEDERATION_PEERS: changes are pushed as they happen, and the federation
+// job reconciles with every peer each FEDERATION_SYNC_INTERVAL. Without
+// federation, the cleanup job prunes tombstones.
+func (s *Server) startFederation() {
+	if !s.federation.cfg.Enabled() {
+		return
+	}
+
+	syncer := federation.NewSyncer(s.federation.cfg, s.federation)
+	s.jobs.AddExclusive(jobFederation, jobs.Every(s.federation.cfg.SyncInterval), func(ctx context.Context) error {
+		syncer.Sync(ctx)
+		return nil
+	})
+	go syncer.Run(context.Background(), s.events)
+}
+
+// federationStore is the local side of the federation, see
+// federation.Store.
+type federationStore struct {
+	s   *Server
+	cfg federation.Config
+
+	// mu serializes applying records, so a record pushed by a peer and the
+	// same record fetched by the syncer are not inserted twice.
+	mu sync.Mutex
+}
+
+// inNamespace reports whether a clipboard is replicated. Only clipboards of
+// the default namespace of the server are.
+func (f *federationStore) inNamespace(c *clipboard.Clipboard) bool {
+	return c.Namespace == account.DefaultNamespace && slices.Contains(c.Tags, f.cfg.Namespace)
+}
+
+// Entries lists the clipboards of the namespace and all tombstones.
+func (f *federationStore) Entries(ctx context.Context) ([]federation.Entry, error) {
+	entries := []federation.Entry{}
+	owners := make(map[int]string)
+	for offset := 0; ; offset += maxListLimit {
+		cs, err := f.s.db.List(ctx, database.ListOptions{AllOwners: true, Namespace: account.DefaultNamespace, Tags: []string{f.cfg.Namespace}, Limit: maxListLimit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cs {
+			rec, err := f.s.exportRecord(ctx, c, owners)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, (&federation.Record{Record: *rec}).Entry())
+		}
+		if len(cs) < maxListLimit {
+			break
+		}
+	}
+
+	tombstones, err := f.s.db.Tombstones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tombstones {
+		entries = append(entries, federation.Entry{PublicId: t.PublicId, UpdatedAt: t.DeletedAt, Deleted: true})
+	}
+
+	return entries, nil
+}
+
+// Record returns the record of a clipboard of the namespace or its
+// tombstone.
+func (f *federationStore) Record(ctx context.Context, publicId string) (*federation.Record, error) {
+	if !clipboard.IsPublicId(publicId) {
+		return nil, nil
+	}
+
+	c, err := f.s.db.GetByPublicId(ctx, publicId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	if c != nil {
+		if !f.inNamespace(c) {
+			return nil, nil
+		}
+		rec, err := f.s.exportRecord(ctx, c, make(map[int]string))
+		if err != nil {
+			return nil, err
+		}
+		return &federation.Record{Record: *rec}, nil
+	}
+
+	t, err := f.s.db.GetTombstone(ctx, publicId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil || t == nil {
+		return nil, err
+	}
+	rec := &federation.Record{Deleted: true}
+	rec.PublicId = t.PublicId
+	rec.UpdatedAt = t.DeletedAt
+	return rec, nil
+}
+
+// Apply applies a record of a peer unless the local state is newer.
+// Clipboards are created with a local id, and replaced or deleted in place,
+// so the tokens, permissions and stack items of the local copy survive.
+// Local clipboards that have left the namespace are left alone.
+func (f *federationStore) Apply(ctx context.Context, rec *federation.Record) (*federation.PushResponse, error) {
+	if !clipboard.IsPublicId(rec.PublicId) {
+		return nil, fmt.Errorf("%w: invalid public id", errInvalidRecord)
+	}
+	if !rec.Deleted && !slices.Contains(rec.Tags, f.cfg.Namespace) {
+		return nil, fmt.Errorf("%w: clipboard is not tagged %s", errInvalidRecord, f.cfg.Namespace)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	local, err := f.Record(ctx, rec.PublicId)
+	if err != nil {
+		return nil, err
+	}
+	if local != nil {
+		if federation.Newer(local.Entry(), rec.Entry()) {
+			return &federation.PushResponse{Result: federation.ResultKept, Record: local}, nil
+		}
+		if !federation.Newer(rec.Entry(), local.Entry()) {
+			return &federation.PushResponse{Result: federation.ResultUnchanged}, nil
+		}
+	}
+
+	existing, err := f.s.db.GetByPublicId(ctx, rec.PublicId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	if existing != nil && !f.inNamespace(existing) {
+		return &federation.PushResponse{Result: federation.ResultUnchanged}, nil
+	}
+
+	if rec.Deleted {
+		if existing == nil {
+			return &federation.PushResponse{Result: federation.ResultUnchanged}, nil
+		}
+		if err := f.s.db.Delete(ctx, existing.Id); err != nil {
+			return nil, err
+		}
+		f.s.publish(events.ClipboardDeleted, existing)
+		return &federation.PushResponse{Result: federation.ResultApplied}, nil
+	}
+
+	c, err := importClipboard(&rec.Record)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRecord, err)
+	}
+	c.Namespace = account.DefaultNamespace
+	if rec.Owner != "" {
+		u, err := f.s.db.EnsureUser(ctx, c.Namespace, rec.Owner)
+		if err != nil {
+			return nil, err
+		}
+		c.OwnerId = u.Id
+	}
+
+	if existing == nil {
+		c.Id = 0
+		if err := f.s.db.Restore(ctx, c, nil); err != nil {
+			return nil, err
+		}
+		f.s.publish(events.ClipboardCreated, c)
+	} else {
+		c.Id = existing.Id
+		if err := f.s.db.Replicate(ctx, c); err != nil {
+			return nil, err
+		}
+		f.s.publish(events.ClipboardUpdated, c)
+	}
+
+	return &federation.PushResponse{Result: federation.ResultApplied}, nil
+}
+
+// Prune forgets deletions older than the given time.
+func (f *federationStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := f.s.db.PruneTombstones(ctx, before)
+	return err
+}
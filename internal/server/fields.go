@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// responseFields are the fields of clipboards in responses, which the
+// fields query parameter selects from.
+var responseFields = jsonFields(reflect.TypeOf(clipboard.Clipboard{}))
+
+// jsonFields returns the names of the fields of a struct type in JSON.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// queryFields returns the fields the fields query parameter selects, e.g.
+// ?fields=name,type,updated_at, for clients that only need some fields of
+// clipboards and not their data, or nil if it is absent.
+func queryFields(r *http.Request) ([]string, error) {
+	fields := queryList(r, "fields")
+	for _, f := range fields {
+		if slices.Contains(responseFields, f) {
+			continue
+		}
+		if s := validation.Suggest(f, responseFields); s != "" {
+			return nil, fmt.Errorf("unknown field %s in fields, did you mean %s?", f, s)
+		}
+		return nil, fmt.Errorf("unknown field %s in fields", f)
+	}
+	return fields, nil
+}
+
+// withFields returns a clipboard with only the given fields and its id, by
+// which clients refer to it, to be encoded in a response. Fields without a
+// value are left out as usual. It returns the clipboard itself if fields is
+// empty.
+func withFields(c *clipboard.Clipboard, fields []string) any {
+	if len(fields) == 0 {
+		return c
+	}
+
+	encoded, _ := json.Marshal(c)
+	var all map[string]json.RawMessage
+	_ = json.Unmarshal(encoded, &all)
+
+	selected := map[string]json.RawMessage{"id": all["id"]}
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			selected[f] = v
+		}
+	}
+	return selected
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/codec"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// responseTypes are the types JSON responses can be sent as, JSON first so
+// it wins ties and wildcards.
+var responseTypes = []string{"application/json", "application/cbor", "application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+
+// binaryFormats lets clients send request bodies as CBOR or MessagePack
+// instead of JSON, converting them to JSON for the handlers, and converts
+// JSON responses to the format the Accept header prefers. Routes whose
+// bodies are clipboard data rather than JSON are left alone, so data of
+// these types is stored and served as is.
+func (s *Server) binaryFormats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || rawBodies(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept")
+		if i := clipboard.Negotiate(r.Header.Get("Accept"), responseTypes); i > 0 && r.Method != http.MethodHead {
+			bw := &binaryResponseWriter{ResponseWriter: w, format: codec.ByMediaType(responseTypes[i]), status: http.StatusOK}
+			defer bw.close()
+			w = bw
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if f := codec.ByMediaType(mediaType); f != nil {
+			body, err := io.ReadAll(r.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				validation.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				validation.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			data, err := f.ToJSON(body)
+			if err != nil {
+				validation.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			r.ContentLength = int64(len(data))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Del("Content-Length")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rawBodies reports whether the request or response body of a route is
+// clipboard data, an archive or a peer message rather than a JSON document.
+func rawBodies(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/raw"), strings.HasSuffix(path, "/download"),
+		strings.HasPrefix(path, "/relay/"),
+		strings.HasPrefix(path, "/federation/"),
+		path == "/export", path == "/import":
+		return true
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "/clipboard/uploads/"):
+		return true
+	}
+	return false
+}
+
+// isJSON reports whether a content type is JSON.
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// binaryResponseWriter buffers JSON responses to convert them to a binary
+// format once complete. Responses of other types, and responses flushed
+// before they end, such as streams, are passed on as they are.
+type binaryResponseWriter struct {
+	http.ResponseWriter
+	format *codec.Format
+
+	status      int
+	wroteHeader bool
+	buf         []byte
+	passThrough bool
+}
+
+func (b *binaryResponseWriter) WriteHeader(status int) {
+	if b.passThrough || status < http.StatusOK {
+		b.ResponseWriter.WriteHeader(status)
+		return
+	}
+	b.status = status
+	b.wroteHeader = true
+}
+
+func (b *binaryResponseWriter) Write(p []byte) (int, error) {
+	if !b.passThrough {
+		// Handlers mostly leave the type of JSON responses to sniffing, so
+		// only responses of other types are known not to be JSON up front.
+		if contentType := b.Header().Get("Content-Type"); contentType == "" || isJSON(contentType) {
+			b.buf = append(b.buf, p...)
+			return len(p), nil
+		}
+		if err := b.pass(); err != nil {
+			return 0, err
+		}
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+// pass sends the header and what was buffered, and passes on the rest of
+// the response.
+func (b *binaryResponseWriter) pass() error {
+	b.passThrough = true
+	b.ResponseWriter.WriteHeader(b.status)
+	if len(b.buf) == 0 {
+		return nil
+	}
+	buf := b.buf
+	b.buf = nil
+	_, err := b.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush passes on the response, as a flushed response is a stream.
+func (b *binaryResponseWriter) Flush() {
+	if !b.passThrough {
+		_ = b.pass()
+	}
+	_ = http.NewResponseController(b.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController and validation reach the underlying
+// writer.
+func (b *binaryResponseWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// close converts and sends a buffered JSON response. Bodies that turn out
+// not to be JSON are sent as they are.
+func (b *binaryResponseWriter) close() {
+	if b.passThrough {
+		return
+	}
+	if len(b.buf) == 0 {
+		if b.wroteHeader {
+			b.ResponseWriter.WriteHeader(b.status)
+		}
+		return
+	}
+	h := b.Header()
+	if h.Get("Content-Type") == "" && !json.Valid(b.buf) {
+		_ = b.pass()
+		return
+	}
+	data, err := b.format.FromJSON(b.buf)
+	if err != nil {
+		_ = b.pass()
+		return
+	}
+
+	h.Set("Content-Type", b.format.MediaType)
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	b.ResponseWriter.WriteHeader(b.status)
+	_, _ = b.ResponseWriter.Write(data)
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// filterIPs answers requests of clients in IP_DENY, or outside of IP_ALLOW
+// if it is set, with 403. The client IP is taken from X-Forwarded-For
+// behind TRUSTED_PROXIES, see clientIP.
+func (s *Server) filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ipAllowed(s.clientIP(r)) {
+			validation.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipAllowed reports whether a client may use the server. Denied networks
+// take precedence over allowed ones, so single addresses can be carved out
+// of an allowed range.
+func (s *Server) ipAllowed(ip string) bool {
+	if containsIP(s.deniedIPs, ip) {
+		return false
+	}
+	return len(s.allowedIPs) == 0 || containsIP(s.allowedIPs, ip)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+const (
+	defaultItemsLimit = 10
+	maxItemsLimit     = 1000
+)
+
+// PushItemHandler puts a new item on top of the stack of a clipboard.
+// Items of encrypted clipboards are encrypted with the clipboard password.
+func (s *Server) PushItemHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionUpdate)
+	if !ok || !checkWritable(w, c) {
+		return
+	}
+
+	var item clipboard.Item
+	if !s.decodeBody(w, r, &item, "type", "data") {
+		return
+	}
+	item.ClipboardId = c.Id
+	if !s.checkClipboardSize(w, c.Namespace, item.Data) || !s.checkType(w, item.DataType, item.Data) {
+		return
+	}
+	// Items cannot be quarantined, so flagged items are rejected as well.
+	quarantine, err := s.scanContent(r.Context(), item.Data)
+	if err == nil && quarantine != "" {
+		err = &rejectedError{quarantine}
+	}
+	if !checkScan(w, err) {
+		return
+	}
+
+	if c.IsEncrypted {
+		_, span := telemetry.Start(r.Context(), "crypto.Encrypt")
+		err := c.EncryptItems(password, &item)
+		telemetry.End(span, err)
+		if err != nil {
+			validation.Error(w, "item encryption failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !s.checkQuota(r.Context(), w, c.Namespace, c.OwnerId, 0, int64(len(item.Data))) {
+		return
+	}
+
+	if err := s.db.PushItem(r.Context(), &item, s.maxStackItems); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publishItem(events.ItemPushed, c, &item)
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(item)
+	_, _ = w.Write(jsonResp)
+}
+
+// ItemsHandler peeks at the top items of the stack of a clipboard, newest first.
+func (s *Server) ItemsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultItemsLimit, maxItemsLimit)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionRead)
+	if !ok {
+		return
+	}
+
+	items, err := s.db.Items(r.Context(), c.Id, limit)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.readItems(w, r, c, password, items...) {
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	jsonResp, _ := json.Marshal(items)
+	_, _ = w.Write(jsonResp)
+}
+
+// PopItemHandler removes the top item of the stack of a clipboard and returns it.
+func (s *Server) PopItemHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionUpdate)
+	if !ok || !checkWritable(w, c) {
+		return
+	}
+
+	// Peek first, so the item stays on the stack if it cannot be served.
+	items, err := s.db.Items(r.Context(), c.Id, 1)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if len(items) == 0 {
+		validation.Error(w, "clipboard stack is empty", http.StatusNotFound)
+		return
+	}
+	if !s.readItems(w, r, c, password, items...) {
+		return
+	}
+
+	item, err := s.db.PopItem(r.Context(), c.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if item == nil || item.Id != items[0].Id {
+		validation.Error(w, "clipboard stack changed concurrently", http.StatusConflict)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publishItem(events.ItemPopped, c, items[0])
+
+	jsonResp, _ := json.Marshal(items[0])
+	_, _ = w.Write(jsonResp)
+}
+
+// readItems decrypts stack items and checks that the client acknowledged the
+// riskiest content among them.
+// If the items cannot be read, it writes an error response and returns false.
+func (s *Server) readItems(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, password string, items ...*clipboard.Item) bool {
+	return s.decryptItems(w, r, c, password, items...) && confirmTrust(w, r, s.assessItems(items))
+}
+
+// decryptItems decrypts the stack items of an encrypted clipboard.
+// If they cannot be decrypted, it writes an error response and returns false.
+func (s *Server) decryptItems(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, password string, items ...*clipboard.Item) bool {
+	if !c.IsEncrypted {
+		return true
+	}
+
+	_, span := telemetry.Start(r.Context(), "crypto.Decrypt")
+	err := c.DecryptItems(password, items...)
+	telemetry.End(span, err)
+	if err != nil {
+		validation.Error(w, "item decryption failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// assessItems sets the trust level of stack items and returns the riskiest.
+func (s *Server) assessItems(items []*clipboard.Item) clipboard.TrustLevel {
+	riskiest := clipboard.TrustSafe
+	for _, item := range items {
+		item.Trust = s.trust.AssessData(item.DataType, item.Data)
+		if item.Trust.Riskier(riskiest) {
+			riskiest = item.Trust
+		}
+	}
+	return riskiest
+}
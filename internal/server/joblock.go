@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// jobLockLease names the lease held by the process running the exclusive
+// jobs.
+const jobLockLease = "jobs"
+
+// jobLock lets one of the server processes sharing a SQLite database file
+// run the exclusive background jobs, by holding a lease in the database
+// and renewing it every third of its TTL. The lease is a row of that file,
+// so the lock only spans processes opening the same file on one host; it
+// does not coordinate replicas or hosts, and gives no failover beyond them.
+// A process only holds the lock while its lease is known to be valid, so a
+// holder that cannot reach the database lets go before another process can
+// take over.
+type jobLock struct {
+	db     database.Service
+	holder string
+	ttl    time.Duration
+
+	// until is when the lease of the process expires, in Unix
+	// nanoseconds, or 0 if another process holds it.
+	until atomic.Int64
+}
+
+// jobLockFromEnv configures the job lock of JOB_LOCK_ENABLED processes.
+// They are told apart by JOB_LOCK_HOLDER, which defaults to the host name
+// with a random suffix, so restarted processes do not resume a lease.
+func jobLockFromEnv(db database.Service) (*jobLock, error) {
+	holder := env.String("JOB_LOCK_HOLDER", "")
+	if holder == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "copybridge"
+		}
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		holder = host + "-" + hex.EncodeToString(b)
+	}
+
+	ttl := env.Duration("JOB_LOCK_TTL", 30*time.Second)
+	if ttl < time.Second {
+		return nil, errors.New("invalid JOB_LOCK_TTL: must be at least 1s")
+	}
+
+	return &jobLock{db: db, holder: holder, ttl: ttl}, nil
+}
+
+// Leading reports whether the process holds the lock.
+func (l *jobLock) Leading() bool {
+	return time.Now().UnixNano() < l.until.Load()
+}
+
+// run takes and renews the lease until ctx is done, and then releases it.
+func (l *jobLock) run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		l.renew(ctx)
+		select {
+		case <-ctx.Done():
+			l.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew takes or renews the lease. If the database cannot be reached, the
+// process keeps the lock until the lease it has expires.
+func (l *jobLock) renew(ctx context.Context) {
+	was := l.Leading()
+	start := time.Now()
+	ok, err := l.db.AcquireLease(ctx, jobLockLease, l.holder, start, l.ttl)
+	switch {
+	case err != nil:
+		log.Printf("jobs: cannot renew the job lock of %s: %v", l.holder, err)
+	case ok:
+		l.until.Store(start.Add(l.ttl).UnixNano())
+	default:
+		l.until.Store(0)
+	}
+
+	if leading := l.Leading(); leading != was {
+		if leading {
+			log.Printf("jobs: %s now holds the job lock", l.holder)
+		} else {
+			log.Printf("jobs: %s no longer holds the job lock", l.holder)
+		}
+	}
+}
+
+// release gives up the lease, so another process can take over without
+// waiting for it to expire.
+func (l *jobLock) release() {
+	if !l.Leading() {
+		return
+	}
+	l.until.Store(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := l.db.ReleaseLease(ctx, jobLockLease, l.holder); err != nil {
+		log.Printf("jobs: cannot release the job lock of %s: %v", l.holder, err)
+	}
+}
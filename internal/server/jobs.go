@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/backup"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/jobs"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Names of the background jobs.
+const (
+	jobRetention  = "retention"
+	jobCleanup    = "cleanup"
+	jobBackup     = "backup"
+	jobStats      = "stats"
+	jobFederation = "federation"
+	jobDBBackup   = "db-backup"
+	jobMasterKeys = "master-keys"
+)
+
+// backupPrefix starts the file names of backup snapshots.
+const backupPrefix = "copybridge-"
+
+// stopTimeout bounds how long stopping waits for running jobs.
+const stopTimeout = 30 * time.Second
+
+// addJobs registers the background jobs configured in the environment.
+// They only run once startJobs starts the scheduler. Replicas leave the
+// jobs changing the database to the primary, and with a job lock only the
+// process holding it runs them.
+func (s *Server) addJobs() error {
+	schedule := func(name, def string) (jobs.Schedule, error) {
+		sched, err := jobs.ParseSchedule(env.String(name, def))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return sched, nil
+	}
+
+	if s.primary == nil {
+		if s.retentionEnabled() {
+			interval := s.namespaces[account.DefaultNamespace].retention.Interval
+			s.jobs.AddExclusive(jobRetention, jobs.Every(interval), func(ctx context.Context) error {
+				return s.applyRetention(ctx, time.Now())
+			})
+		}
+
+		cleanup, err := schedule("CLEANUP_SCHEDULE", "@every 1h")
+		if err != nil {
+			return err
+		}
+		s.jobs.AddExclusive(jobCleanup, cleanup, s.cleanup)
+	}
+
+	if dir := env.String("BACKUP_DIR", ""); dir != "" {
+		sched, err := schedule("BACKUP_SCHEDULE", "@daily")
+		if err != nil {
+			return err
+		}
+		format := env.String("BACKUP_FORMAT", backup.FormatJSON)
+		if format != backup.FormatJSON && format != backup.FormatTar {
+			return fmt.Errorf("invalid BACKUP_FORMAT: unknown archive format %q", format)
+		}
+		keep := env.Int("BACKUP_KEEP", 7)
+		s.jobs.AddExclusive(jobBackup, sched, func(ctx context.Context) error {
+			return s.snapshot(ctx, dir, format, keep, time.Now())
+		})
+	}
+
+	if s.dbBackup.dir != "" {
+		sched, err := schedule("DB_BACKUP_SCHEDULE", "@daily")
+		if err != nil {
+			return err
+		}
+		s.jobs.AddExclusive(jobDBBackup, sched, func(ctx context.Context) error {
+			_, err := s.backupDatabase(ctx, time.Now())
+			return err
+		})
+	}
+
+	// Replicas refresh the keys too, to open data the primary resealed.
+	if env.String("MASTER_KEYS_REFRESH_SCHEDULE", "") != "" {
+		sched, err := schedule("MASTER_KEYS_REFRESH_SCHEDULE", "")
+		if err != nil {
+			return err
+		}
+		s.jobs.Add(jobMasterKeys, sched, s.db.RefreshMasterKeys)
+	}
+
+	if interval := env.Duration("STATS_INTERVAL", time.Minute); interval > 0 {
+		s.jobs.Add(jobStats, jobs.Every(interval), s.aggregateStats)
+	}
+
+	return nil
+}
+
+// stopping tracks the background jobs being stopped on shutdown, which
+// http.Server.Shutdown does not wait for.
+var stopping sync.WaitGroup
+
+// startJobs runs the background jobs, and the job lock if enabled, until
+// the HTTP server shuts down. The lock is only released once the jobs
+// stopped.
+func (s *Server) startJobs(server *http.Server) {
+	electing, stopElecting := context.WithCancel(context.Background())
+	elected := make(chan struct{})
+	if s.jobLock != nil {
+		go func() {
+			defer close(elected)
+			s.jobLock.run(electing)
+		}()
+	} else {
+		close(elected)
+	}
+
+	s.jobs.Start()
+	stopping.Add(1)
+	server.RegisterOnShutdown(func() {
+		defer stopping.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+		if err := s.jobs.Stop(ctx); err != nil {
+			log.Printf("error stopping background jobs: %v", err)
+		}
+		stopElecting()
+		<-elected
+	})
+}
+
+// cleanup deletes expired clipboards, uploads and sessions, and tombstones
+// older than FEDERATION_TOMBSTONE_TTL unless federation prunes them after
+// syncing.
+func (s *Server) cleanup(ctx context.Context) error {
+	now := time.Now()
+	ids, err := s.db.ExpiredClipboards(ctx, now)
+	if err != nil {
+		return fmt.Errorf("finding expired clipboards: %w", err)
+	}
+	for _, id := range ids {
+		if err := (retentionStore{s.db, s}).Delete(ctx, id); err != nil {
+			return fmt.Errorf("deleting expired clipboard %d: %w", id, err)
+		}
+	}
+	if n, err := s.db.DeleteExpiredUploads(ctx, now); countDeleted("uploads", n, err) != nil {
+		return fmt.Errorf("deleting expired uploads: %w", err)
+	}
+	if n, err := s.db.DeleteExpiredBlobUploads(ctx, now); countDeleted("blob_uploads", n, err) != nil {
+		return fmt.Errorf("deleting expired direct uploads: %w", err)
+	}
+	if n, err := s.db.DeleteExpiredSessions(ctx, now); countDeleted("sessions", n, err) != nil {
+		return fmt.Errorf("deleting expired sessions: %w", err)
+	}
+	if s.changesRetention > 0 {
+		if n, err := s.db.PruneChanges(ctx, now.Add(-s.changesRetention)); countDeleted("changes", n, err) != nil {
+			return fmt.Errorf("pruning changes: %w", err)
+		}
+	}
+	if !s.federation.cfg.Enabled() {
+		if n, err := s.db.PruneTombstones(ctx, now.Add(-s.federation.cfg.TombstoneTTL)); countDeleted("tombstones", n, err) != nil {
+			return fmt.Errorf("pruning tombstones: %w", err)
+		}
+	}
+	return nil
+}
+
+// snapshot writes an archive of all clipboards, including encrypted ones,
+// to dir and removes all but the keep most recent snapshots. Archives are
+// written to a temporary file first, so a failed snapshot never replaces a
+// good one.
+func (s *Server) snapshot(ctx context.Context, dir, format string, keep int, now time.Time) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	aw, err := backup.NewWriter(f, format)
+	if err == nil {
+		err = s.export(ctx, aw, true)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	name := backupPrefix + now.UTC().Format("20060102-150405") + "." + format
+	if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+
+	return pruneSnapshots(dir, keep, "."+backup.FormatJSON, "."+backup.FormatTar)
+}
+
+// pruneSnapshots removes all but the keep most recent snapshots in dir with
+// one of the given extensions. Snapshots of all these extensions count
+// together, as their names sort by time.
+func pruneSnapshots(dir string, keep int, exts ...string) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), backupPrefix) && slices.Contains(exts, filepath.Ext(e.Name())) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	var errs []error
+	for i := keep; i < len(names); i++ {
+		errs = append(errs, os.Remove(filepath.Join(dir, names[i])))
+	}
+	return errors.Join(errs...)
+}
+
+// statsSnapshot are the server statistics as last aggregated.
+type statsSnapshot struct {
+	database.Stats
+	AggregatedAt time.Time
+}
+
+// aggregateStats computes the server statistics the admin API reports, so
+// polling them does not scan the database on every request.
+func (s *Server) aggregateStats(ctx context.Context) error {
+	stats, err := s.db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	s.stats.Store(&statsSnapshot{Stats: stats, AggregatedAt: time.Now()})
+	return nil
+}
+
+// AdminJobsHandler lists the background jobs with their statistics.
+func (s *Server) AdminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResp, _ := json.Marshal(s.jobs.Stats())
+	_, _ = w.Write(jsonResp)
+}
+
+// AdminRunJobHandler runs a background job right away and returns its
+// statistics once it finishes.
+func (s *Server) AdminRunJobHandler(w http.ResponseWriter, r *http.Request) {
+	s.extendDeadlines(w)
+
+	name := chi.URLParam(r, "name")
+	if !s.jobs.Trigger(name) {
+		for _, st := range s.jobs.Stats() {
+			if st.Name == name && st.Standby {
+				validation.Error(w, "job runs in the process holding the job lock", http.StatusConflict)
+				return
+			}
+			if st.Name == name {
+				validation.Error(w, "job is already running", http.StatusConflict)
+				return
+			}
+		}
+		validation.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	for _, st := range s.jobs.Stats() {
+		if st.Name == name {
+			jsonResp, _ := json.Marshal(st)
+			_, _ = w.Write(jsonResp)
+			return
+		}
+	}
+}
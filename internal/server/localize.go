@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/i18n"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// localize translates the messages of error responses into the language the
+// client prefers by its Accept-Language header, so client UIs can show them
+// to users as is. WebSocket upgrades are passed through, as the upgrader
+// needs the connection's own writer.
+func localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(validation.Localize(w, lang), r)
+	})
+}
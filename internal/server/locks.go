@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// LockHandler makes a clipboard read-only with POST and writable again with
+// DELETE. Read-only clipboards refuse changes to their data and deletion
+// with 423, so a misconfigured client cannot overwrite them. Only the owner
+// may lock and unlock owned clipboards; anonymous ones can be locked by
+// anyone allowed to update them.
+func (s *Server) LockHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+	if c.OwnerId != 0 && (currentToken(r) != nil || currentUserId(r) != c.OwnerId) {
+		s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeForbidden)
+		validation.Error(w, "only the owner can lock a clipboard", http.StatusForbidden)
+		return
+	}
+
+	if err := s.db.SetReadOnly(r.Context(), c.Id, r.Method == http.MethodPost); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkWritable responds with 423 and returns false if the clipboard is
+// read-only, see LockHandler.
+func checkWritable(w http.ResponseWriter, c *clipboard.Clipboard) bool {
+	if c.ReadOnly {
+		validation.Error(w, "clipboard is read-only", http.StatusLocked)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/copybridge/copybridge-server/internal/mdns"
+)
+
+// startMDNS advertises the server on the local network if MDNS_ENABLED is set.
+// Failing to join the multicast group is not fatal, since the server still
+// works for clients that know its address.
+func (s *Server) startMDNS() {
+	cfg := mdns.ConfigFromEnv(s.port)
+	if !cfg.Enabled {
+		return
+	}
+
+	responder, err := mdns.Listen(cfg)
+	if err != nil {
+		log.Printf("cannot advertise server with mDNS: %v", err)
+		return
+	}
+	go responder.Run(context.Background())
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/metrics"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+var (
+	httpRequests = metrics.NewCounter("copybridge_http_requests_total",
+		"HTTP requests by method, route pattern and status code.", "method", "route", "status")
+	httpDuration = metrics.NewHistogram("copybridge_http_request_duration_seconds",
+		"Time spent serving HTTP requests, by method and route pattern.", metrics.DurationBuckets, "method", "route")
+	janitorDeletions = metrics.NewCounter("copybridge_janitor_deletions_total",
+		"Records deleted by the retention and cleanup jobs, by kind.", "kind")
+	bandwidthBytes = metrics.NewCounter("copybridge_bandwidth_bytes_total",
+		"Bytes of request and response bodies metered for bandwidth quotas, by direction.", "direction")
+	bandwidthRejections = metrics.NewCounter("copybridge_bandwidth_rejections_total",
+		"Requests refused because a bandwidth quota was used up.")
+)
+
+// measure counts requests and their durations per route. Requests matching
+// no route are counted with the route "unmatched", so scanners probing
+// random paths do not create a series per path.
+func (s *Server) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.Inc(r.Method, route, strconv.Itoa(status))
+		httpDuration.Since(start, r.Method, route)
+	})
+}
+
+// MetricsHandler exports the metrics of the server in the Prometheus text
+// format.
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	_ = metrics.Write(w)
+}
+
+// countDeleted counts n records of a kind deleted by a job, passing err
+// through.
+func countDeleted(kind string, n int, err error) error {
+	if err == nil && n > 0 {
+		janitorDeletions.Add(float64(n), kind)
+	}
+	return err
+}
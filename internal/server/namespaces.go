@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/retention"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// namespaceHeader names the namespace of anonymous requests. The /ns/{name}
+// path prefix is turned into it, see resolveNamespace.
+const namespaceHeader = "X-Namespace"
+
+// namespaceConfig holds the settings of a namespace, which default to the
+// global ones.
+type namespaceConfig struct {
+	quota     quota
+	retention retention.Policy
+}
+
+// namespacesFromEnv reads the namespaces of NAMESPACES and the default one.
+// Each may override the QUOTA_* and RETENTION_* rules with variables
+// prefixed with NAMESPACE_<NAME>_, where dashes in the name become
+// underscores.
+func namespacesFromEnv(q quota, policy retention.Policy) (map[string]*namespaceConfig, error) {
+	names := append([]string{account.DefaultNamespace}, env.List("NAMESPACES")...)
+	namespaces := make(map[string]*namespaceConfig, len(names))
+	for _, name := range names {
+		if !account.ValidNamespace(name) {
+			return nil, fmt.Errorf("invalid namespace %q", name)
+		}
+		prefix := "NAMESPACE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		namespaces[name] = &namespaceConfig{
+			quota:     quotaFromEnv(prefix, q),
+			retention: policy.Override(prefix),
+		}
+	}
+	return namespaces, nil
+}
+
+// quotaOf returns the quota of a namespace.
+func (s *Server) quotaOf(namespace string) quota {
+	if ns, ok := s.namespaces[namespace]; ok {
+		return ns.quota
+	}
+	return s.namespaces[account.DefaultNamespace].quota
+}
+
+// namespaceNames returns the names of all namespaces, sorted.
+func (s *Server) namespaceNames() []string {
+	names := make([]string, 0, len(s.namespaces))
+	for name := range s.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveNamespace stores the namespace a request is made in in its context.
+// Requests name it with the X-Namespace header or a /ns/{name} prefix of the
+// path, which is stripped and turned into the header, so the request is
+// routed as usual and replicas forward the namespace to the primary.
+// Requests naming neither are in the default namespace. Unknown namespaces
+// are not found.
+func (s *Server) resolveNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/ns/"); ok {
+			name, path, _ := strings.Cut(rest, "/")
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + path
+			r.URL.RawPath = ""
+			r.Header.Set(namespaceHeader, name)
+		}
+
+		name := r.Header.Get(namespaceHeader)
+		if name == "" {
+			name = account.DefaultNamespace
+		}
+		if _, ok := s.namespaces[name]; !ok {
+			validation.Error(w, "namespace not found", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), namespaceContextKey, name)))
+	})
+}
+
+// namespacePath returns the path prefix of links into a namespace, which is
+// empty for the default namespace.
+func namespacePath(namespace string) string {
+	if namespace == "" || namespace == account.DefaultNamespace {
+		return ""
+	}
+	return "/ns/" + namespace
+}
+
+// currentNamespace returns the namespace of the request: the one of its
+// user, or for anonymous requests the one resolveNamespace found.
+func currentNamespace(r *http.Request) string {
+	if u := currentUser(r); u != nil && u.Namespace != "" {
+		return u.Namespace
+	}
+	if name, ok := r.Context().Value(namespaceContextKey).(string); ok {
+		return name
+	}
+	return account.DefaultNamespace
+}
+
+// checkNamespace responds with 403 and returns false if the request names a
+// namespace other than the one of its user, whose keys only work in their
+// own namespace.
+func checkNamespace(w http.ResponseWriter, r *http.Request, u *account.User) bool {
+	name := r.Header.Get(namespaceHeader)
+	if name == "" || name == u.Namespace {
+		return true
+	}
+	validation.Error(w, "user "+u.Name+" does not belong to namespace "+name, http.StatusForbidden)
+	return false
+}
+
+// inNamespace reports whether the request may see a clipboard, which it can
+// only if it is made in the namespace of the clipboard. Clipboard tokens are
+// bound to their clipboard instead, and administrators see all namespaces.
+func inNamespace(r *http.Request, c *clipboard.Clipboard) bool {
+	if t := currentToken(r); t != nil && t.ClipboardId == c.Id {
+		return true
+	}
+	if isAdmin(r) {
+		return true
+	}
+	return c.Namespace == currentNamespace(r)
+}
+
+// retentionEnabled reports whether any namespace has retention rules.
+func (s *Server) retentionEnabled() bool {
+	for _, ns := range s.namespaces {
+		if ns.retention.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRetention applies the retention policy of every namespace in turn.
+func (s *Server) applyRetention(ctx context.Context, now time.Time) error {
+	for _, name := range s.namespaceNames() {
+		policy := s.namespaces[name].retention
+		if !policy.Enabled() {
+			continue
+		}
+		if _, err := policy.Apply(ctx, retention.InNamespace(retentionStore{s.db, s}, name), now); err != nil {
+			return fmt.Errorf("namespace %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// retentionStore deletes clipboards for the retention rules, announcing the
+// deletions like those made through the API.
+type retentionStore struct {
+	database.Service
+	s *Server
+}
+
+func (rs retentionStore) Delete(ctx context.Context, id int) error {
+	c, err := rs.Service.Get(ctx, id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+	if err := rs.Service.Delete(ctx, id); err != nil {
+		return err
+	}
+	janitorDeletions.Inc("clipboards")
+	if c != nil {
+		rs.s.publish(events.ClipboardDeleted, c)
+	}
+	return nil
+}
+
+func (rs retentionStore) DeleteExpiredUploads(ctx context.Context, before time.Time) (int, error) {
+	n, err := rs.Service.DeleteExpiredUploads(ctx, before)
+	return n, countDeleted("uploads", n, err)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/notify"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type subscriptionBody struct {
+	Channel     string `json:"channel"`
+	Target      string `json:"target"`
+	IncludeData bool   `json:"include_data"`
+}
+
+// SubscriptionsHandler lists the notification subscriptions of the current
+// user to a clipboard.
+func (s *Server) SubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSubscribedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	subs, err := s.db.Subscriptions(r.Context(), c.Id, currentUserId(r))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(subs)
+	_, _ = w.Write(jsonResp)
+}
+
+// SubscribeHandler subscribes the current user to notifications about
+// updates of a clipboard they can read, through one of the configured
+// channels.
+func (s *Server) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSubscribedClipboard(w, r)
+	if c == nil {
+		return
+	}
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionRead); !ok {
+		return
+	}
+
+	var body subscriptionBody
+	if !s.decodeBody(w, r, &body, "channel", "target", "include_data") {
+		return
+	}
+
+	var errs validation.Errors
+	n, ok := s.notifiers[body.Channel]
+	switch {
+	case body.Channel == "":
+		errs.Add("channel", validation.CodeRequired, "channel is required")
+	case !ok:
+		errs.Add("channel", validation.CodeInvalid, "channel must be one of: "+strings.Join(s.channels(), ", "))
+	case body.Target == "":
+		errs.Add("target", validation.CodeRequired, "target is required")
+	default:
+		if err := n.Validate(body.Target); err != nil {
+			errs.Add("target", validation.CodeInvalid, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	sub := &clipboard.Subscription{
+		ClipboardId: c.Id,
+		UserId:      currentUserId(r),
+		Channel:     body.Channel,
+		Target:      body.Target,
+		IncludeData: body.IncludeData,
+	}
+	if err := s.db.CreateSubscription(r.Context(), sub); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(sub)
+	_, _ = w.Write(jsonResp)
+}
+
+// UnsubscribeHandler removes a notification subscription of the current user.
+func (s *Server) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSubscribedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "subscriptionId"))
+	if err != nil {
+		validation.Error(w, "invalid subscription id", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.db.DeleteSubscription(r.Context(), c.Id, currentUserId(r), id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		validation.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebPushKeyHandler returns the public VAPID key browsers subscribe to the
+// webpush channel with.
+func (s *Server) WebPushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.notifiers[notify.ChannelWebPush].(*notify.WebPush)
+	if !ok {
+		validation.Error(w, "web push notifications are not configured", http.StatusNotFound)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(map[string]string{"public_key": p.PublicKey()})
+	_, _ = w.Write(jsonResp)
+}
+
+// loadSubscribedClipboard loads a clipboard whose notifications are managed
+// by the current user. Subscriptions belong to users, so anonymous requests
+// are rejected.
+// If it cannot be loaded, it writes an error response and returns nil.
+func (s *Server) loadSubscribedClipboard(w http.ResponseWriter, r *http.Request) *clipboard.Clipboard {
+	if len(s.notifiers) == 0 {
+		validation.Error(w, "no notification channels are configured", http.StatusNotFound)
+		return nil
+	}
+	if currentUserId(r) == 0 {
+		validation.Error(w, "notifications require an API key or session", http.StatusUnauthorized)
+		return nil
+	}
+
+	return s.loadClipboard(w, r)
+}
+
+// channels returns the names of the configured notification channels.
+func (s *Server) channels() []string {
+	names := make([]string, 0, len(s.notifiers))
+	for name := range s.notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// startNotifications notifies subscribers about clipboard changes if a
+// notification channel is configured.
+func (s *Server) startNotifications() {
+	if len(s.notifiers) == 0 {
+		return
+	}
+
+	d := notify.NewDispatcher(s.notifiers, s.db.NotifiedSubscriptions, s.db.ExpireSubscription)
+	go d.Run(context.Background(), s.events)
+}
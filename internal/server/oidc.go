@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/oidc"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+const (
+	// oidcCookie carries the state of a login with the identity provider
+	// between OIDCLoginHandler and OIDCCallbackHandler.
+	oidcCookie = "copybridge_oidc"
+	// oidcAudience tells login states apart from access tokens, which are
+	// signed with the same secret.
+	oidcAudience = "copybridge-oidc-login"
+	// oidcLoginTTL is how long users have to log in at the provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+// oidcLogin configures logging in with an OpenID Connect provider.
+type oidcLogin struct {
+	provider *oidc.Provider
+	// usernameClaim is the claim matched against user names the first time
+	// a subject logs in. Later logins are mapped by the subject.
+	usernameClaim string
+	// namespace is the namespace of the users logging in.
+	namespace string
+	// createUsers creates users that do not exist yet on first login.
+	createUsers bool
+	// returnURLs are the URLs clients may have the tokens of their session
+	// handed to after logging in.
+	returnURLs []*url.URL
+}
+
+// oidcFromEnv configures logging in with the provider at OIDC_ISSUER, or
+// returns nil if it is not set.
+func oidcFromEnv(baseURL string) (*oidcLogin, error) {
+	issuer := env.String("OIDC_ISSUER", "")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	cfg := oidc.Config{
+		Issuer:       issuer,
+		ClientID:     env.String("OIDC_CLIENT_ID", ""),
+		ClientSecret: env.String("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  env.String("OIDC_REDIRECT_URL", ""),
+		Scopes:       strings.Fields(strings.ReplaceAll(env.String("OIDC_SCOPES", "openid profile email"), ",", " ")),
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID is required")
+	}
+	if cfg.RedirectURL == "" {
+		if baseURL == "" {
+			return nil, errors.New("OIDC_REDIRECT_URL or PUBLIC_URL is required")
+		}
+		cfg.RedirectURL = baseURL + "/auth/oidc/callback"
+	}
+
+	l := &oidcLogin{
+		provider:      oidc.New(cfg),
+		usernameClaim: env.String("OIDC_USERNAME_CLAIM", "preferred_username"),
+		namespace:     env.String("OIDC_NAMESPACE", account.DefaultNamespace),
+		createUsers:   env.Bool("OIDC_CREATE_USERS", false),
+	}
+	for _, entry := range strings.Split(env.String("OIDC_RETURN_URLS", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || u.Scheme == "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid return URL %q", entry)
+		}
+		l.returnURLs = append(l.returnURLs, u)
+	}
+	return l, nil
+}
+
+// allowedReturn reports whether tokens may be handed to a return URL: it
+// matches one of returnURLs, ignoring the port of loopback addresses as
+// native apps listen on any free port (RFC 8252).
+func (l *oidcLogin) allowedReturn(returnTo string) bool {
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Fragment != "" {
+		return false
+	}
+	for _, allowed := range l.returnURLs {
+		if u.Scheme != allowed.Scheme || u.Path != allowed.Path || u.RawQuery != allowed.RawQuery || u.User != nil {
+			continue
+		}
+		if u.Host == allowed.Host {
+			return true
+		}
+		if allowed.Port() == "" && u.Hostname() == allowed.Hostname() {
+			if ip := net.ParseIP(u.Hostname()); ip != nil && ip.IsLoopback() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcState is the state of a login, kept in a signed cookie so any
+// instance of the server can complete it.
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// OIDCLoginHandler sends the user to the identity provider to log in. With
+// ?return_to=, the tokens of the session are handed to that URL once logged
+// in, if it is one of OIDC_RETURN_URLS.
+func (s *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		validation.Error(w, "single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	returnTo := r.URL.Query().Get("return_to")
+	if returnTo != "" && !s.oidc.allowedReturn(returnTo) {
+		validation.Error(w, "return_to is not an allowed URL", http.StatusBadRequest)
+		return
+	}
+
+	var st oidcState
+	for _, v := range []*string{&st.State, &st.Nonce, &st.Verifier} {
+		var err error
+		if *v, err = oidc.NewVerifier(); err != nil {
+			validation.Error(w, "cannot start login", http.StatusInternalServerError)
+			return
+		}
+	}
+	st.ReturnTo = returnTo
+	now := time.Now()
+	st.RegisteredClaims = jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{oidcAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(oidcLoginTTL)),
+	}
+
+	authURL, err := s.oidc.provider.AuthURL(r.Context(), st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		log.Printf("identity provider unavailable: %v", err)
+		validation.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	cookie, err := s.tokens.Sign(st)
+	if err != nil {
+		validation.Error(w, "cannot start login", http.StatusInternalServerError)
+		return
+	}
+
+	s.setOIDCCookie(w, r, cookie, int(oidcLoginTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler completes a login at the identity provider, which
+// redirects back here, and responds with the tokens of a new session like
+// LoginHandler, or hands them to the return URL of the login in its
+// fragment.
+// Sessions count as logged in with two-factor authentication if the provider
+// reports multi-factor authentication. Users who enabled it locally are
+// refused otherwise.
+func (s *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		validation.Error(w, "single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	var st oidcState
+	cookie, err := r.Cookie(oidcCookie)
+	if err == nil {
+		err = s.tokens.Parse(cookie.Value, oidcAudience, &st)
+	}
+	// The state is not needed past the callback, whatever its outcome.
+	s.setOIDCCookie(w, r, "", -1)
+	if err != nil || st.State == "" || r.URL.Query().Get("state") != st.State {
+		validation.Error(w, "invalid or expired login state, start again", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		msg := "login refused by the identity provider: " + e
+		if desc := query.Get("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		validation.Error(w, msg, http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.oidc.provider.Exchange(r.Context(), query.Get("code"), st.Verifier, st.Nonce)
+	if err != nil {
+		log.Printf("identity provider login failed: %v", err)
+		validation.Error(w, "identity provider login failed", http.StatusUnauthorized)
+		return
+	}
+
+	u := s.oidcUser(r.Context(), w, claims)
+	if u == nil {
+		return
+	}
+	mfa := claims.MFA()
+	if u.TOTPEnabled && !mfa {
+		validation.Error(w, "the identity provider did not report multi-factor authentication, which the user requires", http.StatusForbidden)
+		return
+	}
+
+	if st.ReturnTo == "" {
+		s.startSession(r.Context(), w, u, mfa)
+		return
+	}
+
+	sessionId, refreshToken, ok := s.createSession(r.Context(), w, u, mfa)
+	if !ok {
+		return
+	}
+	accessToken, err := s.tokens.Access(u, sessionId, time.Now())
+	if err != nil {
+		validation.Error(w, "cannot issue access token", http.StatusInternalServerError)
+		return
+	}
+	fragment := url.Values{
+		"access_token":  {accessToken},
+		"refresh_token": {refreshToken},
+		"token_type":    {"Bearer"},
+		"expires_in":    {strconv.Itoa(int(s.tokens.AccessTTL.Seconds()))},
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, st.ReturnTo+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// oidcUser returns the user an identity logs in as. Subjects are linked to
+// the user whose name matches their username claim the first time they log
+// in, and stay linked to that user.
+// If there is no such user, it writes an error response and returns nil.
+func (s *Server) oidcUser(ctx context.Context, w http.ResponseWriter, claims oidc.Claims) *account.User {
+	issuer, subject := s.oidc.provider.Issuer(), claims.Subject()
+
+	userId, err := s.db.IdentityUser(ctx, issuer, subject)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil
+	}
+	if userId != 0 {
+		u, err := s.db.User(ctx, userId)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return nil
+		}
+		if u == nil {
+			validation.Error(w, "user not found", http.StatusForbidden)
+		}
+		return u
+	}
+
+	claim := s.oidc.usernameClaim
+	name := strings.TrimSpace(claims.String(claim))
+	if name == "" {
+		validation.Error(w, "the identity provider sent no "+claim+" claim", http.StatusForbidden)
+		return nil
+	}
+	if claim == "email" && !claims.Bool("email_verified") {
+		validation.Error(w, "the identity provider has not verified the email address", http.StatusForbidden)
+		return nil
+	}
+
+	u, err := s.db.UserByName(ctx, s.oidc.namespace, name)
+	if errors.Is(err, database.ErrNotFound) && s.oidc.createUsers {
+		u, err = s.db.EnsureUser(ctx, s.oidc.namespace, name)
+	}
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil
+	}
+	if u == nil {
+		validation.Error(w, "user "+name+" does not exist", http.StatusForbidden)
+		return nil
+	}
+
+	linked, err := s.db.LinkIdentity(ctx, issuer, subject, u.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil
+	}
+	if !linked {
+		validation.Error(w, "user "+name+" is linked to another identity", http.StatusForbidden)
+		return nil
+	}
+	return u
+}
+
+// setOIDCCookie sets the login state cookie for maxAge seconds, or deletes
+// it if maxAge is negative.
+func (s *Server) setOIDCCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(s.baseURL, "https://"),
+		// The provider redirects back with a top-level navigation, which
+		// Lax cookies are sent with.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// AdminUnlinkIdentitiesHandler removes the links of a user to identity
+// providers, so they can log in with a new account at the provider.
+func (s *Server) AdminUnlinkIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := s.db.UserByName(r.Context(), adminNamespace(r), chi.URLParam(r, "user"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	ok, err := s.db.UnlinkIdentities(r.Context(), u.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		validation.Error(w, "user has no linked identities", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
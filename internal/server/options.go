@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Option customizes a server created with New, for programs embedding it
+// in their own.
+type Option func(*Server)
+
+// mount is a handler routed to by a pattern next to the routes of the
+// server, see WithMount.
+type mount struct {
+	pattern string
+	handler http.Handler
+}
+
+// WithPrefix serves the routes under prefix, e.g. "/clipboard-sync", for
+// programs mounting the server at that path of their own. Links and
+// redirects the server responds with include it, but PUBLIC_URL, if set,
+// must too.
+func WithPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithMiddleware adds middleware to all routes. It runs after the built-in
+// middleware, so requests are already identified and filtered, in the order
+// given.
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, middleware...)
+	}
+}
+
+// WithMount routes requests matching pattern, e.g. "/metrics", to handler,
+// next to the routes of the server and behind its middleware. Patterns
+// conflicting with built-in routes make RegisterRoutes panic.
+func WithMount(pattern string, handler http.Handler) Option {
+	return func(s *Server) {
+		s.mounts = append(s.mounts, mount{pattern: pattern, handler: handler})
+	}
+}
+
+// path returns the path p of the routes of the server as clients request
+// it, i.e. with the prefix the server is mounted at.
+func (s *Server) path(p string) string {
+	return s.prefix + p
+}
+
+// embed serves routes without the prefix the server is mounted at, and
+// with a routing context of their own: chi routers mounting them would pass
+// on theirs, and the routes would be matched on its path rather than the
+// one rewritten by resolveNamespace.
+func (s *Server) embed(routes http.Handler) http.Handler {
+	if s.prefix != "" {
+		routes = http.StripPrefix(s.prefix, routes)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, nil)))
+	})
+}
+
+// RequestUser returns the user a request is made by, or nil if it is
+// anonymous, for the middleware and mounts of programs embedding the
+// server.
+func RequestUser(r *http.Request) *account.User {
+	return currentUser(r)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// pairingKey is the key failed claims from all clients are tracked under.
+const pairingKey = "*"
+
+type pairingResponse struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int       `json:"expires_in"`
+}
+
+type claimBody struct {
+	Code string `json:"code"`
+}
+
+// PairHandler issues a short pairing code for the current user. Entering
+// the code on another device with ClaimPairingHandler logs that device in
+// as the same user, without typing an API key.
+func (s *Server) PairHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		validation.Error(w, "an API key or session is required to pair devices", http.StatusUnauthorized)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(s.pairingTTL)
+	// Retry the unlikely collision with an outstanding code.
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err := account.NewPairingCode()
+		if err != nil {
+			validation.Error(w, "pairing code generation failed", http.StatusInternalServerError)
+			return
+		}
+
+		ok, err := s.db.CreatePairing(r.Context(), account.HashKey(code), u.Id, expiresAt)
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			w.WriteHeader(http.StatusCreated)
+			jsonResp, _ := json.Marshal(pairingResponse{
+				Code:      code,
+				ExpiresAt: expiresAt,
+				ExpiresIn: int(s.pairingTTL.Seconds()),
+			})
+			_, _ = w.Write(jsonResp)
+			return
+		}
+	}
+
+	validation.Error(w, "too many outstanding pairing codes", http.StatusServiceUnavailable)
+}
+
+// ClaimPairingHandler exchanges a pairing code for a session of the user who
+// requested it, like LoginHandler. Every code works once.
+// Codes are short, so failed claims lock out the client and, past a higher
+// limit, pairing altogether.
+func (s *Server) ClaimPairingHandler(w http.ResponseWriter, r *http.Request) {
+	ip := s.clientIP(r)
+	if wait := max(s.pairingIPFailures.Locked(ip), s.pairingFailures.Locked(pairingKey)); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	var body claimBody
+	if !s.decodeBody(w, r, &body, "code") {
+		return
+	}
+	code := account.NormalizePairingCode(body.Code)
+	if code == "" {
+		var errs validation.Errors
+		errs.Add("code", validation.CodeInvalid, "code must have 6 digits")
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	userId, err := s.db.ClaimPairing(r.Context(), account.HashKey(code))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if userId == 0 {
+		s.pairingFailures.Fail(pairingKey)
+		if wait := s.pairingIPFailures.Fail(ip); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+		validation.Error(w, "invalid or expired pairing code", http.StatusUnauthorized)
+		return
+	}
+	s.pairingIPFailures.Succeed(ip)
+
+	u, err := s.db.User(r.Context(), userId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "invalid or expired pairing code", http.StatusUnauthorized)
+		return
+	}
+
+	s.startSession(r.Context(), w, u, false)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// PasswordHandler changes the password of an encrypted clipboard, given its
+// current password with Basic Auth and the new one in the body. Only the
+// data key of the clipboard is wrapped again, so its data, flavors and
+// items are not encrypted again and its version is unchanged. Like locking,
+// only the owner may change the password of owned clipboards.
+func (s *Server) PasswordHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionUpdate)
+	if !ok {
+		return
+	}
+	if c.OwnerId != 0 && (currentToken(r) != nil || currentUserId(r) != c.OwnerId) {
+		s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeForbidden)
+		validation.Error(w, "only the owner can change the password of a clipboard", http.StatusForbidden)
+		return
+	}
+	if !c.IsEncrypted {
+		validation.Error(w, "clipboard is not encrypted", http.StatusConflict)
+		return
+	}
+	if !checkWritable(w, c) {
+		return
+	}
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	if !s.decodeBody(w, r, &body, "password") {
+		return
+	}
+	if body.Password == "" {
+		var errs validation.Errors
+		errs.Add("password", validation.CodeRequired, "password is required")
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	_, span := telemetry.Start(r.Context(), "crypto.ChangePassword")
+	err := c.ChangePassword(password, body.Password)
+	telemetry.End(span, err)
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.SetPassword")
+	err = s.db.SetPassword(ctx, c)
+	telemetry.End(span, err)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type grantBody struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// PermissionsHandler lists the users an owned clipboard is shared with.
+func (s *Server) PermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSharedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	ps, err := s.db.Permissions(r.Context(), c.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(ps)
+	_, _ = w.Write(jsonResp)
+}
+
+// GrantHandler shares an owned clipboard with another user, who gets read or
+// write access. Granting a user who already has access changes their role.
+func (s *Server) GrantHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSharedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	var body grantBody
+	if !s.decodeBody(w, r, &body, "user", "role") {
+		return
+	}
+	if !clipboard.ValidRole(body.Role) {
+		validation.Error(w, "role must be read or write", http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.db.UserByName(r.Context(), c.Namespace, body.User)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if u.Id == c.OwnerId {
+		validation.Error(w, "the owner already has full access", http.StatusBadRequest)
+		return
+	}
+
+	p := &clipboard.Permission{ClipboardId: c.Id, UserId: u.Id, User: u.Name, Role: body.Role}
+	if err := s.db.SetPermission(r.Context(), p); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionShare, clipboard.OutcomeSuccess)
+
+	jsonResp, _ := json.Marshal(p)
+	_, _ = w.Write(jsonResp)
+}
+
+// RevokeHandler stops sharing an owned clipboard with a user.
+func (s *Server) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSharedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	u, err := s.db.UserByName(r.Context(), c.Namespace, chi.URLParam(r, "user"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.RemovePermission(r.Context(), c.Id, u.Id); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionShare, clipboard.OutcomeSuccess)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadSharedClipboard loads a clipboard whose permissions are managed by the
+// current user. Only owned clipboards can be shared, by their owner.
+// If it cannot be loaded, it writes an error response and returns nil.
+func (s *Server) loadSharedClipboard(w http.ResponseWriter, r *http.Request) *clipboard.Clipboard {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return nil
+	}
+
+	if c.OwnerId == 0 {
+		validation.Error(w, "only owned clipboards can be shared", http.StatusBadRequest)
+		return nil
+	}
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionShare); !ok {
+		return nil
+	}
+
+	return c
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// PinHandler pins a clipboard with POST and unpins it with DELETE.
+// Pinned clipboards are listed first and never deleted by retention.
+func (s *Server) PinHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+
+	if err := s.db.SetPinned(r.Context(), c.Id, r.Method == http.MethodPost); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"context"
+
+	"github.com/copybridge/copybridge-server/internal/preview"
+)
+
+// startPreviews fetches the titles of links written to clipboards if
+// PREVIEW_FETCH_TITLES is set.
+func (s *Server) startPreviews() {
+	cfg := preview.ConfigFromEnv()
+	if !cfg.FetchTitles {
+		return
+	}
+
+	f := preview.NewFetcher(cfg, s.db.SetTitle)
+	go f.Run(context.Background(), s.events)
+}
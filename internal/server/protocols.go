@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// serveCleartext serves plaintext HTTP. With HTTP2_CLEARTEXT, it also
+// accepts HTTP/2 without TLS (h2c), for reverse proxies that terminate TLS
+// and talk HTTP/2 to the server.
+func serveCleartext(server *http.Server) error {
+	if env.Bool("HTTP3_ENABLED", false) {
+		log.Printf("HTTP/3 requires TLS, not serving it")
+	}
+	if env.Bool("HTTP2_CLEARTEXT", false) {
+		server.Handler = h2c.NewHandler(server.Handler, &http2.Server{IdleTimeout: server.IdleTimeout})
+	}
+	return server.ListenAndServe()
+}
+
+// serveTLS serves HTTPS with server.TLSConfig and the certificate in
+// certFile and keyFile, if given. With HTTP3_ENABLED, it also serves
+// experimental HTTP/3 over QUIC on the same port, or UDP HTTP3_ADDR, and
+// advertises it to HTTPS clients with Alt-Svc headers.
+func serveTLS(server *http.Server, certFile, keyFile string) error {
+	if !env.Bool("HTTP3_ENABLED", false) {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	h3 := &http3.Server{
+		Addr:      env.String("HTTP3_ADDR", server.Addr),
+		Handler:   server.Handler,
+		TLSConfig: http3.ConfigureTLSConfig(server.TLSConfig),
+	}
+	server.Handler = advertiseHTTP3(h3, server.Handler)
+	server.RegisterOnShutdown(func() {
+		_ = h3.Close()
+	})
+
+	go func() {
+		log.Printf("Serving experimental HTTP/3 on UDP %s", h3.Addr)
+		if err := h3.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP/3 listener stopped: %v", err)
+		}
+	}()
+
+	return server.ListenAndServeTLS("", "")
+}
+
+// advertiseHTTP3 sets the Alt-Svc header telling clients that h3 serves
+// HTTP/3, once it listens.
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = h3.SetQuicHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	maxQRSize     = 1024
+	// maxQRText is the largest clipboard encoded as raw text, well below the
+	// capacity of a QR code so it stays scannable from a screen.
+	maxQRText = 1024
+)
+
+// QRHandler renders a QR code for sharing a clipboard to a phone.
+// By default the code encodes the URL of the clipboard; with content=text it
+// encodes the clipboard data itself, for small clipboards.
+// The format query parameter selects png (default) or svg output.
+func (s *Server) QRHandler(w http.ResponseWriter, r *http.Request) {
+	size, err := queryInt(r, "size", defaultQRSize, maxQRSize)
+	if err != nil || size == 0 {
+		validation.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		validation.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	var content string
+	switch r.URL.Query().Get("content") {
+	case "", "url":
+		content = s.publicURL(r) + namespacePath(c.Namespace) + "/clipboard/" + c.PublicId
+	case "text":
+		if !s.readClipboard(w, r, c) {
+			return
+		}
+		if len(c.Data) > maxQRText {
+			validation.Error(w, fmt.Sprintf("clipboard larger than %d bytes cannot be encoded as text", maxQRText), http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+		content = c.Data
+	default:
+		validation.Error(w, "invalid content", http.StatusBadRequest)
+		return
+	}
+
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		validation.Error(w, "QR code generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	// The code may contain clipboard data, so it must not be cached by proxies.
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		_, _ = w.Write([]byte(qrSVG(qr.Bitmap(), size)))
+		return
+	}
+
+	png, err := qr.PNG(size)
+	if err != nil {
+		validation.Error(w, "QR code generation failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(png)
+}
+
+// qrSVG renders a QR code bitmap as an SVG image of the given size.
+func qrSVG(bitmap [][]bool, size int) string {
+	n := len(bitmap)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+
+	return b.String()
+}
+
+// publicURLFromEnv returns PUBLIC_URL without a trailing slash. It must be
+// an absolute http or https URL, as share links and QR codes are built from
+// it. Without it, they are built from the Host header of each request, which
+// clients choose, so that is only meant for trying the server out.
+func publicURLFromEnv() (string, error) {
+	raw := strings.TrimSuffix(env.String("PUBLIC_URL", ""), "/")
+	if raw == "" {
+		log.Printf("PUBLIC_URL is not set, share links and QR codes will use the host requests are sent to")
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("invalid PUBLIC_URL: must be an absolute http or https URL")
+	}
+	return raw, nil
+}
+
+// publicURL returns the base URL clients reach the server at: PUBLIC_URL if
+// configured, or otherwise the host the request was sent to and the prefix
+// the server is mounted at.
+func (s *Server) publicURL(r *http.Request) string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.prefix
+}
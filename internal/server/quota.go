@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// quota limits the storage a user can take up, and the bytes they may
+// transfer per day, see meterBandwidth. Zero values mean unlimited.
+// The anonymous clipboards of a namespace share a single quota.
+type quota struct {
+	MaxClipboards    int   `json:"max_clipboards"`
+	MaxBytes         int64 `json:"max_bytes"`
+	MaxClipboardSize int   `json:"max_clipboard_size"`
+	MaxBandwidth     int64 `json:"max_bandwidth"`
+}
+
+// quotaFromEnv reads the QUOTA_* variables with the given prefix, keeping
+// the limits of def for unset ones.
+func quotaFromEnv(prefix string, def quota) quota {
+	return quota{
+		MaxClipboards:    env.Int(prefix+"QUOTA_MAX_CLIPBOARDS", def.MaxClipboards),
+		MaxBytes:         env.Int64(prefix+"QUOTA_MAX_BYTES", def.MaxBytes),
+		MaxClipboardSize: env.Int(prefix+"QUOTA_MAX_CLIPBOARD_SIZE", def.MaxClipboardSize),
+		MaxBandwidth:     env.Int64(prefix+"QUOTA_MAX_BANDWIDTH", def.MaxBandwidth),
+	}
+}
+
+// checkClipboardSize responds with 413 and returns false if data is larger
+// than the maximum clipboard size of the namespace.
+func (s *Server) checkClipboardSize(w http.ResponseWriter, namespace, data string) bool {
+	if q := s.quotaOf(namespace); q.MaxClipboardSize > 0 && len(data) > q.MaxClipboardSize {
+		validation.Error(w, "clipboard too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
+}
+
+var (
+	errClipboardQuota = errors.New("clipboard quota exceeded")
+	errStorageQuota   = errors.New("storage quota exceeded")
+)
+
+// checkType responds with 415 and returns false if the data type is not
+// allowed or data does not look like it.
+func (s *Server) checkType(w http.ResponseWriter, dataType, data string) bool {
+	if err := s.types.Check(dataType, data); err != nil {
+		validation.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+// checkData validates the fields of a clipboard sent by a client, including
+// its size within its namespace, and checks that the types of its data and
+// flavors are allowed. If they are invalid, it writes an error response and
+// returns false.
+func (s *Server) checkData(w http.ResponseWriter, c *clipboard.Clipboard, requireName bool) bool {
+	errs := validation.Clipboard(c, validation.ClipboardRules{
+		RequireName: requireName,
+		MaxSize:     s.quotaOf(c.Namespace).MaxClipboardSize,
+	})
+	if len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return false
+	}
+
+	if !s.checkType(w, c.DataType, c.Data) {
+		return false
+	}
+	for _, f := range c.Flavors {
+		if !s.checkType(w, f.DataType, f.Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkQuota responds with 403 and returns false if storing added more
+// clipboards and delta more bytes for the owner would exceed its quota in
+// the namespace.
+func (s *Server) checkQuota(ctx context.Context, w http.ResponseWriter, namespace string, ownerId, added int, delta int64) bool {
+	err := s.enforceQuota(ctx, namespace, ownerId, added, delta)
+	switch {
+	case err == errClipboardQuota || err == errStorageQuota:
+		validation.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	case err != nil:
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// enforceQuota returns errClipboardQuota or errStorageQuota if storing added
+// more clipboards and delta more bytes for the owner would exceed its quota
+// in the namespace.
+func (s *Server) enforceQuota(ctx context.Context, namespace string, ownerId, added int, delta int64) error {
+	q := s.quotaOf(namespace)
+	if q.MaxClipboards == 0 && q.MaxBytes == 0 {
+		return nil
+	}
+
+	count, bytes, err := s.db.Usage(ctx, namespace, ownerId)
+	if err != nil {
+		return err
+	}
+
+	if q.MaxClipboards > 0 && count+added > q.MaxClipboards {
+		return errClipboardQuota
+	}
+	if q.MaxBytes > 0 && bytes+delta > q.MaxBytes {
+		return errStorageQuota
+	}
+
+	return nil
+}
+
+func (s *Server) QuotaHandler(w http.ResponseWriter, r *http.Request) {
+	namespace := currentNamespace(r)
+	count, bytes, err := s.db.Usage(r.Context(), namespace, currentUserId(r))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		quota
+		Clipboards int   `json:"clipboards"`
+		Bytes      int64 `json:"bytes"`
+		Bandwidth  int64 `json:"bandwidth"`
+	}{s.quotaOf(namespace), count, bytes, s.bandwidth.Used(s.bandwidthKey(r), time.Now())}
+
+	jsonResp, _ := json.Marshal(resp)
+	_, _ = w.Write(jsonResp)
+}
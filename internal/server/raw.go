@@ -0,0 +1,444 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/convert"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+const (
+	// sniffSize is how much of a streamed body is inspected to check its type.
+	sniffSize = 512
+	// trustPeekSize is how much of a streamed clipboard is inspected to
+	// assess its trust level.
+	trustPeekSize = 64 << 10
+)
+
+// GetRawHandler streams the data of a clipboard as the response body, with
+// the clipboard type as Content-Type, instead of embedding it in JSON.
+// If the clipboard has flavors, the one the Accept header prefers is served.
+// The data itself comes with a Content-Disposition header if the clipboard
+// has a filename or disposition, and any data with a Content-Language header
+// if the clipboard has a locale. Active types such as HTML are downloaded as
+// attachments, see protectData. With ?as=, the data is converted instead,
+// see convertClipboard.
+func (s *Server) GetRawHandler(w http.ResponseWriter, r *http.Request) {
+	as, err := queryConversion(r)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionRead)
+	if !ok || !confirmQuarantine(w, r, c) {
+		return
+	}
+
+	data, err := s.openData(r, c, password)
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+	defer data.Close()
+
+	if as != "" {
+		s.writeConverted(w, r, c, data, as)
+		return
+	}
+
+	// The types are negotiated once opened, as clipboards encrypted with
+	// clipboard.ScopeAll seal theirs.
+	types := c.Types()
+	flavor := clipboard.Negotiate(r.Header.Get("Accept"), types)
+	if len(types) > 1 {
+		w.Header().Add("Vary", "Accept")
+	}
+	if flavor < 0 {
+		validation.Error(w, "clipboard not available as "+r.Header.Get("Accept")+", available as "+strings.Join(types, ", "), http.StatusNotAcceptable)
+		return
+	}
+	contentType := types[flavor]
+
+	// Flavors are never streamed and were decrypted along with the data.
+	dataType, content, length := c.DataType, io.Reader(data), len(c.Data)
+	if flavor > 0 {
+		f := c.Flavors[flavor-1]
+		dataType, content, length = f.DataType, strings.NewReader(f.Data), len(f.Data)
+	}
+
+	br := bufio.NewReaderSize(content, trustPeekSize)
+	head, err := br.Peek(trustPeekSize)
+	if err != nil && err != io.EOF {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+	if !confirmTrust(w, r, s.trust.AssessData(dataType, string(head))) {
+		return
+	}
+
+	// Unencrypted data is downloaded from the blob store directly if it
+	// supports presigned URLs.
+	if s.presignTTL > 0 && !c.IsEncrypted && flavor == 0 {
+		ctx, span := telemetry.Start(r.Context(), "db.DataURL")
+		url, err := s.db.DataURL(ctx, c, contentType, s.presignTTL)
+		telemetry.End(span, err)
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if url != "" {
+			s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+	s.extendDeadlines(w)
+
+	w.Header().Set("Content-Type", contentType)
+	var disposition string
+	if flavor == 0 {
+		disposition = c.DispositionHeader()
+	}
+	if disposition = protectData(w.Header(), contentType, disposition); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	if c.Locale != "" {
+		w.Header().Set("Content-Language", c.Locale)
+	}
+	setETag(w, c)
+	switch {
+	case !c.Streamed:
+		w.Header().Set("Content-Length", strconv.Itoa(length))
+	case !c.IsEncrypted:
+		w.Header().Set("Content-Length", strconv.Itoa(c.Size))
+	}
+
+	if _, err := io.Copy(w, br); err != nil {
+		log.Printf("error streaming clipboard %d: %v", c.Id, err)
+	}
+}
+
+// writeConverted writes the data of a clipboard converted to target as the
+// response body.
+func (s *Server) writeConverted(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, data io.Reader, target string) {
+	converted, ok := s.convertClipboard(w, r, c, data, target)
+	if !ok {
+		return
+	}
+	dataType := convert.Type(target)
+	if !confirmTrust(w, r, s.trust.AssessData(dataType, string(converted[:min(len(converted), trustPeekSize)]))) {
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	w.Header().Set("Content-Type", dataType)
+	if disposition := protectData(w.Header(), dataType, ""); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	if c.Locale != "" {
+		w.Header().Set("Content-Language", c.Locale)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(converted)))
+	setETag(w, c)
+	_, _ = w.Write(converted)
+}
+
+// protectData sets the headers of a response with data of the given type,
+// which anyone may have stored, so browsers cannot run scripts in it on the
+// origin of the API and the web UI: the type is not sniffed, documents are
+// sandboxed, and active types are downloaded rather than shown. It returns
+// the Content-Disposition to serve the data with, which is disposition as
+// an attachment for active types.
+func protectData(h http.Header, dataType, disposition string) string {
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+	if !clipboard.ActiveType(dataType) {
+		return disposition
+	}
+	_, params, _ := mime.ParseMediaType(disposition)
+	return mime.FormatMediaType(clipboard.DispositionAttachment, params)
+}
+
+// PutRawHandler replaces the data of a clipboard with the request body,
+// streaming it to the blob store. The type of the clipboard is taken from
+// the Content-Type header if present, its filename and disposition from
+// the Content-Disposition header and its locale from the Content-Language
+// header. Text is converted to UTF-8 as it is streamed, see
+// clipboard.ParseText.
+func (s *Server) PutRawHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionUpdate)
+	if !ok || !checkWritable(w, c) || !checkVersion(w, r, c) || !s.openFields(w, r, c, password) {
+		return
+	}
+
+	if dataType := r.Header.Get("Content-Type"); dataType != "" {
+		c.DataType = dataType
+	}
+	if header := r.Header.Get("Content-Disposition"); header != "" {
+		disposition, filename, err := clipboard.ParseDisposition(header)
+		if err != nil {
+			validation.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.ContentDisposition, c.Filename = disposition, filename
+	}
+	if language := r.Header.Get("Content-Language"); language != "" {
+		locale, err := clipboard.NormalizeLocale(language)
+		if err != nil {
+			validation.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.Locale = locale
+	}
+	text, err := clipboard.ParseText(c.DataType)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	c.DataType, c.Charset = text.DataType, text.Charset
+
+	limit, limitErr, err := s.streamLimit(r.Context(), c)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	body := r.Body
+	if limitErr != nil {
+		if limit == 0 || r.ContentLength > limit {
+			validation.Error(w, limitErr.Error(), limitStatus(limitErr))
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	s.extendDeadlines(w)
+
+	br := bufio.NewReaderSize(text.Reader(body), sniffSize)
+	head, err := br.Peek(sniffSize)
+	if errors.Is(err, clipboard.ErrInvalidEncoding) {
+		validation.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil && err != io.EOF && !errors.As(err, new(*http.MaxBytesError)) {
+		validation.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !s.checkType(w, c.DataType, string(trimPartialRune(head))) {
+		return
+	}
+
+	var data io.Reader = br
+	// Only the first SCAN_MAX_SIZE bytes of streams are scanned, which are
+	// held in memory for it.
+	c.Quarantine = ""
+	if s.scan.Enabled() {
+		var head io.Reader = br
+		if s.scan.MaxSize > 0 {
+			head = io.LimitReader(br, int64(s.scan.MaxSize))
+		}
+		scanned, err := io.ReadAll(head)
+		if errors.As(err, new(*http.MaxBytesError)) {
+			validation.Error(w, limitErr.Error(), limitStatus(limitErr))
+			return
+		}
+		if errors.Is(err, clipboard.ErrInvalidEncoding) {
+			validation.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			validation.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		c.Quarantine, err = s.scanContent(r.Context(), string(scanned))
+		if !checkScan(w, err) {
+			return
+		}
+		data = io.MultiReader(bytes.NewReader(scanned), br)
+	}
+	if c.IsEncrypted {
+		_, span := telemetry.Start(r.Context(), "crypto.EncryptStream")
+		data, err = c.EncryptStream(password, data)
+		telemetry.End(span, err)
+		if err != nil {
+			validation.Error(w, "clipboard encryption failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.WriteData")
+	err = s.db.WriteData(ctx, c, data)
+	telemetry.End(span, err)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		validation.Error(w, limitErr.Error(), limitStatus(limitErr))
+		return
+	}
+	if errors.Is(err, clipboard.ErrInvalidEncoding) {
+		validation.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publish(events.ClipboardUpdated, c)
+
+	w.Header().Set("Content-Type", "application/json")
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(c)
+	_, _ = w.Write(jsonResp)
+}
+
+// streamLimit returns how many bytes may be streamed into a clipboard and
+// the error to respond with when the body is larger, which is nil if there
+// is no limit. The data currently stored counts towards the limit of the
+// owner, as it is replaced.
+func (s *Server) streamLimit(ctx context.Context, c *clipboard.Clipboard) (int64, error, error) {
+	var limit int64
+	var limitErr error
+	q := s.quotaOf(c.Namespace)
+	if q.MaxClipboardSize > 0 {
+		limit, limitErr = int64(q.MaxClipboardSize), errClipboardTooLarge
+	}
+
+	if q.MaxBytes > 0 {
+		_, used, err := s.db.Usage(ctx, c.Namespace, c.OwnerId)
+		if err != nil {
+			return 0, nil, err
+		}
+		remaining := max(q.MaxBytes-used+int64(c.Size), 0)
+		if limitErr == nil || remaining < limit {
+			limit, limitErr = remaining, errStorageQuota
+		}
+	}
+
+	return limit, limitErr, nil
+}
+
+var errClipboardTooLarge = errors.New("clipboard too large")
+
+// limitStatus returns the status code for a body exceeding a stream limit.
+func limitStatus(err error) int {
+	if err == errStorageQuota {
+		return http.StatusForbidden
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
+// openData returns a reader of the decrypted data of a clipboard, streamed
+// or not.
+func (s *Server) openData(r *http.Request, c *clipboard.Clipboard, password string) (io.ReadCloser, error) {
+	if !c.Streamed {
+		if c.IsEncrypted {
+			_, span := telemetry.Start(r.Context(), "crypto.Decrypt")
+			err := c.Decrypt(password)
+			telemetry.End(span, err)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return io.NopCloser(strings.NewReader(c.Data)), nil
+	}
+
+	if err := c.OpenFields(password); err != nil {
+		return nil, err
+	}
+	data, err := s.db.OpenData(r.Context(), c)
+	if err != nil || !c.IsEncrypted {
+		return data, err
+	}
+
+	plain, err := c.DecryptStream(password, data)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, data}, nil
+}
+
+// loadStreamed reads the data of a streamed clipboard into memory, for
+// clients using the JSON API. If the data cannot be read, it writes an
+// error response and returns false.
+func (s *Server) loadStreamed(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, password string) bool {
+	data, err := s.openData(r, c, password)
+	if err == nil {
+		var b []byte
+		b, err = io.ReadAll(data)
+		data.Close()
+		c.Data = string(b)
+	}
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return false
+	}
+
+	c.IsEncrypted = false
+	return true
+}
+
+// extendDeadlines lifts the server read and write timeouts for a streaming
+// request, which may legitimately take longer, up to STREAM_TIMEOUT.
+func (s *Server) extendDeadlines(w http.ResponseWriter) {
+	deadline := time.Now().Add(s.streamTimeout)
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence cut off at the end of
+// a prefix of a body, so sniffing does not mistake text for binary data.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// openFields decrypts the sealed name, type, filename and disposition of a
+// clipboard encrypted with clipboard.ScopeAll, so they are kept when its
+// data is replaced. If they cannot be decrypted, it writes an error
+// response and returns false.
+func (s *Server) openFields(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, password string) bool {
+	if err := c.OpenFields(password); err != nil {
+		s.decryptionFailed(w, r, c)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
+	"github.com/copybridge/copybridge-server/internal/relay"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// relayDeviceName matches the names devices connect to the relay with.
+var relayDeviceName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// relayMessage is a message sent to a device connected to the relay.
+type relayMessage struct {
+	Op string `json:"op"`
+	*relay.Offer
+}
+
+// checkRelay responds with an error and returns false if the relay is
+// disabled or the request has no user to relay between the devices of.
+func (s *Server) checkRelay(w http.ResponseWriter, r *http.Request) bool {
+	if s.relay == nil {
+		validation.Error(w, "relay is disabled", http.StatusNotFound)
+		return false
+	}
+	if currentUserId(r) == 0 {
+		validation.Error(w, "relay requires an API key or session", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// RelayHandler upgrades the request to a WebSocket connecting a device of
+// the user, named with ?device=, to the relay. The device is sent an offer
+// for every transfer sent to it, which it accepts by fetching
+// /relay/transfers/{transferId}. Nothing the device sends is read but pongs.
+func (s *Server) RelayHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkRelay(w, r) {
+		return
+	}
+	name := r.URL.Query().Get("device")
+	if !relayDeviceName.MatchString(name) {
+		validation.Error(w, "invalid device: must be 1 to 64 letters, digits, dots, dashes or underscores", http.StatusBadRequest)
+		return
+	}
+
+	d, err := s.relay.Connect(currentUserId(r), name)
+	if err == relay.ErrDeviceConnected {
+		validation.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	defer s.relay.Disconnect(d)
+
+	conn, err := syncUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(4096)
+		_ = conn.SetReadDeadline(time.Now().Add(syncPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(syncPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.Printf("relay: %v", err)
+				}
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(syncPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case offer := <-d.Offers():
+			_ = conn.SetWriteDeadline(time.Now().Add(syncWriteWait))
+			err = conn.WriteJSON(relayMessage{Op: "offer", Offer: &offer})
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(syncWriteWait))
+		case <-closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// RelayDevicesHandler lists the devices of the user connected to the relay.
+func (s *Server) RelayDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkRelay(w, r) {
+		return
+	}
+
+	jsonResp, _ := json.Marshal(s.relay.Devices(currentUserId(r)))
+	_, _ = w.Write(jsonResp)
+}
+
+// RelaySendHandler sends the request body to a connected device of the user
+// and waits for the device to accept it, up to RELAY_ACCEPT_TIMEOUT. The
+// body is streamed to the device as it is read and never stored, so the
+// response is only written once the device has received all of it. The data
+// type is taken from the Content-Type header, and ?from= may name the
+// sending device.
+func (s *Server) RelaySendHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkRelay(w, r) {
+		return
+	}
+	from := r.URL.Query().Get("from")
+	if from != "" && !relayDeviceName.MatchString(from) {
+		validation.Error(w, "invalid from: must be 1 to 64 letters, digits, dots, dashes or underscores", http.StatusBadRequest)
+		return
+	}
+	if q := s.quotaOf(currentNamespace(r)); q.MaxClipboardSize > 0 {
+		if r.ContentLength > int64(q.MaxClipboardSize) {
+			validation.Error(w, "clipboard too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(q.MaxClipboardSize))
+	}
+	dataType := r.Header.Get("Content-Type")
+	if dataType == "" {
+		dataType = "application/octet-stream"
+	}
+
+	s.extendDeadlines(w)
+
+	offer := relay.Offer{From: from, DataType: dataType, Size: r.ContentLength}
+	t, err := s.relay.Send(currentUserId(r), chi.URLParam(r, "device"), offer, r.Body)
+	switch {
+	case err == relay.ErrDeviceNotFound:
+		validation.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == relay.ErrBusy:
+		validation.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		validation.Error(w, "cannot start transfer", http.StatusInternalServerError)
+		return
+	}
+
+	timer := time.NewTimer(s.relayTimeout)
+	defer timer.Stop()
+	select {
+	case <-t.Done():
+	case <-timer.C:
+		if s.relay.Cancel(t) {
+			validation.Error(w, "device did not accept the transfer in time", http.StatusGatewayTimeout)
+			return
+		}
+		<-t.Done()
+	case <-r.Context().Done():
+		if s.relay.Cancel(t) {
+			return
+		}
+		<-t.Done()
+	}
+
+	n, err := t.Result()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == relay.ErrDeviceNotFound:
+		validation.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == relay.ErrDeclined:
+		validation.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.As(err, &tooLarge):
+		validation.Error(w, "clipboard too large", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		validation.Error(w, "transfer failed", http.StatusBadGateway)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(map[string]any{"id": t.Id, "size": n})
+	_, _ = w.Write(jsonResp)
+}
+
+// RelayReceiveHandler accepts a transfer offered to a device of the user and
+// streams its data as the sender sends it. If the transfer breaks off, the
+// response is aborted rather than ended, so partial data is not mistaken
+// for all of it.
+func (s *Server) RelayReceiveHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkRelay(w, r) {
+		return
+	}
+	t := s.relay.Accept(currentUserId(r), chi.URLParam(r, "transferId"))
+	if t == nil {
+		validation.Error(w, "transfer not found", http.StatusNotFound)
+		return
+	}
+
+	s.extendDeadlines(w)
+
+	w.Header().Set("Content-Type", t.DataType)
+	if disposition := protectData(w.Header(), t.DataType, ""); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if t.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(t.Size, 10))
+	}
+	if t.From != "" {
+		w.Header().Set("X-Relay-From", t.From)
+	}
+	if _, err := t.CopyTo(w); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// RelayDeclineHandler declines a transfer offered to a device of the user,
+// failing the request sending it with 409.
+func (s *Server) RelayDeclineHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkRelay(w, r) {
+		return
+	}
+	if !s.relay.Decline(currentUserId(r), chi.URLParam(r, "transferId")) {
+		validation.Error(w, "transfer not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
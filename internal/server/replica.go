@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// newPrimaryProxy returns a reverse proxy forwarding requests to the primary
+// at rawURL. The client IP is passed on in X-Forwarded-For, which the
+// primary honours if the replica is listed in its TRUSTED_PROXIES.
+func newPrimaryProxy(rawURL string) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+		return nil, errors.New("must be an http or https URL")
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("error proxying %s %s to primary: %v", r.Method, r.URL.Path, err)
+			validation.Error(w, "primary unreachable", http.StatusBadGateway)
+		},
+	}, nil
+}
+
+// proxyWrites serves reads from the local replica of the database and
+// forwards everything else to the primary: writes, sync connections, which
+// need the events of the primary, the relay, whose devices connect to the
+// primary, and single sign-on, which starts sessions.
+// Requests are forwarded as they are, so the primary authenticates them.
+func (s *Server) proxyWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if read && r.URL.Path != "/sync" && r.URL.Path != "/relay" && !strings.HasPrefix(r.URL.Path, "/relay/") && !strings.HasPrefix(r.URL.Path, "/auth/oidc/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Streamed uploads may take longer than the usual timeouts.
+		s.extendDeadlines(w)
+		s.primary.ServeHTTP(w, r)
+	})
+}
+
+// parseNetworks parses a comma-separated list of IP addresses and CIDR
+// ranges.
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether an address belongs to a trusted proxy.
+func (s *Server) trustedProxy(addr string) bool {
+	return containsIP(s.trustedProxies, addr)
+}
+
+// containsIP reports whether an address belongs to one of the networks.
+// Addresses that are not IP addresses belong to none.
+func containsIP(nets []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loadClipboard retrieves the clipboard identified by the id URL parameter,
+// either its public id, its numeric id or an alias of it. Clipboards of other namespaces,
+// the ones the request may not address by numeric id and the ones hidden by
+// their schedule are reported as not found, see inNamespace, numericAccess
+// and hidden.
+// If it cannot be retrieved, it writes an error response and returns nil.
+func (s *Server) loadClipboard(w http.ResponseWriter, r *http.Request) *clipboard.Clipboard {
+	return s.findClipboard(w, r, chi.URLParam(r, "id"))
+}
+
+// findClipboard retrieves a clipboard by its public id, numeric id or alias
+// as described by loadClipboard.
+func (s *Server) findClipboard(w http.ResponseWriter, r *http.Request, param string) *clipboard.Clipboard {
+	var c *clipboard.Clipboard
+	var err error
+	// Aliases are as guessable as numeric ids, and restricted like them.
+	numeric := !clipboard.IsPublicId(param)
+	id, atoiErr := strconv.Atoi(param)
+	switch {
+	case !numeric:
+		ctx, span := telemetry.Start(r.Context(), "db.GetByPublicId")
+		c, err = s.db.GetByPublicId(ctx, param)
+		telemetry.End(span, err)
+	case atoiErr == nil:
+		ctx, span := telemetry.Start(r.Context(), "db.Get")
+		c, err = s.db.Get(ctx, id)
+		telemetry.End(span, err)
+	case clipboard.IsAlias(param):
+		c, err = s.loadAliased(r, param)
+	default:
+		validation.Error(w, "invalid clipboard id", http.StatusBadRequest)
+		return nil
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		c, err = nil, nil
+	}
+	if c != nil && !inNamespace(r, c) {
+		c = nil
+	}
+	if err == nil && c != nil && numeric {
+		var ok bool
+		if ok, err = s.numericAccess(r, c); !ok {
+			c = nil
+		}
+	}
+	if err == nil && c != nil {
+		var hidden bool
+		if hidden, err = s.hidden(r, c); hidden {
+			c = nil
+		}
+	}
+	if err != nil {
+		databaseError(w, err)
+		return nil
+	}
+
+	if c == nil {
+		validation.Error(w, "clipboard not found", http.StatusNotFound)
+		return nil
+	}
+
+	return c
+}
+
+// numericAccess reports whether the request may address a clipboard by its
+// sequential numeric id. Unless SEQUENTIAL_IDS is disabled, anyone may.
+// Otherwise only users with a role on the clipboard and its tokens may,
+// so anonymous and shared clipboards cannot be found by counting ids.
+func (s *Server) numericAccess(r *http.Request, c *clipboard.Clipboard) (bool, error) {
+	if s.sequentialIds {
+		return true, nil
+	}
+	if t := currentToken(r); t != nil {
+		return t.ClipboardId == c.Id, nil
+	}
+	if c.OwnerId == 0 {
+		return false, nil
+	}
+
+	role, err := s.role(r, c)
+	return role != "", err
+}
+
+// queryInt parses an optional non-negative integer query parameter.
+// It returns def if the parameter is absent and caps the value at max.
+func queryInt(r *http.Request, name string, def, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, errors.New("invalid " + name)
+	}
+
+	return min(i, max), nil
+}
+
+// queryTime returns the time of a query parameter given in RFC 3339 format,
+// or the zero time if it is missing.
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.New("invalid " + name + ": must be an RFC 3339 time")
+	}
+
+	return t, nil
+}
+
+// queryList returns the values of a query parameter that may be repeated or
+// hold a comma-separated list, e.g. ?transform=trim,newlines.
+func queryList(r *http.Request, name string) []string {
+	var values []string
+	for _, v := range r.URL.Query()[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// querySort returns the order of the sort and order query parameters of
+// lists. Names sort ascending and the rest descending unless order says
+// otherwise.
+func querySort(r *http.Request) (string, bool, error) {
+	sort, order := r.URL.Query().Get("sort"), r.URL.Query().Get("order")
+	switch sort {
+	case "":
+		if order != "" {
+			return "", false, errors.New("order requires sort")
+		}
+		return "", false, nil
+	case database.SortUpdatedAt, database.SortName, database.SortSize:
+	default:
+		return "", false, errors.New("sort must be updated_at, name or size")
+	}
+
+	switch order {
+	case "":
+		return sort, sort != database.SortName, nil
+	case "asc", "desc":
+		return sort, order == "desc", nil
+	default:
+		return "", false, errors.New("order must be asc or desc")
+	}
+}
+
+// etag returns the entity tag of a clipboard version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag exposes the version of a clipboard as the ETag header.
+func setETag(w http.ResponseWriter, c *clipboard.Clipboard) {
+	w.Header().Set("ETag", etag(c.Version))
+}
+
+// checkVersion requires the If-Match header to match the current version
+// of the clipboard, so that concurrent updates from two devices cannot
+// silently overwrite each other. "*" matches any version, for clients that
+// mean to overwrite. It responds with 428 if the header is missing and with
+// 409 if it does not match, and returns false.
+func checkVersion(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) bool {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		validation.Error(w, "If-Match header with the clipboard version required", http.StatusPreconditionRequired)
+		return false
+	}
+
+	current := etag(c.Version)
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || tag == current {
+				return true
+			}
+		}
+	}
+
+	setETag(w, c)
+	validation.Error(w, "clipboard was modified, current version is "+strconv.Itoa(c.Version), http.StatusConflict)
+	return false
+}
+
+// clipboardFields are the fields of clipboard bodies. Fields only found in
+// responses are accepted and ignored, so clipboards can be sent back as
+// they were fetched.
+var clipboardFields = []string{
+	"name", "type", "data", "filename", "content_disposition", "locale", "is_encrypted", "encryption_scope", "pinned", "tags", "transforms", "flavors", "publish_at", "expires_at",
+	"id", "public_id", "code", "created_at", "updated_at", "last_read_at", "owner_id", "size",
+	"version", "locked", "read_only", "namespace", "hash", "refs", "metadata", "streamed", "trust", "charset",
+}
+
+// decodeBody decodes the JSON body of a request into v, which may only have
+// the given fields unless STRICT_REQUEST_BODIES is disabled, see
+// validation.Decode. If it cannot, it writes 400 for malformed bodies or
+// 422 listing the unknown and invalid fields, and returns false.
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v any, fields ...string) bool {
+	return s.decodeJSON(w, r.Body, v, false, fields)
+}
+
+// decodeOptionalBody is like decodeBody, but leaves v as is if the body is
+// empty.
+func (s *Server) decodeOptionalBody(w http.ResponseWriter, r *http.Request, v any, fields ...string) bool {
+	return s.decodeJSON(w, r.Body, v, true, fields)
+}
+
+func (s *Server) decodeJSON(w http.ResponseWriter, body io.Reader, v any, optional bool, fields []string) bool {
+	if !s.strictBodies {
+		fields = nil
+	} else if fields == nil {
+		fields = []string{}
+	}
+
+	err := validation.Decode(body, v, fields)
+	var errs validation.Errors
+	switch {
+	case err == nil, optional && errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &errs):
+		validation.WriteErrors(w, errs)
+		return false
+	}
+	validation.Error(w, "invalid request body", http.StatusBadRequest)
+	return false
+}
+
+// decodeClipboard decodes the clipboard sent in the request body according
+// to its Content-Type:
+//   - plain text is the data itself, with the name, filename, locale, tags,
+//     transforms, flags and schedule in the query string, e.g.
+//     ?name=notes&tag=work&encrypted=true, or the locale in the
+//     Content-Language header
+//   - form-encoded bodies, as sent by HTML forms, hold the name, type, data,
+//     filename, locale, tags, transforms, flags and schedule as fields; checkboxes count as set with any true value
+//     or "on"
+//   - anything else is JSON holding all fields, including form-encoded
+//     bodies starting with "{", which is what curl -d sends
+//
+// JSON bodies may only hold clipboardFields. Text is converted to UTF-8,
+// see decodeText.
+// If the body cannot be decoded, it writes an error response and returns false.
+func (s *Server) decodeClipboard(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) bool {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "text/plain":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			validation.Error(w, "invalid request body", http.StatusBadRequest)
+			return false
+		}
+		decodeClipboardFields(c, r.URL.Query())
+		if !decodeSchedule(w, c, r.URL.Query()) {
+			return false
+		}
+		if c.Locale == "" {
+			c.Locale = r.Header.Get("Content-Language")
+		}
+		c.DataType = contentType
+		c.Data = string(data)
+		return decodeText(w, c, true)
+	case "application/x-www-form-urlencoded":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			validation.Error(w, "invalid request body", http.StatusBadRequest)
+			return false
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+			return s.decodeJSON(w, bytes.NewReader(body), c, false, clipboardFields)
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			validation.Error(w, "invalid request body", http.StatusBadRequest)
+			return false
+		}
+		decodeClipboardFields(c, form)
+		if !decodeSchedule(w, c, form) {
+			return false
+		}
+		c.DataType = form.Get("type")
+		if c.DataType == "" {
+			c.DataType = "text/plain"
+		}
+		c.Data = form.Get("data")
+		return decodeText(w, c, true)
+	}
+
+	return s.decodeBody(w, r, c, clipboardFields...) && decodeText(w, c, false)
+}
+
+// decodeText converts text data sent in the charset its type names to
+// UTF-8, relabeling the type and recording the charset, see
+// clipboard.ParseText, and normalizes the locale. Data decoded from JSON is
+// Unicode already, so unless decode is set only the type is relabeled.
+// Unknown charsets and malformed locales are left for validation.Clipboard
+// to report. If the data is not valid in its charset, it writes 422 and
+// returns false.
+func decodeText(w http.ResponseWriter, c *clipboard.Clipboard, decode bool) bool {
+	if locale, err := clipboard.NormalizeLocale(c.Locale); err == nil {
+		c.Locale = locale
+	}
+	t, err := clipboard.ParseText(c.DataType)
+	if err != nil {
+		return true
+	}
+
+	if decode {
+		data, err := t.Decode(c.Data)
+		if err != nil {
+			var errs validation.Errors
+			errs.Add("data", validation.CodeInvalid, err.Error())
+			validation.WriteErrors(w, errs)
+			return false
+		}
+		c.Data = data
+	}
+	c.DataType, c.Charset = t.DataType, t.Charset
+	return true
+}
+
+// decodeClipboardFields sets the metadata of a clipboard sent as query
+// parameters or form fields.
+func decodeClipboardFields(c *clipboard.Clipboard, values url.Values) {
+	c.Name = values.Get("name")
+	c.Filename = values.Get("filename")
+	c.ContentDisposition = values.Get("content_disposition")
+	c.Locale = values.Get("locale")
+	c.Tags = values["tag"]
+	c.Transforms = values["transform"]
+	c.IsEncrypted = formBool(values.Get("encrypted")) || formBool(values.Get("is_encrypted"))
+	c.EncryptionScope = values.Get("encryption_scope")
+	c.Pinned = formBool(values.Get("pinned"))
+}
+
+// decodeSchedule sets the publication and expiry times of a clipboard sent
+// as query parameters or form fields in RFC 3339 format. If one is
+// malformed, it writes 422 and returns false.
+func decodeSchedule(w http.ResponseWriter, c *clipboard.Clipboard, values url.Values) bool {
+	var errs validation.Errors
+	for _, f := range []struct {
+		name string
+		t    **time.Time
+	}{{"publish_at", &c.PublishAt}, {"expires_at", &c.ExpiresAt}} {
+		v := values.Get(f.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs.Add(f.name, validation.CodeInvalid, f.name+" must be a time in RFC 3339 format")
+			continue
+		}
+		*f.t = &t
+	}
+	if len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return false
+	}
+	return true
+}
+
+// formBool parses a boolean query parameter or form field.
+func formBool(v string) bool {
+	if v == "on" {
+		return true
+	}
+	b, _ := strconv.ParseBool(v)
+	return b
+}
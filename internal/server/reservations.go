@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// ReserveHandler reserves a clipboard id, and the name in the optional body,
+// for the current user until RESERVATION_EXPIRY, so clients creating a
+// clipboard in several steps know its id up front and do not race other
+// clients for the name. The clipboard is created under the id with
+// PUT /clipboard/{id}?upsert=true or a chunked upload with a clipboard_id,
+// which releases the reservation.
+func (s *Server) ReserveHandler(w http.ResponseWriter, r *http.Request) {
+	var res clipboard.Reservation
+	if !s.decodeOptionalBody(w, r, &res, "name") {
+		return
+	}
+	if errs := validation.Reservation(&res); len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	res.OwnerId = currentUserId(r)
+	res.Namespace = currentNamespace(r)
+	res.ExpiresAt = time.Now().UTC().Add(s.reservationExpiry)
+
+	err := s.db.Reserve(r.Context(), &res)
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", s.path("/clipboard/"+strconv.Itoa(res.Id)))
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(res)
+	_, _ = w.Write(jsonResp)
+}
+
+// ReleaseReservationHandler releases a reservation of the current user
+// before it expires. The id is not handed out again.
+func (s *Server) ReleaseReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		validation.Error(w, "reservation not found", http.StatusNotFound)
+		return
+	}
+
+	res, err := s.db.GetReservation(r.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if res == nil || !ownsReservation(r, res) {
+		validation.Error(w, "reservation not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.DeleteReservation(r.Context(), id); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkReservation checks that a clipboard created under the given id and
+// name does not take the reservation of another user or another name, and
+// returns the reservation of the id, if any.
+// If it does, it responds with 409 and returns false.
+func (s *Server) checkReservation(w http.ResponseWriter, r *http.Request, id int, name string) (*clipboard.Reservation, bool) {
+	res, err := s.db.GetReservation(r.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	switch {
+	case res == nil:
+		return nil, true
+	case !ownsReservation(r, res):
+		validation.Error(w, "clipboard id is reserved", http.StatusConflict)
+		return nil, false
+	case res.Name != "" && res.Name != name:
+		validation.Error(w, "clipboard id is reserved for another name", http.StatusConflict)
+		return nil, false
+	}
+	return res, true
+}
+
+// ownsReservation reports whether a reservation was made by the current
+// user in the namespace of the request.
+func ownsReservation(r *http.Request, res *clipboard.Reservation) bool {
+	return res.OwnerId == currentUserId(r) && res.Namespace == currentNamespace(r)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// enforceRoles checks the role of the user of every request against the
+// permission the request needs: reads need read, other methods write.
+// The admin API checks for the admin permission itself, and logging in and
+// pairing are open to every role. Anonymous requests and requests made with
+// clipboard tokens have no role and are left to the checks of clipboards.
+func (s *Server) enforceRoles(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := currentUser(r)
+		if u == nil || exemptFromRoles(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		perm := account.PermWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			perm = account.PermRead
+		}
+		if !account.Allows(u.Role, perm) {
+			validation.Error(w, "forbidden for role "+u.Role, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// exemptFromRoles reports whether a path is not subject to enforceRoles.
+func exemptFromRoles(path string) bool {
+	for _, prefix := range []string{"/auth/", "/admin/", "/federation/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return path == "/export" || path == "/import"
+}
+
+// permits reports whether the role of the current user allows an action on
+// clipboards. Reading and auditing need the read permission, any other
+// action write. Anonymous requests have no role to check.
+func permits(r *http.Request, action string) bool {
+	u := currentUser(r)
+	if u == nil {
+		return true
+	}
+
+	perm := account.PermWrite
+	if action == clipboard.ActionRead || action == clipboard.ActionAudit {
+		perm = account.PermRead
+	}
+	return account.Allows(u.Role, perm)
+}
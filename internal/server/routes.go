@@ -1,31 +1,173 @@
 package server
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/convert"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/logging"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(s.resolveClientIP)
+	r.Use(telemetry.Middleware)
+	r.Use(s.measure)
 	r.Use(middleware.Logger)
+	r.Use(localize)
+	if len(s.allowedIPs) > 0 || len(s.deniedIPs) > 0 {
+		r.Use(s.filterIPs)
+	}
+	r.Use(s.compress)
+	r.Use(s.binaryFormats)
+	r.Use(s.resolveNamespace)
+	if s.primary != nil {
+		r.Use(s.proxyWrites)
+	}
+	r.Use(s.identify)
+	r.Use(s.enforceRoles)
+	r.Use(s.meterBandwidth)
+	for _, mw := range s.middleware {
+		r.Use(mw)
+	}
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		validation.Error(w, "not found", http.StatusNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		validation.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	})
 
 	r.Get("/", s.HelloWorldHandler)
 
-	r.Get("/health", s.healthHandler)
-
+	r.Get("/healthz", s.LivenessHandler)
+	r.Get("/readyz", s.ReadinessHandler)
+	// Kept for monitors set up before the split.
+	r.Get("/health", s.ReadinessHandler)
+
+	r.Get("/notifications/webpush", s.WebPushKeyHandler)
+
+	// Clipboard tokens only grant access to routes of their clipboard.
+	r.Group(func(r chi.Router) {
+		r.Use(denyTokens)
+
+		r.Get("/quota", s.QuotaHandler)
+
+		r.Post("/auth/login", s.LoginHandler)
+		r.Post("/auth/refresh", s.RefreshHandler)
+		r.Post("/auth/logout", s.LogoutHandler)
+		r.Post("/auth/pair", s.PairHandler)
+		r.Post("/auth/pair/claim", s.ClaimPairingHandler)
+		r.Get("/auth/oidc/login", s.OIDCLoginHandler)
+		r.Get("/auth/oidc/callback", s.OIDCCallbackHandler)
+		r.Post("/auth/totp/setup", s.TOTPSetupHandler)
+		r.Post("/auth/totp/verify", s.TOTPVerifyHandler)
+		r.Delete("/auth/totp", s.TOTPDisableHandler)
+
+		r.Get("/sync", s.SyncHandler)
+
+		r.Get("/relay", s.RelayHandler)
+		r.Get("/relay/devices", s.RelayDevicesHandler)
+		r.Post("/relay/devices/{device}", s.RelaySendHandler)
+		r.Get("/relay/transfers/{transferId}", s.RelayReceiveHandler)
+		r.Delete("/relay/transfers/{transferId}", s.RelayDeclineHandler)
+
+		r.Get("/changes", s.ChangesHandler)
+		r.Get("/clipboard", s.ListHandler)
+		r.Post("/clipboard", s.PostHandler)
+		r.Post("/clipboard/reserve", s.ReserveHandler)
+		r.Delete("/clipboard/reserve/{id}", s.ReleaseReservationHandler)
+
+		r.Post("/clipboard/uploads", s.StartUploadHandler)
+		r.Get("/clipboard/uploads/{uploadId}", s.UploadStatusHandler)
+		r.Patch("/clipboard/uploads/{uploadId}", s.UploadChunkHandler)
+		r.Post("/clipboard/uploads/{uploadId}/commit", s.CommitUploadHandler)
+		r.Delete("/clipboard/uploads/{uploadId}", s.AbortUploadHandler)
+
+		r.Post("/transactions", s.TransactionHandler)
+	})
+
+	r.Get("/c/{code}", s.CodeHandler)
 	r.Get("/clipboard/{id}", s.GetHandler)
-	r.Post("/clipboard", s.PostHandler)
 	r.Put("/clipboard/{id}", s.PutHandler)
 	r.Delete("/clipboard/{id}", s.DeleteHandler)
+	r.Get("/clipboard/{id}/delta", s.DeltaHandler)
+	r.Get("/clipboard/{id}/diff", s.DiffHandler)
+	r.Patch("/clipboard/{id}/delta", s.PatchDeltaHandler)
+	r.Get("/clipboard/{id}/raw", s.GetRawHandler)
+	r.Put("/clipboard/{id}/raw", s.PutRawHandler)
+	r.Post("/clipboard/{id}/raw/uploads", s.StartBlobUploadHandler)
+	r.Post("/clipboard/{id}/raw/uploads/{uploadId}/commit", s.CommitBlobUploadHandler)
+	r.Delete("/clipboard/{id}/raw/uploads/{uploadId}", s.AbortBlobUploadHandler)
+	r.Get("/clipboard/{id}/conflicts", s.ConflictsHandler)
+	r.Delete("/clipboard/{id}/conflicts/{conflictId}", s.DeleteConflictHandler)
+	r.Get("/clipboard/{id}/audit", s.AuditHandler)
+	r.Get("/clipboard/{id}/stats", s.StatsHandler)
+	r.Get("/clipboard/{id}/qr", s.QRHandler)
+	r.Get("/clipboard/{id}/thumbnail", s.ThumbnailHandler)
+	r.Get("/clipboard/{id}/download", s.DownloadHandler)
+	r.Get("/clipboard/{id}/render", s.RenderHandler)
+	r.Get("/clipboard/{id}/items", s.ItemsHandler)
+	r.Post("/clipboard/{id}/items", s.PushItemHandler)
+	r.Post("/clipboard/{id}/items/pop", s.PopItemHandler)
+	r.Get("/clipboard/{id}/permissions", s.PermissionsHandler)
+	r.Post("/clipboard/{id}/permissions", s.GrantHandler)
+	r.Delete("/clipboard/{id}/permissions/{user}", s.RevokeHandler)
+	r.Get("/clipboard/{id}/tokens", s.TokensHandler)
+	r.Post("/clipboard/{id}/tokens", s.CreateTokenHandler)
+	r.Delete("/clipboard/{id}/tokens/{tokenId}", s.DeleteTokenHandler)
+	r.Get("/clipboard/{id}/aliases", s.AliasesHandler)
+	r.Post("/clipboard/{id}/aliases", s.SetAliasHandler)
+	r.Delete("/clipboard/{id}/aliases/{name}", s.DeleteAliasHandler)
+	r.Get("/clipboard/{id}/notifications", s.SubscriptionsHandler)
+	r.Post("/clipboard/{id}/notifications", s.SubscribeHandler)
+	r.Delete("/clipboard/{id}/notifications/{subscriptionId}", s.UnsubscribeHandler)
+	r.Post("/clipboard/{id}/clear", s.ClearHandler)
+	r.Post("/clipboard/{id}/pin", s.PinHandler)
+	r.Delete("/clipboard/{id}/pin", s.PinHandler)
+	r.Post("/clipboard/{id}/lock", s.LockHandler)
+	r.Delete("/clipboard/{id}/lock", s.LockHandler)
+	r.Post("/clipboard/{id}/password", s.PasswordHandler)
+	r.Post("/clipboard/{id}/tags", s.AddTagsHandler)
+	r.Delete("/clipboard/{id}/tags/{tag}", s.RemoveTagHandler)
+
+	for _, m := range s.mounts {
+		r.Mount(m.pattern, m.handler)
+	}
 
-	return r
+	if s.federation.cfg.Enabled() {
+		r.Route("/federation", s.federationRoutes)
+	}
+
+	if s.uiEnabled {
+		r.Route("/ui", s.uiRoutes)
+	}
+
+	r.Route("/admin", s.adminRoutes)
+	r.With(s.requireAdmin).Get("/export", s.ExportHandler)
+	r.With(s.requireAdmin).Post("/import", s.ImportHandler)
+
+	return s.embed(r)
 }
 
 func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,194 +176,616 @@ func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		log.Printf("error handling JSON marshal: %v", err)
+		validation.Error(w, "internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	jsonResp, _ := json.Marshal(s.db.Health())
+// LivenessHandler reports that the process is up and serving requests.
+// It does not touch the database, so a database outage does not get the
+// server restarted.
+func (s *Server) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResp, _ := json.Marshal(map[string]string{"status": "up"})
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+// ReadinessHandler reports whether the server can handle requests, i.e.
+// whether the database answers a ping in time. With ?deep=true, it also
+// probes the storage of streamed clipboards and reports the latency of each
+// operation. It responds with 503 if a check fails.
+func (s *Server) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	stats := s.db.Health(r.Context())
+	if deep, _ := strconv.ParseBool(r.URL.Query().Get("deep")); deep && stats["status"] == "up" {
+		for k, v := range s.db.ProbeStorage() {
+			stats[k] = v
+		}
+		if stats["storage_status"] == "down" {
+			stats["status"] = "down"
+		}
+	}
+	if stats["status"] != "up" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	jsonResp, _ := json.Marshal(stats)
+	_, _ = w.Write(jsonResp)
+}
+
+// ListHandler lists the clipboards of the current user. Anonymous clipboards
+// belong to no one, so listing them would hand out every one of them to
+// anyone, and anonymous clients are refused.
+func (s *Server) ListHandler(w http.ResponseWriter, r *http.Request) {
+	if currentUserId(r) == 0 {
+		validation.Error(w, "listing clipboards requires an API key or session", http.StatusUnauthorized)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultListLimit, maxListLimit)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, math.MaxInt)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags, err := clipboard.NormalizeTags(r.URL.Query()["tag"])
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pinned, _ := strconv.ParseBool(r.URL.Query().Get("pinned"))
+	var dataType string
+	if v := r.URL.Query().Get("type"); v != "" {
+		dataType, _, err = mime.ParseMediaType(v)
+		if err != nil || !strings.Contains(dataType, "/") || strings.HasPrefix(dataType, "*") {
+			validation.Error(w, "type must be a media type such as text/plain or text/*", http.StatusBadRequest)
+			return
+		}
+	}
+	var encrypted *bool
+	if v := r.URL.Query().Get("encrypted"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			validation.Error(w, "encrypted must be a boolean", http.StatusBadRequest)
+			return
+		}
+		encrypted = &b
+	}
+	updatedSince, err := queryTime(r, "updated_since")
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort, desc, err := querySort(r)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Clients rendering previews from the metadata can leave out the data.
+	withData := true
+	if v := r.URL.Query().Get("data"); v != "" {
+		if withData, err = strconv.ParseBool(v); err != nil {
+			validation.Error(w, "data must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	fields, err := queryFields(r)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.List")
+	cs, err := s.db.List(ctx, database.ListOptions{
+		OwnerId:      currentUserId(r),
+		Namespace:    currentNamespace(r),
+		Tags:         tags,
+		Pinned:       pinned,
+		Type:         dataType,
+		Encrypted:    encrypted,
+		UpdatedSince: updatedSince,
+		VisibleAt:    time.Now(),
+		Sort:         sort,
+		Desc:         desc,
+		Limit:        limit,
+		Offset:       offset,
+	})
+	telemetry.End(span, err)
 	if err != nil {
-		http.Error(w, "invalid clipboard id", http.StatusBadRequest)
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
 		return
 	}
+	for _, c := range cs {
+		untrusted := s.withholdUntrusted(r, c)
+		if !withData || untrusted || withholdQuarantined(r, c) {
+			c.Data = ""
+			c.Flavors = nil
+		}
+	}
+
+	var jsonResp []byte
+	if len(fields) > 0 {
+		selected := make([]any, len(cs))
+		for i, c := range cs {
+			selected[i] = withFields(c, fields)
+		}
+		jsonResp, _ = json.Marshal(selected)
+	} else {
+		jsonResp, _ = json.Marshal(cs)
+	}
+	_, _ = w.Write(jsonResp)
+}
 
-	c, err := s.db.Get(id)
+func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
+	// Transforms requested on read only change the response.
+	transforms, err := clipboard.NormalizeTransforms(queryList(r, "transform"))
 	if err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	as, err := queryConversion(r)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields, err := queryFields(r)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	c := s.loadClipboard(w, r)
 	if c == nil {
-		http.Error(w, "clipboard not found", http.StatusNotFound)
 		return
 	}
 
-	if c.IsEncrypted {
-		_, password, ok := r.BasicAuth()
+	if !s.readClipboard(w, r, c) {
+		return
+	}
+	c.Data = clipboard.Transform(c.DataType, c.Data, transforms)
+	// Converted clipboards only have the converted data, images encoded in
+	// base64 like they are sent.
+	if as != "" {
+		converted, ok := s.convertClipboard(w, r, c, strings.NewReader(c.Data), as)
 		if !ok {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if !c.Authenticate(password) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		if err := c.Decrypt(password); err != nil {
-			http.Error(w, "clipboard decryption failed", http.StatusInternalServerError)
-			return
+		c.DataType, c.Data, c.Flavors = convert.Type(as), string(converted), nil
+		if convert.IsImage(as) {
+			c.Data = base64.StdEncoding.EncodeToString(converted)
 		}
 	}
 
-	jsonResp, _ := json.Marshal(c)
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(withFields(c, fields))
 	_, _ = w.Write(jsonResp)
 }
 
+// dedupeClipboard returns the clipboard of the current user with the same
+// content as cNew, adding a reference to it, or nil if there is none.
+// Encrypted clipboards are never deduplicated, nor are anonymous ones: they
+// would hand out the clipboards of other clients, which their creators could
+// then no longer delete.
+// If the clipboard is invalid, it writes an error response and returns false.
+func (s *Server) dedupeClipboard(w http.ResponseWriter, r *http.Request, cNew *clipboard.Clipboard) (*clipboard.Clipboard, bool) {
+	if cNew.IsEncrypted || currentUserId(r) == 0 {
+		return nil, true
+	}
+	cNew.Namespace = currentNamespace(r)
+	if !s.checkData(w, cNew, true) {
+		return nil, false
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.Dedupe")
+	c, err := s.db.Dedupe(ctx, cNew.Namespace, currentUserId(r), cNew.ContentHash())
+	telemetry.End(span, err)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if c == nil {
+		return nil, true
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionCreate, clipboard.OutcomeSuccess)
+	return c, true
+}
+
+// readClipboard authenticates the request against the clipboard and decrypts
+// it. Scripts and executables are only served to clients that explicitly
+// acknowledge the risk, to make paste-jacking through shared links harder.
+// If the clipboard cannot be read, it writes an error response and returns false.
+func (s *Server) readClipboard(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) bool {
+	password, ok := s.authenticate(w, r, c, clipboard.ActionRead)
+	if !ok {
+		return false
+	}
+
+	if c.Streamed {
+		if !s.loadStreamed(w, r, c, password) {
+			return false
+		}
+	} else if c.IsEncrypted {
+		_, span := telemetry.Start(r.Context(), "crypto.Decrypt")
+		err := c.Decrypt(password)
+		telemetry.End(span, err)
+		if err != nil {
+			s.decryptionFailed(w, r, c)
+			return false
+		}
+	}
+
+	c.Trust = s.trust.Assess(c)
+	return confirmTrust(w, r, c.Trust) && confirmQuarantine(w, r, c)
+}
+
+// withholdUntrusted assesses the trust level of a listed clipboard and
+// reports whether its data must be left out of the list, as the client did
+// not confirm it with a matching X-Confirm-Untrusted header like
+// confirmTrust requires. Encrypted data is ciphertext, so only the type of
+// encrypted clipboards is assessed.
+func (s *Server) withholdUntrusted(r *http.Request, c *clipboard.Clipboard) bool {
+	data := c.Data
+	if c.IsEncrypted {
+		data = ""
+	}
+	c.Trust = s.trust.AssessData(c.DataType, data)
+	return c.Trust != clipboard.TrustSafe && r.Header.Get("X-Confirm-Untrusted") != string(c.Trust)
+}
+
+// confirmTrust checks that the client acknowledged the risk of content that
+// is not safe with a matching X-Confirm-Untrusted header.
+// If it did not, it responds with 428 and returns false.
+func confirmTrust(w http.ResponseWriter, r *http.Request, level clipboard.TrustLevel) bool {
+	if level == clipboard.TrustSafe {
+		return true
+	}
+
+	w.Header().Set("X-Content-Trust", string(level))
+	if r.Header.Get("X-Confirm-Untrusted") != string(level) {
+		validation.Error(w, "clipboard content flagged as "+string(level)+", confirm with X-Confirm-Untrusted header", http.StatusPreconditionRequired)
+		return false
+	}
+
+	return true
+}
+
 func (s *Server) PostHandler(w http.ResponseWriter, r *http.Request) {
 	var cNew clipboard.Clipboard
-	if err := json.NewDecoder(r.Body).Decode(&cNew); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !s.decodeClipboard(w, r, &cNew) {
 		return
 	}
 
-	// log.Printf("Received clipboard: %+v", cNew)
+	logging.Debugf("Received clipboard: %+v", cNew)
 
-	c, err := s.db.Get(cNew.Id)
-	if err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
+	if dedupe, _ := strconv.ParseBool(r.URL.Query().Get("dedupe")); dedupe {
+		c, ok := s.dedupeClipboard(w, r, &cNew)
+		if !ok {
+			return
+		}
+		if c != nil {
+			w.Header().Set("X-Deduplicated", "true")
+			setETag(w, c)
+			jsonResp, _ := json.Marshal(c)
+			_, _ = w.Write(jsonResp)
+			return
+		}
+	}
+
+	if !s.createClipboard(w, r, &cNew) {
 		return
 	}
 
+	setETag(w, &cNew)
+	jsonResp, _ := json.Marshal(cNew)
+	_, _ = w.Write(jsonResp)
+}
+
+// createClipboard stores a new clipboard owned by the current user in the
+// namespace of the request, encrypting it with the Basic Auth password if
+// requested. Clipboards with an id may only take reservations of the user,
+// see checkReservation.
+// If the clipboard cannot be created, it writes an error response and
+// returns false.
+func (s *Server) createClipboard(w http.ResponseWriter, r *http.Request, cNew *clipboard.Clipboard) bool {
+	if !s.prepareClipboard(w, r, cNew) {
+		return false
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.Insert")
+	err := s.db.Insert(ctx, cNew)
+	telemetry.End(span, err)
+	if err != nil {
+		databaseError(w, err)
+		return false
+	}
+
+	s.logAccess(r, cNew.Id, clipboard.ActionCreate, clipboard.OutcomeSuccess)
+	s.publish(events.ClipboardCreated, cNew)
+
+	return true
+}
+
+// prepareClipboard validates, scans and encrypts a new clipboard and checks
+// the quotas for it as described by createClipboard, without storing it.
+// If the clipboard may not be created, it writes an error response and
+// returns false.
+func (s *Server) prepareClipboard(w http.ResponseWriter, r *http.Request, cNew *clipboard.Clipboard) bool {
+	cNew.Namespace = currentNamespace(r)
+	if !s.checkData(w, cNew, true) {
+		return false
+	}
+	cNew.OwnerId = currentUserId(r)
+	cNew.Tags, _ = clipboard.NormalizeTags(cNew.Tags)
+	cNew.Transforms, _ = clipboard.NormalizeTransforms(cNew.Transforms)
+	cNew.ApplyTransforms()
+
+	c, err := s.db.Get(r.Context(), cNew.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return false
+	}
+
 	if c != nil {
-		http.Error(w, "clipboard already exists", http.StatusConflict)
-		return
+		validation.Error(w, "clipboard already exists", http.StatusConflict)
+		return false
+	}
+	if cNew.Id != 0 {
+		if _, ok := s.checkReservation(w, r, cNew.Id, cNew.Name); !ok {
+			return false
+		}
+	}
+	if !s.scanClipboard(w, r, cNew) {
+		return false
 	}
 
+	// The scope only applies to encrypted clipboards.
+	switch {
+	case !cNew.IsEncrypted:
+		cNew.EncryptionScope = ""
+	case cNew.EncryptionScope == "":
+		cNew.EncryptionScope = clipboard.ScopeData
+	}
 	if cNew.IsEncrypted {
 		_, password, ok := r.BasicAuth()
 		if !ok {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
+			validation.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
 		}
+		_, span := telemetry.Start(r.Context(), "crypto.HashPassword")
 		cNew.PasswordHash, err = clipboard.HashPassword(password)
+		telemetry.End(span, err)
 		if err != nil {
-			http.Error(w, "password hashing failed", http.StatusInternalServerError)
-			return
+			validation.Error(w, "password hashing failed", http.StatusInternalServerError)
+			return false
 		}
+		_, span = telemetry.Start(r.Context(), "crypto.Encrypt")
 		err = cNew.Encrypt(password)
+		telemetry.End(span, err)
 		if err != nil {
-			http.Error(w, "clipboard encryption failed", http.StatusInternalServerError)
-			return
+			validation.Error(w, "clipboard encryption failed", http.StatusInternalServerError)
+			return false
 		}
 	}
 
-	// log.Printf("Processed clipboard: %+v", cNew)
-
-	if err := s.db.Insert(&cNew); err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
-		return
-	}
+	logging.Debugf("Processed clipboard: %+v", cNew)
 
-	jsonResp, _ := json.Marshal(cNew)
-	_, _ = w.Write(jsonResp)
+	return s.checkQuota(r.Context(), w, cNew.Namespace, cNew.OwnerId, 1, int64(cNew.DataSize()))
 }
 
 func (s *Server) PutHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		http.Error(w, "invalid clipboard id", http.StatusBadRequest)
+	if upsert, _ := strconv.ParseBool(r.URL.Query().Get("upsert")); upsert && s.upsertClipboard(w, r) {
 		return
 	}
 
-	c, err := s.db.Get(id)
-	if err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
+	mode, ok := mergeMode(w, r)
+	if !ok {
 		return
 	}
 
+	c := s.loadClipboard(w, r)
 	if c == nil {
-		http.Error(w, "clipboard not found", http.StatusNotFound)
 		return
 	}
 
 	var cNew clipboard.Clipboard
-	if err := json.NewDecoder(r.Body).Decode(&cNew); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !s.decodeClipboard(w, r, &cNew) {
+		return
+	}
+	oldSize := c.Size
+	current, password, ok := s.prepareUpdate(w, r, c, &cNew)
+	if !ok {
+		return
+	}
+
+	// Updates based on an outdated version are merged if the request asks
+	// for it, and refused otherwise.
+	var conflict *clipboard.Conflict
+	if base, outdated := outdatedVersion(r, c); outdated && mode != "" && current.Mergeable() && c.Mergeable() {
+		var err error
+		if conflict, err = s.mergeUpdate(r.Context(), w, c, current, base, mode); err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+	} else if !checkVersion(w, r, c) {
+		return
+	}
+	if !s.sealUpdate(w, r, c, password, oldSize) {
+		return
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.Update")
+	var err error
+	if conflict != nil {
+		err = s.db.Overwrite(ctx, c, conflict)
+	} else {
+		err = s.db.Update(ctx, c)
+	}
+	telemetry.End(span, err)
+	if err != nil {
+		databaseError(w, err)
 		return
 	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	s.publish(events.ClipboardUpdated, c)
+
+	setETag(w, c)
+	jsonResp, _ := json.Marshal(c)
+	_, _ = w.Write(jsonResp)
+}
+
+// prepareUpdate applies an update sent as cNew to c: its data, type,
+// filename, disposition, charset, locale, schedule and flavors, and its
+// transforms if it has any. The name is kept. It authenticates the request for updating c
+// and returns c as it was, with its fields opened, and the password the
+// request was made with.
+// If c may not be updated, it writes an error response and returns false.
+func (s *Server) prepareUpdate(w http.ResponseWriter, r *http.Request, c, cNew *clipboard.Clipboard) (*clipboard.Clipboard, string, bool) {
+	cNew.Namespace = c.Namespace
+	if !s.checkData(w, cNew, false) {
+		return nil, "", false
+	}
+	current := *c
 	c.DataType = cNew.DataType
 	c.Data = cNew.Data
+	c.Filename = cNew.Filename
+	c.ContentDisposition = cNew.ContentDisposition
+	c.Charset = cNew.Charset
+	c.Locale = cNew.Locale
+	c.PublishAt = cNew.PublishAt
+	c.ExpiresAt = cNew.ExpiresAt
+	c.Flavors = cNew.Flavors
+	// Transforms are kept unless the body replaces them.
+	if cNew.Transforms != nil {
+		c.Transforms, _ = clipboard.NormalizeTransforms(cNew.Transforms)
+	}
+	c.ApplyTransforms()
+
+	logging.Debugf("Received clipboard: %+v", cNew)
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionUpdate)
+	if !ok || !checkWritable(w, c) {
+		return nil, "", false
+	}
+	// Updates keep the name, which is sealed with the other fields if the
+	// clipboard encrypts them.
+	if !s.openFields(w, r, &current, password) {
+		return nil, "", false
+	}
+	c.Name = current.Name
+
+	return &current, password, true
+}
 
-	// log.Printf("Received clipboard: %+v", cNew)
+// sealUpdate scans and encrypts a clipboard updated by prepareUpdate, and
+// checks the storage quota of its owner for the data it grew by since it
+// had oldSize bytes.
+// If the update may not be stored, it writes an error response and returns
+// false.
+func (s *Server) sealUpdate(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, password string, oldSize int) bool {
+	if !s.scanClipboard(w, r, c) {
+		return false
+	}
 
 	if c.IsEncrypted {
-		_, password, ok := r.BasicAuth()
-		if !ok {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		if !c.Authenticate(password) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		err = c.Encrypt(password)
+		_, span := telemetry.Start(r.Context(), "crypto.Encrypt")
+		err := c.Encrypt(password)
+		telemetry.End(span, err)
 		if err != nil {
-			http.Error(w, "clipboard encryption failed", http.StatusInternalServerError)
-			return
+			validation.Error(w, "clipboard encryption failed", http.StatusInternalServerError)
+			return false
 		}
 	}
 
-	// log.Printf("Processed clipboard: %+v", c)
+	logging.Debugf("Processed clipboard: %+v", c)
 
-	if err := s.db.Update(c); err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
-		return
+	return s.checkQuota(r.Context(), w, c.Namespace, c.OwnerId, 0, int64(c.DataSize()-oldSize))
+}
+
+// upsertClipboard creates the clipboard identified by the id URL parameter
+// from the request body if it does not exist yet, and responds with 201.
+// Requests with an If-Match header naming a version expect the clipboard to
+// exist, so they are left to update it.
+// It returns false if the request was not handled, and true if it was,
+// whether the clipboard was created or an error response written.
+func (s *Server) upsertClipboard(w http.ResponseWriter, r *http.Request) bool {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		return false
+	}
+	if match := r.Header.Get("If-Match"); match != "" && strings.TrimSpace(match) != "*" {
+		return false
 	}
 
-	jsonResp, _ := json.Marshal(c)
+	c, err := s.db.Get(r.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return true
+	}
+	if c != nil {
+		return false
+	}
+
+	var cNew clipboard.Clipboard
+	if !s.decodeClipboard(w, r, &cNew) {
+		return true
+	}
+	cNew.Id = id
+
+	if !s.createClipboard(w, r, &cNew) {
+		return true
+	}
+
+	w.Header().Set("Location", s.path("/clipboard/"+strconv.Itoa(cNew.Id)))
+	setETag(w, &cNew)
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(cNew)
 	_, _ = w.Write(jsonResp)
+	return true
 }
 
 func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		http.Error(w, "invalid clipboard id", http.StatusBadRequest)
-		return
-	}
-
-	c, err := s.db.Get(id)
-	if err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
+	c := s.loadClipboard(w, r)
+	if c == nil {
 		return
 	}
 
-	if c == nil {
-		http.Error(w, "clipboard not found", http.StatusNotFound)
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionDelete); !ok || !checkWritable(w, c) {
 		return
 	}
 
-	if c.IsEncrypted {
-		_, password, ok := r.BasicAuth()
-		if !ok {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+	// Deduplicated clipboards are only deleted with their last reference,
+	// unless all references are dropped at once.
+	if all, _ := strconv.ParseBool(r.URL.Query().Get("all")); c.Refs > 1 && !all {
+		released, err := s.db.Unref(r.Context(), c.Id)
+		if err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
 			return
 		}
-		if !c.Authenticate(password) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if released {
+			s.logAccess(r, c.Id, clipboard.ActionDelete, clipboard.OutcomeSuccess)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 	}
 
-	if err := s.db.Delete(id); err != nil {
-		http.Error(w, "internal database error", http.StatusInternalServerError)
+	ctx, span := telemetry.Start(r.Context(), "db.Delete")
+	err := s.db.Delete(ctx, c.Id)
+	telemetry.End(span, err)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
 		return
 	}
 
+	s.publish(events.ClipboardDeleted, c)
+
 	w.WriteHeader(http.StatusNoContent)
 }
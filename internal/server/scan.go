@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/scan"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+var errScannerUnavailable = errors.New("content scanner unavailable")
+
+// rejectedError is returned for data the content scanner rejected, with
+// the finding it was rejected for.
+type rejectedError struct {
+	finding string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("data rejected by the content scanner (%s)", e.finding)
+}
+
+// scanContent runs the content scanner on data, such as the data of a
+// clipboard and its flavors. It returns a *rejectedError if any of it is
+// rejected, and otherwise what the first flagged part was flagged for, or
+// an empty string if all of it is clean.
+func (s *Server) scanContent(ctx context.Context, data ...string) (string, error) {
+	var quarantine string
+	for _, d := range data {
+		f, err := s.scan.Scan(ctx, []byte(d))
+		if err != nil {
+			log.Printf("error scanning content: %v", err)
+			return "", errScannerUnavailable
+		}
+		switch {
+		case f == nil:
+		case f.Action == scan.Reject:
+			return "", &rejectedError{f.String()}
+		case quarantine == "":
+			quarantine = f.String()
+		}
+	}
+	return quarantine, nil
+}
+
+// scanClipboard runs the content scanner on the data and flavors of a
+// clipboard about to be stored, quarantining it if they are flagged and
+// releasing it from quarantine if they are clean.
+// If they are rejected or cannot be scanned, it writes an error response
+// and returns false.
+func (s *Server) scanClipboard(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) bool {
+	data := []string{c.Data}
+	for _, f := range c.Flavors {
+		data = append(data, f.Data)
+	}
+	quarantine, err := s.scanContent(r.Context(), data...)
+	if !checkScan(w, err) {
+		return false
+	}
+
+	c.Quarantine = quarantine
+	return true
+}
+
+// checkScan writes the error response for an error of scanContent and
+// returns false, or returns true if there is none. Rejected data is
+// answered with 422 naming the finding in the X-Content-Scan header.
+func checkScan(w http.ResponseWriter, err error) bool {
+	var rejected *rejectedError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &rejected):
+		w.Header().Set("X-Content-Scan", rejected.finding)
+		var errs validation.Errors
+		errs.Add("data", validation.CodeRejected, "data rejected by the content scanner")
+		validation.WriteErrors(w, errs)
+	default:
+		validation.Error(w, errScannerUnavailable.Error(), http.StatusServiceUnavailable)
+	}
+	return false
+}
+
+// withholdQuarantined reports whether the data of a quarantined clipboard
+// must be left out of a list, as the client did not confirm it with an
+// X-Confirm-Quarantine header like confirmQuarantine requires.
+func withholdQuarantined(r *http.Request, c *clipboard.Clipboard) bool {
+	return c.Quarantine != "" && r.Header.Get("X-Confirm-Quarantine") != "true"
+}
+
+// confirmQuarantine checks that the client acknowledged that the content
+// of a quarantined clipboard was flagged, with an X-Confirm-Quarantine
+// header of true. If it did not, it responds with 428 and returns false.
+func confirmQuarantine(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard) bool {
+	if c.Quarantine == "" {
+		return true
+	}
+
+	w.Header().Set("X-Quarantine", c.Quarantine)
+	if r.Header.Get("X-Confirm-Quarantine") != "true" {
+		validation.Error(w, "clipboard content quarantined by the content scanner, confirm with X-Confirm-Quarantine header", http.StatusPreconditionRequired)
+		return false
+	}
+
+	return true
+}
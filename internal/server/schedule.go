@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// hidden reports whether a clipboard is hidden from the request by its
+// schedule, in which case it is reported as not found. Expired clipboards
+// are hidden from everyone until the cleanup job deletes them, and
+// clipboards scheduled for later from all but the users who may update
+// them. Tokens are handed out to readers, so they never reveal a clipboard
+// early, and anonymous clipboards have no one to show them to.
+func (s *Server) hidden(r *http.Request, c *clipboard.Clipboard) (bool, error) {
+	now := time.Now()
+	if c.Expired(now) {
+		return true, nil
+	}
+	if c.Published(now) {
+		return false, nil
+	}
+	if currentToken(r) != nil || c.OwnerId == 0 {
+		return true, nil
+	}
+
+	role, err := s.role(r, c)
+	return !clipboard.RoleAllows(role, clipboard.ActionUpdate), err
+}
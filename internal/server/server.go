@@ -1,39 +1,484 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
+	"net"
 	"net/http"
-	"os"
+	"net/http/httputil"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/bandwidth"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
 	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/federation"
+	"github.com/copybridge/copybridge-server/internal/jobs"
+	"github.com/copybridge/copybridge-server/internal/lockout"
+	"github.com/copybridge/copybridge-server/internal/notify"
+	"github.com/copybridge/copybridge-server/internal/relay"
+	"github.com/copybridge/copybridge-server/internal/retention"
+	"github.com/copybridge/copybridge-server/internal/scan"
 )
 
 type Server struct {
 	port int
 
+	// baseURL is the public URL of the server, used in share links.
+	baseURL string
+
+	// sequentialIds allows addressing any clipboard by its numeric id. If
+	// it is false, only users with access to a clipboard may.
+	sequentialIds bool
+
+	// compression configures gzip compression of requests and responses.
+	compression compressionConfig
+
+	// strictBodies rejects JSON request bodies with fields their endpoint
+	// does not take, see decodeBody.
+	strictBodies bool
+
+	// uiEnabled serves the web UI at /ui, titled uiTitle.
+	uiEnabled bool
+	uiTitle   string
+	uiStatic  fs.FS
+
 	db database.Service
+
+	trust clipboard.TrustPolicy
+	types clipboard.TypePolicy
+	// scan inspects content before it is stored, see scanClipboard.
+	scan *scan.Pipeline
+
+	// keys maps API key hashes to their users.
+	keys map[string]*account.User
+	// wireguard identifies requests from WireGuard peers. It is nil if
+	// WIREGUARD_SUBNET is unset.
+	wireguard *wireguardAuth
+	// namespaces holds the settings of every namespace by name, see
+	// namespacesFromEnv.
+	namespaces map[string]*namespaceConfig
+
+	// tokens issues and verifies session access tokens.
+	tokens *account.Tokens
+	// oidc logs users in with an OpenID Connect provider. It is nil if
+	// OIDC_ISSUER is unset.
+	oidc *oidcLogin
+
+	// ipFailures and clipboardFailures track failed password attempts.
+	ipFailures        *lockout.Tracker
+	clipboardFailures *lockout.Tracker
+
+	// bandwidth meters the bytes transferred per user, clipboard token and
+	// anonymous client, see meterBandwidth.
+	bandwidth *bandwidth.Meter
+
+	// pairingTTL is how long pairing codes are valid. pairingIPFailures and
+	// pairingFailures track failed claims per client IP and overall.
+	pairingTTL        time.Duration
+	pairingIPFailures *lockout.Tracker
+	pairingFailures   *lockout.Tracker
+	// totpFailures tracks wrong TOTP codes per user.
+	totpFailures *lockout.Tracker
+	// codeFailures tracks unknown share codes per client IP.
+	codeFailures *lockout.Tracker
+
+	uploadExpiry time.Duration
+	maxChunkSize int64
+
+	// reservationExpiry is how long reserved clipboard ids are held.
+	reservationExpiry time.Duration
+
+	// changesRetention is how long changes are kept in the changefeed, or
+	// 0 to keep them forever.
+	changesRetention time.Duration
+
+	// streamTimeout bounds requests streaming clipboard data, which are
+	// exempt from the usual read and write timeouts.
+	streamTimeout time.Duration
+	// presignTTL is how long presigned blob URLs are valid. Streamed data is
+	// proxied through the server if it is zero.
+	presignTTL time.Duration
+
+	maxStackItems int
+
+	// thumbnailSize is the edge length thumbnails fit in, and
+	// thumbnailMaxPixels the largest image thumbnails are generated for.
+	thumbnailSize      int
+	thumbnailMaxPixels int
+
+	// primary forwards writes to the primary on read-only replicas. It is
+	// nil on primaries.
+	primary *httputil.ReverseProxy
+	// trustedProxies are the networks of proxies whose X-Forwarded-For and
+	// X-Real-IP headers are trusted for client IPs, see forwardedIP.
+	trustedProxies []*net.IPNet
+	// allowedIPs and deniedIPs restrict the clients the server answers, see
+	// filterIPs.
+	allowedIPs []*net.IPNet
+	deniedIPs  []*net.IPNet
+
+	// events announces clipboard changes to bridges such as MQTT.
+	events *events.Bus
+
+	// relay brokers transfers between connected devices without storing
+	// them. It is nil if RELAY_ENABLED is false. relayTimeout is how long
+	// senders wait for the device to accept a transfer.
+	relay        *relay.Hub
+	relayTimeout time.Duration
+
+	// notifiers are the configured notification channels, by name.
+	notifiers map[string]notify.Notifier
+
+	// federation replicates a namespace of clipboards with peers. Its API
+	// is disabled if FEDERATION_SECRET is unset.
+	federation *federationStore
+
+	// jobs runs background work such as retention and backup snapshots.
+	// stats holds the statistics last aggregated by the stats job, if any.
+	jobs  *jobs.Scheduler
+	stats atomic.Pointer[statsSnapshot]
+	// jobLock picks the process running exclusive jobs among the
+	// processes sharing the database file. It is nil unless
+	// JOB_LOCK_ENABLED is set.
+	jobLock *jobLock
+
+	// dbBackup configures snapshots of the database file.
+	dbBackup dbBackupConfig
+
+	// adminTokenHash is the SHA-256 hash of ADMIN_TOKEN. The token is not
+	// accepted if adminEnabled is false, leaving the admin API to users with
+	// the admin role.
+	adminTokenHash [sha256.Size]byte
+	adminEnabled   bool
+	startedAt      time.Time
+
+	// prefix is the path programs embedding the server mount it at, or
+	// empty if it serves the root. middleware and mounts are the routes
+	// and middleware they add, see Option.
+	prefix     string
+	middleware []func(http.Handler) http.Handler
+	mounts     []mount
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
+// New configures a server from the environment on top of db and creates the
+// users of API_KEYS. Unlike NewServer, it starts no background jobs, so tests
+// and programs embedding the server can serve RegisterRoutes with a
+// database of their own, and start them with Start.
+func New(db database.Service, opts ...Option) (*Server, error) {
+	port, _ := strconv.Atoi(env.String("PORT", ""))
+	trust, err := clipboard.NewTrustPolicy(env.String("TRUST_LEVELS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUST_LEVELS: %w", err)
+	}
+	types, err := clipboard.NewTypePolicy(env.String("ALLOWED_TYPES", ""), env.Bool("SNIFF_TYPES", false))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_TYPES: %w", err)
+	}
+	err = clipboard.ConfigureKDF(clipboard.KDFConfig{
+		Params: clipboard.KDFParams{
+			LogN: env.Int("KDF_SCRYPT_LOG_N", clipboard.LegacyKDF.LogN),
+			R:    env.Int("KDF_SCRYPT_R", clipboard.LegacyKDF.R),
+			P:    env.Int("KDF_SCRYPT_P", clipboard.LegacyKDF.P),
+		},
+		MaxConcurrent: env.Int("KDF_MAX_CONCURRENT", runtime.NumCPU()),
+		CacheSize:     env.Int("KDF_CACHE_SIZE", 256),
+		CacheTTL:      env.Duration("KDF_CACHE_TTL", 5*time.Minute),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid KDF configuration: %w", err)
+	}
+	tokens, err := tokensFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := publicURLFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
 		port: port,
 
-		db: database.New(),
+		baseURL: baseURL,
+
+		sequentialIds: env.Bool("SEQUENTIAL_IDS", true),
+
+		compression: compressionConfig{
+			enabled:        env.Bool("COMPRESSION_ENABLED", true),
+			minSize:        env.Int("COMPRESSION_MIN_SIZE", 1024),
+			maxRequestSize: env.Int64("COMPRESSION_MAX_REQUEST_SIZE", 256<<20),
+		},
+
+		strictBodies: env.Bool("STRICT_REQUEST_BODIES", true),
+
+		uiEnabled: env.Bool("UI_ENABLED", true),
+		uiTitle:   env.String("UI_TITLE", "copybridge"),
+
+		db: db,
+
+		trust: trust,
+		types: types,
+
+		keys: make(map[string]*account.User),
+
+		tokens: tokens,
+
+		ipFailures: lockout.New(
+			env.Int("AUTH_MAX_FAILURES_PER_IP", 5),
+			env.Duration("AUTH_LOCKOUT_BASE", time.Second),
+			env.Duration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+		),
+		clipboardFailures: lockout.New(
+			env.Int("AUTH_MAX_FAILURES_PER_CLIPBOARD", 20),
+			env.Duration("AUTH_LOCKOUT_BASE", time.Second),
+			env.Duration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+		),
+
+		bandwidth: bandwidth.New(),
+
+		pairingTTL: env.Duration("PAIRING_CODE_TTL", 5*time.Minute),
+		pairingIPFailures: lockout.New(
+			env.Int("AUTH_MAX_FAILURES_PER_IP", 5),
+			env.Duration("AUTH_LOCKOUT_BASE", time.Second),
+			env.Duration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+		),
+		pairingFailures: lockout.New(
+			env.Int("PAIRING_MAX_FAILURES", 100),
+			env.Duration("AUTH_LOCKOUT_BASE", time.Second),
+			env.Duration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+		),
+
+		totpFailures: lockout.New(
+			env.Int("AUTH_MAX_TOTP_FAILURES", 5),
+			env.Duration("AUTH_LOCKOUT_BASE", time.Second),
+			env.Duration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+		),
+		codeFailures: lockout.New(
+			env.Int("AUTH_MAX_FAILURES_PER_IP", 5),
+			env.Duration("AUTH_LOCKOUT_BASE", time.Second),
+			env.Duration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+		),
+
+		uploadExpiry: env.Duration("UPLOAD_EXPIRY", 24*time.Hour),
+		maxChunkSize: env.Int64("UPLOAD_MAX_CHUNK_SIZE", 8<<20),
+
+		reservationExpiry: env.Duration("RESERVATION_EXPIRY", 24*time.Hour),
+
+		changesRetention: env.Duration("CHANGES_RETENTION", 30*24*time.Hour),
+
+		streamTimeout: env.Duration("STREAM_TIMEOUT", time.Hour),
+		presignTTL:    env.Duration("S3_PRESIGN_TTL", 0),
+
+		maxStackItems: env.Int("STACK_MAX_ITEMS", 100),
+
+		thumbnailSize:      env.Int("THUMBNAIL_SIZE", 256),
+		thumbnailMaxPixels: env.Int("THUMBNAIL_MAX_PIXELS", 50_000_000),
+
+		events: events.NewBus(),
+
+		relayTimeout: env.Duration("RELAY_ACCEPT_TIMEOUT", 30*time.Second),
+
+		jobs:     jobs.NewScheduler(),
+		dbBackup: dbBackupFromEnv(),
+
+		startedAt: time.Now(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.prefix != "" && !strings.HasPrefix(s.prefix, "/") {
+		return nil, fmt.Errorf("invalid prefix %q: must start with /", s.prefix)
+	}
+	if env.Bool("RELAY_ENABLED", true) {
+		s.relay = relay.NewHub()
+	}
+	if primaryURL := env.String("PRIMARY_URL", ""); primaryURL != "" {
+		s.primary, err = newPrimaryProxy(primaryURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PRIMARY_URL: %w", err)
+		}
+	}
+	if env.Bool("JOB_LOCK_ENABLED", false) {
+		if s.primary != nil {
+			return nil, errors.New("JOB_LOCK_ENABLED cannot be combined with PRIMARY_URL")
+		}
+		if s.jobLock, err = jobLockFromEnv(db); err != nil {
+			return nil, err
+		}
+		s.jobs.Elect(s.jobLock)
+	}
+	s.trustedProxies, err = parseNetworks(env.String("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	s.allowedIPs, err = parseNetworks(env.String("IP_ALLOW", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP_ALLOW: %w", err)
+	}
+	s.deniedIPs, err = parseNetworks(env.String("IP_DENY", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP_DENY: %w", err)
+	}
+	s.scan, err = scan.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s.notifiers, err = notify.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s.federation = &federationStore{s: s, cfg: federation.ConfigFromEnv()}
+	if s.federation.cfg.Enabled() {
+		if err := s.federation.cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid federation configuration: %w", err)
+		}
+	}
+	s.namespaces, err = namespacesFromEnv(quotaFromEnv("", quota{}), retention.PolicyFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("invalid NAMESPACES: %w", err)
+	}
+	s.oidc, err = oidcFromEnv(s.baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC configuration: %w", err)
+	}
+	if s.oidc != nil {
+		if _, ok := s.namespaces[s.oidc.namespace]; !ok {
+			return nil, fmt.Errorf("invalid OIDC_NAMESPACE: unknown namespace %s", s.oidc.namespace)
+		}
+	}
+	if token := env.String("ADMIN_TOKEN", ""); token != "" {
+		s.adminTokenHash = sha256.Sum256([]byte(token))
+		s.adminEnabled = true
+	}
+
+	keys, err := account.ParseKeys(env.String("API_KEYS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_KEYS: %w", err)
+	}
+	for hash, qualified := range keys {
+		namespace, name := account.SplitName(qualified)
+		if _, ok := s.namespaces[namespace]; !ok {
+			return nil, fmt.Errorf("invalid API_KEYS: unknown namespace of user %s", qualified)
+		}
+		u, err := s.db.EnsureUser(context.Background(), namespace, name)
+		if errors.Is(err, database.ErrReadOnly) {
+			log.Printf("user %s does not exist on the primary yet, ignoring their API key", qualified)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot create user %s: %w", qualified, err)
+		}
+		s.keys[hash] = u
+	}
+
+	s.wireguard, err = wireguardFromEnv(s.db, s.namespaces)
+	if err != nil {
+		return nil, err
+	}
+	if s.uiEnabled {
+		s.uiStatic, err = fs.Sub(uiFiles, "ui/static")
+		if err != nil {
+			return nil, fmt.Errorf("cannot load web UI assets: %w", err)
+		}
+	}
+
+	// Roles are applied on every start, overriding changes made with the
+	// admin API. Replicas leave them to the primary.
+	roles, err := account.ParseRoles(env.String("USER_ROLES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid USER_ROLES: %w", err)
+	}
+	for qualified, role := range roles {
+		if s.primary != nil {
+			log.Printf("ignoring the role of user %s on a replica", qualified)
+			continue
+		}
+		namespace, name := account.SplitName(qualified)
+		if _, ok := s.namespaces[namespace]; !ok {
+			return nil, fmt.Errorf("invalid USER_ROLES: unknown namespace of user %s", qualified)
+		}
+		u, err := s.db.EnsureUser(context.Background(), namespace, name)
+		if err == nil && u.Role != role {
+			err = s.db.SetRole(context.Background(), u.Id, role)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot set role of user %s: %w", qualified, err)
+		}
+	}
+
+	if err := s.addJobs(); err != nil {
+		return nil, err
+	}
+
+	// Numbers, flags and durations that do not parse were replaced by their
+	// defaults while reading them, and fail the configuration only now.
+	if err := env.Err(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return s, nil
+}
+
+// NewServer configures a server from the environment on top of the
+// database at DB_URL, and starts it with the HTTP server it returns.
+// It returns an error if the configuration is invalid or the database or
+// the bridges cannot be connected.
+func NewServer() (*http.Server, error) {
+	db, err := database.New()
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(db)
+	if err != nil {
+		return nil, err
 	}
 
 	// Declare Server config
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
+		Addr:         fmt.Sprintf(":%d", s.port),
+		Handler:      s.RegisterRoutes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	if err := s.Start(server); err != nil {
+		return nil, err
+	}
 
-	return server
+	return server, nil
+}
+
+// Start starts the background work of the server, such as its jobs,
+// federation and bridges, until server, the HTTP server serving its routes,
+// shuts down. It returns an error, before starting anything, if the MQTT
+// bridge cannot connect.
+func (s *Server) Start(server *http.Server) error {
+	// The bridge connects first, so that nothing is left running if it
+	// cannot.
+	if err := s.startMQTT(); err != nil {
+		return err
+	}
+
+	// Replicas leave federation and link titles to the primary, which they
+	// forward pushes to.
+	if s.primary == nil {
+		s.startFederation()
+		s.startPreviews()
+	}
+	s.startNotifications()
+	s.startMDNS()
+	s.startJobs(server)
+	return nil
 }
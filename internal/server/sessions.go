@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// tokensFromEnv creates the access token issuer from JWT_SECRET,
+// JWT_ACCESS_TTL and JWT_REFRESH_TTL.
+// Without a secret, a random one is generated, so sessions do not survive
+// restarts. It returns an error if the secret is too short.
+func tokensFromEnv() (*account.Tokens, error) {
+	secret := []byte(env.String("JWT_SECRET", ""))
+	switch {
+	case len(secret) == 0:
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("cannot generate JWT secret: %w", err)
+		}
+		log.Printf("JWT_SECRET is not set, sessions will not survive restarts")
+	case len(secret) < 32:
+		return nil, errors.New("invalid JWT_SECRET: must be at least 32 bytes")
+	}
+
+	return account.NewTokens(secret,
+		env.Duration("JWT_ACCESS_TTL", 15*time.Minute),
+		env.Duration("JWT_REFRESH_TTL", 30*24*time.Hour),
+	), nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type refreshBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LoginHandler exchanges an API key for a short-lived access token and a
+// refresh token, so long-running clients do not have to send the key with
+// every request.
+// Users with two-factor authentication enabled also send a TOTP code in the
+// body.
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil || currentSessionId(r) != "" {
+		validation.Error(w, "an API key is required to log in", http.StatusUnauthorized)
+		return
+	}
+
+	verified, ok := s.loginTOTP(w, r, u)
+	if !ok {
+		return
+	}
+
+	s.startSession(r.Context(), w, u, verified)
+}
+
+// startSession creates a session for a user and responds with its tokens.
+// totpVerified marks sessions logged in with a TOTP code.
+func (s *Server) startSession(ctx context.Context, w http.ResponseWriter, u *account.User, totpVerified bool) {
+	sessionId, refreshToken, ok := s.createSession(ctx, w, u, totpVerified)
+	if !ok {
+		return
+	}
+
+	s.writeTokens(w, u, sessionId, refreshToken)
+}
+
+// createSession creates a session for a user and returns its id and refresh
+// token.
+// If the session cannot be created, it writes an error response and returns
+// false.
+func (s *Server) createSession(ctx context.Context, w http.ResponseWriter, u *account.User, totpVerified bool) (sessionId, refreshToken string, ok bool) {
+	sessionId, err := account.NewSessionId()
+	if err != nil {
+		validation.Error(w, "cannot create session", http.StatusInternalServerError)
+		return "", "", false
+	}
+	refreshToken, hash, err := account.NewRefreshToken(sessionId)
+	if err != nil {
+		validation.Error(w, "cannot create session", http.StatusInternalServerError)
+		return "", "", false
+	}
+
+	sess := &account.Session{
+		Id:          sessionId,
+		UserId:      u.Id,
+		RefreshHash: hash,
+		ExpiresAt:   time.Now().UTC().Add(s.tokens.RefreshTTL),
+
+		TOTPVerified: totpVerified,
+	}
+	if err := s.db.CreateSession(ctx, sess); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return "", "", false
+	}
+
+	return sessionId, refreshToken, true
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token can only be used once; reusing one
+// revokes the whole session, since it means the token leaked.
+func (s *Server) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var body refreshBody
+	if !s.decodeBody(w, r, &body, "refresh_token") {
+		return
+	}
+
+	sessionId, oldHash, err := account.ParseRefreshToken(body.RefreshToken)
+	if err != nil {
+		validation.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	sess, err := s.db.GetSession(r.Context(), sessionId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if sess == nil || !sess.Active(time.Now()) {
+		validation.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, newHash, err := account.NewRefreshToken(sessionId)
+	if err != nil {
+		validation.Error(w, "cannot refresh session", http.StatusInternalServerError)
+		return
+	}
+	ok, err := s.db.RotateSession(r.Context(), sessionId, oldHash, newHash, time.Now().UTC().Add(s.tokens.RefreshTTL))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if err := s.db.RevokeSession(r.Context(), sessionId); err != nil {
+			log.Printf("error revoking session %s: %v", sessionId, err)
+		}
+		validation.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := s.db.User(r.Context(), sess.UserId)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	s.writeTokens(w, u, sessionId, refreshToken)
+}
+
+// LogoutHandler revokes the session of the access token of the request, or
+// of the refresh token in the body.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	sessionId := currentSessionId(r)
+	if sessionId == "" {
+		var body refreshBody
+		if !s.decodeBody(w, r, &body, "refresh_token") {
+			return
+		}
+
+		var hash string
+		var err error
+		sessionId, hash, err = account.ParseRefreshToken(body.RefreshToken)
+		if err != nil {
+			validation.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		sess, err := s.db.GetSession(r.Context(), sessionId)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+		if sess == nil || sess.RefreshHash != hash {
+			validation.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if err := s.db.RevokeSession(r.Context(), sessionId); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTokens responds with a new access token and the given refresh token.
+func (s *Server) writeTokens(w http.ResponseWriter, u *account.User, sessionId, refreshToken string) {
+	accessToken, err := s.tokens.Access(u, sessionId, time.Now())
+	if err != nil {
+		validation.Error(w, "cannot issue access token", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(tokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.AccessTTL.Seconds()),
+	})
+	_, _ = w.Write(jsonResp)
+}
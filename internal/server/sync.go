@@ -0,0 +1,400 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+)
+
+const (
+	syncWriteWait   = 10 * time.Second
+	syncPongWait    = time.Minute
+	syncPingPeriod  = syncPongWait * 9 / 10
+	syncEventBuffer = 64
+	syncMaxMessage  = 16 << 20
+)
+
+// Operations of the sync protocol.
+const (
+	// Sent by clients.
+	opSubscribe   = "subscribe"
+	opUnsubscribe = "unsubscribe"
+	opPush        = "push"
+
+	// Sent by the server.
+	opSnapshot = "snapshot"
+	opChange   = "change"
+	opAck      = "ack"
+	opConflict = "conflict"
+	opError    = "error"
+)
+
+var syncUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// syncRequest is a message sent by a sync client.
+type syncRequest struct {
+	Op string `json:"op"`
+
+	// Ids, PublicIds and Names select the clipboards to subscribe or
+	// unsubscribe. Ids are restricted like numeric ids in the API.
+	Ids       []int    `json:"ids"`
+	PublicIds []string `json:"public_ids"`
+	Names     []string `json:"names"`
+
+	// Id, Version, DataType and Data push new data to a clipboard.
+	// The push fails with a conflict unless Version is the current version
+	// of the clipboard or Force is set.
+	Id       int    `json:"id"`
+	Version  int    `json:"version"`
+	Force    bool   `json:"force"`
+	DataType string `json:"type"`
+	Data     string `json:"data"`
+}
+
+// syncMessage is a message sent to a sync client. Snapshots, changes, acks
+// and conflicts describe a clipboard with the fields of an event.
+type syncMessage struct {
+	Op string `json:"op"`
+	*events.Event
+	Message string `json:"message,omitempty"`
+}
+
+// syncSession is the state of a sync connection. It is only used by the
+// goroutine serving the connection.
+type syncSession struct {
+	s    *Server
+	r    *http.Request
+	conn *websocket.Conn
+
+	ids   map[int]bool
+	names map[string]bool
+	// known holds the clipboards the client was told about, so it learns
+	// when they are deleted.
+	known map[int]bool
+}
+
+// SyncHandler upgrades the request to a WebSocket speaking the sync
+// protocol: clients subscribe to clipboards by id or name, get a snapshot of
+// each and then every change as it happens, numbered with the sequence
+// number of the event bus. They push new data with the version it is based
+// on and get an ack or, if the clipboard changed meanwhile, a conflict
+// carrying its current state to merge with.
+// Only unencrypted clipboards can be pushed to, and the data of encrypted
+// and streamed clipboards is never sent.
+func (s *Server) SyncHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := syncUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	changes, unsubscribe := s.events.Subscribe(syncEventBuffer)
+	defer unsubscribe()
+
+	sess := &syncSession{
+		s:     s,
+		r:     r,
+		conn:  conn,
+		ids:   make(map[int]bool),
+		names: make(map[string]bool),
+		known: make(map[int]bool),
+	}
+
+	requests := make(chan syncRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go sess.read(requests, done)
+
+	ticker := time.NewTicker(syncPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			err = sess.handle(req)
+		case e := <-changes:
+			err = sess.forward(e)
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(syncWriteWait))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read passes the messages of the client on until the connection fails or
+// done is closed, and then closes requests.
+func (sess *syncSession) read(requests chan<- syncRequest, done <-chan struct{}) {
+	defer close(requests)
+
+	sess.conn.SetReadLimit(syncMaxMessage)
+	_ = sess.conn.SetReadDeadline(time.Now().Add(syncPongWait))
+	sess.conn.SetPongHandler(func(string) error {
+		return sess.conn.SetReadDeadline(time.Now().Add(syncPongWait))
+	})
+
+	for {
+		var req syncRequest
+		if err := sess.conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("sync: %v", err)
+			}
+			return
+		}
+
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (sess *syncSession) send(msg syncMessage) error {
+	_ = sess.conn.SetWriteDeadline(time.Now().Add(syncWriteWait))
+	return sess.conn.WriteJSON(msg)
+}
+
+func (sess *syncSession) sendError(format string, args ...any) error {
+	return sess.send(syncMessage{Op: opError, Message: fmt.Sprintf(format, args...)})
+}
+
+// sendClipboard sends the current state of a clipboard.
+func (sess *syncSession) sendClipboard(op string, seq uint64, c *clipboard.Clipboard) error {
+	e := clipboardEvent("", c)
+	e.Seq = seq
+	e.Time = c.UpdatedAt
+	sess.known[c.Id] = true
+	return sess.send(syncMessage{Op: op, Event: &e})
+}
+
+// handle serves a message of the client. Only failures to write to the
+// connection are returned, everything else is reported to the client.
+func (sess *syncSession) handle(req syncRequest) error {
+	switch req.Op {
+	case opSubscribe:
+		return sess.subscribe(req)
+	case opUnsubscribe:
+		for _, id := range req.Ids {
+			delete(sess.ids, id)
+		}
+		for _, publicId := range req.PublicIds {
+			if c, err := sess.s.db.GetByPublicId(sess.r.Context(), publicId); err == nil {
+				delete(sess.ids, c.Id)
+			}
+		}
+		for _, name := range req.Names {
+			delete(sess.names, name)
+		}
+		return nil
+	case opPush:
+		return sess.push(req)
+	default:
+		return sess.sendError("unknown operation %q", req.Op)
+	}
+}
+
+// subscribe adds clipboards to the subscription and sends a snapshot of each
+// of them. Snapshots carry the sequence number of the last event published
+// before they were taken, so later changes have higher numbers.
+func (sess *syncSession) subscribe(req syncRequest) error {
+	seq := sess.s.events.Seq()
+
+	for _, id := range req.Ids {
+		c, err := sess.s.db.Get(sess.r.Context(), id)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return sess.sendError("internal database error")
+		}
+		if c != nil && (!sess.numericAccess(c) || sess.hidden(c)) {
+			c = nil
+		}
+		if err := sess.subscribeTo(seq, strconv.Itoa(id), c); err != nil {
+			return err
+		}
+	}
+
+	for _, publicId := range req.PublicIds {
+		c, err := sess.s.db.GetByPublicId(sess.r.Context(), publicId)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return sess.sendError("internal database error")
+		}
+		if c != nil && (!inNamespace(sess.r, c) || sess.hidden(c)) {
+			c = nil
+		}
+		if err := sess.subscribeTo(seq, publicId, c); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range req.Names {
+		sess.names[name] = true
+
+		cs, err := sess.s.db.List(sess.r.Context(), database.ListOptions{OwnerId: currentUserId(sess.r), Namespace: currentNamespace(sess.r), Name: name, VisibleAt: time.Now(), Limit: maxListLimit})
+		if err != nil {
+			return sess.sendError("internal database error")
+		}
+		for _, c := range cs {
+			if c.Locked {
+				continue
+			}
+			sess.s.logAccess(sess.r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+			if err := sess.sendClipboard(opSnapshot, seq, c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// subscribeTo adds c, the clipboard the client subscribed to as ref, to the
+// subscription and sends a snapshot of it, or reports that it is not found
+// or may not be read.
+func (sess *syncSession) subscribeTo(seq uint64, ref string, c *clipboard.Clipboard) error {
+	if c == nil {
+		return sess.sendError("clipboard %s not found", ref)
+	}
+	if !sess.allowed(c, clipboard.ActionRead) {
+		return sess.sendError("clipboard %s: forbidden", ref)
+	}
+
+	sess.ids[c.Id] = true
+	sess.s.logAccess(sess.r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+	return sess.sendClipboard(opSnapshot, seq, c)
+}
+
+// forward sends an event to the client if it concerns a subscribed clipboard
+// the client may read.
+func (sess *syncSession) forward(e events.Event) error {
+	if !sess.ids[e.ClipboardId] && !sess.names[e.Name] && !sess.known[e.ClipboardId] {
+		return nil
+	}
+
+	if e.Type == events.ClipboardDeleted {
+		if !sess.known[e.ClipboardId] {
+			return nil
+		}
+		delete(sess.known, e.ClipboardId)
+		return sess.send(syncMessage{Op: opChange, Event: &e})
+	}
+
+	c, err := sess.s.db.Get(sess.r.Context(), e.ClipboardId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("sync: error loading clipboard %d: %v", e.ClipboardId, err)
+		return nil
+	}
+	if c == nil || !sess.allowed(c, clipboard.ActionRead) {
+		return nil
+	}
+	if !sess.ids[c.Id] && !sess.names[c.Name] {
+		// The clipboard was renamed out of the subscription.
+		return nil
+	}
+
+	sess.known[c.Id] = true
+	return sess.send(syncMessage{Op: opChange, Event: &e})
+}
+
+// push replaces the data of a clipboard if the client based it on the
+// current version, and answers with an ack or a conflict.
+func (sess *syncSession) push(req syncRequest) error {
+	c, err := sess.s.db.Get(sess.r.Context(), req.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return sess.sendError("internal database error")
+	}
+	if c == nil || !sess.numericAccess(c) {
+		return sess.sendError("clipboard %d not found", req.Id)
+	}
+	if !sess.allowed(c, clipboard.ActionUpdate) {
+		return sess.sendError("clipboard %d: forbidden", req.Id)
+	}
+	if c.IsEncrypted {
+		return sess.sendError("clipboard %d is encrypted and cannot be synced", req.Id)
+	}
+
+	if !req.Force && req.Version != c.Version {
+		return sess.sendClipboard(opConflict, sess.s.events.Seq(), c)
+	}
+
+	dataType := req.DataType
+	if dataType == "" {
+		dataType = c.DataType
+	}
+
+	err = sess.s.replaceData(sess.r.Context(), c, dataType, req.Data)
+	if err == database.ErrVersionConflict {
+		c, err = sess.s.db.Get(sess.r.Context(), req.Id)
+		if err != nil || c == nil {
+			return sess.sendError("clipboard %d was deleted", req.Id)
+		}
+		return sess.sendClipboard(opConflict, sess.s.events.Seq(), c)
+	}
+	if err != nil {
+		return sess.sendError("clipboard %d: %v", req.Id, err)
+	}
+
+	sess.s.logAccess(sess.r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+	return sess.sendClipboard(opAck, 0, c)
+}
+
+// allowed reports whether the user of the connection may perform action on
+// a clipboard.
+func (sess *syncSession) allowed(c *clipboard.Clipboard, action string) bool {
+	if c.Locked || !permits(sess.r, action) || !inNamespace(sess.r, c) || sess.hidden(c) {
+		return false
+	}
+	if c.OwnerId == 0 {
+		return true
+	}
+
+	role, err := sess.s.role(sess.r, c)
+	if err != nil {
+		log.Printf("sync: error checking role on clipboard %d: %v", c.Id, err)
+		return false
+	}
+	return clipboard.RoleAllows(role, action)
+}
+
+// numericAccess reports whether the client may address a clipboard by its
+// numeric id, see Server.numericAccess.
+func (sess *syncSession) numericAccess(c *clipboard.Clipboard) bool {
+	if !inNamespace(sess.r, c) {
+		return false
+	}
+	ok, err := sess.s.numericAccess(sess.r, c)
+	if err != nil {
+		log.Printf("sync: error checking role on clipboard %d: %v", c.Id, err)
+	}
+	return ok
+}
+
+// hidden reports whether a clipboard is hidden from the client by its
+// schedule, see Server.hidden.
+func (sess *syncSession) hidden(c *clipboard.Clipboard) bool {
+	hidden, err := sess.s.hidden(sess.r, c)
+	if err != nil {
+		log.Printf("sync: error checking role on clipboard %d: %v", c.Id, err)
+		return true
+	}
+	return hidden
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type tagsBody struct {
+	Tags []string `json:"tags"`
+}
+
+func (s *Server) AddTagsHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	var body tagsBody
+	if !s.decodeBody(w, r, &body, "tags") {
+		return
+	}
+	tags, err := clipboard.NormalizeTags(body.Tags)
+	if err != nil {
+		validation.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+
+	if err := s.db.AddTags(r.Context(), c.Id, tags); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+
+	s.writeTags(r.Context(), w, c.Id)
+}
+
+func (s *Server) RemoveTagHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	if _, ok := s.authenticate(w, r, c, clipboard.ActionUpdate); !ok {
+		return
+	}
+
+	if err := s.db.RemoveTag(r.Context(), c.Id, chi.URLParam(r, "tag")); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+
+	s.writeTags(r.Context(), w, c.Id)
+}
+
+// writeTags responds with the current tags of a clipboard.
+func (s *Server) writeTags(ctx context.Context, w http.ResponseWriter, id int) {
+	c, err := s.db.Get(ctx, id)
+	if err != nil || c == nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(tagsBody{Tags: c.Tags})
+	_, _ = w.Write(jsonResp)
+}
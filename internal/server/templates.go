@@ -0,0 +1,69 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// RenderHandler fills in the placeholders of a template clipboard with the
+// variables of the query string, e.g. ?name=Alice, and serves the result as
+// plain text. Missing variables are reported as field errors.
+func (s *Server) RenderHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionRead)
+	if !ok || !confirmQuarantine(w, r, c) {
+		return
+	}
+
+	if !clipboard.IsTemplate(c.DataType) {
+		validation.Error(w, "clipboard is not a template", http.StatusNotFound)
+		return
+	}
+
+	data, err := s.openData(r, c, password)
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+	template, err := io.ReadAll(data)
+	data.Close()
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return
+	}
+
+	vars := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		vars[name] = values[0]
+	}
+	rendered, err := clipboard.RenderTemplate(string(template), vars, time.Now())
+	if missing, ok := err.(*clipboard.MissingVariablesError); ok {
+		var errs validation.Errors
+		for _, name := range missing.Names {
+			errs.Add(name, validation.CodeRequired, "template variable is required")
+		}
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	if !confirmTrust(w, r, s.trust.AssessData("text/plain", rendered)) {
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	// The result depends on the time through the built-in variables.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(rendered)))
+	_, _ = w.Write([]byte(rendered))
+}
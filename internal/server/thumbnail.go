@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/thumbnail"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// maxThumbnailSource is the largest image data read to generate a thumbnail.
+const maxThumbnailSource = 64 << 20
+
+// ThumbnailHandler serves a small JPEG preview of an image clipboard.
+// Thumbnails are generated on first request and stored for the current
+// version of the clipboard. Thumbnails of encrypted clipboards are generated
+// on every request and never stored, as they would reveal their content.
+func (s *Server) ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	password, ok := s.authenticate(w, r, c, clipboard.ActionRead)
+	if !ok || !confirmQuarantine(w, r, c) {
+		return
+	}
+
+	if s.thumbnailSize <= 0 || !thumbnail.IsImage(c.DataType) {
+		validation.Error(w, "clipboard has no thumbnail", http.StatusNotFound)
+		return
+	}
+
+	var thumb []byte
+	if !c.IsEncrypted {
+		var err error
+		ctx, span := telemetry.Start(r.Context(), "db.Thumbnail")
+		thumb, err = s.db.Thumbnail(ctx, c.Id, c.Version)
+		telemetry.End(span, err)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if thumb == nil {
+		thumb = s.generateThumbnail(w, r, c, password)
+		if thumb == nil {
+			return
+		}
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionRead, clipboard.OutcomeSuccess)
+
+	w.Header().Set("Content-Type", thumbnail.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumb)))
+	setETag(w, c)
+	_, _ = w.Write(thumb)
+}
+
+// generateThumbnail renders the thumbnail of an image clipboard and stores
+// it unless the clipboard is encrypted.
+// If it cannot be generated, it writes an error response and returns nil.
+func (s *Server) generateThumbnail(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, password string) []byte {
+	// Opening the data decrypts the clipboard in place.
+	encrypted := c.IsEncrypted
+
+	data, err := s.openData(r, c, password)
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return nil
+	}
+	src, err := io.ReadAll(io.LimitReader(data, maxThumbnailSource+1))
+	data.Close()
+	if err != nil {
+		s.decryptionFailed(w, r, c)
+		return nil
+	}
+	if len(src) > maxThumbnailSource {
+		validation.Error(w, thumbnail.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+
+	_, span := telemetry.Start(r.Context(), "image.Thumbnail")
+	thumb, err := thumbnail.Generate(src, s.thumbnailSize, s.thumbnailMaxPixels)
+	telemetry.End(span, err)
+	switch {
+	case err == thumbnail.ErrUnsupported:
+		validation.Error(w, "no thumbnail for "+c.DataType+": "+err.Error(), http.StatusUnsupportedMediaType)
+		return nil
+	case err == thumbnail.ErrTooLarge:
+		validation.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	case err != nil:
+		validation.Error(w, "invalid image: "+err.Error(), http.StatusUnprocessableEntity)
+		return nil
+	}
+
+	if !encrypted {
+		ctx, span := telemetry.Start(r.Context(), "db.SaveThumbnail")
+		err := s.db.SaveThumbnail(ctx, c.Id, c.Version, thumb)
+		telemetry.End(span, err)
+		if err != nil {
+			log.Printf("error storing thumbnail of clipboard %d: %v", c.Id, err)
+		}
+	}
+
+	return thumb
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// ListenAndServe starts the given server.
+// It serves HTTPS with the certificate in TLS_CERT/TLS_KEY, or with
+// certificates obtained from Let's Encrypt for TLS_AUTOCERT_DOMAINS.
+// Without any TLS configuration it falls back to plaintext HTTP. See
+// serveTLS and serveCleartext for the HTTP/3 and h2c options.
+// After server.Shutdown, it returns http.ErrServerClosed once the
+// background jobs have stopped.
+func ListenAndServe(server *http.Server) error {
+	err := listenAndServe(server)
+	if errors.Is(err, http.ErrServerClosed) {
+		stopping.Wait()
+	}
+	return err
+}
+
+func listenAndServe(server *http.Server) error {
+	certFile, keyFile := env.String("TLS_CERT", ""), env.String("TLS_KEY", "")
+	domains := env.String("TLS_AUTOCERT_DOMAINS", "")
+
+	switch {
+	case domains != "":
+		cacheDir := env.String("TLS_AUTOCERT_CACHE_DIR", "certs")
+		httpAddr := env.String("TLS_AUTOCERT_HTTP_ADDR", ":80")
+
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cacheDir),
+			HostPolicy: autocert.HostWhitelist(strings.Split(domains, ",")...),
+			Email:      env.String("TLS_AUTOCERT_EMAIL", ""),
+		}
+		server.TLSConfig = m.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+
+		// The HTTP listener answers ACME http-01 challenges and redirects
+		// everything else to HTTPS.
+		go func() {
+			if err := http.ListenAndServe(httpAddr, m.HTTPHandler(nil)); err != nil {
+				log.Printf("autocert http listener stopped: %v", err)
+			}
+		}()
+
+		return serveTLS(server, "", "")
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return errors.New("both TLS_CERT and TLS_KEY must be set")
+		}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+		return serveTLS(server, certFile, keyFile)
+	default:
+		log.Printf("TLS is not configured, passwords will travel in plaintext unless a reverse proxy terminates TLS")
+
+		return serveCleartext(server)
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type tokenBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is the lifetime of the token in seconds, or 0 for a token
+	// that does not expire.
+	ExpiresIn int `json:"expires_in"`
+	// MaxViews is the number of reads the token allows, or 0 for no limit.
+	MaxViews int `json:"max_views"`
+}
+
+// createdToken is the response to a token creation, the only one holding
+// the secret of the token.
+type createdToken struct {
+	*clipboard.Token
+	Secret string `json:"token"`
+	// ShareURL is the share page of the web UI opening the clipboard with
+	// the token, for tokens allowed to read it.
+	ShareURL string `json:"share_url,omitempty"`
+}
+
+// TokensHandler lists the tokens of an owned clipboard, without their secrets.
+func (s *Server) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSharedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	ts, err := s.db.Tokens(r.Context(), c.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(ts)
+	_, _ = w.Write(jsonResp)
+}
+
+// CreateTokenHandler creates a token granting the given scopes on an owned
+// clipboard. The secret of the token is only returned in this response.
+func (s *Server) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSharedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	var body tokenBody
+	if !s.decodeBody(w, r, &body, "name", "scopes", "expires_in", "max_views") {
+		return
+	}
+
+	var errs validation.Errors
+	scopes, err := clipboard.NormalizeScopes(body.Scopes)
+	if err != nil {
+		errs.Add("scopes", validation.CodeInvalid, err.Error())
+	}
+	if len(body.Name) > validation.MaxNameLength {
+		errs.Add("name", validation.CodeTooLarge, "name is too long")
+	}
+	if body.ExpiresIn < 0 {
+		errs.Add("expires_in", validation.CodeInvalid, "expires_in must not be negative")
+	}
+	if body.MaxViews < 0 {
+		errs.Add("max_views", validation.CodeInvalid, "max_views must not be negative")
+	}
+	if len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	id, secret, err := clipboard.NewTokenSecret()
+	if err != nil {
+		validation.Error(w, "token generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	t := &clipboard.Token{
+		Id:          id,
+		ClipboardId: c.Id,
+		Name:        body.Name,
+		Scopes:      scopes,
+		Hash:        account.HashKey(secret),
+		MaxViews:    body.MaxViews,
+	}
+	if body.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().Add(time.Duration(body.ExpiresIn) * time.Second)
+		t.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.CreateToken(r.Context(), t); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionShare, clipboard.OutcomeSuccess)
+
+	created := createdToken{Token: t, Secret: secret}
+	if t.Allows(clipboard.ActionRead) {
+		created.ShareURL = s.shareURL(r, c, secret)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(created)
+	_, _ = w.Write(jsonResp)
+}
+
+// DeleteTokenHandler revokes a token of an owned clipboard.
+func (s *Server) DeleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.loadSharedClipboard(w, r)
+	if c == nil {
+		return
+	}
+
+	ok, err := s.db.DeleteToken(r.Context(), c.Id, chi.URLParam(r, "tokenId"))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		validation.Error(w, "token not found", http.StatusNotFound)
+		return
+	}
+
+	s.logAccess(r, c.Id, clipboard.ActionShare, clipboard.OutcomeSuccess)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// identifyToken checks that a clipboard token exists and has not expired
+// before serving the request with it. The request stays anonymous; the
+// token only grants access to its clipboard.
+func (s *Server) identifyToken(w http.ResponseWriter, r *http.Request, secret string, next http.Handler) {
+	t, err := s.db.TokenByHash(r.Context(), account.HashKey(secret))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if t == nil || !t.Active(time.Now()) {
+		validation.Error(w, "clipboard token expired or revoked", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.db.MarkTokenUsed(r.Context(), t.Id); err != nil {
+		log.Printf("error marking clipboard token %s as used: %v", t.Id, err)
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey, t)))
+}
+
+// countView uses up a view of the clipboard token of a read, if the token
+// is view-limited. Views are counted by the primary, so replicas forward
+// such reads to it. It responds with 401 and returns false if the token has
+// no views left.
+func (s *Server) countView(w http.ResponseWriter, r *http.Request, c *clipboard.Clipboard, action string) bool {
+	t := currentToken(r)
+	if t == nil || t.MaxViews == 0 || action != clipboard.ActionRead {
+		return true
+	}
+	if s.primary != nil {
+		s.primary.ServeHTTP(w, r)
+		return false
+	}
+
+	ok, err := s.db.UseTokenView(r.Context(), t.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		s.logAccess(r, c.Id, action, clipboard.OutcomeUnauthorized)
+		validation.Error(w, "clipboard token expired or revoked", http.StatusUnauthorized)
+		return false
+	}
+	t.Views++
+	return true
+}
+
+// shareURL returns the link to the share page of the web UI for a clipboard
+// token. The clipboard and the secret are in the fragment, which browsers
+// do not send to the server.
+func (s *Server) shareURL(r *http.Request, c *clipboard.Clipboard, secret string) string {
+	return s.publicURL(r) + "/ui/share#" + strconv.Itoa(c.Id) + ":" + secret
+}
+
+// denyTokens rejects requests made with a clipboard token, for routes that
+// are not about a single clipboard.
+func denyTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentToken(r) != nil {
+			validation.Error(w, "clipboard tokens only grant access to their clipboard", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentToken returns the clipboard token the request was made with, or nil.
+func currentToken(r *http.Request) *clipboard.Token {
+	t, _ := r.Context().Value(tokenContextKey).(*clipboard.Token)
+	return t
+}
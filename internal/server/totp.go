@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type totpBody struct {
+	Code string `json:"code"`
+}
+
+type totpSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPSetupHandler starts enrolling the user in two-factor authentication
+// and responds with a new TOTP secret and its otpauth:// URI for
+// authenticator apps. The secret is only required to log in once it is
+// confirmed with TOTPVerifyHandler; setting up again replaces an unconfirmed
+// secret.
+func (s *Server) TOTPSetupHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		validation.Error(w, "an API key is required", http.StatusUnauthorized)
+		return
+	}
+
+	t, err := s.db.TOTP(r.Context(), u.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if t != nil && t.Enabled {
+		validation.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := account.NewTOTPSecret()
+	if err != nil {
+		validation.Error(w, "cannot create TOTP secret", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.SetTOTPSecret(r.Context(), u.Id, secret); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	name := u.Name
+	if u.Namespace != account.DefaultNamespace {
+		name = u.Namespace + "/" + u.Name
+	}
+	resp := totpSetupResponse{
+		Secret: secret,
+		URI:    account.TOTPURI(env.String("TOTP_ISSUER", "copybridge"), name, secret),
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(resp)
+	_, _ = w.Write(jsonResp)
+}
+
+// TOTPVerifyHandler confirms the TOTP secret of the user with a code from
+// their authenticator app, which enables two-factor authentication. Later
+// logins take a code, as does the admin API for users with the admin role.
+func (s *Server) TOTPVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		validation.Error(w, "an API key is required", http.StatusUnauthorized)
+		return
+	}
+
+	var body totpBody
+	if !s.decodeBody(w, r, &body, "code") {
+		return
+	}
+	if body.Code == "" {
+		var errs validation.Errors
+		errs.Add("code", validation.CodeRequired, "code is required")
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	t, err := s.db.TOTP(r.Context(), u.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		validation.Error(w, "two-factor authentication is not set up", http.StatusConflict)
+		return
+	}
+	if !s.checkTOTP(r.Context(), w, u, t, body.Code) {
+		return
+	}
+
+	u.TOTPEnabled = true
+	jsonResp, _ := json.Marshal(u)
+	_, _ = w.Write(jsonResp)
+}
+
+// TOTPDisableHandler turns two-factor authentication of the user off, which
+// takes a current code.
+func (s *Server) TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		validation.Error(w, "an API key is required", http.StatusUnauthorized)
+		return
+	}
+
+	var body totpBody
+	if !s.decodeBody(w, r, &body, "code") {
+		return
+	}
+
+	t, err := s.db.TOTP(r.Context(), u.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if t == nil || !t.Enabled {
+		validation.Error(w, "two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	if !s.checkTOTP(r.Context(), w, u, t, body.Code) {
+		return
+	}
+
+	if err := s.db.DisableTOTP(r.Context(), u.Id); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminResetTOTPHandler turns two-factor authentication of a user off, for
+// users who lost their authenticator.
+func (s *Server) AdminResetTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := s.db.UserByName(r.Context(), adminNamespace(r), chi.URLParam(r, "user"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		validation.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.DisableTOTP(r.Context(), u.Id); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTOTP verifies a code against the TOTP secret of a user and records
+// it, so it cannot be used again. Wrong codes count towards the lockout of
+// the user.
+// If the code is not accepted, it writes an error response and returns
+// false.
+func (s *Server) checkTOTP(ctx context.Context, w http.ResponseWriter, u *account.User, t *account.TOTP, code string) bool {
+	key := strconv.Itoa(u.Id)
+	if wait := s.totpFailures.Locked(key); wait > 0 {
+		tooManyAttempts(w, wait)
+		return false
+	}
+
+	step, ok := account.VerifyTOTP(t.Secret, code, t.LastStep, time.Now())
+	if ok {
+		var err error
+		if ok, err = s.db.UseTOTP(ctx, u.Id, step); err != nil {
+			validation.Error(w, "internal database error", http.StatusInternalServerError)
+			return false
+		}
+	}
+	if !ok {
+		if wait := s.totpFailures.Fail(key); wait > 0 {
+			tooManyAttempts(w, wait)
+			return false
+		}
+		validation.Error(w, "invalid two-factor authentication code", http.StatusUnauthorized)
+		return false
+	}
+
+	s.totpFailures.Succeed(key)
+	return true
+}
+
+// loginTOTP checks the code sent to log in if the user has two-factor
+// authentication enabled, and reports whether the session is verified with
+// it. The body of the request is optional.
+// If the login is refused, it writes an error response and returns ok false.
+func (s *Server) loginTOTP(w http.ResponseWriter, r *http.Request, u *account.User) (verified, ok bool) {
+	var body totpBody
+	if !s.decodeOptionalBody(w, r, &body, "code") {
+		return false, false
+	}
+
+	t, err := s.db.TOTP(r.Context(), u.Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return false, false
+	}
+	if t == nil || !t.Enabled {
+		return false, true
+	}
+	if body.Code == "" {
+		validation.Error(w, "a two-factor authentication code is required", http.StatusUnauthorized)
+		return false, false
+	}
+	if !s.checkTOTP(r.Context(), w, u, t, body.Code) {
+		return false, false
+	}
+	return true, true
+}
+
+// currentTOTPVerified reports whether the request was made with an access
+// token of a session logged in with a TOTP code.
+func currentTOTPVerified(r *http.Request) bool {
+	verified, _ := r.Context().Value(totpContextKey).(bool)
+	return verified
+}
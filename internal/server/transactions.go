@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/telemetry"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// maxTransactionWrites is the maximum number of writes in a transaction.
+const maxTransactionWrites = 100
+
+// transactionWriteHeader names the write a transaction failed at.
+const transactionWriteHeader = "X-Transaction-Write"
+
+type transactionBody struct {
+	Writes []transactionWrite `json:"writes"`
+}
+
+// transactionWrite is a write of a transaction: a clipboard to create, or
+// the clipboard identified by Id to update or delete if it is still at
+// Version. Responses list the writes applied with the new versions of the
+// clipboards.
+type transactionWrite struct {
+	Op        string               `json:"op"`
+	Id        string               `json:"id,omitempty"`
+	Version   int                  `json:"version,omitempty"`
+	Clipboard *clipboard.Clipboard `json:"clipboard,omitempty"`
+}
+
+// TransactionHandler applies writes to several clipboards atomically, so
+// clients keeping paired clipboards, such as a username and a password, in
+// step never leave one of them updated alone. Each write is checked like a
+// request of its own to create, update or delete the clipboard, with the
+// Basic Auth password of the request for encrypted clipboards, and against
+// the quotas together with the writes before it. If any write fails, none is
+// applied and the error of the write is returned, with its position in the
+// X-Transaction-Write header.
+func (s *Server) TransactionHandler(w http.ResponseWriter, r *http.Request) {
+	var body transactionBody
+	if !s.decodeBody(w, r, &body, "writes") {
+		return
+	}
+	if errs := checkTransaction(body.Writes); len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return
+	}
+
+	writes := make([]database.Write, len(body.Writes))
+	current := make([]*clipboard.Clipboard, len(body.Writes))
+	written := make(map[int]bool)
+	usage := make(map[quotaOwner]quotaUsage)
+	for i, tw := range body.Writes {
+		w.Header().Set(transactionWriteHeader, strconv.Itoa(i))
+		c, delta, ok := s.prepareWrite(w, r, tw)
+		if !ok {
+			return
+		}
+		// The quotas are checked against all writes so far, as none of
+		// them is stored yet.
+		if tw.Op != database.OpDelete {
+			owner := quotaOwner{c.Namespace, c.OwnerId}
+			u := usage[owner]
+			if tw.Op == database.OpCreate {
+				u.clipboards++
+			}
+			u.bytes += delta
+			usage[owner] = u
+			if !s.checkQuota(r.Context(), w, owner.namespace, owner.id, u.clipboards, u.bytes) {
+				return
+			}
+		}
+		if tw.Op != database.OpCreate {
+			if written[c.Id] {
+				validation.Error(w, "clipboard written twice in the transaction", http.StatusUnprocessableEntity)
+				return
+			}
+			written[c.Id] = true
+		}
+		writes[i] = database.Write{Op: tw.Op, Clipboard: c}
+		current[i] = c
+	}
+
+	ctx, span := telemetry.Start(r.Context(), "db.Transact")
+	err := s.db.Transact(ctx, writes)
+	telemetry.End(span, err)
+	var writeErr *database.WriteError
+	if errors.As(err, &writeErr) {
+		w.Header().Set(transactionWriteHeader, strconv.Itoa(writeErr.Index))
+		err = writeErr.Err
+	}
+	if err != nil {
+		databaseError(w, err)
+		return
+	}
+	w.Header().Del(transactionWriteHeader)
+
+	applied := make([]transactionWrite, len(writes))
+	for i, write := range writes {
+		c := write.Clipboard
+		applied[i] = transactionWrite{Op: write.Op, Id: c.PublicId, Version: c.Version}
+		switch write.Op {
+		case database.OpCreate:
+			s.logAccess(r, c.Id, clipboard.ActionCreate, clipboard.OutcomeSuccess)
+			s.publish(events.ClipboardCreated, c)
+			applied[i].Clipboard = c
+		case database.OpUpdate:
+			s.logAccess(r, c.Id, clipboard.ActionUpdate, clipboard.OutcomeSuccess)
+			s.publish(events.ClipboardUpdated, c)
+			applied[i].Clipboard = c
+		case database.OpDelete:
+			// Deduplicated clipboards only lost a reference.
+			applied[i].Version = 0
+			if current[i].Refs > 1 {
+				s.logAccess(r, c.Id, clipboard.ActionDelete, clipboard.OutcomeSuccess)
+			} else {
+				s.publish(events.ClipboardDeleted, c)
+			}
+		}
+	}
+
+	jsonResp, _ := json.Marshal(transactionBody{Writes: applied})
+	_, _ = w.Write(jsonResp)
+}
+
+// quotaOwner identifies the quota a write of a transaction counts against.
+type quotaOwner struct {
+	namespace string
+	id        int
+}
+
+// quotaUsage sums the clipboards and bytes the writes of a transaction add
+// to a quota.
+type quotaUsage struct {
+	clipboards int
+	bytes      int64
+}
+
+// checkTransaction validates the writes of a transaction, without looking
+// at the clipboards they address.
+func checkTransaction(writes []transactionWrite) validation.Errors {
+	var errs validation.Errors
+	switch {
+	case len(writes) == 0:
+		errs.Add("writes", validation.CodeRequired, "writes are required")
+	case len(writes) > maxTransactionWrites:
+		errs.Add("writes", validation.CodeTooLarge, fmt.Sprintf("at most %d writes are allowed", maxTransactionWrites))
+	}
+
+	for i, tw := range writes {
+		field := fmt.Sprintf("writes[%d].", i)
+		switch tw.Op {
+		case database.OpCreate:
+			if tw.Id != "" || tw.Version != 0 {
+				errs.Add(field+"id", validation.CodeInvalid, "clipboards to create are not addressed by id and version")
+			}
+		case database.OpUpdate, database.OpDelete:
+			if tw.Id == "" {
+				errs.Add(field+"id", validation.CodeRequired, "id is required")
+			}
+			if tw.Version <= 0 {
+				errs.Add(field+"version", validation.CodeRequired, "version is required")
+			}
+		default:
+			errs.Add(field+"op", validation.CodeInvalid, "op must be create, update or delete")
+			continue
+		}
+		if tw.Op == database.OpDelete {
+			if tw.Clipboard != nil {
+				errs.Add(field+"clipboard", validation.CodeInvalid, "deletes take no clipboard")
+			}
+		} else if tw.Clipboard == nil {
+			errs.Add(field+"clipboard", validation.CodeRequired, "clipboard is required")
+		}
+	}
+	return errs
+}
+
+// prepareWrite checks a write of a transaction like the request creating,
+// updating or deleting the clipboard would, and returns the clipboard to
+// write with the bytes it adds to the quota of its owner. If the write may
+// not be applied, it writes an error response and returns false.
+func (s *Server) prepareWrite(w http.ResponseWriter, r *http.Request, tw transactionWrite) (*clipboard.Clipboard, int64, bool) {
+	if tw.Op == database.OpCreate {
+		c := tw.Clipboard
+		ok := decodeText(w, c, false) && s.prepareClipboard(w, r, c)
+		return c, int64(c.DataSize()), ok
+	}
+
+	c := s.findClipboard(w, r, tw.Id)
+	if c == nil {
+		return nil, 0, false
+	}
+
+	var password string
+	switch tw.Op {
+	case database.OpUpdate:
+		if !decodeText(w, tw.Clipboard, false) {
+			return nil, 0, false
+		}
+		oldSize := c.Size
+		var ok bool
+		if _, password, ok = s.prepareUpdate(w, r, c, tw.Clipboard); !ok {
+			return nil, 0, false
+		}
+		if !checkWriteVersion(w, c, tw.Version) {
+			return nil, 0, false
+		}
+		ok = s.sealUpdate(w, r, c, password, oldSize)
+		return c, int64(c.DataSize() - oldSize), ok
+	default:
+		if _, ok := s.authenticate(w, r, c, clipboard.ActionDelete); !ok || !checkWritable(w, c) {
+			return nil, 0, false
+		}
+		return c, 0, checkWriteVersion(w, c, tw.Version)
+	}
+}
+
+// checkWriteVersion requires a clipboard to be at the version a write of a
+// transaction expects, like checkVersion requires it of If-Match headers.
+func checkWriteVersion(w http.ResponseWriter, c *clipboard.Clipboard, version int) bool {
+	if c.Version == version {
+		return true
+	}
+	validation.Error(w, "clipboard was modified, current version is "+strconv.Itoa(c.Version), http.StatusConflict)
+	return false
+}
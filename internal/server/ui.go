@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// uiFiles holds the web UI, compiled into the binary so it needs no files
+// next to it.
+//
+//go:embed ui/templates ui/static
+var uiFiles embed.FS
+
+var uiTemplates = template.Must(template.ParseFS(uiFiles, "ui/templates/*.html"))
+
+// uiPolicy only lets the UI load its own scripts and styles and talk to its
+// own origin, and keeps other sites from framing it.
+const uiPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self' blob:; " +
+	"connect-src 'self'; form-action 'self'; base-uri 'none'; frame-ancestors 'none'"
+
+// uiRoutes serves the web UI for viewing, creating and copying clipboards
+// from browsers. It uses the JSON API of the same origin, so it needs no
+// CORS headers.
+func (s *Server) uiRoutes(r chi.Router) {
+	static := s.uiStatic
+	r.Use(uiHeaders)
+	r.Get("/", s.UIHandler)
+	r.Get("/share", s.UIShareHandler)
+	// The service worker is served from /ui/ rather than /ui/static/, so
+	// it may control all pages of the UI.
+	r.Handle("/sw.js", http.StripPrefix("/ui/", http.FileServer(http.FS(static))))
+	r.Handle("/static/*", http.StripPrefix("/ui/static/", http.FileServer(http.FS(static))))
+}
+
+func uiHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", uiPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		// Public ids in the URL must not leak to linked sites.
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		// Embedded assets have no modification times to revalidate with, and
+		// change with every release.
+		w.Header().Set("Cache-Control", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UIHandler renders the page of the web UI.
+func (s *Server) UIHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderUI(w, "index.html")
+}
+
+// UIShareHandler renders the share page, which opens a clipboard with the
+// token in the fragment of its URL, so recipients need no account. Password
+// prompts and end-to-end decryption happen in the browser.
+func (s *Server) UIShareHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderUI(w, "share.html")
+}
+
+func (s *Server) renderUI(w http.ResponseWriter, page string) {
+	var buf bytes.Buffer
+	err := uiTemplates.ExecuteTemplate(&buf, page, struct {
+		Title  string
+		Base   string
+		Static string
+	}{
+		Title:  s.uiTitle,
+		Base:   s.prefix,
+		Static: s.path("/ui/static"),
+	})
+	if err != nil {
+		log.Printf("cannot render web UI: %v", err)
+		validation.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,294 @@
+// The web UI talks to the JSON API of the server it is served from. The API
+// key is kept in the session storage of the tab, so it is gone once the tab
+// is closed, and sent in the X-API-Key header, never in a cookie, so other
+// sites cannot make requests on behalf of the user.
+"use strict";
+
+const keyStorage = "copybridge.key";
+
+// base is the path the server is mounted at, empty if it serves the root.
+const base = document.body.dataset.base || "";
+
+const $ = (id) => document.getElementById(id);
+
+let current = null;
+let currentPassword = "";
+let objectURL = null;
+// pushKey is the key of the server to subscribe to Web Push with, or null
+// if the server or browser does not support it.
+let pushKey = null;
+
+function status(message, isError) {
+  $("status").textContent = message || "";
+  $("status").className = isError ? "error" : "";
+}
+
+function headers(password) {
+  const h = {};
+  const key = sessionStorage.getItem(keyStorage);
+  if (key) {
+    h["X-API-Key"] = key;
+  }
+  if (password) {
+    h["Authorization"] = "Basic " + btoa(unescape(encodeURIComponent(":" + password)));
+  }
+  return h;
+}
+
+// confirmUntrusted warns that a clipboard was flagged as a script or an
+// executable and returns whether the user wants to open it anyway.
+function confirmUntrusted(level) {
+  return confirm("This clipboard was flagged as " + (level === "executable" ? "an executable" : "a " + level) +
+    ", which can harm your device if you run it. Open it anyway?");
+}
+
+// request sends a request to the API. Content flagged as untrusted is
+// requested again once the user confirmed the warning, and options.trust
+// keeps the confirmed level for further requests with the same options.
+async function request(method, path, options) {
+  options = options || {};
+  const h = headers(options.password);
+  if (options.trust) {
+    h["X-Confirm-Untrusted"] = options.trust;
+  }
+  let body;
+  if (options.json !== undefined) {
+    h["Content-Type"] = "application/json";
+    body = JSON.stringify(options.json);
+  }
+  const resp = await fetch(base + path, { method, headers: h, body, credentials: "omit" });
+  const level = resp.headers.get("X-Content-Trust");
+  if (resp.status === 428 && level && options.trust !== level && confirmUntrusted(level)) {
+    options.trust = level;
+    return request(method, path, options);
+  }
+  if (!resp.ok) {
+    let message = resp.status + " " + resp.statusText;
+    try {
+      const err = await resp.json();
+      if (err.message) {
+        message = err.message;
+      }
+    } catch (e) {
+      // Not a JSON error response.
+    }
+    throw new Error(message);
+  }
+  return resp;
+}
+
+function isText(type) {
+  const base = (type || "").split(";")[0].trim().toLowerCase();
+  return base.startsWith("text/") || base === "application/json" || base.endsWith("+json") ||
+    base === "application/xml" || base.endsWith("+xml") || base === "application/javascript";
+}
+
+async function open(id, password) {
+  status("Loading…");
+  try {
+    // The options keep the confirmation of untrusted content for the data.
+    const options = { password };
+    const c = await (await request("GET", "/clipboard/" + encodeURIComponent(id), options)).json();
+    current = c;
+    currentPassword = password;
+    $("view").hidden = false;
+    $("view-name").textContent = c.name;
+    $("view-meta").textContent = [c.type, c.size + " bytes", "version " + c.version,
+      c.is_encrypted ? "encrypted" : "", c.pinned ? "pinned" : ""].filter(Boolean).join(" · ");
+
+    if (objectURL) {
+      URL.revokeObjectURL(objectURL);
+      objectURL = null;
+    }
+    $("view-image").hidden = true;
+    $("download").hidden = true;
+    $("view-data").hidden = false;
+    $("copy").hidden = false;
+    $("notify").hidden = !pushKey;
+    if (!isText(c.type)) {
+      const blob = await (await request("GET", "/clipboard/" + encodeURIComponent(id) + "/raw", options)).blob();
+      objectURL = URL.createObjectURL(blob);
+      $("view-data").hidden = true;
+      $("copy").hidden = true;
+      $("download").href = objectURL;
+      $("download").download = c.name;
+      $("download").hidden = false;
+      if (c.type.startsWith("image/")) {
+        $("view-image").src = objectURL;
+        $("view-image").hidden = false;
+      }
+    } else if (isE2E(c.type)) {
+      const key = password || $("open-password").value;
+      if (!key) {
+        $("view-data").value = "";
+        current = null;
+        status("Enter the password to decrypt this clipboard", true);
+        return;
+      }
+      current = Object.assign({}, c, { data: await e2eDecrypt(c.data, key) });
+      $("view-data").value = current.data;
+    } else {
+      $("view-data").value = c.data;
+    }
+    history.replaceState(null, "", "?id=" + encodeURIComponent(c.public_id || c.id));
+    status("");
+  } catch (e) {
+    status(e.message, true);
+  }
+}
+
+async function copy() {
+  if (!current) {
+    return;
+  }
+  try {
+    await navigator.clipboard.writeText(current.data);
+  } catch (e) {
+    // The Clipboard API is only available in secure contexts, which plain
+    // HTTP on a LAN is not.
+    $("view-data").select();
+    if (!document.execCommand("copy")) {
+      status("Copying failed, select the text and copy it manually", true);
+      return;
+    }
+  }
+  status("Copied");
+}
+
+function base64URLBytes(s) {
+  const binary = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+  return Uint8Array.from(binary, (c) => c.charCodeAt(0));
+}
+
+// setUpPush registers the service worker showing Web Push notifications if
+// the server has them enabled.
+async function setUpPush() {
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    return;
+  }
+  try {
+    const resp = await fetch(base + "/notifications/webpush", { credentials: "omit" });
+    if (!resp.ok) {
+      return;
+    }
+    await navigator.serviceWorker.register(base + "/ui/sw.js", { scope: base + "/ui/" });
+    pushKey = base64URLBytes((await resp.json()).public_key);
+    $("notify").hidden = !current;
+  } catch (e) {
+    // Push notifications stay unavailable, e.g. on plain HTTP.
+  }
+}
+
+// subscribe asks for notifications about updates of the open clipboard
+// through the push subscription of this browser.
+async function subscribe() {
+  if (!current || !pushKey) {
+    return;
+  }
+  try {
+    if (await Notification.requestPermission() !== "granted") {
+      status("Notifications are blocked in this browser", true);
+      return;
+    }
+    const registration = await navigator.serviceWorker.ready;
+    const sub = await registration.pushManager.getSubscription() ||
+      await registration.pushManager.subscribe({ userVisibleOnly: true, applicationServerKey: pushKey });
+    await request("POST", "/clipboard/" + encodeURIComponent(current.id) + "/notifications", {
+      json: { channel: "webpush", target: JSON.stringify(sub.toJSON()) },
+      password: currentPassword,
+    });
+    status("You will be notified when " + current.name + " changes");
+  } catch (e) {
+    status(e.message, true);
+  }
+}
+
+async function create(event) {
+  event.preventDefault();
+  const password = $("create-password").value;
+  const e2e = $("create-e2e").checked;
+  const body = {
+    name: $("create-name").value,
+    type: $("create-type").value || "text/plain",
+    data: $("create-data").value,
+    is_encrypted: password !== "" && !e2e,
+  };
+  try {
+    if (e2e) {
+      if (!password) {
+        throw new Error("Encrypting in the browser needs a password");
+      }
+      // The server never sees the password or the data, only the envelope.
+      body.type = e2eType;
+      body.data = await e2eEncrypt(body.data, password);
+    }
+    const c = await (await request("POST", "/clipboard", { json: body, password: body.is_encrypted ? password : "" })).json();
+    $("create-form").reset();
+    status("Created clipboard " + c.id);
+    await open(c.public_id || c.id, password);
+    await list();
+  } catch (e) {
+    status(e.message, true);
+  }
+}
+
+async function list() {
+  const ul = $("list");
+  try {
+    const cs = await (await request("GET", "/clipboard")).json();
+    ul.replaceChildren();
+    for (const c of cs) {
+      const a = document.createElement("a");
+      a.href = "?id=" + encodeURIComponent(c.public_id || c.id);
+      a.textContent = c.name;
+      a.addEventListener("click", (event) => {
+        event.preventDefault();
+        $("open-id").value = c.public_id || c.id;
+        open(c.public_id || c.id, c.is_encrypted ? $("open-password").value : "");
+      });
+      const li = document.createElement("li");
+      li.append(a, " ", Object.assign(document.createElement("span"), {
+        className: "meta",
+        textContent: c.type + (c.is_encrypted ? " · encrypted" : ""),
+      }));
+      ul.append(li);
+    }
+  } catch (e) {
+    ul.replaceChildren();
+    status(e.message, true);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  // Earlier versions kept the key in local storage, where it outlived the
+  // tab.
+  localStorage.removeItem(keyStorage);
+  $("key").value = sessionStorage.getItem(keyStorage) || "";
+  $("key-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const key = $("key").value.trim();
+    if (key) {
+      sessionStorage.setItem(keyStorage, key);
+    } else {
+      sessionStorage.removeItem(keyStorage);
+    }
+    status("Saved");
+    list();
+  });
+  $("open-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    open($("open-id").value.trim(), $("open-password").value);
+  });
+  $("create-form").addEventListener("submit", create);
+  $("copy").addEventListener("click", copy);
+  $("notify").addEventListener("click", subscribe);
+  $("refresh").addEventListener("click", list);
+
+  const id = new URLSearchParams(location.search).get("id");
+  if (id) {
+    $("open-id").value = id;
+    open(id, "");
+  }
+  list();
+  setUpPush();
+});
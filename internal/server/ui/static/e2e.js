@@ -0,0 +1,61 @@
+// End-to-end encryption of clipboards in the browser with WebCrypto. The
+// server only stores the envelope: the key is derived from the password
+// with PBKDF2-SHA256 and the data encrypted with AES-256-GCM.
+"use strict";
+
+const e2eType = "application/vnd.copybridge.e2e+json";
+const e2eIterations = 310000;
+
+function isE2E(type) {
+  return (type || "").split(";")[0].trim().toLowerCase() === e2eType;
+}
+
+function bytesToBase64(bytes) {
+  let binary = "";
+  for (const b of bytes) {
+    binary += String.fromCharCode(b);
+  }
+  return btoa(binary);
+}
+
+function base64ToBytes(s) {
+  return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+}
+
+async function e2eKey(password, salt, iterations) {
+  const material = await crypto.subtle.importKey("raw", new TextEncoder().encode(password), "PBKDF2", false, ["deriveKey"]);
+  return crypto.subtle.deriveKey({ name: "PBKDF2", hash: "SHA-256", salt, iterations },
+    material, { name: "AES-GCM", length: 256 }, false, ["encrypt", "decrypt"]);
+}
+
+// e2eEncrypt returns the envelope of text encrypted with password.
+async function e2eEncrypt(text, password) {
+  const salt = crypto.getRandomValues(new Uint8Array(16));
+  const iv = crypto.getRandomValues(new Uint8Array(12));
+  const key = await e2eKey(password, salt, e2eIterations);
+  const data = await crypto.subtle.encrypt({ name: "AES-GCM", iv }, key, new TextEncoder().encode(text));
+  return JSON.stringify({
+    v: 1,
+    kdf: "PBKDF2-SHA256",
+    iterations: e2eIterations,
+    salt: bytesToBase64(salt),
+    iv: bytesToBase64(iv),
+    data: bytesToBase64(new Uint8Array(data)),
+  });
+}
+
+// e2eDecrypt returns the text of an envelope, failing if the password is
+// wrong or the envelope was tampered with.
+async function e2eDecrypt(envelope, password) {
+  const e = JSON.parse(envelope);
+  if (e.v !== 1 || e.kdf !== "PBKDF2-SHA256") {
+    throw new Error("Unsupported encryption format");
+  }
+  const key = await e2eKey(password, base64ToBytes(e.salt), e.iterations);
+  try {
+    const data = await crypto.subtle.decrypt({ name: "AES-GCM", iv: base64ToBytes(e.iv) }, key, base64ToBytes(e.data));
+    return new TextDecoder().decode(data);
+  } catch (err) {
+    throw new Error("Wrong password");
+  }
+}
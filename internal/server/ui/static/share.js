@@ -0,0 +1,175 @@
+// The share page opens a clipboard with the token in the fragment of its
+// URL, #<id>:<token>, which browsers never send to the server. Every
+// successful load uses up one view of a view-limited token, so the page
+// loads the data once and only asks for a password when the server or the
+// end-to-end encryption needs one.
+"use strict";
+
+const base = document.body.dataset.base || "";
+
+const $ = (id) => document.getElementById(id);
+
+let share = null;
+// loaded is the response of the server, kept so decrypting end-to-end
+// encrypted data with another password does not use up another view.
+let loaded = null;
+let text = null;
+
+function status(message, isError) {
+  $("status").textContent = message || "";
+  $("status").className = isError ? "error" : "";
+}
+
+function parseFragment() {
+  const fragment = decodeURIComponent(location.hash.slice(1));
+  const i = fragment.indexOf(":");
+  if (i <= 0 || i === fragment.length - 1) {
+    return null;
+  }
+  return { id: fragment.slice(0, i), token: fragment.slice(i + 1) };
+}
+
+function filename(disposition) {
+  const m = /filename\*=UTF-8''([^;]+)|filename="([^"]*)"/i.exec(disposition || "");
+  if (!m) {
+    return "clipboard";
+  }
+  return m[1] ? decodeURIComponent(m[1]) : m[2];
+}
+
+// confirmUntrusted warns that a clipboard was flagged as a script or an
+// executable and returns whether the user wants to open it anyway.
+function confirmUntrusted(level) {
+  return confirm("This clipboard was flagged as " + (level === "executable" ? "an executable" : "a " + level) +
+    ", which can harm your device if you run it. Open it anyway?");
+}
+
+// load fetches the data of the clipboard, with the password of a clipboard
+// encrypted by the server and the trust level the user confirmed, if any.
+// Wrong passwords are rejected before a view is used up, unlike untrusted
+// content, whose confirmation takes another view.
+async function load(password, trust) {
+  const h = { "X-API-Key": share.token };
+  if (password) {
+    h["Authorization"] = "Basic " + btoa(unescape(encodeURIComponent(":" + password)));
+  }
+  if (trust) {
+    h["X-Confirm-Untrusted"] = trust;
+  }
+  const resp = await fetch(base + "/clipboard/" + encodeURIComponent(share.id) + "/raw", { headers: h, credentials: "omit" });
+  const level = resp.headers.get("X-Content-Trust");
+  if (resp.status === 428 && level && trust !== level && confirmUntrusted(level)) {
+    return load(password, level);
+  }
+  if (!resp.ok) {
+    let message = resp.status + " " + resp.statusText;
+    try {
+      const err = await resp.json();
+      if (err.message) {
+        message = err.message;
+      }
+    } catch (e) {
+      // Not a JSON error response.
+    }
+    const err = new Error(message);
+    err.status = resp.status;
+    throw err;
+  }
+  return {
+    type: resp.headers.get("Content-Type") || "application/octet-stream",
+    filename: filename(resp.headers.get("Content-Disposition")),
+    blob: await resp.blob(),
+  };
+}
+
+async function show(password) {
+  const type = loaded.type.split(";")[0].trim();
+  $("view-image").hidden = true;
+  $("download").hidden = true;
+  $("view-data").hidden = false;
+  $("copy").hidden = false;
+
+  if (isE2E(type)) {
+    if (!password) {
+      $("unlock").hidden = false;
+      status("This clipboard is encrypted end to end, enter its password");
+      return;
+    }
+    text = await e2eDecrypt(await loaded.blob.text(), password);
+    $("view-meta").textContent = "Decrypted in your browser";
+  } else if (type.startsWith("text/") || type === "application/json") {
+    text = await loaded.blob.text();
+    $("view-meta").textContent = type;
+  } else {
+    text = null;
+    const url = URL.createObjectURL(loaded.blob);
+    $("view-data").hidden = true;
+    $("copy").hidden = true;
+    $("download").href = url;
+    $("download").download = loaded.filename;
+    $("download").hidden = false;
+    if (type.startsWith("image/")) {
+      $("view-image").src = url;
+      $("view-image").hidden = false;
+    }
+    $("view-meta").textContent = type + " · " + loaded.blob.size + " bytes";
+  }
+
+  if (text !== null) {
+    $("view-data").value = text;
+  }
+  $("unlock").hidden = true;
+  $("view").hidden = false;
+  status("");
+}
+
+async function open(password) {
+  status("Loading…");
+  try {
+    if (!loaded) {
+      loaded = await load(password);
+    }
+    await show(password);
+  } catch (e) {
+    // Clipboards encrypted by the server are rejected without a password,
+    // and links that are used up or revoked with one.
+    if (e.status === 401) {
+      $("unlock").hidden = false;
+    }
+    status(e.message, true);
+  }
+}
+
+async function copy() {
+  if (text === null) {
+    return;
+  }
+  try {
+    await navigator.clipboard.writeText(text);
+  } catch (e) {
+    // The Clipboard API is only available in secure contexts.
+    $("view-data").select();
+    if (!document.execCommand("copy")) {
+      status("Copying failed, select the text and copy it manually", true);
+      return;
+    }
+  }
+  status("Copied");
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("unlock-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    open($("password").value);
+  });
+  $("copy").addEventListener("click", copy);
+
+  share = parseFragment();
+  if (!share) {
+    status("This share link is incomplete", true);
+    return;
+  }
+  // Keep the token out of the history of the browser.
+  history.replaceState(null, "", location.pathname);
+  open("");
+});
@@ -0,0 +1,28 @@
+// The service worker shows the Web Push notifications of clipboards the
+// user subscribed to, even when no page of the web UI is open, and opens
+// the clipboard when one is clicked.
+"use strict";
+
+self.addEventListener("push", (event) => {
+  const m = event.data ? event.data.json() : {};
+  event.waitUntil(self.registration.showNotification(m.title || "Clipboard updated", {
+    body: m.body || "",
+    // Later updates of a clipboard replace its earlier notification.
+    tag: m.clipboard_id ? "clipboard-" + m.clipboard_id : undefined,
+    data: { id: m.clipboard_id },
+  }));
+});
+
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+  const id = event.notification.data && event.notification.data.id;
+  const url = new URL(id ? "./?id=" + encodeURIComponent(id) : "./", self.registration.scope).href;
+  event.waitUntil(self.clients.matchAll({ type: "window" }).then((windows) => {
+    for (const w of windows) {
+      if (w.url === url && "focus" in w) {
+        return w.focus();
+      }
+    }
+    return self.clients.openWindow(url);
+  }));
+});
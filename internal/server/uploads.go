@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// StartUploadHandler starts a chunked upload.
+// The body holds the metadata of the clipboard to create and, optionally,
+// the total length of its data and the reserved id to create it under, see
+// ReserveHandler.
+func (s *Server) StartUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var u clipboard.Upload
+	if !s.decodeBody(w, r, &u, "name", "type", "filename", "content_disposition", "is_encrypted", "tags", "length", "clipboard_id") {
+		return
+	}
+
+	// The clipboard is validated again on commit, with its data.
+	if errs := validation.Clipboard(u.Clipboard(""), validation.ClipboardRules{RequireName: true}); len(errs) > 0 {
+		validation.WriteErrors(w, errs)
+		return
+	}
+	u.Tags, _ = clipboard.NormalizeTags(u.Tags)
+
+	// The data is only sniffed on commit.
+	if !s.checkType(w, u.DataType, "") {
+		return
+	}
+
+	if u.Length < 0 {
+		validation.Error(w, "invalid length", http.StatusBadRequest)
+		return
+	}
+	if u.ClipboardId != 0 {
+		res, ok := s.checkReservation(w, r, u.ClipboardId, u.Name)
+		if !ok {
+			return
+		}
+		if res == nil {
+			validation.Error(w, "clipboard id is not reserved", http.StatusConflict)
+			return
+		}
+	}
+	if q := s.quotaOf(currentNamespace(r)); q.MaxClipboardSize > 0 && u.Length > q.MaxClipboardSize {
+		validation.Error(w, "clipboard too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if _, err := s.db.DeleteExpiredUploads(r.Context(), time.Now()); err != nil {
+		log.Printf("error deleting expired uploads: %v", err)
+	}
+
+	var err error
+	u.Id, err = clipboard.NewUploadId()
+	if err != nil {
+		validation.Error(w, "upload id generation failed", http.StatusInternalServerError)
+		return
+	}
+	u.OwnerId = currentUserId(r)
+	u.ExpiresAt = time.Now().UTC().Add(s.uploadExpiry)
+
+	if err := s.db.CreateUpload(r.Context(), &u); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", s.path("/clipboard/uploads/"+u.Id))
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+	jsonResp, _ := json.Marshal(u)
+	_, _ = w.Write(jsonResp)
+}
+
+// UploadStatusHandler reports how many bytes of an upload were received, so
+// interrupted clients know where to resume.
+func (s *Server) UploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	u := s.loadUpload(w, r)
+	if u == nil {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.Itoa(u.Offset))
+	jsonResp, _ := json.Marshal(u)
+	_, _ = w.Write(jsonResp)
+}
+
+// UploadChunkHandler appends the request body to an upload.
+// The Upload-Offset header must match the number of bytes received so far.
+func (s *Server) UploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	u := s.loadUpload(w, r)
+	if u == nil {
+		return
+	}
+
+	offset, err := strconv.Atoi(r.Header.Get("Upload-Offset"))
+	if err != nil || offset < 0 {
+		validation.Error(w, "invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+	if offset != u.Offset {
+		w.Header().Set("Upload-Offset", strconv.Itoa(u.Offset))
+		validation.Error(w, "upload offset mismatch", http.StatusConflict)
+		return
+	}
+
+	chunk, err := io.ReadAll(io.LimitReader(r.Body, s.maxChunkSize+1))
+	if err != nil {
+		validation.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if int64(len(chunk)) > s.maxChunkSize {
+		validation.Error(w, fmt.Sprintf("chunk larger than %d bytes", s.maxChunkSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	size := offset + len(chunk)
+	maxSize := s.quotaOf(currentNamespace(r)).MaxClipboardSize
+	if (u.Length > 0 && size > u.Length) || (maxSize > 0 && size > maxSize) {
+		validation.Error(w, "clipboard too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ok, err := s.db.AppendUpload(r.Context(), u.Id, offset, string(chunk))
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// Another request appended a chunk concurrently.
+		validation.Error(w, "upload offset mismatch", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.Itoa(size))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CommitUploadHandler creates the clipboard from a completed upload.
+// Encrypted uploads are encrypted with the Basic Auth password of the request.
+func (s *Server) CommitUploadHandler(w http.ResponseWriter, r *http.Request) {
+	u := s.loadUpload(w, r)
+	if u == nil {
+		return
+	}
+
+	if u.Length > 0 && u.Offset != u.Length {
+		w.Header().Set("Upload-Offset", strconv.Itoa(u.Offset))
+		validation.Error(w, "upload incomplete", http.StatusConflict)
+		return
+	}
+
+	data, err := s.db.UploadData(r.Context(), u.Id)
+	if err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	c := u.Clipboard(data)
+	if !decodeText(w, c, true) || !s.createClipboard(w, r, c) {
+		return
+	}
+
+	if err := s.db.DeleteUpload(r.Context(), u.Id); err != nil {
+		log.Printf("error deleting committed upload %s: %v", u.Id, err)
+	}
+
+	jsonResp, _ := json.Marshal(c)
+	_, _ = w.Write(jsonResp)
+}
+
+// AbortUploadHandler discards an upload.
+func (s *Server) AbortUploadHandler(w http.ResponseWriter, r *http.Request) {
+	u := s.loadUpload(w, r)
+	if u == nil {
+		return
+	}
+
+	if err := s.db.DeleteUpload(r.Context(), u.Id); err != nil {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadUpload retrieves the upload identified by the uploadId URL parameter.
+// Uploads of other users are reported as not found.
+// If it cannot be retrieved, it writes an error response and returns nil.
+func (s *Server) loadUpload(w http.ResponseWriter, r *http.Request) *clipboard.Upload {
+	u, err := s.db.GetUpload(r.Context(), chi.URLParam(r, "uploadId"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		validation.Error(w, "internal database error", http.StatusInternalServerError)
+		return nil
+	}
+
+	if u == nil || u.OwnerId != currentUserId(r) {
+		validation.Error(w, "upload not found", http.StatusNotFound)
+		return nil
+	}
+
+	return u
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+// wireguardAuth authenticates requests without credentials by the WireGuard
+// peer they come from, so devices on an overlay network need no API keys.
+// It relies on WireGuard binding the addresses of the subnets to the keys of
+// peers, so the subnets must only be reachable through the tunnel.
+type wireguardAuth struct {
+	subnets []*net.IPNet
+	// peers maps the addresses of peers, in canonical form, to their users
+	// and devices.
+	peers map[string]*wireguardPeer
+}
+
+type wireguardPeer struct {
+	user   *account.User
+	device string
+}
+
+// wireguardFromEnv configures the peers of WIREGUARD_PEERS in the subnets of
+// WIREGUARD_SUBNET, creating their users like API_KEYS does. It returns nil
+// if WIREGUARD_SUBNET is unset.
+func wireguardFromEnv(db database.Service, namespaces map[string]*namespaceConfig) (*wireguardAuth, error) {
+	subnets, err := parseNetworks(env.String("WIREGUARD_SUBNET", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WIREGUARD_SUBNET: %w", err)
+	}
+	peers, err := account.ParsePeers(env.String("WIREGUARD_PEERS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WIREGUARD_PEERS: %w", err)
+	}
+	if len(subnets) == 0 {
+		if len(peers) > 0 {
+			return nil, errors.New("WIREGUARD_PEERS requires WIREGUARD_SUBNET")
+		}
+		return nil, nil
+	}
+
+	wg := &wireguardAuth{subnets: subnets, peers: make(map[string]*wireguardPeer)}
+	for addr, p := range peers {
+		if !containsIP(subnets, addr) {
+			return nil, fmt.Errorf("invalid WIREGUARD_PEERS: %s of %s@%s is outside of WIREGUARD_SUBNET", addr, p.User, p.Device)
+		}
+		namespace, name := account.SplitName(p.User)
+		if _, ok := namespaces[namespace]; !ok {
+			return nil, fmt.Errorf("invalid WIREGUARD_PEERS: unknown namespace of user %s", p.User)
+		}
+		u, err := db.EnsureUser(context.Background(), namespace, name)
+		if errors.Is(err, database.ErrReadOnly) {
+			log.Printf("user %s does not exist on the primary yet, ignoring their peer %s", p.User, p.Device)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot create user %s: %w", p.User, err)
+		}
+		wg.peers[addr] = &wireguardPeer{user: u, device: p.Device}
+	}
+	return wg, nil
+}
+
+// peer returns the peer at a client IP, or nil if the address is not one of
+// a configured peer in the subnets.
+func (wg *wireguardAuth) peer(addr string) *wireguardPeer {
+	if wg == nil || !containsIP(wg.subnets, addr) {
+		return nil
+	}
+	return wg.peers[net.ParseIP(addr).String()]
+}
+
+// servePeer serves a request from a WireGuard peer as its user, like a
+// request with their API key. The device of the peer replaces the one the
+// client names in access logs, see device.
+func (s *Server) servePeer(w http.ResponseWriter, r *http.Request, p *wireguardPeer, next http.Handler) {
+	ctx := context.WithValue(r.Context(), peerContextKey, p.device)
+	s.serveAs(w, r.WithContext(ctx), p.user, next)
+}
@@ -0,0 +1,129 @@
+// Package stream encrypts and decrypts data of unknown length segment by
+// segment, so large payloads never have to be held in memory.
+//
+// Every segment is sealed with the same AEAD under a nonce made of a random
+// prefix, the segment counter and a flag marking the last segment, which
+// makes reordering, truncation and extension detectable.
+package stream
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// SegmentSize is the size of plaintext segments.
+const SegmentSize = 64 << 10
+
+// PrefixSize is the size of the random nonce prefix.
+const PrefixSize = 7
+
+var errTruncated = errors.New("stream: truncated or corrupted ciphertext")
+
+// NewPrefix generates a random nonce prefix.
+func NewPrefix() ([]byte, error) {
+	prefix := make([]byte, PrefixSize)
+	_, err := rand.Read(prefix)
+	return prefix, err
+}
+
+// EncryptReader returns a reader of the ciphertext of r.
+// The AEAD must use 12-byte nonces.
+func EncryptReader(aead cipher.AEAD, prefix []byte, r io.Reader) io.Reader {
+	return &segmentReader{aead: aead, prefix: prefix, src: bufio.NewReaderSize(r, SegmentSize), in: SegmentSize, seal: true}
+}
+
+// DecryptReader returns a reader of the plaintext of ciphertext produced by
+// EncryptReader with the same AEAD and prefix.
+func DecryptReader(aead cipher.AEAD, prefix []byte, r io.Reader) io.Reader {
+	size := SegmentSize + aead.Overhead()
+	return &segmentReader{aead: aead, prefix: prefix, src: bufio.NewReaderSize(r, size), in: size}
+}
+
+type segmentReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	src     *bufio.Reader
+	in      int
+	seal    bool
+	counter uint32
+	buf     []byte
+	out     []byte
+	done    bool
+	err     error
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.next()
+	}
+
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// next processes the next segment into out.
+func (s *segmentReader) next() {
+	if s.buf == nil {
+		s.buf = make([]byte, s.in)
+	}
+
+	n, err := io.ReadFull(s.src, s.buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		s.done = true
+	case err != nil:
+		s.err = err
+		return
+	default:
+		// A full segment is the last one if nothing follows it.
+		if _, err := s.src.Peek(1); err == io.EOF {
+			s.done = true
+		} else if err != nil {
+			s.err = err
+			return
+		}
+	}
+
+	if s.counter == ^uint32(0) {
+		s.err = errors.New("stream: too many segments")
+		return
+	}
+	nonce := s.nonce()
+	s.counter++
+
+	if s.seal {
+		s.out = s.aead.Seal(s.out[:0], nonce, s.buf[:n], nil)
+		return
+	}
+
+	if n < s.aead.Overhead() {
+		s.err = errTruncated
+		return
+	}
+	s.out, err = s.aead.Open(s.out[:0], nonce, s.buf[:n], nil)
+	if err != nil {
+		s.err = errTruncated
+	}
+}
+
+// nonce returns the nonce of the current segment.
+func (s *segmentReader) nonce() []byte {
+	nonce := make([]byte, PrefixSize+5)
+	copy(nonce, s.prefix)
+	binary.BigEndian.PutUint32(nonce[PrefixSize:], s.counter)
+	if s.done {
+		nonce[PrefixSize+4] = 1
+	}
+	return nonce
+}
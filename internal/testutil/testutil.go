@@ -0,0 +1,224 @@
+// Package testutil runs the server against an in-memory database, so tests
+// can exercise the HTTP API end to end.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/server"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+// API keys of the users every test server is configured with.
+const (
+	AliceKey = "alice-test-key"
+	BobKey   = "bob-test-key"
+)
+
+// databases numbers the in-memory databases, which are shared by name
+// between the connections of a pool.
+var databases atomic.Int64
+
+// Server is a running test server.
+type Server struct {
+	*httptest.Server
+
+	// DB is the database of the server, for setting up state the API does
+	// not expose.
+	DB database.Service
+}
+
+// NewServer starts a server with a fresh in-memory database. The users
+// alice and bob are configured with AliceKey and BobKey, and scrypt is
+// tuned down to keep encryption fast.
+//
+// env holds further "KEY=value" settings, applied with t.Setenv. Settings the
+// database package reads on startup, such as DB_URL, cannot be changed.
+// The server and its database are closed when the test ends.
+func NewServer(t testing.TB, env ...string) *Server {
+	t.Helper()
+	return NewServerAt(t, fmt.Sprintf("file:copybridge-test-%d?mode=memory&cache=shared", databases.Add(1)), env...)
+}
+
+// NewServerAt starts a server like NewServer, with the database at url, e.g.
+// a file the test inspects or copies.
+func NewServerAt(t testing.TB, url string, env ...string) *Server {
+	t.Helper()
+
+	t.Setenv("API_KEYS", "alice:"+AliceKey+",bob:"+BobKey)
+	t.Setenv("KDF_SCRYPT_LOG_N", "10")
+	for _, kv := range env {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			t.Fatalf("invalid environment setting %q", kv)
+		}
+		t.Setenv(key, value)
+	}
+
+	db, err := database.Open(url)
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+
+	s, err := server.New(db)
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot configure server: %v", err)
+	}
+
+	ts := &Server{Server: httptest.NewServer(s.RegisterRoutes()), DB: db}
+	t.Cleanup(func() {
+		ts.Close()
+		db.Close()
+	})
+	return ts
+}
+
+// Option modifies a request before it is sent.
+type Option func(*http.Request)
+
+// WithAPIKey authenticates a request with an API key, session access token
+// or clipboard token.
+func WithAPIKey(key string) Option {
+	return func(r *http.Request) {
+		r.Header.Set("X-API-Key", key)
+	}
+}
+
+// WithPassword sends the password of an encrypted clipboard with Basic Auth.
+func WithPassword(password string) Option {
+	return func(r *http.Request) {
+		r.SetBasicAuth("", password)
+	}
+}
+
+// WithHeader sets a request header.
+func WithHeader(key, value string) Option {
+	return func(r *http.Request) {
+		r.Header.Set(key, value)
+	}
+}
+
+// Response is a response with its body read.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do sends a request to the server and reads the response. Bodies of type
+// string or []byte are sent as is, and any other non-nil body as JSON.
+// It fails the test if the request cannot be sent.
+func (s *Server) Do(t testing.TB, method, path string, body any, opts ...Option) *Response {
+	t.Helper()
+
+	var r io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("cannot encode request body: %v", err)
+		}
+		r = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, r)
+	if err != nil {
+		t.Fatalf("cannot create request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: cannot read response body: %v", method, path, err)
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}
+}
+
+// Expect fails the test unless the response has the given status code.
+func (r *Response) Expect(t testing.TB, status int) *Response {
+	t.Helper()
+
+	if r.StatusCode != status {
+		t.Fatalf("expected status %d; got %d: %s", status, r.StatusCode, r.Body)
+	}
+	return r
+}
+
+// JSON decodes the response body into v, failing the test if it is invalid.
+func (r *Response) JSON(t testing.TB, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", r.Body, err)
+	}
+}
+
+// Error decodes an error response body.
+func (r *Response) Error(t testing.TB) validation.Response {
+	t.Helper()
+
+	var resp validation.Response
+	r.JSON(t, &resp)
+	return resp
+}
+
+// TOTPSession enrolls the user of an API key in two-factor authentication
+// and logs them in with a code, as the admin API requires of
+// administrators. It returns an option authenticating requests with the
+// access token of the session.
+func (s *Server) TOTPSession(t testing.TB, key string) Option {
+	t.Helper()
+
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	s.Do(t, http.MethodPost, "/auth/totp/setup", nil, WithAPIKey(key)).Expect(t, http.StatusCreated).JSON(t, &setup)
+
+	// Every code works once, so logging in takes the code of the next step.
+	now := time.Now()
+	code, err := account.TOTPCode(setup.Secret, now)
+	if err != nil {
+		t.Fatalf("cannot compute TOTP code: %v", err)
+	}
+	s.Do(t, http.MethodPost, "/auth/totp/verify", map[string]string{"code": code}, WithAPIKey(key)).Expect(t, http.StatusOK)
+	if code, err = account.TOTPCode(setup.Secret, now.Add(30*time.Second)); err != nil {
+		t.Fatalf("cannot compute TOTP code: %v", err)
+	}
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	s.Do(t, http.MethodPost, "/auth/login", map[string]string{"code": code}, WithAPIKey(key)).Expect(t, http.StatusOK).JSON(t, &tokens)
+	return WithAPIKey(tokens.AccessToken)
+}
@@ -0,0 +1,127 @@
+// Package thumbnail renders small previews of clipboard images, so clients
+// listing clipboards do not have to download full screenshots.
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"mime"
+	"strings"
+)
+
+// ContentType is the type of generated thumbnails.
+const ContentType = "image/jpeg"
+
+// samples is the number of source pixels averaged per thumbnail pixel in
+// each direction.
+const samples = 4
+
+var (
+	// ErrUnsupported is returned for image formats that cannot be decoded.
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images with more pixels than allowed.
+	ErrTooLarge = errors.New("image too large for a thumbnail")
+)
+
+// IsImage reports whether a data type is an image type.
+func IsImage(dataType string) bool {
+	base, _, err := mime.ParseMediaType(dataType)
+	return err == nil && strings.HasPrefix(base, "image/")
+}
+
+// Generate decodes a PNG, JPEG or GIF image and renders it as a JPEG fitting
+// in a square of size pixels, keeping its aspect ratio. Smaller images are
+// not scaled up, and transparent areas turn white.
+// Images sent base64-encoded through the JSON API are decoded first.
+// Images with more than maxPixels pixels are rejected before they are
+// decoded, to bound memory use.
+func Generate(data []byte, size, maxPixels int) ([]byte, error) {
+	img, err := Decode(data, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(h*size/w, 1)
+		} else {
+			w, h = max(w*size/h, 1), size
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scale(img, w, h), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a PNG, JPEG or GIF image, which may be base64-encoded, and
+// rejects images with more than maxPixels pixels before decoding them.
+func Decode(data []byte, maxPixels int) (image.Image, error) {
+	img, err := decode(data, maxPixels)
+	if err == ErrUnsupported {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if decodeErr != nil {
+			return nil, err
+		}
+		img, err = decode(decoded, maxPixels)
+	}
+	return img, err
+}
+
+// decode decodes an image after checking its dimensions.
+func decode(data []byte, maxPixels int) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPixels/max(cfg.Height, 1) {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// scale resizes an image to w by h pixels, averaging a grid of samples for
+// every pixel, and flattens it onto white.
+func scale(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	b := src.Bounds()
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, bl, a uint32
+			for sy := 0; sy < samples; sy++ {
+				py := b.Min.Y + (y*samples+sy)*b.Dy()/(h*samples)
+				for sx := 0; sx < samples; sx++ {
+					px := b.Min.X + (x*samples+sx)*b.Dx()/(w*samples)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+				}
+			}
+
+			// Colors are alpha-premultiplied, so white shows through
+			// by the missing alpha.
+			n := uint32(samples * samples)
+			r, g, bl, a = r/n, g/n, bl/n, a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + 0xffff - a) >> 8),
+				G: uint8((g + 0xffff - a) >> 8),
+				B: uint8((bl + 0xffff - a) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,122 @@
+package validation
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+// MaxNameLength is the maximum length of a clipboard name in bytes.
+const MaxNameLength = 255
+
+// ClipboardRules control the validation of a clipboard.
+type ClipboardRules struct {
+	// RequireName is set when creating a clipboard. Updates keep the
+	// existing name.
+	RequireName bool
+	// MaxSize is the maximum size of the data and flavors of a clipboard,
+	// or 0 for unlimited.
+	MaxSize int
+}
+
+// Clipboard validates the fields of a clipboard sent by a client: a
+// non-empty name without control characters, a well-formed media type with
+// a known charset, the filename, disposition and locale, the encryption
+// scope, an expiry in the future and after publication, the total size,
+// the tags, the flavors and the envelope of end-to-end encrypted data.
+// Whether the type is allowed by the server is
+// checked separately.
+func Clipboard(c *clipboard.Clipboard, rules ClipboardRules) Errors {
+	var errs Errors
+
+	checkName(&errs, c.Name, rules.RequireName)
+	checkType(&errs, "type", c.DataType)
+	if base, _, _ := mime.ParseMediaType(c.DataType); base == clipboard.E2EType {
+		if err := clipboard.CheckE2E(c.Data); err != nil {
+			errs.Add("data", CodeInvalid, err.Error())
+		}
+	}
+	if err := clipboard.CheckFilename(c.Filename); err != nil {
+		errs.Add("filename", CodeInvalid, err.Error())
+	}
+	if err := clipboard.CheckDisposition(c.ContentDisposition); err != nil {
+		errs.Add("content_disposition", CodeInvalid, err.Error())
+	}
+	if _, err := clipboard.NormalizeLocale(c.Locale); err != nil {
+		errs.Add("locale", CodeInvalid, err.Error())
+	}
+	switch c.EncryptionScope {
+	case "", clipboard.ScopeData, clipboard.ScopeAll:
+	default:
+		errs.Add("encryption_scope", CodeInvalid, "encryption scope must be data or all")
+	}
+	if c.ExpiresAt != nil {
+		switch {
+		case c.PublishAt != nil && !c.ExpiresAt.After(*c.PublishAt):
+			errs.Add("expires_at", CodeInvalid, "expires_at must be after publish_at")
+		case c.Expired(time.Now()):
+			errs.Add("expires_at", CodeInvalid, "expires_at must be in the future")
+		}
+	}
+	for i, f := range c.Flavors {
+		checkType(&errs, fmt.Sprintf("flavors[%d].type", i), f.DataType)
+	}
+	if err := c.CheckFlavors(); err != nil {
+		errs.Add("flavors", CodeInvalid, err.Error())
+	}
+
+	if rules.MaxSize > 0 && c.DataSize() > rules.MaxSize {
+		errs.Add("data", CodeTooLarge, fmt.Sprintf("data and flavors must be at most %d bytes", rules.MaxSize))
+	}
+
+	if _, err := clipboard.NormalizeTags(c.Tags); err != nil {
+		errs.Add("tags", CodeInvalid, err.Error())
+	}
+	if _, err := clipboard.NormalizeTransforms(c.Transforms); err != nil {
+		errs.Add("transforms", CodeInvalid, err.Error())
+	}
+
+	return errs
+}
+
+// Reservation validates a reservation sent by a client, whose name is
+// optional but otherwise follows the rules of clipboard names.
+func Reservation(r *clipboard.Reservation) Errors {
+	var errs Errors
+	checkName(&errs, r.Name, false)
+	return errs
+}
+
+// checkName records an error if name is empty but required, too long or
+// contains control characters.
+func checkName(errs *Errors, name string, required bool) {
+	switch {
+	case required && strings.TrimSpace(name) == "":
+		errs.Add("name", CodeRequired, "name is required")
+	case len(name) > MaxNameLength:
+		errs.Add("name", CodeTooLarge, fmt.Sprintf("name must be at most %d bytes", MaxNameLength))
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		errs.Add("name", CodeInvalid, "name must not contain control characters")
+	}
+}
+
+// checkType records an error if dataType is not a well-formed media type
+// or names an unknown charset.
+func checkType(errs *Errors, field, dataType string) {
+	if dataType == "" {
+		errs.Add(field, CodeRequired, "type is required")
+		return
+	}
+	base, _, err := mime.ParseMediaType(dataType)
+	if err != nil || !strings.Contains(base, "/") {
+		errs.Add(field, CodeInvalid, fmt.Sprintf("%q is not a valid media type", dataType))
+		return
+	}
+	if _, err := clipboard.ParseText(dataType); err != nil {
+		errs.Add(field, CodeInvalid, err.Error())
+	}
+}
@@ -0,0 +1,150 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// errTrailingData is returned for bodies holding more than one JSON value.
+var errTrailingData = errors.New("unexpected data after JSON body")
+
+// Decode decodes the JSON object read from r into v. If allowed is not nil,
+// the object may only have the fields it lists, spelled exactly, and nested
+// objects only the fields of their types. Unknown fields and values of the
+// wrong type are returned as Errors, suggesting the field an unknown one was
+// likely meant to be. Bodies that are empty, not JSON or not a single object
+// fail with other errors; empty ones with io.EOF.
+func Decode(r io.Reader, v any, allowed []string) error {
+	dec := json.NewDecoder(r)
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errTrailingData
+	}
+
+	var errs Errors
+	if allowed != nil {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !slices.Contains(allowed, name) {
+				errs.Add(name, CodeUnknown, unknownMessage(name, allowed))
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+
+	dec = json.NewDecoder(bytes.NewReader(raw))
+	if allowed != nil {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		errs.Add(typeErr.Field, CodeInvalid, "must be "+jsonType(typeErr.Type))
+		return errs
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// Unknown fields of nested objects, which are only reported one
+		// at a time and without their path.
+		name := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		errs.Add(name, CodeUnknown, "unknown field")
+		return errs
+	}
+	return err
+}
+
+// unknownMessage describes an unknown field, suggesting the allowed field
+// it was likely meant to be.
+func unknownMessage(name string, allowed []string) string {
+	if s := Suggest(name, allowed); s != "" {
+		return "unknown field, did you mean " + s + "?"
+	}
+	return "unknown field"
+}
+
+// Suggest returns the allowed field a misspelled one was likely meant to
+// be: one differing in case, one it ends with, as data_type ends with type,
+// or one at most two edits away. It returns "" if there is none.
+func Suggest(name string, allowed []string) string {
+	lower := strings.ToLower(name)
+	for _, a := range allowed {
+		if lower == a {
+			return a
+		}
+	}
+	for _, a := range allowed {
+		if strings.HasSuffix(lower, "_"+a) {
+			return a
+		}
+	}
+
+	best, bestDistance := "", 3
+	for _, a := range allowed {
+		if d := editDistance(lower, a); d < bestDistance {
+			best, bestDistance = a, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// jsonType names the JSON type values of a Go type are decoded from.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,156 @@
+// Package validation checks request payloads and writes error responses as
+// JSON bodies of a consistent shape:
+//
+//	{"code": "validation_failed", "message": "...", "fields": [{"field": "name", "code": "required", "message": "..."}]}
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/copybridge/copybridge-server/internal/i18n"
+)
+
+// Codes of field errors.
+const (
+	CodeRequired = "required"
+	CodeInvalid  = "invalid"
+	CodeTooLarge = "too_large"
+	CodeUnknown  = "unknown"
+	CodeRejected = "rejected"
+)
+
+// Response is the body of error responses.
+type Response struct {
+	// Code identifies the kind of error, derived from the status code, e.g.
+	// "not_found" or "validation_failed".
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes an invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors collects the field errors of a request body.
+type Errors []FieldError
+
+// Add records an error of a field.
+func (e *Errors) Add(field, code, message string) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Error joins the messages of the field errors.
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Status returns the status code to respond with: 413 if a field is too
+// large, and 422 otherwise.
+func (e Errors) Status() int {
+	for _, f := range e {
+		if f.Code == CodeTooLarge {
+			return http.StatusRequestEntityTooLarge
+		}
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// Error replies to the request with the given message and status code as a
+// JSON error body. It is a drop-in replacement for http.Error.
+func Error(w http.ResponseWriter, message string, status int) {
+	write(w, status, Response{Code: code(status), Message: message})
+}
+
+// WriteErrors replies to the request with the field errors.
+func WriteErrors(w http.ResponseWriter, errs Errors) {
+	status := errs.Status()
+	c := "validation_failed"
+	if status != http.StatusUnprocessableEntity {
+		c = code(status)
+	}
+	write(w, status, Response{Code: c, Message: "invalid request body", Fields: errs})
+}
+
+// Localize returns a writer whose error responses are translated into
+// lang, which should be negotiated with i18n.Negotiate. Codes are never
+// translated, so clients can keep matching on them.
+func Localize(w http.ResponseWriter, lang string) http.ResponseWriter {
+	return &localizedWriter{ResponseWriter: w, lang: lang}
+}
+
+// localizedWriter carries the language of a response to write.
+type localizedWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *localizedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// language returns the language error responses written to w are to be
+// translated into, looking through writers wrapping a localizedWriter. It
+// returns false if w was not localized.
+func language(w http.ResponseWriter) (string, bool) {
+	for {
+		switch lw := w.(type) {
+		case *localizedWriter:
+			return lw.lang, true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = lw.Unwrap()
+		default:
+			return i18n.Default, false
+		}
+	}
+}
+
+func write(w http.ResponseWriter, status int, resp Response) {
+	h := w.Header()
+	// Drop the length of the response the error replaces, if it was set.
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if lang, ok := language(w); ok {
+		h.Add("Vary", "Accept-Language")
+		h.Set("Content-Language", lang)
+		resp = resp.translate(lang)
+	}
+	w.WriteHeader(status)
+
+	jsonResp, _ := json.Marshal(resp)
+	_, _ = w.Write(jsonResp)
+}
+
+// translate returns the response with its messages translated into lang.
+func (resp Response) translate(lang string) Response {
+	resp.Message = i18n.Translate(lang, resp.Message)
+	if resp.Fields != nil {
+		fields := make([]FieldError, len(resp.Fields))
+		for i, f := range resp.Fields {
+			f.Message = i18n.Translate(lang, f.Message)
+			fields[i] = f
+		}
+		resp.Fields = fields
+	}
+	return resp
+}
+
+// code derives an error code from a status code, e.g. "not_found" from 404.
+func code(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
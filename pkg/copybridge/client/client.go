@@ -0,0 +1,259 @@
+// Package client talks to a copybridge server over its HTTP API, for the
+// reference CLI, the agent and other Go programs:
+//
+//	c := &client.Client{BaseURL: "http://localhost:8080", APIKey: key}
+//	created, err := c.Create("greeting", "text/plain")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	_, err = c.Write(created.PublicId, created.Version, "text/plain", strings.NewReader("hello"))
+//
+// Clipboards are addressed by a reference, which is their public id, numeric
+// id or alias. The public id of a clipboard works wherever the client may
+// access it, while servers with SEQUENTIAL_IDS=false restrict numeric ids and
+// aliases to owners and users a clipboard is shared with, so programs keep
+// the public ids of the clipboards they create.
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrUnauthorized is returned when a clipboard needs a password that
+	// was not given or is wrong.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrConflict is returned when a request conflicts with the state of the
+	// server, such as a Write based on a version that is no longer current.
+	// The error carries the message of the server.
+	ErrConflict = errors.New("conflict")
+)
+
+// UntrustedError is returned when the server refuses to serve a clipboard
+// flagged as a script or executable without confirmation, see
+// Client.ConfirmUntrusted.
+type UntrustedError struct {
+	// Level is the trust level the server flagged the content with.
+	Level string
+}
+
+func (e *UntrustedError) Error() string {
+	return fmt.Sprintf("clipboard content flagged as %s", e.Level)
+}
+
+// Client talks to a copybridge server.
+type Client struct {
+	// BaseURL is the URL of the server, without a trailing slash.
+	BaseURL string
+	// APIKey authenticates the requests, unless it is empty.
+	APIKey string
+	// Password is the password of encrypted clipboards. Create encrypts new
+	// clipboards with it.
+	Password string
+	// ConfirmUntrusted acknowledges untrusted content with the level the
+	// server flagged it with, instead of failing with an UntrustedError.
+	ConfirmUntrusted bool
+	// HTTP sends the requests. It is http.DefaultClient if nil.
+	HTTP *http.Client
+}
+
+// Clipboard is the JSON representation of a clipboard, as far as clients
+// need it.
+type Clipboard struct {
+	Id          int    `json:"id,omitempty"`
+	PublicId    string `json:"public_id,omitempty"`
+	Name        string `json:"name"`
+	DataType    string `json:"type"`
+	Data        string `json:"data"`
+	IsEncrypted bool   `json:"is_encrypted"`
+	Size        int    `json:"size,omitempty"`
+	Version     int    `json:"version,omitempty"`
+	Streamed    bool   `json:"streamed,omitempty"`
+}
+
+// Header returns the headers authenticating requests of the client.
+func (c *Client) Header() http.Header {
+	h := make(http.Header)
+	if c.APIKey != "" {
+		h.Set("X-API-Key", c.APIKey)
+	}
+	if c.Password != "" {
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+c.Password)))
+	}
+	return h
+}
+
+// do sends a request to the server and returns the response if it
+// succeeded. Failures are turned into errors carrying the server message.
+// Additional headers are given as key-value pairs.
+func (c *Client) do(method, path, contentType string, body io.Reader, headers ...string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.Header()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case http.StatusPreconditionRequired:
+		if level := resp.Header.Get("X-Content-Trust"); level != "" {
+			return nil, &UntrustedError{Level: level}
+		}
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusConflict {
+		return nil, fmt.Errorf("%w: %s", ErrConflict, errorMessage(msg))
+	}
+	return nil, fmt.Errorf("server responded with %s: %s", resp.Status, errorMessage(msg))
+}
+
+// errorMessage extracts the message and field errors of a JSON error body,
+// falling back to the body as is.
+func errorMessage(body []byte) string {
+	var e struct {
+		Message string `json:"message"`
+		Fields  []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(body, &e); err != nil || e.Message == "" {
+		return strings.TrimSpace(string(body))
+	}
+
+	msg := e.Message
+	for _, f := range e.Fields {
+		msg += "; " + f.Field + ": " + f.Message
+	}
+	return msg
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON
+// response into out, unless it is nil.
+func (c *Client) doJSON(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	resp, err := c.do(method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// clipboardPath returns the path of the clipboard with reference ref,
+// followed by suffix.
+func clipboardPath(ref, suffix string) string {
+	return "/clipboard/" + url.PathEscape(ref) + suffix
+}
+
+// Create creates an empty clipboard, encrypted with the password if any.
+func (c *Client) Create(name, dataType string) (*Clipboard, error) {
+	var created Clipboard
+	err := c.doJSON(http.MethodPost, "/clipboard", Clipboard{Name: name, DataType: dataType, IsEncrypted: c.Password != ""}, &created)
+	return &created, err
+}
+
+// Get retrieves a clipboard.
+func (c *Client) Get(ref string) (*Clipboard, error) {
+	var cb Clipboard
+	err := c.doJSON(http.MethodGet, clipboardPath(ref, ""), nil, &cb)
+	return &cb, err
+}
+
+// Write streams data into a clipboard if it is still at the given version,
+// and returns the clipboard with its new version. Version 0 overwrites any
+// version. It returns an ErrConflict error if the clipboard has changed.
+func (c *Client) Write(ref string, version int, dataType string, data io.Reader) (*Clipboard, error) {
+	ifMatch := "*"
+	if version > 0 {
+		ifMatch = strconv.Quote(strconv.Itoa(version))
+	}
+	resp, err := c.do(http.MethodPut, clipboardPath(ref, "/raw"), dataType, data, "If-Match", ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var cb Clipboard
+	return &cb, json.NewDecoder(resp.Body).Decode(&cb)
+}
+
+// Read streams the data of a clipboard. It returns the data, which the
+// caller closes, and its type.
+func (c *Client) Read(ref string) (io.ReadCloser, string, error) {
+	path := clipboardPath(ref, "/raw")
+	resp, err := c.do(http.MethodGet, path, "", nil)
+	var untrusted *UntrustedError
+	if errors.As(err, &untrusted) && c.ConfirmUntrusted {
+		resp, err = c.do(http.MethodGet, path, "", nil, "X-Confirm-Untrusted", untrusted.Level)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// List retrieves the clipboards of the user of the API key.
+func (c *Client) List() ([]Clipboard, error) {
+	var cs []Clipboard
+	err := c.doJSON(http.MethodGet, "/clipboard", nil, &cs)
+	return cs, err
+}
+
+// Delete deletes a clipboard.
+func (c *Client) Delete(ref string) error {
+	return c.doJSON(http.MethodDelete, clipboardPath(ref, ""), nil, nil)
+}
+
+// Sync opens a connection speaking the sync protocol.
+func (c *Client) Sync() (*websocket.Conn, error) {
+	u := "ws" + strings.TrimPrefix(c.BaseURL, "http") + "/sync"
+	conn, resp, err := websocket.DefaultDialer.Dial(u, c.Header())
+	if err != nil && resp != nil {
+		return nil, fmt.Errorf("server responded with %s", resp.Status)
+	}
+	return conn, err
+}
@@ -0,0 +1,142 @@
+// Package copybridge embeds a copybridge server in other Go programs, e.g.
+// under a path of their own HTTP server:
+//
+//	cb, err := copybridge.New(copybridge.WithPrefix("/clipboard"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer cb.Close()
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/clipboard/", cb.Handler())
+//	server := &http.Server{Addr: ":8080", Handler: mux}
+//	if err := cb.Start(server); err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(server.ListenAndServe())
+//
+// The server is configured from the environment as when it runs on its own,
+// see the Configuration section of the README. Options add to that.
+package copybridge
+
+import (
+	"net/http"
+
+	"github.com/copybridge/copybridge-server/internal/database"
+	"github.com/copybridge/copybridge-server/internal/env"
+	"github.com/copybridge/copybridge-server/internal/server"
+)
+
+// Server is an embedded copybridge server.
+type Server struct {
+	s *server.Server
+	// db is the storage the server opened itself and closes on Close.
+	db Service
+}
+
+// Option customizes an embedded server.
+type Option func(*config)
+
+type config struct {
+	db   Service
+	opts []server.Option
+}
+
+// WithStorage stores clipboards in db instead of the SQLite database at
+// DB_URL, e.g. to wrap a database opened with OpenDatabase. db is not
+// closed by Close.
+func WithStorage(db Service) Option {
+	return func(c *config) {
+		c.db = db
+	}
+}
+
+// WithPrefix serves the server under prefix, e.g. "/clipboard", the path
+// the embedding program mounts its Handler at. Links and redirects include
+// it, but PUBLIC_URL, if set, must too.
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.opts = append(c.opts, server.WithPrefix(prefix))
+	}
+}
+
+// WithMiddleware adds middleware to all routes, running after the built-in
+// middleware in the order given, so RequestUser tells who requests are made
+// by.
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(c *config) {
+		c.opts = append(c.opts, server.WithMiddleware(middleware...))
+	}
+}
+
+// WithMount routes requests matching pattern, e.g. "/stats", to handler,
+// next to the routes of the server and behind its middleware. Patterns
+// conflicting with built-in routes make Handler panic.
+func WithMount(pattern string, handler http.Handler) Option {
+	return func(c *config) {
+		c.opts = append(c.opts, server.WithMount(pattern, handler))
+	}
+}
+
+// New configures a server from the environment and opts. Unless
+// WithStorage is given, it opens and migrates the database at DB_URL.
+// It returns an error if the configuration is invalid or the database
+// cannot be opened.
+func New(opts ...Option) (*Server, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	var owned Service
+	if c.db == nil {
+		db, err := OpenDatabase(env.String("DB_URL", ""))
+		if err != nil {
+			return nil, err
+		}
+		c.db, owned = db, db
+	}
+
+	s, err := server.New(c.db, c.opts...)
+	if err != nil {
+		if owned != nil {
+			owned.Close()
+		}
+		return nil, err
+	}
+	return &Server{s: s, db: owned}, nil
+}
+
+// Handler returns the routes of the server.
+func (s *Server) Handler() http.Handler {
+	return s.s.RegisterRoutes()
+}
+
+// Start starts the background work of the server, such as retention,
+// backups and notifications, until server, the HTTP server serving its
+// Handler, shuts down. It returns an error if the MQTT bridge cannot
+// connect.
+func (s *Server) Start(server *http.Server) error {
+	return s.s.Start(server)
+}
+
+// Close closes the database the server opened. Call it after the HTTP
+// server serving it shut down.
+func (s *Server) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// OpenDatabase opens and migrates the SQLite database at url, e.g. to pass
+// it on wrapped to WithStorage. It is read-only with DB_READ_ONLY.
+func OpenDatabase(url string) (Service, error) {
+	return database.Open(url)
+}
+
+// RequestUser returns the user a request is made by, or nil if it is
+// anonymous, for middleware and mounts.
+func RequestUser(r *http.Request) *User {
+	return server.RequestUser(r)
+}
@@ -0,0 +1,58 @@
+package copybridge
+
+import (
+	"github.com/copybridge/copybridge-server/internal/account"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/database"
+)
+
+// Service stores clipboards, users and everything else the server keeps.
+// Custom storage for WithStorage implements it, typically by wrapping the
+// Service of OpenDatabase and overriding some of its methods. The types of
+// its methods are aliased below.
+type Service = database.Service
+
+type (
+	Clipboard   = clipboard.Clipboard
+	Item        = clipboard.Item
+	Token       = clipboard.Token
+	Permission  = clipboard.Permission
+	AccessEntry = clipboard.AccessEntry
+	// ClipboardStats are the statistics of one clipboard, unlike Stats.
+	ClipboardStats = clipboard.Stats
+	DeviceStats    = clipboard.DeviceStats
+	SizeEntry      = clipboard.SizeEntry
+	Subscription   = clipboard.Subscription
+	Upload         = clipboard.Upload
+	BlobUpload     = clipboard.BlobUpload
+	Reservation    = clipboard.Reservation
+	Conflict       = clipboard.Conflict
+	Change         = clipboard.Change
+	Alias          = clipboard.Alias
+
+	User    = account.User
+	Session = account.Session
+	TOTP    = account.TOTP
+
+	ListOptions          = database.ListOptions
+	ChangeOptions        = database.ChangeOptions
+	SecurityEventOptions = database.SecurityEventOptions
+	Stats                = database.Stats
+	CacheStats           = database.CacheStats
+	UserUsage            = database.UserUsage
+	Tombstone            = database.Tombstone
+)
+
+// Errors a Service returns for the server to respond to them as it does
+// for its own database.
+var (
+	ErrNotFound         = database.ErrNotFound
+	ErrConflict         = database.ErrConflict
+	ErrUnauthorized     = database.ErrUnauthorized
+	ErrVersionConflict  = database.ErrVersionConflict
+	ErrReadOnly         = database.ErrReadOnly
+	ErrNameTaken        = database.ErrNameTaken
+	ErrClipboardExists  = database.ErrClipboardExists
+	ErrDirectUploads    = database.ErrDirectUploads
+	ErrUploadIncomplete = database.ErrUploadIncomplete
+)
@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/account"
+)
+
+func TestTokens(t *testing.T) {
+	tokens := account.NewTokens([]byte("0123456789abcdef0123456789abcdef"), time.Minute, time.Hour)
+	u := &account.User{Id: 7, Name: "ann"}
+
+	token, err := tokens.Access(u, "session", time.Now())
+	if err != nil {
+		t.Fatalf("error issuing token: %v", err)
+	}
+	if !account.IsJWT(token) {
+		t.Errorf("expected token to look like a JWT")
+	}
+
+	claims, err := tokens.Verify(token)
+	if err != nil {
+		t.Fatalf("error verifying token: %v", err)
+	}
+	if claims.UserId() != 7 || claims.Name != "ann" || claims.SessionId != "session" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	expired, _ := tokens.Access(u, "session", time.Now().Add(-2*time.Minute))
+	if _, err := tokens.Verify(expired); err == nil {
+		t.Errorf("expected expired token to be rejected")
+	}
+
+	other := account.NewTokens([]byte("fedcba9876543210fedcba9876543210"), time.Minute, time.Hour)
+	if _, err := other.Verify(token); err == nil {
+		t.Errorf("expected token signed with another secret to be rejected")
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	token, hash, err := account.NewRefreshToken("session")
+	if err != nil {
+		t.Fatalf("error generating refresh token: %v", err)
+	}
+
+	id, parsed, err := account.ParseRefreshToken(token)
+	if err != nil {
+		t.Fatalf("error parsing refresh token: %v", err)
+	}
+	if id != "session" || parsed != hash {
+		t.Errorf("expected session and hash to round-trip; got %q, %q", id, parsed)
+	}
+
+	if _, _, err := account.ParseRefreshToken("garbage"); err == nil {
+		t.Errorf("expected malformed refresh token to be rejected")
+	}
+}
+
+func TestPairingCode(t *testing.T) {
+	code, err := account.NewPairingCode()
+	if err != nil {
+		t.Fatalf("NewPairingCode failed: %v", err)
+	}
+	if account.NormalizePairingCode(code) != code {
+		t.Errorf("expected generated code %q to be well-formed", code)
+	}
+
+	for input, expected := range map[string]string{
+		"012345":  "012345",
+		"012 345": "012345",
+		"012-345": "012345",
+		"01234":   "",
+		"0123456": "",
+		"01234a":  "",
+		"０１２３４５":  "",
+	} {
+		if got := account.NormalizePairingCode(input); got != expected {
+			t.Errorf("NormalizePairingCode(%q) = %q; expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	if !account.Allows(account.RoleAdmin, account.PermAdmin) || account.Allows(account.RoleUser, account.PermAdmin) {
+		t.Errorf("expected only admins to have the admin permission")
+	}
+	if !account.Allows(account.RoleReadOnly, account.PermRead) || account.Allows(account.RoleReadOnly, account.PermWrite) {
+		t.Errorf("expected readonly users to only read")
+	}
+	if account.Allows("root", account.PermRead) {
+		t.Errorf("expected unknown roles to have no permissions")
+	}
+
+	roles, err := account.ParseRoles("alice:admin, bob:readonly")
+	if err != nil {
+		t.Fatalf("error parsing roles: %v", err)
+	}
+	if roles["alice"] != account.RoleAdmin || roles["bob"] != account.RoleReadOnly {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	for _, invalid := range []string{"alice", ":admin", "alice:root"} {
+		if _, err := account.ParseRoles(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	for qualified, want := range map[string][2]string{
+		"alice":        {account.DefaultNamespace, "alice"},
+		"family/alice": {"family", "alice"},
+	} {
+		if namespace, name := account.SplitName(qualified); namespace != want[0] || name != want[1] {
+			t.Errorf("%s: expected %v; got %s, %s", qualified, want, namespace, name)
+		}
+	}
+	for name, valid := range map[string]bool{"family": true, "team-1_b": true, "": false, "Family": false, "-a": false, "a/b": false} {
+		if account.ValidNamespace(name) != valid {
+			t.Errorf("expected ValidNamespace(%q) to be %v", name, valid)
+		}
+	}
+}
+
+func TestPeers(t *testing.T) {
+	peers, err := account.ParsePeers("alice@laptop:10.8.0.2, family/bob@phone:fd00::0003")
+	if err != nil {
+		t.Fatalf("error parsing peers: %v", err)
+	}
+	if p := peers["10.8.0.2"]; p.User != "alice" || p.Device != "laptop" {
+		t.Errorf("unexpected peer %+v", p)
+	}
+	if p := peers["fd00::3"]; p.User != "family/bob" || p.Device != "phone" {
+		t.Errorf("expected IPv6 addresses in canonical form; got %v", peers)
+	}
+	for _, invalid := range []string{"alice:10.8.0.2", "alice@:10.8.0.2", "@laptop:10.8.0.2", "alice@laptop", "alice@laptop:10.8.0.0/24", "alice@laptop:10.8.0.2,bob@phone:10.8.0.2"} {
+		if _, err := account.ParsePeers(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// The SHA-1 test vector of RFC 6238, truncated to six digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	at := time.Unix(59, 0)
+	code, err := account.TOTPCode(secret, at)
+	if err != nil || code != "287082" {
+		t.Fatalf("TOTPCode() = %q, %v; want 287082", code, err)
+	}
+
+	step, ok := account.VerifyTOTP(secret, code, 0, at)
+	if !ok || step != 1 {
+		t.Fatalf("VerifyTOTP() = %d, %v; want step 1", step, ok)
+	}
+	if _, ok := account.VerifyTOTP(secret, code, step, at); ok {
+		t.Error("expected a used code to be rejected")
+	}
+	if _, ok := account.VerifyTOTP(secret, code, 0, at.Add(30*time.Second)); !ok {
+		t.Error("expected the code of the previous step to be accepted")
+	}
+	if _, ok := account.VerifyTOTP(secret, code, 0, at.Add(time.Minute)); ok {
+		t.Error("expected an outdated code to be rejected")
+	}
+	if _, ok := account.VerifyTOTP(secret, "000000", 0, at); ok {
+		t.Error("expected a wrong code to be rejected")
+	}
+	if _, ok := account.VerifyTOTP("not base32!", code, 0, at); ok {
+		t.Error("expected an invalid secret to be rejected")
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/backup"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []*backup.Record{
+		{Id: 100000, Name: "notes", DataType: "text/plain", Version: 3, Owner: "alice", Tags: []string{"work"}, CreatedAt: created, UpdatedAt: created, Flavors: []backup.Flavor{{DataType: "text/html", Data: []byte("<b>hello</b>")}}, Data: []byte("hello")},
+		{Id: 100001, Name: "secret", DataType: "application/pdf", IsEncrypted: true, PasswordHash: "$2a$10$hash", Salt: "c2FsdA==", Nonce: "bm9uY2U=", Streamed: true, Version: 1, CreatedAt: created, UpdatedAt: created, Data: []byte{0, 1, 2, 0xff}},
+	}
+
+	for _, format := range []string{backup.FormatJSON, backup.FormatTar} {
+		var buf bytes.Buffer
+		w, err := backup.NewWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: error creating writer: %v", format, err)
+		}
+		for _, rec := range records {
+			if err := w.Write(rec); err != nil {
+				t.Fatalf("%s: error writing record: %v", format, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: error closing archive: %v", format, err)
+		}
+
+		var read []*backup.Record
+		err = backup.Read(&buf, format, func(rec *backup.Record) error {
+			read = append(read, rec)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: error reading archive: %v", format, err)
+		}
+		if !reflect.DeepEqual(read, records) {
+			t.Errorf("%s: expected %+v; got %+v", format, records, read)
+		}
+	}
+}
+
+func TestBackupEmptyJSON(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := backup.NewWriter(&buf, backup.FormatJSON)
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing archive: %v", err)
+	}
+
+	n := 0
+	if err := backup.Read(&buf, backup.FormatJSON, func(*backup.Record) error { n++; return nil }); err != nil || n != 0 {
+		t.Errorf("expected empty archive; got %d records, %v", n, err)
+	}
+}
@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/bandwidth"
+)
+
+func TestBandwidthMeter(t *testing.T) {
+	m := bandwidth.New()
+	now := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+
+	m.Add("alice", 100, now)
+	if used := m.Add("alice", 50, now); used != 150 {
+		t.Errorf("expected 150 bytes used; got %d", used)
+	}
+	if used := m.Used("bob", now); used != 0 {
+		t.Errorf("expected unrelated key to have used nothing; got %d", used)
+	}
+
+	reset := bandwidth.Reset(now)
+	if !reset.Equal(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected reset at midnight UTC; got %v", reset)
+	}
+	if used := m.Used("alice", reset); used != 0 {
+		t.Errorf("expected usage to reset the next day; got %d", used)
+	}
+
+	// Days are UTC days, whatever the time zone of now.
+	local := time.Date(2024, 3, 2, 1, 0, 0, 0, time.FixedZone("CET", 3600))
+	m.Add("alice", 10, local)
+	if used := m.Used("alice", reset); used != 10 {
+		t.Errorf("expected usage of the same UTC day; got %d", used)
+	}
+}
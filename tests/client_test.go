@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/testutil"
+	"github.com/copybridge/copybridge-server/pkg/copybridge/client"
+)
+
+func TestClient(t *testing.T) {
+	s := testutil.NewServer(t, "SEQUENTIAL_IDS=false")
+	anon := &client.Client{BaseURL: s.URL}
+
+	// Anonymous clipboards are only reachable by public id.
+	created, err := anon.Create("greeting", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.PublicId == "" {
+		t.Fatalf("expected a public id; got %+v", created)
+	}
+	if _, err := anon.Get(strconv.Itoa(created.Id)); err == nil {
+		t.Fatalf("expected the numeric id to be restricted")
+	}
+	written, err := anon.Write(created.PublicId, created.Version, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.Version <= created.Version {
+		t.Errorf("expected a new version; got %+v", written)
+	}
+	if _, err := anon.Write(created.PublicId, created.Version, "text/plain", strings.NewReader("stale")); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a write of a stale version to conflict; got %v", err)
+	}
+
+	data, dataType, err := anon.Read(created.PublicId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(data)
+	data.Close()
+	if string(b) != "hello" || !strings.HasPrefix(dataType, "text/plain") {
+		t.Errorf("expected the written data; got %q of type %q", b, dataType)
+	}
+
+	if err := anon.Delete(created.PublicId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anon.Get(created.PublicId); err == nil {
+		t.Errorf("expected the clipboard to be deleted")
+	}
+
+	// Encrypted clipboards need their password.
+	alice := &client.Client{BaseURL: s.URL, APIKey: testutil.AliceKey, Password: "secret"}
+	sealed, err := alice.Create("sealed", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Write(sealed.PublicId, 0, "text/plain", strings.NewReader("hidden")); err != nil {
+		t.Fatal(err)
+	}
+	alice.Password = ""
+	if _, _, err := alice.Read(sealed.PublicId); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected a missing password to be reported; got %v", err)
+	}
+
+	list, err := alice.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].PublicId != sealed.PublicId || !list[0].IsEncrypted {
+		t.Errorf("expected the encrypted clipboard to be listed; got %+v", list)
+	}
+}
+
+func TestClientUntrusted(t *testing.T) {
+	s := testutil.NewServer(t, "TRUST_LEVELS=text/x-shellscript=script")
+	c := &client.Client{BaseURL: s.URL}
+
+	created, err := c.Create("script", "text/x-shellscript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(created.PublicId, 0, "text/x-shellscript", strings.NewReader("rm -rf /")); err != nil {
+		t.Fatal(err)
+	}
+
+	var untrusted *client.UntrustedError
+	if _, _, err := c.Read(created.PublicId); !errors.As(err, &untrusted) || untrusted.Level != "script" {
+		t.Fatalf("expected the script to be flagged; got %v", err)
+	}
+	c.ConfirmUntrusted = true
+	data, _, err := c.Read(created.PublicId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+}
+
+func TestSyncPublicIds(t *testing.T) {
+	s := testutil.NewServer(t, "SEQUENTIAL_IDS=false")
+	c := &client.Client{BaseURL: s.URL}
+
+	created, err := c.Create("phone", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	type message struct {
+		Op       string `json:"op"`
+		Id       int    `json:"id"`
+		PublicId string `json:"public_id"`
+		Version  int    `json:"version"`
+		Message  string `json:"message"`
+	}
+	read := func() message {
+		t.Helper()
+		var m message
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// Numeric ids are restricted like in the API, public ids are not.
+	if err := conn.WriteJSON(map[string]any{"op": "subscribe", "ids": []int{created.Id}, "public_ids": []string{created.PublicId}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := read(); m.Op != "error" || !strings.Contains(m.Message, "not found") {
+		t.Fatalf("expected the numeric id to be restricted; got %+v", m)
+	}
+	if m := read(); m.Op != "snapshot" || m.PublicId != created.PublicId {
+		t.Fatalf("expected a snapshot of the clipboard; got %+v", m)
+	}
+
+	written, err := c.Write(created.PublicId, 0, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := read(); m.Op != "change" || m.Id != created.Id || m.Version != written.Version {
+		t.Fatalf("expected the change of the clipboard; got %+v", m)
+	}
+}
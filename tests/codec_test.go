@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/codec"
+)
+
+func TestCodecCBOR(t *testing.T) {
+	// Examples from RFC 8949, appendix A, except for floats, which are
+	// encoded as float32 or float64 and never as half-precision floats.
+	tests := []struct {
+		json string
+		cbor string
+	}{
+		{`0`, "00"},
+		{`23`, "17"},
+		{`24`, "1818"},
+		{`1000`, "1903e8"},
+		{`1000000000000`, "1b000000e8d4a51000"},
+		{`18446744073709551615`, "1bffffffffffffffff"},
+		{`-1`, "20"},
+		{`-1000`, "3903e7"},
+		{`-9223372036854775808`, "3b7fffffffffffffff"},
+		{`1.5`, "fa3fc00000"},
+		{`1.1`, "fb3ff199999999999a"},
+		{`""`, "60"},
+		{`"IETF"`, "6449455446"},
+		{`"ü"`, "62c3bc"},
+		{`false`, "f4"},
+		{`true`, "f5"},
+		{`null`, "f6"},
+		{`[]`, "80"},
+		{`[1,2,3]`, "83010203"},
+		{`{}`, "a0"},
+		{`{"b":[2,3],"a":1}`, "a26162820203616101"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.cbor)
+		got, err := codec.CBOR.FromJSON([]byte(tt.json))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("FromJSON(%s) = %x, %v; want %s", tt.json, got, err, tt.cbor)
+		}
+		back, err := codec.CBOR.ToJSON(want)
+		if err != nil || string(back) != tt.json {
+			t.Errorf("ToJSON(%s) = %s, %v; want %s", tt.cbor, back, err, tt.json)
+		}
+	}
+}
+
+func TestCodecCBORDecode(t *testing.T) {
+	tests := []struct {
+		cbor string
+		json string
+	}{
+		{"f93e00", `1.5`},
+		{"f90001", `5.960464477539063e-08`},
+		{"3bffffffffffffffff", `-18446744073709551616`},
+		{"c11a514b67b0", `1363896240`},
+		{"4401020304", `"AQIDBA=="`},
+		{"5f42010243030405ff", `"AQIDBAU="`},
+		{"7f657374726561646d696e67ff", `"streaming"`},
+		{"9fff", `[]`},
+		{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+		{"f7", `null`},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.cbor)
+		got, err := codec.CBOR.ToJSON(data)
+		if err != nil || string(got) != tt.json {
+			t.Errorf("ToJSON(%s) = %s, %v; want %s", tt.cbor, got, err, tt.json)
+		}
+	}
+}
+
+func TestCodecMessagePack(t *testing.T) {
+	tests := []struct {
+		json    string
+		msgpack string
+	}{
+		{`0`, "00"},
+		{`127`, "7f"},
+		{`128`, "cc80"},
+		{`256`, "cd0100"},
+		{`65536`, "ce00010000"},
+		{`4294967296`, "cf0000000100000000"},
+		{`-1`, "ff"},
+		{`-32`, "e0"},
+		{`-33`, "d0df"},
+		{`-129`, "d1ff7f"},
+		{`-9223372036854775808`, "d38000000000000000"},
+		{`1.5`, "ca3fc00000"},
+		{`1.1`, "cb3ff199999999999a"},
+		{`"a"`, "a161"},
+		{`"` + strings.Repeat("x", 32) + `"`, "d920" + strings.Repeat("78", 32)},
+		{`null`, "c0"},
+		{`false`, "c2"},
+		{`true`, "c3"},
+		{`[]`, "90"},
+		{`{"b":[2,3],"a":1}`, "82a162920203a16101"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.msgpack)
+		got, err := codec.MessagePack.FromJSON([]byte(tt.json))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("FromJSON(%s) = %x, %v; want %s", tt.json, got, err, tt.msgpack)
+		}
+		back, err := codec.MessagePack.ToJSON(want)
+		if err != nil || string(back) != tt.json {
+			t.Errorf("ToJSON(%s) = %s, %v; want %s", tt.msgpack, back, err, tt.json)
+		}
+	}
+}
+
+func TestCodecMessagePackDecode(t *testing.T) {
+	tests := []struct {
+		msgpack string
+		json    string
+	}{
+		{"d0ff", `-1`},
+		{"d3ffffffffffffffff", `-1`},
+		{"dc000101", `[1]`},
+		{"de0001a16101", `{"a":1}`},
+		{"c403010203", `"AQID"`},
+		{"d6ff00000000", `"1970-01-01T00:00:00Z"`},
+		{"c70cff0000000100000000000000ff", `"1970-01-01T00:04:15.000000001Z"`},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.msgpack)
+		got, err := codec.MessagePack.ToJSON(data)
+		if err != nil || string(got) != tt.json {
+			t.Errorf("ToJSON(%s) = %s, %v; want %s", tt.msgpack, got, err, tt.json)
+		}
+	}
+}
+
+func TestCodecInvalid(t *testing.T) {
+	deep := strings.Repeat("81", 600) + "00"
+
+	tests := []struct {
+		format *codec.Format
+		data   string
+	}{
+		{codec.CBOR, ""},
+		{codec.CBOR, "6261"},
+		{codec.CBOR, "0000"},
+		{codec.CBOR, "1c"},
+		{codec.CBOR, "a10102"},
+		{codec.CBOR, "61ff"},
+		{codec.CBOR, "fb7ff8000000000000"},
+		{codec.CBOR, "f97c00"},
+		{codec.CBOR, "9b00000000ffffffff"},
+		{codec.CBOR, deep},
+		{codec.MessagePack, ""},
+		{codec.MessagePack, "c1"},
+		{codec.MessagePack, "a261"},
+		{codec.MessagePack, "810102"},
+		{codec.MessagePack, "d40500"},
+		{codec.MessagePack, "dd7fffffff"},
+		{codec.MessagePack, deep},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.data)
+		if got, err := tt.format.ToJSON(data); err == nil {
+			t.Errorf("%s ToJSON(%s) = %s; want an error", tt.format.Name, tt.data, got)
+		}
+	}
+
+	for _, doc := range []string{``, `{`, `[1,]`, `1 2`} {
+		if _, err := codec.CBOR.FromJSON([]byte(doc)); err == nil {
+			t.Errorf("FromJSON(%q) succeeded; want an error", doc)
+		}
+	}
+}
+
+func TestCodecMediaTypes(t *testing.T) {
+	tests := map[string]*codec.Format{
+		"application/cbor":        codec.CBOR,
+		"application/msgpack":     codec.MessagePack,
+		"application/x-msgpack":   codec.MessagePack,
+		"Application/Vnd.Msgpack": codec.MessagePack,
+		"application/json":        nil,
+	}
+	for mediaType, want := range tests {
+		if got := codec.ByMediaType(mediaType); got != want {
+			t.Errorf("ByMediaType(%q) = %v; want %v", mediaType, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/convert"
+	"github.com/copybridge/copybridge-server/internal/thumbnail"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"headings", "# Title\n\nSub\n---", "<h1>Title</h1>\n<h2>Sub</h2>"},
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>"},
+		{"emphasis", "*em*, **strong**, ~~gone~~ and snake_case_name", "<p><em>em</em>, <strong>strong</strong>, <del>gone</del> and snake_case_name</p>"},
+		{"code span", "run `rm -rf <dir>` now", "<p>run <code>rm -rf &lt;dir&gt;</code> now</p>"},
+		{"link", "[docs](https://example.com/a_(b)?x=1&y=2)", `<p><a href="https://example.com/a_(b)?x=1&amp;y=2">docs</a></p>`},
+		{"unsafe link", "[click](javascript:alert(1))", "<p>click</p>"},
+		{"image", "![logo](/logo.png)", `<p><img src="/logo.png" alt="logo" /></p>`},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com">https://example.com</a></p>`},
+		{"raw html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"line break", "a  \nb", "<p>a<br />\nb</p>"},
+		{"escapes", `\*not em\*`, "<p>*not em*</p>"},
+		{"tight list", "- a\n- b\n  - c", "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul></li>\n</ul>"},
+		{"loose list", "1. a\n\n2. b", "<ol>\n<li><p>a</p></li>\n<li><p>b</p></li>\n</ol>"},
+		{"ordered start", "3) c", "<ol start=\"3\">\n<li>c</li>\n</ol>"},
+		{"quote", "> a\nb", "<blockquote>\n<p>a\nb</p>\n</blockquote>"},
+		{"fenced code", "```go\nx := <-ch\n```", "<pre><code class=\"language-go\">x := &lt;-ch\n</code></pre>"},
+		{"indented code", "    a\n\n    b", "<pre><code>a\n\nb\n</code></pre>"},
+		{"thematic break", "a\n\n***", "<p>a</p>\n<hr />"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convert.Markdown(tt.in); got != tt.want {
+				t.Errorf("Markdown(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"whitespace", "<p>Hello   <b>world</b>\n again</p>", "Hello world again\n"},
+		{"paragraphs", "<h1>Title</h1><p>one<br>two</p><div>three</div>", "Title\n\none\ntwo\n\nthree\n"},
+		{"document", "<html><head><title>t</title><style>p {}</style></head><body><script>x()</script><p>body</p></body></html>", "body\n"},
+		{"lists", "<ul><li>a</li><li>b<ol start=\"2\"><li>c</li></ol></li></ul><p>after</p>", "- a\n- b\n  2. c\n\nafter\n"},
+		{"table", "<table><tr><th>k</th><th>v</th></tr><tr><td>1</td><td>2</td></tr></table>", "k\tv\n1\t2\n"},
+		{"pre", "<pre>  a\n    b\n</pre><p>c</p>", "a\n    b\n\nc\n"},
+		{"links", `<a href="https://example.com">site</a> <a href="https://example.com">https://example.com</a> <img alt="pic">`, "site (https://example.com) https://example.com pic\n"},
+		{"entities", "<p>a &amp; b &lt;c&gt;</p>", "a & b <c>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convert.HTMLText(tt.in); got != tt.want {
+				t.Errorf("HTMLText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		dataType string
+		data     string
+		target   string
+		want     string
+	}{
+		{"text/markdown", "# Hi", convert.HTML, "<h1>Hi</h1>"},
+		{"text/markdown; charset=utf-8", "**Hi** [there](https://example.com)", convert.Text, "Hi there (https://example.com)\n"},
+		{"text/plain", "a & b\nc\n\nd", convert.HTML, "<p>a &amp; b<br />\nc</p>\n<p>d</p>"},
+		{"text/html", "<b>Hi</b>", convert.HTML, "<b>Hi</b>"},
+		{"text/html", "<b>Hi</b>", convert.Text, "Hi\n"},
+		{"text/x-go", "package main", convert.Text, "package main"},
+		{"application/json", `{"a":[1,2]}`, convert.JSON, "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n"},
+		{"application/ld+json", ` {"a":1} `, convert.JSON, "{\n  \"a\": 1\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataType+" as "+tt.target, func(t *testing.T) {
+			got, err := convert.Convert(tt.dataType, []byte(tt.data), tt.target, 1<<20)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("Convert() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := convert.Convert("application/json", []byte("{"), convert.JSON, 1<<20); !errors.Is(err, convert.ErrInvalidData) {
+		t.Errorf("expected invalid JSON to be rejected; got %v", err)
+	}
+	if _, err := convert.Convert("image/png", []byte("x"), convert.HTML, 1<<20); err != convert.ErrUnsupported {
+		t.Errorf("expected images not to convert to HTML; got %v", err)
+	}
+}
+
+func TestConvertImages(t *testing.T) {
+	src := encodePNG(t, 40, 30)
+
+	for _, data := range [][]byte{src, []byte(base64.StdEncoding.EncodeToString(src))} {
+		out, err := convert.Convert("image/png", data, convert.JPEG, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil || img.Bounds() != image.Rect(0, 0, 40, 30) {
+			t.Errorf("expected a 40x30 JPEG; got %v, %v", img, err)
+		}
+	}
+
+	out, err := convert.Convert("image/png", src, convert.GIF, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gif.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("expected a GIF; got %v", err)
+	}
+
+	if _, err := convert.Convert("image/png", src, convert.PNG, 100); err != thumbnail.ErrTooLarge {
+		t.Errorf("expected large images to be rejected; got %v", err)
+	}
+	if _, err := convert.Convert("image/png", []byte("not an image"), convert.PNG, 1<<20); !errors.Is(err, convert.ErrInvalidData) {
+		t.Errorf("expected broken images to be rejected; got %v", err)
+	}
+}
+
+func TestConvertPick(t *testing.T) {
+	types := []string{"text/plain", "text/html", "text/markdown"}
+	if got := convert.Pick(types, convert.HTML); got != 1 {
+		t.Errorf("expected the HTML flavor; got %d", got)
+	}
+	if got := convert.Pick(types, convert.Text); got != 0 {
+		t.Errorf("expected the plain text; got %d", got)
+	}
+	if got := convert.Pick([]string{"image/png", "text/markdown"}, convert.HTML); got != 1 {
+		t.Errorf("expected the Markdown flavor; got %d", got)
+	}
+	if got := convert.Pick([]string{"image/png"}, convert.JSON); got != -1 {
+		t.Errorf("expected no type to convert; got %d", got)
+	}
+
+	for _, target := range []string{"html", "JPG", " text "} {
+		if _, err := convert.ParseTarget(target); err != nil {
+			t.Errorf("ParseTarget(%q): %v", target, err)
+		}
+	}
+	if _, err := convert.ParseTarget("pdf"); err == nil {
+		t.Error("expected unknown targets to be rejected")
+	}
+}
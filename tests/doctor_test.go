@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/doctor"
+)
+
+func TestDoctorReport(t *testing.T) {
+	var r doctor.Report
+	r.OK("config", "configuration is valid")
+	r.Warn("schema", "back up the database", "%d migrations are applied on start", 2)
+	if r.Failed() {
+		t.Fatal("expected a report without errors not to fail")
+	}
+	r.Fail("tls", "", "cannot load certificate")
+	if !r.Failed() {
+		t.Fatal("expected a report with an error to fail")
+	}
+
+	var out bytes.Buffer
+	r.Write(&out)
+	for _, want := range []string{
+		"ok     config      configuration is valid\n",
+		"warn   schema      2 migrations are applied on start\n",
+		"→ back up the database\n",
+		"error  tls         cannot load certificate\n",
+		"3 checks, 1 warnings, 1 errors\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+// writeCert writes a self-signed certificate for host, valid from notBefore
+// to notAfter, and its key to dir, returning their paths.
+func writeCert(t *testing.T, dir, host string, notBefore, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestDoctorTLS(t *testing.T) {
+	now := time.Now()
+	certFile, keyFile := writeCert(t, t.TempDir(), "clip.example.com", now.Add(-time.Hour), now.Add(90*24*time.Hour))
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		now      time.Time
+		statuses []doctor.Status
+		message  string
+	}{
+		{"plain HTTP", nil, now, []doctor.Status{doctor.OK}, "not enabled"},
+		{"only certificate", map[string]string{"TLS_CERT": certFile}, now, []doctor.Status{doctor.Error}, "only one of"},
+		{"missing files", map[string]string{"TLS_CERT": certFile + ".missing", "TLS_KEY": keyFile}, now, []doctor.Status{doctor.Error}, "cannot load certificate"},
+		{"valid", map[string]string{"TLS_CERT": certFile, "TLS_KEY": keyFile, "PUBLIC_URL": "https://clip.example.com"}, now, []doctor.Status{doctor.OK}, "valid until"},
+		{"expiring", map[string]string{"TLS_CERT": certFile, "TLS_KEY": keyFile}, now.Add(80 * 24 * time.Hour), []doctor.Status{doctor.Warning}, "expires on"},
+		{"expired", map[string]string{"TLS_CERT": certFile, "TLS_KEY": keyFile}, now.Add(100 * 24 * time.Hour), []doctor.Status{doctor.Error}, "expired on"},
+		{"not yet valid", map[string]string{"TLS_CERT": certFile, "TLS_KEY": keyFile}, now.Add(-2 * time.Hour), []doctor.Status{doctor.Error}, "not valid before"},
+		{"other host", map[string]string{"TLS_CERT": certFile, "TLS_KEY": keyFile, "PUBLIC_URL": "https://other.example.com"}, now, []doctor.Status{doctor.OK, doctor.Warning}, "does not cover other.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"TLS_CERT", "TLS_KEY", "TLS_AUTOCERT_DOMAINS", "PUBLIC_URL"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			var r doctor.Report
+			doctor.TLS(&r, tt.now)
+			if len(r.Findings) != len(tt.statuses) {
+				t.Fatalf("expected %d findings, got %+v", len(tt.statuses), r.Findings)
+			}
+			for i, f := range r.Findings {
+				if f.Status != tt.statuses[i] {
+					t.Errorf("expected finding %d to be %s, got %+v", i, tt.statuses[i], f)
+				}
+			}
+			last := r.Findings[len(r.Findings)-1]
+			if !strings.Contains(last.Message, tt.message) {
+				t.Errorf("expected message to contain %q, got %q", tt.message, last.Message)
+			}
+			if last.Status != doctor.OK && last.Hint == "" {
+				t.Errorf("expected a hint for %+v", last)
+			}
+		})
+	}
+}
+
+func TestDoctorCrypto(t *testing.T) {
+	var r doctor.Report
+	doctor.Crypto(&r)
+	if len(r.Findings) != 1 || r.Findings[0].Status == doctor.Error {
+		t.Fatalf("expected crypto self-test to pass, got %+v", r.Findings)
+	}
+}
+
+func TestCheckWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := doctor.CheckWritableDir(dir); err != nil {
+		t.Fatalf("expected %s to be writable, got %v", dir, err)
+	}
+	if err := doctor.CheckWritableDir(filepath.Join(dir, "a", "b")); err != nil {
+		t.Fatalf("expected a missing directory to be creatable, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("expected the check to leave no files behind, got %v", entries)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := doctor.CheckWritableDir(file); err == nil {
+		t.Fatal("expected a file not to be a writable directory")
+	}
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/testutil"
+	"github.com/copybridge/copybridge-server/pkg/copybridge"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// countingStorage counts the clipboards read from the storage it wraps.
+type countingStorage struct {
+	copybridge.Service
+	reads atomic.Int64
+}
+
+func (s *countingStorage) Get(ctx context.Context, id int) (*copybridge.Clipboard, error) {
+	s.reads.Add(1)
+	return s.Service.Get(ctx, id)
+}
+
+// newEmbedded embeds a server under /cb in a program routing with parent,
+// and returns it with the storage it wraps.
+func newEmbedded(t *testing.T, parent func(h http.Handler) http.Handler) (*testutil.Server, *countingStorage) {
+	t.Helper()
+	t.Setenv("API_KEYS", "alice:"+testutil.AliceKey)
+	t.Setenv("KDF_SCRYPT_LOG_N", "10")
+
+	db, err := copybridge.OpenDatabase(fmt.Sprintf("file:copybridge-embed-%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "-")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &countingStorage{Service: db}
+
+	cb, err := copybridge.New(
+		copybridge.WithStorage(storage),
+		copybridge.WithPrefix("/cb"),
+		copybridge.WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if u := copybridge.RequestUser(r); u != nil {
+					w.Header().Set("X-Embedded-User", u.Name)
+				}
+				next.ServeHTTP(w, r)
+			})
+		}),
+		copybridge.WithMount("/hello", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello from the embedding program"))
+		})),
+	)
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot embed server: %v", err)
+	}
+
+	ts := &testutil.Server{Server: httptest.NewServer(parent(cb.Handler())), DB: db}
+	t.Cleanup(func() {
+		ts.Close()
+		cb.Close()
+		db.Close()
+	})
+	return ts, storage
+}
+
+func TestEmbed(t *testing.T) {
+	parents := map[string]func(h http.Handler) http.Handler{
+		"net/http": func(h http.Handler) http.Handler {
+			mux := http.NewServeMux()
+			mux.Handle("/cb/", h)
+			return mux
+		},
+		"chi": func(h http.Handler) http.Handler {
+			r := chi.NewRouter()
+			r.Mount("/cb", h)
+			return r
+		},
+	}
+	for name, parent := range parents {
+		t.Run(name, func(t *testing.T) {
+			s, storage := newEmbedded(t, parent)
+
+			resp := s.Do(t, http.MethodPut, "/cb/clipboard/424242?upsert=true", map[string]any{"name": "embedded", "type": "text/plain", "data": "hi"}, testutil.WithAPIKey(testutil.AliceKey)).Expect(t, http.StatusCreated)
+			if loc := resp.Header.Get("Location"); loc != "/cb/clipboard/424242" {
+				t.Errorf("expected Location /cb/clipboard/424242, got %q", loc)
+			}
+			if resp.Header.Get("X-Embedded-User") != "alice" {
+				t.Errorf("expected middleware to see alice, got %q", resp.Header.Get("X-Embedded-User"))
+			}
+
+			reads := storage.reads.Load()
+			s.Do(t, http.MethodGet, "/cb/ns/default/clipboard/424242", nil, testutil.WithAPIKey(testutil.AliceKey)).Expect(t, http.StatusOK)
+			if storage.reads.Load() == reads {
+				t.Error("expected the clipboard to be read from the custom storage")
+			}
+
+			if body := string(s.Do(t, http.MethodGet, "/cb/hello", nil).Expect(t, http.StatusOK).Body); body != "hello from the embedding program" {
+				t.Errorf("expected the mounted handler, got %q", body)
+			}
+			s.Do(t, http.MethodGet, "/clipboard", nil).Expect(t, http.StatusNotFound)
+
+			page := string(s.Do(t, http.MethodGet, "/cb/ui", nil).Expect(t, http.StatusOK).Body)
+			if !strings.Contains(page, `data-base="/cb"`) || !strings.Contains(page, `src="/cb/ui/static/app.js"`) {
+				t.Errorf("expected the web UI to load from /cb, got %s", page)
+			}
+			s.Do(t, http.MethodGet, "/cb/ui/static/app.js", nil).Expect(t, http.StatusOK)
+			s.Do(t, http.MethodGet, "/cb/ui/sw.js", nil).Expect(t, http.StatusOK)
+		})
+	}
+}
+
+func TestEmbedInvalidPrefix(t *testing.T) {
+	t.Setenv("API_KEYS", "")
+	db, err := copybridge.OpenDatabase("file:copybridge-embed-prefix?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := copybridge.New(copybridge.WithStorage(db), copybridge.WithPrefix("cb")); err == nil {
+		t.Fatal("expected a prefix without a leading slash to be rejected")
+	}
+}
+
+// failingStorage fails updates with err.
+type failingStorage struct {
+	copybridge.Service
+	err error
+}
+
+func (s *failingStorage) Update(ctx context.Context, c *copybridge.Clipboard) error {
+	return s.err
+}
+
+func TestEmbedStorageErrors(t *testing.T) {
+	t.Setenv("API_KEYS", "alice:"+testutil.AliceKey)
+	t.Setenv("KDF_SCRYPT_LOG_N", "10")
+	db, err := copybridge.OpenDatabase("file:copybridge-embed-errors?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storage := &failingStorage{Service: db}
+	cb, err := copybridge.New(copybridge.WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testutil.Server{Server: httptest.NewServer(cb.Handler()), DB: db}
+	defer s.Close()
+
+	etag := s.Do(t, http.MethodPut, "/clipboard/424242?upsert=true", map[string]any{"name": "errors", "type": "text/plain", "data": "hi"}, testutil.WithAPIKey(testutil.AliceKey)).Expect(t, http.StatusCreated).Header.Get("ETag")
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("no such row: %w", copybridge.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("locked by another writer: %w", copybridge.ErrConflict), http.StatusConflict},
+		{fmt.Errorf("storage denied the write: %w", copybridge.ErrUnauthorized), http.StatusUnauthorized},
+		{copybridge.ErrVersionConflict, http.StatusConflict},
+		{errors.New("disk on fire"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		storage.err = tt.err
+		resp := s.Do(t, http.MethodPut, "/clipboard/424242", map[string]any{"name": "errors", "type": "text/plain", "data": "changed"}, testutil.WithAPIKey(testutil.AliceKey), testutil.WithHeader("If-Match", etag)).Expect(t, tt.want)
+		if msg := resp.Error(t).Message; tt.want != http.StatusInternalServerError && msg != tt.err.Error() {
+			t.Errorf("expected %q, got %q", tt.err.Error(), msg)
+		}
+	}
+}
+
+func TestEmbedInvalidConfig(t *testing.T) {
+	t.Setenv("API_KEYS", "")
+	t.Setenv("JWT_SECRET", "too short")
+	db, err := copybridge.OpenDatabase("file:copybridge-embed-config?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := copybridge.New(copybridge.WithStorage(db)); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Fatalf("expected a short JWT_SECRET to be rejected, got %v", err)
+	}
+}
+
+func TestEmbedInvalidNumbers(t *testing.T) {
+	t.Setenv("API_KEYS", "")
+	t.Setenv("COMPRESSION_MIN_SIZE", "1kb")
+	t.Setenv("STRICT_REQUEST_BODIES", "maybe")
+	db, err := copybridge.OpenDatabase("file:copybridge-embed-numbers?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = copybridge.New(copybridge.WithStorage(db))
+	if err == nil || !strings.Contains(err.Error(), "COMPRESSION_MIN_SIZE") || !strings.Contains(err.Error(), "STRICT_REQUEST_BODIES") {
+		t.Fatalf("expected the invalid variables to be rejected, got %v", err)
+	}
+}
+
+func TestEmbedNotFound(t *testing.T) {
+	db, err := copybridge.OpenDatabase("file:copybridge-embed-not-found?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.Get(ctx, 424242); !errors.Is(err, copybridge.ErrNotFound) {
+		t.Fatalf("expected a missing clipboard to be not found, got %v", err)
+	}
+
+	// So are the other records the getters look up.
+	for name, get := range map[string]func() (any, error){
+		"user":         func() (any, error) { return db.User(ctx, 424242) },
+		"user by name": func() (any, error) { return db.UserByName(ctx, "default", "nobody") },
+		"TOTP secret":  func() (any, error) { return db.TOTP(ctx, 424242) },
+		"alias":        func() (any, error) { return db.AliasByName(ctx, "default", "nowhere") },
+		"token":        func() (any, error) { return db.TokenByHash(ctx, "unknown") },
+		"thumbnail":    func() (any, error) { return db.Thumbnail(ctx, 424242, 1) },
+		"duplicate":    func() (any, error) { return db.Dedupe(ctx, "default", 1, "unknown") },
+		"stack item":   func() (any, error) { return db.PopItem(ctx, 424242) },
+	} {
+		if _, err := get(); !errors.Is(err, copybridge.ErrNotFound) {
+			t.Errorf("expected a missing %s to be not found, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/env"
+)
+
+func TestSnapshotRedactsSecrets(t *testing.T) {
+	t.Setenv("TEST_API_KEYS", "ann:k1")
+	t.Setenv("TEST_PORT", "8080")
+
+	env.String("TEST_API_KEYS", "")
+	env.Int("TEST_PORT", 0)
+	env.Duration("TEST_INTERVAL", 0)
+
+	snapshot := env.Snapshot()
+	if v := snapshot["TEST_API_KEYS"]; v != "[redacted]" {
+		t.Errorf("expected secret to be redacted; got %q", v)
+	}
+	if v := snapshot["TEST_PORT"]; v != "8080" {
+		t.Errorf("expected value to be shown; got %q", v)
+	}
+	if v := snapshot["TEST_INTERVAL"]; v != "0s" {
+		t.Errorf("expected default to be shown; got %q", v)
+	}
+}
+
+func TestErrReportsInvalidValues(t *testing.T) {
+	t.Setenv("TEST_WORKERS", "many")
+	t.Setenv("TEST_TIMEOUT", "soon")
+	env.Err()
+
+	if v := env.Int("TEST_WORKERS", 4); v != 4 {
+		t.Errorf("expected the default for an invalid value; got %d", v)
+	}
+	env.Duration("TEST_TIMEOUT", time.Second)
+	err := env.Err()
+	if err == nil || !strings.Contains(err.Error(), "TEST_WORKERS") || !strings.Contains(err.Error(), "TEST_TIMEOUT") {
+		t.Fatalf("expected both invalid values to be reported; got %v", err)
+	}
+	if err := env.Err(); err != nil {
+		t.Errorf("expected errors to be reported once; got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/events"
+)
+
+func TestBusFanOut(t *testing.T) {
+	bus := events.NewBus()
+	a, unsubscribeA := bus.Subscribe(1)
+	b, unsubscribeB := bus.Subscribe(1)
+	defer unsubscribeB()
+
+	bus.Publish(events.Event{Type: events.ClipboardCreated, ClipboardId: 1})
+	for _, ch := range []<-chan events.Event{a, b} {
+		if e := <-ch; e.ClipboardId != 1 || e.Seq != 1 || e.Time.IsZero() {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+
+	// A full buffer drops events instead of blocking the publisher.
+	bus.Publish(events.Event{Type: events.ClipboardUpdated, ClipboardId: 1})
+	bus.Publish(events.Event{Type: events.ClipboardUpdated, ClipboardId: 2})
+	if e := <-b; e.ClipboardId != 1 {
+		t.Errorf("expected the first event to be kept; got %+v", e)
+	}
+	if seq := bus.Seq(); seq != 3 {
+		t.Errorf("expected dropped events to be numbered too; got sequence %d", seq)
+	}
+
+	// Unsubscribing closes the channel once it is drained.
+	unsubscribeA()
+	for range a {
+	}
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/backup"
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/federation"
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+const federationSecret = "federation-test-secret"
+
+func TestFederationSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"public_id": "x"}`)
+	sign := func() *http.Request {
+		r := httptest.NewRequest("POST", "/federation/records", bytes.NewReader(body))
+		federation.Sign(r, body, federationSecret, now)
+		return r
+	}
+
+	if err := federation.Verify(sign(), body, federationSecret, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected a valid signature; got %v", err)
+	}
+	if err := federation.Verify(sign(), []byte(`{}`), federationSecret, now); err != federation.ErrInvalidSignature {
+		t.Errorf("expected a changed body to be rejected; got %v", err)
+	}
+	if err := federation.Verify(sign(), body, "another-secret-of-a-peer", now); err != federation.ErrInvalidSignature {
+		t.Errorf("expected another secret to be rejected; got %v", err)
+	}
+	if err := federation.Verify(sign(), body, federationSecret, now.Add(time.Hour)); err != federation.ErrExpiredSignature {
+		t.Errorf("expected an old signature to be rejected; got %v", err)
+	}
+	if err := federation.Verify(httptest.NewRequest("GET", "/federation/records", nil), nil, federationSecret, now); err != federation.ErrUnsigned {
+		t.Errorf("expected an unsigned request to be rejected; got %v", err)
+	}
+}
+
+func TestFederationNewer(t *testing.T) {
+	t1 := time.Now()
+	t2 := t1.Add(time.Second)
+	tests := []struct {
+		name string
+		a, b federation.Entry
+		want bool
+	}{
+		{"later update", federation.Entry{UpdatedAt: t2, Hash: "a"}, federation.Entry{UpdatedAt: t1, Hash: "b"}, true},
+		{"earlier update", federation.Entry{UpdatedAt: t1, Hash: "b"}, federation.Entry{UpdatedAt: t2, Hash: "a"}, false},
+		{"same update", federation.Entry{UpdatedAt: t1, Hash: "a"}, federation.Entry{UpdatedAt: t1, Hash: "a"}, false},
+		{"tie broken by hash", federation.Entry{UpdatedAt: t1, Hash: "b"}, federation.Entry{UpdatedAt: t1, Hash: "a"}, true},
+		{"deletion at the same time", federation.Entry{UpdatedAt: t1, Deleted: true}, federation.Entry{UpdatedAt: t1, Hash: "a"}, true},
+		{"update after deletion", federation.Entry{UpdatedAt: t2, Hash: "a"}, federation.Entry{UpdatedAt: t1, Deleted: true}, true},
+		{"later deletion", federation.Entry{UpdatedAt: t2, Deleted: true}, federation.Entry{UpdatedAt: t1, Deleted: true}, false},
+	}
+	for _, tt := range tests {
+		if got := federation.Newer(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: expected %v; got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAPIFederation(t *testing.T) {
+	home := testutil.NewServer(t, "FEDERATION_SECRET="+federationSecret)
+	cloud := testutil.NewServer(t, "FEDERATION_SECRET="+federationSecret)
+	homePeer := federation.NewClient(home.URL, federationSecret)
+	cloudPeer := federation.NewClient(cloud.URL, federationSecret)
+	alice := testutil.WithAPIKey(testutil.AliceKey)
+	ctx := context.Background()
+
+	var c clipboard.Clipboard
+	home.Do(t, "POST", "/clipboard", map[string]any{"name": "notes", "type": "text/plain", "data": "v1", "tags": []string{"federated"}}, alice).Expect(t, http.StatusOK).JSON(t, &c)
+	home.Do(t, "POST", "/clipboard", map[string]any{"name": "private", "type": "text/plain", "data": "x"}, alice).Expect(t, http.StatusOK)
+
+	entries, err := homePeer.Entries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].PublicId != c.PublicId {
+		t.Fatalf("expected only the federated clipboard; got %+v", entries)
+	}
+
+	rec, err := homePeer.Get(ctx, c.PublicId)
+	if err != nil || rec == nil {
+		t.Fatalf("expected the record of %s; got %v, %v", c.PublicId, rec, err)
+	}
+	push := func(peer *federation.Client, rec *federation.Record, want string) *federation.PushResponse {
+		t.Helper()
+		resp, err := peer.Push(ctx, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Result != want {
+			t.Fatalf("expected %s; got %+v", want, resp)
+		}
+		return resp
+	}
+	push(cloudPeer, rec, federation.ResultApplied)
+	push(cloudPeer, rec, federation.ResultUnchanged)
+
+	// The copy keeps the public id and owner, under an id of its own.
+	var replicated clipboard.Clipboard
+	cloud.Do(t, "GET", "/clipboard/"+c.PublicId, nil, alice).Expect(t, http.StatusOK).JSON(t, &replicated)
+	if replicated.Data != "v1" || replicated.Name != "notes" || !replicated.UpdatedAt.Equal(c.UpdatedAt) {
+		t.Errorf("unexpected replicated clipboard %+v", replicated)
+	}
+
+	// A newer local change wins and is returned to the sender.
+	cloud.Do(t, "PUT", fmt.Sprintf("/clipboard/%d", replicated.Id), map[string]any{"type": "text/plain", "data": "v2"}, alice, testutil.WithHeader("If-Match", "*")).Expect(t, http.StatusOK)
+	resp := push(cloudPeer, rec, federation.ResultKept)
+	if resp.Record == nil || string(resp.Record.Data) != "v2" {
+		t.Fatalf("expected the newer record; got %+v", resp.Record)
+	}
+	push(homePeer, resp.Record, federation.ResultApplied)
+	home.Do(t, "GET", fmt.Sprintf("/clipboard/%d", c.Id), nil, alice).Expect(t, http.StatusOK).JSON(t, &c)
+	if c.Data != "v2" {
+		t.Errorf("expected the home server to be updated in place; got %+v", c)
+	}
+
+	// Deletions travel as tombstones.
+	cloud.Do(t, "DELETE", fmt.Sprintf("/clipboard/%d", replicated.Id), nil, alice).Expect(t, http.StatusNoContent)
+	tombstone, err := cloudPeer.Get(ctx, c.PublicId)
+	if err != nil || tombstone == nil || !tombstone.Deleted {
+		t.Fatalf("expected a tombstone; got %+v, %v", tombstone, err)
+	}
+	push(homePeer, tombstone, federation.ResultApplied)
+	home.Do(t, "GET", fmt.Sprintf("/clipboard/%d", c.Id), nil, alice).Expect(t, http.StatusNotFound)
+
+	// Only records of the namespace are accepted, and only from peers.
+	untagged := &federation.Record{Record: backup.Record{PublicId: c.PublicId, Name: "x", DataType: "text/plain", UpdatedAt: time.Now()}}
+	if _, err := cloudPeer.Push(ctx, untagged); err == nil {
+		t.Error("expected a record outside of the namespace to be rejected")
+	}
+	cloud.Do(t, "GET", "/federation/records", nil).Expect(t, http.StatusUnauthorized)
+	if _, err := federation.NewClient(cloud.URL, "another-secret-of-a-peer").Entries(ctx); err == nil {
+		t.Error("expected a request signed with another secret to be rejected")
+	}
+	testutil.NewServer(t, "FEDERATION_SECRET=").Do(t, "GET", "/federation/records", nil).Expect(t, http.StatusNotFound)
+}
+
+// memoryStore is a federation.Store holding records in memory.
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]*federation.Record
+}
+
+func (m *memoryStore) Entries(context.Context) ([]federation.Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []federation.Entry
+	for _, rec := range m.records {
+		entries = append(entries, rec.Entry())
+	}
+	return entries, nil
+}
+
+func (m *memoryStore) Record(_ context.Context, publicId string) (*federation.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.records[publicId], nil
+}
+
+func (m *memoryStore) Apply(_ context.Context, rec *federation.Record) (*federation.PushResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if local := m.records[rec.PublicId]; local != nil && !federation.Newer(rec.Entry(), local.Entry()) {
+		return &federation.PushResponse{Result: federation.ResultUnchanged}, nil
+	}
+	m.records[rec.PublicId] = rec
+	return &federation.PushResponse{Result: federation.ResultApplied}, nil
+}
+
+func (m *memoryStore) Prune(_ context.Context, before time.Time) error {
+	return nil
+}
+
+func TestFederationSync(t *testing.T) {
+	cloud := testutil.NewServer(t, "FEDERATION_SECRET="+federationSecret)
+	alice := testutil.WithAPIKey(testutil.AliceKey)
+
+	var remote clipboard.Clipboard
+	cloud.Do(t, "POST", "/clipboard", map[string]any{"name": "remote", "type": "text/plain", "data": "from the cloud", "tags": []string{"federated"}}, alice).Expect(t, http.StatusOK).JSON(t, &remote)
+
+	publicId, _ := clipboard.NewPublicId()
+	local := &federation.Record{Record: backup.Record{
+		PublicId: publicId, Name: "local", DataType: "text/plain", Data: []byte("from home"),
+		Owner: "alice", Tags: []string{"federated"}, CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(),
+	}}
+	store := &memoryStore{records: map[string]*federation.Record{publicId: local}}
+
+	cfg := federation.Config{Secret: federationSecret, Namespace: "federated", Peers: []string{cloud.URL}, SyncInterval: time.Minute}
+	federation.NewSyncer(cfg, store).Sync(context.Background())
+
+	if rec := store.records[remote.PublicId]; rec == nil || string(rec.Data) != "from the cloud" {
+		t.Errorf("expected the clipboard of the peer to be fetched; got %+v", rec)
+	}
+	var pushed clipboard.Clipboard
+	cloud.Do(t, "GET", "/clipboard/"+publicId, nil, alice).Expect(t, http.StatusOK).JSON(t, &pushed)
+	if pushed.Data != "from home" || pushed.Name != "local" {
+		t.Errorf("expected the local clipboard to be pushed; got %+v", pushed)
+	}
+}
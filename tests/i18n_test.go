@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/i18n"
+)
+
+func TestI18nNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-CH, fr;q=0.5", "de"},
+		{"FR-ca", "fr"},
+		{"ja, es;q=0.8, en;q=0.9", "en"},
+		{"ja, es;q=0.8", "es"},
+		{"de;q=0, fr;q=0.1", "fr"},
+		{"de;q=x, es", "es"},
+		{"*", "en"},
+	}
+	for _, tt := range tests {
+		if got := i18n.Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q; want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestI18nTranslate(t *testing.T) {
+	tests := []struct {
+		lang    string
+		message string
+		want    string
+	}{
+		{"de", "clipboard not found", "Zwischenablage nicht gefunden"},
+		{"fr", "clipboard not found", "presse-papiers introuvable"},
+		{"en", "clipboard not found", "clipboard not found"},
+		{"ja", "clipboard not found", "clipboard not found"},
+		{"de", "no such message", "no such message"},
+		{"de", "clipboard was modified, current version is 7", "Zwischenablage wurde geändert, aktuelle Version ist 7"},
+		{"de", "user bob does not belong to namespace team", "Benutzer bob gehört nicht zum Namensraum team"},
+		{"es", "data type not allowed: image/png", "tipo de datos no permitido: image/png"},
+	}
+	for _, tt := range tests {
+		if got := i18n.Translate(tt.lang, tt.message); got != tt.want {
+			t.Errorf("Translate(%q, %q) = %q; want %q", tt.lang, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestI18nLanguages(t *testing.T) {
+	langs := i18n.Languages()
+	if len(langs) < 2 || langs[0] != i18n.Default {
+		t.Fatalf("unexpected languages %v", langs)
+	}
+	for _, lang := range langs[1:] {
+		if got := i18n.Translate(lang, "internal database error"); got == "internal database error" {
+			t.Errorf("expected %s to translate common messages", lang)
+		}
+	}
+}
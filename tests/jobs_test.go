@@ -0,0 +1,309 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/backup"
+	"github.com/copybridge/copybridge-server/internal/jobs"
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+func TestSchedule(t *testing.T) {
+	from := time.Date(2024, time.March, 15, 10, 20, 30, 0, time.Local) // a Friday
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"@every 90s", from.Add(90 * time.Second)},
+		{"* * * * *", time.Date(2024, time.March, 15, 10, 21, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2024, time.March, 15, 10, 30, 0, 0, time.Local)},
+		{"@hourly", time.Date(2024, time.March, 15, 11, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.Local)},
+		{"30 3 * * 1-5", time.Date(2024, time.March, 18, 3, 30, 0, 0, time.Local)},
+		{"0 12 1,15 * *", time.Date(2024, time.March, 15, 12, 0, 0, 0, time.Local)},
+		{"0 9 1,15 * *", time.Date(2024, time.April, 1, 9, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2024, time.March, 17, 0, 0, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		s, err := jobs.ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Errorf("%s: %v", tt.schedule, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v; got %v", tt.schedule, tt.want, got)
+		}
+	}
+
+	for _, invalid := range []string{"", "@every", "@every -1m", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := jobs.ParseSchedule(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+	if s, _ := jobs.ParseSchedule("0 0 30 2 *"); !s.Next(from).IsZero() {
+		t.Errorf("expected a schedule that never matches to have no next run")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := jobs.NewScheduler()
+
+	var runs atomic.Int32
+	s.Add("count", jobs.Every(10*time.Millisecond), func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+	s.Add("fail", jobs.Every(time.Hour), func(ctx context.Context) error {
+		return errors.New("broken")
+	})
+	stopped := make(chan struct{})
+	s.Add("block", jobs.Every(time.Hour), func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+	s.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if runs.Load() < 3 {
+		t.Fatalf("expected the job to run repeatedly; ran %d times", runs.Load())
+	}
+
+	stats := s.Stats()
+	if len(stats) != 3 || stats[0].Name != "block" || stats[1].Name != "count" || stats[2].Name != "fail" {
+		t.Fatalf("expected the stats of all jobs by name; got %+v", stats)
+	}
+	if !stats[0].Running || s.Trigger("block") {
+		t.Errorf("expected a running job not to run twice")
+	}
+	if fail := stats[2]; fail.Runs != 1 || fail.Failures != 1 || fail.LastError != "broken" || fail.Schedule != "@every 1h0m0s" {
+		t.Errorf("unexpected stats of a failed job %+v", fail)
+	}
+	if s.Trigger("missing") {
+		t.Errorf("expected an unknown job not to run")
+	}
+	if !s.Trigger("fail") || s.Stats()[2].Runs != 2 {
+		t.Errorf("expected a triggered job to run right away")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("error stopping: %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("expected Stop to wait for running jobs")
+	}
+	n := runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	if runs.Load() != n {
+		t.Errorf("expected no runs after Stop")
+	}
+}
+
+func TestAPIJobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, old := range []string{"copybridge-20200101-000000.json", "copybridge-20200102-000000.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, old), []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := testutil.NewServer(t, "ADMIN_TOKEN=admin-token", "BACKUP_DIR="+dir, "BACKUP_KEEP=2")
+	admin := testutil.WithHeader("X-Admin-Token", "admin-token")
+
+	s.Do(t, "POST", "/clipboard", map[string]any{"name": "notes", "type": "text/plain", "data": "backed up"}, testutil.WithAPIKey(testutil.AliceKey)).Expect(t, http.StatusOK)
+
+	var list []jobs.Stats
+	s.Do(t, "GET", "/admin/jobs", nil, admin).Expect(t, http.StatusOK).JSON(t, &list)
+	var names []string
+	for _, st := range list {
+		names = append(names, st.Name)
+	}
+	if strings.Join(names, ",") != "backup,cleanup,stats" {
+		t.Errorf("unexpected jobs %v", names)
+	}
+
+	var st jobs.Stats
+	s.Do(t, "POST", "/admin/jobs/backup/run", nil, admin).Expect(t, http.StatusOK).JSON(t, &st)
+	if st.Runs != 1 || st.Failures != 0 {
+		t.Fatalf("expected a successful run; got %+v", st)
+	}
+	s.Do(t, "POST", "/admin/jobs/missing/run", nil, admin).Expect(t, http.StatusNotFound)
+	s.Do(t, "GET", "/admin/jobs", nil, testutil.WithAPIKey(testutil.BobKey)).Expect(t, http.StatusForbidden)
+
+	// The new snapshot and the most recent old one are kept.
+	matches, _ := filepath.Glob(filepath.Join(dir, "copybridge-*"))
+	if len(matches) != 2 || filepath.Base(matches[0]) != "copybridge-20200102-000000.json" {
+		t.Fatalf("expected two snapshots; got %v", matches)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("expected other files to be left alone: %v", err)
+	}
+
+	f, err := os.Open(matches[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var data []string
+	err = backup.Read(f, backup.FormatJSON, func(rec *backup.Record) error {
+		data = append(data, string(rec.Data))
+		return nil
+	})
+	if err != nil || len(data) != 1 || data[0] != "backed up" {
+		t.Errorf("expected the snapshot to hold the clipboard; got %v, %v", data, err)
+	}
+}
+
+func TestAPIDatabaseBackup(t *testing.T) {
+	var mu sync.Mutex
+	uploads := make(map[string]int)
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		uploads[r.Method+" "+r.URL.Path] = len(body)
+		mu.Unlock()
+	}))
+	defer bucket.Close()
+
+	dir := t.TempDir()
+	for _, old := range []string{"copybridge-20200101-000000.db", "copybridge-20200101-000000.json"} {
+		if err := os.WriteFile(filepath.Join(dir, old), []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := testutil.NewServer(t, "ADMIN_TOKEN=admin-token", "DB_BACKUP_DIR="+dir, "DB_BACKUP_KEEP=1",
+		"S3_BUCKET=snapshots", "S3_ENDPOINT="+bucket.URL, "DB_BACKUP_S3_PREFIX=db/")
+	admin := testutil.WithHeader("X-Admin-Token", "admin-token")
+
+	s.Do(t, "POST", "/clipboard", map[string]any{"name": "notes", "type": "text/plain", "data": "backed up"}, testutil.WithAPIKey(testutil.AliceKey)).Expect(t, http.StatusOK)
+
+	var b struct {
+		File  string `json:"file"`
+		Size  int64  `json:"size"`
+		S3Key string `json:"s3_key"`
+	}
+	s.Do(t, "POST", "/admin/backup", nil, admin).Expect(t, http.StatusCreated).JSON(t, &b)
+	if !strings.HasPrefix(b.File, "copybridge-") || !strings.HasSuffix(b.File, ".db") || b.Size == 0 || b.S3Key != "db/"+b.File {
+		t.Fatalf("unexpected backup %+v", b)
+	}
+	if n := uploads["PUT /snapshots/db/"+b.File]; int64(n) != b.Size {
+		t.Errorf("expected the snapshot to be uploaded; got %v", uploads)
+	}
+
+	// Only the new snapshot is kept, and archives are left alone.
+	matches, _ := filepath.Glob(filepath.Join(dir, "copybridge-*"))
+	if len(matches) != 2 || filepath.Base(matches[0]) != "copybridge-20200101-000000.json" || filepath.Base(matches[1]) != b.File {
+		t.Fatalf("unexpected files %v", matches)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, b.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data string
+	if err := db.QueryRow(`SELECT data FROM clipboards WHERE name = 'notes'`).Scan(&data); err != nil || data != "backed up" {
+		t.Errorf("expected the snapshot to hold the clipboard; got %q, %v", data, err)
+	}
+
+	testutil.NewServer(t, "DB_BACKUP_DIR=").Do(t, "POST", "/admin/backup", nil, admin).Expect(t, http.StatusNotFound)
+}
+
+// fakeElector leads while leading is set.
+type fakeElector struct{ leading atomic.Bool }
+
+func (e *fakeElector) Leading() bool { return e.leading.Load() }
+
+func TestSchedulerExclusive(t *testing.T) {
+	s := jobs.NewScheduler()
+	s.Add("local", jobs.Every(time.Hour), func(ctx context.Context) error { return nil })
+	s.AddExclusive("shared", jobs.Every(time.Hour), func(ctx context.Context) error { return nil })
+
+	if !s.Trigger("shared") {
+		t.Errorf("expected exclusive jobs to run without an elector")
+	}
+
+	var e fakeElector
+	s.Elect(&e)
+	if s.Trigger("shared") || !s.Trigger("local") {
+		t.Errorf("expected only exclusive jobs to wait for the lock")
+	}
+	if stats := s.Stats(); stats[0].Standby || !stats[1].Exclusive || !stats[1].Standby || stats[1].Runs != 1 {
+		t.Errorf("expected the exclusive job on standby; got %+v", stats)
+	}
+
+	e.leading.Store(true)
+	if !s.Trigger("shared") || s.Stats()[1].Standby {
+		t.Errorf("expected the lock holder to run exclusive jobs")
+	}
+}
+
+func TestLeases(t *testing.T) {
+	s := testutil.NewServer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	acquire := func(holder string, at time.Time) bool {
+		t.Helper()
+		ok, err := s.DB.AcquireLease(ctx, "jobs", holder, at, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if !acquire("a", now) || !acquire("a", now.Add(30*time.Second)) {
+		t.Fatalf("expected the lease to be taken and renewed")
+	}
+	if acquire("b", now.Add(time.Minute)) {
+		t.Fatalf("expected a renewed lease to be kept")
+	}
+	if !acquire("b", now.Add(2*time.Minute)) || acquire("a", now.Add(2*time.Minute)) {
+		t.Fatalf("expected an expired lease to be taken over")
+	}
+
+	if err := s.DB.ReleaseLease(ctx, "jobs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if acquire("a", now.Add(2*time.Minute)) {
+		t.Fatalf("expected releasing the lease of another holder to do nothing")
+	}
+	if err := s.DB.ReleaseLease(ctx, "jobs", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if !acquire("a", now.Add(2*time.Minute)) {
+		t.Errorf("expected a released lease to be free")
+	}
+}
+
+func TestAPIJobsLock(t *testing.T) {
+	s := testutil.NewServer(t, "ADMIN_TOKEN=admin-token", "JOB_LOCK_ENABLED=true")
+	admin := testutil.WithHeader("X-Admin-Token", "admin-token")
+
+	// Test servers never take the lease, so they stand by.
+	var list []jobs.Stats
+	s.Do(t, "GET", "/admin/jobs", nil, admin).Expect(t, http.StatusOK).JSON(t, &list)
+	if len(list) != 2 || list[0].Name != "cleanup" || !list[0].Standby || list[1].Name != "stats" || list[1].Standby {
+		t.Fatalf("expected cleanup to stand by; got %+v", list)
+	}
+	s.Do(t, "POST", "/admin/jobs/cleanup/run", nil, admin).Expect(t, http.StatusConflict)
+	s.Do(t, "POST", "/admin/jobs/stats/run", nil, admin).Expect(t, http.StatusOK)
+}
@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/lockout"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	tr := lockout.New(3, time.Second, 5*time.Second)
+
+	for i := 0; i < 2; i++ {
+		if wait := tr.Fail("ip"); wait != 0 {
+			t.Fatalf("expected no lockout before threshold; got %v", wait)
+		}
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for _, e := range expected {
+		if wait := tr.Fail("ip"); wait != e {
+			t.Errorf("expected lockout of %v; got %v", e, wait)
+		}
+	}
+	if tr.Locked("ip") == 0 {
+		t.Errorf("expected key to be locked")
+	}
+	if tr.Locked("other") != 0 {
+		t.Errorf("expected unrelated key not to be locked")
+	}
+
+	tr.Succeed("ip")
+	if tr.Locked("ip") != 0 {
+		t.Errorf("expected key to be unlocked after success")
+	}
+}
@@ -0,0 +1,364 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/masterkey"
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+// ageIdentity is the age X25519 identity of the secret key of 32 0x42 bytes.
+const ageIdentity = "AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX"
+
+// ageEncrypt encrypts plaintext to the public key of a secret key as the
+// age format specifies.
+func ageEncrypt(t *testing.T, secret, plaintext []byte) []byte {
+	t.Helper()
+	derive := func(secret, salt []byte, label string) []byte {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(label)), key); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := rand.Read(b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	recipient, _ := curve25519.X25519(secret, curve25519.Basepoint)
+	ephemeral := random(32)
+	share, _ := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	shared, _ := curve25519.X25519(ephemeral, recipient)
+	fileKey := random(16)
+	wrap, _ := chacha20poly1305.New(derive(shared, append(append([]byte{}, share...), recipient...), "age-encryption.org/v1/X25519"))
+	body := base64.RawStdEncoding.EncodeToString(wrap.Seal(nil, make([]byte, 12), fileKey, nil))
+
+	header := "age-encryption.org/v1\n-> X25519 " + base64.RawStdEncoding.EncodeToString(share) + "\n" + body + "\n---"
+	mac := hmac.New(sha256.New, derive(fileKey, nil, "header"))
+	mac.Write([]byte(header))
+
+	var out bytes.Buffer
+	out.WriteString(header + " " + base64.RawStdEncoding.EncodeToString(mac.Sum(nil)) + "\n")
+	nonce := random(16)
+	out.Write(nonce)
+	stream, _ := chacha20poly1305.New(derive(fileKey, nonce, "payload"))
+	for counter := 0; ; counter++ {
+		chunk := plaintext[:min(len(plaintext), 64<<10)]
+		plaintext = plaintext[len(chunk):]
+		chunkNonce := make([]byte, 12)
+		chunkNonce[10] = byte(counter)
+		if len(plaintext) == 0 {
+			chunkNonce[11] = 1
+		}
+		out.Write(stream.Seal(nil, chunkNonce, chunk, nil))
+		if len(plaintext) == 0 {
+			return out.Bytes()
+		}
+	}
+}
+
+// writeFile writes a file into the temporary directory of the test.
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyringRotation(t *testing.T) {
+	k1 := "k1:" + base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	k2 := "k2:" + base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+
+	old, err := masterkey.Parse(k1)
+	if err != nil {
+		t.Fatalf("error parsing keys: %v", err)
+	}
+	sealed, err := old.Seal("hello")
+	if err != nil {
+		t.Fatalf("error sealing: %v", err)
+	}
+	if !strings.HasPrefix(sealed, "k1.") || strings.Contains(sealed, "hello") {
+		t.Errorf("unexpected sealed value %q", sealed)
+	}
+
+	rotated, err := masterkey.Parse(k2 + "," + k1)
+	if err != nil {
+		t.Fatalf("error parsing keys: %v", err)
+	}
+	if rotated.Current() != "k2" {
+		t.Errorf("expected first key to be current; got %q", rotated.Current())
+	}
+	if value, err := rotated.Open(sealed); err != nil || value != "hello" {
+		t.Errorf("expected value sealed with old key to open; got %q, %v", value, err)
+	}
+
+	retired, _ := masterkey.Parse(k2)
+	if _, err := retired.Open(sealed); err == nil {
+		t.Errorf("expected value sealed with a removed key not to open")
+	}
+}
+
+func TestKeyringParse(t *testing.T) {
+	if k, err := masterkey.Parse(""); k != nil || err != nil {
+		t.Errorf("expected no keyring without keys; got %v, %v", k, err)
+	}
+
+	for _, s := range []string{"k1", "k1:short", "bad_id:" + base64.StdEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := masterkey.Parse(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	k1 := "k1:" + base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	k2 := "k2:" + base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+
+	old, _ := masterkey.Parse(k1)
+	sealed, _ := old.Seal("hello")
+
+	rotated, _ := masterkey.Parse(k2 + "," + k1)
+	rewrapped, err := rotated.Rewrap(sealed)
+	if err != nil {
+		t.Fatalf("error rewrapping: %v", err)
+	}
+	parts := strings.Split(rewrapped, ".")
+	if parts[0] != "k2" || parts[2] != strings.Split(sealed, ".")[2] {
+		t.Errorf("expected only the data key to be rewrapped; got %q from %q", rewrapped, sealed)
+	}
+	if again, _ := rotated.Rewrap(rewrapped); again != rewrapped {
+		t.Errorf("expected values sealed with the current key to be kept")
+	}
+	retired, _ := masterkey.Parse(k2)
+	if value, err := retired.Open(rewrapped); err != nil || value != "hello" {
+		t.Errorf("expected rewrapped value to open without the old key; got %q, %v", value, err)
+	}
+
+	if !old.Replace(rotated) || old.Current() != "k2" || old.Replace(retired) {
+		t.Errorf("expected Replace to report changes of the current key")
+	}
+}
+
+func TestVaultSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/copybridge":
+			_, _ = w.Write([]byte(`{"data":{"data":{"master_keys":"k1:a"},"metadata":{"version":3}}}`))
+		case "/v1/kv/copybridge":
+			_, _ = w.Write([]byte(`{"data":{"keys":"k1:b"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	v := &masterkey.Vault{Addr: ts.URL, Token: "s.token", Path: "secret/data/copybridge", Field: "master_keys"}
+	if keys, err := v.Fetch(ctx); err != nil || keys != "k1:a" {
+		t.Errorf("expected keys of a KV version 2 secret; got %q, %v", keys, err)
+	}
+	v = &masterkey.Vault{Addr: ts.URL, Token: "s.token", Path: "kv/copybridge", Field: "keys"}
+	if keys, err := v.Fetch(ctx); err != nil || keys != "k1:b" {
+		t.Errorf("expected keys of a KV version 1 secret; got %q, %v", keys, err)
+	}
+
+	v.Field = "missing"
+	if _, err := v.Fetch(ctx); err == nil {
+		t.Errorf("expected a missing field to fail")
+	}
+	v.Token = "wrong"
+	if _, err := v.Fetch(ctx); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the status of Vault in the error; got %v", err)
+	}
+}
+
+func TestKMSSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" || !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20240301/eu-west-1/kms/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=") {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			CiphertextBlob []byte
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || string(req.CiphertextBlob) != "\x01ciphertext" {
+			http.Error(w, "unexpected ciphertext", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"KeyId":"arn:aws:kms:eu-west-1:1:key/x","Plaintext":"` + base64.StdEncoding.EncodeToString([]byte("k1:c")) + `"}`))
+	}))
+	defer ts.Close()
+
+	k := &masterkey.KMS{
+		Endpoint:        ts.URL,
+		Region:          "eu-west-1",
+		AccessKeyId:     "AKID",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		File:            writeFile(t, "keys.enc", []byte("\x01ciphertext")),
+		Clock:           func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) },
+	}
+	if keys, err := k.Fetch(context.Background()); err != nil || keys != "k1:c" {
+		t.Errorf("expected decrypted keys; got %q, %v", keys, err)
+	}
+}
+
+func TestAgeFileSource(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	identity := writeFile(t, "identity.txt", []byte("# created: 2024-03-01\n"+ageIdentity+"\n"))
+
+	large := strings.Repeat("k", 100<<10)
+	encrypted := ageEncrypt(t, secret, []byte(large))
+	a := &masterkey.AgeFile{File: writeFile(t, "keys.age", encrypted), Identity: identity}
+	if keys, err := a.Fetch(context.Background()); err != nil || keys != large {
+		t.Fatalf("expected decrypted keys; got %d bytes, %v", len(keys), err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(ageEncrypt(t, secret, []byte("k1:d")))
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN AGE ENCRYPTED FILE-----\n")
+	for len(encoded) > 64 {
+		armored.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	armored.WriteString(encoded + "\n-----END AGE ENCRYPTED FILE-----\n")
+	a.File = writeFile(t, "keys.age.asc", []byte(armored.String()))
+	if keys, err := a.Fetch(context.Background()); err != nil || keys != "k1:d" {
+		t.Errorf("expected decrypted keys of an armored file; got %q, %v", keys, err)
+	}
+
+	other := ageEncrypt(t, bytes.Repeat([]byte{0x43}, 32), []byte("k1:e"))
+	a.File = writeFile(t, "other.age", other)
+	if _, err := a.Fetch(context.Background()); err == nil {
+		t.Errorf("expected a file for another recipient not to decrypt")
+	}
+	encrypted[len(encrypted)-1] ^= 1
+	a.File = writeFile(t, "tampered.age", encrypted)
+	if _, err := a.Fetch(context.Background()); err == nil {
+		t.Errorf("expected a tampered file not to decrypt")
+	}
+}
+
+func TestAgeFileMalformed(t *testing.T) {
+	identity := writeFile(t, "identity.txt", []byte(ageIdentity))
+	encrypted := ageEncrypt(t, bytes.Repeat([]byte{0x42}, 32), []byte("k1:d"))
+	header, _, _ := bytes.Cut(encrypted, []byte("\n---"))
+
+	files := map[string][]byte{
+		"no stanza body":      []byte("age-encryption.org/v1\n-> X25519\n"),
+		"no recipient args":   []byte("age-encryption.org/v1\n-> \n\n--- AAAA\n"),
+		"short share":         []byte("age-encryption.org/v1\n-> X25519 AAAA\nAAAA\n--- " + strings.Repeat("A", 43) + "\n"),
+		"bad MAC":             append(append([]byte{}, header...), "\n--- not base64\n"...),
+		"bad armor":           []byte("-----BEGIN AGE ENCRYPTED FILE-----\n!!!\n-----END AGE ENCRYPTED FILE-----\n"),
+		"header only":         header,
+		"payload too short":   encrypted[:len(encrypted)-len("k1:d")-32],
+		"oversized body line": []byte("age-encryption.org/v1\n-> X25519 AAAA\n" + strings.Repeat("A", 65) + "\n"),
+	}
+	for name, data := range files {
+		a := &masterkey.AgeFile{File: writeFile(t, "malformed.age", data), Identity: identity}
+		if _, err := a.Fetch(context.Background()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Every truncation of a valid file fails without panicking.
+	for i := range encrypted {
+		a := &masterkey.AgeFile{File: writeFile(t, "truncated.age", encrypted[:i]), Identity: identity}
+		if _, err := a.Fetch(context.Background()); err == nil {
+			t.Errorf("expected a file truncated to %d bytes not to decrypt", i)
+		}
+	}
+
+	// So do malformed and truncated identity files.
+	keys := writeFile(t, "keys.age", encrypted)
+	identities := map[string]string{
+		"empty":        "# created: 2024-03-01\n",
+		"truncated":    ageIdentity[:len(ageIdentity)-6],
+		"bad checksum": ageIdentity[:len(ageIdentity)-1] + "Q",
+		"public key":   "age1qyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqs3290gq",
+		"garbage":      "not a key",
+	}
+	for name, data := range identities {
+		a := &masterkey.AgeFile{File: keys, Identity: writeFile(t, "identity.txt", []byte(data))}
+		if _, err := a.Fetch(context.Background()); err == nil {
+			t.Errorf("%s identity: expected an error", name)
+		}
+	}
+	for i := range ageIdentity {
+		a := &masterkey.AgeFile{File: keys, Identity: writeFile(t, "identity.txt", []byte(ageIdentity[:i]))}
+		if _, err := a.Fetch(context.Background()); err == nil {
+			t.Errorf("expected an identity truncated to %d characters to be rejected", i)
+		}
+	}
+}
+
+func TestAPIMasterKeysFromAgeFile(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	k1 := "k1:" + base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	k2 := "k2:" + base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+	keys := writeFile(t, "keys.age", ageEncrypt(t, secret, []byte(k1)))
+	s := testutil.NewServer(t, "MASTER_KEYS_AGE_FILE="+keys, "MASTER_KEYS_AGE_IDENTITY="+writeFile(t, "identity.txt", []byte(ageIdentity)))
+
+	var c clipboard.Clipboard
+	s.Do(t, "POST", "/clipboard", map[string]any{"name": "a", "type": "text/plain", "data": "sealed"}).Expect(t, http.StatusOK).JSON(t, &c)
+
+	// Rotating the key in the file reseals the data on refresh, after which
+	// the old key can be dropped.
+	refresh := func(keyList string) {
+		t.Helper()
+		if err := os.WriteFile(keys, ageEncrypt(t, secret, []byte(keyList)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DB.RefreshMasterKeys(context.Background()); err != nil {
+			t.Fatalf("error refreshing master keys: %v", err)
+		}
+	}
+	refresh(k2 + "," + k1)
+	s.Do(t, "GET", "/clipboard/"+c.PublicId, nil).Expect(t, http.StatusOK)
+	refresh(k2)
+	var status int
+	for deadline := time.Now().Add(5 * time.Second); status != http.StatusOK && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		status = s.Do(t, "GET", "/clipboard/"+c.PublicId, nil).StatusCode
+	}
+	if status != http.StatusOK {
+		t.Errorf("expected the clipboard to be resealed with the new key; got %d", status)
+	}
+}
+
+func TestMasterKeysSourceExclusive(t *testing.T) {
+	t.Setenv("MASTER_KEYS_VAULT_PATH", "secret/data/copybridge")
+	t.Setenv("MASTER_KEYS_KMS_FILE", "keys.enc")
+	if _, err := masterkey.SourceFromEnv(); err == nil {
+		t.Errorf("expected two sources to be rejected")
+	}
+}
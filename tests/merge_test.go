@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/merge"
+)
+
+func TestMergeThreeWay(t *testing.T) {
+	base := "one\ntwo\nthree\nfour\nfive\n"
+
+	tests := []struct {
+		name         string
+		ours, theirs string
+		want         string
+		ok           bool
+	}{
+		{"unchanged", base, base, base, true},
+		{"only ours", "one\n2\nthree\nfour\nfive\n", base, "one\n2\nthree\nfour\nfive\n", true},
+		{"only theirs", base, "one\ntwo\nthree\nfour\n5\n", "one\ntwo\nthree\nfour\n5\n", true},
+		{"same change", "one\n2\nthree\nfour\nfive\n", "one\n2\nthree\nfour\nfive\n", "one\n2\nthree\nfour\nfive\n", true},
+		{"separate lines", "ONE\ntwo\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\nFIVE\n", "ONE\ntwo\nthree\nfour\nFIVE\n", true},
+		{"insertions", "zero\none\ntwo\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\nfive\nsix\n", "zero\none\ntwo\nthree\nfour\nfive\nsix\n", true},
+		{"deletion and change", "one\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\n5\n", "one\nthree\nfour\n5\n", true},
+		{"same line", "one\n2\nthree\nfour\nfive\n", "one\nzwei\nthree\nfour\nfive\n", "", false},
+		{"adjacent lines", "one\n2\nthree\nfour\nfive\n", "one\ntwo\n3\nfour\nfive\n", "", false},
+		{"insertions at the same place", "one\ntwo\nthree\nfour\nfive\nsix\n", "one\ntwo\nthree\nfour\nfive\nseven\n", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := merge.ThreeWay(base, tt.ours, tt.theirs)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("ThreeWay() = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMergeThreeWayLarge(t *testing.T) {
+	base := strings.Repeat("line\n", 5000)
+	if _, ok := merge.ThreeWay(base, base+"ours\n", "theirs\n"+base); ok {
+		t.Error("expected texts too large to compare to conflict")
+	}
+}
+
+func TestMergeDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		ops      int
+	}{
+		{"unchanged", "one\ntwo\n", "one\ntwo\n", 0},
+		{"empty", "", "", 0},
+		{"from empty", "", "one\ntwo\n", 1},
+		{"to empty", "one\ntwo\n", "", 1},
+		{"changed word", "one\ntwo\nthree\n", "one\nzwei\nthree\n", 3},
+		{"appended", "one\ntwo\n", "one\ntwo\nthree\n", 2},
+		{"inserted and deleted", "zero\none\ntwo\nthree\n", "one\ntwo\n2.5\nthree\n", 3},
+		{"single line", `{"a":1,"b":2}`, `{"a":1,"b":3}`, 3},
+		{"unicode", "grüße\nwelt\n", "grüße\nwörld\n", 3},
+		{"without final newline", "one\ntwo", "one\ntwo\nthree", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := merge.Delta(tt.from, tt.to)
+			if len(ops) != tt.ops {
+				t.Errorf("Delta() = %+v; want %d ops", ops, tt.ops)
+			}
+			if got, err := merge.Apply(tt.from, ops); err != nil || got != tt.to {
+				t.Errorf("Apply(Delta()) = %q, %v; want %q", got, err, tt.to)
+			}
+		})
+	}
+}
+
+func TestMergeDeltaLarge(t *testing.T) {
+	from := strings.Repeat("line\n", 5000)
+	to := "first\n" + strings.Repeat("line\n", 2500) + "middle\n" + strings.Repeat("line\n", 2500)
+	if got, err := merge.Apply(from, merge.Delta(from, to)); err != nil || got != to {
+		t.Errorf("expected texts too large to compare to be replaced; got %v", err)
+	}
+}
+
+func TestMergeApply(t *testing.T) {
+	ops := []merge.Op{{Retain: 2}, {Delete: 1}, {Insert: "ß"}, {Retain: 1}, {Insert: "!"}}
+	if got, err := merge.Apply("abcde", ops); err != nil || got != "abßd!e" {
+		t.Errorf("Apply() = %q, %v", got, err)
+	}
+
+	for _, ops := range [][]merge.Op{
+		{{Retain: 6}},
+		{{Retain: 2}, {Delete: 4}},
+		{{}},
+		{{Retain: 1, Insert: "x"}},
+		{{Delete: -1}},
+	} {
+		if _, err := merge.Apply("abcde", ops); err == nil {
+			t.Errorf("expected %+v to be invalid", ops)
+		}
+	}
+}
+
+func TestMergeUnified(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	from := strings.Join(lines, "")
+	to := "line 0\n" + strings.Join(lines[:9], "") + "ten\n" + strings.Join(lines[10:19], "") + "line 20"
+
+	expected := `--- a
++++ b
+@@ -1,3 +1,4 @@
++line 0
+ line 1
+ line 2
+ line 3
+@@ -7,7 +8,7 @@
+ line 7
+ line 8
+ line 9
+-line 10
++ten
+ line 11
+ line 12
+ line 13
+@@ -17,4 +18,4 @@
+ line 17
+ line 18
+ line 19
+-line 20
++line 20
+\ No newline at end of file
+`
+	if got := merge.Unified(from, to, "a", "b"); got != expected {
+		t.Errorf("Unified() =\n%s\nwant\n%s", got, expected)
+	}
+
+	if got := merge.Unified(from, from, "a", "b"); got != "" {
+		t.Errorf("expected no diff of equal texts; got %q", got)
+	}
+	if got := merge.Unified("", "one\n", "a", "b"); got != "--- a\n+++ b\n@@ -0,0 +1 @@\n+one\n" {
+		t.Errorf("unexpected diff from an empty text %q", got)
+	}
+	// Nearby changes share their context.
+	if got := merge.Unified("1\n2\n3\n4\n5\n", "x\n2\n3\n4\ny\n", "a", "b"); strings.Count(got, "@@") != 2 {
+		t.Errorf("expected a single hunk; got\n%s", got)
+	}
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/metrics"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	c := metrics.NewCounter("test_format_total", "Things\ncounted.", "kind")
+	c.Inc(`say "hi"`)
+	c.Add(2.5, "a")
+	h := metrics.NewHistogram("test_format_seconds", "Durations.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var b strings.Builder
+	if err := metrics.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	expected := `# HELP test_format_seconds Durations.
+# TYPE test_format_seconds histogram
+test_format_seconds_bucket{le="0.1"} 1
+test_format_seconds_bucket{le="1"} 2
+test_format_seconds_bucket{le="+Inf"} 3
+test_format_seconds_sum 3.55
+test_format_seconds_count 3
+# HELP test_format_total Things\ncounted.
+# TYPE test_format_total counter
+test_format_total{kind="a"} 2.5
+test_format_total{kind="say \"hi\""} 1
+`
+	if !strings.Contains(out, expected) {
+		t.Fatalf("expected\n%s\nin\n%s", expected, out)
+	}
+	if c.Value("a") != 2.5 || h.Count() != 3 {
+		t.Errorf("unexpected values %v and %v", c.Value("a"), h.Count())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a metric twice to panic")
+		}
+	}()
+	metrics.NewCounter("test_format_total", "Again.")
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/migrate"
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+// openSQLite opens a SQLite database with the plain driver, as the
+// migration tool does.
+func openSQLite(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	source := "file:" + filepath.Join(dir, "source.db")
+	target := "file:" + filepath.Join(dir, "target.db")
+	alice := testutil.WithAPIKey(testutil.AliceKey)
+	password := testutil.WithPassword("correct horse")
+
+	s := testutil.NewServerAt(t, source)
+	var plain, sealed clipboard.Clipboard
+	s.Do(t, "POST", "/clipboard", map[string]any{"name": "notes", "type": "text/plain", "data": "hello\tworld\n"}, alice).Expect(t, http.StatusOK).JSON(t, &plain)
+	s.Do(t, "POST", "/clipboard", map[string]any{"name": "secret", "type": "text/plain", "data": "s3cr3t", "is_encrypted": true}, alice, password).Expect(t, http.StatusOK).JSON(t, &sealed)
+
+	src := openSQLite(t, migrate.ReadOnlyDSN(source))
+	dst := openSQLite(t, target)
+	tables, err := migrate.Copy(context.Background(), src, dst, migrate.SQLite, false)
+	if err != nil {
+		t.Fatalf("cannot copy the database: %v", err)
+	}
+	rows := make(map[string]int64)
+	for _, table := range tables {
+		rows[table.Name] = table.Rows
+	}
+	if rows["clipboards"] != 2 || rows["schema_migrations"] == 0 {
+		t.Errorf("expected the clipboards and the schema to be copied; got %v", rows)
+	}
+
+	// Existing tables are only overwritten on request.
+	if _, err := migrate.Copy(context.Background(), src, dst, migrate.SQLite, false); err == nil {
+		t.Errorf("expected existing tables to be refused")
+	}
+	if _, err := migrate.Copy(context.Background(), src, dst, migrate.SQLite, true); err != nil {
+		t.Fatalf("cannot replace the copy: %v", err)
+	}
+
+	// A server on the copy serves the same clipboards, decrypts them with
+	// their password and keeps numbering new ones after them.
+	copied := testutil.NewServerAt(t, target)
+	var got clipboard.Clipboard
+	copied.Do(t, "GET", fmt.Sprintf("/clipboard/%d", plain.Id), nil, alice).Expect(t, http.StatusOK).JSON(t, &got)
+	if got.Data != "hello\tworld\n" || got.Version != plain.Version {
+		t.Errorf("expected the copied clipboard; got %+v", got)
+	}
+	copied.Do(t, "GET", fmt.Sprintf("/clipboard/%d", sealed.Id), nil, alice).Expect(t, http.StatusUnauthorized)
+	copied.Do(t, "GET", fmt.Sprintf("/clipboard/%d", sealed.Id), nil, alice, password).Expect(t, http.StatusOK).JSON(t, &got)
+	if got.Data != "s3cr3t" {
+		t.Errorf("expected the encrypted clipboard to decrypt; got %+v", got)
+	}
+
+	var created clipboard.Clipboard
+	copied.Do(t, "POST", "/clipboard", map[string]any{"name": "new", "type": "text/plain", "data": "x"}, alice).Expect(t, http.StatusOK).JSON(t, &created)
+	if created.Id <= sealed.Id {
+		t.Errorf("expected new ids after the copied ones; got %d", created.Id)
+	}
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/events"
+	"github.com/copybridge/copybridge-server/internal/notify"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestNewMessage(t *testing.T) {
+	e := events.Event{Type: events.ClipboardUpdated, ClipboardId: 7, Name: "otp", DataType: "text/plain", Data: " 123456\n"}
+
+	m := notify.NewMessage(e, true)
+	if m.Title != "Clipboard otp updated" || m.Body != "123456" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m := notify.NewMessage(e, false); strings.Contains(m.Body, "123456") {
+		t.Errorf("expected data to be left out; got %+v", m)
+	}
+
+	image := events.Event{Type: events.ItemPushed, ClipboardId: 7, DataType: "image/png", Data: "iVBORw0KGgo="}
+	if m := notify.NewMessage(image, true); m.Title != "New item on clipboard #7" || strings.Contains(m.Body, "iVBOR") {
+		t.Errorf("expected a message without binary data; got %+v", m)
+	}
+
+	long := events.Event{Type: events.ClipboardUpdated, DataType: "text/plain", Data: strings.Repeat("é", 1000)}
+	if m := notify.NewMessage(long, true); len([]rune(m.Body)) != 257 {
+		t.Errorf("expected a preview of 256 characters; got %d", len([]rune(m.Body)))
+	}
+}
+
+func TestNtfyNotify(t *testing.T) {
+	var path, title, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		path, title, body = r.URL.Path, r.Header.Get("Title"), string(b)
+	}))
+	defer server.Close()
+
+	n := &notify.Ntfy{URL: server.URL, Client: server.Client()}
+	if err := n.Validate("bad topic"); err == nil {
+		t.Error("expected topic with a space to be rejected")
+	}
+	if err := n.Notify(context.Background(), "phone", notify.Message{Title: "Clipboard otp updated", Body: "123456"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if path != "/phone" || title != "Clipboard otp updated" || body != "123456" {
+		t.Errorf("unexpected request to %s with title %q and body %q", path, title, body)
+	}
+}
+
+func TestSMTPValidate(t *testing.T) {
+	s := &notify.SMTP{Addr: "localhost:25"}
+	for target, valid := range map[string]bool{
+		"me@example.com":       true,
+		"Me <me@example.com>":  false,
+		"me@example.com, x@y":  false,
+		"not an email address": false,
+	} {
+		if err := s.Validate(target); (err == nil) != valid {
+			t.Errorf("Validate(%q) = %v; expected valid %v", target, err, valid)
+		}
+	}
+}
+
+// pushBrowser is the browser end of a push subscription.
+type pushBrowser struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newPushBrowser(t *testing.T) *pushBrowser {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	_, _ = rand.Read(auth)
+	return &pushBrowser{key, auth}
+}
+
+// subscription returns the push subscription of the browser for endpoint.
+func (b *pushBrowser) subscription(endpoint string) string {
+	sub, _ := json.Marshal(map[string]any{
+		"endpoint": endpoint,
+		"keys": map[string]string{
+			"p256dh": base64.RawURLEncoding.EncodeToString(b.key.PublicKey().Bytes()),
+			"auth":   base64.RawURLEncoding.EncodeToString(b.auth),
+		},
+	})
+	return string(sub)
+}
+
+// decrypt decrypts a push message as in RFC 8291.
+func (b *pushBrowser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	salt, idLen := body[:16], int(body[20])
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != 4096 || idLen != 65 {
+		t.Fatalf("unexpected record size %d and key id length %d", rs, idLen)
+	}
+	asPublic, err := ecdh.P256().NewPublicKey(body[21 : 21+idLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := b.key.ECDH(asPublic)
+
+	read := func(r io.Reader, n int) []byte {
+		out := make([]byte, n)
+		_, _ = io.ReadFull(r, out)
+		return out
+	}
+	info := append(append([]byte("WebPush: info\x00"), b.key.PublicKey().Bytes()...), asPublic.Bytes()...)
+	ikm := read(hkdf.New(sha256.New, secret, b.auth, info), 32)
+	cek := read(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), 16)
+	nonce := read(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("cannot decrypt push message: %v", err)
+	}
+	if plain[len(plain)-1] != 2 {
+		t.Fatalf("expected the last record delimiter; got %x", plain[len(plain)-1])
+	}
+	return plain[:len(plain)-1]
+}
+
+func newWebPush(t *testing.T, client *http.Client) *notify.WebPush {
+	t.Helper()
+	k, _ := ecdh.P256().GenerateKey(rand.Reader)
+	key, err := notify.ParseVAPIDKey(base64.RawURLEncoding.EncodeToString(k.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &notify.WebPush{Key: key, Subject: "mailto:admin@example.com", TTL: time.Hour, Client: client}
+	if want := base64.RawURLEncoding.EncodeToString(k.PublicKey().Bytes()); p.PublicKey() != want {
+		t.Fatalf("expected the public key %q; got %q", want, p.PublicKey())
+	}
+	return p
+}
+
+func TestWebPushNotify(t *testing.T) {
+	browser := newPushBrowser(t)
+	var req *http.Request
+	var body []byte
+	status := http.StatusCreated
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	p := newWebPush(t, server.Client())
+	target := browser.subscription(server.URL + "/push/abc")
+	if err := p.Validate(target); err != nil {
+		t.Fatalf("expected the subscription to be valid: %v", err)
+	}
+	if err := p.Notify(context.Background(), target, notify.Message{Title: "Clipboard otp updated", Body: "123456", ClipboardId: 7}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if req.URL.Path != "/push/abc" || req.Header.Get("Content-Encoding") != "aes128gcm" || req.Header.Get("TTL") != "3600" || req.Header.Get("Topic") != "clipboard-7" {
+		t.Errorf("unexpected request to %s with headers %v", req.URL.Path, req.Header)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(browser.decrypt(t, body), &m); err != nil || m["title"] != "Clipboard otp updated" || m["body"] != "123456" || m["clipboard_id"] != 7.0 {
+		t.Errorf("unexpected message %v, %v", m, err)
+	}
+
+	// The VAPID token is signed for the origin of the push service.
+	var token, key string
+	for _, param := range strings.Split(strings.TrimPrefix(req.Header.Get("Authorization"), "vapid "), ", ") {
+		if v, ok := strings.CutPrefix(param, "t="); ok {
+			token = v
+		}
+		if v, ok := strings.CutPrefix(param, "k="); ok {
+			key = v
+		}
+	}
+	if key != p.PublicKey() {
+		t.Errorf("expected the public key %q; got %q", p.PublicKey(), key)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) { return &p.Key.PublicKey, nil }, jwt.WithValidMethods([]string{"ES256"})); err != nil {
+		t.Fatalf("invalid VAPID token: %v", err)
+	}
+	if claims["aud"] != server.URL || claims["sub"] != "mailto:admin@example.com" {
+		t.Errorf("unexpected VAPID claims %v", claims)
+	}
+
+	status = http.StatusGone
+	if err := p.Notify(context.Background(), target, notify.Message{Title: "x"}); !errors.Is(err, notify.ErrExpired) {
+		t.Errorf("expected an expired subscription; got %v", err)
+	}
+}
+
+func TestWebPushValidate(t *testing.T) {
+	p := newWebPush(t, nil)
+	valid := newPushBrowser(t).subscription("https://push.example.com/abc")
+	for _, target := range []string{
+		"push.example.com",
+		strings.Replace(valid, "https:", "http:", 1),
+		`{"endpoint": "https://push.example.com/abc", "keys": {"p256dh": "AAAA", "auth": "AAAAAAAAAAAAAAAAAAAAAA"}}`,
+		strings.Replace(valid, `"auth":"`, `"auth":"AAAA`, 1),
+	} {
+		if err := p.Validate(target); err == nil {
+			t.Errorf("expected %s to be rejected", target)
+		}
+	}
+
+	if _, err := notify.ParseVAPIDKey("not a key"); err == nil {
+		t.Error("expected an invalid VAPID key to be rejected")
+	}
+}
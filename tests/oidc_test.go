@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+// fakeProvider is an OpenID Connect provider logging in whoever claims is
+// set to.
+type fakeProvider struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// claims are the claims of the next login.
+	claims jwt.MapClaims
+	// logins holds the nonce and code challenge of each issued code.
+	logins map[string]url.Values
+}
+
+// oidcRedirectURL is the callback the server is configured with. Tests
+// forward the redirect to the test server themselves.
+const oidcRedirectURL = "http://copybridge.invalid/auth/oidc/callback"
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{t: t, key: key, logins: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "copybridge" || q.Get("redirect_uri") != oidcRedirectURL || q.Get("code_challenge_method") != "S256" {
+			t.Errorf("unexpected authorization request %v", q)
+		}
+		code := "code-" + q.Get("state")
+		p.mu.Lock()
+		p.logins[code] = q
+		p.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "copybridge" || secret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		p.mu.Lock()
+		login, ok := p.logins[r.FormValue("code")]
+		delete(p.logins, r.FormValue("code"))
+		claims := jwt.MapClaims{}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		p.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != login.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims["iss"] = p.URL
+		claims["aud"] = "copybridge"
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		claims["nonce"] = login.Get("nonce")
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// login logs in at the server through the provider as the given claims and
+// returns the response of the callback.
+func (p *fakeProvider) login(s *testutil.Server, claims jwt.MapClaims, returnTo string) *http.Response {
+	p.t.Helper()
+	p.mu.Lock()
+	p.claims = claims
+	p.mu.Unlock()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(u string) *http.Response {
+		resp, err := client.Get(u)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	path := "/auth/oidc/login"
+	if returnTo != "" {
+		path += "?return_to=" + url.QueryEscape(returnTo)
+	}
+	resp := get(s.URL + path)
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), p.URL+"/authorize?") {
+		p.t.Fatalf("expected a redirect to the provider; got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp = get(resp.Header.Get("Location"))
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	resp, err = client.Get(s.URL + callback.Path + "?" + callback.RawQuery)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAPIOIDC(t *testing.T) {
+	p := newFakeProvider(t)
+	s := testutil.NewServer(t, "OIDC_ISSUER="+p.URL, "OIDC_CLIENT_ID=copybridge", "OIDC_CLIENT_SECRET=client-secret",
+		"OIDC_REDIRECT_URL="+oidcRedirectURL, "OIDC_RETURN_URLS=http://127.0.0.1/done,app://login", "USER_ROLES=alice:admin", "ADMIN_TOKEN=admin-secret")
+
+	// Identities are matched to existing users by their username claim.
+	resp := p.login(s, jwt.MapClaims{"sub": "u-1", "preferred_username": "alice"}, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a session; got %d", resp.StatusCode)
+	}
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("expected tokens; got %+v, %v", tokens, err)
+	}
+	alice := testutil.WithAPIKey(tokens.AccessToken)
+	s.Do(t, "POST", "/clipboard", map[string]any{"name": "sso", "type": "text/plain", "data": "x"}, alice).Expect(t, http.StatusOK)
+	// Without multi-factor authentication at the provider, the admin API
+	// stays closed.
+	s.Do(t, "GET", "/admin/users", nil, alice).Expect(t, http.StatusForbidden)
+
+	// The subject stays linked to alice when its username changes, and
+	// nobody else can claim alice.
+	resp = p.login(s, jwt.MapClaims{"sub": "u-1", "preferred_username": "bob", "amr": []string{"pwd", "otp"}}, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a session; got %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	s.Do(t, "GET", "/admin/users", nil, testutil.WithAPIKey(tokens.AccessToken)).Expect(t, http.StatusOK)
+	if resp := p.login(s, jwt.MapClaims{"sub": "u-2", "preferred_username": "alice"}, ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected alice to be linked already; got %d", resp.StatusCode)
+	}
+
+	// Administrators unlink users who got a new account at the provider.
+	admin := testutil.WithHeader("X-Admin-Token", "admin-secret")
+	s.Do(t, "DELETE", "/admin/users/alice/identities", nil, admin).Expect(t, http.StatusNoContent)
+	s.Do(t, "DELETE", "/admin/users/alice/identities", nil, admin).Expect(t, http.StatusNotFound)
+	if resp := p.login(s, jwt.MapClaims{"sub": "u-2", "preferred_username": "alice"}, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a session after unlinking; got %d", resp.StatusCode)
+	}
+
+	// Unknown users are not created by default.
+	if resp := p.login(s, jwt.MapClaims{"sub": "u-3", "preferred_username": "mallory"}, ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected unknown users to be refused; got %d", resp.StatusCode)
+	}
+
+	// Native apps get the tokens handed to their loopback redirect on any
+	// port.
+	resp = p.login(s, jwt.MapClaims{"sub": "u-4", "preferred_username": "bob"}, "http://127.0.0.1:4711/done")
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil || location.Host != "127.0.0.1:4711" {
+		t.Fatalf("expected a redirect to the app; got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	fragment, _ := url.ParseQuery(location.Fragment)
+	s.Do(t, "GET", "/quota", nil, testutil.WithAPIKey(fragment.Get("access_token"))).Expect(t, http.StatusOK)
+
+	s.Do(t, "GET", "/auth/oidc/login?return_to="+url.QueryEscape("https://evil.example/done"), nil).Expect(t, http.StatusBadRequest)
+	s.Do(t, "GET", "/auth/oidc/callback?code=x&state=forged", nil).Expect(t, http.StatusBadRequest)
+}
+
+func TestAPIOIDCCreateUsers(t *testing.T) {
+	p := newFakeProvider(t)
+	s := testutil.NewServer(t, "OIDC_ISSUER="+p.URL, "OIDC_CLIENT_ID=copybridge", "OIDC_CLIENT_SECRET=client-secret",
+		"OIDC_REDIRECT_URL="+oidcRedirectURL, "OIDC_USERNAME_CLAIM=email", "OIDC_CREATE_USERS=true")
+
+	if resp := p.login(s, jwt.MapClaims{"sub": "u-1", "email": "carol@example.com"}, ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected unverified email addresses to be refused; got %d", resp.StatusCode)
+	}
+	if resp := p.login(s, jwt.MapClaims{"sub": "u-1", "email": "carol@example.com", "email_verified": true}, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected carol to be created; got %d", resp.StatusCode)
+	}
+	u, err := s.DB.UserByName(context.Background(), "default", "carol@example.com")
+	if err != nil || u == nil {
+		t.Fatalf("expected carol to exist; got %v, %v", u, err)
+	}
+}
+
+func TestAPIOIDCDisabled(t *testing.T) {
+	s := testutil.NewServer(t)
+	s.Do(t, "GET", "/auth/oidc/login", nil).Expect(t, http.StatusNotFound)
+	s.Do(t, "GET", "/auth/oidc/callback", nil).Expect(t, http.StatusNotFound)
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/preview"
+)
+
+func TestPreviewExtract(t *testing.T) {
+	png := encodePNG(t, 40, 30)
+
+	tests := []struct {
+		name     string
+		dataType string
+		data     string
+		want     *clipboard.Metadata
+	}{
+		{"empty", "text/plain", "", nil},
+		{"text", "text/plain", "hello\nworld\n", &clipboard.Metadata{Lines: 2}},
+		{"no trailing newline", "text/plain", "a\nb\nc", &clipboard.Metadata{Lines: 3}},
+		{"json by type", "application/json", `{"a": 1}`, &clipboard.Metadata{Lines: 1, Language: "json"}},
+		{"json by content", "text/plain", "[1, 2]", &clipboard.Metadata{Lines: 1, Language: "json"}},
+		{"go", "text/plain", "package main\n\nfunc main() {}\n", &clipboard.Metadata{Lines: 3, Language: "go"}},
+		{"python", "text/plain", "def add(a, b):\n    return a + b", &clipboard.Metadata{Lines: 2, Language: "python"}},
+		{"shebang", "text/plain; charset=utf-8", "#!/bin/sh\necho hi", &clipboard.Metadata{Lines: 2, Language: "shell"}},
+		{"sql", "text/plain", "SELECT id FROM users;", &clipboard.Metadata{Lines: 1, Language: "sql"}},
+		{"prose", "text/plain", "Remember to buy milk", &clipboard.Metadata{Lines: 1}},
+		{"png", "image/png", string(png), &clipboard.Metadata{Width: 40, Height: 30}},
+		{"base64 png", "image/png", base64.StdEncoding.EncodeToString(png), &clipboard.Metadata{Width: 40, Height: 30}},
+		{"broken image", "image/png", "not an image", nil},
+		{"binary", "application/octet-stream", "\xff\xfe\x00", nil},
+		{"template", "text/x-template", "Hi {{name}}, see you on {{date}} at {{place|the office}}", &clipboard.Metadata{Lines: 1, Variables: []string{"name", "place"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preview.Extract(tt.dataType, tt.data)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extract() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewLink(t *testing.T) {
+	tests := []struct {
+		dataType string
+		data     string
+		want     string
+	}{
+		{"text/plain", " https://example.com/page?q=1\n", "https://example.com/page?q=1"},
+		{"text/uri-list", "# comment\nhttp://example.com/a\nhttp://example.com/b\n", "http://example.com/a"},
+		{"text/plain", "see https://example.com", ""},
+		{"text/plain", "ftp://example.com/file", ""},
+		{"text/plain", "https://", ""},
+		{"text/html", "https://example.com", ""},
+	}
+	for _, tt := range tests {
+		got, ok := preview.Link(tt.dataType, tt.data)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("Link(%q, %q) = %q, %v; want %q", tt.dataType, tt.data, got, ok, tt.want)
+		}
+	}
+}
+
+func TestPreviewTitle(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html><head><TITLE>\n  Fish &amp; Chips\n</TITLE></head></html>"))
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("<title>not html</title>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer page.Close()
+
+	f := preview.NewFetcher(preview.Config{Timeout: 5 * time.Second, AllowPrivate: true}, nil)
+	title, err := f.Title(context.Background(), page.URL+"/page")
+	if err != nil || title != "Fish & Chips" {
+		t.Errorf("Title() = %q, %v; want the unescaped title", title, err)
+	}
+	if title, err := f.Title(context.Background(), page.URL+"/text"); err != nil || title != "" {
+		t.Errorf("Title() = %q, %v; want no title of non-HTML pages", title, err)
+	}
+	if _, err := f.Title(context.Background(), page.URL+"/missing"); err == nil {
+		t.Error("expected an error for a missing page")
+	}
+
+	f = preview.NewFetcher(preview.Config{Timeout: 5 * time.Second}, nil)
+	if _, err := f.Title(context.Background(), page.URL+"/page"); !errors.Is(err, preview.ErrPrivateAddress) {
+		t.Errorf("expected loopback addresses to be refused; got %v", err)
+	}
+}
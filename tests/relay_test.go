@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/copybridge/copybridge-server/internal/relay"
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+// relaySend sends data to a device through the relay in the background, as
+// the request only completes once the device received it.
+func relaySend(s *testutil.Server, device, data string) <-chan *http.Response {
+	done := make(chan *http.Response, 1)
+	go func() {
+		req, _ := http.NewRequest("POST", s.URL+"/relay/devices/"+device+"?from=phone", strings.NewReader(data))
+		req.Header.Set("X-API-Key", testutil.AliceKey)
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := s.Client().Do(req)
+		if err != nil {
+			close(done)
+			return
+		}
+		resp.Body.Close()
+		done <- resp
+	}()
+	return done
+}
+
+func TestAPIRelay(t *testing.T) {
+	s := testutil.NewServer(t, "RELAY_ACCEPT_TIMEOUT=300ms")
+	alice := testutil.WithAPIKey(testutil.AliceKey)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/relay?device=laptop", http.Header{"X-API-Key": {testutil.AliceKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	offer := func() relay.Offer {
+		t.Helper()
+		var msg struct {
+			Op string `json:"op"`
+			relay.Offer
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil || msg.Op != "offer" {
+			t.Fatalf("expected an offer; got %+v, %v", msg, err)
+		}
+		return msg.Offer
+	}
+
+	var devices []relay.Device
+	s.Do(t, "GET", "/relay/devices", nil, alice).Expect(t, http.StatusOK).JSON(t, &devices)
+	if len(devices) != 1 || devices[0].Name != "laptop" {
+		t.Fatalf("expected the laptop to be connected; got %+v", devices)
+	}
+	s.Do(t, "GET", "/relay?device=laptop", nil, alice).Expect(t, http.StatusConflict)
+	s.Do(t, "GET", "/relay?device=a/b", nil, alice).Expect(t, http.StatusBadRequest)
+	s.Do(t, "POST", "/relay/devices/laptop", "hi").Expect(t, http.StatusUnauthorized)
+	s.Do(t, "POST", "/relay/devices/laptop", "hi", testutil.WithAPIKey(testutil.BobKey)).Expect(t, http.StatusNotFound)
+
+	// The data is passed on once the device fetches it.
+	sent := relaySend(s, "laptop", "hello")
+	o := offer()
+	if o.From != "phone" || o.DataType != "text/plain" || o.Size != 5 {
+		t.Fatalf("unexpected offer %+v", o)
+	}
+	s.Do(t, "GET", "/relay/transfers/"+o.Id, nil, testutil.WithAPIKey(testutil.BobKey)).Expect(t, http.StatusNotFound)
+	resp := s.Do(t, "GET", "/relay/transfers/"+o.Id, nil, alice).Expect(t, http.StatusOK)
+	if string(resp.Body) != "hello" || resp.Header.Get("X-Relay-From") != "phone" {
+		t.Fatalf("expected the data; got %q", resp.Body)
+	}
+	if resp := <-sent; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the transfer to succeed; got %v", resp)
+	}
+	s.Do(t, "GET", "/relay/transfers/"+o.Id, nil, alice).Expect(t, http.StatusNotFound)
+
+	// Devices can decline transfers, and ignoring them times out.
+	sent = relaySend(s, "laptop", "spam")
+	s.Do(t, "DELETE", "/relay/transfers/"+offer().Id, nil, alice).Expect(t, http.StatusNoContent)
+	if resp := <-sent; resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected the transfer to be declined; got %v", resp)
+	}
+	sent = relaySend(s, "laptop", "late")
+	o = offer()
+	if resp := <-sent; resp == nil || resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected the transfer to time out; got %v", resp)
+	}
+	s.Do(t, "GET", "/relay/transfers/"+o.Id, nil, alice).Expect(t, http.StatusNotFound)
+
+	// Nothing was stored.
+	var list []json.RawMessage
+	s.Do(t, "GET", "/clipboard", nil, alice).Expect(t, http.StatusOK).JSON(t, &list)
+	if len(list) != 0 {
+		t.Errorf("expected no clipboards; got %d", len(list))
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); len(devices) > 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.Do(t, "GET", "/relay/devices", nil, alice).Expect(t, http.StatusOK).JSON(t, &devices)
+	}
+	if len(devices) != 0 {
+		t.Fatalf("expected the laptop to be disconnected; got %+v", devices)
+	}
+	s.Do(t, "POST", "/relay/devices/laptop", "hi", alice).Expect(t, http.StatusNotFound)
+}
+
+func TestAPIRelayDisabled(t *testing.T) {
+	s := testutil.NewServer(t, "RELAY_ENABLED=false")
+	s.Do(t, "GET", "/relay/devices", nil, testutil.WithAPIKey(testutil.AliceKey)).Expect(t, http.StatusNotFound)
+}
+
+func TestRelayHubDisconnect(t *testing.T) {
+	hub := relay.NewHub()
+	d, err := hub.Connect(1, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Connect(1, "laptop"); err != relay.ErrDeviceConnected {
+		t.Fatalf("expected the name to be taken; got %v", err)
+	}
+	if _, err := hub.Connect(2, "laptop"); err != nil {
+		t.Fatalf("expected names to be per user; got %v", err)
+	}
+
+	tr, err := hub.Send(1, "laptop", relay.Offer{DataType: "text/plain", Size: -1}, strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.Disconnect(d)
+	<-tr.Done()
+	if _, err := tr.Result(); err != relay.ErrDeviceNotFound {
+		t.Errorf("expected pending transfers to fail with the device; got %v", err)
+	}
+	if _, err := hub.Send(1, "laptop", relay.Offer{}, strings.NewReader("")); err != relay.ErrDeviceNotFound {
+		t.Errorf("expected the device to be gone; got %v", err)
+	}
+}
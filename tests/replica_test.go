@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/server"
+	"github.com/copybridge/copybridge-server/internal/testutil"
+)
+
+// newReplica starts a replica forwarding writes to primaryURL. It shares
+// the database of primary, as if it were replicated without delay.
+func newReplica(t *testing.T, primary *testutil.Server, primaryURL string) *testutil.Server {
+	t.Helper()
+	t.Setenv("PRIMARY_URL", primaryURL)
+	s, err := server.New(primary.DB)
+	if err != nil {
+		t.Fatalf("cannot configure replica: %v", err)
+	}
+	replica := &testutil.Server{Server: httptest.NewServer(s.RegisterRoutes()), DB: primary.DB}
+	t.Cleanup(replica.Close)
+	return replica
+}
+
+func TestReplica(t *testing.T) {
+	primary := testutil.NewServer(t)
+	alice := testutil.WithAPIKey(testutil.AliceKey)
+
+	// The primary is reached through a proxy recording what was forwarded.
+	target, _ := url.Parse(primary.URL)
+	var mu sync.Mutex
+	var forwarded []string
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		forwarded = append(forwarded, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	defer recorder.Close()
+	replica := newReplica(t, primary, recorder.URL)
+
+	var created clipboard.Clipboard
+	replica.Do(t, "POST", "/clipboard", map[string]any{"name": "notes", "type": "text/plain", "data": "hello"}, alice).Expect(t, http.StatusOK).JSON(t, &created)
+	path := fmt.Sprintf("/clipboard/%d", created.Id)
+
+	var got clipboard.Clipboard
+	replica.Do(t, "GET", path, nil, alice).Expect(t, http.StatusOK).JSON(t, &got)
+	if got.Data != "hello" {
+		t.Errorf("expected the replica to serve the clipboard; got %+v", got)
+	}
+	replica.Do(t, "PUT", path, map[string]any{"name": "notes", "type": "text/plain", "data": "stale"}, alice, testutil.WithHeader("If-Match", `"7"`)).Expect(t, http.StatusConflict)
+	replica.Do(t, "DELETE", path, nil, alice).Expect(t, http.StatusNoContent)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"POST /clipboard", "PUT " + path, "DELETE " + path}
+	if strings.Join(forwarded, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected only writes to be forwarded; got %q", forwarded)
+	}
+}
+
+func TestReplicaPrimaryUnreachable(t *testing.T) {
+	primary := testutil.NewServer(t)
+	alice := testutil.WithAPIKey(testutil.AliceKey)
+
+	var created clipboard.Clipboard
+	primary.Do(t, "POST", "/clipboard", map[string]any{"name": "notes", "type": "text/plain", "data": "hello"}, alice).Expect(t, http.StatusOK).JSON(t, &created)
+
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	replica := newReplica(t, primary, gone.URL)
+
+	// Reads keep working, writes fail until the primary is back.
+	replica.Do(t, "GET", fmt.Sprintf("/clipboard/%d", created.Id), nil, alice).Expect(t, http.StatusOK)
+	replica.Do(t, "POST", "/clipboard", map[string]any{"name": "new", "type": "text/plain", "data": "x"}, alice).Expect(t, http.StatusBadGateway)
+}
+
+func TestReplicaInvalidPrimary(t *testing.T) {
+	primary := testutil.NewServer(t)
+	t.Setenv("PRIMARY_URL", "ftp://primary.example")
+	if _, err := server.New(primary.DB); err == nil || !strings.Contains(err.Error(), "PRIMARY_URL") {
+		t.Fatalf("expected an invalid PRIMARY_URL to be rejected; got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/copybridge/copybridge-server/internal/retention"
+)
+
+type fakeStore struct {
+	stale   map[bool][]int
+	byRead  []int
+	deleted []int
+}
+
+func (f *fakeStore) StaleClipboards(_ context.Context, encrypted bool, before time.Time) ([]int, error) {
+	return f.stale[encrypted], nil
+}
+
+func (f *fakeStore) CountClipboards(context.Context) (int, error) {
+	return len(f.byRead) - len(f.deleted), nil
+}
+
+func (f *fakeStore) LeastRecentlyRead(_ context.Context, limit int) ([]int, error) {
+	var ids []int
+	for _, id := range f.byRead {
+		if !contains(f.deleted, id) && len(ids) < limit {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (f *fakeStore) Delete(_ context.Context, id int) error {
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakeStore) DeleteExpiredUploads(_ context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRetentionApply(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		store := &fakeStore{
+			stale:  map[bool][]int{false: {3}, true: {5}},
+			byRead: []int{1, 2, 3, 4, 5, 6},
+		}
+		p := retention.Policy{UnencryptedMaxAge: time.Hour, MaxClipboards: 3, DryRun: dryRun}
+
+		ids, err := p.Apply(context.Background(), store, time.Now())
+		if err != nil {
+			t.Fatalf("error applying retention. Err: %v", err)
+		}
+		if expected := []int{3, 1, 2}; !reflect.DeepEqual(ids, expected) {
+			t.Errorf("dry run %v: expected %v to be deleted; got %v", dryRun, expected, ids)
+		}
+		if dryRun && len(store.deleted) != 0 {
+			t.Errorf("expected dry run not to delete anything; deleted %v", store.deleted)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/stream"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 32))
+	aead, _ := cipher.NewGCM(block)
+	prefix, err := stream.NewPrefix()
+	if err != nil {
+		t.Fatalf("error generating prefix: %v", err)
+	}
+
+	for _, size := range []int{0, 1, stream.SegmentSize, stream.SegmentSize + 1, 3*stream.SegmentSize - 7} {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+
+		ciphertext, err := io.ReadAll(stream.EncryptReader(aead, prefix, bytes.NewReader(plaintext)))
+		if err != nil {
+			t.Fatalf("size %d: error encrypting: %v", size, err)
+		}
+		decrypted, err := io.ReadAll(stream.DecryptReader(aead, prefix, bytes.NewReader(ciphertext)))
+		if err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: expected round trip; got %d bytes, %v", size, len(decrypted), err)
+		}
+
+		// Dropping the last segment must not go unnoticed.
+		if size > stream.SegmentSize {
+			truncated := ciphertext[:stream.SegmentSize+aead.Overhead()]
+			if _, err := io.ReadAll(stream.DecryptReader(aead, prefix, bytes.NewReader(truncated))); err == nil {
+				t.Errorf("size %d: expected truncated stream to fail", size)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/thumbnail"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("error encoding PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnailSize(t *testing.T) {
+	src := encodePNG(t, 1000, 500)
+	cases := map[string][]byte{
+		"raw":    src,
+		"base64": []byte(base64.StdEncoding.EncodeToString(src)),
+	}
+	for name, data := range cases {
+		thumb, err := thumbnail.Generate(data, 256, 1<<20)
+		if err != nil {
+			t.Fatalf("%s: error generating thumbnail: %v", name, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatalf("%s: thumbnail is not a JPEG: %v", name, err)
+		}
+		if cfg.Width != 256 || cfg.Height != 128 {
+			t.Errorf("%s: expected 256x128; got %dx%d", name, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestThumbnailSmallImageNotScaledUp(t *testing.T) {
+	thumb, err := thumbnail.Generate(encodePNG(t, 40, 60), 256, 1<<20)
+	if err != nil {
+		t.Fatalf("error generating thumbnail: %v", err)
+	}
+	cfg, _ := jpeg.DecodeConfig(bytes.NewReader(thumb))
+	if cfg.Width != 40 || cfg.Height != 60 {
+		t.Errorf("expected 40x60; got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestThumbnailErrors(t *testing.T) {
+	if _, err := thumbnail.Generate([]byte("not an image"), 256, 1<<20); err != thumbnail.ErrUnsupported {
+		t.Errorf("expected ErrUnsupported; got %v", err)
+	}
+	if _, err := thumbnail.Generate(encodePNG(t, 100, 100), 256, 5000); err != thumbnail.ErrTooLarge {
+		t.Errorf("expected ErrTooLarge; got %v", err)
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	for dataType, want := range map[string]bool{"image/png": true, "IMAGE/JPEG; q=1": true, "text/plain": false, "": false} {
+		if got := thumbnail.IsImage(dataType); got != want {
+			t.Errorf("%q: expected %v; got %v", dataType, want, got)
+		}
+	}
+}
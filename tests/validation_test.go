@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/copybridge/copybridge-server/internal/clipboard"
+	"github.com/copybridge/copybridge-server/internal/validation"
+)
+
+func TestValidateClipboard(t *testing.T) {
+	valid := &clipboard.Clipboard{Name: "notes", DataType: "text/plain", Data: "hello", Tags: []string{"work"}}
+	if errs := validation.Clipboard(valid, validation.ClipboardRules{RequireName: true, MaxSize: 10}); len(errs) != 0 {
+		t.Errorf("expected valid clipboard; got %v", errs)
+	}
+
+	cases := []struct {
+		c      clipboard.Clipboard
+		rules  validation.ClipboardRules
+		field  string
+		status int
+	}{
+		{clipboard.Clipboard{DataType: "text/plain"}, validation.ClipboardRules{RequireName: true}, "name", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: "a\nb", DataType: "text/plain"}, validation.ClipboardRules{}, "name", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: strings.Repeat("a", 256), DataType: "text/plain"}, validation.ClipboardRules{}, "name", http.StatusRequestEntityTooLarge},
+		{clipboard.Clipboard{Name: "a"}, validation.ClipboardRules{}, "type", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: "a", DataType: "plain"}, validation.ClipboardRules{}, "type", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: "a", DataType: "text/plain", Data: "hello"}, validation.ClipboardRules{MaxSize: 4}, "data", http.StatusRequestEntityTooLarge},
+		{clipboard.Clipboard{Name: "a", DataType: "text/plain", Tags: []string{"Not a tag"}}, validation.ClipboardRules{}, "tags", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: "a", DataType: "text/plain", Filename: "../notes.txt"}, validation.ClipboardRules{}, "filename", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: "a", DataType: "text/plain", ContentDisposition: "download"}, validation.ClipboardRules{}, "content_disposition", http.StatusUnprocessableEntity},
+		{clipboard.Clipboard{Name: "a", DataType: "text/plain", Flavors: []clipboard.Flavor{{DataType: "html"}}}, validation.ClipboardRules{}, "flavors[0].type", http.StatusUnprocessableEntity},
+	}
+	for i, tc := range cases {
+		errs := validation.Clipboard(&tc.c, tc.rules)
+		if len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("case %d: expected an error of %s; got %v", i, tc.field, errs)
+			continue
+		}
+		if errs.Status() != tc.status {
+			t.Errorf("case %d: expected status %d; got %d", i, tc.status, errs.Status())
+		}
+	}
+}
+
+func TestValidationErrorBody(t *testing.T) {
+	rec := httptest.NewRecorder()
+	validation.Error(rec, "clipboard not found", http.StatusNotFound)
+
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected 404 JSON response; got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var resp validation.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error decoding body: %v", err)
+	}
+	if resp.Code != "not_found" || resp.Message != "clipboard not found" {
+		t.Errorf("expected not_found error; got %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	var errs validation.Errors
+	errs.Add("name", validation.CodeRequired, "name is required")
+	validation.WriteErrors(rec, errs)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error decoding body: %v", err)
+	}
+	if rec.Code != http.StatusUnprocessableEntity || resp.Code != "validation_failed" || len(resp.Fields) != 1 {
+		t.Errorf("expected validation_failed with one field error; got %d %+v", rec.Code, resp)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	allowed := []string{"name", "type", "data", "flavors"}
+	var c clipboard.Clipboard
+
+	err := validation.Decode(strings.NewReader(`{"name":"a","data_type":"text/plain"}`), &c, allowed)
+	errs, ok := err.(validation.Errors)
+	if !ok || len(errs) != 1 || errs[0].Field != "data_type" || errs[0].Code != validation.CodeUnknown || errs[0].Message != "unknown field, did you mean type?" {
+		t.Errorf("expected data_type to be unknown; got %v", err)
+	}
+	err = validation.Decode(strings.NewReader(`{"Name":"a","dta":"x","colour":1}`), &c, allowed)
+	if errs, ok := err.(validation.Errors); !ok || len(errs) != 3 || errs[0].Message != "unknown field, did you mean name?" ||
+		errs[1].Message != "unknown field" || errs[2].Message != "unknown field, did you mean data?" {
+		t.Errorf("expected three unknown fields; got %v", err)
+	}
+	err = validation.Decode(strings.NewReader(`{"data":1}`), &c, allowed)
+	if errs, ok := err.(validation.Errors); !ok || len(errs) != 1 || errs[0].Field != "data" || errs[0].Message != "must be a string" {
+		t.Errorf("expected data to be invalid; got %v", err)
+	}
+	err = validation.Decode(strings.NewReader(`{"flavors":[{"type":"text/html","colour":1}]}`), &c, allowed)
+	if errs, ok := err.(validation.Errors); !ok || len(errs) != 1 || errs[0].Field != "colour" {
+		t.Errorf("expected a nested unknown field; got %v", err)
+	}
+
+	if err := validation.Decode(strings.NewReader(`{"name":"a"} {}`), &c, allowed); err == nil {
+		t.Error("expected trailing data to fail")
+	}
+	if err := validation.Decode(strings.NewReader(""), &c, allowed); err != io.EOF {
+		t.Errorf("expected io.EOF for an empty body; got %v", err)
+	}
+	if err := validation.Decode(strings.NewReader(`{"name":"b","extra":1}`), &c, nil); err != nil || c.Name != "b" {
+		t.Errorf("expected unknown fields to be ignored without a whitelist; got %v", err)
+	}
+}